@@ -0,0 +1,3 @@
+module github.com/aybabtme/humanize
+
+go 1.13
@@ -0,0 +1,3 @@
+module github.com/codegangsta/cli
+
+go 1.13
@@ -0,0 +1,3 @@
+module github.com/dustin/randbo
+
+go 1.13
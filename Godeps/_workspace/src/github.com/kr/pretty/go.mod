@@ -0,0 +1,7 @@
+module github.com/kr/pretty
+
+go 1.13
+
+require github.com/kr/text v0.0.0-00010101000000-000000000000
+
+replace github.com/kr/text => ../text
@@ -0,0 +1,3 @@
+module github.com/kr/text
+
+go 1.13
@@ -189,6 +189,13 @@ type Auth struct {
 	expiration           time.Time
 }
 
+// CredentialsProvider gives the credentials requests are signed with, each
+// time they're signed, so that they can change while in use, such as
+// temporary credentials renewed before they expire.
+type CredentialsProvider interface {
+	Credentials() (Auth, error)
+}
+
 func (a *Auth) Token() string {
 	if a.token == "" {
 		return ""
@@ -199,6 +206,12 @@ func (a *Auth) Token() string {
 	return a.token
 }
 
+// SessionToken is the token of temporary credentials, which unlike Token
+// doesn't replace the credentials once they expire.
+func (a *Auth) SessionToken() string {
+	return a.token
+}
+
 func (a *Auth) Expiration() time.Time {
 	return a.expiration
 }
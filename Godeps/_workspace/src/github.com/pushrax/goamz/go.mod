@@ -0,0 +1,3 @@
+module github.com/pushrax/goamz
+
+go 1.13
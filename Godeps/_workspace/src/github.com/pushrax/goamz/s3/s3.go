@@ -40,7 +40,29 @@ type S3 struct {
 	ConnectTimeout      time.Duration
 	ReadTimeout         time.Duration
 	MaxIdleConnsPerHost int
-	private             byte // Reserve the right of using private data.
+	// Signature is the version of the scheme used to sign requests, either
+	// aws.V2Signature or aws.V4Signature. Regions opened after 2014 only
+	// accept aws.V4Signature.
+	Signature int
+	// RequesterPays acknowledges that the requests are billed to the
+	// requester, which requester pays buckets demand.
+	RequesterPays bool
+	// CustomerKey is the 256-bit key of the objects encrypted with
+	// customer-provided keys (SSE-C), sent with each request to read or
+	// write an object. S3 doesn't keep the key, objects written with it
+	// can't be read without it.
+	CustomerKey []byte
+	// Credentials, when set, gives the credentials each request is signed
+	// with, instead of Auth, each time it's signed, retries included.
+	Credentials aws.CredentialsProvider
+	// OnResponse, when set, is called with each response from S3, errors
+	// included, before its body is read. It can't close the body.
+	OnResponse func(*http.Response)
+	// Transport, when set, makes the requests and keeps their connections
+	// alive for the next ones. Otherwise each request has a connection of
+	// its own, dialed with ConnectTimeout and ReadTimeout.
+	Transport http.RoundTripper
+	private    byte // Reserve the right of using private data.
 }
 
 // The Bucket type encapsulates operations with an S3 bucket.
@@ -64,16 +86,42 @@ type Options struct {
 	CacheControl     string
 	RedirectLocation string
 	ContentMD5       string
-	// What else?
-	// Content-Disposition string
-	//// The following become headers so they are []strings rather than strings... I think
-	// x-amz-storage-class []string
+	// ContentDisposition of the object, such as attachment; filename="a".
+	ContentDisposition string
+	// Object Lock settings of the object, in buckets with Object Lock
+	// enabled. ObjectLockMode is either GOVERNANCE or COMPLIANCE, and
+	// requires ObjectLockRetainUntil.
+	ObjectLockMode        string
+	ObjectLockRetainUntil time.Time
+	ObjectLockLegalHold   bool
+	// Preconditions on the object being replaced: IfMatch is the ETag it
+	// must have, IfNoneMatch "*" requires that there's none.
+	IfMatch     string
+	IfNoneMatch string
+	// StorageClass of the object, such as STANDARD_IA, STANDARD when empty.
+	StorageClass string
+	// Tagging is the tag set of the object, URL-encoded as in a query
+	// string, such as "team=storage&env=prod".
+	Tagging string
 }
 
 type CopyOptions struct {
 	Options
 	MetadataDirective string
-	ContentType       string
+	// TaggingDirective is REPLACE to replace the tags of the copy by the
+	// Tagging of its options, rather than copy the ones of the source.
+	TaggingDirective string
+	ContentType      string
+	// RequesterPays is needed to copy from a requester pays bucket.
+	RequesterPays bool
+	// CopySourceCustomerKey is the SSE-C key of the source object, its
+	// CustomerKey, needed to copy it. The key of the copy is the one of
+	// the destination S3.
+	CopySourceCustomerKey []byte
+	// Preconditions on the source object, the copy fails with
+	// PreconditionFailed if they don't hold.
+	CopySourceIfMatch           string
+	CopySourceIfUnmodifiedSince time.Time
 }
 
 // CopyObjectResult is the output from a Copy request
@@ -356,6 +404,9 @@ func (o Options) addHeaders(headers map[string][]string) {
 	if len(o.CacheControl) != 0 {
 		headers["Cache-Control"] = []string{o.CacheControl}
 	}
+	if len(o.ContentDisposition) != 0 {
+		headers["Content-Disposition"] = []string{o.ContentDisposition}
+	}
 	if len(o.ContentMD5) != 0 {
 		headers["Content-MD5"] = []string{o.ContentMD5}
 	}
@@ -365,6 +416,25 @@ func (o Options) addHeaders(headers map[string][]string) {
 	for k, v := range o.Meta {
 		headers["x-amz-meta-"+k] = v
 	}
+	if len(o.ObjectLockMode) != 0 {
+		headers["x-amz-object-lock-mode"] = []string{o.ObjectLockMode}
+		headers["x-amz-object-lock-retain-until-date"] = []string{o.ObjectLockRetainUntil.UTC().Format(time.RFC3339)}
+	}
+	if o.ObjectLockLegalHold {
+		headers["x-amz-object-lock-legal-hold"] = []string{"ON"}
+	}
+	if len(o.IfMatch) != 0 {
+		headers["If-Match"] = []string{o.IfMatch}
+	}
+	if len(o.IfNoneMatch) != 0 {
+		headers["If-None-Match"] = []string{o.IfNoneMatch}
+	}
+	if len(o.StorageClass) != 0 {
+		headers["x-amz-storage-class"] = []string{o.StorageClass}
+	}
+	if len(o.Tagging) != 0 {
+		headers["x-amz-tagging"] = []string{o.Tagging}
+	}
 }
 
 // addHeaders adds o's specified fields to headers
@@ -373,9 +443,24 @@ func (o CopyOptions) addHeaders(headers map[string][]string) {
 	if len(o.MetadataDirective) != 0 {
 		headers["x-amz-metadata-directive"] = []string{o.MetadataDirective}
 	}
+	if len(o.TaggingDirective) != 0 {
+		headers["x-amz-tagging-directive"] = []string{o.TaggingDirective}
+	}
 	if len(o.ContentType) != 0 {
 		headers["Content-Type"] = []string{o.ContentType}
 	}
+	if o.RequesterPays {
+		headers["x-amz-request-payer"] = []string{"requester"}
+	}
+	if len(o.CopySourceCustomerKey) != 0 {
+		addCustomerKey(headers, "x-amz-copy-source-server-side-encryption-customer-", o.CopySourceCustomerKey)
+	}
+	if len(o.CopySourceIfMatch) != 0 {
+		headers["x-amz-copy-source-if-match"] = []string{o.CopySourceIfMatch}
+	}
+	if !o.CopySourceIfUnmodifiedSince.IsZero() {
+		headers["x-amz-copy-source-if-unmodified-since"] = []string{o.CopySourceIfUnmodifiedSince.UTC().Format(http.TimeFormat)}
+	}
 }
 
 func makeXmlBuffer(doc []byte) *bytes.Buffer {
@@ -426,6 +511,95 @@ func (b *Bucket) PutBucketSubresource(subresource string, r io.Reader, length in
 	return b.S3.query(req, nil)
 }
 
+// GetBucketSubresource returns the document of a subresource of the bucket,
+// such as its "cors" or "lifecycle" configuration, as S3 returns it: XML,
+// or JSON for the "policy".
+func (b *Bucket) GetBucketSubresource(subresource string) ([]byte, error) {
+	req := &request{
+		path:   "/",
+		bucket: b.Name,
+		params: url.Values{subresource: {""}},
+	}
+	err := b.S3.prepare(req)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := attempts.Start(); attempt.Next(); {
+		resp, err := b.S3.run(req, nil)
+		if shouldRetry(err) && attempt.HasNext() {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return ioutil.ReadAll(resp.Body)
+	}
+	panic("unreachable")
+}
+
+// Location returns the name of the region of the bucket, from its location
+// constraint, which is empty for us-east-1 and "EU" for the buckets created
+// in eu-west-1 with its legacy name.
+func (b *Bucket) Location() (string, error) {
+	doc, err := b.GetBucketSubresource("location")
+	if err != nil {
+		return "", err
+	}
+	var loc struct {
+		Constraint string `xml:",chardata"`
+	}
+	if err := xml.Unmarshal(doc, &loc); err != nil {
+		return "", err
+	}
+	switch loc.Constraint {
+	case "":
+		return "us-east-1", nil
+	case "EU":
+		return "eu-west-1", nil
+	}
+	return loc.Constraint, nil
+}
+
+// PutBucketConfig replaces a subresource of the bucket by doc, with its
+// Content-MD5, which S3 demands for the "cors", "lifecycle" and "tagging"
+// subresources.
+func (b *Bucket) PutBucketConfig(subresource string, doc []byte) error {
+	sum := md5.Sum(doc)
+	headers := map[string][]string{
+		"Content-Length": {strconv.Itoa(len(doc))},
+		"Content-MD5":    {base64.StdEncoding.EncodeToString(sum[:])},
+	}
+	req := &request{
+		path:    "/",
+		method:  "PUT",
+		bucket:  b.Name,
+		headers: headers,
+		payload: bytes.NewReader(doc),
+		params:  url.Values{subresource: {""}},
+	}
+	return b.S3.query(req, nil)
+}
+
+// DelBucketSubresource removes a subresource of the bucket, such as its
+// "website" configuration.
+func (b *Bucket) DelBucketSubresource(subresource string) error {
+	req := &request{
+		path:   "/",
+		method: "DELETE",
+		bucket: b.Name,
+		params: url.Values{subresource: {""}},
+	}
+	var err error
+	for attempt := attempts.Start(); attempt.Next(); {
+		err = b.S3.query(req, nil)
+		if !shouldRetry(err) {
+			break
+		}
+	}
+	return err
+}
+
 // Del removes an object from the S3 bucket.
 //
 // See http://goo.gl/APeTt for details.
@@ -448,20 +622,42 @@ type Object struct {
 	VersionId string `xml:"VersionId,omitempty"`
 }
 
+// DeleteResult is the outcome of each object of a DelMultiResult: the
+// objects deleted, unless the request was quiet, and those that failed.
+type DeleteResult struct {
+	Deleted []Object      `xml:"Deleted"`
+	Errors  []DeleteError `xml:"Error"`
+}
+
+// DeleteError is an object that failed to be deleted by DelMultiResult.
+type DeleteError struct {
+	Key       string `xml:"Key"`
+	VersionId string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
 // DelMulti removes up to 1000 objects from the S3 bucket.
 //
 // See http://goo.gl/jx6cWK for details.
 func (b *Bucket) DelMulti(objects Delete) error {
+	_, err := b.DelMultiResult(objects)
+	return err
+}
+
+// DelMultiResult removes up to 1000 objects from the S3 bucket, like
+// DelMulti, returning which of them failed to be deleted.
+func (b *Bucket) DelMultiResult(objects Delete) (*DeleteResult, error) {
 	doc, err := xml.Marshal(objects)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	buf := makeXmlBuffer(doc)
 	digest := md5.New()
 	size, err := digest.Write(buf.Bytes())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	headers := map[string][]string{
@@ -478,7 +674,11 @@ func (b *Bucket) DelMulti(objects Delete) error {
 		payload: buf,
 	}
 
-	return b.S3.query(req, nil)
+	result := &DeleteResult{}
+	if err := b.S3.query(req, result); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // The ListResp type holds the results of a List bucket operation.
@@ -629,6 +829,91 @@ func (b *Bucket) GetPermissions(path string) (result *PermissionsResp, err error
 	return result, nil
 }
 
+// grantHeaders are the headers of PUT ?acl granting each permission.
+var grantHeaders = map[string]string{
+	"READ":         "x-amz-grant-read",
+	"WRITE":        "x-amz-grant-write",
+	"READ_ACP":     "x-amz-grant-read-acp",
+	"WRITE_ACP":    "x-amz-grant-write-acp",
+	"FULL_CONTROL": "x-amz-grant-full-control",
+}
+
+// PutGrants replaces the ACL of an object by grants, each to a canonical
+// user by ID or to a group by URI, rather than by a canned ACL.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObjectAcl.html
+func (b *Bucket) PutGrants(path string, grants []Grant) error {
+	headers := map[string][]string{}
+	for _, g := range grants {
+		name, ok := grantHeaders[g.Permission]
+		if !ok {
+			return fmt.Errorf("unknown permission %q", g.Permission)
+		}
+		var grantee string
+		switch {
+		case g.Grantee.URI != "":
+			grantee = fmt.Sprintf("uri=%q", g.Grantee.URI)
+		case g.Grantee.ID != "":
+			grantee = fmt.Sprintf("id=%q", g.Grantee.ID)
+		default:
+			return fmt.Errorf("grant of %s without a grantee", g.Permission)
+		}
+		if h := headers[name]; len(h) > 0 {
+			h[0] += ", " + grantee
+		} else {
+			headers[name] = []string{grantee}
+		}
+	}
+	req := &request{
+		method:  "PUT",
+		bucket:  b.Name,
+		path:    path,
+		headers: headers,
+		params:  url.Values{"acl": {""}},
+	}
+	return b.S3.query(req, nil)
+}
+
+// Tag is a tag of an object.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Tagging is the tag set of an object.
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+// GetTags returns the tags of an object, by key. HEAD and GET responses
+// tell how many tags an object has with x-amz-tagging-count.
+//
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObjectTagging.html
+func (b *Bucket) GetTags(path string) (map[string]string, error) {
+	req := &request{
+		bucket: b.Name,
+		params: map[string][]string{"tagging": {""}},
+		path:   path,
+	}
+	var result Tagging
+	var err error
+	for attempt := attempts.Start(); attempt.Next(); {
+		err = b.S3.query(req, &result)
+		if !shouldRetry(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(result.TagSet))
+	for _, tag := range result.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
 // The VersionsResp type holds the results of a list bucket Versions operation.
 type VersionsResp struct {
 	Name            string
@@ -810,6 +1095,7 @@ type request struct {
 	baseurl  string
 	payload  io.Reader
 	prepared bool
+	v4       bool // signed with Signature Version 4
 }
 
 func (req *request) url() (*url.URL, error) {
@@ -819,6 +1105,10 @@ func (req *request) url() (*url.URL, error) {
 	}
 	u.RawQuery = req.params.Encode()
 	u.Path = req.path
+	if req.v4 {
+		// Send the path escaped as it was signed.
+		u.RawPath = v4EscapePath(req.path)
+	}
 	return u, nil
 }
 
@@ -887,13 +1177,64 @@ func (s3 *S3) prepare(req *request) error {
 	reqSignpathSpaceFix := (&url.URL{Path: signpath}).String()
 	req.headers["Host"] = []string{u.Host}
 	req.headers["Date"] = []string{time.Now().In(time.UTC).Format(time.RFC1123)}
-	if s3.Auth.Token() != "" {
-		req.headers["X-Amz-Security-Token"] = []string{s3.Auth.Token()}
+	var auth aws.Auth
+	var token string
+	if s3.Credentials != nil {
+		if auth, err = s3.Credentials.Credentials(); err != nil {
+			return fmt.Errorf("getting credentials: %v", err)
+		}
+		token = auth.SessionToken()
+	} else {
+		token = s3.Auth.Token()
+		auth = s3.Auth
+	}
+	if token != "" {
+		req.headers["X-Amz-Security-Token"] = []string{token}
+	}
+	if s3.RequesterPays {
+		req.headers["x-amz-request-payer"] = []string{"requester"}
+	}
+	if len(s3.CustomerKey) != 0 && isObjectRequest(req, signpath) {
+		addCustomerKey(req.headers, "x-amz-server-side-encryption-customer-", s3.CustomerKey)
 	}
-	sign(s3.Auth, req.method, reqSignpathSpaceFix, req.params, req.headers)
+	// Signed URLs are still signed with Signature Version 2.
+	if s3.Signature == aws.V4Signature && req.params.Get("Expires") == "" {
+		req.v4 = true
+		signV4(auth, s3.Region.Name, req.method, req.path, req.params, req.headers, time.Now())
+		return nil
+	}
+	sign(auth, req.method, reqSignpathSpaceFix, req.params, req.headers)
 	return nil
 }
 
+// subresourcesWithoutCustomerKey are the subresources of objects that are
+// read or written without their SSE-C key.
+var subresourcesWithoutCustomerKey = []string{"acl", "tagging", "retention", "legal-hold", "torrent"}
+
+// isObjectRequest is true for the requests that read or write the data or
+// metadata of an object, which need its SSE-C key. signpath is the path of
+// the request, starting with its bucket.
+func isObjectRequest(req *request, signpath string) bool {
+	if req.bucket == "" || req.method == "DELETE" || signpath == "/"+req.bucket+"/" {
+		return false
+	}
+	for _, name := range subresourcesWithoutCustomerKey {
+		if _, ok := req.params[name]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// addCustomerKey adds the headers of an SSE-C key, with prefix
+// x-amz-server-side-encryption-customer- or the one of copy sources.
+func addCustomerKey(headers map[string][]string, prefix string, key []byte) {
+	sum := md5.Sum(key)
+	headers[prefix+"algorithm"] = []string{"AES256"}
+	headers[prefix+"key"] = []string{base64.StdEncoding.EncodeToString(key)}
+	headers[prefix+"key-MD5"] = []string{base64.StdEncoding.EncodeToString(sum[:])}
+}
+
 // run sends req and returns the http response from the server.
 // If resp is not nil, the XML data contained in the response
 // body will be unmarshalled on it.
@@ -946,11 +1287,18 @@ func (s3 *S3) run(req *request, resp interface{}) (*http.Response, error) {
 			ResponseHeaderTimeout: s3.ReadTimeout,
 		},
 	}
+	if s3.Transport != nil {
+		c.Transport = s3.Transport
+		hreq.Close = false
+	}
 
 	hresp, err := c.Do(&hreq)
 	if err != nil {
 		return nil, err
 	}
+	if s3.OnResponse != nil {
+		s3.OnResponse(hresp)
+	}
 	if debug {
 		dump, _ := httputil.DumpResponse(hresp, true)
 		log.Printf("} -> %s\n", dump)
@@ -81,6 +81,11 @@ type Bucket struct {
 	Acl     s3.ACL
 	Ctime   time.Time
 	Objects map[string]*Object
+	// Configs are the documents of the configuration subresources of the
+	// bucket, such as "cors", by name.
+	Configs map[string][]byte
+	// Location is the constraint the bucket was created with.
+	Location string
 }
 
 type Object struct {
@@ -89,6 +94,11 @@ type Object struct {
 	Meta     http.Header // metadata to return with requests.
 	Checksum []byte      // also held as Content-MD5 in meta.
 	Data     []byte
+	Tags     url.Values // the tag set, not returned as metadata.
+	Grants   []s3.Grant // the grants of PUT ?acl, not copied.
+	// CustomerKeyMD5 is the MD5 sum of the SSE-C key of the object, base64
+	// encoded, which requests to read it must provide.
+	CustomerKeyMD5 string
 }
 
 // A resource encapsulates the subject of an HTTP request.
@@ -146,6 +156,7 @@ func (srv *Server) Buckets() map[string]Bucket {
 			Acl:     v.Acl,
 			Ctime:   v.Ctime,
 			Objects: v.Objects,
+			Configs: v.Configs,
 		}
 
 		for oname, odata := range v.Objects {
@@ -247,21 +258,26 @@ func xmlMarshal(w io.Writer, x interface{}) {
 // its own resource type.
 var unimplementedBucketResourceNames = map[string]bool{
 	"acl":            true,
-	"lifecycle":      true,
-	"policy":         true,
-	"location":       true,
 	"logging":        true,
 	"notification":   true,
 	"versions":       true,
 	"requestPayment": true,
 	"versioning":     true,
-	"website":        true,
 	"uploads":        true,
 }
 
+// bucketConfigs are the configuration subresources of buckets, which are
+// stored as they're put, with the code of the error of those that aren't.
+var bucketConfigs = map[string]string{
+	"cors":      "NoSuchCORSConfiguration",
+	"lifecycle": "NoSuchLifecycleConfiguration",
+	"policy":    "NoSuchBucketPolicy",
+	"tagging":   "NoSuchTagSet",
+	"website":   "NoSuchWebsiteConfiguration",
+}
+
 var unimplementedObjectResourceNames = map[string]bool{
 	"uploadId": true,
-	"acl":      true,
 	"torrent":  true,
 	"uploads":  true,
 }
@@ -289,6 +305,18 @@ func (srv *Server) resourceForURL(u *url.URL) (r resource) {
 			if unimplementedBucketResourceNames[name] {
 				return nullResource{}
 			}
+			if name == "location" {
+				if b.bucket == nil {
+					fatalf(404, "NoSuchBucket", "The specified bucket does not exist")
+				}
+				return bucketLocationResource{bucket: b.bucket}
+			}
+			if _, ok := bucketConfigs[name]; ok {
+				if b.bucket == nil {
+					fatalf(404, "NoSuchBucket", "The specified bucket does not exist")
+				}
+				return bucketConfigResource{bucket: b.bucket, name: name}
+			}
 		}
 		return b
 
@@ -313,6 +341,61 @@ func (srv *Server) resourceForURL(u *url.URL) (r resource) {
 	return objr
 }
 
+// bucketConfigResource is a configuration subresource of a bucket.
+type bucketConfigResource struct {
+	bucket *Bucket // always non-nil.
+	name   string
+}
+
+func (r bucketConfigResource) get(a *action) interface{} {
+	doc, ok := r.bucket.Configs[r.name]
+	if !ok {
+		fatalf(404, bucketConfigs[r.name], "The specified configuration does not exist")
+	}
+	if _, err := a.w.Write(doc); err != nil {
+		log.Printf("error writing data: %v", err)
+	}
+	return nil
+}
+
+func (r bucketConfigResource) put(a *action) interface{} {
+	doc, err := ioutil.ReadAll(a.req.Body)
+	if err != nil {
+		fatalf(400, "TODO", "read error")
+	}
+	if r.name != "policy" && r.name != "website" && a.req.Header.Get("Content-MD5") == "" {
+		fatalf(400, "InvalidRequest", "Missing required header for this request: Content-MD5")
+	}
+	if r.bucket.Configs == nil {
+		r.bucket.Configs = make(map[string][]byte)
+	}
+	r.bucket.Configs[r.name] = doc
+	return nil
+}
+
+func (r bucketConfigResource) delete(a *action) interface{} {
+	delete(r.bucket.Configs, r.name)
+	return nil
+}
+
+func (r bucketConfigResource) post(a *action) interface{} { return notAllowed() }
+
+// bucketLocationResource is the location constraint of a bucket.
+type bucketLocationResource struct {
+	bucket *Bucket // always non-nil.
+}
+
+func (r bucketLocationResource) get(a *action) interface{} {
+	return &struct {
+		XMLName    xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ LocationConstraint"`
+		Constraint string   `xml:",chardata"`
+	}{Constraint: r.bucket.Location}
+}
+
+func (bucketLocationResource) put(a *action) interface{}    { return notAllowed() }
+func (bucketLocationResource) post(a *action) interface{}   { return notAllowed() }
+func (bucketLocationResource) delete(a *action) interface{} { return notAllowed() }
+
 // nullResource has error stubs for all resource methods.
 type nullResource struct{}
 
@@ -457,14 +540,16 @@ func (r bucketResource) put(a *action) interface{} {
 		if !validBucketName(r.name) {
 			fatalf(400, "InvalidBucketName", "The specified bucket is not valid")
 		}
-		if loc := locationConstraint(a); loc == "" {
+		loc := locationConstraint(a)
+		if loc == "" {
 			fatalf(400, "InvalidRequets", "The unspecified location constraint is incompatible for the region specific endpoint this request was sent to.")
 		}
 		// TODO validate acl
 		r.bucket = &Bucket{
 			Name: r.name,
 			// TODO default acl
-			Objects: make(map[string]*Object),
+			Objects:  make(map[string]*Object),
+			Location: loc,
 		}
 		a.srv.buckets[r.name] = r.bucket
 		created = true
@@ -477,9 +562,36 @@ func (r bucketResource) put(a *action) interface{} {
 	return nil
 }
 
-func (bucketResource) post(a *action) interface{} {
-	fatalf(400, "Method", "bucket POST method not available")
-	return nil
+// POST on a bucket with ?delete deletes many objects.
+// http://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
+func (r bucketResource) post(a *action) interface{} {
+	if _, ok := a.req.URL.Query()["delete"]; !ok {
+		fatalf(400, "Method", "bucket POST method not available")
+	}
+	if r.bucket == nil {
+		fatalf(404, "NoSuchBucket", "The specified bucket does not exist")
+	}
+	var del s3.Delete
+	if err := xml.NewDecoder(a.req.Body).Decode(&del); err != nil {
+		fatalf(400, "MalformedXML", "The XML you provided was not well-formed: %v", err)
+	}
+	if len(del.Objects) > 1000 {
+		fatalf(400, "MalformedXML", "The request can't delete more than 1000 objects")
+	}
+	result := &deleteResult{}
+	for _, obj := range del.Objects {
+		// deleting a missing object succeeds
+		delete(r.bucket.Objects, obj.Key)
+		if !del.Quiet {
+			result.Deleted = append(result.Deleted, obj)
+		}
+	}
+	return result
+}
+
+type deleteResult struct {
+	XMLName xml.Name    `xml:"DeleteResult"`
+	Deleted []s3.Object `xml:"Deleted"`
 }
 
 // validBucketName returns whether name is a valid bucket name.
@@ -543,11 +655,27 @@ func (objr objectResource) get(a *action) interface{} {
 	if obj == nil {
 		fatalf(404, "NoSuchKey", "The specified key does not exist.")
 	}
+	if _, ok := a.req.URL.Query()["tagging"]; ok && a.req.Method == "GET" {
+		tagging := s3.Tagging{}
+		for key := range obj.Tags {
+			tagging.TagSet = append(tagging.TagSet, s3.Tag{Key: key, Value: obj.Tags.Get(key)})
+		}
+		return tagging
+	}
+	if _, ok := a.req.URL.Query()["acl"]; ok && a.req.Method == "GET" {
+		return s3.PermissionsResp{AccessControlList: obj.Grants}
+	}
+	if customerKeyMD5(a.req.Header, "x-amz-server-side-encryption-customer-") != obj.CustomerKeyMD5 {
+		fatalf(400, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+	}
 	h := a.w.Header()
 	// add metadata
 	for name, d := range obj.Meta {
 		h[name] = d
 	}
+	if len(obj.Tags) > 0 {
+		h.Set("x-amz-tagging-count", fmt.Sprint(len(obj.Tags)))
+	}
 	// override header values in response to request parameters.
 	for name, vals := range a.req.Form {
 		if strings.HasPrefix(name, "response-") {
@@ -589,6 +717,10 @@ var metaHeaders = map[string]bool{
 	"Content-Type":        true,
 	"Content-Encoding":    true,
 	"Content-Disposition": true,
+	"Cache-Control":       true,
+
+	"X-Amz-Website-Redirect-Location": true,
+	"X-Amz-Storage-Class":             true,
 }
 
 // PUT on an object creates the object.
@@ -600,6 +732,13 @@ func (objr objectResource) put(a *action) interface{} {
 
 	// TODO is this correct, or should we erase all previous metadata?
 	obj := objr.object
+	if _, ok := a.req.URL.Query()["acl"]; ok {
+		if obj == nil {
+			fatalf(404, "NoSuchKey", "The specified key does not exist.")
+		}
+		obj.Grants = parseGrants(a.req.Header)
+		return nil
+	}
 	if obj == nil {
 		obj = &Object{
 			Name: objr.name,
@@ -636,10 +775,22 @@ func (objr objectResource) put(a *action) interface{} {
 			obj.Meta[key] = values
 		}
 	}
+	if tagging := a.req.Header.Get("x-amz-tagging"); tagging != "" {
+		tags, err := url.ParseQuery(tagging)
+		if err != nil {
+			fatalf(400, "InvalidArgument", "invalid tagging encoding")
+		}
+		obj.Tags = tags
+	}
 
 	var resp *s3.CopyObjectResult
 	source := a.req.Header.Get("x-amz-copy-source")
 	if source != "" {
+		// like S3, the copy source is URL encoded
+		source, err := url.QueryUnescape(source)
+		if err != nil {
+			fatalf(400, "InvalidArgument", "invalid copy source encoding")
+		}
 		parts := strings.SplitN(source, "/", 2)
 		srcBkt, ok := objr.srv.buckets[parts[0]]
 		if !ok {
@@ -649,6 +800,9 @@ func (objr objectResource) put(a *action) interface{} {
 		if !ok {
 			fatalf(404, "NoSuchKey", "bad source key:"+parts[1])
 		}
+		if customerKeyMD5(a.req.Header, "x-amz-copy-source-server-side-encryption-customer-") != srcObj.CustomerKeyMD5 {
+			fatalf(400, "InvalidRequest", "The source object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+		}
 		obj.Data = srcObj.Data
 		obj.Checksum = srcObj.Checksum
 
@@ -661,11 +815,71 @@ func (objr objectResource) put(a *action) interface{} {
 		obj.Checksum = gotHash
 	}
 
+	obj.CustomerKeyMD5 = customerKeyMD5(a.req.Header, "x-amz-server-side-encryption-customer-")
 	obj.Mtime = time.Now()
 	objr.bucket.Objects[objr.name] = obj
 	return resp
 }
 
+// grantPermissions are the permissions granted by the x-amz-grant-*
+// headers of PUT ?acl, in the order the grants are kept.
+var grantPermissions = []struct{ header, permission string }{
+	{"X-Amz-Grant-Full-Control", "FULL_CONTROL"},
+	{"X-Amz-Grant-Read", "READ"},
+	{"X-Amz-Grant-Read-Acp", "READ_ACP"},
+	{"X-Amz-Grant-Write", "WRITE"},
+	{"X-Amz-Grant-Write-Acp", "WRITE_ACP"},
+}
+
+// parseGrants parses the grants of the x-amz-grant-* headers, such as
+// id="abc", uri="http://acs.amazonaws.com/groups/global/AllUsers".
+func parseGrants(h http.Header) []s3.Grant {
+	var grants []s3.Grant
+	for _, p := range grantPermissions {
+		for _, value := range h[p.header] {
+			for _, grantee := range strings.Split(value, ",") {
+				kv := strings.SplitN(strings.TrimSpace(grantee), "=", 2)
+				if len(kv) != 2 {
+					fatalf(400, "InvalidArgument", "invalid grantee "+grantee)
+				}
+				g := s3.Grant{Permission: p.permission}
+				v := strings.Trim(kv[1], `"`)
+				switch kv[0] {
+				case "id":
+					g.Grantee.ID = v
+				case "uri":
+					g.Grantee.URI = v
+				default:
+					fatalf(400, "InvalidArgument", "unsupported grantee "+grantee)
+				}
+				grants = append(grants, g)
+			}
+		}
+	}
+	return grants
+}
+
+// customerKeyMD5 checks the SSE-C key of a request, given the prefix of its
+// headers, and returns its MD5 sum, empty if there's none.
+func customerKeyMD5(h http.Header, prefix string) string {
+	algorithm, key, keyMD5 := h.Get(prefix+"algorithm"), h.Get(prefix+"key"), h.Get(prefix+"key-MD5")
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return ""
+	}
+	if algorithm != "AES256" {
+		fatalf(400, "InvalidEncryptionAlgorithmError", "The encryption request you specified is not valid. The valid value is AES256.")
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		fatalf(400, "InvalidArgument", "The secret key was invalid for the specified algorithm.")
+	}
+	sum := md5.Sum(raw)
+	if base64.StdEncoding.EncodeToString(sum[:]) != keyMD5 {
+		fatalf(400, "InvalidArgument", "The calculated MD5 hash of the key did not match the hash that was provided.")
+	}
+	return keyMD5
+}
+
 func (objr objectResource) delete(a *action) interface{} {
 	delete(objr.bucket.Objects, objr.name)
 	return nil
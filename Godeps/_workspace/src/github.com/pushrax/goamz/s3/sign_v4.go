@@ -0,0 +1,147 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// S3 signing with Signature Version 4 (http://goo.gl/Lp5qbm)
+
+const (
+	v4Algorithm   = "AWS4-HMAC-SHA256"
+	v4DateFormat  = "20060102T150405Z"
+	v4ShortFormat = "20060102"
+
+	// The payload isn't hashed, so that request bodies can be streamed
+	// without being read twice.
+	v4UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// signV4 adds the X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers
+// to a request for canonicalPath, signing it for the region with the scheme
+// of Signature Version 4.
+func signV4(auth aws.Auth, region, method, canonicalPath string, params url.Values, headers http.Header, t time.Time) {
+	t = t.UTC()
+	headers["X-Amz-Date"] = []string{t.Format(v4DateFormat)}
+	headers["X-Amz-Content-Sha256"] = []string{v4UnsignedPayload}
+	delete(headers, "Date")
+	delete(headers, "Authorization")
+
+	signedHeaders, canonicalHeaders := v4Headers(headers)
+	canonicalRequest := strings.Join([]string{
+		method,
+		v4EscapePath(canonicalPath),
+		v4Query(params),
+		canonicalHeaders,
+		signedHeaders,
+		v4UnsignedPayload,
+	}, "\n")
+
+	scope := strings.Join([]string{t.Format(v4ShortFormat), region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		v4Algorithm,
+		t.Format(v4DateFormat),
+		scope,
+		v4Hash(canonicalRequest),
+	}, "\n")
+
+	key := v4HMAC([]byte("AWS4"+auth.SecretKey), t.Format(v4ShortFormat))
+	key = v4HMAC(key, region)
+	key = v4HMAC(key, "s3")
+	key = v4HMAC(key, "aws4_request")
+	signature := hex.EncodeToString(v4HMAC(key, stringToSign))
+
+	headers["Authorization"] = []string{fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		v4Algorithm, auth.AccessKey, scope, signedHeaders, signature)}
+	if debug {
+		log.Printf("Canonical request: %q", canonicalRequest)
+		log.Printf("String to sign: %q", stringToSign)
+	}
+}
+
+// v4Headers returns the list of signed headers, and their canonical form.
+// Content-Length is left out since the http client may drop it.
+func v4Headers(headers http.Header) (signed, canonical string) {
+	values := make(map[string]string, len(headers))
+	var names []string
+	for k, v := range headers {
+		name := strings.ToLower(k)
+		if name == "content-length" {
+			continue
+		}
+		trimmed := make([]string, len(v))
+		for i, s := range v {
+			trimmed[i] = strings.Join(strings.Fields(s), " ")
+		}
+		if _, ok := values[name]; ok {
+			values[name] += "," + strings.Join(trimmed, ",")
+			continue
+		}
+		names = append(names, name)
+		values[name] = strings.Join(trimmed, ",")
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + ":" + values[name] + "\n"
+	}
+	return strings.Join(names, ";"), strings.Join(lines, "")
+}
+
+// v4Query returns the canonical query string of params, sorted by name then
+// value, with every name and value escaped.
+func v4Query(params url.Values) string {
+	var pairs []string
+	for k, vs := range params {
+		for _, v := range vs {
+			pairs = append(pairs, v4Escape(k, false)+"="+v4Escape(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// v4EscapePath escapes a path the way it's canonicalized by S3, which is
+// also how it has to be sent.
+func v4EscapePath(path string) string {
+	return v4Escape(path, true)
+}
+
+// v4Escape escapes every byte of s except the unreserved characters, and
+// '/' if it's a path.
+func v4Escape(s string, path bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', path && c == '/':
+			buf = append(buf, c)
+		default:
+			buf = append(buf, '%', hexDigits[c>>4], hexDigits[c&15])
+		}
+	}
+	return string(buf)
+}
+
+func v4Hash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func v4HMAC(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,8 @@
+/*
+Package humanize converts boring ugly numbers to human-friendly strings.
+
+Durations can be turned into strings such as "3 days ago", numbers
+representing sizes like 82854982 into useful strings like, "83MB" or
+"79MiB" (whichever you prefer).
+*/
+package humanize
@@ -0,0 +1,144 @@
+// Package brigade lists, diffs and syncs S3 buckets from other Go programs,
+// the way the brigade commands do. Listings are gzip'd, like the files of
+// the commands, so that both can be used on the same files.
+//
+// The commands are thin wrappers of this package where they can be. The
+// packages under cmd/ that it's built on can be used directly for finer
+// control, such as SyncTask in cmd/sync.
+package brigade
+
+import (
+	"compress/gzip"
+	"github.com/Shopify/brigade/cmd/compare"
+	"github.com/Shopify/brigade/cmd/diff"
+	"github.com/Shopify/brigade/cmd/list"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Lister lists the keys of the buckets of an S3 endpoint.
+type Lister struct {
+	// Format of the listings, see the listing package.
+	Format string
+
+	s3 *s3.S3
+}
+
+// NewLister lists the buckets of s, in JSON.
+func NewLister(s *s3.S3) *Lister {
+	return &Lister{Format: listing.JSON, s3: s}
+}
+
+// List the keys of a bucket under prefix, writing them to w.
+func (l *Lister) List(bucket, prefix string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	lw, err := listing.NewWriter(gz, l.Format)
+	if err != nil {
+		return err
+	}
+	if err := list.ListTo(l.s3, bucket, prefix, lw); err != nil {
+		_ = gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// Differ computes the keys of a listing that aren't in an older listing of
+// the same bucket: the keys added since, and those whose ETag changed.
+type Differ struct {
+	// Tolerance of the differences between the ETags of the keys, see
+	// diff.DiffWith.
+	Tolerance compare.Tolerance
+}
+
+// NewDiffer creates a Differ.
+func NewDiffer() *Differ { return &Differ{} }
+
+// Diff reads the old and new JSON listings, writing the keys of the new one
+// that aren't in the old one to w.
+func (d *Differ) Diff(oldList, newList io.Reader, w io.Writer) error {
+	oldgz, err := gzip.NewReader(oldList)
+	if err != nil {
+		return err
+	}
+	newgz, err := gzip.NewReader(newList)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	if err := diff.DiffWith(oldgz, newgz, gz, d.Tolerance); err != nil {
+		_ = gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+// Syncer syncs the keys of listings from a source bucket to a destination
+// bucket. Each sync has its own task, with its own retries and progress.
+type Syncer struct {
+	// Concurrency is the number of keys synced at a time, and MaxRetry how
+	// many times a key is retried before it fails. When 0, they're the
+	// defaults of the sync command.
+	Concurrency int
+	MaxRetry    int
+	// CrossRegion does a GET then a PUT of each key rather than a copy,
+	// for buckets in different regions or accounts.
+	CrossRegion bool
+	// Format of the listings of the synced and failed keys, see the listing
+	// package.
+	Format string
+
+	src, dst *s3.Bucket
+}
+
+// NewSyncer syncs keys from src to dst.
+func NewSyncer(src, dst *s3.Bucket) *Syncer {
+	return &Syncer{Format: listing.JSON, src: src, dst: dst}
+}
+
+// Sync the keys of the input listing, writing those that were synced and
+// those that failed to their outputs, which can be ioutil.Discard. Returns
+// the progress of the sync once it's done.
+func (s *Syncer) Sync(input io.Reader, synced, failed io.Writer) (sync.Progress, error) {
+	opts := []sync.Option{sync.WithOutputFormat(s.Format)}
+	if s.Concurrency > 0 {
+		opts = append(opts, sync.WithConcurrency(s.Concurrency))
+	}
+	if s.MaxRetry > 0 {
+		opts = append(opts, sync.WithRetry(s.MaxRetry, time.Second))
+	}
+	if s.CrossRegion {
+		opts = append(opts, sync.WithSyncer(sync.GetPutSyncer))
+	}
+	task, err := sync.NewSyncTask(s.src, s.dst, opts...)
+	if err != nil {
+		return sync.Progress{}, err
+	}
+
+	gzin, err := gzip.NewReader(input)
+	if err != nil {
+		return sync.Progress{}, err
+	}
+	syncedgz, failedgz := gzipped(synced), gzipped(failed)
+	err = task.Start(gzin, syncedgz, failedgz)
+	for _, w := range []io.Writer{syncedgz, failedgz} {
+		if gz, ok := w.(*gzip.Writer); ok {
+			if cerr := gz.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return task.Progress(), err
+}
+
+// gzipped compresses what's written to w, unless it's discarded anyway.
+func gzipped(w io.Writer) io.Writer {
+	if w == ioutil.Discard {
+		return w
+	}
+	return gzip.NewWriter(w)
+}
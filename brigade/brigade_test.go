@@ -0,0 +1,98 @@
+package brigade_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/Shopify/brigade/brigade"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// readKeys reads the keys of a gzip'd JSON listing.
+func readKeys(t *testing.T, r io.Reader) []s3.Key {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatalf("can't read gzip: %v", err)
+	}
+	var keys []s3.Key
+	dec := json.NewDecoder(gz)
+	for {
+		var key s3.Key
+		switch err := dec.Decode(&key); err {
+		case io.EOF:
+			return keys
+		case nil:
+			keys = append(keys, key)
+		default:
+			t.Fatalf("can't decode key: %v", err)
+		}
+	}
+}
+
+// writeKeys writes keys as a gzip'd JSON listing.
+func writeKeys(t *testing.T, keys []s3.Key) *bytes.Buffer {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, key := range keys {
+		if err := enc.Encode(key); err != nil {
+			t.Fatalf("can't encode key: %v", err)
+		}
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("can't close gzip: %v", err)
+	}
+	return &buf
+}
+
+func TestListDiffSync(t *testing.T) {
+	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	if err := dst.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+
+	var listed bytes.Buffer
+	if err := brigade.NewLister(mocks3.S3()).List(mockbkt.Name(), "/", &listed); err != nil {
+		t.Fatalf("can't list: %v", err)
+	}
+	keys := readKeys(t, bytes.NewReader(listed.Bytes()))
+	if len(keys) != len(mockbkt.Keys()) {
+		t.Fatalf("want %d keys listed, got %d", len(mockbkt.Keys()), len(keys))
+	}
+
+	// only the last key is new since the old listing
+	var diffed bytes.Buffer
+	old := writeKeys(t, keys[:len(keys)-1])
+	if err := brigade.NewDiffer().Diff(old, bytes.NewReader(listed.Bytes()), &diffed); err != nil {
+		t.Fatalf("can't diff: %v", err)
+	}
+	diff := readKeys(t, bytes.NewReader(diffed.Bytes()))
+	if len(diff) != 1 || diff[0].Key != keys[len(keys)-1].Key {
+		t.Fatalf("want the last key in the diff, got %v", diff)
+	}
+
+	syncer := brigade.NewSyncer(src, dst)
+	syncer.Concurrency = 2
+	var synced bytes.Buffer
+	progress, err := syncer.Sync(&diffed, &synced, ioutil.Discard)
+	if err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if progress.Synced != 1 || len(readKeys(t, &synced)) != 1 {
+		t.Errorf("want the key of the diff synced, got %+v", progress)
+	}
+	if _, ok := mocks3.ListBuckets()["dst-bucket"].Objects[diff[0].Key]; !ok {
+		t.Errorf("want %q at the destination", diff[0].Key)
+	}
+}
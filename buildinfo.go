@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Those are set by the `GOLDFLAGS` in the Makefile, as
+// -X main.version=v1.2.0, for the releases. Other builds read them from the
+// build info the go command embeds in the binary.
+var version, branch, commit, built string
+
+// buildInfo tells which build of brigade is running, so that the bug
+// reports and manifests of its runs can be tied to it.
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Built   string `json:"built,omitempty"`
+	// Modified is set for builds of a tree with uncommitted changes.
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// build is the build of brigade running.
+var build = readBuildInfo()
+
+func init() {
+	expvar.Publish("brigade.build", expvar.Func(func() interface{} { return build }))
+}
+
+// readBuildInfo reads the build of brigade from the variables set when
+// linking it, or from the module and version control info the go command
+// embeds in it.
+func readBuildInfo() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, Branch: branch, Built: built, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "(devel)" {
+			b.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if b.Commit == "" {
+					b.Commit = s.Value
+				}
+			case "vcs.time":
+				if b.Built == "" {
+					b.Built = s.Value
+				}
+			case "vcs.modified":
+				b.Modified = s.Value == "true"
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "devel"
+	}
+	// as git rev-parse --short does
+	if len(b.Commit) > 12 {
+		b.Commit = b.Commit[:12]
+	}
+	return b
+}
+
+// String is the version of the build, then where it was built from, such as
+// v1.2.0 (master, 8f1a3fd, modified).
+func (b buildInfo) String() string {
+	var from []string
+	for _, s := range []string{b.Branch, b.Commit} {
+		if s != "" {
+			from = append(from, s)
+		}
+	}
+	if b.Modified {
+		from = append(from, "modified")
+	}
+	if len(from) == 0 {
+		return b.Version
+	}
+	return fmt.Sprintf("%s (%s)", b.Version, strings.Join(from, ", "))
+}
+
+// fields of the build in the logs.
+func (b buildInfo) fields() logrus.Fields {
+	return logrus.Fields{
+		"version":    b.Version,
+		"commit":     b.Commit,
+		"built":      b.Built,
+		"modified":   b.Modified,
+		"go_version": b.GoVersion,
+	}
+}
+
+// serveBuildInfo serves the build of brigade as JSON, on /version of the
+// monitoring handler.
+func serveBuildInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(build)
+}
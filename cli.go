@@ -1,45 +1,144 @@
 package main
 
 import (
+	"bufio"
 	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Shopify/brigade/brigade"
+	"github.com/Shopify/brigade/cmd/audit"
 	"github.com/Shopify/brigade/cmd/backup"
-	"github.com/Shopify/brigade/cmd/diff"
+	"github.com/Shopify/brigade/cmd/batch"
+	"github.com/Shopify/brigade/cmd/bench"
+	"github.com/Shopify/brigade/cmd/bucketconfig"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/compare"
+	"github.com/Shopify/brigade/cmd/creds"
+	"github.com/Shopify/brigade/cmd/daemon"
+	"github.com/Shopify/brigade/cmd/drift"
+	"github.com/Shopify/brigade/cmd/estimate"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/Shopify/brigade/cmd/ingest"
+	"github.com/Shopify/brigade/cmd/lint"
 	"github.com/Shopify/brigade/cmd/list"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/manifest"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Shopify/brigade/cmd/plan"
+	"github.com/Shopify/brigade/cmd/queue"
+	"github.com/Shopify/brigade/cmd/s3file"
 	"github.com/Shopify/brigade/cmd/slice"
+	"github.com/Shopify/brigade/cmd/soak"
+	"github.com/Shopify/brigade/cmd/split"
+	"github.com/Shopify/brigade/cmd/state"
 	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/cmd/transport"
 	"github.com/Sirupsen/logrus"
+	"github.com/aybabtme/humanize"
 	"github.com/codegangsta/cli"
 	"github.com/pushrax/goamz/s3"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var version = "0.0.1"
-
-// Those are set by the `GOLDFLAGS` in the Makefile.
-var branch, commit string
-
 func newApp() *cli.App {
 	app := cli.NewApp()
 	app.Name = "brigade"
 	app.Usage = "Toolkit to list and sync S3 buckets."
-	app.Version = fmt.Sprintf("%s (%s, %s)", version, branch, commit)
+	app.Version = build.String()
+
+	var (
+		errorLogFlag         = cli.StringFlag{Name: "error-log", Usage: "optional file where to also write the errors, as JSON lines"}
+		errorLogMaxSizeFlag  = cli.IntFlag{Name: "error-log-max-size", Value: 100, Usage: "size in MB past which the error log is rotated, 0 to never rotate it"}
+		errorLogMaxFilesFlag = cli.IntFlag{Name: "error-log-max-files", Value: 5, Usage: "number of rotated error logs to keep, as error-log.1, error-log.2 and so on"}
+		syslogFlag           = cli.StringFlag{Name: "syslog", Usage: "optional syslog daemon where to also send the errors and warnings, 'local' (journald under systemd) or an address like udp://host:514"}
+		syslogTagFlag        = cli.StringFlag{Name: "syslog-tag", Value: "brigade", Usage: "tag of the messages sent to syslog"}
+		runIDFlag            = cli.StringFlag{Name: "run-id", Usage: "optional ID of the run, attached to its log lines, failures, events, audit records, manifest and metrics to tell it apart from other runs, generated from the time and random bytes when empty"}
+	)
+	app.Flags = []cli.Flag{errorLogFlag, errorLogMaxSizeFlag, errorLogMaxFilesFlag, syslogFlag, syslogTagFlag, runIDFlag}
+	app.Before = func(c *cli.Context) error {
+		// first, so that the other hooks log the ID too
+		id := c.GlobalString(runIDFlag.Name)
+		if id == "" {
+			id = newRunID(time.Now())
+		}
+		setRunID(id)
+		logrus.WithFields(build.fields()).Info("brigade build")
+
+		if addr := c.GlobalString(syslogFlag.Name); addr != "" {
+			hook, err := newSyslogHook(addr, c.GlobalString(syslogTagFlag.Name))
+			if err != nil {
+				return fmt.Errorf("connecting to syslog: %v", err)
+			}
+			logrus.AddHook(hook)
+		}
+
+		filename := c.GlobalString(errorLogFlag.Name)
+		if filename == "" {
+			return nil
+		}
+		maxSize := int64(c.GlobalInt(errorLogMaxSizeFlag.Name)) << 20
+		file, err := openRotatingFile(filename, maxSize, c.GlobalInt(errorLogMaxFilesFlag.Name))
+		if err != nil {
+			return fmt.Errorf("opening error log: %v", err)
+		}
+		// writes aren't buffered, the file is left open until the process
+		// exits so that errors logged while terminating are kept
+		logrus.AddHook(&errorLogHook{w: file})
+		return nil
+	}
 
 	app.Commands = []cli.Command{
 		listCommand(),
 		syncCommand(),
+		ingestCommand(),
 		sliceCommand(),
+		splitCommand(),
 		diffCommand(),
+		batchCommand(),
+		deleteCommand(),
+		purgeCommand(),
+		headCommand(),
+		bucketConfigCommand(),
 		backupCommand(),
+		mergeCommand(),
+		convertCommand(),
+		estimateCommand(),
+		benchCommand(),
+		soakCommand(),
+		lintCommand(),
+		driftCommand(),
+		compareCommand(),
+		auditCommand(),
+		planCommand(),
+		executeCommand(),
+		statusCommand(),
+		watchCommand(),
+		coordinateCommand(),
+		workCommand(),
+		daemonCommand(),
+		serveCommand(),
 	}
 
 	return app
 }
 
+// prewarmParallel is how many connections are opened at once by -prewarm.
+const prewarmParallel = 16
+
+// streamEvery is the interval at which the progress of a sync is streamed
+// to the clients of /events.
+const streamEvery = time.Second
+
 func setupS3Timeouts(s *s3.S3) *s3.S3 {
 	s.MaxIdleConnsPerHost = 10000
 	s.ConnectTimeout = time.Second * 30
@@ -57,6 +156,39 @@ func mustURL(c *cli.Context, f cli.StringFlag) *url.URL {
 	return u
 }
 
+// mustURLs parses the comma separated URLs of a flag.
+func mustURLs(c *cli.Context, f cli.StringFlag) []*url.URL {
+	var urls []*url.URL
+	for _, s := range strings.Split(mustString(c, f), ",") {
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil || u.Host == "" {
+			cli.ShowCommandHelp(c, c.Command.Name)
+			logrus.WithField("url", s).Fatal("not a valid url")
+		}
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+func mustDuration(c *cli.Context, f cli.StringFlag) time.Duration {
+	s := mustString(c, f)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		cli.ShowCommandHelp(c, c.Command.Name)
+		logrus.WithField("duration", s).Fatal("not a valid duration")
+	}
+	return d
+}
+
+func mustDistribution(c *cli.Context, f cli.StringFlag) func() monitor.Distribution {
+	newDist, err := monitor.NewDistribution(mustString(c, f))
+	if err != nil {
+		cli.ShowCommandHelp(c, c.Command.Name)
+		logrus.WithField("error", err).Fatal("not a valid latency backend")
+	}
+	return newDist
+}
+
 func mustString(c *cli.Context, f cli.StringFlag) string {
 	s := c.String(f.Name)
 	if s == "" && f.Value == "" {
@@ -66,6 +198,18 @@ func mustString(c *cli.Context, f cli.StringFlag) string {
 	return s
 }
 
+// listingFormat negotiates the format of a listing file: the format flag
+// when it's set, else the extension of the file, else the flag's default.
+func listingFormat(c *cli.Context, f cli.StringFlag, filename string) string {
+	if c.IsSet(f.Name) {
+		return c.String(f.Name)
+	}
+	if format := listing.FormatOf(filename); format != "" {
+		return format
+	}
+	return f.Value
+}
+
 func mustConfig(c *cli.Context, f cli.StringFlag) *Config {
 	filename := mustString(c, f)
 	file, err := os.Open(filename)
@@ -75,20 +219,180 @@ func mustConfig(c *cli.Context, f cli.StringFlag) *Config {
 	}
 	defer func() { _ = file.Close() }()
 
+	cfg, err := loadConfigFile(file)
+	if err != nil {
+		cli.ShowCommandHelp(c, c.Command.Name)
+		logrus.WithField("error", err).Fatal("could not load config file")
+	}
+	return cfg
+}
+
+// loadConfigFile loads a config file, which must only be accessible by the
+// current user.
+func loadConfigFile(file *os.File) (*Config, error) {
 	stat, err := file.Stat()
 	if err != nil {
-		logrus.WithField("error", err).Fatal("could not stat config file")
+		return nil, fmt.Errorf("could not stat config file: %v", err)
 	}
 	if !onlyUserAccessible(stat.Mode()) {
-		logrus.Fatal("bad permission on config file, should be only accessible by current user")
+		return nil, errors.New("bad permission on config file, should be only accessible by current user")
 	}
+	return LoadConfig(file)
+}
 
-	cfg, err := LoadConfig(file)
+// openListing opens a listing file, or an s3://bucket/key URL read with the
+// credentials of the state bucket, which is streamed rather than copied
+// locally. It also returns the size of the listing, -1 if unknown.
+func openListing(cfg *Config, name string) (io.ReadCloser, int64, error) {
+	if bucket, key, ok := s3file.Parse(name); ok {
+		bkt := setupS3Timeouts(cfg.State.S3()).Bucket(bucket)
+		r, err := s3file.Open(bkt, key)
+		if err != nil {
+			return nil, 0, err
+		}
+		return r, r.Size(), nil
+	}
+	file, err := os.Open(name)
 	if err != nil {
-		cli.ShowCommandHelp(c, c.Command.Name)
-		logrus.WithField("error", err).Fatal("could not load config file")
+		return nil, 0, err
 	}
-	return cfg
+	size := int64(-1)
+	if fi, err := file.Stat(); err == nil {
+		size = fi.Size()
+	}
+	return file, size, nil
+}
+
+// expandInputFiles expands the listing of a source to its files: names
+// joined with +, each a file, an s3:// URL or a glob of files, such as the
+// listings written by split.
+func expandInputFiles(spec string) ([]string, error) {
+	var files []string
+	for _, name := range strings.Split(spec, "+") {
+		if _, _, ok := s3file.Parse(name); ok || !strings.ContainsAny(name, "*?[") {
+			files = append(files, name)
+			continue
+		}
+		matches, err := filepath.Glob(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %v", name, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no listing file matches %q", name)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// checksumListing checksums a listing by name, from a file or an s3:// URL.
+func checksumListing(cfg *Config, name string) (manifest.Input, error) {
+	file, _, err := openListing(cfg, name)
+	if err != nil {
+		return manifest.Input{}, err
+	}
+	defer func() { logIfErr(file.Close()) }()
+	return manifest.Checksum(name, file)
+}
+
+// flagValues returns the value of each flag of a command, whether set or
+// defaulted, as it would be given on the command line.
+func flagValues(c *cli.Context) map[string]string {
+	values := make(map[string]string, len(c.Command.Flags))
+	for _, f := range c.Command.Flags {
+		switch f := f.(type) {
+		case cli.StringFlag:
+			values[f.Name] = c.String(f.Name)
+		case cli.IntFlag:
+			values[f.Name] = strconv.Itoa(c.Int(f.Name))
+		case cli.Float64Flag:
+			values[f.Name] = strconv.FormatFloat(c.Float64(f.Name), 'g', -1, 64)
+		case cli.BoolFlag:
+			values[f.Name] = strconv.FormatBool(c.Bool(f.Name))
+		}
+	}
+	return values
+}
+
+// readETags reads the ETags of the keys of a gzip'd listing by name, from a
+// file or an s3:// URL.
+func readETags(cfg *Config, name string) (map[string]string, error) {
+	file, _, err := openListing(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { logIfErr(file.Close()) }()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { logIfErr(gz.Close()) }()
+	return sync.ReadETags(gz)
+}
+
+// newEmitter creates an emitter of sync events to an SQS queue URL or to a
+// kinesis://<stream> URL, using the credentials of the queue.
+func newEmitter(cfg *Config, target string) (*events.Emitter, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	queueCfg := cfg.QueueConfig()
+	auth, region := queueCfg.AWS()
+	var pub events.Publisher
+	switch u.Scheme {
+	case "kinesis":
+		pub = events.NewKinesis(auth, region, u.Host)
+	case "http", "https":
+		pub = events.NewSQS(auth, region, target)
+	default:
+		return nil, fmt.Errorf("want an SQS queue URL or kinesis://<stream>, got %q", target)
+	}
+	logrus.WithField("target", target).Info("pushing sync events")
+	return events.NewEmitter(pub, time.Second), nil
+}
+
+// createOutput creates an atomic, gzip'd output file. If filename is empty
+// or /dev/null, the output is discarded. If it's an s3:// URL, the output is
+// uploaded as it's written using the credentials of the state bucket of
+// cfg, and fsyncEvery doesn't apply. If it's the URL of an SQS queue, each
+// key is sent as a JSON message using the credentials of the queue config.
+func createOutput(cfg *Config, filename string, fsyncEvery time.Duration) (io.Writer, func() error, error) {
+	if filename == "" || filename == os.DevNull {
+		// sync tasks don't encode keys at all for ioutil.Discard
+		closer := func() error { return nil }
+		return ioutil.Discard, closer, nil
+	}
+	if isQueueURL(filename) {
+		if cfg == nil {
+			return nil, nil, fmt.Errorf("can't send %q to a queue, only to files", filename)
+		}
+		queueCfg := cfg.QueueConfig()
+		auth, region := queueCfg.AWS()
+		out := queue.NewOutput(queue.NewSQS(auth, region, filename))
+		return out, out.Close, nil
+	}
+	if bucket, key, ok := s3file.Parse(filename); ok {
+		if cfg == nil {
+			return nil, nil, fmt.Errorf("can't write %q to S3, only to files", filename)
+		}
+		out, err := createS3Output(setupS3Timeouts(cfg.State.S3()).Bucket(bucket), key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return out, out.Close, nil
+	}
+
+	file, err := createAtomicFile(filename, fsyncEvery)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
+
+// isQueueURL tells whether an output is an SQS queue rather than a file.
+func isQueueURL(filename string) bool {
+	return strings.HasPrefix(filename, "https://") || strings.HasPrefix(filename, "http://")
 }
 
 func onlyUserAccessible(mode os.FileMode) bool {
@@ -101,6 +405,7 @@ func listCommand() cli.Command {
 		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
 		bucketFlag = cli.StringFlag{Name: "bucket", Value: "", Usage: "path to bucket to list, of the form s3://name/path/"}
 		destFlag   = cli.StringFlag{Name: "dest", Value: "bucket_list.json.gz", Usage: "filename to which the list of keys is saved"}
+		formatFlag = cli.StringFlag{Name: "format", Value: listing.JSON, Usage: "format of the list of keys, json, binary or msgpack, defaults to the one of the dest extension (.json, .bin, .msgpack) or json"}
 	)
 
 	return cli.Command{
@@ -113,6 +418,7 @@ traversing is saved and gzip'd as a list of s3 keys in JSON form.`),
 			configFlag,
 			bucketFlag,
 			destFlag,
+			formatFlag,
 		},
 		Action: func(c *cli.Context) {
 
@@ -120,7 +426,7 @@ traversing is saved and gzip'd as a list of s3 keys in JSON form.`),
 			bkt := mustURL(c, bucketFlag)
 			dest := mustString(c, destFlag)
 
-			srcS3 := setupS3Timeouts(s3.New(cfg.Source.AWS()))
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
 
 			file, dsterr := os.Create(dest)
 			if dsterr != nil {
@@ -133,12 +439,17 @@ traversing is saved and gzip'd as a list of s3 keys in JSON form.`),
 			}
 			defer func() { logIfErr(file.Close()) }()
 
-			gw := gzip.NewWriter(file)
-			defer func() { logIfErr(gw.Close()) }()
+			lister := brigade.NewLister(srcS3)
+			lister.Format = listingFormat(c, formatFlag, dest)
+			if _, err := listing.NewWriter(ioutil.Discard, lister.Format); err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid format")
+				return
+			}
 
 			logrus.Info("starting command ", c.Command.Name)
 
-			err := list.List(srcS3, bkt.Host, bkt.Path, gw)
+			err := lister.List(bkt.Host, bkt.Path, file)
 			if err != nil {
 				logrus.WithField("error", err).Error("failed to list bucket")
 			}
@@ -150,12 +461,128 @@ func syncCommand() cli.Command {
 	var (
 		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
 
-		inputFlag       = cli.StringFlag{Name: "input", Usage: "name of the file containing the list of keys to sync"}
-		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the list of keys that succeeded to sync, defaults to /dev/null"}
-		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of keys that failed to sync, defaults to /dev/null"}
-		srcFlag         = cli.StringFlag{Name: "src", Usage: "source bucket to get the keys from"}
-		dstFlag         = cli.StringFlag{Name: "dest", Usage: "destination bucket to put the keys into"}
-		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 1000, Usage: "number of concurrent sync request"}
+		inputFlag       = cli.StringFlag{Name: "input", Usage: "name of the file containing the list of keys to sync, or its s3://bucket/key URL in the state bucket, comma separated for many sources; the listing of a source can be many files, joined with + or matched by a glob like 'listings/*_bucket.json.gz'"}
+		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the list of keys that succeeded to sync, s3:// URL, or SQS queue URL, defaults to /dev/null"}
+		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of keys that failed to sync, s3:// URL, or SQS queue URL, defaults to /dev/null"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "source bucket to get the keys from, or comma separated buckets to merge into the destination"}
+		dstFlag         = cli.StringFlag{Name: "dest", Usage: "destination bucket to put the keys into, or comma separated buckets to copy each key to all of them"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 1000, Usage: "number of concurrent sync request, per destination"}
+		shardsFlag      = cli.IntFlag{Name: "shards", Value: 1, Usage: "number of files over which to shard the success and failure outputs, each with its own encoder"}
+		fsyncFlag       = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs are flushed to disk, 0 to only flush on completion"}
+		stateFlag       = cli.StringFlag{Name: "state", Usage: "optional file where to record the status of each key, keys already synced in this file are skipped"}
+
+		cloudwatchFlag      = cli.StringFlag{Name: "cloudwatch", Usage: "optional CloudWatch namespace where to publish the sync metrics, with the RunID of the run among their dimensions"}
+		cloudwatchEveryFlag = cli.StringFlag{Name: "cloudwatch-every", Value: "1m", Usage: "interval at which metrics are published to CloudWatch"}
+		latencyReportFlag   = cli.StringFlag{Name: "latency-report", Usage: "optional file where to write the histogram of sync latencies, as JSON, once done"}
+		latencyBackendFlag  = cli.StringFlag{Name: "latency-backend", Value: monitor.HDR, Usage: "how the sync latencies of the process are aggregated into quantiles: hdr, a histogram with a bounded error at any quantile, or tdigest, more accurate at the tails"}
+		injectFaultsFlag    = cli.StringFlag{Name: "inject-faults", Usage: "for testing only, faults to inject in the sync calls, e.g. 'error=0.01:SlowDown,InternalError;spike=0.05:2s;drop=0.001;seed=42'"}
+		getPutFlag          = cli.BoolFlag{Name: "get-put", Usage: "GET then PUT the keys instead of copying them, for buckets in different regions or accounts, uploads are accelerated if the destination config enables it"}
+		bandwidthFlag       = cli.StringFlag{Name: "max-bandwidth", Usage: "optional bytes a second, like 50MB, read from the source by all the copies of -get-put, destinations included, to cap the network the sync takes"}
+		workerBandwidthFlag = cli.StringFlag{Name: "max-worker-bandwidth", Usage: "optional bytes a second, like 5MB, read from the source by each copy of -get-put"}
+		lockModeFlag        = cli.StringFlag{Name: "lock-mode", Usage: "optional Object Lock retention mode of the copies, GOVERNANCE or COMPLIANCE"}
+		lockUntilFlag       = cli.StringFlag{Name: "lock-until", Usage: "date until which the copies are retained with lock-mode, in RFC 3339 format"}
+		legalHoldFlag       = cli.BoolFlag{Name: "legal-hold", Usage: "put the copies under Object Lock legal hold"}
+		copyGrantsFlag      = cli.BoolFlag{Name: "copy-grants", Usage: "copy the ACL grants of each key onto its copy, such as those to other accounts, rather than only whether it's public-read or private"}
+		grantIDsFlag        = cli.StringFlag{Name: "grant-ids", Usage: "optional file of the canonical IDs of accounts at the source and of those that replace them at the destination, a pair per line, for -copy-grants"}
+		redirectsFlag       = cli.BoolFlag{Name: "preserve-redirects", Usage: "HEAD every key to copy its website redirect location, which S3 doesn't copy, for static website buckets"}
+		mtimeFlag           = cli.BoolFlag{Name: "preserve-mtime", Usage: "HEAD every key to stamp its Last-Modified time into the metadata of its copy, as x-amz-meta-src-mtime, since S3 sets the one of copies to the time they're copied"}
+		rewriteFlag         = cli.BoolFlag{Name: "rewrite", Usage: "rewrite the metadata, ACL, storage class or tags of the keys in place at the destination, copying each onto itself, the source defaults to the destination and isn't read"}
+		setTypeFlag         = cli.StringFlag{Name: "set-content-type", Usage: "Content-Type of the keys with -rewrite"}
+		setCacheFlag        = cli.StringFlag{Name: "set-cache-control", Usage: "Cache-Control of the keys with -rewrite"}
+		setDispositionFlag  = cli.StringFlag{Name: "set-content-disposition", Usage: "Content-Disposition of the keys with -rewrite"}
+		setEncodingFlag     = cli.StringFlag{Name: "set-content-encoding", Usage: "Content-Encoding of the keys with -rewrite"}
+		setMetaFlag         = cli.StringFlag{Name: "set-meta", Usage: "user metadata set on the keys with -rewrite, on top of the one they have, like 'owner=web&tier=hot'"}
+		setACLFlag          = cli.StringFlag{Name: "set-acl", Usage: "canned ACL of the keys with -rewrite, such as private or public-read, else the one they have"}
+		setClassFlag        = cli.StringFlag{Name: "set-storage-class", Usage: "storage class of the keys with -rewrite, such as STANDARD_IA, else the one they have"}
+		setTagsFlag         = cli.StringFlag{Name: "set-tags", Usage: "tags replacing the ones of the keys with -rewrite, like 'team=web&env=prod'"}
+		formatFlag          = cli.StringFlag{Name: "format", Value: listing.JSON, Usage: "encoder of the success and failure outputs, " + strings.Join(listing.Encoders(), ", ") + ", defaults to the format of the success extension (.json, .bin, .msgpack) or json; outputs that are SQS queue URLs get a JSON message per key whatever the encoder"}
+		failureFormatFlag   = cli.StringFlag{Name: "failure-format", Usage: "optional encoder of the failure output, when it's not the one of -format"}
+		breakerWindowFlag   = cli.IntFlag{Name: "breaker-window", Usage: "optional number of last keys over which failure rates are measured, to pause the sync when they're too high"}
+		breakerRateFlag     = cli.Float64Flag{Name: "breaker-failure-rate", Value: 0.5, Usage: "fraction of the keys of the breaker window that must fail for the sync to pause"}
+		breakerCodeRateFlag = cli.Float64Flag{Name: "breaker-code-rate", Usage: "optional fraction of the keys of the breaker window that must fail with the same error code for the sync to pause"}
+		breakerCoolDownFlag = cli.StringFlag{Name: "breaker-cool-down", Value: "5m", Usage: "how long the sync pauses when the breaker trips"}
+		budgetFlag          = cli.IntFlag{Name: "retry-budget", Usage: "optional number of retries shared by all the keys, each key synced earning back a fraction of a retry, to stop retrying quickly when the destination is down"}
+		budgetRefillFlag    = cli.Float64Flag{Name: "retry-budget-refill", Value: 0.1, Usage: "fraction of a retry earned back by each key synced"}
+		budgetActionFlag    = cli.StringFlag{Name: "retry-budget-action", Value: sync.BudgetFail, Usage: "what to do once the retry budget is spent: fail keys without retries, pause the sync for the cool-down, or abort it"}
+		budgetCoolDownFlag  = cli.StringFlag{Name: "retry-budget-cool-down", Value: "5m", Usage: "how long the sync pauses when the retry budget is spent, with the pause action"}
+		hedgeFlag           = cli.Float64Flag{Name: "hedge-quantile", Usage: "optional quantile of the latency of the sync calls, such as 0.95, after which a call is hedged with a second one, the first to succeed winning"}
+		hedgeMinCallsFlag   = cli.IntFlag{Name: "hedge-min-calls", Value: 1000, Usage: "number of sync calls made before any is hedged, for the quantile to be meaningful"}
+		hedgeMaxRateFlag    = cli.Float64Flag{Name: "hedge-max-rate", Value: 0.05, Usage: "fraction of the sync calls that can be hedged, to cap the extra calls when S3 is slow as a whole"}
+		parkDelayFlag       = cli.StringFlag{Name: "park-delay", Usage: "optional duration for which the keys that exhausted their retries are parked, to retry them once more at the end of the sync instead of failing them"}
+		retryConcFlag       = cli.IntFlag{Name: "retry-concurrency", Usage: "optional number of workers that retry the keys whose first sync call failed, per destination, so that a burst of failing keys doesn't hold the -concurrency workers from the fresh keys"}
+		parkMaxFlag         = cli.IntFlag{Name: "park-max", Value: 100000, Usage: "number of keys that can be parked, keys that exhaust their retries once they're all taken fail right away"}
+		maxFailuresFlag     = cli.IntFlag{Name: "max-failures", Usage: "optional number of keys that can fail to sync before the sync stops with a non-zero status"}
+		maxFailureRateFlag  = cli.Float64Flag{Name: "max-failure-rate", Usage: "optional fraction of the keys done so far that can fail to sync before the sync stops with a non-zero status, checked after 100 keys"}
+		progressFlag        = cli.StringFlag{Name: "progress-file", Usage: "optional file where to write a JSON snapshot of the counters, rates and ETA of the sync, which 'status -progress' reports on"}
+		progressEveryFlag   = cli.StringFlag{Name: "progress-every", Value: "10s", Usage: "interval at which the progress file is written"}
+		rateEveryFlag       = cli.StringFlag{Name: "rate-every", Value: "1s", Usage: "interval over which the throughput is measured, for the peak throughput of the summary, and at which the progress is logged with -log-progress"}
+		logProgressFlag     = cli.BoolFlag{Name: "log-progress", Usage: "log the progress of the sync every rate-every"}
+		eventsFlag          = cli.StringFlag{Name: "events", Usage: "optional SQS queue URL, or kinesis://<stream>, where to push an event for each key synced or failed, with the credentials of the queue config"}
+		timeoutFlag         = cli.StringFlag{Name: "sync-timeout", Usage: "optional duration after which a sync call is given up on and retried, so that a hung request can't hold a worker forever"}
+		stallAfterFlag      = cli.StringFlag{Name: "stall-after", Usage: "optional duration without any key sync'd, while keys are pending, after which the sync is considered stalled"}
+		stallActionFlag     = cli.StringFlag{Name: "stall-action", Value: sync.StallDump, Usage: "action taken when the sync stalls: log, dump the goroutine stacks, restart the workers or abort, each also taking the previous ones"}
+		slowKeyAfterFlag    = cli.StringFlag{Name: "slow-key-after", Usage: "optional duration after which a key that is still syncing, retries included, is warned about, and the slowest keys listed in the progress file"}
+		slowKeyTopFlag      = cli.IntFlag{Name: "slow-key-top", Value: 10, Usage: "number of the slowest keys listed in the progress file"}
+		anomalyEveryFlag    = cli.StringFlag{Name: "anomaly-every", Usage: "optional window over which the latency and failure rate of the sync calls are compared to their baseline, warning when they deviate from it"}
+		anomalyBaseFlag     = cli.IntFlag{Name: "anomaly-baseline", Value: 10, Usage: "number of windows the baseline of the sync calls spans"}
+		anomalyLatencyFlag  = cli.Float64Flag{Name: "anomaly-latency-factor", Value: 5, Usage: "how many times the p95 latency of the baseline the p95 of a window must be to warn"}
+		anomalyFailureFlag  = cli.Float64Flag{Name: "anomaly-failure-rate", Value: 0.25, Usage: "how much above the failure rate of the baseline the fraction of the calls of a window that fail must be to warn"}
+		anomalyMinCallsFlag = cli.IntFlag{Name: "anomaly-min-calls", Value: 20, Usage: "number of calls a window needs to be compared to the baseline"}
+		anomalyWebhookFlag  = cli.StringFlag{Name: "anomaly-webhook", Usage: "optional URL to which each anomaly, and its recovery, is POSTed as JSON"}
+		mapFlag             = cli.StringFlag{Name: "map", Usage: "optional comma separated from=to prefix mappings, one per source, moving the keys of a source from one prefix to the other at the destination"}
+		normalizeFlag       = cli.BoolFlag{Name: "normalize-keys", Usage: "normalize the names of the keys at the destination after their mapping, dropping the problems the lint command finds"}
+		collisionsFlag      = cli.StringFlag{Name: "collisions", Value: sync.CollideOverwrite, Usage: "what to do with the keys that many sources name the same at the destination: overwrite, skip or fail all but the first"}
+		existingFlag        = cli.StringFlag{Name: "existing", Value: sync.CollideOverwrite, Usage: "what to do with the keys that already exist at the destination: overwrite, skip, fail or rename them with the existing-suffix, checked with a HEAD of each key unless existing-listing is set"}
+		existingSuffixFlag  = cli.StringFlag{Name: "existing-suffix", Value: ".brigade", Usage: "suffix of the name of the keys renamed because they already exist at the destination"}
+		existingListFlag    = cli.StringFlag{Name: "existing-listing", Usage: "optional listing of the destination, to check which keys already exist without a HEAD of each key"}
+		deltaFlag           = cli.BoolFlag{Name: "delta", Usage: "only sync the keys that are missing at the destination or have another ETag there, checked with a HEAD of each key unless existing-listing is set"}
+		verifySampleFlag    = cli.StringFlag{Name: "verify-sample", Usage: "optional fraction of the synced keys, such as 1%, picked at random and HEAD'd at the destination once the sync is done, to check that they match their source"}
+		checksumFlag        = cli.StringFlag{Name: "checksum", Usage: "optional hash, of md5, sha256, xxhash or crc32c, comparing the sampled keys of -verify-sample whose ETags can't tell, with the metadata carrying the sums of the keys as hash:name, such as sha256:content-sha256"}
+		reconcileFlag       = cli.IntFlag{Name: "reconcile-depth", Usage: "optional depth of the prefixes of the destination, in path segments, whose keys are counted once the sync is done and compared to the keys synced there"}
+		reconcileMaxFlag    = cli.IntFlag{Name: "reconcile-prefixes", Usage: "most prefixes counted by -reconcile-depth, picked at random, all of them when 0"}
+		dedupeFlag          = cli.StringFlag{Name: "dedupe-report", Usage: "optional file where to write, once the sync is done, the sets of keys synced that hold the same content at the destination, by size and ETag, as JSON"}
+		dedupeMinSizeFlag   = cli.StringFlag{Name: "dedupe-min-size", Value: "1B", Usage: "size of the smallest keys in the -dedupe-report, like 64KB, so that the many small keys with the same few bytes don't swamp it"}
+		validateInputFlag   = cli.BoolFlag{Name: "validate-input", Usage: "decode a sample of the start of each listing before syncing, and refuse to sync one that isn't made of valid keys"}
+		validateSampleFlag  = cli.IntFlag{Name: "validate-sample", Value: 10000, Usage: "number of keys decoded at the start of each listing with -validate-input"}
+		previewFlag         = cli.IntFlag{Name: "preview", Usage: "optional number of keys of each listing printed with their name at the destination, after -map and -normalize-keys, along with counts of the keys by prefix, before exiting without syncing"}
+		previewDepthFlag    = cli.IntFlag{Name: "preview-depth", Value: 1, Usage: "number of '/'-separated parts of the keys that make the prefixes counted by -preview"}
+		maxLineFlag         = cli.IntFlag{Name: "max-line-size", Value: sync.DefaultMaxLine, Usage: "longest line of a JSON listing read, in bytes, longer lines are malformed"}
+		skipMalformedFlag   = cli.BoolFlag{Name: "skip-malformed", Usage: "skip the malformed lines of the listing, counting them, rather than failing the sync on the first"}
+		maxMalformedFlag    = cli.IntFlag{Name: "max-malformed", Usage: "optional number of malformed lines skipped before the sync fails anyway, since the listing is likely corrupt"}
+		conditionalFlag     = cli.BoolFlag{Name: "conditional", Usage: "only copy the keys that didn't change at the source since they were listed, nor at the destination since it was listed in existing-listing, other keys fail as conflicts"}
+		execFlag            = cli.StringFlag{Name: "exec-per-key", Usage: "optional shell command run after each key is synced, with {key} and {bucket} replaced by the key and bucket at the destination, e.g. 'purge-cache {key}'"}
+		execParaFlag        = cli.IntFlag{Name: "exec-concurrency", Value: 4, Usage: "number of exec-per-key commands run at once, per destination"}
+		execRetryFlag       = cli.IntFlag{Name: "exec-retries", Value: 2, Usage: "number of times a failed exec-per-key command is retried before it's given up on"}
+		execTimeoutFlag     = cli.StringFlag{Name: "exec-timeout", Usage: "optional duration after which an exec-per-key command is killed, and fails"}
+		sampleFlag          = cli.StringFlag{Name: "sample", Usage: "optional fraction of the keys of the listing, such as 0.1%, picked at random to be synced, to rehearse a sync"}
+		sampleCountFlag     = cli.IntFlag{Name: "sample-count", Usage: "optional number of keys of the listing picked at random to be synced, to rehearse a sync, read from the whole listing first"}
+		sampleSeedFlag      = cli.IntFlag{Name: "sample-seed", Usage: "optional seed of the random sample, the same seed picks the same keys of a listing, random when 0"}
+		instanceShardFlag   = cli.StringFlag{Name: "shard", Usage: "optional i/n share of the keys of the listing to sync, such as 2/4, for n instances of the sync to split a listing by a hash of the keys, each with its own i from 1 to n"}
+		prewarmFlag         = cli.IntFlag{Name: "prewarm", Usage: "optional number of connections opened to each S3 endpoint before the sync starts, a few at a time, and kept alive for its requests"}
+		dnsRefreshFlag      = cli.StringFlag{Name: "dns-refresh", Usage: "optional interval at which the addresses of the S3 endpoints are looked up again, caching them in between, and keeping them when the lookup fails"}
+		prefixRateFlag      = cli.Float64Flag{Name: "prefix-rate", Usage: "optional cap on the writes a second to each prefix of the destination, below the rate at which S3 throttles a prefix"}
+		prefixRatesFlag     = cli.StringFlag{Name: "prefix-rates", Usage: "optional caps on the writes a second to given prefixes of the destination, like 'images/=3000,logs/=500'"}
+		prefixDepthFlag     = cli.IntFlag{Name: "prefix-depth", Value: 1, Usage: "number of '/'-separated parts of the keys that make the prefixes capped by -prefix-rate"}
+		scheduleFlag        = cli.StringFlag{Name: "schedule", Usage: "optional caps on the sync calls by the time of day, like '06:00-22:00=rate:50,calls:20', in calls a second and calls in flight, uncapped outside of the windows"}
+		scheduleTZFlag      = cli.StringFlag{Name: "schedule-tz", Usage: "optional time zone of the windows of -schedule, like America/Toronto, the local time zone when empty"}
+		priorityFlag        = cli.StringFlag{Name: "priority", Usage: "optional comma-separated prefixes of the keys to sync ahead of the others, from the most urgent"}
+		priorityWindowFlag  = cli.IntFlag{Name: "priority-window", Value: 100000, Usage: "number of keys of the listing held to sync them by priority, which is how far ahead an urgent key is found"}
+		orderedFlag         = cli.IntFlag{Name: "ordered-window", Usage: "optional number of keys held to write the success output in the order of the listing, for consumers that rely on it; a slow key holds up the sync once they're all held, each costs about 160 bytes plus its name, and the keys are decoded by a single decoder"}
+		markersFlag         = cli.StringFlag{Name: "markers", Value: sync.MarkersCopy, Usage: "what to do with the directory markers, the empty keys whose name ends with a '/': copy them, skip them, or synthesize the ones missing at the destination for the prefixes of the keys synced, copying the others; they're counted apart from the keys"}
+		inputInterleaveFlag = cli.BoolFlag{Name: "input-interleave", Usage: "read a key of each listing file of a source in turn, rather than one file after the other, when -input names many"}
+		interleaveFlag      = cli.IntFlag{Name: "interleave", Usage: "optional number of keys of the listing held to sync them round-robin over their top-level prefixes, rather than prefix after prefix, to spread the load S3 throttles per prefix"}
+		maxDecodersFlag     = cli.IntFlag{Name: "max-decoders", Usage: "optional number of JSON decoders the pool of decoders can grow to when lines wait to be decoded, 4 per CPU when 0"}
+		queueDirFlag        = cli.StringFlag{Name: "queue-dir", Usage: "optional directory where the decoded keys are queued on their way to the sync workers, so the listing is read ahead of them, and a restart drains the queue instead of reading the listing again"}
+		spillDirFlag        = cli.StringFlag{Name: "spill-dir", Usage: "optional directory where the synced and failed keys spill to temporary files when the outputs can't keep up, instead of holding up the sync workers"}
+		auditFlag           = cli.StringFlag{Name: "audit-log", Usage: "optional file, or s3:// URL, where to write a JSON line per key with its outcome, start and end times, attempts, worker and the S3 request ID of its last response"}
+		manifestFlag        = cli.StringFlag{Name: "manifest", Usage: "optional file where to write the manifest of the sync, with its flags, version and the checksums of its listings, at start and once done"}
+		sameAsFlag          = cli.StringFlag{Name: "same-as", Usage: "optional manifest of an earlier sync, refusing to start unless this sync repeats it, with the same version, flags and listings"}
+		probeAddrFlag       = cli.StringFlag{Name: "probe-addr", Usage: "optional address, such as :8080, where to serve the /healthz liveness and /readyz readiness probes of a sync run as a Kubernetes Job"}
+		livenessFlag        = cli.StringFlag{Name: "liveness-timeout", Value: "10m", Usage: "duration without any key read, retried or done, while the sync isn't paused, after which /healthz fails for the pod to be restarted"}
+		checkpointFlag      = cli.StringFlag{Name: "checkpoint", Usage: "optional s3://bucket/prefix in the state bucket where the state file is uploaded every checkpoint-every and once done, and from which it's restored when missing, for a restarted sync to resume"}
+		checkpointEveryFlag = cli.StringFlag{Name: "checkpoint-every", Value: "1m", Usage: "interval at which the state file is uploaded to -checkpoint"}
+		statePassphraseFlag = cli.StringFlag{Name: "state-passphrase-file", Usage: "optional file holding the passphrase the records of the -state file are encrypted with"}
+		stateKMSKeyFlag     = cli.StringFlag{Name: "state-kms-key", Usage: "optional ID, ARN or alias of the KMS key whose data key encrypts the records of the -state file, called with the credentials of the state bucket"}
 	)
 
 	return cli.Command{
@@ -163,7 +590,247 @@ func syncCommand() cli.Command {
 		Usage: "Syncs the keys from a source S3 bucket to another.",
 		Description: strings.TrimSpace(`
 Reads the keys from an s3 key listing and sync them one by one from a source
-bucket to a destination bucket.`),
+bucket to a destination bucket. Once done, a summary of the sync is printed
+to stderr: keys, bytes, time, throughput, retries and failures by error.
+
+The success and failure outputs are written to temporary files, which are
+renamed to their final name only once the sync is done. A missing output
+file thus means the sync didn't complete. In JSON, each key of the failure
+output comes with its error code and message, retries and time of failure,
+and the failure output can still be the input of another sync.
+
+The listing of a source can be many files, such as the listings of split:
+-input takes them joined with +, or as a glob, and reads them one after the
+other, or a key of each in turn with -input-interleave, without
+concatenating them first. The keys read, synced, failed and skipped of each
+file are logged once the sync is done, and kept in the -progress-file:
+
+  brigade sync -input '*_bucket.json.gz' -src src -dest dst \
+    -success synced.json.gz -failure failed.json.gz
+
+Given many destination buckets, each key is copied to all of them. Each
+destination is synced independently, with its own retries, and its own
+success, failure, state and progress files, named after the bucket: the
+keys synced to "dr" with -success synced.json.gz go to synced-dr.json.gz.
+
+Given many source buckets, each with its listing, the keys of all of them
+are merged into the destination, one source after the other. The keys of
+each source can be moved under another prefix with -map, and -collisions
+decides what to do with the keys that many sources name the same. Each
+source has its own outputs, named after the bucket like destinations.
+With -normalize-keys, the names of the keys at the destination are also
+normalized, fixing the problems the lint command reports.
+
+Keys that already exist at the destination are overwritten, unless
+-existing says to skip them, fail them, or copy them under another name.
+With -delta, the keys that are at the destination with the same ETag are
+skipped, so a full listing of the source only syncs what's missing or
+different, without listing and diffing the destination first.
+With -conditional, keys that changed since they were listed, at the source
+or at the destination, aren't copied and fail with PreconditionFailed.
+
+Directory markers, the empty keys whose name ends with a '/' that consoles
+create for folders, are copied like the other keys but counted apart, in
+the markers of the progress. -markers skip leaves them out, and -markers
+synthesize also creates the markers missing at the destination for the
+prefixes of the keys synced, for tools that browse the bucket as folders.
+
+With -rewrite, the keys aren't copied from the source but onto themselves at
+the destination, to fix their metadata after a sync: the -set-* flags
+replace their Content-Type, Cache-Control, user metadata, ACL, storage class
+or tags, and the rest of their metadata is read with a HEAD and kept. No
+data moves between buckets, and -src can be omitted. For instance:
+	brigade sync -config conf.json -dest s3://dst-bucket/ -rewrite \
+		-set-content-type text/css -input css_list.json.gz -success fixed.json.gz \
+		-failure unfixed.json.gz
+
+Copies are public-read or private, like their source. With -copy-grants,
+the full ACL of each key is read from the source and applied to its copy
+instead, with the grants to other accounts. -grant-ids maps the canonical
+IDs of the accounts at the source to those replacing them at the
+destination, a pair separated by spaces per line. Other accounts keep
+their ID.
+
+With -sample or -sample-count, only a random sample of the keys of the
+listing is synced, to rehearse a sync before the full run: to check that
+the credentials can copy the keys, or to measure the throughput.
+
+With -shard i/n, many instances of the sync, on different machines, split
+the keys of the same listing between them by a hash of their name: each
+instance syncs the keys of its shard, from 1/n to n/n, without overlap.
+
+With -verify-sample, a random sample of the synced keys is HEAD'd at the
+destination once the sync is done, and the keys that don't match their
+source are logged, failing the sync. The ETags of keys uploaded in parts
+don't tell whether their copies match, -checksum compares them by a hash of
+their content instead: md5, sha256, xxhash or crc32c. The sum a key carries
+in its user metadata, named after the hash unless given as hash:name, is
+used rather than reading the key, and so is the checksum S3 computes for
+its copy with a name like x-amz-checksum-crc32c. The others are read in
+full to hash them.
+
+With -reconcile-depth, the keys of the destination are counted once the sync
+is done, under each prefix it synced to, such as each top level "directory"
+with a depth of 1, and a prefix holding fewer keys than were synced or found
+there fails the sync, and is flagged in its summary. The counts list the
+prefixes, a page of 1000 keys a request, so -reconcile-prefixes caps how
+many are counted, picked at random. Keys the sync didn't write don't count
+against it.
+
+With -dedupe-report, the keys synced are tracked by their size and ETag, and
+once the sync is done the sets of keys holding the same content at each
+destination are written to the report, the most bytes reclaimable by keeping
+a single key of a set first, along with totals, for planning the
+deduplication of a bucket consolidating many sources. The ETag of a key
+uploaded in parts depends on the size of its parts, so the same content
+uploaded in parts of other sizes isn't found. The name of each key tracked
+is held in memory until the sync is done, so -dedupe-min-size leaves out the
+small ones.
+
+With -validate-input, the first -validate-sample keys of each listing are
+decoded before the sync starts, to check that they're keys of the format
+the listing is detected to be in, with a name, a size, an ETag and a last
+modified date. Their number is extrapolated to the whole listing, and the
+sync is refused if any has a problem, rather than spending hours on a
+listing that turns out to be garbage.
+
+With -preview, the first keys of each listing are printed with the names
+they'd be synced under, after -map and -normalize-keys, along with the
+counts of the keys by their prefixes, -preview-depth parts deep, at the
+source and at the destination, and the command exits without syncing. The
+whole listing is read for the counts, so that a mapping that sends a part of
+the keys to the wrong place shows, and a mapping that moves none of the
+keys fails the preview.
+
+Lines of a JSON listing longer than -max-line-size, such as those of a
+corrupt file without newlines, are read through without being kept in
+memory. They and the lines that aren't keys fail the sync, unless
+-skip-malformed skips them, up to -max-malformed of them.
+
+With -exec-per-key, a command is run for each key once it's synced, such as
+a cache purge or a notification. Commands run in the background, a few at
+a time, and are retried when they fail. Keys whose command still fails are
+logged, and fail the sync once it's done, but stay synced.
+
+When the outputs can't keep up with the sync, such as on a slow disk or an
+S3 output, the sync workers wait for them, and the time they waited is
+logged and reported in the progress. With -spill-dir, the keys spill to
+temporary files in that directory instead, and are written to the outputs
+once the sync is done.
+
+With -queue-dir, the decoded keys are queued to a file in that directory on
+their way to the sync workers, so the listing is read to the end however far
+behind the workers are. Once the whole listing is queued, a sync restarted
+with the same -queue-dir drains the queue without reading the listing again,
+and -state skips the keys it had already synced. With many sources or
+destinations, each gets its own subdirectory, named after its bucket.
+
+Listings are sorted, so the keys under a prefix are synced together, and
+S3 throttles the requests to a prefix with SlowDown errors when there are
+too many at once. With -interleave, that many keys of the listing are held
+and handed to the sync workers round-robin over their top-level prefixes.
+
+With -priority, the keys of critical prefixes are synced ahead of the bulk
+of the listing. Up to -priority-window keys of the listing are held in a
+queue per prefix, and the sync workers always get a key of the most urgent
+prefix held, the keys under none of the prefixes coming last. With
+-interleave too, the keys of each priority are interleaved.
+
+Each key is retried up to 50 times. With -retry-budget, the retries of all
+the keys also come out of a shared budget, which each key synced refills a
+little: when the destination is down, the budget is spent after a few keys
+and the sync fails the keys without retries, pauses, or aborts, as
+-retry-budget-action says, rather than retrying every key.
+
+S3 throttles the writes to a prefix past a few thousand a second. With
+-prefix-rate, the writes to each prefix of the destination, -prefix-depth
+parts of the keys deep, are capped to that rate, and -prefix-rates caps the
+writes to given prefixes, so that the sync stays below the rate of S3 rather
+than finding it through SlowDown errors. The calls that waited for the rate
+of their prefix are reported in the progress.
+
+With -schedule, the sync calls are capped by the time of day, so that a sync
+that runs for days slows down during business hours on its own. Each window
+of the schedule, like '06:00-22:00=rate:50,calls:20;22:00-06:00=rate:500',
+caps the calls a second, the calls in flight, or both, and the calls are
+uncapped outside of the windows. The windows are in the time zone of
+-schedule-tz, and the caps follow them within a few seconds. The calls that
+waited for the schedule are reported as brigade.sync.scheduleWaits.
+
+Each S3 request has a connection of its own, unless -prewarm or -dns-refresh
+is set, in which case the requests share connections that are kept alive.
+With -prewarm, that many connections to each endpoint are opened before the
+sync starts, a few at a time, rather than by all the sync workers at once.
+With -dns-refresh, the addresses of the endpoints are cached and looked up
+again at that interval, give or take, and are kept when the lookup fails,
+rather than failing the requests of the sync.
+
+With -hedge-quantile, a sync call that takes longer than that quantile of
+the latency of the calls so far is hedged with a second, identical call,
+and the first of the two to succeed wins, which cuts the long tail of the
+occasional slow S3 response. The call that lost goes on in the background,
+its outcome ignored. At most -hedge-max-rate of the calls are hedged.
+
+With -get-put, the data of the keys goes through the host of the sync. On a
+host that shares its network with production traffic, -max-bandwidth caps
+the bytes a second that all the copies read from the source together, and
+-max-worker-bandwidth those of each copy. The time the copies waited for
+the bandwidth is reported as brigade.sync.secondsThrottled.
+
+With -park-delay, the keys that exhausted their retries are parked instead
+of failing, and retried once more at the end of the sync, at least that
+long after they were parked, since outages of S3 are often over by then.
+
+With -retry-concurrency, the keys whose first sync call failed are retried
+by that many workers of their own, while the -concurrency workers go on with
+the fresh keys: a burst of failing keys in a prefix otherwise holds the sync
+workers sleeping between their retries, and the keys of the other prefixes,
+which would sync right away, wait for them. The keys being retried are
+reported as retrying in the progress.
+
+With -anomaly-every, the p95 latency and failure rate of the sync calls over
+each window are compared to a baseline of the windows before it, and the
+sync warns when they deviate from it, such as when S3 has an incident or
+throttles the sync, long before the sync times out. The warnings, and the
+recoveries that follow them, are also POSTed to -anomaly-webhook.
+
+With -slow-key-after, the keys that are still syncing after that long,
+retries included, such as huge keys or keys whose connection is wedged,
+are warned about, and the -slow-key-top slowest keys are listed in the
+-progress-file, which the status command reports on.
+
+While it runs, the sync serves a dashboard on http://127.0.0.1:6060/dashboard
+with its counters, throughput, latencies and last failures, and buttons to
+pause and resume it. The 'watch' command follows the same progress from a
+terminal.
+
+With -manifest, the sync writes a JSON manifest of its version and commit,
+the value of each of its flags, defaults included, and the size and sha256
+of each of its listings when it starts, and again with its outcome and
+summary once done. A failed sync can be repeated identically with the flags
+of its manifest and -same-as pointing to it, which refuses to start if
+anything but the outputs of the sync differs.
+
+A sync that looks stalled can be sent SIGQUIT: it dumps its metrics, the
+progress of each bucket it syncs, and its goroutines grouped by stack to
+stderr, and carries on.
+
+To run as a restartable Kubernetes Job, -probe-addr serves a liveness probe
+on /healthz, which fails once a sync hasn't read, retried or done any key
+for -liveness-timeout while not paused, and a readiness probe on /readyz,
+which succeeds once the syncs started. Set the timeout above -park-delay.
+With -checkpoint, the -state file is uploaded under that prefix of the
+state bucket every -checkpoint-every, once done, and on SIGTERM, which
+also completes the outputs. A restarted sync whose state file is missing,
+such as in a new pod, downloads it from there and skips the keys it had
+already synced.
+
+When the names of the keys are themselves sensitive, the records of the
+-state file, and of its checkpoints, are encrypted with the passphrase in
+-state-passphrase-file, or with a data key generated by the KMS key of
+-state-kms-key, which the state file keeps encrypted by KMS. A state file
+is encrypted from its creation, and is then only opened with the same
+passphrase, or by a config whose state bucket can decrypt its data key.`),
 		Flags: []cli.Flag{
 			configFlag,
 			inputFlag,
@@ -172,6 +839,121 @@ bucket to a destination bucket.`),
 			srcFlag,
 			dstFlag,
 			concurrencyFlag,
+			shardsFlag,
+			fsyncFlag,
+			stateFlag,
+			cloudwatchFlag,
+			cloudwatchEveryFlag,
+			latencyReportFlag,
+			latencyBackendFlag,
+			injectFaultsFlag,
+			getPutFlag,
+			bandwidthFlag,
+			workerBandwidthFlag,
+			lockModeFlag,
+			lockUntilFlag,
+			legalHoldFlag,
+			copyGrantsFlag,
+			grantIDsFlag,
+			redirectsFlag,
+			mtimeFlag,
+			rewriteFlag,
+			setTypeFlag,
+			setCacheFlag,
+			setDispositionFlag,
+			setEncodingFlag,
+			setMetaFlag,
+			setACLFlag,
+			setClassFlag,
+			setTagsFlag,
+			formatFlag,
+			failureFormatFlag,
+			breakerWindowFlag,
+			breakerRateFlag,
+			breakerCodeRateFlag,
+			breakerCoolDownFlag,
+			budgetFlag,
+			budgetRefillFlag,
+			budgetActionFlag,
+			budgetCoolDownFlag,
+			hedgeFlag,
+			hedgeMinCallsFlag,
+			hedgeMaxRateFlag,
+			parkDelayFlag,
+			parkMaxFlag,
+			retryConcFlag,
+			maxFailuresFlag,
+			maxFailureRateFlag,
+			progressFlag,
+			progressEveryFlag,
+			rateEveryFlag,
+			logProgressFlag,
+			eventsFlag,
+			timeoutFlag,
+			stallAfterFlag,
+			stallActionFlag,
+			slowKeyAfterFlag,
+			slowKeyTopFlag,
+			anomalyEveryFlag,
+			anomalyBaseFlag,
+			anomalyLatencyFlag,
+			anomalyFailureFlag,
+			anomalyMinCallsFlag,
+			anomalyWebhookFlag,
+			mapFlag,
+			normalizeFlag,
+			collisionsFlag,
+			existingFlag,
+			existingSuffixFlag,
+			existingListFlag,
+			deltaFlag,
+			verifySampleFlag,
+			checksumFlag,
+			reconcileFlag,
+			reconcileMaxFlag,
+			dedupeFlag,
+			dedupeMinSizeFlag,
+			validateInputFlag,
+			validateSampleFlag,
+			previewFlag,
+			previewDepthFlag,
+			maxLineFlag,
+			skipMalformedFlag,
+			maxMalformedFlag,
+			conditionalFlag,
+			execFlag,
+			execParaFlag,
+			execRetryFlag,
+			execTimeoutFlag,
+			auditFlag,
+			queueDirFlag,
+			spillDirFlag,
+			maxDecodersFlag,
+			prewarmFlag,
+			dnsRefreshFlag,
+			prefixRateFlag,
+			prefixRatesFlag,
+			prefixDepthFlag,
+			scheduleFlag,
+			scheduleTZFlag,
+			priorityFlag,
+			priorityWindowFlag,
+			interleaveFlag,
+			inputInterleaveFlag,
+			orderedFlag,
+			markersFlag,
+			sampleFlag,
+			sampleCountFlag,
+			sampleSeedFlag,
+			instanceShardFlag,
+			manifestFlag,
+			sameAsFlag,
+			probeAddrFlag,
+			livenessFlag,
+			checkpointFlag,
+			checkpointEveryFlag,
+			statePassphraseFlag,
+			stateKMSKeyFlag,
 		},
 		Action: func(c *cli.Context) {
 
@@ -179,97 +961,1393 @@ bucket to a destination bucket.`),
 			successFilename := mustString(c, successFlag)
 			failureFilename := mustString(c, failureFlag)
 			cfg := mustConfig(c, configFlag)
-			src := mustURL(c, srcFlag)
-			dest := mustURL(c, dstFlag)
+			dests := mustURLs(c, dstFlag)
+			// the source isn't read by a rewrite
+			srcs := dests[:1]
+			if !c.Bool(rewriteFlag.Name) || c.String(srcFlag.Name) != "" {
+				srcs = mustURLs(c, srcFlag)
+			}
 			conc := c.Int(concurrencyFlag.Name)
+			shards := c.Int(shardsFlag.Name)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+			rateEvery := mustDuration(c, rateEveryFlag)
+			var (
+				checkpointBkt    *s3.Bucket
+				checkpointPrefix string
+			)
+			checkpointEvery := mustDuration(c, checkpointEveryFlag)
+			if spec := c.String(checkpointFlag.Name); spec != "" {
+				bucket, prefix, ok := s3file.Parse(spec)
+				switch {
+				case !ok:
+					logrus.WithField("checkpoint", spec).Error("checkpoint must be an s3://bucket/prefix URL")
+					exitStatus = 1
+					return
+				case c.String(stateFlag.Name) == "":
+					logrus.Error("-checkpoint needs a -state file to checkpoint")
+					exitStatus = 1
+					return
+				case checkpointEvery <= 0:
+					logrus.WithField("checkpoint_every", checkpointEvery).Error("invalid checkpoint interval, must be positive")
+					exitStatus = 1
+					return
+				}
+				checkpointBkt = setupS3Timeouts(cfg.State.S3()).Bucket(bucket)
+				checkpointPrefix = prefix
+			}
+			stateEnc, encErr := stateEncryption(cfg, c.String(statePassphraseFlag.Name), c.String(stateKMSKeyFlag.Name))
+			switch {
+			case encErr != nil:
+				logrus.WithField("error", encErr).Error("invalid encryption of the state file")
+				exitStatus = 1
+				return
+			case stateEnc != nil && c.String(stateFlag.Name) == "":
+				logrus.Error("only a -state file can be encrypted")
+				exitStatus = 1
+				return
+			}
+			if addr := c.String(probeAddrFlag.Name); addr != "" {
+				stop, err := serveProbes(addr, mustDuration(c, livenessFlag))
+				if err != nil {
+					logrus.WithField("error", err).Error("couldn't serve probes")
+					exitStatus = 1
+					return
+				}
+				defer stop()
+			}
+			if shards < 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("shards", shards).Error("need at least 1 output shard")
+				exitStatus = 1
+				return
+			}
+			inputFilenames := strings.Split(inputFilename, ",")
+			switch {
+			case len(srcs) > 1 && len(dests) > 1:
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("can't sync from many sources to many destinations at once")
+				exitStatus = 1
+				return
+			case len(inputFilenames) != len(srcs):
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithFields(logrus.Fields{
+					"sources":  len(srcs),
+					"listings": len(inputFilenames),
+				}).Error("need a listing per source")
+				exitStatus = 1
+				return
+			}
+			inputFiles := make([][]string, len(inputFilenames))
+			var allInputFiles []string
+			for i, name := range inputFilenames {
+				files, err := expandInputFiles(name)
+				if err != nil {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("error", err).Error("invalid listing")
+					exitStatus = 1
+					return
+				}
+				inputFiles[i] = files
+				allInputFiles = append(allInputFiles, files...)
+			}
+			mappings := make([]sync.Mapping, len(srcs))
+			if spec := c.String(mapFlag.Name); spec != "" {
+				specs := strings.Split(spec, ",")
+				if len(specs) != len(srcs) {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("mappings", spec).Error("need a mapping per source")
+					exitStatus = 1
+					return
+				}
+				for i, spec := range specs {
+					m, err := sync.ParseMapping(spec)
+					if err != nil {
+						logrus.WithField("error", err).Error("invalid mapping")
+						exitStatus = 1
+						return
+					}
+					mappings[i] = m
+				}
+			}
+			for i := range mappings {
+				mappings[i].Normalize = c.Bool(normalizeFlag.Name)
+			}
 
-			srcS3 := setupS3Timeouts(s3.New(cfg.Source.AWS()))
-			srcBkt := srcS3.Bucket(src.Host)
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
 
-			destS3 := setupS3Timeouts(s3.New(cfg.Destination.AWS()))
-			destBkt := destS3.Bucket(dest.Host)
+			getPut := c.Bool(getPutFlag.Name)
+			destS3 := setupS3Timeouts(cfg.Destination.S3())
+			switch {
+			case getPut:
+				destS3 = setupS3Timeouts(cfg.Destination.UploadS3())
+			case cfg.Destination.Accelerate:
+				logrus.Warn("copies can't be accelerated, use -get-put to accelerate uploads to the destination")
+			}
 
-			listfile, err := os.Open(inputFilename)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error":    err,
-					"filename": inputFilename,
-				}).Error("couldn't open listing file")
-				cli.ShowCommandHelp(c, c.Command.Name)
+			prewarm := c.Int(prewarmFlag.Name)
+			if prewarm < 0 {
+				logrus.WithField("prewarm", prewarm).Error("invalid number of connections to prewarm, must be positive")
+				exitStatus = 1
 				return
 			}
-			defer func() { logIfErr(listfile.Close()) }()
+			var shared *http.Transport
+			if prewarm > 0 || c.String(dnsRefreshFlag.Name) != "" {
+				opts := transport.Options{
+					ConnectTimeout:      srcS3.ConnectTimeout,
+					ReadTimeout:         srcS3.ReadTimeout,
+					MaxIdleConnsPerHost: srcS3.MaxIdleConnsPerHost,
+				}
+				if c.String(dnsRefreshFlag.Name) != "" {
+					resolver, err := transport.NewResolver(mustDuration(c, dnsRefreshFlag), nil)
+					if err != nil {
+						logrus.WithField("error", err).Error("invalid DNS refresh")
+						exitStatus = 1
+						return
+					}
+					opts.Resolver = resolver
+				}
+				shared = transport.New(opts)
+				srcS3.Transport = shared
+				destS3.Transport = shared
+			}
+			// endpoints already prewarmed, by host
+			prewarmed := make(map[string]bool)
+			prewarmBucket := func(bkt *s3.Bucket) *s3.Bucket {
+				if prewarm == 0 {
+					return bkt
+				}
+				endpoint := bkt.URL("/")
+				u, err := url.Parse(endpoint)
+				if err != nil || prewarmed[u.Host] {
+					return bkt
+				}
+				prewarmed[u.Host] = true
+				if _, err := transport.Prewarm(shared, endpoint, prewarm, prewarmParallel); err != nil {
+					logrus.WithField("error", err).Warn("couldn't prewarm connections")
+				}
+				return bkt
+			}
 
-			createOutput := func(filename string) (io.Writer, func() error, error) {
-				if filename == "" {
-					file, err := os.Open(os.DevNull)
-					closer := func() error { return nil }
-					return file, closer, err
+			var sample *sync.Sample
+			if spec, count := c.String(sampleFlag.Name), c.Int(sampleCountFlag.Name); spec != "" || count != 0 {
+				sample = &sync.Sample{Count: count, Seed: int64(c.Int(sampleSeedFlag.Name))}
+				if spec != "" {
+					var err error
+					if sample.Fraction, err = sync.ParseSample(spec); err != nil {
+						logrus.WithField("error", err).Error("invalid sample")
+						exitStatus = 1
+						return
+					}
+				}
+				if err := sample.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid sample")
+					exitStatus = 1
+					return
+				}
+				if sample.Seed == 0 {
+					sample.Seed = time.Now().UnixNano()
 				}
+				logrus.WithFields(logrus.Fields{
+					"fraction": sample.Fraction,
+					"count":    sample.Count,
+					"seed":     sample.Seed,
+				}).Warn("only syncing a random sample of the keys")
+			}
 
-				file, err := os.Create(filename)
+			var instanceShard *sync.InstanceShard
+			if spec := c.String(instanceShardFlag.Name); spec != "" {
+				sh, err := sync.ParseInstanceShard(spec)
 				if err != nil {
-					return nil, nil, err
+					logrus.WithField("error", err).Error("invalid shard")
+					exitStatus = 1
+					return
+				}
+				logrus.WithField("shard", spec).Info("only syncing the keys of the shard")
+				instanceShard = &sh
+			}
+
+			// each source of a fan-in, or destination of a fan-out, gets its
+			// own outputs and state, named after its bucket
+			legName := func(filename, bucket string) string {
+				if len(srcs) == 1 && len(dests) == 1 || filename == "" || filename == os.DevNull || isQueueURL(filename) {
+					return filename
+				}
+				return sync.BucketFileName(filename, bucket)
+			}
+
+			createShards := func(filename string) ([]io.Writer, func() error, error) {
+				// the shards share a queue, its output being safe to use from
+				// many encoders
+				if shards == 1 || filename == "" || filename == os.DevNull || isQueueURL(filename) {
+					w, closer, err := createOutput(cfg, filename, fsyncEvery)
+					return []io.Writer{w}, closer, err
 				}
-				gzFile := gzip.NewWriter(file)
-				closer := func() error {
-					if err := gzFile.Close(); err != nil {
+				var writers []io.Writer
+				var closers []func() error
+				closeAll := func() error {
+					var cerr error
+					for _, closer := range closers {
+						if err := closer(); err != nil && cerr == nil {
+							cerr = err
+						}
+					}
+					return cerr
+				}
+				for i := 0; i < shards; i++ {
+					w, closer, err := createOutput(cfg, sync.ShardName(filename, i), fsyncEvery)
+					if err != nil {
+						logIfErr(closeAll())
+						return nil, nil, err
+					}
+					writers = append(writers, w)
+					closers = append(closers, closer)
+				}
+				return writers, closeAll, nil
+			}
+
+			// the bytes of the listings read so far tell how far along the sync is
+			progressFilename := c.String(progressFlag.Name)
+			type listingInput struct {
+				rd    io.Reader
+				count *sync.CountingReader
+				size  int64
+				// files of a listing of many files
+				files *sync.Inputs
+			}
+			if c.Bool(validateInputFlag.Name) {
+				for _, name := range allInputFiles {
+					if !validateInput(cfg, name, int64(c.Int(validateSampleFlag.Name))) {
+						exitStatus = 1
+						return
+					}
+				}
+			}
+			if samples := c.Int(previewFlag.Name); samples > 0 {
+				for i, files := range inputFiles {
+					for _, name := range files {
+						if !previewMapping(cfg, name, mappings[i], samples, c.Int(previewDepthFlag.Name)) {
+							exitStatus = 1
+						}
+					}
+				}
+				return
+			}
+			var inputs []listingInput
+			for i, name := range inputFilenames {
+				var in listingInput
+				var files []sync.InputFile
+				for _, filename := range inputFiles[i] {
+					listfile, inputSize, err := openListing(cfg, filename)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error":    err,
+							"filename": filename,
+						}).Error("couldn't open listing file")
+						cli.ShowCommandHelp(c, c.Command.Name)
+						exitStatus = 1
+						return
+					}
+					defer func() { logIfErr(listfile.Close()) }()
+
+					if inputSize < 0 || in.size < 0 {
+						in.size = -1
+					} else {
+						in.size += inputSize
+					}
+					var input io.Reader = listfile
+					if progressFilename != "" {
+						if in.count == nil {
+							in.count = sync.NewCountingReader(listfile)
+							input = in.count
+						} else {
+							input = in.count.Counting(listfile)
+						}
+					}
+					inputGzRd, err := gzip.NewReader(input)
+					if err != nil {
 						logrus.WithFields(logrus.Fields{
 							"error":    err,
 							"filename": filename,
-						}).Error("closing gzip writer")
+						}).Error("listing file is not a gzip file")
+						cli.ShowCommandHelp(c, c.Command.Name)
+						exitStatus = 1
+						return
+					}
+					defer func() { logIfErr(inputGzRd.Close()) }()
+					files = append(files, sync.InputFile{Name: filename, R: inputGzRd})
+				}
+				in.rd = files[0].R
+				if len(files) > 1 {
+					var err error
+					if in.files, err = sync.NewInputs(files, c.Bool(inputInterleaveFlag.Name)); err != nil {
+						logrus.WithField("error", err).Error("couldn't read listing files")
+						cli.ShowCommandHelp(c, c.Command.Name)
+						exitStatus = 1
+						return
+					}
+					in.rd = in.files
+				}
+				var err error
+				if instanceShard != nil {
+					// sampled after sharding, so that the shards of many
+					// instances add up to the sample
+					if in.rd, err = sync.ShardListing(in.rd, *instanceShard); err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error":    err,
+							"filename": name,
+						}).Error("couldn't shard listing file")
+						exitStatus = 1
+						return
+					}
+				}
+				if sample != nil {
+					if in.rd, err = sync.SampleListing(in.rd, *sample); err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error":    err,
+							"filename": name,
+						}).Error("couldn't sample listing file")
+						exitStatus = 1
+						return
 					}
-					return file.Close()
 				}
-				return gzFile, closer, nil
+				inputs = append(inputs, in)
 			}
 
-			successFile, sucCloser, err := createOutput(successFilename)
-			if err != nil {
-				logrus.WithField("error", err).Error("couldn't create success key file")
+			manifestFilename := c.String(manifestFlag.Name)
+			var run *manifest.Manifest
+			if manifestFilename != "" || c.String(sameAsFlag.Name) != "" {
+				flags := flagValues(c)
+				if sample != nil {
+					// the seed picked at random, to pick the same keys again
+					flags[sampleSeedFlag.Name] = strconv.FormatInt(sample.Seed, 10)
+				}
+				run = &manifest.Manifest{
+					RunID:     runID,
+					Command:   c.Command.Name,
+					Version:   build.Version,
+					Commit:    build.Commit,
+					GoVersion: build.GoVersion,
+					Args:      os.Args,
+					Flags:     flags,
+					Started:   time.Now(),
+					Status:    manifest.Running,
+				}
+				run.Host, _ = os.Hostname()
+				for _, name := range allInputFiles {
+					in, err := checksumListing(cfg, name)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error":    err,
+							"filename": name,
+						}).Error("couldn't checksum listing file")
+						exitStatus = 1
+						return
+					}
+					run.Inputs = append(run.Inputs, in)
+				}
 			}
-			defer func() { logIfErr(sucCloser()) }()
-
-			failureFile, failCloser, err := createOutput(failureFilename)
-			if err != nil {
-				logrus.WithField("error", err).Error("couldn't create failure key file")
+			if prevFilename := c.String(sameAsFlag.Name); prevFilename != "" {
+				prev, err := manifest.Read(prevFilename)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": prevFilename,
+					}).Error("couldn't read manifest of the earlier sync")
+					exitStatus = 1
+					return
+				}
+				// where the outputs go doesn't change what's synced
+				diffs := manifest.Diff(prev, run,
+					manifestFlag.Name, sameAsFlag.Name, successFlag.Name, failureFlag.Name,
+					progressFlag.Name, latencyReportFlag.Name, auditFlag.Name)
+				for _, diff := range diffs {
+					logrus.WithField("difference", diff).Error("sync differs from the earlier one")
+				}
+				if len(diffs) > 0 {
+					exitStatus = 1
+					return
+				}
+				logrus.WithField("manifest", prevFilename).Info("repeating an earlier sync")
 			}
-			defer func() { logIfErr(failCloser()) }()
-
-			inputGzRd, err := gzip.NewReader(listfile)
-			if err != nil {
-				logrus.WithField("error", err).Error("listing file is not a gzip file")
-				cli.ShowCommandHelp(c, c.Command.Name)
-				return
+			if manifestFilename != "" {
+				if err := manifest.Write(manifestFilename, run); err != nil {
+					logrus.WithField("error", err).Error("couldn't write manifest")
+					exitStatus = 1
+					return
+				}
 			}
-			defer func() { logIfErr(inputGzRd.Close()) }()
 
 			logrus.Info("starting command ", c.Command.Name)
 
-			syncTask, err := sync.NewSyncTask(srcBkt, destBkt)
-			if err != nil {
-				logrus.WithField("error", err).Error("failed to prepare sync task")
+			retention := sync.Retention{
+				Mode:      strings.ToUpper(c.String(lockModeFlag.Name)),
+				LegalHold: c.Bool(legalHoldFlag.Name),
+			}
+			if until := c.String(lockUntilFlag.Name); until != "" {
+				var err error
+				retention.Until, err = time.Parse(time.RFC3339, until)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid retention date")
+					exitStatus = 1
+					return
+				}
+			}
+			if err := retention.Validate(); err != nil {
+				logrus.WithField("error", err).Error("invalid retention")
+				exitStatus = 1
 				return
 			}
-			syncTask.SyncPara = conc
-			err = syncTask.Start(inputGzRd, successFile, failureFile)
-			if err != nil {
-				logrus.WithField("error", err).Error("failed to sync")
+			copier := sync.PutCopy
+			switch {
+			case getPut && retention != (sync.Retention{}):
+				logrus.Error("retention can only be set on copies, not with -get-put")
+				exitStatus = 1
+				return
+			case getPut:
+				copier = sync.GetPut
+			case retention != (sync.Retention{}):
+				copier = sync.LockedCopy(sync.FixedRetention(retention))
 			}
-		},
-	}
-}
-
-func sliceCommand() cli.Command {
-	var (
-		nFlag        = cli.IntFlag{Name: "n", Value: 0, Usage: "number of slices to split the S3 key listing over"}
-		filenameFlag = cli.StringFlag{Name: "src", Value: "", Usage: "file from which to read the S3 key listing"}
-	)
-
-	return cli.Command{
-		Name:  "slice",
-		Usage: "Slice an S3 key listing into multiple sub-listings.",
-		Description: strings.TrimSpace(`
+			var bandwidth sync.Bandwidth
+			for _, f := range []struct {
+				flag cli.StringFlag
+				rate *int64
+			}{{bandwidthFlag, &bandwidth.Total}, {workerBandwidthFlag, &bandwidth.PerWorker}} {
+				if spec := c.String(f.flag.Name); spec != "" {
+					n, err := humanize.ParseBytes(spec)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error": err,
+							"flag":  f.flag.Name,
+						}).Error("invalid bandwidth")
+						exitStatus = 1
+						return
+					}
+					*f.rate = int64(n)
+				}
+			}
+			if bandwidth != (sync.Bandwidth{}) {
+				if !getPut {
+					logrus.Error("only the copies of -get-put go through this host and can have their bandwidth capped")
+					exitStatus = 1
+					return
+				}
+				if err := bandwidth.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid bandwidth")
+					exitStatus = 1
+					return
+				}
+				copier = sync.ThrottledGetPut(bandwidth)
+			}
+			rewrite := sync.Rewrite{
+				ContentType:        c.String(setTypeFlag.Name),
+				CacheControl:       c.String(setCacheFlag.Name),
+				ContentDisposition: c.String(setDispositionFlag.Name),
+				ContentEncoding:    c.String(setEncodingFlag.Name),
+				ACL:                s3.ACL(c.String(setACLFlag.Name)),
+				StorageClass:       strings.ToUpper(c.String(setClassFlag.Name)),
+				Tagging:            c.String(setTagsFlag.Name),
+			}
+			if spec := c.String(setMetaFlag.Name); spec != "" {
+				meta, err := url.ParseQuery(spec)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid metadata to rewrite")
+					exitStatus = 1
+					return
+				}
+				rewrite.Meta = meta
+			}
+			rewriting := c.Bool(rewriteFlag.Name)
+			switch {
+			case !rewriting && rewrite.Validate() == nil:
+				logrus.Error("the metadata, ACL, storage class or tags of the keys can only be set with -rewrite")
+				exitStatus = 1
+				return
+			case !rewriting:
+			case getPut || retention != (sync.Retention{}) || c.Bool(mtimeFlag.Name):
+				logrus.Error("rewrites are copies in place, not with -get-put, a retention or -preserve-mtime")
+				exitStatus = 1
+				return
+			default:
+				if err := rewrite.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid rewrite")
+					exitStatus = 1
+					return
+				}
+				copier = sync.RewriteCopy(rewrite)
+			}
+			if c.Bool(redirectsFlag.Name) {
+				sync.RedirectForKey = sync.S3RedirectForKey
+			}
+			sync.PreserveMTime = c.Bool(mtimeFlag.Name)
+			var breaker *sync.Breaker
+			if window := c.Int(breakerWindowFlag.Name); window > 0 {
+				breaker = &sync.Breaker{
+					Window:         window,
+					MaxFailureRate: c.Float64(breakerRateFlag.Name),
+					MaxCodeRate:    c.Float64(breakerCodeRateFlag.Name),
+					CoolDown:       mustDuration(c, breakerCoolDownFlag),
+				}
+				if err := breaker.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid circuit breaker")
+					exitStatus = 1
+					return
+				}
+			}
+			var budget *sync.RetryBudget
+			if tokens := c.Int(budgetFlag.Name); tokens > 0 {
+				budget = &sync.RetryBudget{
+					Tokens:   tokens,
+					Refill:   c.Float64(budgetRefillFlag.Name),
+					Action:   c.String(budgetActionFlag.Name),
+					CoolDown: mustDuration(c, budgetCoolDownFlag),
+				}
+				if err := budget.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid retry budget")
+					exitStatus = 1
+					return
+				}
+			}
+			var hedging *sync.Hedging
+			if quantile := c.Float64(hedgeFlag.Name); quantile > 0 {
+				hedging = &sync.Hedging{
+					Quantile: quantile,
+					MinCalls: int64(c.Int(hedgeMinCallsFlag.Name)),
+					MaxRate:  c.Float64(hedgeMaxRateFlag.Name),
+				}
+				if err := hedging.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid hedging")
+					exitStatus = 1
+					return
+				}
+			}
+			var prefixRates *sync.PrefixRates
+			if rate, spec := c.Float64(prefixRateFlag.Name), c.String(prefixRatesFlag.Name); rate != 0 || spec != "" {
+				prefixRates = &sync.PrefixRates{Default: rate, Depth: c.Int(prefixDepthFlag.Name)}
+				if spec != "" {
+					var err error
+					if prefixRates.Rates, err = sync.ParsePrefixRates(spec); err != nil {
+						logrus.WithField("error", err).Error("invalid prefix rates")
+						exitStatus = 1
+						return
+					}
+				}
+				if err := prefixRates.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid prefix rates")
+					exitStatus = 1
+					return
+				}
+			}
+			var schedule *sync.Schedule
+			if spec := c.String(scheduleFlag.Name); spec != "" {
+				windows, err := sync.ParseSchedule(spec)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid schedule")
+					exitStatus = 1
+					return
+				}
+				schedule = &sync.Schedule{Windows: windows, Location: time.Local}
+				if tz := c.String(scheduleTZFlag.Name); tz != "" {
+					if schedule.Location, err = time.LoadLocation(tz); err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error":       err,
+							"schedule-tz": tz,
+						}).Error("invalid time zone of schedule")
+						exitStatus = 1
+						return
+					}
+				}
+				if err := schedule.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid schedule")
+					exitStatus = 1
+					return
+				}
+			}
+			var priorities *sync.Priorities
+			if prefixes := c.String(priorityFlag.Name); prefixes != "" {
+				priorities = &sync.Priorities{
+					Prefixes: strings.Split(prefixes, ","),
+					Window:   c.Int(priorityWindowFlag.Name),
+				}
+				if err := priorities.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid priorities")
+					exitStatus = 1
+					return
+				}
+			}
+			var parking *sync.Parking
+			if c.String(parkDelayFlag.Name) != "" {
+				parking = &sync.Parking{
+					Delay: mustDuration(c, parkDelayFlag),
+					Max:   c.Int(parkMaxFlag.Name),
+				}
+				if err := parking.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid parking")
+					exitStatus = 1
+					return
+				}
+			}
+			sync.Latency = monitor.NewRecorderWith(time.Minute, mustDistribution(c, latencyBackendFlag))
+			var watchdog *sync.Watchdog
+			if c.String(stallAfterFlag.Name) != "" {
+				watchdog = &sync.Watchdog{
+					After:  mustDuration(c, stallAfterFlag),
+					Action: c.String(stallActionFlag.Name),
+				}
+				if err := watchdog.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid stall detection")
+					exitStatus = 1
+					return
+				}
+			}
+			var anomalies *sync.Anomalies
+			if c.String(anomalyEveryFlag.Name) != "" {
+				anomalies = &sync.Anomalies{
+					Every:         mustDuration(c, anomalyEveryFlag),
+					Baseline:      c.Int(anomalyBaseFlag.Name),
+					LatencyFactor: c.Float64(anomalyLatencyFlag.Name),
+					FailureRate:   c.Float64(anomalyFailureFlag.Name),
+					MinCalls:      int64(c.Int(anomalyMinCallsFlag.Name)),
+					Webhook:       c.String(anomalyWebhookFlag.Name),
+				}
+				if err := anomalies.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid anomaly detection")
+					exitStatus = 1
+					return
+				}
+			}
+			var slowKeys *sync.SlowKeys
+			if c.String(slowKeyAfterFlag.Name) != "" {
+				slowKeys = &sync.SlowKeys{
+					After: mustDuration(c, slowKeyAfterFlag),
+					Top:   c.Int(slowKeyTopFlag.Name),
+				}
+				if err := slowKeys.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid slow keys")
+					exitStatus = 1
+					return
+				}
+			}
+
+			var faults *sync.Faults
+			if spec := c.String(injectFaultsFlag.Name); spec != "" {
+				f, err := sync.ParseFaults(spec)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid faults to inject")
+					exitStatus = 1
+					return
+				}
+				logrus.WithField("faults", spec).Warn("injecting faults in sync calls")
+				faults = &f
+			}
+
+			existing := &sync.Existing{
+				Policy:        c.String(existingFlag.Name),
+				Suffix:        c.String(existingSuffixFlag.Name),
+				SkipUnchanged: c.Bool(deltaFlag.Name),
+			}
+			if err := existing.Validate(); err != nil {
+				logrus.WithField("error", err).Error("invalid policy for existing keys")
+				exitStatus = 1
+				return
+			}
+			if name := c.String(existingListFlag.Name); name != "" {
+				if len(dests) > 1 {
+					logrus.Error("can't check the keys of many destinations against a single listing")
+					exitStatus = 1
+					return
+				}
+				keys, err := readETags(cfg, name)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": name,
+					}).Error("couldn't read listing of the destination")
+					exitStatus = 1
+					return
+				}
+				logrus.WithField("key_count", len(keys)).Info("read the keys existing at the destination")
+				existing.Keys = keys
+			}
+			conditional := c.Bool(conditionalFlag.Name)
+			if conditional {
+				if getPut || retention != (sync.Retention{}) || rewriting {
+					logrus.Error("conditional copies can't be made with -get-put, a retention or -rewrite")
+					exitStatus = 1
+					return
+				}
+				copier = sync.ConditionalCopy(existing.Keys)
+			}
+			if c.Bool(copyGrantsFlag.Name) {
+				var grants sync.Grants
+				if name := c.String(grantIDsFlag.Name); name != "" {
+					ids, err := readGrantIDs(name)
+					if err != nil {
+						logrus.WithFields(logrus.Fields{
+							"error":    err,
+							"filename": name,
+						}).Error("couldn't read the IDs of the grantees")
+						exitStatus = 1
+						return
+					}
+					grants.IDs = ids
+				}
+				// the grants replace the ACL of the copy, not worth reading
+				// it for a canned one
+				sync.ACLForKey = func(*s3.Bucket, s3.Key) s3.ACL { return s3.Private }
+				copier = sync.GrantsCopy(copier, grants)
+			} else if c.String(grantIDsFlag.Name) != "" {
+				logrus.Error("the IDs of the grantees are only used with -copy-grants")
+				exitStatus = 1
+				return
+			}
+			if existing.Policy == sync.CollideOverwrite && existing.Keys == nil && !existing.SkipUnchanged {
+				// overwritten anyway, not worth a HEAD per key
+				existing = nil
+			}
+
+			var verifySample float64
+			if spec := c.String(verifySampleFlag.Name); spec != "" {
+				var err error
+				if verifySample, err = sync.ParseSample(spec); err != nil {
+					logrus.WithField("error", err).Error("invalid verification sample")
+					exitStatus = 1
+					return
+				}
+			}
+			var sum *checksum.Checksum
+			if spec := c.String(checksumFlag.Name); spec != "" {
+				parsed, err := checksum.ParseChecksum(spec)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid checksum")
+					exitStatus = 1
+					return
+				}
+				sum = &parsed
+			}
+
+			var dedupe *sync.Dedupe
+			if c.String(dedupeFlag.Name) != "" {
+				minSize, err := humanize.ParseBytes(c.String(dedupeMinSizeFlag.Name))
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid smallest key to dedupe")
+					exitStatus = 1
+					return
+				}
+				dedupe = &sync.Dedupe{MinSize: int64(minSize)}
+			}
+
+			var reconcile *sync.Reconcile
+			if depth := c.Int(reconcileFlag.Name); depth > 0 {
+				reconcile = &sync.Reconcile{Depth: depth, Prefixes: c.Int(reconcileMaxFlag.Name)}
+				if err := reconcile.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid reconciliation")
+					exitStatus = 1
+					return
+				}
+			}
+
+			malformed := sync.Malformed{
+				MaxLine:    int64(c.Int(maxLineFlag.Name)),
+				Skip:       c.Bool(skipMalformedFlag.Name),
+				MaxSkipped: int64(c.Int(maxMalformedFlag.Name)),
+			}
+			if err := malformed.Validate(); err != nil {
+				logrus.WithField("error", err).Error("invalid handling of malformed lines")
+				exitStatus = 1
+				return
+			}
+
+			var hook *sync.Hook
+			if command := c.String(execFlag.Name); command != "" {
+				hook = &sync.Hook{
+					Command:  command,
+					Para:     c.Int(execParaFlag.Name),
+					MaxRetry: c.Int(execRetryFlag.Name),
+				}
+				if c.String(execTimeoutFlag.Name) != "" {
+					hook.Timeout = mustDuration(c, execTimeoutFlag)
+				}
+				if err := hook.Validate(); err != nil {
+					logrus.WithField("error", err).Error("invalid command per key")
+					exitStatus = 1
+					return
+				}
+			}
+
+			var emitter *events.Emitter
+			if target := c.String(eventsFlag.Name); target != "" {
+				var err error
+				emitter, err = newEmitter(cfg, target)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid events target")
+					exitStatus = 1
+					return
+				}
+				// closed once the sync is done, to publish its last events
+				defer emitter.Close()
+			}
+
+			// a task per source of a fan-in, or per destination of a fan-out
+			type leg struct {
+				name           string
+				task           *sync.SyncTask
+				synced, failed []io.Writer
+			}
+			newLeg := func(src, dest *url.URL, mapping sync.Mapping, input listingInput) (leg, func(), error) {
+				var closers []func()
+				closeAll := func() {
+					for i := len(closers) - 1; i >= 0; i-- {
+						closers[i]()
+					}
+				}
+				name := dest.Host
+				if len(srcs) > 1 {
+					name = src.Host
+				}
+
+				opts := []sync.Option{
+					sync.WithConcurrency(conc),
+					sync.WithMaxFailures(int64(c.Int(maxFailuresFlag.Name)), c.Float64(maxFailureRateFlag.Name)),
+					sync.WithOutputFormat(listingFormat(c, formatFlag, successFilename)),
+					sync.WithFailureFormat(c.String(failureFormatFlag.Name)),
+					sync.WithMapping(mapping),
+					sync.WithVerifySample(verifySample),
+					sync.WithCopier(copier),
+					sync.WithRunID(runID),
+				}
+				if sum != nil {
+					opts = append(opts, sync.WithChecksum(*sum))
+				}
+				if input.files != nil && len(dests) == 1 {
+					// the keys of a fan-out are synced once per destination
+					opts = append(opts, sync.WithInputs(input.files))
+				}
+				if c.String(timeoutFlag.Name) != "" {
+					opts = append(opts, sync.WithTimeout(mustDuration(c, timeoutFlag)))
+				}
+				if existing != nil {
+					opts = append(opts, sync.WithExisting(*existing))
+				}
+				if conditional {
+					opts = append(opts, sync.WithConditional())
+				}
+				if faults != nil {
+					opts = append(opts, sync.WithFaults(*faults))
+				}
+				if breaker != nil {
+					opts = append(opts, sync.WithBreaker(*breaker))
+				}
+				if budget != nil {
+					opts = append(opts, sync.WithRetryBudget(*budget))
+				}
+				if hedging != nil {
+					opts = append(opts, sync.WithHedging(*hedging))
+				}
+				if parking != nil {
+					opts = append(opts, sync.WithParking(*parking))
+				}
+				if n := c.Int(retryConcFlag.Name); n > 0 {
+					opts = append(opts, sync.WithRetryWorkers(n))
+				}
+				opts = append(opts, sync.WithProgress(rateEvery, c.Bool(logProgressFlag.Name)))
+				if watchdog != nil {
+					opts = append(opts, sync.WithWatchdog(*watchdog))
+				}
+				if anomalies != nil {
+					opts = append(opts, sync.WithAnomalies(*anomalies))
+				}
+				if slowKeys != nil {
+					opts = append(opts, sync.WithSlowKeys(*slowKeys))
+				}
+				if reconcile != nil {
+					opts = append(opts, sync.WithReconcile(*reconcile))
+				}
+				if dedupe != nil {
+					opts = append(opts, sync.WithDedupe(*dedupe))
+				}
+				opts = append(opts, sync.WithMalformed(malformed))
+				if emitter != nil {
+					opts = append(opts, sync.WithEvents(events.Tee{emitter, progressStream}))
+				} else {
+					opts = append(opts, sync.WithEvents(progressStream))
+				}
+				if hook != nil {
+					opts = append(opts, sync.WithHook(*hook))
+				}
+				if priorities != nil {
+					opts = append(opts, sync.WithPriorities(*priorities))
+				}
+				if prefixRates != nil {
+					opts = append(opts, sync.WithPrefixRates(*prefixRates))
+				}
+				if schedule != nil {
+					opts = append(opts, sync.WithSchedule(*schedule))
+				}
+				if window := c.Int(interleaveFlag.Name); window != 0 {
+					opts = append(opts, sync.WithInterleave(window))
+				}
+				if window := c.Int(orderedFlag.Name); window != 0 {
+					opts = append(opts, sync.WithOrderedOutput(window))
+				}
+				if markers := c.String(markersFlag.Name); markers != sync.MarkersCopy {
+					opts = append(opts, sync.WithMarkers(markers))
+				}
+				if maxDecoders := c.Int(maxDecodersFlag.Name); maxDecoders != 0 {
+					opts = append(opts, sync.WithMaxDecoders(maxDecoders))
+				}
+				if spillDir := c.String(spillDirFlag.Name); spillDir != "" {
+					opts = append(opts, sync.WithSpillDir(spillDir))
+				}
+				if queueDir := c.String(queueDirFlag.Name); queueDir != "" {
+					if len(srcs) > 1 || len(dests) > 1 {
+						queueDir = filepath.Join(queueDir, name)
+						if err := os.MkdirAll(queueDir, 0755); err != nil {
+							return leg{}, closeAll, fmt.Errorf("creating queue dir: %v", err)
+						}
+					}
+					opts = append(opts, sync.WithQueueDir(queueDir))
+				}
+
+				if stateFilename := c.String(stateFlag.Name); stateFilename != "" {
+					stateFilename = legName(stateFilename, name)
+					key := checkpointKey(checkpointPrefix, stateFilename)
+					if checkpointBkt != nil {
+						restored, err := restoreCheckpoint(checkpointBkt, key, stateFilename)
+						if err != nil {
+							return leg{}, closeAll, fmt.Errorf("restoring state file: %v", err)
+						}
+						if restored {
+							logrus.WithFields(logrus.Fields{
+								"filename":   stateFilename,
+								"checkpoint": fmt.Sprintf("s3://%s/%s", checkpointBkt.Name, key),
+							}).Info("restored state file from checkpoint")
+						}
+					}
+					store, err := openState(stateFilename, stateEnc)
+					if err != nil {
+						return leg{}, closeAll, fmt.Errorf("opening state file: %v", err)
+					}
+					store.SyncEvery(fsyncEvery)
+					stopCheckpoint := func() {}
+					if checkpointBkt != nil {
+						stopCheckpoint = checkpointState(store, checkpointBkt, key, stateFilename, checkpointEvery)
+					}
+					// the last checkpoint is taken before the store is closed
+					closers = append(closers, func() {
+						stopCheckpoint()
+						logIfErr(store.Close())
+					})
+					logrus.WithFields(logrus.Fields{
+						"filename":  stateFilename,
+						"key_count": store.Len(),
+					}).Info("resuming from state file")
+					opts = append(opts, sync.WithState(store))
+				}
+
+				if auditFilename := c.String(auditFlag.Name); auditFilename != "" {
+					w, auditCloser, err := createOutput(cfg, legName(auditFilename, name), fsyncEvery)
+					if err != nil {
+						return leg{}, closeAll, fmt.Errorf("creating audit log: %v", err)
+					}
+					closers = append(closers, func() { logIfErr(auditCloser()) })
+					opts = append(opts, sync.WithAudit(sync.NewAuditLog(w)))
+				}
+
+				srcBkt := prewarmBucket(regionalBucket(srcS3, src.Host))
+				destBkt := prewarmBucket(regionalBucket(destS3, dest.Host))
+				syncTask, err := sync.NewSyncTask(srcBkt, destBkt, opts...)
+				if err != nil {
+					return leg{}, closeAll, fmt.Errorf("preparing sync task: %v", err)
+				}
+
+				successFiles, sucCloser, err := createShards(legName(successFilename, name))
+				if err != nil {
+					logrus.WithField("error", err).Error("couldn't create success key file")
+				}
+				closers = append(closers, func() { logIfErr(sucCloser()) })
+
+				failureFiles, failCloser, err := createShards(legName(failureFilename, name))
+				if err != nil {
+					logrus.WithField("error", err).Error("couldn't create failure key file")
+				}
+				closers = append(closers, func() { logIfErr(failCloser()) })
+
+				if progressFilename != "" {
+					stop := syncTask.WriteSnapshots(legName(progressFilename, name), mustDuration(c, progressEveryFlag), input.count, input.size)
+					closers = append(closers, stop)
+				}
+				stop := syncTask.StreamSnapshots(progressStream, name, streamEvery, input.count, input.size)
+				closers = append(closers, stop)
+				return leg{name: name, task: syncTask, synced: successFiles, failed: failureFiles}, closeAll, nil
+			}
+
+			var legs []leg
+			for i, src := range srcs {
+				for _, dest := range dests {
+					l, closer, err := newLeg(src, dest, mappings[i], inputs[i])
+					defer closer()
+					if err != nil {
+						logrus.WithField("error", err).Error("failed to prepare sync task")
+						exitStatus = 1
+						return
+					}
+					legs = append(legs, l)
+				}
+			}
+			for _, l := range legs {
+				l := l
+				onDashboardControl(l.name, l.task.Pause, l.task.Resume)
+				onProbe(l.name, func() (int64, bool) {
+					p := l.task.Progress()
+					return p.Lines + p.Retries + p.Synced + p.Failed + p.Skipped, p.Paused || p.Cancelled
+				})
+				onStatsDump(func(w io.Writer) {
+					progress, _ := json.Marshal(l.task.Progress())
+					fmt.Fprintf(w, "--- sync %s\n%s\n%s", l.name, progress, l.task.Summary())
+					// the keys that have been syncing the longest are the
+					// likeliest to be stuck
+					for _, k := range l.task.Slowest(10) {
+						slow, _ := json.Marshal(k)
+						fmt.Fprintf(w, "slow key: %s\n", slow)
+					}
+				})
+			}
+
+			if namespace := c.String(cloudwatchFlag.Name); namespace != "" {
+				var srcNames, destNames []string
+				for _, src := range srcs {
+					srcNames = append(srcNames, src.Host)
+				}
+				for _, dest := range dests {
+					destNames = append(destNames, dest.Host)
+				}
+				dims := map[string]string{"Source": strings.Join(srcNames, ","), "Destination": strings.Join(destNames, ",")}
+				stop := publishCloudWatch(cfg, namespace, mustDuration(c, cloudwatchEveryFlag), dims)
+				defer stop()
+			}
+
+			setReady()
+			var err error
+			switch {
+			case len(srcs) > 1:
+				var sources []sync.Source
+				for i, l := range legs {
+					sources = append(sources, sync.Source{
+						Task:   l.task,
+						Input:  inputs[i].rd,
+						Synced: l.synced,
+						Failed: l.failed,
+					})
+				}
+				err = sync.FanIn(sources, c.String(collisionsFlag.Name))
+			case len(dests) > 1:
+				var destinations []sync.Destination
+				for _, l := range legs {
+					destinations = append(destinations, sync.Destination{
+						Task:   l.task,
+						Synced: l.synced,
+						Failed: l.failed,
+					})
+				}
+				err = sync.FanOut(inputs[0].rd, destinations)
+			default:
+				err = legs[0].task.StartSharded(inputs[0].rd, legs[0].synced, legs[0].failed)
+			}
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to sync")
+				// the sync stopped short of its listing, such as on a
+				// corrupt listing or too many failures, unless it was
+				// cancelled on purpose
+				if !errors.Is(err, sync.ErrCancelled) {
+					exitStatus = 1
+				}
+			}
+
+			if reconcile != nil {
+				for _, l := range legs {
+					if !reconcileSync(l.name, l.task) {
+						exitStatus = 1
+					}
+				}
+			}
+
+			for _, in := range inputs {
+				if in.files == nil {
+					continue
+				}
+				for _, p := range in.files.Progress() {
+					logrus.WithFields(logrus.Fields{
+						"filename": p.Name,
+						"keys":     p.Keys,
+						"synced":   p.Synced,
+						"failed":   p.Failed,
+						"skipped":  p.Skipped,
+						"done":     p.Done,
+					}).Info("keys of listing file")
+				}
+			}
+
+			// the numbers people report about a sync, after the logs
+			for _, l := range legs {
+				fmt.Fprintf(os.Stderr, "\nsync summary for %s:\n%s", l.name, l.task.Summary())
+			}
+
+			for _, l := range legs {
+				if failed := l.task.Progress().HookFailed; failed > 0 {
+					logrus.WithFields(logrus.Fields{
+						"bucket":      l.name,
+						"hook_failed": failed,
+					}).Error("command per key failed for some synced keys")
+					exitStatus = 1
+				}
+				if panics := l.task.Progress().Panics; panics > 0 {
+					logrus.WithFields(logrus.Fields{
+						"bucket": l.name,
+						"panics": panics,
+					}).Error("workers recovered from panics, their keys are in the logs")
+					exitStatus = 1
+				}
+			}
+
+			if verifySample > 0 {
+				for _, l := range legs {
+					if !verifySync(l.name, l.task) {
+						exitStatus = 1
+					}
+				}
+			}
+
+			if dedupe != nil {
+				reports := make(map[string]sync.DedupeReport, len(legs))
+				for _, l := range legs {
+					reports[l.name] = dedupeSync(l.name, l.task)
+				}
+				data, err := json.MarshalIndent(reports, "", "  ")
+				if err == nil {
+					err = ioutil.WriteFile(c.String(dedupeFlag.Name), append(data, '\n'), 0644)
+				}
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": c.String(dedupeFlag.Name),
+					}).Error("failed to write dedupe report")
+					exitStatus = 1
+				}
+			}
+
+			if manifestFilename != "" {
+				run.Results = make(map[string]interface{}, len(legs))
+				for _, l := range legs {
+					run.Results[l.name] = l.task.Summary()
+				}
+				if err == nil && exitStatus != 0 {
+					err = errors.New("sync completed with errors")
+				}
+				run.Finish(time.Now(), err)
+				if err := manifest.Write(manifestFilename, run); err != nil {
+					logrus.WithField("error", err).Error("couldn't write manifest")
+				}
+			}
+
+			if reportFilename := c.String(latencyReportFlag.Name); reportFilename != "" {
+				if err := writeLatencyReport(reportFilename); err != nil {
+					logrus.WithField("error", err).Error("failed to write latency report")
+				}
+			}
+		},
+	}
+}
+
+func ingestCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "source of the files, sftp://user@host:port/path for the files under a path of an SFTP server, or the http(s):// URL of a manifest listing the files to get"}
+		destFlag        = cli.StringFlag{Name: "dest", Usage: "destination bucket and prefix where to put the files, of the form s3://name/prefix/"}
+		inputFlag       = cli.StringFlag{Name: "input", Usage: "optional listing of the files to ingest, such as the failure output of an earlier ingest, instead of listing the source"}
+		listingFlag     = cli.StringFlag{Name: "listing", Usage: "optional file where to keep the listing of the source, as JSON keys"}
+		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the list of files that succeeded to ingest, defaults to /dev/null"}
+		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of files that failed to ingest, defaults to /dev/null"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 16, Usage: "number of files ingested at a time"}
+		retriesFlag     = cli.IntFlag{Name: "retries", Value: 5, Usage: "attempts at ingesting each file"}
+		sshFlag         = cli.StringFlag{Name: "ssh-command", Value: "ssh", Usage: "command connecting to SFTP sources, with its options, such as 'ssh -i key.pem -o StrictHostKeyChecking=yes'"}
+		fsyncFlag       = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs are flushed to disk, 0 to only flush on completion"}
+	)
+
+	return cli.Command{
+		Name:  "ingest",
+		Usage: "Ingests the files of an SFTP server or HTTP manifest into an S3 bucket.",
+		Description: strings.TrimSpace(`
+Lists the files of a source other than S3 and puts them into the destination
+bucket under its prefix, with the workers, retries, outputs and summary of
+sync. The source is only ever read.
+
+An sftp:// source is the regular files under its path on an SFTP server,
+symbolic links aside, reached by running the -ssh-command with the sftp
+subsystem: it authenticates non interactively, with the keys of the agent or
+those of its options, and checks the host key as ssh does. The keys of the
+files are their path under the source path.
+
+An http:// or https:// source is a manifest, a text file with the URL of a
+file on each line, relative to the manifest or not, optionally followed by
+its size in bytes. Blank lines and lines starting with # are skipped. The
+keys of the files are their host and path, such as
+files.example.com/data/a.csv. Files served without their length are spooled
+to a temporary file before they're put.
+
+Files gone since they were listed are counted synced, like deleted keys are.
+Files that can't be read, denied or failing after -retries, are written to
+the failure output, which can be the -input of another ingest to try them
+again. For instance:
+	brigade ingest -config cfg.json -src sftp://etl@legacy.example.com/exports \
+		-dest s3://data-bucket/legacy/ -success ingested.json -failure failed.json`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcFlag,
+			destFlag,
+			inputFlag,
+			listingFlag,
+			successFlag,
+			failureFlag,
+			concurrencyFlag,
+			retriesFlag,
+			sshFlag,
+			fsyncFlag,
+		},
+		Action: func(c *cli.Context) {
+			cfg := mustConfig(c, configFlag)
+			dest := mustURL(c, destFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+			srcURL := mustString(c, srcFlag)
+			src, err := ingest.NewSource(srcURL, strings.Fields(c.String(sshFlag.Name)))
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid source")
+				exitStatus = 1
+				return
+			}
+			defer func() { logIfErr(src.Close()) }()
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			inputName := c.String(inputFlag.Name)
+			if inputName == "" {
+				inputName, err = listSource(src, c.String(listingFlag.Name))
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":  err,
+						"source": srcURL,
+					}).Error("failed to list source")
+					exitStatus = 1
+					return
+				}
+				if c.String(listingFlag.Name) == "" {
+					defer func() { logIfErr(os.Remove(inputName)) }()
+				}
+			}
+			input, _, err := openListing(cfg, inputName)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to open listing")
+				exitStatus = 1
+				return
+			}
+			defer func() { logIfErr(input.Close()) }()
+
+			synced, sucCloser, err := createOutput(cfg, c.String(successFlag.Name), fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to create success output")
+				exitStatus = 1
+				return
+			}
+			defer func() { logIfErr(sucCloser()) }()
+			failed, failCloser, err := createOutput(cfg, c.String(failureFlag.Name), fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to create failure output")
+				exitStatus = 1
+				return
+			}
+			defer func() { logIfErr(failCloser()) }()
+
+			// the files are put into the destination, which is also the
+			// source of the task for its calls that need one
+			dst := regionalBucket(setupS3Timeouts(cfg.Destination.S3()), dest.Host)
+			task, err := sync.NewSyncTask(dst, dst,
+				sync.WithConcurrency(c.Int(concurrencyFlag.Name)),
+				sync.WithRetry(c.Int(retriesFlag.Name), time.Second),
+				sync.WithCopier(ingest.Copier(src)),
+				sync.WithMapping(sync.Mapping{To: strings.TrimPrefix(dest.Path, "/")}),
+				sync.WithRunID(runID),
+			)
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid ingest")
+				exitStatus = 1
+				return
+			}
+			err = task.Start(input, synced, failed)
+			fmt.Fprintf(os.Stderr, "\ningest summary:\n%s", task.Summary())
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to ingest")
+				exitStatus = 1
+				return
+			}
+			if p := task.Progress(); p.Failed > 0 {
+				logrus.WithField("failed", p.Failed).Error("some files failed to ingest")
+				exitStatus = 1
+			}
+		},
+	}
+}
+
+// listSource writes the listing of the files of a source to a file, or to a
+// temporary one if there's none, and returns its name.
+func listSource(src ingest.Source, filename string) (string, error) {
+	var (
+		file *os.File
+		err  error
+	)
+	if filename == "" {
+		file, err = ioutil.TempFile("", "brigade-ingest-")
+	} else {
+		file, err = os.Create(filename)
+	}
+	if err != nil {
+		return "", err
+	}
+	w, err := listing.NewWriter(file, listing.JSON)
+	if err == nil {
+		var n int64
+		err = src.List(func(key s3.Key) error {
+			if n++; n%10000 == 0 {
+				logrus.WithField("files", n).Info("listing source")
+			}
+			return w.Write(key)
+		})
+		if err == nil {
+			err = w.Flush()
+		}
+		logrus.WithField("files", n).Info("listed source")
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+func sliceCommand() cli.Command {
+	var (
+		nFlag        = cli.IntFlag{Name: "n", Value: 0, Usage: "number of slices to split the S3 key listing over"}
+		filenameFlag = cli.StringFlag{Name: "src", Value: "", Usage: "file from which to read the S3 key listing"}
+	)
+
+	return cli.Command{
+		Name:  "slice",
+		Usage: "Slice an S3 key listing into multiple sub-listings.",
+		Description: strings.TrimSpace(`
 Slices a listing of S3 keys into multiple files, each containing evenly
 distributed keys. It expects a key listing in the form of a gzip'd JSON file
 and will produce such files in return. Each file is prefixed by its index,
@@ -282,187 +2360,3498 @@ Will produce the files:
 		Flags: []cli.Flag{nFlag, filenameFlag},
 		Action: func(c *cli.Context) {
 
-			n := c.Int(nFlag.Name)
-			filename := c.String(filenameFlag.Name)
+			n := c.Int(nFlag.Name)
+			filename := c.String(filenameFlag.Name)
+
+			hadError := true
+			switch {
+			case filename == "":
+				logrus.Error("need a file to slice")
+			case n <= 1:
+				logrus.Error("need to slice in at least 2 parts")
+			default:
+				hadError = false
+			}
+			if hadError {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return
+			}
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			_, err := slice.Slice(filename, n)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to slice")
+			}
+
+		},
+	}
+}
+
+func splitCommand() cli.Command {
+	var (
+		srcfileFlag = cli.StringFlag{Name: "src", Usage: "gzip'd key listing to split, in any format"}
+		nFlag       = cli.IntFlag{Name: "n", Usage: "number of listings to split the keys into"}
+		dirFlag     = cli.StringFlag{Name: "dir", Value: ".", Usage: "directory where to write the listings"}
+		formatFlag  = cli.StringFlag{Name: "format", Value: listing.JSON, Usage: "format of the listings, json, binary or msgpack, defaults to the one of the src extension (.json, .bin, .msgpack) or json"}
+	)
+
+	return cli.Command{
+		Name:  "split",
+		Usage: "Splits a key listing into listings of balanced bytes.",
+		Description: strings.TrimSpace(`
+Splits a gzip'd key listing into n listings holding about the same bytes,
+so that the machines or the runs that sync them each get an equal share of
+the work, where 'slice' gives them the same number of keys whatever their
+sizes. Each key goes to the listing with the fewest bytes so far, so the
+listings differ by at most the size of the largest key, and the listing is
+read once. Each listing is prefixed by its index, so calling:
+	brigade split -n 3 -src bucket.json.gz
+Will produce the files:
+	0_bucket.json.gz
+	1_bucket.json.gz
+	2_bucket.json.gz`),
+		Flags: []cli.Flag{srcfileFlag, nFlag, dirFlag, formatFlag},
+		Action: func(c *cli.Context) {
+
+			srcfile := mustString(c, srcfileFlag)
+			n := c.Int(nFlag.Name)
+			if n <= 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need to split in at least 2 parts")
+				return
+			}
+			dir := c.String(dirFlag.Name)
+			format := listingFormat(c, formatFlag, srcfile)
+			if _, err := listing.NewWriter(ioutil.Discard, format); err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid format")
+				return
+			}
+			if err := os.MkdirAll(dir, 0750); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"dir":   dir,
+				}).Error("couldn't create directory")
+				return
+			}
+
+			srcf, err := os.Open(srcfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Fatal("couldn't open file")
+			}
+			defer func() { logIfErr(srcf.Close()) }()
+			srcgz, err := gzip.NewReader(srcf)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Fatal("couldn't read gzip")
+			}
+			defer func() { logIfErr(srcgz.Close()) }()
+			rd, err := listing.NewReader(srcgz)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("couldn't read listing")
+			}
+
+			filenames := make([]string, n)
+			for i := range filenames {
+				filenames[i] = filepath.Join(dir, fmt.Sprintf("%d_%s", i, filepath.Base(srcfile)))
+			}
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			parts, err := split.Split(rd, filenames, format)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to split")
+				exitStatus = 1
+				return
+			}
+			for _, part := range parts {
+				logrus.WithFields(logrus.Fields{
+					"filename": part.Filename,
+					"keys":     part.Keys,
+					"bytes":    part.Bytes,
+				}).Info("part")
+			}
+			logrus.WithField("parts", len(parts)).Info("done splitting")
+		},
+	}
+}
+
+func diffCommand() cli.Command {
+	var (
+		oldfileFlag  = cli.StringFlag{Name: "old", Usage: "old file from which to read s3 keys"}
+		newfileFlag  = cli.StringFlag{Name: "new", Usage: "new file from which to read s3 keys"}
+		dstfileFlag  = cli.StringFlag{Name: "dest", Usage: "destination file where to write the keys that have changed"}
+		tolerateFlag = cli.StringFlag{Name: "tolerate", Usage: "optional comma separated differences that don't count, like compare -tolerate, only multipart changes the diff"}
+	)
+
+	return cli.Command{
+		Name:  "diff",
+		Usage: "Generates a differential listing of S3 keys.",
+		Description: strings.TrimSpace(`
+Reads from an old s3 key listing and a new one, computes which keys have changed
+in the new listing and generates a new files containing only those keys.
+
+Keys are told changed by their ETag. After a migration that rewrote the keys,
+-tolerate multipart keeps a key unchanged when the old listing has a key of
+the same name and size, and either of their ETags is of a multipart upload.
+Diff doesn't compare the other differences that compare can tolerate.`),
+		Flags: []cli.Flag{oldfileFlag, newfileFlag, dstfileFlag, tolerateFlag},
+		Action: func(c *cli.Context) {
+
+			oldfile := c.String(oldfileFlag.Name)
+			newfile := c.String(newfileFlag.Name)
+			dstfile := c.String(dstfileFlag.Name)
+
+			tolerance, tolerr := compare.ParseTolerance(c.String(tolerateFlag.Name))
+
+			hadError := true
+			switch {
+			case tolerr != nil:
+				logrus.WithField("error", tolerr).Error("invalid tolerance")
+			case oldfile == "":
+				logrus.Error("need a filename for old key listing")
+			case newfile == "":
+				logrus.Error("need a filename for new key listing")
+			case dstfile == "":
+				logrus.Error("need a filename for dest key listing")
+			default:
+				hadError = false
+			}
+			if hadError {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return
+			}
+
+			open := func(filename string) *os.File {
+				f, err := os.Open(filename)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": filename,
+					}).Fatal("couldn't open file")
+				}
+				return f
+			}
+
+			newf := open(newfile)
+			defer func() { logIfErr(newf.Close()) }()
+			oldf := open(oldfile)
+			defer func() { logIfErr(oldf.Close()) }()
+
+			dstf, err := os.Create(dstfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": dstfile,
+				}).Fatal("couldn't create destination file")
+			}
+			defer func() { logIfErr(dstf.Close()) }()
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			differ := brigade.NewDiffer()
+			differ.Tolerance = tolerance
+			if err := differ.Diff(oldf, newf, dstf); err != nil {
+				logrus.WithField("error", err).Error("failed to diff")
+			}
+		},
+	}
+}
+
+func deleteCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		inputFlag       = cli.StringFlag{Name: "input", Usage: "name of the file containing the list of keys to delete, or its s3://bucket/key URL in the state bucket"}
+		bucketFlag      = cli.StringFlag{Name: "bucket", Usage: "bucket to delete the keys from, with the credentials of the destination"}
+		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the list of keys that were deleted, s3:// URL, or SQS queue URL, defaults to /dev/null"}
+		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of keys that failed to be deleted, s3:// URL, or SQS queue URL, defaults to /dev/null"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 10, Usage: "number of concurrent delete requests"}
+		batchSizeFlag   = cli.IntFlag{Name: "batch-size", Value: sync.DeleteBatch, Usage: "number of keys deleted by each request, at most 1000"}
+		fsyncFlag       = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs are flushed to disk, 0 to only flush on completion"}
+		formatFlag      = cli.StringFlag{Name: "format", Value: listing.JSON, Usage: "encoder of the success and failure outputs, " + strings.Join(listing.Encoders(), ", ") + ", defaults to the format of the success extension (.json, .bin, .msgpack) or json"}
+		maxFractionFlag = cli.Float64Flag{Name: "max-delete-fraction", Value: 0.1, Usage: "largest fraction of the keys of the bucket that can be deleted without -force"}
+		bucketListFlag  = cli.StringFlag{Name: "bucket-list", Usage: "optional listing of the bucket, whose keys are counted instead of listing the bucket to check -max-delete-fraction"}
+		forceFlag       = cli.BoolFlag{Name: "force", Usage: "delete the keys whatever the fraction of the bucket they are"}
+		yesFlag         = cli.BoolFlag{Name: "yes", Usage: "delete the keys without showing the plan of the delete and asking to confirm it"}
+		quarantineFlag  = cli.BoolFlag{Name: "quarantine", Usage: "move the keys to the " + sync.TrashPrefix + "<run id>/ prefix of the bucket rather than deleting them outright, for purge to empty"}
+	)
+
+	return cli.Command{
+		Name:  "delete",
+		Usage: "Deletes the keys of a listing from an S3 bucket.",
+		Description: strings.TrimSpace(`
+Reads the keys from an s3 key listing, such as the keys of a diff of a
+destination against its source, and deletes them from a bucket, many at once
+with DeleteObjects, in batches of up to 1000 keys. Keys that fail with a
+retriable error are retried, like with sync, and those that still fail are
+written to the failure output with their error, which can be the input of
+another delete. Keys that are already gone count as deleted.
+
+Before deleting anything, the keys of the listing and those of the bucket are
+counted, and the delete is refused when it would remove more than
+-max-delete-fraction of the bucket, 10% by default, such as when a truncated
+listing of the source of a mirror has most of its destination to delete. The
+bucket is listed to count its keys, unless a recent -bucket-list of it is
+given. -force deletes the keys whatever the fraction of the bucket they are.
+
+The plan of the delete, the keys and bytes it removes, is then shown, and the
+keys are only deleted once the plan is confirmed by answering yes, like
+terraform apply. Scripts, which have no terminal to answer from, must say
+-yes, which deletes the keys without showing the plan, or counting them with
+-force:
+	brigade delete -bucket s3://dst -input extra.json.gz -bucket-list dst.json.gz
+
+With -quarantine, the keys are moved to the trash of the bucket rather than
+deleted outright: each is copied under .brigade-trash/<run id>/ and only
+deleted once copied, so that a mirror deleting keys it shouldn't have can
+be undone by copying them back. The keys already in the trash are left
+there. Purge empties the trash once its retention period is over.`),
+		Flags: []cli.Flag{
+			configFlag,
+			inputFlag,
+			bucketFlag,
+			successFlag,
+			failureFlag,
+			concurrencyFlag,
+			batchSizeFlag,
+			fsyncFlag,
+			formatFlag,
+			maxFractionFlag,
+			bucketListFlag,
+			forceFlag,
+			yesFlag,
+			quarantineFlag,
+		},
+		Action: func(c *cli.Context) {
+			inputFilename := mustString(c, inputFlag)
+			successFilename := c.String(successFlag.Name)
+			failureFilename := c.String(failureFlag.Name)
+			cfg := mustConfig(c, configFlag)
+			bkt := mustURLs(c, bucketFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+			if len(bkt) != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need a single bucket to delete from")
+				return
+			}
+			dstS3 := setupS3Timeouts(cfg.Destination.S3())
+
+			var deletes *keyCounter
+			bucketKeys := int64(-1)
+			if !c.Bool(forceFlag.Name) {
+				guard := sync.DeleteGuard{MaxFraction: c.Float64(maxFractionFlag.Name)}
+				if err := guard.Validate(); err != nil {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("error", err).Error("invalid -" + maxFractionFlag.Name)
+					return
+				}
+				var err error
+				deletes, bucketKeys, err = countDeletes(cfg, inputFilename, dstS3, bkt[0], c.String(bucketListFlag.Name))
+				if err != nil {
+					logrus.WithField("error", err).Error("couldn't count the keys to delete")
+					exitStatus = 1
+					return
+				}
+				log := logrus.WithFields(logrus.Fields{
+					"deletes":      deletes.n,
+					"bucket_keys":  bucketKeys,
+					"max_fraction": guard.MaxFraction,
+				})
+				if err := guard.Check(deletes.n, bucketKeys); err != nil {
+					log.WithField("error", err).Error("refusing to delete, check the listings or delete with -force")
+					exitStatus = 1
+					return
+				}
+				log.Info("the keys to delete are within the fraction of the bucket allowed")
+			}
+			if !c.Bool(yesFlag.Name) {
+				if deletes == nil {
+					var err error
+					if deletes, err = countListing(cfg, inputFilename); err != nil {
+						logrus.WithField("error", err).Error("couldn't count the keys to delete")
+						exitStatus = 1
+						return
+					}
+				}
+				verb := "delete"
+				if c.Bool(quarantineFlag.Name) {
+					verb = "move to the trash"
+				}
+				plan := fmt.Sprintf("%s %s keys (%s) from s3://%s", verb, humanize.Comma(deletes.n), humanize.Bytes(uint64(deletes.bytes)), bkt[0].Host)
+				if bucketKeys >= 0 {
+					plan += fmt.Sprintf(", out of the %s keys it has", humanize.Comma(bucketKeys))
+				}
+				if !confirmPlan(plan) {
+					exitStatus = 1
+					return
+				}
+			}
+
+			listfile, _, err := openListing(cfg, inputFilename)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": inputFilename,
+				}).Error("couldn't open listing file")
+				return
+			}
+			defer func() { logIfErr(listfile.Close()) }()
+			input, err := gzip.NewReader(listfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": inputFilename,
+				}).Error("listing file is not a gzip file")
+				return
+			}
+			defer func() { logIfErr(input.Close()) }()
+
+			deleted, delCloser, err := createOutput(cfg, successFilename, fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't create success output")
+				return
+			}
+			failed, failCloser, err := createOutput(cfg, failureFilename, fsyncEvery)
+			if err != nil {
+				logIfErr(delCloser())
+				logrus.WithField("error", err).Error("couldn't create failure output")
+				return
+			}
+
+			task, err := sync.NewDeleteTask(regionalBucket(dstS3, bkt[0].Host))
+			if err != nil {
+				logIfErr(delCloser())
+				logIfErr(failCloser())
+				logrus.WithField("error", err).Error("couldn't prepare delete task")
+				return
+			}
+			task.DeletePara = c.Int(concurrencyFlag.Name)
+			task.BatchSize = c.Int(batchSizeFlag.Name)
+			task.OutputFormat = listingFormat(c, formatFlag, successFilename)
+			task.RunID = runID
+			if c.Bool(quarantineFlag.Name) {
+				task.Quarantine = sync.QuarantinePrefix(runID)
+			}
+
+			logrus.Info("starting command ", c.Command.Name)
+			err = task.Start(input, deleted, failed)
+			logIfErr(delCloser())
+			logIfErr(failCloser())
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to delete")
+				exitStatus = 1
+			}
+			if failed := task.Progress().Failed; failed > 0 {
+				logrus.WithField("failed", failed).Error("some keys couldn't be deleted")
+				exitStatus = 1
+			}
+		},
+	}
+}
+
+func purgeCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		bucketFlag      = cli.StringFlag{Name: "bucket", Usage: "bucket whose trash to empty, with the credentials of the destination"}
+		retentionFlag   = cli.StringFlag{Name: "retention", Value: "168h", Usage: "how long the keys moved to the trash are kept before they're purged"}
+		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the list of keys that were purged, s3:// URL, or SQS queue URL, defaults to /dev/null"}
+		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of keys that failed to be purged, s3:// URL, or SQS queue URL, defaults to /dev/null"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 10, Usage: "number of concurrent delete requests"}
+		fsyncFlag       = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs are flushed to disk, 0 to only flush on completion"}
+		yesFlag         = cli.BoolFlag{Name: "yes", Usage: "purge the keys without showing the plan of the purge and asking to confirm it"}
+	)
+
+	return cli.Command{
+		Name:  "purge",
+		Usage: "Empties the trash of keys moved to it by delete -quarantine.",
+		Description: strings.TrimSpace(`
+Lists the trash of a bucket, the keys that delete -quarantine moved under
+its .brigade-trash/ prefix, and deletes those that were moved to it longer
+than -retention ago, a week by default, like delete does. The keys moved
+since are kept, to be restored if they shouldn't have been deleted.
+
+The plan of the purge, the keys and bytes it removes, is shown first, and
+the keys are only deleted once it's confirmed by answering yes, unless
+-yes is given. For instance:
+	brigade purge -config conf.json -bucket s3://dst-bucket/ -retention 720h -yes`),
+		Flags: []cli.Flag{
+			configFlag,
+			bucketFlag,
+			retentionFlag,
+			successFlag,
+			failureFlag,
+			concurrencyFlag,
+			fsyncFlag,
+			yesFlag,
+		},
+		Action: func(c *cli.Context) {
+			cfg := mustConfig(c, configFlag)
+			bkt := mustURLs(c, bucketFlag)
+			retention := mustDuration(c, retentionFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+			successFilename := c.String(successFlag.Name)
+			failureFilename := c.String(failureFlag.Name)
+			if len(bkt) != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need a single bucket to purge the trash of")
+				return
+			}
+			dstS3 := setupS3Timeouts(cfg.Destination.S3())
+
+			// the keys to purge are kept aside, to be counted before
+			// they're deleted
+			tmp, err := ioutil.TempFile("", "brigade-purge")
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't create temporary listing")
+				exitStatus = 1
+				return
+			}
+			defer func() {
+				logIfErr(tmp.Close())
+				logIfErr(os.Remove(tmp.Name()))
+			}()
+			w, err := listing.NewWriter(tmp, listing.JSON)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't create temporary listing")
+				exitStatus = 1
+				return
+			}
+			expired := &sync.ExpiredTrash{W: w, Before: time.Now().Add(-retention)}
+			if err := list.ListTo(dstS3, bkt[0].Host, sync.TrashPrefix, expired); err != nil {
+				logrus.WithField("error", err).Error("couldn't list the trash")
+				exitStatus = 1
+				return
+			}
+			log := logrus.WithFields(logrus.Fields{
+				"bucket":    bkt[0].Host,
+				"retention": retention,
+				"keys":      expired.Keys,
+				"bytes":     expired.Bytes,
+			})
+			if expired.Keys == 0 {
+				log.Info("nothing in the trash to purge")
+				return
+			}
+			if !c.Bool(yesFlag.Name) {
+				plan := fmt.Sprintf("purge %s keys (%s) moved to the trash of s3://%s over %v ago", humanize.Comma(expired.Keys), humanize.Bytes(uint64(expired.Bytes)), bkt[0].Host, retention)
+				if !confirmPlan(plan) {
+					exitStatus = 1
+					return
+				}
+			}
+			if _, err := tmp.Seek(0, 0); err != nil {
+				logrus.WithField("error", err).Error("couldn't read temporary listing")
+				exitStatus = 1
+				return
+			}
+
+			deleted, delCloser, err := createOutput(cfg, successFilename, fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't create success output")
+				return
+			}
+			failed, failCloser, err := createOutput(cfg, failureFilename, fsyncEvery)
+			if err != nil {
+				logIfErr(delCloser())
+				logrus.WithField("error", err).Error("couldn't create failure output")
+				return
+			}
+			task, err := sync.NewDeleteTask(regionalBucket(dstS3, bkt[0].Host))
+			if err != nil {
+				logIfErr(delCloser())
+				logIfErr(failCloser())
+				logrus.WithField("error", err).Error("couldn't prepare delete task")
+				return
+			}
+			task.DeletePara = c.Int(concurrencyFlag.Name)
+			task.RunID = runID
+
+			log.Info("starting command ", c.Command.Name)
+			err = task.Start(tmp, deleted, failed)
+			logIfErr(delCloser())
+			logIfErr(failCloser())
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to purge")
+				exitStatus = 1
+			}
+			if failed := task.Progress().Failed; failed > 0 {
+				logrus.WithField("failed", failed).Error("some keys couldn't be purged")
+				exitStatus = 1
+			}
+		},
+	}
+}
+
+func headCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		inputFlag       = cli.StringFlag{Name: "input", Usage: "name of the file containing the list of keys to enrich, or its s3://bucket/key URL in the state bucket"}
+		bucketFlag      = cli.StringFlag{Name: "bucket", Usage: "bucket of the keys, with the credentials of the source"}
+		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the enriched listing, or s3:// URL, defaults to /dev/null"}
+		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of keys whose metadata couldn't be read, or s3:// URL, defaults to /dev/null"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 100, Usage: "number of concurrent HEAD requests"}
+		tagsFlag        = cli.BoolFlag{Name: "tags", Usage: "also read the tags of the keys that have some, with a request per key"}
+		tagFilterFlag   = cli.StringFlag{Name: "tag-filter", Usage: "optional comma separated conditions on the tags of the keys, all of which must hold for a key to be in the success output, like 'backup=true,team,!legacy', reading the tags as with -tags"}
+		fsyncFlag       = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs are flushed to disk, 0 to only flush on completion"}
+		formatFlag      = cli.StringFlag{Name: "format", Value: listing.JSON, Usage: "format of the success and failure outputs, json, binary or msgpack, defaults to the one of the success extension (.json, .bin, .msgpack) or json"}
+	)
+
+	return cli.Command{
+		Name:  "head",
+		Usage: "Enriches the keys of a listing with the metadata of their objects.",
+		Description: strings.TrimSpace(`
+Reads the keys from an s3 key listing and does a HEAD on each of them, many
+at once, writing them to the success output with their Content-Type, user
+metadata and storage class, and with their tags with -tags. Filters and
+syncs can then use the metadata of the keys without a request per key at
+copy time. Keys that no longer exist are left out, and keys whose metadata
+couldn't be read are written to the failure output with their error.
+
+With -tag-filter, only the keys whose tags meet all its conditions are
+written to the success output, so that a sync of it only copies them, such
+as the keys tagged backup=true: tag=value for a tag with a value, tag for a
+tag with any value, and !tag for a tag the key doesn't have. For instance:
+	brigade head -config conf.json -bucket s3://src-bucket/ -input list.json.gz \
+		-tag-filter backup=true -success backups.json.gz
+
+Only the json format has room for the metadata, the binary and msgpack
+formats only keep the storage class of the keys.`),
+		Flags: []cli.Flag{
+			configFlag,
+			inputFlag,
+			bucketFlag,
+			successFlag,
+			failureFlag,
+			concurrencyFlag,
+			tagsFlag,
+			tagFilterFlag,
+			fsyncFlag,
+			formatFlag,
+		},
+		Action: func(c *cli.Context) {
+			inputFilename := mustString(c, inputFlag)
+			successFilename := c.String(successFlag.Name)
+			failureFilename := c.String(failureFlag.Name)
+			cfg := mustConfig(c, configFlag)
+			bkt := mustURLs(c, bucketFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+			if len(bkt) != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need a single bucket to read the keys from")
+				return
+			}
+			var tagFilter sync.TagFilter
+			if spec := c.String(tagFilterFlag.Name); spec != "" {
+				var err error
+				if tagFilter, err = sync.ParseTagFilter(spec); err != nil {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("error", err).Error("invalid tag filter")
+					return
+				}
+			}
+
+			listfile, _, err := openListing(cfg, inputFilename)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": inputFilename,
+				}).Error("couldn't open listing file")
+				return
+			}
+			defer func() { logIfErr(listfile.Close()) }()
+			input, err := gzip.NewReader(listfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": inputFilename,
+				}).Error("listing file is not a gzip file")
+				return
+			}
+			defer func() { logIfErr(input.Close()) }()
+
+			enriched, okCloser, err := createOutput(cfg, successFilename, fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't create success output")
+				return
+			}
+			failed, failCloser, err := createOutput(cfg, failureFilename, fsyncEvery)
+			if err != nil {
+				logIfErr(okCloser())
+				logrus.WithField("error", err).Error("couldn't create failure output")
+				return
+			}
+
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
+			task, err := sync.NewHeadTask(regionalBucket(srcS3, bkt[0].Host))
+			if err != nil {
+				logIfErr(okCloser())
+				logIfErr(failCloser())
+				logrus.WithField("error", err).Error("couldn't prepare head task")
+				return
+			}
+			task.HeadPara = c.Int(concurrencyFlag.Name)
+			task.Tags = c.Bool(tagsFlag.Name)
+			task.TagFilter = tagFilter
+			task.OutputFormat = listingFormat(c, formatFlag, successFilename)
+			task.RunID = runID
+
+			logrus.Info("starting command ", c.Command.Name)
+			err = task.Start(input, enriched, failed)
+			logIfErr(okCloser())
+			logIfErr(failCloser())
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to read the metadata of keys")
+				exitStatus = 1
+			}
+			if failed := task.Progress().Failed; failed > 0 {
+				logrus.WithField("failed", failed).Error("the metadata of some keys couldn't be read")
+				exitStatus = 1
+			}
+		},
+	}
+}
+
+func bucketConfigCommand() cli.Command {
+	var (
+		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		srcFlag    = cli.StringFlag{Name: "src", Usage: "source bucket to copy the configuration from"}
+		destFlag   = cli.StringFlag{Name: "dest", Usage: "destination bucket to copy the configuration to"}
+		onlyFlag   = cli.StringFlag{Name: "only", Value: strings.Join(bucketconfig.Subresources, ","), Usage: "comma separated configurations to copy"}
+		dryRunFlag = cli.BoolFlag{Name: "dry-run", Usage: "only print how the configuration of the destination differs, without changing it"}
+		pruneFlag  = cli.BoolFlag{Name: "prune", Usage: "delete the configurations of the destination that the source doesn't have"}
+		yesFlag    = cli.BoolFlag{Name: "yes", Usage: "replace and delete the configurations of the destination without asking to confirm it"}
+	)
+
+	return cli.Command{
+		Name:  "bucket-config",
+		Usage: "Copies the configuration of a bucket to another.",
+		Description: strings.TrimSpace(`
+Copies the configuration of the source bucket that matters to a migration to
+the destination bucket, so that the destination behaves like the source once
+its keys are synced: its CORS rules, lifecycle rules, website, tags and
+policy. The ARNs of the source in its policy are renamed to the ones of the
+destination, its principals are kept as they are.
+
+Prints how each configuration of the destination differs from the one of the
+source before copying it, and only prints it with -dry-run. Configurations of
+the destination that the source doesn't have are left alone, unless -prune.
+Replacing or deleting configurations of the destination is only done once
+confirmed by answering yes, or with -yes.`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcFlag,
+			destFlag,
+			onlyFlag,
+			dryRunFlag,
+			pruneFlag,
+			yesFlag,
+		},
+		Action: func(c *cli.Context) {
+			cfg := mustConfig(c, configFlag)
+			src := mustURLs(c, srcFlag)
+			dst := mustURLs(c, destFlag)
+			if len(src) != 1 || len(dst) != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need a single source and destination bucket")
+				return
+			}
+			var only []string
+			for _, name := range strings.Split(c.String(onlyFlag.Name), ",") {
+				only = append(only, strings.TrimSpace(name))
+			}
+			if err := bucketconfig.Validate(only); err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid configurations")
+				return
+			}
+
+			srcBkt := regionalBucket(setupS3Timeouts(cfg.Source.S3()), src[0].Host)
+			dstBkt := regionalBucket(setupS3Timeouts(cfg.Destination.S3()), dst[0].Host)
+
+			logrus.Info("starting command ", c.Command.Name)
+			changes, err := bucketconfig.Diff(srcBkt, dstBkt, only)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't compare the bucket configurations")
+				exitStatus = 1
+				return
+			}
+			if err := bucketconfig.WriteDiff(os.Stdout, changes); err != nil {
+				logrus.WithField("error", err).Error("couldn't print the differences")
+				exitStatus = 1
+				return
+			}
+			if c.Bool(dryRunFlag.Name) {
+				return
+			}
+			var replaced, deleted []string
+			for _, change := range changes {
+				switch {
+				case change.Action == bucketconfig.Update:
+					replaced = append(replaced, change.Subresource)
+				case change.Action == bucketconfig.Extra && c.Bool(pruneFlag.Name):
+					deleted = append(deleted, change.Subresource)
+				}
+			}
+			if (len(replaced) > 0 || len(deleted) > 0) && !c.Bool(yesFlag.Name) {
+				var plan []string
+				if len(replaced) > 0 {
+					plan = append(plan, "replace the "+strings.Join(replaced, ", ")+" configuration")
+				}
+				if len(deleted) > 0 {
+					plan = append(plan, "delete the "+strings.Join(deleted, ", ")+" configuration")
+				}
+				if !confirmPlan(strings.Join(plan, " and ") + " of s3://" + dst[0].Host) {
+					exitStatus = 1
+					return
+				}
+			}
+			if err := bucketconfig.Apply(dstBkt, changes, c.Bool(pruneFlag.Name)); err != nil {
+				logrus.WithField("error", err).Error("couldn't copy the bucket configuration")
+				exitStatus = 1
+			}
+		},
+	}
+}
+
+func backupCommand() cli.Command {
+	var (
+		srcFlag   = cli.StringFlag{Name: "src", Usage: "source bucket to get the keys from"}
+		destFlag  = cli.StringFlag{Name: "dest", Usage: "destination bucket to put the keys into"}
+		stateFlag = cli.StringFlag{Name: "state", Usage: "state bucket where artifacts of backups are held"}
+
+		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+	)
+
+	return cli.Command{
+		Name:  "backup",
+		Usage: "Executes list, diff and sync from a source to a destination bucket.",
+		Description: strings.TrimSpace(`
+Executes list, diff and sync one after another. It works against a
+'state' s3 bucket, which contains past backups and where this backup
+will store its output.`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcFlag,
+			destFlag,
+			stateFlag,
+		},
+		Action: func(c *cli.Context) {
+
+			cfg := mustConfig(c, configFlag)
+			src := mustURL(c, srcFlag)
+			dest := mustURL(c, destFlag)
+			state := mustURL(c, stateFlag)
+
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
+			srcBkt := regionalBucket(srcS3, src.Host)
+
+			destS3 := setupS3Timeouts(cfg.Destination.S3())
+			destBkt := regionalBucket(destS3, dest.Host)
+
+			stateS3 := setupS3Timeouts(cfg.State.S3())
+			stateBkt := stateS3.Bucket(state.Host)
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			srcPath := src.Path
+			if strings.HasPrefix(srcPath, "/") {
+				srcPath = srcPath[1:]
+			}
+
+			statePath := state.Path
+			if strings.HasPrefix(statePath, "/") {
+				statePath = statePath[1:]
+			}
+
+			task, err := backup.NewBackup(srcBkt, destBkt, stateBkt, srcPath, statePath)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to prepare backup task")
+				return
+			}
+
+			var deleteFiles bool
+			if err := task.Execute(); err != nil {
+				logrus.WithField("error", err).Error("failed to backup")
+				deleteFiles = false
+			} else {
+				deleteFiles = true
+			}
+			if err := task.Cleanup(deleteFiles); err != nil {
+				logrus.WithField("error", err).Error("failed to close backup task")
+			}
+
+		},
+	}
+}
+
+func mergeCommand() cli.Command {
+	var (
+		dstfileFlag = cli.StringFlag{Name: "dest", Usage: "destination file where to write the merged keys"}
+	)
+
+	return cli.Command{
+		Name:  "merge",
+		Usage: "Merges sharded key listings into a single one.",
+		Description: strings.TrimSpace(`
+Concatenates the gzip'd key listings given as arguments into a single gzip'd
+listing, such as the shards written by 'sync -shards'. For instance:
+	brigade merge -dest synced.json.gz synced-000.json.gz synced-001.json.gz`),
+		Flags: []cli.Flag{dstfileFlag},
+		Action: func(c *cli.Context) {
+
+			dstfile := mustString(c, dstfileFlag)
+			if !c.Args().Present() {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need at least one file to merge")
+				return
+			}
+
+			var shards []io.Reader
+			for _, filename := range c.Args() {
+				f, err := os.Open(filename)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": filename,
+					}).Fatal("couldn't open file")
+				}
+				defer func() { logIfErr(f.Close()) }()
+
+				gzr, err := gzip.NewReader(f)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": filename,
+					}).Fatal("couldn't read gzip")
+				}
+				defer func() { logIfErr(gzr.Close()) }()
+				shards = append(shards, gzr)
+			}
+
+			dstf, err := os.Create(dstfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": dstfile,
+				}).Fatal("couldn't create destination file")
+			}
+			defer func() { logIfErr(dstf.Close()) }()
+			dstgz := gzip.NewWriter(dstf)
+			defer func() { logIfErr(dstgz.Close()) }()
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			if err := sync.MergeShards(dstgz, shards...); err != nil {
+				logrus.WithField("error", err).Error("failed to merge")
+			}
+		},
+	}
+}
+
+func convertCommand() cli.Command {
+	var (
+		srcfileFlag = cli.StringFlag{Name: "src", Usage: "gzip'd key listing to convert, in any format"}
+		dstfileFlag = cli.StringFlag{Name: "dest", Usage: "destination file where to write the converted listing"}
+		formatFlag  = cli.StringFlag{Name: "format", Value: listing.Binary, Usage: "format of the converted listing, json, binary or msgpack, defaults to the one of the dest extension (.json, .bin, .msgpack) or binary"}
+	)
+
+	return cli.Command{
+		Name:  "convert",
+		Usage: "Converts key listings between the JSON, binary and msgpack formats.",
+		Description: strings.TrimSpace(`
+Reads a gzip'd key listing and writes it in another format, also gzip'd. The
+binary format is about 5 times smaller than JSON and faster to decode, the
+msgpack format sits in between and is readable by any msgpack library. sync
+reads listings in any format. For instance:
+	brigade convert -src bucket_list.json.gz -dest bucket_list.bin.gz`),
+		Flags: []cli.Flag{srcfileFlag, dstfileFlag, formatFlag},
+		Action: func(c *cli.Context) {
+
+			srcfile := mustString(c, srcfileFlag)
+			dstfile := mustString(c, dstfileFlag)
+			format := listingFormat(c, formatFlag, dstfile)
+
+			srcf, err := os.Open(srcfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Fatal("couldn't open file")
+			}
+			defer func() { logIfErr(srcf.Close()) }()
+			srcgz, err := gzip.NewReader(srcf)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Fatal("couldn't read gzip")
+			}
+			defer func() { logIfErr(srcgz.Close()) }()
+			rd, err := listing.NewReader(srcgz)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("couldn't read listing")
+			}
+
+			dstf, err := os.Create(dstfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": dstfile,
+				}).Fatal("couldn't create destination file")
+			}
+			defer func() { logIfErr(dstf.Close()) }()
+			dstgz := gzip.NewWriter(dstf)
+			defer func() { logIfErr(dstgz.Close()) }()
+			w, err := listing.NewWriter(dstgz, format)
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Fatal("invalid format")
+			}
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			n, err := listing.Copy(w, rd)
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to convert")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"keys":   n,
+				"format": format,
+			}).Info("done converting listing")
+		},
+	}
+}
+
+func batchCommand() cli.Command {
+	var (
+		configFlag    = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys, the manifest is uploaded and the job created with the keys of the state bucket, in its region"}
+		srcfileFlag   = cli.StringFlag{Name: "src", Usage: "gzip'd key listing, or diff, in any format, or its s3://bucket/key URL in the state bucket"}
+		bucketFlag    = cli.StringFlag{Name: "bucket", Usage: "source bucket of the keys of the listing, of the form s3://name"}
+		dstfileFlag   = cli.StringFlag{Name: "dest", Usage: "file where to write the CSV manifest, or its s3://bucket/key URL in the state bucket to create a job"}
+		targetFlag    = cli.StringFlag{Name: "target", Usage: "optional s3://bucket/prefix where a batch job copies the keys of the manifest, the job is only created when it's set"}
+		roleFlag      = cli.StringFlag{Name: "role-arn", Usage: "role the batch job assumes to read the manifest and the keys, and to write the copies and the report"}
+		accountFlag   = cli.StringFlag{Name: "account-id", Usage: "ID of the AWS account the batch job is created in"}
+		reportFlag    = cli.StringFlag{Name: "report", Usage: "optional s3://bucket/prefix where the batch job writes its completion report, with the keys that failed"}
+		reportAllFlag = cli.BoolFlag{Name: "report-all", Usage: "report all the keys of the batch job, not only the ones that failed"}
+		priorityFlag  = cli.IntFlag{Name: "priority", Value: 10, Usage: "priority of the batch job among the jobs of the account, the higher the sooner"}
+		confirmFlag   = cli.BoolFlag{Name: "confirm", Usage: "hold the batch job until it's confirmed in the console, to check its manifest first"}
+	)
+
+	return cli.Command{
+		Name:  "batch",
+		Usage: "Hands the keys of a listing over to S3 Batch Operations.",
+		Description: strings.TrimSpace(`
+Writes the keys of a gzip'd key listing, such as the one of diff, as the CSV
+manifest of an S3 Batch Operations job, a bucket and URL-encoded key per
+line, for the part of a sync that is better left to AWS. Listings only hold
+the current version of the keys, so the manifest has no version column.
+
+With -target, the manifest must be written to the state bucket, and a batch
+job is created that copies its keys under the bucket and prefix of -target,
+assuming -role-arn. The same manifest creates a single job, however many
+times the command runs. For instance:
+	brigade batch -config config.json -src changed.json.gz -bucket s3://src \
+		-dest s3://state/batch/changed.csv -target s3://dst/ \
+		-account-id 123456789012 -role-arn arn:aws:iam::123456789012:role/batch`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcfileFlag,
+			bucketFlag,
+			dstfileFlag,
+			targetFlag,
+			roleFlag,
+			accountFlag,
+			reportFlag,
+			reportAllFlag,
+			priorityFlag,
+			confirmFlag,
+		},
+		Action: func(c *cli.Context) {
+			srcfile := mustString(c, srcfileFlag)
+			dstfile := mustString(c, dstfileFlag)
+			bucket := mustURL(c, bucketFlag)
+
+			var job *batch.Job
+			if target := c.String(targetFlag.Name); target != "" {
+				targetBucket, targetPrefix, ok := s3file.Parse(target)
+				if !ok {
+					logrus.WithField("target", target).Error("target must be an s3://bucket/prefix URL")
+					exitStatus = 1
+					return
+				}
+				manifestBucket, manifestKey, ok := s3file.Parse(dstfile)
+				if !ok {
+					logrus.WithField("dest", dstfile).Error("the manifest of a batch job must be written to the state bucket")
+					exitStatus = 1
+					return
+				}
+				job = &batch.Job{
+					RoleARN:              c.String(roleFlag.Name),
+					ManifestBucket:       manifestBucket,
+					ManifestKey:          manifestKey,
+					TargetBucket:         targetBucket,
+					TargetPrefix:         targetPrefix,
+					ReportAll:            c.Bool(reportAllFlag.Name),
+					Priority:             c.Int(priorityFlag.Name),
+					Description:          "brigade " + filepath.Base(srcfile),
+					ConfirmationRequired: c.Bool(confirmFlag.Name),
+				}
+				if report := c.String(reportFlag.Name); report != "" {
+					if job.ReportBucket, job.ReportPrefix, ok = s3file.Parse(report); !ok {
+						logrus.WithField("report", report).Error("report must be an s3://bucket/prefix URL")
+						exitStatus = 1
+						return
+					}
+				}
+				switch {
+				case c.String(accountFlag.Name) == "":
+					logrus.Error("need the -account-id to create the batch job in")
+					exitStatus = 1
+					return
+				case job.RoleARN == "":
+					logrus.Error("need the -role-arn of the batch job")
+					exitStatus = 1
+					return
+				}
+			}
+
+			var cfg *Config
+			_, _, srcInS3 := s3file.Parse(srcfile)
+			_, _, dstInS3 := s3file.Parse(dstfile)
+			if srcInS3 || dstInS3 {
+				cfg = mustConfig(c, configFlag)
+			}
+			srcf, _, err := openListing(cfg, srcfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Error("couldn't open listing file")
+				exitStatus = 1
+				return
+			}
+			defer func() { logIfErr(srcf.Close()) }()
+			srcgz, err := gzip.NewReader(srcf)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Error("listing file is not a gzip file")
+				exitStatus = 1
+				return
+			}
+			defer func() { logIfErr(srcgz.Close()) }()
+			rd, err := listing.NewReader(srcgz)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't read listing")
+				exitStatus = 1
+				return
+			}
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			// batch jobs only read manifests that aren't compressed, and a
+			// manifest that failed to be written mustn't be uploaded
+			var (
+				out   io.WriteCloser
+				abort func() error
+			)
+			if manifestBucket, manifestKey, ok := s3file.Parse(dstfile); ok {
+				var upload *s3file.Writer
+				upload, err = s3file.Create(setupS3Timeouts(cfg.State.S3()).Bucket(manifestBucket), manifestKey, "text/csv")
+				if err == nil {
+					out, abort = upload, upload.Abort
+				}
+			} else {
+				var file *os.File
+				if file, err = os.Create(dstfile); err == nil {
+					out, abort = file, file.Close
+				}
+			}
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": dstfile,
+				}).Error("couldn't create manifest")
+				exitStatus = 1
+				return
+			}
+			buf := bufio.NewWriter(out)
+			n, err := batch.WriteManifest(buf, rd, bucket.Host)
+			if err == nil {
+				err = buf.Flush()
+			}
+			if err == nil {
+				err = out.Close()
+			} else {
+				logIfErr(abort())
+			}
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to write manifest")
+				exitStatus = 1
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"keys":     n,
+				"manifest": dstfile,
+			}).Info("done writing manifest")
+			if job == nil {
+				return
+			}
+
+			// the job reads the version of the manifest just written
+			resp, err := setupS3Timeouts(cfg.State.S3()).Bucket(job.ManifestBucket).Head(job.ManifestKey, nil)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't get the ETag of the manifest")
+				exitStatus = 1
+				return
+			}
+			logIfErr(resp.Body.Close())
+			job.ManifestETag = resp.Header.Get("ETag")
+			id, err := cfg.State.Batch(c.String(accountFlag.Name)).CreateJob(*job)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't create batch job")
+				exitStatus = 1
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"job_id":  id,
+				"keys":    n,
+				"target":  c.String(targetFlag.Name),
+				"confirm": job.ConfirmationRequired,
+			}).Info("created batch job")
+		},
+	}
+}
+
+func estimateCommand() cli.Command {
+	var (
+		configFlag  = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys, to list a bucket"}
+		srcfileFlag = cli.StringFlag{Name: "src", Usage: "gzip'd key listing to estimate, in any format"}
+		bucketFlag  = cli.StringFlag{Name: "bucket", Usage: "bucket to list and estimate instead of a listing, of the form s3://name/path/"}
+		dstfileFlag = cli.StringFlag{Name: "dest", Usage: "optional file where to write the report as JSON, instead of stdout"}
+
+		costFlag          = cli.BoolFlag{Name: "cost", Usage: "estimate the cost in USD of the S3 requests and transfers of syncing the keys"}
+		getPutFlag        = cli.BoolFlag{Name: "get-put", Usage: "estimate the cost of a sync with -get-put instead of copies"}
+		crossRegionFlag   = cli.BoolFlag{Name: "cross-region", Usage: "estimate the cost of transferring the keys between regions"}
+		withListFlag      = cli.BoolFlag{Name: "with-list", Usage: "estimate the cost of listing the source, always done with -bucket"}
+		writePriceFlag    = cli.Float64Flag{Name: "write-price", Value: estimate.DefaultPrices.Write, Usage: "price in USD of 1000 PUT, COPY or LIST requests"}
+		transferPriceFlag = cli.Float64Flag{Name: "transfer-price", Value: estimate.DefaultPrices.TransferPerGB, Usage: "price in USD of transferring a GB between regions"}
+	)
+
+	return cli.Command{
+		Name:  "estimate",
+		Usage: "Reports the keys and bytes of a listing or a bucket.",
+		Description: strings.TrimSpace(`
+Counts the keys and bytes of a gzip'd key listing, or of a bucket as it's
+listed, to size a sync before deciding on its parallelism and timeline. The
+report has the totals, a histogram of the sizes of the keys, their counts by
+storage class, and the counts of each top-level prefix by decreasing bytes.
+
+With -cost, the report also has the approximate cost of the S3 requests and
+transfers of syncing the keys, with the us-east-1 prices of GET requests by
+storage class. Keys in classes without a price, like GLACIER, aren't in the
+cost. For instance:
+	brigade estimate -src bucket_list.json.gz
+	brigade estimate -config cfg.json -bucket s3://bucket/ -cost -cross-region`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcfileFlag,
+			bucketFlag,
+			dstfileFlag,
+			costFlag,
+			getPutFlag,
+			crossRegionFlag,
+			withListFlag,
+			writePriceFlag,
+			transferPriceFlag,
+		},
+		Action: func(c *cli.Context) {
+
+			srcfile := c.String(srcfileFlag.Name)
+			bucket := c.String(bucketFlag.Name)
+			if (srcfile == "") == (bucket == "") {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need either a listing or a bucket to estimate")
+				return
+			}
+
+			est := estimate.NewEstimator()
+			logrus.Info("starting command ", c.Command.Name)
+
+			if bucket != "" {
+				cfg := mustConfig(c, configFlag)
+				bkt := mustURL(c, bucketFlag)
+				if err := list.ListTo(setupS3Timeouts(cfg.Source.S3()), bkt.Host, bkt.Path, est); err != nil {
+					logrus.WithField("error", err).Error("failed to list bucket")
+					return
+				}
+			} else {
+				srcf, err := os.Open(srcfile)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": srcfile,
+					}).Fatal("couldn't open file")
+				}
+				defer func() { logIfErr(srcf.Close()) }()
+				srcgz, err := gzip.NewReader(srcf)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": srcfile,
+					}).Fatal("couldn't read gzip")
+				}
+				defer func() { logIfErr(srcgz.Close()) }()
+				rd, err := listing.NewReader(srcgz)
+				if err != nil {
+					logrus.WithField("error", err).Fatal("couldn't read listing")
+				}
+				if _, err := listing.Copy(est, rd); err != nil {
+					logrus.WithField("error", err).Error("failed to read listing")
+					return
+				}
+			}
+
+			report := est.Report()
+			logrus.WithFields(logrus.Fields{
+				"keys":     report.Keys,
+				"bytes":    report.Bytes,
+				"prefixes": len(report.Prefixes),
+			}).Info("done estimating")
+
+			if c.Bool(costFlag.Name) {
+				prices := estimate.DefaultPrices
+				prices.Write = c.Float64(writePriceFlag.Name)
+				prices.TransferPerGB = c.Float64(transferPriceFlag.Name)
+				cost := report.EstimateCost(estimate.Operation{
+					List:        bucket != "" || c.Bool(withListFlag.Name),
+					GetPut:      c.Bool(getPutFlag.Name),
+					CrossRegion: c.Bool(crossRegionFlag.Name),
+				}, prices)
+				report.Cost = &cost
+				logrus.WithFields(logrus.Fields{
+					"requests_usd": cost.RequestsUSD,
+					"transfer_usd": cost.TransferUSD,
+					"total_usd":    cost.TotalUSD,
+				}).Info("estimated cost")
+				if len(cost.Unpriced) > 0 {
+					logrus.WithField("storage_classes", cost.Unpriced).Warn("keys of storage classes without a price aren't in the cost")
+				}
+			}
+
+			out := io.Writer(os.Stdout)
+			if dstfile := c.String(dstfileFlag.Name); dstfile != "" {
+				file, err := os.Create(dstfile)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": dstfile,
+					}).Error("couldn't create report file")
+					return
+				}
+				defer func() { logIfErr(file.Close()) }()
+				out = file
+			}
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err == nil {
+				_, err = out.Write(append(data, '\n'))
+			}
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to write report")
+			}
+		},
+	}
+}
+
+func benchCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "scratch prefix of the source bucket where the synthetic keys are written, of the form s3://name/scratch/, which must hold no keys"}
+		destFlag        = cli.StringFlag{Name: "dest", Usage: "optional destination bucket the keys are synced to, under the same scratch prefix, of the form s3://name, defaults to the source bucket"}
+		keysFlag        = cli.IntFlag{Name: "keys", Value: 1000, Usage: "number of synthetic keys"}
+		sizeFlag        = cli.StringFlag{Name: "size", Value: "1KB", Usage: "size of each synthetic key"}
+		parallelismFlag = cli.StringFlag{Name: "parallelism", Value: "1,10,100", Usage: "comma separated parallelism of each cycle of list, sync and delete"}
+		outputFlag      = cli.StringFlag{Name: "output", Usage: "optional file where to write the results as JSON"}
+	)
+
+	return cli.Command{
+		Name:  "bench",
+		Usage: "Measures the throughput and latency of S3 on buckets, with synthetic keys.",
+		Description: strings.TrimSpace(`
+Writes synthetic keys of random content under a scratch prefix of the source
+bucket, then runs a cycle of each -parallelism: lists the keys with that many
+workers, syncs them to the destination under the same scratch prefix with as
+many sync workers, and deletes the copies with as many delete requests at
+once. The synthetic keys are deleted once done, even when a cycle fails.
+Nothing is written outside of the scratch prefix, which must hold no keys in
+either bucket, so that nothing but the keys written by bench is deleted.
+
+Each phase reports the keys done and failed, the keys and bytes per second,
+and the latency quantiles of its requests to S3, retries included, so that
+the -concurrency of the syncs between the buckets can be picked from the
+numbers of the actual buckets and regions. The results are printed as a
+table, and written as JSON to -output. For instance:
+	brigade bench -config cfg.json -src s3://src-bucket/brigade-bench/ \
+		-dest s3://dst-bucket -keys 10000 -size 64KB -parallelism 10,100,500`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcFlag,
+			destFlag,
+			keysFlag,
+			sizeFlag,
+			parallelismFlag,
+			outputFlag,
+		},
+		Action: func(c *cli.Context) {
+			cfg := mustConfig(c, configFlag)
+			src := mustURL(c, srcFlag)
+			benchCfg := bench.Config{
+				Prefix: strings.TrimPrefix(src.Path, "/"),
+				Keys:   c.Int(keysFlag.Name),
+			}
+			size, err := humanize.ParseBytes(c.String(sizeFlag.Name))
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid size")
+				exitStatus = 1
+				return
+			}
+			benchCfg.Size = int64(size)
+			for _, spec := range strings.Split(c.String(parallelismFlag.Name), ",") {
+				para, err := strconv.Atoi(strings.TrimSpace(spec))
+				if err != nil {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("parallelism", spec).Error("invalid parallelism")
+					exitStatus = 1
+					return
+				}
+				benchCfg.Parallelism = append(benchCfg.Parallelism, para)
+			}
+			if err := benchCfg.Validate(); err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid benchmark")
+				exitStatus = 1
+				return
+			}
+
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
+			destS3, destName := srcS3, src.Host
+			if c.String(destFlag.Name) != "" {
+				dest := mustURL(c, destFlag)
+				destS3, destName = setupS3Timeouts(cfg.Destination.S3()), dest.Host
+			}
+			// the connections are kept alive like those of sync -prewarm, so
+			// that the latencies are those of the requests rather than of
+			// dialing S3
+			shared := transport.New(transport.Options{
+				ConnectTimeout:      srcS3.ConnectTimeout,
+				ReadTimeout:         srcS3.ReadTimeout,
+				MaxIdleConnsPerHost: srcS3.MaxIdleConnsPerHost,
+			})
+			srcS3.Transport = shared
+			destS3.Transport = shared
+
+			b, err := bench.New(regionalBucket(srcS3, src.Host), regionalBucket(destS3, destName), benchCfg)
+			if err != nil {
+				logrus.WithField("error", err).Error("invalid benchmark")
+				exitStatus = 1
+				return
+			}
+			logrus.Info("starting command ", c.Command.Name)
+			results, err := b.Run(func(r bench.Result) {
+				logrus.WithFields(logrus.Fields{
+					"phase":          r.Phase,
+					"parallelism":    r.Parallelism,
+					"keys":           r.Keys,
+					"failed":         r.Failed,
+					"keys_per_sec":   r.KeysPerSecond,
+					"bytes_per_sec":  r.BytesPerSecond,
+					"latency_p50_ms": r.Latency["p50_ms"],
+					"latency_p99_ms": r.Latency["p99_ms"],
+				}).Info("done with phase")
+			})
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to benchmark")
+				exitStatus = 1
+			}
+			fmt.Print(bench.Table(results))
+
+			if output := c.String(outputFlag.Name); output != "" {
+				data, err := json.MarshalIndent(results, "", "  ")
+				if err == nil {
+					err = ioutil.WriteFile(output, append(data, '\n'), 0644)
+				}
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": output,
+					}).Error("failed to write results")
+					exitStatus = 1
+				}
+			}
+		},
+	}
+}
+
+func soakCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "scratch prefix of the source bucket where the synthetic keys are written, of the form s3://name/scratch/, which must hold no keys"}
+		destFlag        = cli.StringFlag{Name: "dest", Usage: "optional destination bucket the keys are synced to, under the same scratch prefix, of the form s3://name, defaults to the source bucket"}
+		keysFlag        = cli.IntFlag{Name: "keys", Value: 100, Usage: "number of synthetic keys written each round, new ones and overwrites"}
+		sizeFlag        = cli.StringFlag{Name: "size", Value: "1KB", Usage: "size of each synthetic key"}
+		durationFlag    = cli.StringFlag{Name: "duration", Value: "4h", Usage: "how long to soak, 0 for no limit but -rounds"}
+		roundsFlag      = cli.IntFlag{Name: "rounds", Usage: "optional most rounds to run"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 10, Usage: "number of keys synced and written at a time"}
+		faultsFlag      = cli.StringFlag{Name: "inject-faults", Value: "error=0.01:SlowDown,InternalError;spike=0.05:1s;drop=0.005", Usage: "faults to inject in the sync calls, see sync -inject-faults, empty for none"}
+		restartRateFlag = cli.Float64Flag{Name: "restart-rate", Value: 0.5, Usage: "fraction of the syncs cancelled midway and restarted"}
+		rotateRateFlag  = cli.Float64Flag{Name: "rotate-rate", Value: 0.25, Usage: "fraction of the syncs during which the credentials are rotated"}
+		attemptsFlag    = cli.IntFlag{Name: "attempts", Value: 10, Usage: "attempts at syncing the keys of a round, restarts included"}
+		seedFlag        = cli.IntFlag{Name: "seed", Usage: "optional seed of the workload, restarts and rotations, for a soak to do the same again"}
+		outputFlag      = cli.StringFlag{Name: "output", Usage: "optional file where to write the summary as JSON"}
+	)
+
+	return cli.Command{
+		Name:  "soak",
+		Usage: "Syncs synthetic keys for hours through faults, restarts and credential rotations, checking none is lost.",
+		Description: strings.TrimSpace(`
+Writes synthetic keys of random content under a scratch prefix of the source
+bucket, and syncs them to the destination under the same scratch prefix,
+round after round for -duration: each round writes -keys keys, new ones and
+overwrites of the keys of earlier rounds, then syncs all the keys of the
+source. The sync calls fail and slow down as -inject-faults says, and
+-restart-rate of the syncs are cancelled midway and started again with the
+keys they didn't report synced, as after a crash. -rotate-rate of the syncs
+get their credentials rotated while the requests go on: the credentials are
+fetched again, which gives new ones for sts and vault credentials.
+
+Once the keys of a round are synced, every key reported synced must have a
+copy of the same content, reported once, and every copy must have a key in
+the source. The violations are logged as they're found, lost keys, stale
+copies, duplicates and orphan copies, and brigade exits with an error if
+there was any. The keys that failed to sync after -attempts are failures,
+not violations. The keys written are deleted once done, and nothing is
+written outside of the scratch prefix, which must hold no keys in either
+bucket. A soak test on the actual buckets is the confidence to have before
+trusting brigade with a production migration. For instance:
+	brigade soak -config cfg.json -src s3://src-bucket/brigade-soak/ \
+		-dest s3://dst-bucket -duration 8h -output soak.json`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcFlag,
+			destFlag,
+			keysFlag,
+			sizeFlag,
+			durationFlag,
+			roundsFlag,
+			concurrencyFlag,
+			faultsFlag,
+			restartRateFlag,
+			rotateRateFlag,
+			attemptsFlag,
+			seedFlag,
+			outputFlag,
+		},
+		Action: func(c *cli.Context) {
+			cfg := mustConfig(c, configFlag)
+			src := mustURL(c, srcFlag)
+			soakCfg := soak.Config{
+				Prefix:      strings.TrimPrefix(src.Path, "/"),
+				Keys:        c.Int(keysFlag.Name),
+				Duration:    mustDuration(c, durationFlag),
+				Rounds:      c.Int(roundsFlag.Name),
+				Concurrency: c.Int(concurrencyFlag.Name),
+				RestartRate: c.Float64(restartRateFlag.Name),
+				RotateRate:  c.Float64(rotateRateFlag.Name),
+				Attempts:    c.Int(attemptsFlag.Name),
+				RetryBase:   time.Second,
+				Seed:        int64(c.Int(seedFlag.Name)),
+			}
+			if soakCfg.Seed == 0 {
+				soakCfg.Seed = time.Now().UnixNano()
+			}
+			size, err := humanize.ParseBytes(c.String(sizeFlag.Name))
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid size")
+				exitStatus = 1
+				return
+			}
+			soakCfg.Size = int64(size)
+			if spec := c.String(faultsFlag.Name); spec != "" {
+				soakCfg.Faults, err = sync.ParseFaults(spec)
+				if err != nil {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("error", err).Error("invalid faults to inject")
+					exitStatus = 1
+					return
+				}
+				soakCfg.Faults.Seed = soakCfg.Seed
+			}
+
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
+			destS3, destName := srcS3, src.Host
+			if c.String(destFlag.Name) != "" {
+				dest := mustURL(c, destFlag)
+				destS3, destName = setupS3Timeouts(cfg.Destination.S3()), dest.Host
+			}
+			// static keys are rotated too, fetched again like the others
+			var cached []*creds.Cached
+			for _, s := range []*s3.S3{srcS3, destS3} {
+				if s.Credentials == nil {
+					s.Credentials = creds.NewCached(creds.Static{Auth: s.Auth})
+				}
+				if p, ok := s.Credentials.(*creds.Cached); ok {
+					cached = append(cached, p)
+				}
+			}
+			soakCfg.Rotate = func() {
+				logrus.Info("rotating credentials")
+				for _, p := range cached {
+					p.Expire()
+				}
+			}
+			if err := soakCfg.Validate(); err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid soak test")
+				exitStatus = 1
+				return
+			}
+
+			s, err := soak.New(regionalBucket(srcS3, src.Host), regionalBucket(destS3, destName), soakCfg)
+			if err != nil {
+				logrus.WithField("error", err).Error("invalid soak test")
+				exitStatus = 1
+				return
+			}
+			logrus.WithField("seed", soakCfg.Seed).Info("starting command ", c.Command.Name)
+			sum, err := s.Run(func(r soak.Round) {
+				for _, v := range r.Violations {
+					logrus.WithFields(logrus.Fields{
+						"round":  v.Round,
+						"kind":   v.Kind,
+						"key":    v.Key,
+						"detail": v.Detail,
+					}).Error("invariant violated")
+				}
+				logrus.WithFields(logrus.Fields{
+					"round":      r.Round,
+					"keys":       r.Keys,
+					"written":    r.Written,
+					"synced":     r.Synced,
+					"failed":     r.Failed,
+					"attempts":   r.Attempts,
+					"restarts":   r.Restarts,
+					"rotations":  r.Rotations,
+					"violations": len(r.Violations),
+					"seconds":    r.Seconds,
+				}).Info("done with round")
+			})
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to soak")
+				exitStatus = 1
+			}
+			logrus.WithFields(logrus.Fields{
+				"rounds":     sum.Rounds,
+				"written":    sum.Written,
+				"synced":     sum.Synced,
+				"failed":     sum.Failed,
+				"restarts":   sum.Restarts,
+				"rotations":  sum.Rotations,
+				"violations": len(sum.Violations),
+				"seconds":    sum.Seconds,
+			}).Info("done soaking")
+			if len(sum.Violations) > 0 {
+				logrus.WithField("violations", len(sum.Violations)).Error("soak test found invariant violations")
+				exitStatus = 1
+			}
+
+			if output := c.String(outputFlag.Name); output != "" {
+				data, err := json.MarshalIndent(sum, "", "  ")
+				if err == nil {
+					err = ioutil.WriteFile(output, append(data, '\n'), 0644)
+				}
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": output,
+					}).Error("failed to write summary")
+					exitStatus = 1
+				}
+			}
+		},
+	}
+}
+
+func lintCommand() cli.Command {
+	var (
+		configFlag    = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys, to read a listing from the state bucket"}
+		srcfileFlag   = cli.StringFlag{Name: "src", Usage: "gzip'd key listing to lint, in any format, or its s3://bucket/key URL in the state bucket"}
+		dstfileFlag   = cli.StringFlag{Name: "dest", Usage: "optional file where to write the keys that have problems, instead of stdout"}
+		normalizeFlag = cli.BoolFlag{Name: "normalize", Usage: "also write the name each key would be synced under with sync -normalize-keys"}
+		mapFlag       = cli.StringFlag{Name: "map", Usage: "optional from=to prefix mapping applied to the keys before normalizing them, like sync -map"}
+	)
+
+	return cli.Command{
+		Name:  "lint",
+		Usage: "Reports the keys of a listing likely to cause problems.",
+		Description: strings.TrimSpace(`
+Checks the keys of a gzip'd key listing for the names that are likely to
+cause problems when syncing them, or when using them once synced: keys with
+control characters, with white space at the end of a segment, longer than
+1024 bytes, or that aren't valid UTF-8. Each key that has problems is
+written as a line of JSON, with its problems and the key quoted with its
+bytes escaped, and the counts of the problems are logged. The command fails
+when keys have problems, so it can check a listing before a sync.
+
+With -normalize, the keys also have the name sync -normalize-keys would
+copy them under. Keys can normalize to the same name, which -existing can
+guard against during the sync. For instance:
+	brigade lint -src bucket_list.json.gz -normalize -map legacy/=shard-1/`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcfileFlag,
+			dstfileFlag,
+			normalizeFlag,
+			mapFlag,
+		},
+		Action: func(c *cli.Context) {
+			srcfile := mustString(c, srcfileFlag)
+			var normalize func(string) string
+			if c.Bool(normalizeFlag.Name) {
+				mapping := sync.Mapping{Normalize: true}
+				if spec := c.String(mapFlag.Name); spec != "" {
+					m, err := sync.ParseMapping(spec)
+					if err != nil {
+						logrus.WithField("error", err).Error("invalid mapping")
+						return
+					}
+					mapping.From, mapping.To = m.From, m.To
+				}
+				normalize = mapping.Map
+			}
+
+			var cfg *Config
+			if _, _, ok := s3file.Parse(srcfile); ok {
+				cfg = mustConfig(c, configFlag)
+			}
+			srcf, _, err := openListing(cfg, srcfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Error("couldn't open listing file")
+				return
+			}
+			defer func() { logIfErr(srcf.Close()) }()
+			srcgz, err := gzip.NewReader(srcf)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Error("listing file is not a gzip file")
+				return
+			}
+			defer func() { logIfErr(srcgz.Close()) }()
+			rd, err := listing.NewReader(srcgz)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't read listing")
+				return
+			}
+
+			out := io.Writer(os.Stdout)
+			if dstfile := c.String(dstfileFlag.Name); dstfile != "" {
+				file, err := os.Create(dstfile)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": dstfile,
+					}).Error("couldn't create findings file")
+					return
+				}
+				defer func() { logIfErr(file.Close()) }()
+				out = file
+			}
+			buf := bufio.NewWriter(out)
+			defer func() { logIfErr(buf.Flush()) }()
+
+			logrus.Info("starting command ", c.Command.Name)
+			linter := lint.NewLinter(buf, normalize)
+			if _, err := listing.Copy(linter, rd); err != nil {
+				logrus.WithField("error", err).Error("failed to lint listing")
+				exitStatus = 1
+				return
+			}
+			report := linter.Report()
+			log := logrus.WithFields(logrus.Fields{
+				"keys":     report.Keys,
+				"findings": report.Findings,
+			})
+			for problem, n := range report.Problems {
+				log = log.WithField(problem, n)
+			}
+			if report.Findings > 0 {
+				log.Error("some keys are likely to cause problems")
+				exitStatus = 1
+				return
+			}
+			log.Info("done linting, no key has problems")
+		},
+	}
+}
+
+func driftCommand() cli.Command {
+	var (
+		configFlag    = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys, the inventory is read with the keys of the destination bucket"}
+		inputFlag     = cli.StringFlag{Name: "input", Usage: "gzip'd listing of the synced keys, in any format, or its s3://bucket/key URL in the state bucket, comma separated for many listings"}
+		manifestFlag  = cli.StringFlag{Name: "manifest", Usage: "optional manifest of the sync, written by sync -manifest, to compare the listings it read with its mappings instead of -input"}
+		inventoryFlag = cli.StringFlag{Name: "inventory", Usage: "s3://bucket/prefix/ of the inventory of the destination, as prefix/destination-bucket/inventory-id/, whose latest report is compared, or the s3:// URL of the manifest.json of a report"}
+		dstfileFlag   = cli.StringFlag{Name: "dest", Usage: "optional file where to write the keys that drifted, instead of stdout"}
+		mapFlag       = cli.StringFlag{Name: "map", Usage: "optional comma separated from=to prefix mappings, one per listing, like sync -map"}
+		extraFlag     = cli.BoolFlag{Name: "extra", Usage: "also report the keys of the inventory that weren't synced"}
+	)
+
+	return cli.Command{
+		Name:  "drift",
+		Usage: "Compares the synced keys to the latest S3 Inventory of the destination.",
+		Description: strings.TrimSpace(`
+Compares the listings of the keys a sync copied to the latest S3 Inventory
+report of the destination, to find the keys that drifted since the sync:
+the keys missing from the inventory, and the keys with another size or
+ETag in it. The keys whose ETag is of a multipart upload are only compared
+by size. Each key that drifted is written as a line of JSON, with how it
+was listed and how it's inventoried, and the counts are logged. The command
+fails when keys drifted, so it can check a migration on a schedule.
+
+The listings are the ones given to the sync, or the success listings it
+wrote, with the same -map, or the ones of the manifest of the sync, with
+its mappings. The inventory must be in CSV. For instance:
+	brigade drift -config conf.json -manifest sync.json \
+		-inventory s3://inventory-bucket/inventory/dst-bucket/daily/`),
+		Flags: []cli.Flag{
+			configFlag,
+			inputFlag,
+			manifestFlag,
+			inventoryFlag,
+			dstfileFlag,
+			mapFlag,
+			extraFlag,
+		},
+		Action: func(c *cli.Context) {
+			cfg := mustConfig(c, configFlag)
+			inventoryURL := mustString(c, inventoryFlag)
+			inputFilename := c.String(inputFlag.Name)
+			mapSpec := c.String(mapFlag.Name)
+			normalize := false
+			if filename := c.String(manifestFlag.Name); filename != "" {
+				run, err := manifest.Read(filename)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": filename,
+					}).Error("couldn't read manifest of the sync")
+					return
+				}
+				if inputFilename == "" {
+					inputFilename = run.Flags["input"]
+				}
+				if mapSpec == "" {
+					mapSpec = run.Flags["map"]
+				}
+				normalize = run.Flags["normalize-keys"] == "true"
+			}
+			if inputFilename == "" {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need the listings of the sync, or its manifest")
+				return
+			}
+			inputFilenames := strings.Split(inputFilename, ",")
+			mappings := make([]sync.Mapping, len(inputFilenames))
+			if mapSpec != "" {
+				specs := strings.Split(mapSpec, ",")
+				if len(specs) != len(inputFilenames) {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("mappings", mapSpec).Error("need a mapping per listing")
+					return
+				}
+				for i, spec := range specs {
+					m, err := sync.ParseMapping(spec)
+					if err != nil {
+						logrus.WithField("error", err).Error("invalid mapping")
+						return
+					}
+					mappings[i] = m
+				}
+			}
+			for i := range mappings {
+				mappings[i].Normalize = normalize
+			}
+
+			bucket, prefix, ok := s3file.Parse(inventoryURL)
+			if !ok {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("inventory", inventoryURL).Error("inventory must be an s3:// URL")
+				return
+			}
+			inventoryBkt := setupS3Timeouts(cfg.Destination.S3()).Bucket(bucket)
+			manifestKey := prefix
+			if !strings.HasSuffix(prefix, drift.ManifestName) {
+				var err error
+				if manifestKey, err = drift.LatestManifest(inventoryBkt, prefix); err != nil {
+					logrus.WithField("error", err).Error("couldn't find the latest inventory report")
+					return
+				}
+			}
+			manifestFile, err := s3file.Open(inventoryBkt, manifestKey)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"key":   manifestKey,
+				}).Error("couldn't open inventory manifest")
+				return
+			}
+			inventory, err := drift.ParseManifest(manifestFile)
+			logIfErr(manifestFile.Close())
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't read inventory manifest")
+				return
+			}
+
+			out := io.Writer(os.Stdout)
+			if dstfile := c.String(dstfileFlag.Name); dstfile != "" {
+				file, err := os.Create(dstfile)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": dstfile,
+					}).Error("couldn't create drift file")
+					return
+				}
+				defer func() { logIfErr(file.Close()) }()
+				out = file
+			}
+			buf := bufio.NewWriter(out)
+			defer func() { logIfErr(buf.Flush()) }()
+
+			logrus.Info("starting command ", c.Command.Name)
+			detector := drift.NewDetector(buf, c.Bool(extraFlag.Name))
+			for i, name := range inputFilenames {
+				if err := readListing(cfg, name, func(rd listing.Reader) error {
+					return detector.Listed(rd, mappings[i].Map)
+				}); err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": name,
+					}).Error("couldn't read listing of the synced keys")
+					exitStatus = 1
+					return
+				}
+			}
+			logrus.WithFields(logrus.Fields{
+				"report": manifestKey,
+				"files":  len(inventory.Files),
+			}).Info("reading inventory")
+			for _, f := range inventory.Files {
+				if err := readInventoryFile(inventoryBkt, f.Key, inventory.Schema(), detector); err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error": err,
+						"key":   f.Key,
+					}).Error("couldn't read inventory file")
+					exitStatus = 1
+					return
+				}
+			}
+			report, err := detector.Finish()
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't write drifted keys")
+				exitStatus = 1
+				return
+			}
+			log := logrus.WithFields(logrus.Fields{
+				"listed":      report.Listed,
+				"inventoried": report.Inventoried,
+				"missing":     report.Missing,
+				"changed":     report.Changed,
+				"extra":       report.Extra,
+			})
+			if report.Drifted() > 0 {
+				log.Error("some keys drifted since the sync")
+				exitStatus = 1
+				return
+			}
+			log.Info("done comparing, no key drifted")
+		},
+	}
+}
+
+func compareCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys, to list the buckets or read the listings in the state bucket"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "source bucket to list, of the form s3://name/path/"}
+		srcListFlag     = cli.StringFlag{Name: "src-list", Usage: "gzip'd listing of the source instead of -src, in any format, or its s3://bucket/key URL in the state bucket"}
+		destFlag        = cli.StringFlag{Name: "dest", Usage: "destination bucket to list, of the form s3://name/path/"}
+		destListFlag    = cli.StringFlag{Name: "dest-list", Usage: "gzip'd listing of the destination instead of -dest, in any format, or its s3://bucket/key URL in the state bucket"}
+		mapFlag         = cli.StringFlag{Name: "map", Usage: "optional from=to prefix mapping of the source keys to the destination, like sync -map"}
+		samplesFlag     = cli.IntFlag{Name: "samples", Value: 10, Usage: "how many keys of each difference are in the report"}
+		outputFlag      = cli.StringFlag{Name: "output", Usage: "optional file where to write the report as JSON, instead of stdout"}
+		ignoreExtraFlag = cli.BoolFlag{Name: "ignore-extra", Usage: "don't fail when the destination holds keys that aren't in the source"}
+		tolerateFlag    = cli.StringFlag{Name: "tolerate", Value: compare.ToleranceMultipart, Usage: "comma separated differences that don't count, of last-modified, multipart and metadata, or none when empty"}
+		checksumFlag    = cli.StringFlag{Name: "checksum", Usage: "optional hash, of md5, sha256, xxhash or crc32c, comparing the keys that carry a sum of it in their user metadata by their sums rather than their ETags, as hash:name when the metadata isn't named after the hash"}
+	)
+
+	return cli.Command{
+		Name:  "compare",
+		Usage: "Checks that a destination holds the keys of a source, with the same content.",
+		Description: strings.TrimSpace(`
+Compares the keys of a source and a destination, from listings of them or by
+listing the buckets, and reports the keys compared, the ones that match, the
+ones missing from the destination, the extra ones in it, and the ones whose
+size or ETag differ, with a sample of the keys of each difference. Nothing
+is written to the buckets. The destination is held in memory while the source
+is streamed.
+
+The keys that match are also checked for the differences that -tolerate
+doesn't tolerate, a comma separated list of:
+	multipart      the keys whose ETag is of a multipart upload are only
+	               compared by size, as a copy doesn't keep it (the default)
+	last-modified  the keys of the destination last modified before those
+	               of the source aren't stale
+	metadata       the Content-Type and user metadata of the keys aren't
+	               compared, they are when both listings are enriched with
+	               them, such as by head
+After a previous migration, tolerating its differences keeps them from
+hiding the others. For instance -tolerate multipart,last-modified. An empty
+-tolerate compares everything.
+
+With -checksum, the keys and copies that both carry a sum of a hash of
+their content in their user metadata, such as sha256:content-sha256, are
+compared by their sums rather than by their ETags, and those that differ
+are reported as checksum mismatches. Keys uploaded in parts are then
+compared by their content without reading them. The listings must be
+enriched with the metadata, such as by head.
+
+The report is written as JSON, and the command exits with status 0 when the
+destination matches the source, 1 when it differs, and 2 when the comparison
+couldn't be done, so it can gate a migration. With -ignore-extra, the extra
+keys are reported but don't fail the comparison. For instance:
+	brigade compare -config conf.json -src s3://src-bucket/ \
+		-dest-list dst_list.json.gz -map legacy/=shard-1/`),
+		Flags: []cli.Flag{
+			configFlag,
+			srcFlag,
+			srcListFlag,
+			destFlag,
+			destListFlag,
+			mapFlag,
+			samplesFlag,
+			outputFlag,
+			ignoreExtraFlag,
+			tolerateFlag,
+			checksumFlag,
+		},
+		Action: func(c *cli.Context) {
+			// status of a comparison that couldn't be done, distinct from
+			// one that found differences
+			const failed = 2
+
+			src, srcList := c.String(srcFlag.Name), c.String(srcListFlag.Name)
+			dest, destList := c.String(destFlag.Name), c.String(destListFlag.Name)
+			if (src == "") == (srcList == "") || (dest == "") == (destList == "") {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need either a bucket or a listing for both the source and the destination")
+				exitStatus = failed
+				return
+			}
+			var cfg *Config
+			if src != "" || dest != "" || c.String(configFlag.Name) != "" {
+				cfg = mustConfig(c, configFlag)
+			}
+			var mapKey func(string) string
+			if spec := c.String(mapFlag.Name); spec != "" {
+				m, err := sync.ParseMapping(spec)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid mapping")
+					exitStatus = failed
+					return
+				}
+				mapKey = m.Map
+			}
+			tolerance, err := compare.ParseTolerance(c.String(tolerateFlag.Name))
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid tolerance")
+				exitStatus = failed
+				return
+			}
+			samples := c.Int(samplesFlag.Name)
+			if samples < 0 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("samples", samples).Error("samples can't be negative")
+				exitStatus = failed
+				return
+			}
+			var sum *checksum.Checksum
+			if spec := c.String(checksumFlag.Name); spec != "" {
+				parsed, err := checksum.ParseChecksum(spec)
+				if err != nil {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					logrus.WithField("error", err).Error("invalid checksum")
+					exitStatus = failed
+					return
+				}
+				sum = &parsed
+			}
+
+			out := io.Writer(os.Stdout)
+			if output := c.String(outputFlag.Name); output != "" {
+				file, err := os.Create(output)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": output,
+					}).Error("couldn't create report file")
+					exitStatus = failed
+					return
+				}
+				defer func() { logIfErr(file.Close()) }()
+				out = file
+			}
+
+			logrus.WithField("tolerate", tolerance.String()).Info("starting command ", c.Command.Name)
+			comparer := compare.New(mapKey, samples)
+			comparer.Tolerance = tolerance
+			comparer.Checksum = sum
+			// the destination is read first, as it's held in memory
+			read := func(bucket, listingName string, sss func() *s3.S3, w compare.Writer) error {
+				if bucket != "" {
+					u, err := url.Parse(bucket)
+					if err != nil || u.Host == "" {
+						return fmt.Errorf("not a valid bucket url %q", bucket)
+					}
+					return list.ListTo(setupS3Timeouts(sss()), u.Host, u.Path, w)
+				}
+				return readListing(cfg, listingName, func(rd listing.Reader) error {
+					_, err := listing.CopyEnriched(w, rd)
+					return err
+				})
+			}
+			if err := read(dest, destList, func() *s3.S3 { return cfg.Destination.S3() }, comparer.Destination()); err != nil {
+				logrus.WithField("error", err).Error("couldn't read the keys of the destination")
+				exitStatus = failed
+				return
+			}
+			if err := read(src, srcList, func() *s3.S3 { return cfg.Source.S3() }, comparer.Source()); err != nil {
+				logrus.WithField("error", err).Error("couldn't read the keys of the source")
+				exitStatus = failed
+				return
+			}
+			report := comparer.Finish()
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err == nil {
+				_, err = out.Write(append(data, '\n'))
+			}
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to write report")
+				exitStatus = failed
+				return
+			}
+			log := logrus.WithFields(logrus.Fields{
+				"source":            report.Source,
+				"destination":       report.Destination,
+				"matching":          report.Matching,
+				"missing":           report.Missing,
+				"extra":             report.Extra,
+				"size_mismatch":     report.SizeMismatch,
+				"etag_mismatch":     report.ETagMismatch,
+				"checksum_mismatch": report.ChecksumMismatch,
+				"metadata_mismatch": report.MetadataMismatch,
+				"stale":             report.Stale,
+			})
+			if !report.Same(c.Bool(ignoreExtraFlag.Name)) {
+				log.Error("the destination differs from the source")
+				exitStatus = 1
+				return
+			}
+			log.Info("done comparing, the destination matches the source")
+		},
+	}
+}
+
+func auditCommand() cli.Command {
+	var (
+		configFlag      = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		inputFlag       = cli.StringFlag{Name: "input", Usage: "gzip'd listing of the keys to audit, in any format, which are expected in both buckets, or its s3://bucket/key URL in the state bucket"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "source bucket of the keys, of the form s3://name/"}
+		destFlag        = cli.StringFlag{Name: "dest", Usage: "destination bucket of the copies, of the form s3://name/"}
+		mapFlag         = cli.StringFlag{Name: "map", Usage: "optional from=to prefix mapping of the source keys to the destination, like sync -map"}
+		attributesFlag  = cli.StringFlag{Name: "attributes", Value: strings.Join(audit.Attributes, ","), Usage: "comma separated attributes to compare, of " + strings.Join(audit.Attributes, ", ")}
+		grantIDsFlag    = cli.StringFlag{Name: "grant-ids", Usage: "optional file of the canonical IDs of accounts at the source and of those that replace them at the destination, a pair per line, like sync -grant-ids"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 100, Usage: "number of keys audited at once"}
+		outputFlag      = cli.StringFlag{Name: "output", Usage: "optional file where to write the drifts as JSON, one per line, instead of stdout"}
+	)
+
+	return cli.Command{
+		Name:  "audit",
+		Usage: "Checks that the copies of keys have their ACL, tags and metadata.",
+		Description: strings.TrimSpace(`
+Reads the keys of a listing, such as the success output of a sync, and
+compares the attributes of each to those of its copy, many keys at once:
+	acl       the grants of their ACLs, with a request per key and copy
+	tags      their tags, with a request per key or copy that has some
+	metadata  their Content-Type and user metadata, read with a HEAD
+Compare and diff check that the copies hold the data of the keys, audit
+checks that they're exposed and described like them. -grant-ids maps the
+grantees of the source to those of the destination before the ACLs are
+compared, like sync -grant-ids. Nothing is written to the buckets.
+
+Each attribute that differs is written as a JSON line with the key and the
+attribute at the source and at the destination, and copies that don't exist
+as a missing drift. The command exits with status 0 when the copies have the
+attributes of their keys, 1 when they differ, and 2 when the audit couldn't
+be done. For instance:
+	brigade audit -config conf.json -input synced.json.gz \
+		-src s3://src-bucket/ -dest s3://dst-bucket/ -attributes acl,tags`),
+		Flags: []cli.Flag{
+			configFlag,
+			inputFlag,
+			srcFlag,
+			destFlag,
+			mapFlag,
+			attributesFlag,
+			grantIDsFlag,
+			concurrencyFlag,
+			outputFlag,
+		},
+		Action: func(c *cli.Context) {
+			// status of an audit that couldn't be done, distinct from one
+			// that found drifts
+			const failed = 2
+
+			inputFilename := mustString(c, inputFlag)
+			cfg := mustConfig(c, configFlag)
+			src, dest := mustURLs(c, srcFlag), mustURLs(c, destFlag)
+			if len(src) != 1 || len(dest) != 1 {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need a single source and a single destination")
+				exitStatus = failed
+				return
+			}
+			attrs, err := audit.ParseAttributes(c.String(attributesFlag.Name))
+			if err != nil {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.WithField("error", err).Error("invalid attributes")
+				exitStatus = failed
+				return
+			}
+
+			auditor := audit.New(
+				regionalBucket(setupS3Timeouts(cfg.Source.S3()), src[0].Host),
+				regionalBucket(setupS3Timeouts(cfg.Destination.S3()), dest[0].Host),
+			)
+			auditor.Para = c.Int(concurrencyFlag.Name)
+			auditor.Attributes = attrs
+			if spec := c.String(mapFlag.Name); spec != "" {
+				m, err := sync.ParseMapping(spec)
+				if err != nil {
+					logrus.WithField("error", err).Error("invalid mapping")
+					exitStatus = failed
+					return
+				}
+				auditor.MapKey = m.Map
+			}
+			if name := c.String(grantIDsFlag.Name); name != "" {
+				ids, err := readGrantIDs(name)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": name,
+					}).Error("couldn't read the IDs of the grantees")
+					exitStatus = failed
+					return
+				}
+				auditor.Grants.IDs = ids
+			}
+
+			out := io.Writer(os.Stdout)
+			if output := c.String(outputFlag.Name); output != "" {
+				file, err := os.Create(output)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": output,
+					}).Error("couldn't create drifts file")
+					exitStatus = failed
+					return
+				}
+				defer func() { logIfErr(file.Close()) }()
+				out = file
+			}
+
+			logrus.WithField("attributes", strings.Join(attrs, ",")).Info("starting command ", c.Command.Name)
+			var report audit.Report
+			err = readListing(cfg, inputFilename, func(rd listing.Reader) error {
+				var err error
+				report, err = auditor.Run(rd, out)
+				return err
+			})
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't audit the keys")
+				exitStatus = failed
+				return
+			}
+			log := logrus.WithFields(logrus.Fields{
+				"keys":    report.Keys,
+				"audited": report.Audited,
+				"drifted": report.Drifted,
+				"missing": report.Missing,
+				"failed":  report.Failed,
+			})
+			for _, attr := range audit.Attributes {
+				log = log.WithField(attr, report.Drifts[attr])
+			}
+			switch {
+			case report.Failed > 0:
+				log.Error("some keys couldn't be audited")
+				exitStatus = failed
+			case !report.Same():
+				log.Error("the copies drifted from their keys")
+				exitStatus = 1
+			default:
+				log.Info("done auditing, the copies have the attributes of their keys")
+			}
+		},
+	}
+}
+
+// countDeletes counts the keys of the listing of the keys to delete, and
+// those of the bucket, from its listing if given or by listing it.
+func countDeletes(cfg *Config, inputFilename string, sss *s3.S3, bkt *url.URL, bucketList string) (deletes *keyCounter, keys int64, err error) {
+	deletes, err = countListing(cfg, inputFilename)
+	if err != nil {
+		return nil, 0, err
+	}
+	if bucketList != "" {
+		counter, err := countListing(cfg, bucketList)
+		if err != nil {
+			return nil, 0, err
+		}
+		return deletes, counter.n, nil
+	}
+	counter := &keyCounter{}
+	if err := list.ListTo(sss, bkt.Host, bkt.Path, counter); err != nil {
+		return nil, 0, fmt.Errorf("listing bucket %q: %v", bkt.Host, err)
+	}
+	return deletes, counter.n, nil
+}
+
+// countListing counts the keys of a listing and their bytes.
+func countListing(cfg *Config, name string) (*keyCounter, error) {
+	counter := &keyCounter{}
+	err := readListing(cfg, name, func(rd listing.Reader) error {
+		_, err := listing.Copy(counter, rd)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading listing %q: %v", name, err)
+	}
+	return counter, nil
+}
+
+// keyCounter is a listing.Writer that counts the keys and their bytes.
+type keyCounter struct{ n, bytes int64 }
+
+func (c *keyCounter) Write(k s3.Key) error { c.n++; c.bytes += k.Size; return nil }
+func (c *keyCounter) Flush() error         { return nil }
+
+// readListing reads a gzip'd listing by name, in any format, from a file or
+// an s3:// URL.
+func readListing(cfg *Config, name string, read func(listing.Reader) error) error {
+	file, _, err := openListing(cfg, name)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(file.Close()) }()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(gz.Close()) }()
+	rd, err := listing.NewReader(gz)
+	if err != nil {
+		return err
+	}
+	return read(rd)
+}
+
+// readInventoryFile compares the keys of a gzip'd file of an inventory.
+func readInventoryFile(bkt *s3.Bucket, key string, schema []string, detector *drift.Detector) error {
+	file, err := s3file.Open(bkt, key)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(file.Close()) }()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(gz.Close()) }()
+	rd, err := drift.NewInventoryReader(gz, schema)
+	if err != nil {
+		return err
+	}
+	return detector.Inventory(rd)
+}
+
+func planCommand() cli.Command {
+	var (
+		srcfileFlag       = cli.StringFlag{Name: "src", Usage: "gzip'd key listing to split, in any format"}
+		dirFlag           = cli.StringFlag{Name: "dir", Usage: "directory where to write the plan and the listings of its partitions"}
+		formatFlag        = cli.StringFlag{Name: "format", Value: listing.Binary, Usage: "format of the listings of the partitions, json, binary or msgpack"}
+		maxPartitionsFlag = cli.IntFlag{Name: "max-partitions", Value: 256, Usage: "maximum number of top-level prefixes, each partition's listing is open while splitting"}
+		concurrencyFlag   = cli.IntFlag{Name: "concurrency", Value: 1000, Usage: "number of concurrent sync requests shared by the partitions, in proportion of their keys"}
+	)
+
+	return cli.Command{
+		Name:  "plan",
+		Usage: "Splits a key listing into partitions by top-level prefix.",
+		Description: strings.TrimSpace(`
+Splits a gzip'd key listing into partitions, one per top-level prefix, for
+'execute' to sync independently. Each partition gets its own listing and a
+share of the sync workers in proportion of its keys. The plan is saved in the
+directory as plan.json, where the keys, bytes, synced and failed counts of
+each partition are reported. For instance:
+	brigade plan -src bucket_list.json.gz -dir plan/`),
+		Flags: []cli.Flag{srcfileFlag, dirFlag, formatFlag, maxPartitionsFlag, concurrencyFlag},
+		Action: func(c *cli.Context) {
+
+			srcfile := mustString(c, srcfileFlag)
+			dir := mustString(c, dirFlag)
+
+			srcf, err := os.Open(srcfile)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Fatal("couldn't open file")
+			}
+			defer func() { logIfErr(srcf.Close()) }()
+			srcgz, err := gzip.NewReader(srcf)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": srcfile,
+				}).Fatal("couldn't read gzip")
+			}
+			defer func() { logIfErr(srcgz.Close()) }()
+			rd, err := listing.NewReader(srcgz)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("couldn't read listing")
+			}
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			p, err := plan.Split(rd, dir, c.String(formatFlag.Name), c.Int(maxPartitionsFlag.Name), c.Int(concurrencyFlag.Name))
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to plan")
+				return
+			}
+			for _, part := range p.Partitions {
+				logrus.WithFields(logrus.Fields{
+					"prefix":  part.Prefix,
+					"keys":    part.Keys,
+					"bytes":   part.Bytes,
+					"workers": part.Workers,
+				}).Info("partition")
+			}
+			logrus.WithField("partitions", len(p.Partitions)).Info("done planning")
+		},
+	}
+}
+
+func executeCommand() cli.Command {
+	var (
+		configFlag   = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		dirFlag      = cli.StringFlag{Name: "dir", Usage: "directory of the plan created by 'plan'"}
+		srcFlag      = cli.StringFlag{Name: "src", Usage: "source bucket to get the keys from"}
+		dstFlag      = cli.StringFlag{Name: "dest", Usage: "destination bucket to put the keys into"}
+		parallelFlag = cli.IntFlag{Name: "parallel", Value: 4, Usage: "number of partitions sync'd at once"}
+		fsyncFlag    = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the outputs of the partitions are flushed to disk, 0 to only flush on completion"}
+	)
+
+	return cli.Command{
+		Name:  "execute",
+		Usage: "Syncs the partitions of a plan.",
+		Description: strings.TrimSpace(`
+Syncs the partitions of a plan created by 'plan' from a source bucket to a
+destination bucket, each with its own workers, and its own state file as a
+checkpoint. Partitions that are done are skipped when a plan is executed
+again, and the keys of interrupted partitions that were already synced are
+skipped. The synced and failed keys of each partition are written next to
+its listing, and its counts are reported in plan.json.`),
+		Flags: []cli.Flag{configFlag, dirFlag, srcFlag, dstFlag, parallelFlag, fsyncFlag},
+		Action: func(c *cli.Context) {
+
+			cfg := mustConfig(c, configFlag)
+			dir := mustString(c, dirFlag)
+			src := mustURL(c, srcFlag)
+			dest := mustURL(c, dstFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+
+			p, err := plan.Load(dir)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't load plan")
+				return
+			}
+
+			srcBkt := regionalBucket(setupS3Timeouts(cfg.Source.S3()), src.Host)
+			destBkt := regionalBucket(setupS3Timeouts(cfg.Destination.S3()), dest.Host)
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			err = p.Execute(c.Int(parallelFlag.Name), func(part *plan.Partition) error {
+				return executePartition(srcBkt, destBkt, dir, part, fsyncEvery)
+			})
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to execute plan")
+				exitStatus = 1
+			}
+		},
+	}
+}
+
+// executePartition syncs the keys of a partition of a plan, resuming from
+// its state file.
+func executePartition(src, dst *s3.Bucket, dir string, part *plan.Partition, fsyncEvery time.Duration) error {
+	listfile, err := os.Open(part.Listing(dir))
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(listfile.Close()) }()
+	input, err := gzip.NewReader(listfile)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(input.Close()) }()
+
+	store, err := state.Open(part.State(dir))
+	if err != nil {
+		return err
+	}
+	store.SyncEvery(fsyncEvery)
+	defer func() { logIfErr(store.Close()) }()
+
+	synced, sucCloser, err := createOutput(nil, part.SyncedFile(dir), fsyncEvery)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(sucCloser()) }()
+	failed, failCloser, err := createOutput(nil, part.FailedFile(dir), fsyncEvery)
+	if err != nil {
+		return err
+	}
+	defer func() { logIfErr(failCloser()) }()
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(part.Workers), sync.WithState(store), sync.WithRunID(runID))
+	if err != nil {
+		return err
+	}
+	if err := syncTask.Start(input, synced, failed); err != nil {
+		return err
+	}
+	// keys skipped were synced by an earlier, interrupted execution
+	progress := syncTask.Progress()
+	part.Synced = progress.Synced + progress.Skipped
+	part.Failed = progress.Failed
+	return nil
+}
+
+func statusCommand() cli.Command {
+	var (
+		stateFlag     = cli.StringFlag{Name: "state", Usage: "state file recorded by 'sync -state'"}
+		progressFlag  = cli.StringFlag{Name: "progress", Usage: "progress file written by 'sync -progress-file', to report on a running sync instead of a state file"}
+		pendingFlag   = cli.BoolFlag{Name: "pending", Usage: "select the keys that were pending"}
+		syncedFlag    = cli.BoolFlag{Name: "synced", Usage: "select the keys that were synced"}
+		failedFlag    = cli.BoolFlag{Name: "failed", Usage: "select the keys that failed to sync"}
+		errorCodeFlag = cli.StringFlag{Name: "error-code", Usage: "only select the keys that failed with this S3 error code"}
+		dstfileFlag   = cli.StringFlag{Name: "dest", Usage: "optional file where to write the selected keys, as a listing"}
+		compactFlag   = cli.BoolFlag{Name: "compact", Usage: "rewrite the state file with only the last record of each key"}
+		configFlag    = cli.StringFlag{Name: "config", Usage: "optional JSON file containing AWS keys, to decrypt the data key of a state file encrypted with 'sync -state-kms-key' with the keys of the state bucket"}
+		passFlag      = cli.StringFlag{Name: "state-passphrase-file", Usage: "optional file holding the passphrase of a state file encrypted with 'sync -state-passphrase-file'"}
+	)
+
+	return cli.Command{
+		Name:  "status",
+		Usage: "Queries the state file of a sync.",
+		Description: strings.TrimSpace(`
+Reads the state recorded by 'sync -state' and counts the keys in each status.
+The keys selected by the filters are written to a gzip'd listing, which can be
+used as the input of another sync to re-drive them. For instance:
+	brigade status -state sync.state -failed -error-code SlowDown -dest redrive.json.gz
+
+With -progress, reports the counters, rates and ETA of the last snapshot
+written by 'sync -progress-file', and when it was written.
+
+An encrypted state file is read with the passphrase it was encrypted with,
+or with the KMS key whose data key encrypted it, called with the keys of the
+state bucket of -config.`),
+		Flags: []cli.Flag{stateFlag, progressFlag, pendingFlag, syncedFlag, failedFlag, errorCodeFlag, dstfileFlag, compactFlag, configFlag, passFlag},
+		Action: func(c *cli.Context) {
+
+			if progressFilename := c.String(progressFlag.Name); progressFilename != "" {
+				reportProgress(progressFilename)
+				return
+			}
+
+			stateFilename := mustString(c, stateFlag)
+			errorCode := c.String(errorCodeFlag.Name)
+			dstfile := c.String(dstfileFlag.Name)
+
+			wantStatus := map[state.Status]bool{
+				state.Pending: c.Bool(pendingFlag.Name),
+				state.Synced:  c.Bool(syncedFlag.Name),
+				state.Failed:  c.Bool(failedFlag.Name),
+			}
+
+			var stateEnc *state.Encryption
+			encrypted, err := state.IsEncrypted(stateFilename)
+			if err != nil && !os.IsNotExist(err) {
+				logrus.WithField("error", err).Fatal("couldn't read state file")
+			}
+			switch {
+			case !encrypted:
+			case c.String(passFlag.Name) != "":
+				if stateEnc, err = stateEncryption(nil, c.String(passFlag.Name), ""); err != nil {
+					logrus.WithField("error", err).Fatal("couldn't read passphrase of state file")
+				}
+			case c.String(configFlag.Name) != "":
+				stateEnc = &state.Encryption{KMS: mustConfig(c, configFlag).State.KMS("")}
+			default:
+				logrus.Fatal("state file is encrypted, need its -state-passphrase-file, or a -config to decrypt its data key with KMS")
+			}
+			store, err := openState(stateFilename, stateEnc)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("couldn't open state file")
+			}
+			defer func() { logIfErr(store.Close()) }()
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			var enc *json.Encoder
+			if dstfile != "" {
+				dstf, err := os.Create(dstfile)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{
+						"error":    err,
+						"filename": dstfile,
+					}).Fatal("couldn't create destination file")
+				}
+				defer func() { logIfErr(dstf.Close()) }()
+				dstgz := gzip.NewWriter(dstf)
+				defer func() { logIfErr(dstgz.Close()) }()
+				enc = json.NewEncoder(dstgz)
+			}
+
+			counts := make(map[state.Status]int)
+			codes := make(map[string]int)
+			selected := 0
+			err = store.Each(func(rec state.Record) bool {
+				counts[rec.Status]++
+				if rec.ErrorCode != "" {
+					codes[rec.ErrorCode]++
+				}
+				if !wantStatus[rec.Status] || (errorCode != "" && rec.ErrorCode != errorCode) {
+					return true
+				}
+				selected++
+				if enc != nil {
+					if err := enc.Encode(rec.Key); err != nil {
+						logrus.WithField("error", err).Fatal("couldn't write key to destination")
+					}
+				}
+				return true
+			})
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't read state file")
+				exitStatus = 1
+				return
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"pending":  counts[state.Pending],
+				"synced":   counts[state.Synced],
+				"failed":   counts[state.Failed],
+				"selected": selected,
+			}).Info("key status")
+			for code, count := range codes {
+				logrus.WithFields(logrus.Fields{
+					"error_code": code,
+					"count":      count,
+				}).Info("failed keys by error code")
+			}
+
+			if c.Bool(compactFlag.Name) {
+				if err := store.Compact(); err != nil {
+					logrus.WithField("error", err).Error("failed to compact state file")
+				}
+			}
+		},
+	}
+}
+
+// verifySync checks the sample of the keys synced by a task, named after
+// its bucket, logging the mismatches. It's false if any key doesn't match.
+func verifySync(name string, task *sync.SyncTask) bool {
+	v := task.Verify()
+	for _, m := range v.Mismatches {
+		logrus.WithFields(logrus.Fields{
+			"bucket": name,
+			"key":    m.Name,
+			"reason": m.Reason,
+		}).Error("synced key doesn't match its source")
+	}
+	entry := logrus.WithFields(logrus.Fields{
+		"bucket":     name,
+		"sampled":    v.Sampled,
+		"mismatches": len(v.Mismatches),
+		"errors":     v.Errors,
+		"read":       v.Read,
+	})
+	if len(v.Mismatches) > 0 || v.Errors > 0 {
+		entry.Error("verification of the synced keys failed")
+		return false
+	}
+	entry.Info("verified a sample of the synced keys")
+	return true
+}
+
+// readGrantIDs reads a file of canonical IDs, see sync.ReadGrantIDs.
+func readGrantIDs(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { logIfErr(file.Close()) }()
+	return sync.ReadGrantIDs(file)
+}
+
+// validateInput decodes a sample of the start of a gzipped listing by name,
+// logging what it holds and its problems. It's false if it has any.
+func validateInput(cfg *Config, name string, sample int64) bool {
+	logFail := func(err error) bool {
+		logrus.WithFields(logrus.Fields{
+			"error":    err,
+			"filename": name,
+		}).Error("couldn't validate listing")
+		return false
+	}
+	file, size, err := openListing(cfg, name)
+	if err != nil {
+		return logFail(err)
+	}
+	defer func() { logIfErr(file.Close()) }()
+	count := sync.NewCountingReader(file)
+	gz, err := gzip.NewReader(count)
+	if err != nil {
+		return logFail(fmt.Errorf("listing isn't a gzip file: %v", err))
+	}
+	report, err := sync.ValidateInput(gz, sample)
+	if err != nil {
+		return logFail(err)
+	}
+
+	for _, p := range report.Problems {
+		logrus.WithFields(logrus.Fields{
+			"filename": name,
+			"line":     p.Line,
+			"problem":  p.Problem,
+		}).Error("listing has an invalid key")
+	}
+	fields := logrus.Fields{
+		"filename": name,
+		"format":   report.Format,
+		"sampled":  report.Sampled,
+		"complete": report.Complete,
+		"problems": report.ProblemCount,
+	}
+	// the compressed bytes read for the sample, a little more with what gzip
+	// reads ahead, tell how much of the listing it took
+	if estimate := report.EstimateKeys(count.Count(), size); estimate > 0 {
+		fields["estimated_keys"] = estimate
+	}
+	entry := logrus.WithFields(fields)
+	if !report.OK() {
+		entry.Error("listing isn't valid, refusing to sync it")
+		return false
+	}
+	entry.Info("listing is valid")
+	return true
+}
+
+// previewMapping reads a gzipped listing by name, and prints the names the
+// mapping gives the first samples keys at the destination, and the counts of
+// the keys by prefix before and after it. It's false if the listing can't be
+// read, or if the mapping moves none of its keys.
+func previewMapping(cfg *Config, name string, mapping sync.Mapping, samples, depth int) bool {
+	logFail := func(err error) bool {
+		logrus.WithFields(logrus.Fields{
+			"error":    err,
+			"filename": name,
+		}).Error("couldn't preview the mapping of listing")
+		return false
+	}
+	file, _, err := openListing(cfg, name)
+	if err != nil {
+		return logFail(err)
+	}
+	defer func() { logIfErr(file.Close()) }()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return logFail(fmt.Errorf("listing isn't a gzip file: %v", err))
+	}
+	preview, err := sync.PreviewMapping(gz, mapping, samples, depth)
+	if err != nil {
+		return logFail(err)
+	}
+
+	fmt.Printf("--- mapping of %s\n%s\n", name, preview)
+	if preview.Suspicious() {
+		logrus.WithFields(logrus.Fields{
+			"filename": name,
+			"from":     mapping.From,
+			"to":       mapping.To,
+			"keys":     preview.Keys,
+		}).Error("none of the keys of the listing are under the prefix of the mapping")
+		return false
+	}
+	return true
+}
+
+// reconcileSync counts the keys of the destination of a task, named after
+// its bucket, logging the prefixes short of keys. It's false if any is.
+func reconcileSync(name string, task *sync.SyncTask) bool {
+	r := task.ReconcileCounts()
+	for _, d := range r.Discrepancies {
+		logrus.WithFields(logrus.Fields{
+			"bucket":    name,
+			"prefix":    d.Prefix,
+			"recursive": d.Recursive,
+			"expected":  d.Expected,
+			"found":     d.Found,
+		}).Error("prefix of the destination has fewer keys than were synced to it")
+	}
+	entry := logrus.WithFields(logrus.Fields{
+		"bucket":        name,
+		"prefixes":      r.Prefixes,
+		"counted":       r.Counted,
+		"discrepancies": len(r.Discrepancies),
+		"errors":        r.Errors,
+	})
+	if !r.OK() {
+		entry.Error("reconciliation of the key counts failed")
+		return false
+	}
+	entry.Info("the key counts of the destination match the sync")
+	return true
+}
+
+// dedupeSync reports the keys a task synced that hold the same content,
+// logging the totals for the destination it's named after.
+func dedupeSync(name string, task *sync.SyncTask) sync.DedupeReport {
+	r := task.DedupeKeys()
+	logrus.WithFields(logrus.Fields{
+		"bucket":      name,
+		"tracked":     r.Tracked,
+		"untracked":   r.Untracked,
+		"sets":        len(r.Sets),
+		"duplicates":  r.Duplicates,
+		"reclaimable": humanize.Bytes(uint64(r.Reclaimable)),
+	}).Info("keys holding the same content at the destination")
+	return r
+}
+
+// reportProgress logs the last progress snapshot of a sync.
+func reportProgress(filename string) {
+	snap, err := sync.ReadSnapshot(filename)
+	if err != nil {
+		logrus.WithField("error", err).Fatal("couldn't read progress file")
+	}
+	logrus.WithFields(snapshotFields(snap)).Info("sync progress")
+	for _, k := range snap.Slowest {
+		logrus.WithFields(logrus.Fields{
+			"key":     k.Key,
+			"size":    k.Size,
+			"elapsed": time.Duration(k.Elapsed * float64(time.Second)),
+			"attempt": k.Attempt,
+		}).Info("slow key")
+	}
+}
+
+// snapshotFields are the fields logged of a progress snapshot.
+func snapshotFields(snap sync.Snapshot) logrus.Fields {
+	fields := logrus.Fields{
+		"age":         time.Since(snap.Time),
+		"sync_run_id": snap.RunID,
+		"elapsed":     time.Duration(snap.Elapsed * float64(time.Second)),
+		"done":        snap.Done,
+		"paused":      snap.Paused,
+		"cancelled":   snap.Cancelled,
+		"synced":      snap.Synced,
+		"failed":      snap.Failed,
+		"skipped":     snap.Skipped,
+		"inflight":    snap.Inflight,
+		"retries":     snap.Retries,
+		"parked":      snap.Parked,
+		"retrying":    snap.Retrying,
+		"hedged":      snap.Hedged,
+		"rate_capped": snap.RateCapped,
+		"bytes":       snap.Bytes,
+		"existing":    snap.Existing,
+		"collisions":  snap.Collisions,
+		"conflicts":   snap.Conflicts,
+		"unchanged":   snap.Unchanged,
+		"hook_failed": snap.HookFailed,
+		"malformed":   snap.Malformed,
+		"panics":      snap.Panics,
+		"markers":     snap.Markers,
+		"queued":      snap.OutputQueued,
+		"spilled":     snap.Spilled,
+		"reordering":  snap.Reordering,
+		"disk_queued": snap.DiskQueued,
+		"output_wait": time.Duration(snap.OutputWait * float64(time.Second)),
+		"decoders":    snap.Decoders,
+		"decode_pct":  snap.DecodeQueue,
+		"sync_pct":    snap.SyncQueue,
+		"keys_per_s":  snap.RecentRate.Keys,
+		"bytes_per_s": snap.RecentRate.Bytes,
+
+		"retries_per_s": snap.RecentRate.Retries,
+	}
+	if snap.InputSize > 0 {
+		fields["fraction"] = snap.Fraction
+	}
+	if snap.ETA != nil {
+		fields["eta"] = snap.ETA.Format(time.RFC3339)
+	}
+	return fields
+}
+
+func watchCommand() cli.Command {
+	var (
+		keysFlag   = cli.BoolFlag{Name: "keys", Usage: "also report each key as it's synced or fails"}
+		failedFlag = cli.BoolFlag{Name: "failed", Usage: "also report each key that fails to sync"}
+	)
+
+	return cli.Command{
+		Name:  "watch",
+		Usage: "Follows the progress of a running sync.",
+		Description: strings.TrimSpace(`
+Connects to the monitoring handler of a running brigade, at the address given
+as argument, and reports the progress of its syncs as it's streamed, every
+second, until the sync is done. It exits with 1 when the watched process goes
+away before its syncs are done. For instance:
+	brigade watch 127.0.0.1:6060
+
+The same stream of Server-Sent Events is served on /events, for dashboards to
+follow in a browser: "progress" events hold the snapshots of each sync, like
+'sync -progress-file' writes, and "synced" and "failed" events the keys, which
+-keys and -failed report too. Clients that can't keep up miss events rather
+than slowing down the sync. The handler listens on 127.0.0.1:6060, so a sync
+on another host is watched through a tunnel, such as:
+	ssh -L 6060:127.0.0.1:6060 sync-host`),
+		Flags: []cli.Flag{keysFlag, failedFlag},
+		Action: func(c *cli.Context) {
+
+			addr := c.Args().First()
+			if addr == "" {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				logrus.Error("need the address of the brigade to watch")
+				exitStatus = 2
+				return
+			}
+			if !strings.Contains(addr, "://") {
+				addr = "http://" + addr
+			}
+			u, err := url.Parse(addr)
+			if err != nil {
+				logrus.WithField("error", err).Error("invalid address")
+				exitStatus = 2
+				return
+			}
+			if u.Path == "" || u.Path == "/" {
+				u.Path = "/events"
+			}
+			keys, failed := c.Bool(keysFlag.Name), c.Bool(failedFlag.Name)
+
+			resp, err := http.Get(u.String())
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't connect to the brigade to watch")
+				exitStatus = 2
+				return
+			}
+			defer func() { logIfErr(resp.Body.Close()) }()
+			if resp.StatusCode != http.StatusOK {
+				logrus.WithField("status", resp.Status).Error("couldn't follow the events of the brigade to watch")
+				exitStatus = 2
+				return
+			}
+			logrus.WithField("url", u.String()).Info("watching")
+
+			// whether each sync watched is done
+			done := make(map[string]bool)
+			err = events.ReadStream(resp.Body, func(event string, data []byte) error {
+				switch event {
+				case sync.ProgressEvent:
+					var snap sync.StreamedSnapshot
+					if err := json.Unmarshal(data, &snap); err != nil {
+						return fmt.Errorf("decoding progress: %v", err)
+					}
+					fields := snapshotFields(snap.Snapshot)
+					fields["name"] = snap.Name
+					logrus.WithFields(fields).Info("sync progress")
+					done[snap.Name] = snap.Done
+				case events.Synced, events.Failed:
+					if !keys && (!failed || event != events.Failed) {
+						return nil
+					}
+					var ev events.Event
+					if err := json.Unmarshal(data, &ev); err != nil {
+						return fmt.Errorf("decoding event: %v", err)
+					}
+					entry := logrus.WithFields(logrus.Fields{
+						"key":         ev.Key.Key,
+						"size":        ev.Key.Size,
+						"destination": ev.Destination,
+					})
+					if event == events.Synced {
+						entry.Info("key synced")
+						return nil
+					}
+					entry.WithFields(logrus.Fields{
+						"error":      ev.Error,
+						"error_code": ev.ErrorCode,
+					}).Warn("key failed to sync")
+				}
+				return nil
+			})
+			if err != nil && err != io.ErrUnexpectedEOF {
+				logrus.WithField("error", err).Error("stopped watching")
+				exitStatus = 2
+				return
+			}
+			for name, ok := range done {
+				if !ok {
+					logrus.WithField("name", name).Warn("the watched brigade went away before its sync was done")
+					exitStatus = 1
+				}
+			}
+			if len(done) == 0 {
+				logrus.Warn("the watched brigade went away without syncing")
+				exitStatus = 1
+			}
+			if exitStatus == 0 {
+				logrus.Info("the watched syncs are done")
+			}
+		},
+	}
+}
+
+func coordinateCommand() cli.Command {
+	var (
+		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		inputFlag  = cli.StringFlag{Name: "input", Usage: "name of the file containing the list of keys to distribute, or its s3://bucket/key URL in the state bucket"}
+		queueFlag  = cli.StringFlag{Name: "queue", Usage: "URL of the SQS queue where to push the batches of keys"}
+		batchFlag  = cli.IntFlag{Name: "batch", Value: 500, Usage: "number of keys per batch"}
+	)
+
+	return cli.Command{
+		Name:  "coordinate",
+		Usage: "Distributes a key listing over a work queue.",
+		Description: strings.TrimSpace(`
+Reads the keys from an s3 key listing and pushes them in batches on an SQS
+queue, from which any number of 'work' processes on many hosts pull batches
+of keys to sync.`),
+		Flags: []cli.Flag{configFlag, inputFlag, queueFlag, batchFlag},
+		Action: func(c *cli.Context) {
+
+			cfg := mustConfig(c, configFlag)
+			inputFilename := mustString(c, inputFlag)
+			queueURL := mustURL(c, queueFlag)
+			batchSize := c.Int(batchFlag.Name)
 
-			hadError := true
-			switch {
-			case filename == "":
-				logrus.Error("need a file to slice")
-			case n <= 1:
-				logrus.Error("need to slice in at least 2 parts")
-			default:
-				hadError = false
+			listfile, _, err := openListing(cfg, inputFilename)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": inputFilename,
+				}).Error("couldn't open listing file")
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return
 			}
-			if hadError {
+			defer func() { logIfErr(listfile.Close()) }()
+
+			inputGzRd, err := gzip.NewReader(listfile)
+			if err != nil {
+				logrus.WithField("error", err).Error("listing file is not a gzip file")
 				cli.ShowCommandHelp(c, c.Command.Name)
 				return
 			}
+			defer func() { logIfErr(inputGzRd.Close()) }()
+
+			queueCfg := cfg.QueueConfig()
+			auth, region := queueCfg.AWS()
+			q := queue.NewSQS(auth, region, queueURL.String())
 
 			logrus.Info("starting command ", c.Command.Name)
 
-			_, err := slice.Slice(filename, n)
+			n, err := queue.Distribute(q, inputGzRd, batchSize)
+			logrus.WithField("key_count", n).Info("done distributing keys")
 			if err != nil {
-				logrus.WithField("error", err).Error("failed to slice")
+				logrus.WithField("error", err).Error("failed to distribute keys")
 			}
-
 		},
 	}
 }
 
-func diffCommand() cli.Command {
+func workCommand() cli.Command {
 	var (
-		oldfileFlag = cli.StringFlag{Name: "old", Usage: "old file from which to read s3 keys"}
-		newfileFlag = cli.StringFlag{Name: "new", Usage: "new file from which to read s3 keys"}
-		dstfileFlag = cli.StringFlag{Name: "dest", Usage: "destination file where to write the keys that have changed"}
+		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+
+		queueFlag       = cli.StringFlag{Name: "queue", Usage: "URL of the SQS queue from which to pull batches of keys"}
+		successFlag     = cli.StringFlag{Name: "success", Usage: "name of the output file where to write the list of keys that succeeded to sync, or s3:// URL, defaults to /dev/null"}
+		failureFlag     = cli.StringFlag{Name: "failure", Usage: "name of the output file where to write the list of keys that failed to sync, or s3:// URL, defaults to /dev/null"}
+		srcFlag         = cli.StringFlag{Name: "src", Usage: "source bucket to get the keys from, or comma separated buckets to merge into the destination"}
+		dstFlag         = cli.StringFlag{Name: "dest", Usage: "destination bucket to put the keys into"}
+		concurrencyFlag = cli.IntFlag{Name: "concurrency", Value: 1000, Usage: "number of concurrent sync request"}
+		idleFlag        = cli.IntFlag{Name: "idle", Value: 3, Usage: "number of consecutive empty receives from the queue after which the worker stops"}
+		fsyncFlag       = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs are flushed to disk, 0 to only flush on completion"}
+
+		cloudwatchFlag      = cli.StringFlag{Name: "cloudwatch", Usage: "optional CloudWatch namespace where to publish the sync metrics, with the RunID of the run among their dimensions"}
+		cloudwatchEveryFlag = cli.StringFlag{Name: "cloudwatch-every", Value: "1m", Usage: "interval at which metrics are published to CloudWatch"}
 	)
 
 	return cli.Command{
-		Name:  "diff",
-		Usage: "Generates a differential listing of S3 keys.",
+		Name:  "work",
+		Usage: "Syncs the keys pulled from a work queue.",
 		Description: strings.TrimSpace(`
-Reads from an old s3 key listing and a new one, computes which keys have changed
-in the new listing and generates a new files containing only those keys.`),
-		Flags: []cli.Flag{oldfileFlag, newfileFlag, dstfileFlag},
+Pulls batches of keys from an SQS queue filled by 'coordinate' and syncs them
+from a source bucket to a destination bucket. A batch is removed from the
+queue only once all its keys have been sync'd or have failed to sync, so a
+batch held by a worker that dies is eventually picked up by another worker.`),
+		Flags: []cli.Flag{
+			configFlag,
+			queueFlag,
+			successFlag,
+			failureFlag,
+			srcFlag,
+			dstFlag,
+			concurrencyFlag,
+			idleFlag,
+			fsyncFlag,
+			cloudwatchFlag,
+			cloudwatchEveryFlag,
+		},
 		Action: func(c *cli.Context) {
 
-			oldfile := c.String(oldfileFlag.Name)
-			newfile := c.String(newfileFlag.Name)
-			dstfile := c.String(dstfileFlag.Name)
+			cfg := mustConfig(c, configFlag)
+			queueURL := mustURL(c, queueFlag)
+			successFilename := c.String(successFlag.Name)
+			failureFilename := c.String(failureFlag.Name)
+			src := mustURL(c, srcFlag)
+			dest := mustURL(c, dstFlag)
+			conc := c.Int(concurrencyFlag.Name)
+			idle := c.Int(idleFlag.Name)
+			fsyncEvery := mustDuration(c, fsyncFlag)
 
-			hadError := true
-			switch {
-			case oldfile == "":
-				logrus.Error("need a filename for old key listing")
-			case newfile == "":
-				logrus.Error("need a filename for new key listing")
-			case dstfile == "":
-				logrus.Error("need a filename for dest key listing")
-			default:
-				hadError = false
+			srcS3 := setupS3Timeouts(cfg.Source.S3())
+			srcBkt := regionalBucket(srcS3, src.Host)
+
+			destS3 := setupS3Timeouts(cfg.Destination.S3())
+			destBkt := regionalBucket(destS3, dest.Host)
+
+			successFile, sucCloser, err := createOutput(cfg, successFilename, fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("couldn't create success key file")
 			}
-			if hadError {
-				cli.ShowCommandHelp(c, c.Command.Name)
-				return
+			defer func() { logIfErr(sucCloser()) }()
+
+			failureFile, failCloser, err := createOutput(cfg, failureFilename, fsyncEvery)
+			if err != nil {
+				logrus.WithField("error", err).Fatal("couldn't create failure key file")
 			}
+			defer func() { logIfErr(failCloser()) }()
 
-			open := func(filename string) *os.File {
-				f, err := os.Open(filename)
-				if err != nil {
-					logrus.WithFields(logrus.Fields{
-						"error":    err,
-						"filename": filename,
-					}).Fatal("couldn't open file")
-				}
-				return f
+			queueCfg := cfg.QueueConfig()
+			auth, region := queueCfg.AWS()
+			q := queue.NewSQS(auth, region, queueURL.String())
+
+			logrus.Info("starting command ", c.Command.Name)
+
+			syncTask, err := sync.NewSyncTask(srcBkt, destBkt, sync.WithConcurrency(conc), sync.WithRunID(runID))
+			if err != nil {
+				logrus.WithField("error", err).Error("failed to prepare sync task")
+				return
 			}
 
-			newf := open(newfile)
-			defer func() { logIfErr(newf.Close()) }()
-			oldf := open(oldfile)
-			defer func() { logIfErr(oldf.Close()) }()
+			if namespace := c.String(cloudwatchFlag.Name); namespace != "" {
+				dims := map[string]string{"Source": src.Host, "Destination": dest.Host}
+				stop := publishCloudWatch(cfg, namespace, mustDuration(c, cloudwatchEveryFlag), dims)
+				defer stop()
+			}
 
-			dstf, err := os.Create(dstfile)
+			err = queue.Work(q, idle, func(batch io.Reader) error {
+				return syncTask.Start(batch, successFile, failureFile)
+			})
 			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error":    err,
-					"filename": dstfile,
-				}).Fatal("couldn't create destination file")
+				logrus.WithField("error", err).Error("failed to work on queue")
 			}
-			defer func() { logIfErr(dstf.Close()) }()
+		},
+	}
+}
 
-			gzread := func(f *os.File) *gzip.Reader {
-				gzr, err := gzip.NewReader(f)
-				if err != nil {
-					logrus.WithFields(logrus.Fields{
-						"error":    err,
-						"filename": f.Name(),
-					}).Fatal("couldn't read gzip")
-				}
-				return gzr
+func daemonCommand() cli.Command {
+	var (
+		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		listenFlag = cli.StringFlag{Name: "listen", Value: "127.0.0.1:7070", Usage: "address on which to serve the control API"}
+		fsyncFlag  = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs of jobs are flushed to disk, 0 to only flush on completion"}
+	)
+	access := jobAccessFlags()
+	limits := jobLimitFlags()
+
+	return cli.Command{
+		Name:  "daemon",
+		Usage: "Runs sync jobs submitted over an RPC control API.",
+		Description: strings.TrimSpace(`
+Runs as a long lived process, serving a control API through which sync jobs
+are submitted, followed, paused, resumed and cancelled, and through which the
+keys that a finished job failed to sync can be fetched. A job syncs the keys
+of a local listing file from a source bucket to a destination bucket, like
+the 'sync' command does, using the credentials of the config file, or those
+of the config file named by the job.
+
+The control API is Go's net/rpc rather than gRPC, which brigade doesn't
+depend on, and progress isn't streamed: a client follows a job by calling
+Wait in a loop, which returns the status of the job once it ends or once the
+timeout of the call elapses.
+
+` + jobAccessDescription + `
+
+` + jobLimitsDescription),
+		Flags: []cli.Flag{
+			configFlag,
+			listenFlag,
+			fsyncFlag,
+			access.tokenFile,
+			access.jobsDir,
+			limits.maxJobConcurrency,
+			limits.maxCalls,
+		},
+		Action: func(c *cli.Context) {
+
+			cfg := mustConfig(c, configFlag)
+			addr := mustString(c, listenFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
+
+			token, jobsDir, ok := access.load(c)
+			if !ok {
+				exitStatus = 1
+				return
+			}
+			prepare, ok := limits.preparer(c, cfg, jobsDir, fsyncEvery)
+			if !ok {
+				exitStatus = 1
+				return
 			}
 
-			newgz := gzread(newf)
-			oldgz := gzread(oldf)
-			dstgz := gzip.NewWriter(dstf)
-			defer func() { logIfErr(dstgz.Close()) }()
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				logrus.WithField("error", err).Error("couldn't listen for the control api")
+				exitStatus = 1
+				return
+			}
 
 			logrus.Info("starting command ", c.Command.Name)
 
-			if err := diff.Diff(oldgz, newgz, dstgz); err != nil {
-				logrus.WithField("error", err).Error("failed to diff")
+			m := daemon.NewManager(prepare)
+			if err := daemon.Serve(l, m, token); err != nil {
+				logrus.WithField("error", err).Error("failed to serve control api")
+				exitStatus = 1
 			}
 		},
 	}
 }
 
-func backupCommand() cli.Command {
+func serveCommand() cli.Command {
 	var (
-		srcFlag   = cli.StringFlag{Name: "src", Usage: "source bucket to get the keys from"}
-		destFlag  = cli.StringFlag{Name: "dest", Usage: "destination bucket to put the keys into"}
-		stateFlag = cli.StringFlag{Name: "state", Usage: "state bucket where artifacts of backups are held"}
-
 		configFlag = cli.StringFlag{Name: "config", Usage: "JSON file containing AWS keys"}
+		listenFlag = cli.StringFlag{Name: "listen", Value: "127.0.0.1:7080", Usage: "address on which to serve the REST API"}
+		fsyncFlag  = cli.StringFlag{Name: "fsync-every", Value: "10s", Usage: "interval at which the success and failure outputs of jobs are flushed to disk, 0 to only flush on completion"}
 	)
+	access := jobAccessFlags()
+	limits := jobLimitFlags()
 
 	return cli.Command{
-		Name:  "backup",
-		Usage: "Executes list, diff and sync from a source to a destination bucket.",
+		Name:  "serve",
+		Usage: "Runs sync jobs submitted over a REST API.",
 		Description: strings.TrimSpace(`
-Executes list, diff and sync one after another. It works against a
-'state' s3 bucket, which contains past backups and where this backup
-will store its output.`),
+Like 'daemon', but serves the jobs over a REST API:
+
+    POST /jobs                submit a job, as a JSON object with fields
+                              source, destination, input, success, failure,
+                              concurrency and an optional config
+    GET  /jobs                list the status of all jobs
+    GET  /jobs/{id}           status of a job
+    POST /jobs/{id}/pause     pause a job
+    POST /jobs/{id}/resume    resume a job
+    POST /jobs/{id}/cancel    cancel a job
+    GET  /jobs/{id}/failed    keys that an ended job failed to sync
+
+Requests present the token in an 'Authorization: Bearer <token>' header.
+
+` + jobAccessDescription + `
+
+` + jobLimitsDescription),
 		Flags: []cli.Flag{
 			configFlag,
-			srcFlag,
-			destFlag,
-			stateFlag,
+			listenFlag,
+			fsyncFlag,
+			access.tokenFile,
+			access.jobsDir,
+			limits.maxJobConcurrency,
+			limits.maxCalls,
 		},
 		Action: func(c *cli.Context) {
 
 			cfg := mustConfig(c, configFlag)
-			src := mustURL(c, srcFlag)
-			dest := mustURL(c, destFlag)
-			state := mustURL(c, stateFlag)
+			addr := mustString(c, listenFlag)
+			fsyncEvery := mustDuration(c, fsyncFlag)
 
-			srcS3 := setupS3Timeouts(s3.New(cfg.Source.AWS()))
-			srcBkt := srcS3.Bucket(src.Host)
-
-			destS3 := setupS3Timeouts(s3.New(cfg.Destination.AWS()))
-			destBkt := destS3.Bucket(dest.Host)
-
-			stateS3 := setupS3Timeouts(s3.New(cfg.State.AWS()))
-			stateBkt := stateS3.Bucket(state.Host)
+			token, jobsDir, ok := access.load(c)
+			if !ok {
+				exitStatus = 1
+				return
+			}
+			prepare, ok := limits.preparer(c, cfg, jobsDir, fsyncEvery)
+			if !ok {
+				exitStatus = 1
+				return
+			}
 
 			logrus.Info("starting command ", c.Command.Name)
 
-			srcPath := src.Path
-			if strings.HasPrefix(srcPath, "/") {
-				srcPath = srcPath[1:]
+			m := daemon.NewManager(prepare)
+			logrus.WithField("addr", addr).Info("serving rest api")
+			if err := http.ListenAndServe(addr, daemon.NewHandler(m, token)); err != nil {
+				logrus.WithField("error", err).Error("failed to serve rest api")
+				exitStatus = 1
 			}
+		},
+	}
+}
 
-			statePath := state.Path
-			if strings.HasPrefix(statePath, "/") {
-				statePath = statePath[1:]
-			}
+const jobAccessDescription = `Clients must present the secret held by the file of -token-file, and the
+local files that jobs name, their listing, outputs and config, must be within
+the directory of -jobs-dir, so that a client can't read or overwrite other
+files of the host. Listings in S3 and outputs to S3 or to a queue aren't
+confined, but the config must be a local file.`
 
-			task, err := backup.NewBackup(srcBkt, destBkt, stateBkt, srcPath, statePath)
+// jobAccess are the flags that restrict what the clients of a daemon can
+// do.
+type jobAccess struct {
+	tokenFile cli.StringFlag
+	jobsDir   cli.StringFlag
+}
+
+func jobAccessFlags() jobAccess {
+	return jobAccess{
+		tokenFile: cli.StringFlag{Name: "token-file", Usage: "file holding the secret token that clients must present"},
+		jobsDir:   cli.StringFlag{Name: "jobs-dir", Usage: "directory to which the local files of jobs are confined"},
+	}
+}
+
+// load the token and the jobs directory of the flags.
+func (a jobAccess) load(c *cli.Context) (token, jobsDir string, ok bool) {
+	tokenFile := c.String(a.tokenFile.Name)
+	if tokenFile == "" {
+		logrus.Error("missing -token-file, the control api requires a token")
+		return "", "", false
+	}
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		logrus.WithField("error", err).Error("couldn't read token file")
+		return "", "", false
+	}
+	if token = strings.TrimSpace(string(data)); token == "" {
+		logrus.WithField("token_file", tokenFile).Error("token file is empty")
+		return "", "", false
+	}
+
+	jobsDir = c.String(a.jobsDir.Name)
+	if jobsDir == "" {
+		logrus.Error("missing -jobs-dir, the files of jobs must be confined to a directory")
+		return "", "", false
+	}
+	if fi, err := os.Stat(jobsDir); err != nil || !fi.IsDir() {
+		logrus.WithField("jobs_dir", jobsDir).Error("jobs directory isn't a directory")
+		return "", "", false
+	}
+	return token, jobsDir, true
+}
+
+const jobLimitsDescription = `Jobs run side by side, each with its own workers. With -max-job-concurrency,
+a job can't ask for more workers than that, and gets that many when it
+doesn't ask. With -max-calls, the jobs share a budget of that many sync calls
+in flight: a call that ends hands its slot to the job waiting with the fewest
+calls in flight, so that a job with many workers doesn't starve the others.`
+
+// jobLimits are the flags that isolate the jobs of a daemon from one
+// another.
+type jobLimits struct {
+	maxJobConcurrency cli.IntFlag
+	maxCalls          cli.IntFlag
+}
+
+func jobLimitFlags() jobLimits {
+	return jobLimits{
+		maxJobConcurrency: cli.IntFlag{Name: "max-job-concurrency", Usage: "optional cap on the concurrency of each job, which is also the concurrency of the jobs that don't set one"},
+		maxCalls:          cli.IntFlag{Name: "max-calls", Usage: "optional budget of sync calls in flight, shared fairly among all the jobs"},
+	}
+}
+
+// preparer of the jobs of a daemon, within the limits of the flags, with
+// their local files confined to jobsDir.
+func (l jobLimits) preparer(c *cli.Context, cfg *Config, jobsDir string, fsyncEvery time.Duration) (daemon.PrepareFunc, bool) {
+	maxConc := c.Int(l.maxJobConcurrency.Name)
+	if maxConc < 0 {
+		logrus.WithField("max_job_concurrency", maxConc).Error("invalid job concurrency cap, must be positive")
+		return nil, false
+	}
+	var calls *sync.CallBudget
+	if max := c.Int(l.maxCalls.Name); max != 0 {
+		var err error
+		if calls, err = sync.NewCallBudget(max); err != nil {
+			logrus.WithField("error", err).Error("invalid call budget")
+			return nil, false
+		}
+	}
+	return jobPreparer(cfg, jobsDir, fsyncEvery, maxConc, calls), true
+}
+
+// jobPreparer sets up the buckets, input and outputs of the sync jobs of a
+// daemon. The local files of the jobs must be within jobsDir. The
+// concurrency of the jobs is capped to maxConc when it's not 0, and their
+// calls share the budget of calls when it's set.
+func jobPreparer(cfg *Config, jobsDir string, fsyncEvery time.Duration, maxConc int, calls *sync.CallBudget) daemon.PrepareFunc {
+	return func(spec daemon.JobSpec) (daemon.JobSpec, *sync.SyncTask, func() error, error) {
+		if err := confineJob(&spec, jobsDir); err != nil {
+			return spec, nil, nil, err
+		}
+		conc := spec.Concurrency
+		switch {
+		case maxConc == 0:
+		case conc == 0:
+			conc = maxConc
+		case conc > maxConc:
+			return spec, nil, nil, fmt.Errorf("concurrency of %d is above the cap of %d per job", conc, maxConc)
+		}
+		jobCfg := cfg
+		if spec.Config != "" {
+			file, err := os.Open(spec.Config)
 			if err != nil {
-				logrus.WithField("error", err).Error("failed to prepare backup task")
-				return
+				return spec, nil, nil, fmt.Errorf("opening config file: %v", err)
 			}
+			jobCfg, err = loadConfigFile(file)
+			logIfErr(file.Close())
+			if err != nil {
+				return spec, nil, nil, fmt.Errorf("loading config file %q: %v", spec.Config, err)
+			}
+		}
 
-			var deleteFiles bool
-			if err := task.Execute(); err != nil {
-				logrus.WithField("error", err).Error("failed to backup")
-				deleteFiles = false
-			} else {
-				deleteFiles = true
+		src, err := url.Parse(spec.Source)
+		if err != nil {
+			return spec, nil, nil, fmt.Errorf("invalid source %q: %v", spec.Source, err)
+		}
+		dest, err := url.Parse(spec.Destination)
+		if err != nil {
+			return spec, nil, nil, fmt.Errorf("invalid destination %q: %v", spec.Destination, err)
+		}
+
+		srcBkt := regionalBucket(setupS3Timeouts(jobCfg.Source.S3()), src.Host)
+		destBkt := regionalBucket(setupS3Timeouts(jobCfg.Destination.S3()), dest.Host)
+
+		opts := []sync.Option{sync.WithRunID(runID)}
+		if conc > 0 {
+			opts = append(opts, sync.WithConcurrency(conc))
+		}
+		if calls != nil {
+			opts = append(opts, sync.WithCallBudget(calls))
+		}
+		syncTask, err := sync.NewSyncTask(srcBkt, destBkt, opts...)
+		if err != nil {
+			return spec, nil, nil, err
+		}
+
+		listfile, _, err := openListing(jobCfg, spec.Input)
+		if err != nil {
+			return spec, nil, nil, fmt.Errorf("opening listing file: %v", err)
+		}
+		inputGzRd, err := gzip.NewReader(listfile)
+		if err != nil {
+			logIfErr(listfile.Close())
+			return spec, nil, nil, fmt.Errorf("listing file is not a gzip file: %v", err)
+		}
+
+		run := func() error {
+			defer func() { logIfErr(listfile.Close()) }()
+			defer func() { logIfErr(inputGzRd.Close()) }()
+
+			successFile, sucCloser, err := createOutput(jobCfg, spec.Success, fsyncEvery)
+			if err != nil {
+				return fmt.Errorf("creating success key file: %v", err)
 			}
-			if err := task.Cleanup(deleteFiles); err != nil {
-				logrus.WithField("error", err).Error("failed to close backup task")
+			defer func() { logIfErr(sucCloser()) }()
+
+			failureFile, failCloser, err := createOutput(jobCfg, spec.Failure, fsyncEvery)
+			if err != nil {
+				return fmt.Errorf("creating failure key file: %v", err)
 			}
+			defer func() { logIfErr(failCloser()) }()
 
-		},
+			return syncTask.Start(inputGzRd, successFile, failureFile)
+		}
+		return spec, syncTask, run, nil
+	}
+}
+
+// confineJob resolves the local files of a job within jobsDir, failing if
+// any of them is outside of it. The input can also be an s3:// URL, and the
+// outputs s3:// or queue URLs, but the config must be a local file.
+func confineJob(spec *daemon.JobSpec, jobsDir string) error {
+	if _, _, ok := s3file.Parse(spec.Config); ok || isQueueURL(spec.Config) {
+		return fmt.Errorf("config %q must be a local file", spec.Config)
+	}
+	if isQueueURL(spec.Input) {
+		return fmt.Errorf("input %q must be a file or an s3:// URL", spec.Input)
+	}
+	for _, name := range []*string{&spec.Input, &spec.Config, &spec.Success, &spec.Failure} {
+		if *name == "" || *name == os.DevNull || isQueueURL(*name) {
+			continue
+		}
+		if _, _, ok := s3file.Parse(*name); ok {
+			continue
+		}
+		confined, err := daemon.Confine(jobsDir, *name)
+		if err != nil {
+			return err
+		}
+		*name = confined
 	}
+	return nil
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/daemon"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncExitsOnCorruptListing(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+	// the config reaches the mock through the region of its buckets
+	region := mocks3.S3().Region
+	aws.Regions[region.Name] = region
+	defer delete(aws.Regions, region.Name)
+	for _, name := range []string{"src-bucket", "dst-bucket"} {
+		if err := mocks3.S3().Bucket(name).PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "brigade-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bkt := BucketConfig{Region: region.Name, AccessKey: "key", SecretKey: "secret"}
+	cfg, err := json.Marshal(Config{Source: bkt, Destination: bkt, State: bkt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgName := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(cfgName, cfg, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys without a single newline between them
+	var listing bytes.Buffer
+	gz := gzip.NewWriter(&listing)
+	for i := 0; i < 1000; i++ {
+		gz.Write([]byte(`{"Key":"a","Size":1}`))
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	listName := filepath.Join(dir, "listing.json.gz")
+	if err := ioutil.WriteFile(listName, listing.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	exitStatus = 0
+	defer func() { exitStatus = 0 }()
+	err = newApp().Run([]string{"brigade", "sync",
+		"-config", cfgName,
+		"-input", listName,
+		"-src", "s3://src-bucket",
+		"-dest", "s3://dst-bucket",
+		"-success", filepath.Join(dir, "synced.json.gz"),
+		"-failure", filepath.Join(dir, "failed.json.gz"),
+		"-max-line-size", "1024",
+		"-concurrency", "1",
+	})
+	if err != nil {
+		t.Fatalf("can't run sync: %v", err)
+	}
+	if exitStatus == 0 {
+		t.Errorf("want a non-zero exit status for a listing without newlines")
+	}
+}
+
+func TestConfineJob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brigade-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := daemon.JobSpec{
+		Input:   "s3://state-bucket/list.json.gz",
+		Config:  "config.json",
+		Success: "https://sqs.us-east-1.amazonaws.com/1234/synced",
+		Failure: "failed.json.gz",
+	}
+	if err := confineJob(&spec, dir); err != nil {
+		t.Fatal(err)
+	}
+	want := daemon.JobSpec{
+		Input:   "s3://state-bucket/list.json.gz",
+		Config:  filepath.Join(root, "config.json"),
+		Success: "https://sqs.us-east-1.amazonaws.com/1234/synced",
+		Failure: filepath.Join(root, "failed.json.gz"),
+	}
+	if spec != want {
+		t.Errorf("want %#v, got %#v", want, spec)
+	}
+
+	for _, spec := range []daemon.JobSpec{
+		{Input: "list.json.gz", Config: "s3://state-bucket/config.json"},
+		{Input: "list.json.gz", Config: "https://sqs.us-east-1.amazonaws.com/1234/config"},
+		{Input: "https://sqs.us-east-1.amazonaws.com/1234/list"},
+		{Input: "../list.json.gz"},
+	} {
+		if err := confineJob(&spec, dir); err == nil {
+			t.Errorf("want an error for %#v", spec)
+		}
+	}
+}
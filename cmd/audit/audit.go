@@ -0,0 +1,306 @@
+// Package audit compares the attributes of keys to those of their copies:
+// their ACL grants, their tags, and their Content-Type and user metadata.
+// Where compare and diff check that the copies hold the same data, audit
+// checks that they're exposed and described the same, which a sync with the
+// wrong flags gets wrong with every byte copied right.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// The attributes of keys that are audited.
+const (
+	// ACL is the grants of the ACL of a key, read with a request per key.
+	ACL = "acl"
+	// Tags are read with a request per key that has some.
+	Tags = "tags"
+	// Metadata is the Content-Type and the user metadata of a key.
+	Metadata = "metadata"
+)
+
+// Attributes are all the attributes, in the order they're compared.
+var Attributes = []string{ACL, Tags, Metadata}
+
+// Missing is the drift of the keys whose copy doesn't exist, whose
+// attributes can't be compared.
+const Missing = "missing"
+
+// ParseAttributes parses a comma separated list of attributes, such as
+// "acl,tags".
+func ParseAttributes(spec string) ([]string, error) {
+	var attrs []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case ACL, Tags, Metadata:
+		default:
+			return nil, fmt.Errorf("can't audit %q, only %s", name, strings.Join(Attributes, ", "))
+		}
+		if !seen[name] {
+			seen[name] = true
+			attrs = append(attrs, name)
+		}
+	}
+	return attrs, nil
+}
+
+// Drift of an attribute of a copy from the one of its key. The attributes
+// are formatted so that equal ones are equal strings: ACLs as their sorted
+// PERMISSION:grantee grants, and tags and metadata as sorted query strings,
+// the Content-Type being the content-type parameter of the metadata.
+type Drift struct {
+	Key string `json:"key"`
+	// DestKey is the name of the copy, when it's not the one of the key.
+	DestKey     string `json:"dest_key,omitempty"`
+	Attribute   string `json:"attribute"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// Report of an audit.
+type Report struct {
+	// Keys read from the listing, those whose attributes were compared,
+	// and those with at least a drift.
+	Keys    int64 `json:"keys"`
+	Audited int64 `json:"audited"`
+	Drifted int64 `json:"drifted"`
+	// Missing copies, and keys that couldn't be audited.
+	Missing int64 `json:"missing"`
+	Failed  int64 `json:"failed"`
+	// Drifts by attribute.
+	Drifts map[string]int64 `json:"drifts"`
+}
+
+// Same is true when the copies have the attributes of their keys.
+func (r Report) Same() bool {
+	return r.Drifted == 0 && r.Missing == 0 && r.Failed == 0
+}
+
+// Auditor compares the attributes of the keys of a source to those of their
+// copies in a destination, many keys at once.
+type Auditor struct {
+	// Para is the number of keys audited at once.
+	Para int
+	// Attributes compared, all of them when empty.
+	Attributes []string
+	// Grants maps the grantees of the ACLs of the source to those of the
+	// destination, like sync -grant-ids, before the ACLs are compared.
+	Grants sync.Grants
+	// MapKey maps the name of a key to the one of its copy, when set.
+	MapKey func(string) string
+
+	src, dst *s3.Bucket
+}
+
+// New creates an auditor of the copies in dst of keys of src.
+func New(src, dst *s3.Bucket) *Auditor {
+	return &Auditor{Para: 100, src: src, dst: dst}
+}
+
+// Run audits the keys of a listing, which are expected in both buckets,
+// and writes their drifts to out as JSON, one per line.
+func (a *Auditor) Run(input listing.Reader, out io.Writer) (Report, error) {
+	if a.Para < 1 {
+		return Report{}, fmt.Errorf("need at least 1 worker, got %d", a.Para)
+	}
+	attrs := a.Attributes
+	if len(attrs) == 0 {
+		attrs = Attributes
+	}
+	want := make(map[string]bool)
+	for _, attr := range attrs {
+		want[attr] = true
+	}
+
+	var mu gosync.Mutex
+	report := Report{Drifts: make(map[string]int64)}
+	count := func(f func(r *Report)) {
+		mu.Lock()
+		f(&report)
+		mu.Unlock()
+	}
+
+	start := time.Now()
+	keys := make(chan s3.Key, a.Para*pipeline.BufferFactor)
+	drifts := make(chan Drift, a.Para*pipeline.BufferFactor)
+	var workers pipeline.Workers
+	workers.Start(a.Para, func(int) {
+		for key := range keys {
+			found, err := a.audit(key, want)
+			switch {
+			case err != nil:
+				logrus.WithFields(logrus.Fields{
+					"key":   key.Key,
+					"error": err,
+				}).Error("couldn't audit key")
+				count(func(r *Report) { r.Failed++ })
+				continue
+			case len(found) == 1 && found[0].Attribute == Missing:
+				count(func(r *Report) { r.Missing++ })
+			default:
+				count(func(r *Report) {
+					r.Audited++
+					if len(found) > 0 {
+						r.Drifted++
+					}
+					for _, d := range found {
+						r.Drifts[d.Attribute]++
+					}
+				})
+			}
+			for _, d := range found {
+				drifts <- d
+			}
+		}
+	})
+
+	var writeErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		enc := json.NewEncoder(out)
+		for d := range drifts {
+			if writeErr == nil {
+				writeErr = enc.Encode(d)
+			}
+		}
+	}()
+
+	var err error
+	for {
+		var key s3.Key
+		if err = input.Read(&key); err != nil {
+			break
+		}
+		count(func(r *Report) { r.Keys++ })
+		keys <- key
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	close(keys)
+	workers.Wait()
+	close(drifts)
+	<-done
+	if err == nil {
+		err = writeErr
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"since_start": time.Since(start),
+		"keys":        report.Keys,
+		"audited":     report.Audited,
+		"drifted":     report.Drifted,
+		"missing":     report.Missing,
+		"failed":      report.Failed,
+	}).Info("done auditing keys")
+	return report, err
+}
+
+// audit a key, returning the drifts of its copy.
+func (a *Auditor) audit(key s3.Key, want map[string]bool) ([]Drift, error) {
+	dstKey := key.Key
+	if a.MapKey != nil {
+		dstKey = a.MapKey(key.Key)
+	}
+	drift := func(attr, src, dst string) Drift {
+		d := Drift{Key: key.Key, Attribute: attr, Source: src, Destination: dst}
+		if dstKey != key.Key {
+			d.DestKey = dstKey
+		}
+		return d
+	}
+
+	// the copy is read first, as it's the one that may be gone
+	dst, err := sync.Head(a.dst, s3.Key{Key: dstKey}, want[Tags])
+	if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+		return []Drift{drift(Missing, key.Key, "")}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading the copy %q: %v", dstKey, err)
+	}
+	src, err := sync.Head(a.src, key, want[Tags])
+	if err != nil {
+		return nil, fmt.Errorf("reading the key: %v", err)
+	}
+
+	var drifts []Drift
+	for _, attr := range Attributes {
+		if !want[attr] {
+			continue
+		}
+		var srcValue, dstValue string
+		switch attr {
+		case ACL:
+			srcACL, err := a.src.GetPermissions(key.Key)
+			if err != nil {
+				return nil, fmt.Errorf("reading the ACL of the key: %v", err)
+			}
+			dstACL, err := a.dst.GetPermissions(dstKey)
+			if err != nil {
+				return nil, fmt.Errorf("reading the ACL of the copy %q: %v", dstKey, err)
+			}
+			srcValue = formatGrants(a.Grants.Map(srcACL.AccessControlList))
+			dstValue = formatGrants(dstACL.AccessControlList)
+		case Tags:
+			srcValue, dstValue = formatTags(src.Tags), formatTags(dst.Tags)
+		case Metadata:
+			srcValue, dstValue = formatMetadata(src), formatMetadata(dst)
+		}
+		if srcValue != dstValue {
+			drifts = append(drifts, drift(attr, srcValue, dstValue))
+		}
+	}
+	return drifts, nil
+}
+
+// formatGrants formats grants as sorted PERMISSION:grantee, the grantee
+// being uri=<group> or id=<canonical ID>.
+func formatGrants(grants []s3.Grant) string {
+	formatted := make([]string, 0, len(grants))
+	for _, g := range grants {
+		grantee := "id=" + g.Grantee.ID
+		if g.Grantee.URI != "" {
+			grantee = "uri=" + g.Grantee.URI
+		}
+		formatted = append(formatted, g.Permission+":"+grantee)
+	}
+	sort.Strings(formatted)
+	return strings.Join(formatted, ",")
+}
+
+// formatTags formats tags as a sorted query string.
+func formatTags(tags map[string]string) string {
+	v := make(url.Values, len(tags))
+	for name, value := range tags {
+		v.Set(name, value)
+	}
+	return v.Encode()
+}
+
+// formatMetadata formats the metadata of a key as a sorted query string of
+// its headers.
+func formatMetadata(e listing.Enriched) string {
+	v := make(url.Values, len(e.Metadata)+1)
+	for name, value := range e.Metadata {
+		v.Set("x-amz-meta-"+name, value)
+	}
+	if e.ContentType != "" {
+		v.Set("content-type", e.ContentType)
+	}
+	return v.Encode()
+}
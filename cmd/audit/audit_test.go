@@ -0,0 +1,115 @@
+package audit_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/audit"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+	src, dst := mocks3.S3().Bucket("src-bucket"), mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	put := func(bkt *s3.Bucket, name, contentType string, opts s3.Options) {
+		if err := bkt.Put(name, []byte(name), contentType, s3.Private, opts); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+	}
+	meta := s3.Options{Meta: map[string][]string{"owner": {"web"}}, Tagging: "team=web"}
+	for _, name := range []string{"same", "tags", "type", "acl"} {
+		put(src, name, "text/plain", meta)
+	}
+	put(src, "gone", "text/plain", s3.Options{})
+	put(dst, "copies/same", "text/plain", meta)
+	put(dst, "copies/tags", "text/plain", s3.Options{Meta: meta.Meta, Tagging: "team=ops"})
+	put(dst, "copies/type", "application/octet-stream", meta)
+	put(dst, "copies/acl", "text/plain", meta)
+	if err := src.PutGrants("acl", []s3.Grant{{Grantee: s3.Grantee{URI: "http://acs.amazonaws.com/groups/global/AllUsers"}, Permission: "READ"}}); err != nil {
+		t.Fatalf("can't grant: %v", err)
+	}
+
+	var input bytes.Buffer
+	w, _ := listing.NewWriter(&input, listing.JSON)
+	for _, name := range []string{"same", "tags", "type", "acl", "gone"} {
+		if err := w.Write(s3.Key{Key: name}); err != nil {
+			t.Fatalf("can't write key: %v", err)
+		}
+	}
+	rd, err := listing.NewReader(&input)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+
+	m := sync.Mapping{From: "", To: "copies/"}
+	auditor := audit.New(src, dst)
+	auditor.Para = 2
+	auditor.MapKey = m.Map
+	var out bytes.Buffer
+	report, err := auditor.Run(rd, &out)
+	if err != nil {
+		t.Fatalf("can't audit: %v", err)
+	}
+	want := audit.Report{
+		Keys: 5, Audited: 4, Drifted: 3, Missing: 1,
+		Drifts: map[string]int64{audit.ACL: 1, audit.Tags: 1, audit.Metadata: 1},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("want report %+v, got %+v", want, report)
+	}
+	if report.Same() {
+		t.Errorf("want the copies to differ")
+	}
+
+	drifts := make(map[string]audit.Drift)
+	var names []string
+	scan := bufio.NewScanner(&out)
+	for scan.Scan() {
+		var d audit.Drift
+		if err := json.Unmarshal(scan.Bytes(), &d); err != nil {
+			t.Fatalf("can't decode drift: %v", err)
+		}
+		drifts[d.Key] = d
+		names = append(names, d.Key+":"+d.Attribute)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "acl:acl gone:missing tags:tags type:metadata" {
+		t.Errorf("want a drift per differing attribute, got %s", got)
+	}
+	if d := drifts["tags"]; d.DestKey != "copies/tags" || d.Source != "team=web" || d.Destination != "team=ops" {
+		t.Errorf("want the tags of both keys, got %+v", d)
+	}
+	if d := drifts["type"]; !strings.Contains(d.Destination, "content-type=application%2Foctet-stream") {
+		t.Errorf("want the Content-Type of the copy, got %+v", d)
+	}
+
+	// only the attributes asked for are compared
+	attrs, err := audit.ParseAttributes("tags, metadata")
+	if err != nil {
+		t.Fatalf("can't parse attributes: %v", err)
+	}
+	auditor.Attributes = attrs
+	rd, _ = listing.NewReader(strings.NewReader(`{"Key":"acl"}` + "\n"))
+	if report, err := auditor.Run(rd, &out); err != nil || !report.Same() {
+		t.Errorf("want the ACL left alone, got %+v, %v", report, err)
+	}
+	if _, err := audit.ParseAttributes("acl,owner"); err == nil {
+		t.Errorf("want an error for an unknown attribute")
+	}
+}
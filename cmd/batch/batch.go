@@ -0,0 +1,51 @@
+// Package batch hands keys of a listing over to S3 Batch Operations, for the
+// part of a sync that is better left to AWS: it writes the keys as the CSV
+// manifest of a batch job, and creates the job that copies them.
+package batch
+
+import (
+	"encoding/csv"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ManifestFormat is the format of the manifests written by WriteManifest.
+const ManifestFormat = "S3BatchOperations_CSV_20180820"
+
+// ManifestFields are the columns of the manifests written by WriteManifest.
+// Listings only hold the current version of the keys, so the manifests have
+// no version column.
+var ManifestFields = []string{"Bucket", "Key"}
+
+// WriteManifest writes the keys of a listing to w as the CSV manifest of a
+// batch job on bucket, a key per line, and returns how many it wrote.
+func WriteManifest(w io.Writer, rd listing.Reader, bucket string) (int64, error) {
+	cw := csv.NewWriter(w)
+	var (
+		n   int64
+		key s3.Key
+	)
+	for {
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			cw.Flush()
+			return n, cw.Error()
+		case nil:
+		default:
+			return n, err
+		}
+		if err := cw.Write([]string{bucket, EscapeKey(key.Key)}); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// EscapeKey URL-encodes the name of a key, as the manifests of batch jobs
+// want them. Spaces are encoded as %20 rather than +, which a key can hold.
+func EscapeKey(key string) string {
+	return strings.Replace(url.QueryEscape(key), "+", "%20", -1)
+}
@@ -0,0 +1,119 @@
+package batch_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/batch"
+	"github.com/Shopify/brigade/cmd/creds"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := listing.NewWriter(&buf, listing.JSON)
+	if err != nil {
+		t.Fatalf("can't create listing: %v", err)
+	}
+	for _, name := range []string{"a/b.txt", "with space+plus", "comma,é"} {
+		if err := w.Write(s3.Key{Key: name}); err != nil {
+			t.Fatalf("can't write key: %v", err)
+		}
+	}
+	rd, err := listing.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+
+	var manifest bytes.Buffer
+	n, err := batch.WriteManifest(&manifest, rd, "src-bucket")
+	if err != nil {
+		t.Fatalf("can't write manifest: %v", err)
+	}
+	want := "src-bucket,a%2Fb.txt\nsrc-bucket,with%20space%2Bplus\nsrc-bucket,comma%2C%C3%A9\n"
+	if n != 3 || manifest.String() != want {
+		t.Errorf("want 3 keys in manifest\n%s\ngot %d\n%s", want, n, manifest.String())
+	}
+}
+
+func TestCreateJob(t *testing.T) {
+	var got struct {
+		AccountID string   `xml:"AccountId"`
+		Target    string   `xml:"Operation>S3PutObjectCopy>TargetResource"`
+		Prefix    string   `xml:"Operation>S3PutObjectCopy>TargetKeyPrefix"`
+		Manifest  string   `xml:"Manifest>Location>ObjectArn"`
+		ETag      string   `xml:"Manifest>Location>ETag"`
+		Fields    []string `xml:"Manifest>Spec>Fields>member"`
+		Report    bool     `xml:"Report>Enabled"`
+		Token     string   `xml:"ClientRequestToken"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v20180820/jobs" || r.Header.Get("X-Amz-Account-Id") != "123" {
+			t.Errorf("want a POST of the jobs of account 123, got %s %s %q", r.Method, r.URL.Path, r.Header.Get("X-Amz-Account-Id"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(body, &got); err != nil {
+			t.Errorf("can't decode request: %v", err)
+		}
+		if got.Target == "arn:aws:s3:::denied" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<CreateJobResult><JobId>job-1</JobId></CreateJobResult>`)
+	}))
+	defer srv.Close()
+
+	client := batch.Client{
+		Credentials: creds.Static{Auth: aws.Auth{AccessKey: "key", SecretKey: "secret"}},
+		AccountID:   "123",
+		Region:      "us-east-1",
+		Endpoint:    srv.URL,
+	}
+	job := batch.Job{
+		RoleARN:        "arn:aws:iam::123:role/batch",
+		ManifestBucket: "state",
+		ManifestKey:    "manifests/m.csv",
+		ManifestETag:   `"etag"`,
+		TargetBucket:   "dst",
+		TargetPrefix:   "copied/",
+	}
+	id, err := client.CreateJob(job)
+	if err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	if id != "job-1" {
+		t.Errorf("want job-1, got %q", id)
+	}
+	if got.AccountID != "123" || got.Target != "arn:aws:s3:::dst" || got.Prefix != "copied/" ||
+		got.Manifest != "arn:aws:s3:::state/manifests/m.csv" || got.ETag != `"etag"` ||
+		strings.Join(got.Fields, ",") != "Bucket,Key" || got.Report || len(got.Token) == 0 {
+		t.Errorf("want the job in the request, got %+v", got)
+	}
+	token := got.Token
+
+	// the same job has the same token, so it's only created once
+	job.ReportBucket = "reports"
+	if _, err := client.CreateJob(job); err != nil {
+		t.Fatalf("can't create job: %v", err)
+	}
+	if !got.Report || got.Token != token {
+		t.Errorf("want a report and the same token, got %+v", got)
+	}
+
+	job.TargetBucket = "denied"
+	if _, err := client.CreateJob(job); err == nil || !strings.Contains(err.Error(), "AccessDenied: not allowed") {
+		t.Errorf("want the error of S3 Control, got %v", err)
+	}
+	job.RoleARN = ""
+	if _, err := client.CreateJob(job); err == nil {
+		t.Error("want an error creating a job without a role")
+	}
+}
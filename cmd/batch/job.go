@@ -0,0 +1,214 @@
+package batch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	controlNamespace = "http://awss3control.amazonaws.com/doc/2018-08-20/"
+	reportFormat     = "Report_CSV_20180820"
+)
+
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// Job of S3 Batch Operations that copies the keys of a manifest to a bucket.
+type Job struct {
+	// RoleARN is the role the job assumes to read the manifest and the keys,
+	// and to write the copies and the report.
+	RoleARN string
+	// ManifestBucket, ManifestKey and ManifestETag locate the manifest, and
+	// its version.
+	ManifestBucket string
+	ManifestKey    string
+	ManifestETag   string
+	// TargetBucket the keys are copied to, under TargetPrefix.
+	TargetBucket string
+	TargetPrefix string
+	// ReportBucket is optional, the completion report of the job is written
+	// there under ReportPrefix, with the tasks that failed, or all of them
+	// with ReportAll.
+	ReportBucket string
+	ReportPrefix string
+	ReportAll    bool
+	Priority     int
+	Description  string
+	// ConfirmationRequired holds the job until it's confirmed in the
+	// console, to check its manifest first.
+	ConfirmationRequired bool
+}
+
+// Validate that the job has what it needs to be created.
+func (j Job) Validate() error {
+	switch {
+	case j.RoleARN == "":
+		return errors.New("need the role of the job")
+	case j.ManifestBucket == "" || j.ManifestKey == "" || j.ManifestETag == "":
+		return errors.New("need the location and ETag of the manifest")
+	case j.TargetBucket == "":
+		return errors.New("need the bucket the keys are copied to")
+	case j.Priority < 0:
+		return fmt.Errorf("priority must be positive, got %d", j.Priority)
+	}
+	return nil
+}
+
+// token identifies the job, so that creating it twice with the same manifest
+// creates a single job.
+func (j Job) token() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s\x00%s\x00%s/%s", j.ManifestBucket, j.ManifestKey, j.ManifestETag, j.TargetBucket, j.TargetPrefix)))
+	return hex.EncodeToString(sum[:])
+}
+
+type createJobRequest struct {
+	XMLName              xml.Name `xml:"CreateJobRequest"`
+	Namespace            string   `xml:"xmlns,attr"`
+	AccountID            string   `xml:"AccountId"`
+	ConfirmationRequired bool     `xml:"ConfirmationRequired"`
+	Operation            struct {
+		TargetResource  string `xml:"S3PutObjectCopy>TargetResource"`
+		TargetKeyPrefix string `xml:"S3PutObjectCopy>TargetKeyPrefix,omitempty"`
+	} `xml:"Operation"`
+	Report struct {
+		Bucket      string `xml:"Bucket,omitempty"`
+		Format      string `xml:"Format,omitempty"`
+		Enabled     bool   `xml:"Enabled"`
+		Prefix      string `xml:"Prefix,omitempty"`
+		ReportScope string `xml:"ReportScope,omitempty"`
+	} `xml:"Report"`
+	ClientRequestToken string `xml:"ClientRequestToken"`
+	Manifest           struct {
+		Format    string   `xml:"Spec>Format"`
+		Fields    []string `xml:"Spec>Fields>member"`
+		ObjectARN string   `xml:"Location>ObjectArn"`
+		ETag      string   `xml:"Location>ETag"`
+	} `xml:"Manifest"`
+	Description string `xml:"Description,omitempty"`
+	Priority    int    `xml:"Priority"`
+	RoleARN     string `xml:"RoleArn"`
+}
+
+type createJobResult struct {
+	JobID string `xml:"JobId"`
+}
+
+type controlError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// Client of the jobs of S3 Batch Operations of an account.
+type Client struct {
+	Credentials aws.CredentialsProvider
+	AccountID   string
+	Region      string
+	// Endpoint of S3 Control, https://<account>.s3-control.<region>.amazonaws.com
+	// when empty.
+	Endpoint string
+	Client   *http.Client
+}
+
+// CreateJob creates a job, and returns its ID. The job starts once S3 read
+// its manifest, unless it requires a confirmation.
+func (c Client) CreateJob(job Job) (string, error) {
+	if err := job.Validate(); err != nil {
+		return "", err
+	}
+	if c.Credentials == nil {
+		return "", errors.New("need credentials to create a job")
+	}
+	auth, err := c.Credentials.Credentials()
+	if err != nil {
+		return "", err
+	}
+
+	r := createJobRequest{
+		Namespace:            controlNamespace,
+		AccountID:            c.AccountID,
+		ConfirmationRequired: job.ConfirmationRequired,
+		ClientRequestToken:   job.token(),
+		Description:          job.Description,
+		Priority:             job.Priority,
+		RoleARN:              job.RoleARN,
+	}
+	r.Operation.TargetResource = "arn:aws:s3:::" + job.TargetBucket
+	r.Operation.TargetKeyPrefix = job.TargetPrefix
+	if job.ReportBucket != "" {
+		r.Report.Bucket = "arn:aws:s3:::" + job.ReportBucket
+		r.Report.Format = reportFormat
+		r.Report.Enabled = true
+		r.Report.Prefix = job.ReportPrefix
+		r.Report.ReportScope = "FailedTasksOnly"
+		if job.ReportAll {
+			r.Report.ReportScope = "AllTasks"
+		}
+	}
+	r.Manifest.Format = ManifestFormat
+	r.Manifest.Fields = ManifestFields
+	r.Manifest.ObjectARN = "arn:aws:s3:::" + job.ManifestBucket + "/" + job.ManifestKey
+	r.Manifest.ETag = job.ManifestETag
+	body, err := xml.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3-control.%s.amazonaws.com", c.AccountID, c.Region)
+	}
+	req, err := http.NewRequest("POST", endpoint+"/v20180820/jobs", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Amz-Account-Id", c.AccountID)
+	req.Header.Set("Content-Type", "application/xml")
+	if token := auth.SessionToken(); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	aws.NewV4Signer(auth, "s3", aws.Region{Name: c.Region}).Sign(req)
+
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("creating batch job: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("creating batch job: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("creating batch job: %s", parseError(data, resp.Status))
+	}
+	var result createJobResult
+	if err := xml.Unmarshal(data, &result); err != nil || result.JobID == "" {
+		return "", fmt.Errorf("decoding the created batch job: %q", data)
+	}
+	return result.JobID, nil
+}
+
+// parseError finds the code and message of an error of S3 Control, which
+// may or may not be wrapped in an ErrorResponse.
+func parseError(data []byte, status string) string {
+	var wrapped struct {
+		Error controlError `xml:"Error"`
+	}
+	e := wrapped.Error
+	if xml.Unmarshal(data, &wrapped) == nil && wrapped.Error.Code != "" {
+		e = wrapped.Error
+	} else if xml.Unmarshal(data, &e) != nil || e.Code == "" {
+		return status
+	}
+	return e.Code + ": " + e.Message
+}
@@ -0,0 +1,427 @@
+// Package bench measures the throughput and latency that S3 gives brigade
+// on given buckets, to tune the parallelism of the syncs with numbers rather
+// than guesses. It writes synthetic keys under a scratch prefix of the
+// source, then lists, syncs and deletes them with each parallelism, and
+// deletes everything it wrote once done.
+package bench
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Sirupsen/logrus"
+	"github.com/aybabtme/humanize"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// The phases of a benchmark.
+const (
+	// Generate puts the synthetic keys in the source.
+	Generate = "generate"
+	// List lists the synthetic keys, each worker listing some of them.
+	List = "list"
+	// Sync copies the keys to the destination with a SyncTask.
+	Sync = "sync"
+	// Delete deletes the copies with a DeleteTask.
+	Delete = "delete"
+	// Cleanup deletes the synthetic keys from the source.
+	Cleanup = "cleanup"
+)
+
+// Config of a benchmark.
+type Config struct {
+	// Prefix under which the keys are written, in both buckets. It must
+	// hold no keys: the synthetic keys are under its keys/ prefix, and
+	// their copies under its copies/ prefix.
+	Prefix string
+	// Keys to write, of Size bytes each.
+	Keys int
+	Size int64
+	// Parallelism of each cycle of list, sync and delete.
+	Parallelism []int
+}
+
+// Validate the config.
+func (c Config) Validate() error {
+	switch {
+	case c.Prefix == "" || !strings.HasSuffix(c.Prefix, "/"):
+		return fmt.Errorf("need a scratch prefix ending with /, got %q", c.Prefix)
+	case c.Keys < 1:
+		return fmt.Errorf("need at least a key, got %d", c.Keys)
+	case c.Size < 0:
+		return fmt.Errorf("size of the keys can't be negative, got %d", c.Size)
+	case len(c.Parallelism) == 0:
+		return errors.New("need at least a parallelism")
+	}
+	for _, p := range c.Parallelism {
+		if p < 1 {
+			return fmt.Errorf("parallelism must be at least 1, got %d", p)
+		}
+	}
+	return nil
+}
+
+func (c Config) maxParallelism() int {
+	max := 0
+	for _, p := range c.Parallelism {
+		if p > max {
+			max = p
+		}
+	}
+	return max
+}
+
+// Result of a phase of a benchmark.
+type Result struct {
+	Phase       string `json:"phase"`
+	Parallelism int    `json:"parallelism"`
+	// Keys done by the phase, and those that Failed.
+	Keys           int64   `json:"keys"`
+	Failed         int64   `json:"failed"`
+	Bytes          int64   `json:"bytes"`
+	Seconds        float64 `json:"seconds"`
+	KeysPerSecond  float64 `json:"keys_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	// Latency of the requests of the phase to S3, retries included, in
+	// milliseconds, see monitor.Summary.Millis.
+	Latency map[string]float64 `json:"latency"`
+}
+
+// Bench runs the phases of a benchmark on a source and a destination, which
+// can be the same bucket.
+type Bench struct {
+	cfg      Config
+	src, dst *s3.Bucket
+	// latency of the requests of the current phase
+	latency atomic.Value
+	// keys generated in the source
+	keys []s3.Key
+}
+
+// New creates a benchmark of the buckets. The requests to S3 are timed by
+// wrapping the Transport of the buckets, or http.DefaultTransport when they
+// have none.
+func New(src, dst *s3.Bucket, cfg Config) (*Bench, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	b := &Bench{cfg: cfg}
+	b.latency.Store(monitor.NewHistogram())
+	b.src, b.dst = b.timed(src), b.timed(dst)
+	return b, nil
+}
+
+// timed is a copy of a bucket whose requests are timed.
+func (b *Bench) timed(bkt *s3.Bucket) *s3.Bucket {
+	s := *bkt.S3
+	s.Transport = timing{next: s.Transport, b: b}
+	return &s3.Bucket{S3: &s, Name: bkt.Name}
+}
+
+type timing struct {
+	next http.RoundTripper
+	b    *Bench
+}
+
+func (t timing) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	t.b.latency.Load().(*monitor.Histogram).Record(time.Since(start))
+	return resp, err
+}
+
+// Run the benchmark: generate the keys, run a cycle of each parallelism,
+// and clean up. The results are passed to progress as the phases end, if
+// it's set. The keys generated are deleted even when a cycle fails.
+func (b *Bench) Run(progress func(Result)) ([]Result, error) {
+	var results []Result
+	report := func(r Result) {
+		results = append(results, r)
+		if progress != nil {
+			progress(r)
+		}
+	}
+	r, err := b.Generate()
+	report(r)
+	if err != nil {
+		return results, err
+	}
+	for _, p := range b.cfg.Parallelism {
+		var cycle []Result
+		cycle, err = b.Cycle(p)
+		for _, r := range cycle {
+			report(r)
+		}
+		if err != nil {
+			break
+		}
+	}
+	r, cleanErr := b.Cleanup()
+	report(r)
+	if err == nil {
+		err = cleanErr
+	}
+	return results, err
+}
+
+func (b *Bench) keysPrefix() string   { return b.cfg.Prefix + "keys/" }
+func (b *Bench) copiesPrefix() string { return b.cfg.Prefix + "copies/" }
+
+// shard of the key i, so that the keys can be listed by many workers.
+func (b *Bench) shard(i int) string {
+	return fmt.Sprintf("%s%04d/", b.keysPrefix(), i%b.cfg.maxParallelism())
+}
+
+// Generate checks that the scratch prefix holds no keys, and puts the
+// synthetic keys under it, as many at once as the largest parallelism.
+func (b *Bench) Generate() (Result, error) {
+	for _, bkt := range []*s3.Bucket{b.src, b.dst} {
+		resp, err := bkt.List(b.cfg.Prefix, "", "", 1)
+		if err != nil {
+			return Result{Phase: Generate}, fmt.Errorf("listing scratch prefix %q of bucket %q: %v", b.cfg.Prefix, bkt.Name, err)
+		}
+		if len(resp.Contents) > 0 {
+			return Result{Phase: Generate}, fmt.Errorf("scratch prefix %q of bucket %q already holds keys, need an empty one", b.cfg.Prefix, bkt.Name)
+		}
+	}
+
+	para := b.cfg.maxParallelism()
+	data := make([]byte, b.cfg.Size)
+	// random content, so that nothing on the way compresses it
+	_, _ = rand.New(rand.NewSource(time.Now().UnixNano())).Read(data)
+	names := make(chan string, para*pipeline.BufferFactor)
+	put := make(chan s3.Key, para*pipeline.BufferFactor)
+	var failed int64
+
+	phase := b.start()
+	var workers pipeline.Workers
+	workers.Start(para, func(int) {
+		for name := range names {
+			if err := b.src.Put(name, data, "application/octet-stream", s3.Private, s3.Options{}); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"key":   name,
+					"error": err,
+				}).Warn("couldn't put synthetic key")
+				atomic.AddInt64(&failed, 1)
+				continue
+			}
+			put <- s3.Key{Key: name, Size: b.cfg.Size}
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for key := range put {
+			b.keys = append(b.keys, key)
+		}
+	}()
+	for i := 0; i < b.cfg.Keys; i++ {
+		names <- fmt.Sprintf("%s%08d", b.shard(i), i)
+	}
+	close(names)
+	workers.Wait()
+	close(put)
+	<-done
+
+	r := phase.result(Generate, para, int64(len(b.keys)), failed, int64(len(b.keys))*b.cfg.Size)
+	if len(b.keys) == 0 {
+		return r, errors.New("couldn't put any synthetic key")
+	}
+	return r, nil
+}
+
+// Cycle lists the synthetic keys, syncs them and deletes their copies, each
+// with a parallelism of para.
+func (b *Bench) Cycle(para int) ([]Result, error) {
+	var results []Result
+	r, keys, err := b.list(para)
+	results = append(results, r)
+	if err != nil {
+		return results, err
+	}
+	r, syncErr := b.sync(para, keys)
+	results = append(results, r)
+	// the copies are deleted even when the sync failed, as some were made
+	copies := make([]s3.Key, len(keys))
+	for i, key := range keys {
+		copies[i] = s3.Key{Key: b.copiesPrefix() + strings.TrimPrefix(key.Key, b.keysPrefix())}
+	}
+	r, err = b.delete(Delete, para, b.dst, copies)
+	results = append(results, r)
+	if syncErr != nil {
+		return results, syncErr
+	}
+	return results, err
+}
+
+// Cleanup deletes the synthetic keys, as many batches at once as the
+// largest parallelism.
+func (b *Bench) Cleanup() (Result, error) {
+	r, err := b.delete(Cleanup, b.cfg.maxParallelism(), b.src, b.keys)
+	if err == nil {
+		b.keys = nil
+	}
+	return r, err
+}
+
+// list the shards of the synthetic keys with para workers, a page at a
+// time.
+func (b *Bench) list(para int) (Result, []s3.Key, error) {
+	shards := make(chan string, b.cfg.maxParallelism())
+	for i := 0; i < b.cfg.maxParallelism() && i < b.cfg.Keys; i++ {
+		shards <- b.shard(i)
+	}
+	close(shards)
+	listed := make(chan s3.Key, para*pipeline.BufferFactor)
+	var firstErr pipeline.FirstError
+
+	phase := b.start()
+	var workers pipeline.Workers
+	workers.Start(para, func(int) {
+		for shard := range shards {
+			marker := ""
+			for {
+				resp, err := b.src.List(shard, "", marker, 1000)
+				if err != nil {
+					firstErr.Set(fmt.Errorf("listing %q: %v", shard, err))
+					break
+				}
+				for _, key := range resp.Contents {
+					listed <- key
+				}
+				if !resp.IsTruncated || len(resp.Contents) == 0 {
+					break
+				}
+				marker = resp.Contents[len(resp.Contents)-1].Key
+			}
+		}
+	})
+	var keys []s3.Key
+	var size int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for key := range listed {
+			keys = append(keys, key)
+			size += key.Size
+		}
+	}()
+	workers.Wait()
+	close(listed)
+	<-done
+	return phase.result(List, para, int64(len(keys)), 0, size), keys, firstErr.Err()
+}
+
+// sync the keys to their copies with a SyncTask of para workers.
+func (b *Bench) sync(para int, keys []s3.Key) (Result, error) {
+	var input bytes.Buffer
+	w, _ := listing.NewWriter(&input, listing.JSON)
+	for _, key := range keys {
+		if err := w.Write(key); err != nil {
+			return Result{Phase: Sync, Parallelism: para}, err
+		}
+	}
+	task, err := sync.NewSyncTask(b.src, b.dst,
+		sync.WithConcurrency(para),
+		sync.WithMapping(sync.Mapping{From: b.keysPrefix(), To: b.copiesPrefix()}),
+		sync.WithCopier(sync.PutCopy),
+	)
+	if err != nil {
+		return Result{Phase: Sync, Parallelism: para}, err
+	}
+	phase := b.start()
+	err = task.Start(&input, ioutil.Discard, ioutil.Discard)
+	progress := task.Progress()
+	return phase.result(Sync, para, progress.Synced, progress.Failed, progress.Bytes), err
+}
+
+// delete keys from a bucket with a DeleteTask deleting para batches at
+// once, each batch small enough that all the workers have one.
+func (b *Bench) delete(name string, para int, bkt *s3.Bucket, keys []s3.Key) (Result, error) {
+	var input bytes.Buffer
+	w, _ := listing.NewWriter(&input, listing.JSON)
+	for _, key := range keys {
+		if err := w.Write(key); err != nil {
+			return Result{Phase: name, Parallelism: para}, err
+		}
+	}
+	task, err := sync.NewDeleteTask(bkt)
+	if err != nil {
+		return Result{Phase: name, Parallelism: para}, err
+	}
+	task.DeletePara = para
+	task.BatchSize = (len(keys) + para - 1) / para
+	switch {
+	case task.BatchSize < 1:
+		task.BatchSize = 1
+	case task.BatchSize > sync.DeleteBatch:
+		task.BatchSize = sync.DeleteBatch
+	}
+	phase := b.start()
+	err = task.Start(&input, ioutil.Discard, ioutil.Discard)
+	progress := task.Progress()
+	return phase.result(name, para, progress.Deleted, progress.Failed, 0), err
+}
+
+// phase being timed, with the latency of its requests.
+type phase struct {
+	start   time.Time
+	latency *monitor.Histogram
+}
+
+// start timing a phase.
+func (b *Bench) start() phase {
+	p := phase{start: time.Now(), latency: monitor.NewHistogram()}
+	b.latency.Store(p.latency)
+	return p
+}
+
+func (p phase) result(name string, para int, keys, failed, size int64) Result {
+	elapsed := time.Since(p.start)
+	r := Result{
+		Phase:       name,
+		Parallelism: para,
+		Keys:        keys,
+		Failed:      failed,
+		Bytes:       size,
+		Seconds:     elapsed.Seconds(),
+		Latency:     p.latency.Summarize().Millis(),
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		r.KeysPerSecond = float64(keys) / secs
+		r.BytesPerSecond = float64(size) / secs
+	}
+	return r
+}
+
+// Table formats results as a table, for people to read.
+func Table(results []Result) string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "phase\tparallelism\tkeys\tfailed\tkeys/s\tbytes/s\tp50\tp99\tmax")
+	ms := func(r Result, name string) string {
+		return fmt.Sprintf("%.1fms", r.Latency[name])
+	}
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%.1f\t%s\t%s\t%s\t%s\n",
+			r.Phase, r.Parallelism, humanize.Comma(r.Keys), humanize.Comma(r.Failed), r.KeysPerSecond,
+			humanize.Bytes(uint64(r.BytesPerSecond)), ms(r, "p50_ms"), ms(r, "p99_ms"), ms(r, "max_ms"))
+	}
+	_ = tw.Flush()
+	return buf.String()
+}
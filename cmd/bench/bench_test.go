@@ -0,0 +1,82 @@
+package bench_test
+
+import (
+	"github.com/Shopify/brigade/cmd/bench"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+	src, dst := mocks3.S3().Bucket("src-bucket"), mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	if err := src.Put("kept", []byte("kept"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put kept key: %v", err)
+	}
+
+	cfg := bench.Config{Prefix: "bench/", Keys: 25, Size: 10, Parallelism: []int{1, 4}}
+	b, err := bench.New(src, dst, cfg)
+	if err != nil {
+		t.Fatalf("can't create bench: %v", err)
+	}
+	var phases []string
+	results, err := b.Run(func(r bench.Result) { phases = append(phases, r.Phase) })
+	if err != nil {
+		t.Fatalf("can't run bench: %v", err)
+	}
+	want := []string{"generate", "list", "sync", "delete", "list", "sync", "delete", "cleanup"}
+	if strings.Join(phases, ",") != strings.Join(want, ",") {
+		t.Fatalf("want phases %v, got %v", want, phases)
+	}
+	for i, r := range results {
+		if r.Keys != 25 || r.Failed != 0 {
+			t.Errorf("want all the keys done by phase %d, got %+v", i, r)
+		}
+		if r.Latency["count"] == 0 {
+			t.Errorf("want the requests of phase %d timed, got %+v", i, r)
+		}
+	}
+	if results[2].Parallelism != 1 || results[5].Parallelism != 4 || results[5].Bytes != 250 {
+		t.Errorf("want the second sync with 4 workers, got %+v", results[5])
+	}
+	if table := bench.Table(results); strings.Count(table, "\n") != len(results)+1 {
+		t.Errorf("want a line per result, got\n%s", table)
+	}
+
+	// everything written is deleted, and only that
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		resp, err := bkt.List("", "", "", 1000)
+		if err != nil {
+			t.Fatalf("can't list bucket: %v", err)
+		}
+		if n := len(resp.Contents); n != 0 && (bkt != src || n != 1) {
+			t.Errorf("want the keys of the bench deleted from %q, got %d keys", bkt.Name, n)
+		}
+	}
+
+	// the scratch prefix must be empty
+	cfg.Prefix = ""
+	if _, err := bench.New(src, dst, cfg); err == nil {
+		t.Errorf("want an error without a scratch prefix")
+	}
+	b, err = bench.New(src, dst, bench.Config{Prefix: "kept/", Keys: 1, Parallelism: []int{1}})
+	if err != nil {
+		t.Fatalf("can't create bench: %v", err)
+	}
+	if err := src.Put("kept/a", []byte("kept"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put kept key: %v", err)
+	}
+	if _, err := b.Run(nil); err == nil || !strings.Contains(err.Error(), "already holds keys") {
+		t.Errorf("want an error benching in a prefix holding keys, got %v", err)
+	}
+}
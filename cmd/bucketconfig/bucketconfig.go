@@ -0,0 +1,220 @@
+// Package bucketconfig copies the configuration of a bucket to another, so
+// that the destination of a migration behaves like its source once its keys
+// are synced: its CORS rules, lifecycle rules, website, tags and policy.
+package bucketconfig
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Subresources are the configurations of buckets that are copied, in the
+// order they're copied.
+var Subresources = []string{"cors", "lifecycle", "website", "tagging", "policy"}
+
+// missingCodes are the codes of the errors of the configurations a bucket
+// doesn't have.
+var missingCodes = map[string]string{
+	"cors":      "NoSuchCORSConfiguration",
+	"lifecycle": "NoSuchLifecycleConfiguration",
+	"website":   "NoSuchWebsiteConfiguration",
+	"tagging":   "NoSuchTagSet",
+	"policy":    "NoSuchBucketPolicy",
+}
+
+// The actions that make the configuration of the destination the one of
+// the source.
+const (
+	Create = "create"
+	Update = "update"
+	Same   = "same"
+	// Extra is a configuration the destination has but not the source,
+	// which is only deleted when pruning.
+	Extra = "extra"
+)
+
+// Change is how a configuration of the destination differs from the one of
+// the source.
+type Change struct {
+	Subresource string
+	Action      string
+	// Source and Dest are the documents of the configuration, nil when the
+	// bucket has none. The source policy names the destination bucket.
+	Source, Dest []byte
+}
+
+// Validate checks that the names of configurations are ones that are
+// copied.
+func Validate(subresources []string) error {
+	for _, name := range subresources {
+		if _, ok := missingCodes[name]; !ok {
+			return fmt.Errorf("unknown bucket configuration %q, want one of %s", name, strings.Join(Subresources, ", "))
+		}
+	}
+	return nil
+}
+
+// Diff compares the configurations of src and dst, returning a change for
+// each of subresources.
+func Diff(src, dst *s3.Bucket, subresources []string) ([]Change, error) {
+	if err := Validate(subresources); err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, name := range subresources {
+		srcDoc, err := get(src, name)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get %s of source %q: %v", name, src.Name, err)
+		}
+		dstDoc, err := get(dst, name)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get %s of destination %q: %v", name, dst.Name, err)
+		}
+		if name == "policy" && srcDoc != nil {
+			srcDoc = renamePolicy(srcDoc, src.Name, dst.Name)
+		}
+		c := Change{Subresource: name, Source: srcDoc, Dest: dstDoc}
+		switch {
+		case srcDoc == nil && dstDoc == nil:
+			continue
+		case srcDoc == nil:
+			c.Action = Extra
+		case dstDoc == nil:
+			c.Action = Create
+		case same(name, srcDoc, dstDoc):
+			c.Action = Same
+		default:
+			c.Action = Update
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// Apply the changes to dst, deleting its extra configurations if prune is
+// set.
+func Apply(dst *s3.Bucket, changes []Change, prune bool) error {
+	for _, c := range changes {
+		log := logrus.WithFields(logrus.Fields{
+			"bucket":        dst.Name,
+			"configuration": c.Subresource,
+			"action":        c.Action,
+		})
+		var err error
+		switch c.Action {
+		case Create, Update:
+			err = dst.PutBucketConfig(c.Subresource, c.Source)
+		case Extra:
+			if !prune {
+				log.Warn("destination has a configuration the source doesn't, leaving it")
+				continue
+			}
+			err = dst.DelBucketSubresource(c.Subresource)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't %s %s of %q: %v", c.Action, c.Subresource, dst.Name, err)
+		}
+		log.Info("copied bucket configuration")
+	}
+	return nil
+}
+
+// WriteDiff writes the changes as a diff of each configuration, the lines
+// of the destination prefixed with -, those of the source with +.
+func WriteDiff(w io.Writer, changes []Change) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range changes {
+		fmt.Fprintf(bw, "=== %s: %s\n", c.Subresource, c.Action)
+		if c.Action == Same {
+			continue
+		}
+		writeLines(bw, "- ", c.Dest)
+		writeLines(bw, "+ ", c.Source)
+	}
+	return bw.Flush()
+}
+
+func writeLines(w io.Writer, prefix string, doc []byte) {
+	for _, line := range strings.Split(strings.TrimSpace(string(doc)), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "%s%s\n", prefix, line)
+		}
+	}
+}
+
+// get the document of a configuration of bkt, nil if it has none.
+func get(bkt *s3.Bucket, name string) ([]byte, error) {
+	doc, err := bkt.GetBucketSubresource(name)
+	if s3.IsS3Error(err, missingCodes[name]) {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// renamePolicy makes the ARNs of the source bucket in its policy the ARNs
+// of the destination bucket. The accounts of the principals are kept.
+func renamePolicy(doc []byte, src, dst string) []byte {
+	arn := regexp.MustCompile(`(arn:aws[a-z-]*:s3:::)` + regexp.QuoteMeta(src) + `(["/])`)
+	return arn.ReplaceAll(doc, []byte("${1}"+dst+"${2}"))
+}
+
+// same is true if two documents of a configuration are equivalent: the same
+// JSON values for policies, the same XML elements for the others.
+func same(name string, a, b []byte) bool {
+	if name == "policy" {
+		var va, vb interface{}
+		if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+			return bytes.Equal(a, b)
+		}
+		return reflect.DeepEqual(va, vb)
+	}
+	na, erra := normalizeXML(a)
+	nb, errb := normalizeXML(b)
+	if erra != nil || errb != nil {
+		return bytes.Equal(a, b)
+	}
+	return na == nb
+}
+
+// normalizeXML is the XML elements of doc without the whitespace between
+// them.
+func normalizeXML(doc []byte) (string, error) {
+	var buf bytes.Buffer
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	enc := xml.NewEncoder(&buf)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.ProcInst, xml.Comment:
+			continue
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) == 0 {
+				continue
+			}
+		}
+		if err := enc.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return "", err
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
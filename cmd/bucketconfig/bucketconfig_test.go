@@ -0,0 +1,107 @@
+package bucketconfig_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/bucketconfig"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"testing"
+)
+
+const (
+	cors = `<CORSConfiguration><CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod></CORSRule></CORSConfiguration>`
+	// the same rule, formatted differently
+	corsIndented = `<?xml version="1.0" encoding="UTF-8"?>
+<CORSConfiguration>
+  <CORSRule>
+    <AllowedOrigin>*</AllowedOrigin>
+    <AllowedMethod>GET</AllowedMethod>
+  </CORSRule>
+</CORSConfiguration>`
+	lifecycle = `<LifecycleConfiguration><Rule><ID>expire-logs</ID><Prefix>logs/</Prefix><Status>Enabled</Status><Expiration><Days>30</Days></Expiration></Rule></LifecycleConfiguration>`
+	website   = `<WebsiteConfiguration><IndexDocument><Suffix>index.html</Suffix></IndexDocument></WebsiteConfiguration>`
+	policy    = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::src-bucket/*"}]}`
+)
+
+func TestCopyBucketConfig(t *testing.T) {
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	for name, doc := range map[string]string{"cors": cors, "lifecycle": lifecycle, "policy": policy} {
+		if err := src.PutBucketConfig(name, []byte(doc)); err != nil {
+			t.Fatalf("can't put %s: %v", name, err)
+		}
+	}
+	if err := dst.PutBucketConfig("cors", []byte(corsIndented)); err != nil {
+		t.Fatalf("can't put cors: %v", err)
+	}
+	if err := dst.PutBucketConfig("website", []byte(website)); err != nil {
+		t.Fatalf("can't put website: %v", err)
+	}
+
+	changes, err := bucketconfig.Diff(src, dst, bucketconfig.Subresources)
+	if err != nil {
+		t.Fatalf("can't diff: %v", err)
+	}
+	want := map[string]string{
+		"cors":      bucketconfig.Same,
+		"lifecycle": bucketconfig.Create,
+		"website":   bucketconfig.Extra,
+		"policy":    bucketconfig.Create,
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("want %d changes, got %+v", len(want), changes)
+	}
+	for _, c := range changes {
+		if c.Action != want[c.Subresource] {
+			t.Errorf("want %s to %s, got %s", c.Subresource, want[c.Subresource], c.Action)
+		}
+	}
+	var diff bytes.Buffer
+	if err := bucketconfig.WriteDiff(&diff, changes); err != nil {
+		t.Fatalf("can't write diff: %v", err)
+	}
+	if !strings.Contains(diff.String(), "=== lifecycle: create\n+ <LifecycleConfiguration>") {
+		t.Errorf("want the lifecycle created in the diff, got\n%s", diff.String())
+	}
+
+	if err := bucketconfig.Apply(dst, changes, false); err != nil {
+		t.Fatalf("can't apply: %v", err)
+	}
+	got, err := dst.GetBucketSubresource("policy")
+	if err != nil {
+		t.Fatalf("can't get the policy of the destination: %v", err)
+	}
+	if !strings.Contains(string(got), "arn:aws:s3:::dst-bucket/*") {
+		t.Errorf("want the policy to name the destination, got %s", got)
+	}
+
+	// the website of the destination is only deleted when pruning
+	changes, err = bucketconfig.Diff(src, dst, bucketconfig.Subresources)
+	if err != nil {
+		t.Fatalf("can't diff: %v", err)
+	}
+	for _, c := range changes {
+		if c.Action != bucketconfig.Same && c.Action != bucketconfig.Extra {
+			t.Errorf("want %s the same once applied, got %s", c.Subresource, c.Action)
+		}
+	}
+	if err := bucketconfig.Apply(dst, changes, true); err != nil {
+		t.Fatalf("can't apply: %v", err)
+	}
+	if _, err := dst.GetBucketSubresource("website"); !s3.IsS3Error(err, "NoSuchWebsiteConfiguration") {
+		t.Errorf("want the website of the destination deleted, got %v", err)
+	}
+
+	if _, err := bucketconfig.Diff(src, dst, []string{"acl"}); err == nil {
+		t.Errorf("want unknown configurations refused")
+	}
+}
@@ -0,0 +1,151 @@
+// Package checksum hashes the content of keys to compare them where their
+// ETags can't tell, such as keys uploaded in parts. The sums that objects
+// already carry, in their user metadata or in the checksums S3 computes, are
+// used instead of reading the objects whenever they're there.
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Hash of the content of keys.
+type Hash interface {
+	// Name of the hash, as Parse knows it.
+	Name() string
+	// New starts hashing some content.
+	New() hash.Hash
+}
+
+type hashFunc struct {
+	name string
+	new  func() hash.Hash
+}
+
+func (h hashFunc) Name() string   { return h.name }
+func (h hashFunc) New() hash.Hash { return h.new() }
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// The hashes known to Parse.
+var (
+	MD5    Hash = hashFunc{name: "md5", new: md5.New}
+	SHA256 Hash = hashFunc{name: "sha256", new: sha256.New}
+	XXHash Hash = hashFunc{name: "xxhash", new: func() hash.Hash { return NewXXHash() }}
+	CRC32C Hash = hashFunc{name: "crc32c", new: func() hash.Hash { return crc32.New(castagnoli) }}
+)
+
+// Hashes are all the hashes known to Parse.
+var Hashes = []Hash{MD5, SHA256, XXHash, CRC32C}
+
+// Parse finds a hash by its name.
+func Parse(name string) (Hash, error) {
+	var names []string
+	for _, h := range Hashes {
+		if h.Name() == name {
+			return h, nil
+		}
+		names = append(names, h.Name())
+	}
+	return nil, fmt.Errorf("unknown hash %q, want one of %s", name, strings.Join(names, ", "))
+}
+
+// amzChecksum is the prefix of the headers of the checksums S3 computes
+// itself, such as x-amz-checksum-sha256.
+const amzChecksum = "x-amz-checksum-"
+
+// Checksum of the content of keys with a Hash, and where objects carry it.
+type Checksum struct {
+	Hash Hash
+	// Meta is the name of the user metadata carrying the sum of objects,
+	// without its x-amz-meta- prefix, or the header of a checksum S3
+	// computes, such as x-amz-checksum-crc32c. The sums are hex or base64
+	// encoded.
+	Meta string
+}
+
+// ParseChecksum parses a hash and the metadata carrying the sums of objects,
+// such as sha256:content-sha256. The metadata is named after the hash when
+// it's left out.
+func ParseChecksum(spec string) (Checksum, error) {
+	name, meta := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, meta = spec[:i], spec[i+1:]
+	}
+	h, err := Parse(name)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if meta == "" {
+		meta = h.Name()
+	}
+	return Checksum{Hash: h, Meta: strings.ToLower(meta)}, nil
+}
+
+// String is the spec ParseChecksum parses the checksum from.
+func (c Checksum) String() string { return c.Hash.Name() + ":" + c.Meta }
+
+// Computed tells whether S3 computes the sums carried by objects from the
+// data it stores, rather than them being user metadata set by whoever
+// uploaded the objects, and copied along with them.
+func (c Checksum) Computed() bool { return strings.HasPrefix(c.Meta, amzChecksum) }
+
+// Headers to send with a HEAD request for objects to carry their sum: S3
+// only returns the checksums it computes when asked to.
+func (c Checksum) Headers() map[string][]string {
+	if !c.Computed() {
+		return nil
+	}
+	return map[string][]string{"x-amz-checksum-mode": {"ENABLED"}}
+}
+
+// Header returns the sum carried by the headers of an object, if it does.
+func (c Checksum) Header(h http.Header) ([]byte, bool) {
+	name := c.Meta
+	if !c.Computed() {
+		name = "x-amz-meta-" + name
+	}
+	return c.decode(h.Get(name))
+}
+
+// Metadata returns the sum carried by the user metadata of an object, by
+// name without the x-amz-meta- prefix, as in enriched listings.
+func (c Checksum) Metadata(meta map[string]string) ([]byte, bool) {
+	if c.Computed() {
+		return nil, false
+	}
+	return c.decode(meta[c.Meta])
+}
+
+// decode a sum carried by an object, hex or base64 encoded. Values that
+// aren't a sum of the hash aren't.
+func (c Checksum) decode(value string) ([]byte, bool) {
+	size := c.Hash.New().Size()
+	if value == "" {
+		return nil, false
+	}
+	if sum, err := hex.DecodeString(value); err == nil && len(sum) == size {
+		return sum, true
+	}
+	if sum, err := base64.StdEncoding.DecodeString(value); err == nil && len(sum) == size {
+		return sum, true
+	}
+	return nil, false
+}
+
+// Sum reads some content until EOF to hash it.
+func (c Checksum) Sum(r io.Reader) ([]byte, error) {
+	h := c.Hash.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
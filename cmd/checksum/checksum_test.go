@@ -0,0 +1,109 @@
+package checksum_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestXXHash(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+	} {
+		h := checksum.NewXXHash()
+		h.Write([]byte(tt.data))
+		if got := h.Sum64(); got != tt.want {
+			t.Errorf("xxhash of %q: want %x, got %x", tt.data, tt.want, got)
+		}
+	}
+
+	// writes of any size hash the same as the whole content at once
+	data := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz"), 10)
+	whole := checksum.NewXXHash()
+	whole.Write(data)
+	for _, size := range []int{1, 7, 31, 32, 33, 100} {
+		h := checksum.NewXXHash()
+		for p := data; len(p) > 0; {
+			n := size
+			if n > len(p) {
+				n = len(p)
+			}
+			h.Write(p[:n])
+			p = p[n:]
+		}
+		if h.Sum64() != whole.Sum64() {
+			t.Errorf("writes of %d bytes: want %x, got %x", size, whole.Sum64(), h.Sum64())
+		}
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	for spec, want := range map[string]string{
+		"sha256":                       "sha256:sha256",
+		"md5:Content-MD5":              "md5:content-md5",
+		"crc32c:x-amz-checksum-crc32c": "crc32c:x-amz-checksum-crc32c",
+		"xxhash:xxh64":                 "xxhash:xxh64",
+	} {
+		c, err := checksum.ParseChecksum(spec)
+		if err != nil {
+			t.Errorf("can't parse %q: %v", spec, err)
+			continue
+		}
+		if c.String() != want {
+			t.Errorf("%q: want %s, got %s", spec, want, c)
+		}
+	}
+	if _, err := checksum.ParseChecksum("sha1"); err == nil {
+		t.Errorf("want an error for an unknown hash")
+	}
+}
+
+func TestChecksumCarried(t *testing.T) {
+	c, err := checksum.ParseChecksum("sha256:content-sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := c.Sum(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, value := range []string{hex.EncodeToString(want), base64.StdEncoding.EncodeToString(want)} {
+		h := http.Header{}
+		h.Set("X-Amz-Meta-Content-Sha256", value)
+		if got, ok := c.Header(h); !ok || !bytes.Equal(got, want) {
+			t.Errorf("want the sum of %q carried, got %x", value, got)
+		}
+		if got, ok := c.Metadata(map[string]string{"content-sha256": value}); !ok || !bytes.Equal(got, want) {
+			t.Errorf("want the sum of %q in the metadata, got %x", value, got)
+		}
+	}
+	for _, value := range []string{"", "not a sum", hex.EncodeToString(want[:16])} {
+		if _, ok := c.Metadata(map[string]string{"content-sha256": value}); ok {
+			t.Errorf("want no sum carried by %q", value)
+		}
+	}
+	if c.Computed() || c.Headers() != nil {
+		t.Errorf("want user metadata, not a checksum computed by S3")
+	}
+
+	c, err = checksum.ParseChecksum("crc32c:x-amz-checksum-crc32c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ = c.Sum(strings.NewReader("hello"))
+	h := http.Header{}
+	h.Set("x-amz-checksum-crc32c", base64.StdEncoding.EncodeToString(want))
+	if got, ok := c.Header(h); !c.Computed() || !ok || !bytes.Equal(got, want) {
+		t.Errorf("want the checksum computed by S3, got %x", got)
+	}
+}
@@ -0,0 +1,127 @@
+package checksum
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// The primes of XXH64, variables for their sums to wrap around.
+var (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+// xxhash is XXH64 with a seed of 0, a fast hash that isn't cryptographic,
+// for tools that checksum objects with it.
+type xxhash struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	// bytes of a stripe of 32 not hashed yet
+	mem [32]byte
+	n   int
+}
+
+// NewXXHash starts hashing some content with XXH64, with a seed of 0.
+func NewXXHash() hash.Hash64 {
+	x := &xxhash{}
+	x.Reset()
+	return x
+}
+
+func (x *xxhash) Reset() {
+	x.v1 = prime1 + prime2
+	x.v2 = prime2
+	x.v3 = 0
+	x.v4 = -prime1
+	x.total = 0
+	x.n = 0
+}
+
+func (x *xxhash) Size() int      { return 8 }
+func (x *xxhash) BlockSize() int { return 32 }
+
+func (x *xxhash) Write(p []byte) (int, error) {
+	n := len(p)
+	x.total += uint64(n)
+	if x.n+len(p) < 32 {
+		x.n += copy(x.mem[x.n:], p)
+		return n, nil
+	}
+	if x.n > 0 {
+		c := copy(x.mem[x.n:], p)
+		x.stripe(x.mem[:])
+		p = p[c:]
+		x.n = 0
+	}
+	for ; len(p) >= 32; p = p[32:] {
+		x.stripe(p)
+	}
+	x.n = copy(x.mem[:], p)
+	return n, nil
+}
+
+func (x *xxhash) stripe(p []byte) {
+	x.v1 = round(x.v1, binary.LittleEndian.Uint64(p[0:8]))
+	x.v2 = round(x.v2, binary.LittleEndian.Uint64(p[8:16]))
+	x.v3 = round(x.v3, binary.LittleEndian.Uint64(p[16:24]))
+	x.v4 = round(x.v4, binary.LittleEndian.Uint64(p[24:32]))
+}
+
+func (x *xxhash) Sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64(x.v1, 1) + bits.RotateLeft64(x.v2, 7) +
+			bits.RotateLeft64(x.v3, 12) + bits.RotateLeft64(x.v4, 18)
+		h = merge(h, x.v1)
+		h = merge(h, x.v2)
+		h = merge(h, x.v3)
+		h = merge(h, x.v4)
+	} else {
+		h = prime5
+	}
+	h += x.total
+
+	p := x.mem[:x.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*prime1 + prime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * prime1
+		h = bits.RotateLeft64(h, 23)*prime2 + prime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		h ^= uint64(b) * prime5
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return h
+}
+
+// Sum appends the sum, big endian like the other 64 bit hashes.
+func (x *xxhash) Sum(b []byte) []byte {
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], x.Sum64())
+	return append(b, sum[:]...)
+}
+
+func round(acc, input uint64) uint64 {
+	acc += input * prime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * prime1
+}
+
+func merge(acc, v uint64) uint64 {
+	acc ^= round(0, v)
+	return acc*prime1 + prime4
+}
@@ -0,0 +1,230 @@
+// Package compare checks that a destination holds the same keys as a source,
+// with the same content, from listings of both. It only reads, so it can gate
+// a migration on its outcome: the keys missing from the destination, the
+// extra keys in it, and the keys whose size, ETag or metadata differ. The
+// differences left by a previous migration can be tolerated.
+package compare
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+)
+
+// The differences between the keys of the source and the destination.
+const (
+	// Missing keys are in the source but not in the destination.
+	Missing = "missing"
+	// Extra keys are in the destination but not in the source.
+	Extra = "extra"
+	// SizeMismatch keys are in both, with other sizes.
+	SizeMismatch = "size_mismatch"
+	// ETagMismatch keys are in both with the same size, but other ETags.
+	ETagMismatch = "etag_mismatch"
+	// ChecksumMismatch keys are in both with the same size, but carry other
+	// sums of the Checksum of the comparison.
+	ChecksumMismatch = "checksum_mismatch"
+	// MetadataMismatch keys are in both with the same content, but other
+	// metadata.
+	MetadataMismatch = "metadata_mismatch"
+	// Stale keys are in both with the same content and metadata, but the
+	// destination was last modified before the source.
+	Stale = "stale"
+)
+
+// Report counts the keys compared, and those that differ, with a sample of
+// the keys of each difference.
+type Report struct {
+	Source       int64 `json:"source"`
+	Destination  int64 `json:"destination"`
+	Matching     int64 `json:"matching"`
+	Missing      int64 `json:"missing"`
+	Extra        int64 `json:"extra"`
+	SizeMismatch int64 `json:"size_mismatch"`
+	ETagMismatch int64 `json:"etag_mismatch"`
+	// ChecksumMismatch keys are only counted with a Checksum.
+	ChecksumMismatch int64 `json:"checksum_mismatch,omitempty"`
+	// MetadataMismatch and Stale keys are only counted when they aren't
+	// tolerated.
+	MetadataMismatch int64 `json:"metadata_mismatch"`
+	Stale            int64 `json:"stale"`
+	// Samples of the keys of each difference, by their name in the
+	// destination.
+	Samples map[string][]string `json:"samples,omitempty"`
+}
+
+// Same tells whether the destination holds the keys of the source, with the
+// same content, and no others unless ignoreExtra is set.
+func (r Report) Same(ignoreExtra bool) bool {
+	if r.Missing+r.SizeMismatch+r.ETagMismatch+r.ChecksumMismatch+r.MetadataMismatch+r.Stale > 0 {
+		return false
+	}
+	return ignoreExtra || r.Extra == 0
+}
+
+type object struct {
+	size         int64
+	etag         string
+	lastModified string
+	contentType  string
+	metadata     map[string]string
+}
+
+func objectOf(e listing.Enriched) object {
+	return object{
+		size:         e.Key.Size,
+		etag:         e.Key.ETag,
+		lastModified: e.Key.LastModified,
+		contentType:  e.ContentType,
+		metadata:     e.Metadata,
+	}
+}
+
+// Comparer compares the keys of a source to those of a destination, from
+// listings or from the listing of the buckets. The destination keys are held
+// in memory while the source keys are streamed.
+type Comparer struct {
+	// Tolerance of the comparison, which tolerates the ETags of multipart
+	// uploads unless it's changed before the keys are written.
+	Tolerance Tolerance
+	// Checksum, when set, compares the keys that both carry a sum of it in
+	// their user metadata by their sums rather than by their ETags, which
+	// tells keys uploaded in parts apart without reading them. The listings
+	// must be enriched with the metadata, such as by head.
+	Checksum *checksum.Checksum
+
+	mapKey  func(string) string
+	samples int
+	dst     map[string]object
+	report  Report
+}
+
+// New creates a comparer keeping up to samples keys of each difference. The
+// source keys are mapped to their name in the destination by mapKey if it's
+// set.
+func New(mapKey func(string) string, samples int) *Comparer {
+	return &Comparer{
+		Tolerance: Tolerance{Multipart: true},
+		mapKey:    mapKey,
+		samples:   samples,
+		dst:       make(map[string]object),
+		report:    Report{Samples: make(map[string][]string)},
+	}
+}
+
+// Writer of the keys of a source or a destination, as listed, with their
+// metadata when the listing is enriched.
+type Writer interface {
+	listing.Writer
+	listing.EnrichedWriter
+}
+
+// Destination is the writer of the keys of the destination, as listed. They
+// must all be written before the source is.
+func (c *Comparer) Destination() Writer { return destination{c} }
+
+// Source is the writer of the keys of the source, as listed. Each is compared
+// to the destination, and each destination key found in the source is done
+// with.
+func (c *Comparer) Source() Writer { return source{c} }
+
+type destination struct{ c *Comparer }
+
+func (w destination) Write(key s3.Key) error {
+	return w.WriteEnriched(listing.Enriched{Key: key})
+}
+
+func (w destination) WriteEnriched(e listing.Enriched) error {
+	c := w.c
+	if _, ok := c.dst[e.Key.Key]; !ok {
+		c.report.Destination++
+	}
+	c.dst[e.Key.Key] = objectOf(e)
+	return nil
+}
+
+func (w destination) Flush() error { return nil }
+
+type source struct{ c *Comparer }
+
+func (w source) Write(key s3.Key) error {
+	return w.WriteEnriched(listing.Enriched{Key: key})
+}
+
+func (w source) WriteEnriched(e listing.Enriched) error {
+	c := w.c
+	c.report.Source++
+	key, src := e.Key, objectOf(e)
+	name := key.Key
+	if c.mapKey != nil {
+		name = c.mapKey(name)
+	}
+	dst, ok := c.dst[name]
+	if !ok {
+		c.report.Missing++
+		c.sample(Missing, name)
+		return nil
+	}
+	delete(c.dst, name)
+	sameSum, summed := c.sameSum(src, dst)
+	switch {
+	case dst.size != key.Size:
+		c.report.SizeMismatch++
+		c.sample(SizeMismatch, name)
+	case summed && !sameSum:
+		c.report.ChecksumMismatch++
+		c.sample(ChecksumMismatch, name)
+	case !summed && !c.Tolerance.SameETag(dst.etag, key.ETag):
+		c.report.ETagMismatch++
+		c.sample(ETagMismatch, name)
+	case !c.Tolerance.sameMetadata(src, dst):
+		c.report.MetadataMismatch++
+		c.sample(MetadataMismatch, name)
+	case c.Tolerance.stale(key.LastModified, dst.lastModified):
+		c.report.Stale++
+		c.sample(Stale, name)
+	default:
+		c.report.Matching++
+	}
+	return nil
+}
+
+func (w source) Flush() error { return nil }
+
+// Finish counts the destination keys that weren't in the source, and
+// reports on the comparison.
+func (c *Comparer) Finish() Report {
+	names := make([]string, 0, len(c.dst))
+	for name := range c.dst {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.report.Extra++
+		c.sample(Extra, name)
+	}
+	c.dst = make(map[string]object)
+	return c.report
+}
+
+// sameSum tells whether a key and its copy carry the same sum of the
+// Checksum, if they both carry one.
+func (c *Comparer) sameSum(src, dst object) (same, summed bool) {
+	if c.Checksum == nil {
+		return false, false
+	}
+	srcSum, srcOk := c.Checksum.Metadata(src.metadata)
+	dstSum, dstOk := c.Checksum.Metadata(dst.metadata)
+	if !srcOk || !dstOk {
+		return false, false
+	}
+	return bytes.Equal(srcSum, dstSum), true
+}
+
+func (c *Comparer) sample(diff, name string) {
+	if len(c.report.Samples[diff]) < c.samples {
+		c.report.Samples[diff] = append(c.report.Samples[diff], name)
+	}
+}
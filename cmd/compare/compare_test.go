@@ -0,0 +1,205 @@
+package compare_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/compare"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func copyListing(t *testing.T, dst listing.Writer, keys []s3.Key) {
+	var buf bytes.Buffer
+	w, err := listing.NewWriter(&buf, "json")
+	if err != nil {
+		t.Fatalf("can't create listing: %v", err)
+	}
+	for _, k := range keys {
+		if err := w.Write(k); err != nil {
+			t.Fatalf("can't write listing: %v", err)
+		}
+	}
+	r, err := listing.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+	if _, err := listing.Copy(dst, r); err != nil {
+		t.Fatalf("can't compare listing: %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	src := []s3.Key{
+		{Key: "legacy/same", Size: 3, ETag: `"aaa"`},
+		{Key: "legacy/resized", Size: 3, ETag: `"bbb"`},
+		{Key: "legacy/changed", Size: 3, ETag: `"ccc"`},
+		{Key: "legacy/missing-1", Size: 4, ETag: `"ddd"`},
+		{Key: "legacy/missing-2", Size: 4, ETag: `"ddd"`},
+		// only compared by size, as its ETag is of a multipart upload
+		{Key: "legacy/multipart", Size: 10, ETag: `"eee"`},
+	}
+	dst := []s3.Key{
+		{Key: "shard-1/same", Size: 3, ETag: `"aaa"`},
+		{Key: "shard-1/resized", Size: 5, ETag: `"bbb"`},
+		{Key: "shard-1/changed", Size: 3, ETag: `"fff"`},
+		{Key: "shard-1/multipart", Size: 10, ETag: `"ggg-2"`},
+		{Key: "shard-1/extra-b", Size: 1, ETag: `"hhh"`},
+		{Key: "shard-1/extra-a", Size: 1, ETag: `"hhh"`},
+	}
+	mapKey := func(key string) string { return "shard-1/" + strings.TrimPrefix(key, "legacy/") }
+
+	c := compare.New(mapKey, 1)
+	copyListing(t, c.Destination(), dst)
+	copyListing(t, c.Source(), src)
+	got := c.Finish()
+	want := compare.Report{
+		Source:       6,
+		Destination:  6,
+		Matching:     2,
+		Missing:      2,
+		Extra:        2,
+		SizeMismatch: 1,
+		ETagMismatch: 1,
+		Samples: map[string][]string{
+			compare.Missing:      {"shard-1/missing-1"},
+			compare.Extra:        {"shard-1/extra-a"},
+			compare.SizeMismatch: {"shard-1/resized"},
+			compare.ETagMismatch: {"shard-1/changed"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want report\n%+v\ngot\n%+v", want, got)
+	}
+	if got.Same(true) {
+		t.Errorf("want the buckets to differ")
+	}
+
+	c = compare.New(nil, 10)
+	copyListing(t, c.Destination(), append(src, s3.Key{Key: "extra"}))
+	copyListing(t, c.Source(), src)
+	got = c.Finish()
+	if got.Matching != 6 || got.Extra != 1 || got.Same(false) || !got.Same(true) {
+		t.Errorf("want the buckets the same but for an extra key, got %+v", got)
+	}
+}
+
+func TestCompareTolerance(t *testing.T) {
+	enriched := func(key s3.Key, contentType string, meta map[string]string) string {
+		data, err := json.Marshal(listing.Enriched{Key: key, ContentType: contentType, Metadata: meta})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data) + "\n"
+	}
+	src := enriched(s3.Key{Key: "meta", Size: 1, ETag: `"aaa"`}, "text/plain", map[string]string{"owner": "a"}) +
+		enriched(s3.Key{Key: "stale", Size: 1, ETag: `"bbb"`, LastModified: "2017-02-01T00:00:00.000Z"}, "", nil) +
+		enriched(s3.Key{Key: "multipart", Size: 1, ETag: `"ccc-2"`}, "", nil) +
+		enriched(s3.Key{Key: "plain", Size: 1, ETag: `"ddd"`}, "text/plain", nil)
+	dst := enriched(s3.Key{Key: "meta", Size: 1, ETag: `"aaa"`}, "text/plain", map[string]string{"owner": "b"}) +
+		enriched(s3.Key{Key: "stale", Size: 1, ETag: `"bbb"`, LastModified: "2017-01-01T00:00:00.000Z"}, "", nil) +
+		enriched(s3.Key{Key: "multipart", Size: 1, ETag: `"eee"`}, "", nil) +
+		// not enriched, so its metadata isn't compared
+		enriched(s3.Key{Key: "plain", Size: 1, ETag: `"ddd"`}, "", nil)
+
+	tests := []struct {
+		tolerate string
+		want     compare.Report
+	}{
+		{"", compare.Report{Matching: 1, ETagMismatch: 1, MetadataMismatch: 1, Stale: 1}},
+		{"multipart", compare.Report{Matching: 2, MetadataMismatch: 1, Stale: 1}},
+		{"last-modified,multipart,metadata", compare.Report{Matching: 4}},
+	}
+	for _, tt := range tests {
+		tolerance, err := compare.ParseTolerance(tt.tolerate)
+		if err != nil {
+			t.Fatalf("can't parse %q: %v", tt.tolerate, err)
+		}
+		c := compare.New(nil, 0)
+		c.Tolerance = tolerance
+		for _, l := range []struct {
+			w    compare.Writer
+			data string
+		}{{c.Destination(), dst}, {c.Source(), src}} {
+			r, err := listing.NewReader(strings.NewReader(l.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := listing.CopyEnriched(l.w, r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got := c.Finish()
+		tt.want.Source, tt.want.Destination, tt.want.Samples = 4, 4, map[string][]string{}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tolerating %q, want report\n%+v\ngot\n%+v", tt.tolerate, tt.want, got)
+		}
+		if got := tolerance.String(); got != tt.tolerate {
+			t.Errorf("want tolerance %q, got %q", tt.tolerate, got)
+		}
+	}
+
+	if _, err := compare.ParseTolerance("size"); err == nil {
+		t.Errorf("want an error tolerating an unknown difference")
+	}
+}
+
+func TestCompareChecksum(t *testing.T) {
+	enriched := func(key s3.Key, sum string) string {
+		var meta map[string]string
+		if sum != "" {
+			meta = map[string]string{"xxh64": sum}
+		}
+		data, err := json.Marshal(listing.Enriched{Key: key, ContentType: "text/plain", Metadata: meta})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data) + "\n"
+	}
+	// keys uploaded in parts, whose copies have other ETags
+	src := enriched(s3.Key{Key: "same", Size: 1, ETag: `"aaa-2"`}, "00000000000000aa") +
+		enriched(s3.Key{Key: "changed", Size: 1, ETag: `"bbb-2"`}, "00000000000000bb") +
+		enriched(s3.Key{Key: "unsummed", Size: 1, ETag: `"ccc-2"`}, "")
+	dst := enriched(s3.Key{Key: "same", Size: 1, ETag: `"ddd"`}, "00000000000000aa") +
+		enriched(s3.Key{Key: "changed", Size: 1, ETag: `"eee"`}, "00000000000000ff") +
+		enriched(s3.Key{Key: "unsummed", Size: 1, ETag: `"fff"`}, "")
+
+	sum, err := checksum.ParseChecksum("xxhash:xxh64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := compare.New(nil, 1)
+	// without tolerating them, the ETags of the keys without sums differ
+	c.Tolerance = compare.Tolerance{Metadata: true}
+	c.Checksum = &sum
+	for _, l := range []struct {
+		w    compare.Writer
+		data string
+	}{{c.Destination(), dst}, {c.Source(), src}} {
+		r, err := listing.NewReader(strings.NewReader(l.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := listing.CopyEnriched(l.w, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := c.Finish()
+	want := compare.Report{
+		Source:           3,
+		Destination:      3,
+		Matching:         1,
+		ETagMismatch:     1,
+		ChecksumMismatch: 1,
+		Samples: map[string][]string{
+			compare.ETagMismatch:     {"unsummed"},
+			compare.ChecksumMismatch: {"changed"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want report\n%+v\ngot\n%+v", want, got)
+	}
+}
@@ -0,0 +1,109 @@
+package compare
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The differences that a Tolerance can tolerate, by the name ParseTolerance
+// knows them by.
+const (
+	ToleranceLastModified = "last-modified"
+	ToleranceMultipart    = "multipart"
+	ToleranceMetadata     = "metadata"
+)
+
+// Tolerance of a comparison, the differences between a key and its copy
+// that don't count, such as those left by a previous migration.
+type Tolerance struct {
+	// LastModified tolerates copies last modified before the key they're a
+	// copy of, as when the source was rewritten after being copied.
+	LastModified bool
+	// Multipart tolerates copies of the same size whose ETags differ, when
+	// either is the ETag of a multipart upload: it isn't the MD5 of the
+	// content, and a copy doesn't keep it.
+	Multipart bool
+	// Metadata tolerates copies whose Content-Type or user metadata differ.
+	// They're only compared when both listings are enriched with them.
+	Metadata bool
+}
+
+// ParseTolerance parses a comma separated list of the differences to
+// tolerate, such as "multipart,last-modified", or none when empty.
+func ParseTolerance(spec string) (Tolerance, error) {
+	var t Tolerance
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case ToleranceLastModified:
+			t.LastModified = true
+		case ToleranceMultipart:
+			t.Multipart = true
+		case ToleranceMetadata:
+			t.Metadata = true
+		default:
+			return Tolerance{}, fmt.Errorf("can't tolerate %q, only %s, %s or %s", name, ToleranceLastModified, ToleranceMultipart, ToleranceMetadata)
+		}
+	}
+	return t, nil
+}
+
+// String lists the tolerated differences, as ParseTolerance parses them.
+func (t Tolerance) String() string {
+	var names []string
+	if t.LastModified {
+		names = append(names, ToleranceLastModified)
+	}
+	if t.Multipart {
+		names = append(names, ToleranceMultipart)
+	}
+	if t.Metadata {
+		names = append(names, ToleranceMetadata)
+	}
+	return strings.Join(names, ",")
+}
+
+// SameETag tells whether two keys of the same size have the same content by
+// their ETags.
+func (t Tolerance) SameETag(a, b string) bool {
+	a, b = strings.Trim(a, `"`), strings.Trim(b, `"`)
+	if t.Multipart && (IsMultipart(a) || IsMultipart(b)) {
+		return true
+	}
+	return a == b
+}
+
+// IsMultipart tells whether an ETag is the one of a multipart upload.
+func IsMultipart(etag string) bool { return strings.Contains(etag, "-") }
+
+// stale tells whether a copy was last modified before the key it's a copy
+// of. Keys whose LastModified can't be parsed aren't stale.
+func (t Tolerance) stale(src, dst string) bool {
+	if t.LastModified {
+		return false
+	}
+	srcTime, err := time.Parse(time.RFC3339, src)
+	if err != nil {
+		return false
+	}
+	dstTime, err := time.Parse(time.RFC3339, dst)
+	return err == nil && dstTime.Before(srcTime)
+}
+
+// sameMetadata tells whether a copy has the metadata of the key it's a copy
+// of, when both are known.
+func (t Tolerance) sameMetadata(src, dst object) bool {
+	if t.Metadata || src.contentType == "" || dst.contentType == "" {
+		return true
+	}
+	if src.contentType != dst.contentType || len(src.metadata) != len(dst.metadata) {
+		return false
+	}
+	for name, value := range src.metadata {
+		if v, ok := dst.metadata[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
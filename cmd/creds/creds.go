@@ -0,0 +1,150 @@
+// Package creds provides the credentials that the S3 requests of long syncs
+// are signed with, from static keys, the environment, the role of the EC2
+// instance, a role assumed through STS, or the AWS secrets engine of Vault.
+// Temporary credentials are fetched again before they expire, while the
+// requests go on, rather than failing the requests with ExpiredToken once
+// they did.
+package creds
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/aws"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var metrics = struct {
+	fetched     *expvar.Int
+	fetchErrors *expvar.Int
+}{
+	fetched:     expvar.NewInt("brigade.creds.fetched"),
+	fetchErrors: expvar.NewInt("brigade.creds.fetchErrors"),
+}
+
+const (
+	// DefaultMargin is how long before they expire credentials are fetched
+	// again.
+	DefaultMargin = 5 * time.Minute
+	// retryEvery is how often credentials that failed to be fetched again
+	// are retried, while the ones fetched before haven't expired.
+	retryEvery = 10 * time.Second
+)
+
+// defaultClient makes the requests of the fetchers without a client of their
+// own.
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+func client(c *http.Client) *http.Client {
+	if c == nil {
+		return defaultClient
+	}
+	return c
+}
+
+// Fetcher fetches credentials, and when they expire. Credentials that don't
+// expire have a zero expiration.
+type Fetcher interface {
+	Fetch() (auth aws.Auth, expires time.Time, err error)
+}
+
+// Static credentials, such as the keys of a config.
+type Static struct {
+	Auth aws.Auth
+}
+
+// Credentials returns the static credentials.
+func (s Static) Credentials() (aws.Auth, error) { return s.Auth, nil }
+
+// Fetch returns the static credentials, which don't expire.
+func (s Static) Fetch() (aws.Auth, time.Time, error) { return s.Auth, time.Time{}, nil }
+
+// Env fetches the credentials from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables.
+type Env struct{}
+
+// Fetch reads the credentials from the environment.
+func (Env) Fetch() (aws.Auth, time.Time, error) {
+	auth, err := aws.EnvAuth()
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	auth, err = aws.GetAuth(auth.AccessKey, auth.SecretKey, os.Getenv("AWS_SESSION_TOKEN"), time.Time{})
+	return auth, time.Time{}, err
+}
+
+// Cached gives the credentials of a fetcher, and only fetches them again
+// once they expire within Margin, or half their lifetime when they're
+// shorter lived than twice the margin. When fetching them again fails, the
+// credentials fetched before keep being used as long as they haven't
+// expired, and fetching is retried every few seconds.
+type Cached struct {
+	Fetcher Fetcher
+	Margin  time.Duration
+
+	mu      sync.Mutex
+	auth    aws.Auth
+	expires time.Time
+	renew   time.Time
+	fetched bool
+	failed  time.Time
+}
+
+// NewCached caches the credentials of a fetcher, fetching them again
+// DefaultMargin before they expire.
+func NewCached(f Fetcher) *Cached {
+	return &Cached{Fetcher: f, Margin: DefaultMargin}
+}
+
+// Credentials returns the credentials of the fetcher, fetching them first
+// if they're missing or about to expire.
+func (c *Cached) Credentials() (aws.Auth, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case !c.fetched:
+	case c.expires.IsZero() || now.Before(c.renew):
+		return c.auth, nil
+	case now.Before(c.expires) && now.Sub(c.failed) < retryEvery:
+		// renewing them failed a moment ago
+		return c.auth, nil
+	}
+
+	auth, expires, err := c.Fetcher.Fetch()
+	if err != nil {
+		c.failed = now
+		metrics.fetchErrors.Add(1)
+		if c.fetched && now.Before(c.expires) {
+			logrus.WithFields(logrus.Fields{
+				"error":   err,
+				"expires": c.expires,
+			}).Warn("couldn't renew credentials, using the current ones until they expire")
+			return c.auth, nil
+		}
+		return aws.Auth{}, fmt.Errorf("fetching credentials: %v", err)
+	}
+	metrics.fetched.Add(1)
+	if !expires.IsZero() {
+		logrus.WithField("expires", expires).Info("fetched credentials")
+	}
+	c.auth, c.expires, c.fetched = auth, expires, true
+	margin := c.Margin
+	if lifetime := expires.Sub(now); lifetime < 2*margin {
+		margin = lifetime / 2
+	}
+	c.renew = expires.Add(-margin)
+	return c.auth, nil
+}
+
+// Expire the cached credentials, so that they're fetched again by the next
+// request, as when they're rotated.
+func (c *Cached) Expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched = false
+}
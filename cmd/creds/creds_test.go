@@ -0,0 +1,243 @@
+package creds_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/creds"
+	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rotating fetches new keys each time, expiring after ttl, or fails once
+// failing is set.
+type rotating struct {
+	ttl     time.Duration
+	fetches int32
+	failing bool
+}
+
+func (r *rotating) Fetch() (aws.Auth, time.Time, error) {
+	n := atomic.AddInt32(&r.fetches, 1)
+	if r.failing {
+		return aws.Auth{}, time.Time{}, errors.New("fetcher is down")
+	}
+	expires := time.Now().Add(r.ttl)
+	auth, err := aws.GetAuth(fmt.Sprintf("key%d", n), "secret", "token", expires)
+	return auth, expires, err
+}
+
+func TestCachedRenewsBeforeExpiry(t *testing.T) {
+	long := &rotating{ttl: time.Hour}
+	cached := creds.NewCached(long)
+	for i := 0; i < 3; i++ {
+		auth, err := cached.Credentials()
+		if err != nil {
+			t.Fatalf("can't get credentials: %v", err)
+		}
+		if auth.AccessKey != "key1" {
+			t.Errorf("want cached key1, got %q", auth.AccessKey)
+		}
+	}
+	if long.fetches != 1 {
+		t.Errorf("want credentials fetched once, got %d", long.fetches)
+	}
+
+	// credentials shorter lived than the margin are renewed at half their
+	// lifetime
+	short := &rotating{ttl: 200 * time.Millisecond}
+	cached = creds.NewCached(short)
+	for i := 1; i <= 3; i++ {
+		if i > 1 {
+			time.Sleep(150 * time.Millisecond)
+		}
+		auth, err := cached.Credentials()
+		if err != nil {
+			t.Fatalf("can't get credentials: %v", err)
+		}
+		if want := fmt.Sprintf("key%d", i); auth.AccessKey != want {
+			t.Errorf("want renewed %s, got %q", want, auth.AccessKey)
+		}
+	}
+}
+
+func TestCachedExpire(t *testing.T) {
+	f := &rotating{ttl: time.Hour}
+	cached := creds.NewCached(f)
+	for i := 1; i <= 2; i++ {
+		auth, err := cached.Credentials()
+		if err != nil {
+			t.Fatalf("can't get credentials: %v", err)
+		}
+		if want := fmt.Sprintf("key%d", i); auth.AccessKey != want {
+			t.Errorf("want %s, got %q", want, auth.AccessKey)
+		}
+		cached.Expire()
+	}
+}
+
+func TestCachedKeepsCredentialsOnError(t *testing.T) {
+	f := &rotating{ttl: time.Second}
+	cached := creds.NewCached(f)
+	if _, err := cached.Credentials(); err != nil {
+		t.Fatalf("can't get credentials: %v", err)
+	}
+
+	time.Sleep(600 * time.Millisecond)
+	f.failing = true
+	for i := 0; i < 3; i++ {
+		auth, err := cached.Credentials()
+		if err != nil {
+			t.Fatalf("want the unexpired credentials, got %v", err)
+		}
+		if auth.AccessKey != "key1" {
+			t.Errorf("want key1, got %q", auth.AccessKey)
+		}
+	}
+	if f.fetches != 2 {
+		t.Errorf("want a single retry in a few seconds, got %d fetches", f.fetches)
+	}
+
+	if _, err := creds.NewCached(f).Credentials(); err == nil {
+		t.Error("want an error without credentials to fall back on")
+	}
+}
+
+func TestInstanceMetadata(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && r.URL.Path == "/latest/api/token" {
+			fmt.Fprint(w, "session")
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session" {
+			http.Error(w, "no session token", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "sync-role")
+		case "/latest/meta-data/iam/security-credentials/sync-role":
+			fmt.Fprint(w, `{"Code":"Success","AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"token","Expiration":"2030-01-02T15:04:05Z"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	auth, expires, err := creds.InstanceMetadata{Endpoint: srv.URL}.Fetch()
+	if err != nil {
+		t.Fatalf("can't fetch credentials: %v", err)
+	}
+	if auth.AccessKey != "ASIA" || auth.SecretKey != "secret" || auth.SessionToken() != "token" {
+		t.Errorf("want the credentials of the role, got %#v", auth)
+	}
+	if want := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC); !expires.Equal(want) {
+		t.Errorf("want expiration %v, got %v", want, expires)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=base/") {
+			t.Errorf("want the request signed with the base credentials, got %q", r.Header.Get("Authorization"))
+		}
+		if r.URL.Query().Get("RoleArn") == "arn:aws:iam::1:role/denied" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Code>AccessDenied</Code><Message>not allowed</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+<SessionToken>token</SessionToken><Expiration>2030-01-02T15:04:05Z</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+	defer srv.Close()
+
+	role := creds.AssumeRole{
+		Base:       creds.Static{Auth: aws.Auth{AccessKey: "base", SecretKey: "secret"}},
+		RoleARN:    "arn:aws:iam::1:role/sync",
+		ExternalID: "ext",
+		Endpoint:   srv.URL,
+	}
+	auth, expires, err := role.Fetch()
+	if err != nil {
+		t.Fatalf("can't assume role: %v", err)
+	}
+	if auth.AccessKey != "ASIA" || auth.SessionToken() != "token" || expires.Year() != 2030 {
+		t.Errorf("want the credentials of the role, got %#v expiring %v", auth, expires)
+	}
+	for _, want := range []string{"Action=AssumeRole", "ExternalId=ext", "DurationSeconds=3600", "RoleSessionName=brigade"} {
+		if !strings.Contains(query, want) {
+			t.Errorf("want %q in query %q", want, query)
+		}
+	}
+
+	role.RoleARN = "arn:aws:iam::1:role/denied"
+	if _, _, err := role.Fetch(); err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("want the error code of STS, got %v", err)
+	}
+}
+
+func TestVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		if r.URL.Path != "/v1/aws/sts/sync" {
+			t.Errorf("want path /v1/aws/sts/sync, got %q", r.URL.Path)
+		}
+		fmt.Fprint(w, `{"lease_duration":3600,"data":{"access_key":"ASIA","secret_key":"secret","security_token":"token"}}`)
+	}))
+	defer srv.Close()
+
+	auth, expires, err := creds.Vault{Addr: srv.URL + "/", Path: "aws/sts/sync", Token: "root"}.Fetch()
+	if err != nil {
+		t.Fatalf("can't read credentials: %v", err)
+	}
+	if auth.AccessKey != "ASIA" || auth.SessionToken() != "token" {
+		t.Errorf("want the leased credentials, got %#v", auth)
+	}
+	if d := time.Until(expires); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("want credentials expiring with their lease, in %v", d)
+	}
+
+	_, _, err = creds.Vault{Addr: srv.URL, Path: "aws/sts/sync", Token: "nope"}.Fetch()
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("want the errors of vault, got %v", err)
+	}
+}
+
+func TestS3SignsWithProvider(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Authorization"))
+		fmt.Fprint(w, "data")
+	}))
+	defer srv.Close()
+
+	region := aws.USEast
+	region.S3Endpoint = srv.URL
+	s := s3.New(aws.Auth{AccessKey: "static", SecretKey: "secret"}, region)
+	s.Credentials = creds.NewCached(&rotating{ttl: 200 * time.Millisecond})
+	bkt := s.Bucket("bucket")
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(150 * time.Millisecond)
+		}
+		if _, err := bkt.Get("key"); err != nil {
+			t.Fatalf("can't get key: %v", err)
+		}
+	}
+	if len(keys) != 2 || !strings.HasPrefix(keys[0], "AWS key1:") || !strings.HasPrefix(keys[1], "AWS key2:") {
+		t.Errorf("want each request signed with the renewed credentials, got %q", keys)
+	}
+}
@@ -0,0 +1,92 @@
+package creds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultMetadataEndpoint is the instance metadata service of EC2.
+const DefaultMetadataEndpoint = "http://169.254.169.254"
+
+// InstanceMetadata fetches the credentials of the role of the EC2 instance
+// from its metadata service, with a session token when the service demands
+// one (IMDSv2).
+type InstanceMetadata struct {
+	// Endpoint of the metadata service, DefaultMetadataEndpoint when empty.
+	Endpoint string
+	Client   *http.Client
+}
+
+type instanceCredentials struct {
+	Code            string
+	Message         string
+	AccessKeyId     string
+	SecretAccessKey string
+	Token           string
+	Expiration      time.Time
+}
+
+// Fetch gets the credentials of the role of the instance.
+func (m InstanceMetadata) Fetch() (aws.Auth, time.Time, error) {
+	endpoint := m.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultMetadataEndpoint
+	}
+	// without a session token, the service may still serve IMDSv1
+	token, _ := m.get("PUT", endpoint+"/latest/api/token", "")
+	session := strings.TrimSpace(string(token))
+
+	const path = "/latest/meta-data/iam/security-credentials/"
+	roles, err := m.get("GET", endpoint+path, session)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("getting the role of the instance: %v", err)
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return aws.Auth{}, time.Time{}, errors.New("instance has no role")
+	}
+	data, err := m.get("GET", endpoint+path+role, session)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("getting the credentials of role %q: %v", role, err)
+	}
+	var cred instanceCredentials
+	if err := json.Unmarshal(data, &cred); err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("decoding the credentials of role %q: %v", role, err)
+	}
+	if cred.Code != "Success" {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("credentials of role %q not available: %s: %s", role, cred.Code, cred.Message)
+	}
+	auth, err := aws.GetAuth(cred.AccessKeyId, cred.SecretAccessKey, cred.Token, cred.Expiration)
+	return auth, cred.Expiration, err
+}
+
+func (m InstanceMetadata) get(method, url, token string) ([]byte, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if method == "PUT" {
+		req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	} else if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	resp, err := client(m.Client).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return body, nil
+}
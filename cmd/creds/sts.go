@@ -0,0 +1,118 @@
+package creds
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultSTSEndpoint is the global endpoint of STS, which signs requests in
+// us-east-1.
+const DefaultSTSEndpoint = "https://sts.amazonaws.com"
+
+// AssumeRole fetches the temporary credentials of a role assumed through
+// STS, signing the request with the credentials of Base.
+type AssumeRole struct {
+	Base        aws.CredentialsProvider
+	RoleARN     string
+	SessionName string
+	// ExternalID is optional, for the roles of other accounts that demand
+	// it.
+	ExternalID string
+	// Duration of the credentials, an hour when 0.
+	Duration time.Duration
+	// Endpoint of STS, and Region it signs requests in, DefaultSTSEndpoint
+	// and us-east-1 when empty.
+	Endpoint string
+	Region   string
+	Client   *http.Client
+}
+
+type assumeRoleResponse struct {
+	Credentials struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      time.Time
+	} `xml:"AssumeRoleResult>Credentials"`
+}
+
+type stsError struct {
+	Code    string `xml:"Error>Code"`
+	Message string `xml:"Error>Message"`
+}
+
+// Fetch assumes the role.
+func (a AssumeRole) Fetch() (aws.Auth, time.Time, error) {
+	if a.Base == nil {
+		return aws.Auth{}, time.Time{}, errors.New("need base credentials to assume a role")
+	}
+	base, err := a.Base.Credentials()
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	endpoint, region := a.Endpoint, a.Region
+	if endpoint == "" {
+		endpoint = DefaultSTSEndpoint
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	duration := a.Duration
+	if duration == 0 {
+		duration = time.Hour
+	}
+	sessionName := a.SessionName
+	if sessionName == "" {
+		sessionName = "brigade"
+	}
+
+	params := url.Values{
+		"Action":          {"AssumeRole"},
+		"Version":         {"2011-06-15"},
+		"RoleArn":         {a.RoleARN},
+		"RoleSessionName": {sessionName},
+		"DurationSeconds": {strconv.Itoa(int(duration / time.Second))},
+	}
+	if a.ExternalID != "" {
+		params.Set("ExternalId", a.ExternalID)
+	}
+	req, err := http.NewRequest("GET", endpoint+"/?"+params.Encode(), nil)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	if token := base.SessionToken(); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	aws.NewV4Signer(base, "sts", aws.Region{Name: region}).Sign(req)
+
+	resp, err := client(a.Client).Do(req)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("assuming role %q: %v", a.RoleARN, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("assuming role %q: %v", a.RoleARN, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e stsError
+		if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+			return aws.Auth{}, time.Time{}, fmt.Errorf("assuming role %q: %s", a.RoleARN, resp.Status)
+		}
+		return aws.Auth{}, time.Time{}, fmt.Errorf("assuming role %q: %s: %s", a.RoleARN, e.Code, e.Message)
+	}
+	var r assumeRoleResponse
+	if err := xml.Unmarshal(body, &r); err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("decoding the credentials of role %q: %v", a.RoleARN, err)
+	}
+	c := r.Credentials
+	auth, err := aws.GetAuth(c.AccessKeyId, c.SecretAccessKey, c.SessionToken, c.Expiration)
+	return auth, c.Expiration, err
+}
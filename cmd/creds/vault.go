@@ -0,0 +1,65 @@
+package creds
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault fetches credentials leased from the AWS secrets engine of Vault,
+// such as from aws/creds/<role> or aws/sts/<role>, which expire with their
+// lease.
+type Vault struct {
+	// Addr of the Vault server, like https://vault:8200.
+	Addr string
+	// Path of the credentials, without the /v1/ prefix of the API.
+	Path   string
+	Token  string
+	Client *http.Client
+}
+
+type vaultSecret struct {
+	LeaseDuration int `json:"lease_duration"`
+	Data          struct {
+		AccessKey     string `json:"access_key"`
+		SecretKey     string `json:"secret_key"`
+		SecurityToken string `json:"security_token"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+// Fetch reads the credentials from Vault.
+func (v Vault) Fetch() (aws.Auth, time.Time, error) {
+	url := strings.TrimSuffix(v.Addr, "/") + "/v1/" + strings.TrimPrefix(v.Path, "/")
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	resp, err := client(v.Client).Do(req)
+	if err != nil {
+		return aws.Auth{}, time.Time{}, fmt.Errorf("reading %s from vault: %v", v.Path, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var secret vaultSecret
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	switch {
+	case resp.StatusCode != http.StatusOK:
+		return aws.Auth{}, time.Time{}, fmt.Errorf("reading %s from vault: %s: %s", v.Path, resp.Status, strings.Join(secret.Errors, ", "))
+	case err != nil:
+		return aws.Auth{}, time.Time{}, fmt.Errorf("decoding %s from vault: %v", v.Path, err)
+	case secret.Data.AccessKey == "" || secret.Data.SecretKey == "":
+		return aws.Auth{}, time.Time{}, fmt.Errorf("%s from vault has no AWS keys", v.Path)
+	}
+	var expires time.Time
+	if secret.LeaseDuration > 0 {
+		expires = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
+	}
+	d := secret.Data
+	auth, err := aws.GetAuth(d.AccessKey, d.SecretKey, d.SecurityToken, expires)
+	return auth, expires, err
+}
@@ -0,0 +1,119 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// handshakeTimeout bounds the time a client has to present its token.
+const handshakeTimeout = 10 * time.Second
+
+// maxTokenLen bounds the token read from a client.
+const maxTokenLen = 4096
+
+// handshakeOK is the reply of the daemon to a client that presented the
+// right token.
+const handshakeOK = "ok\n"
+
+// errUnauthorized is returned to clients that don't present the token.
+var errUnauthorized = errors.New("unauthorized")
+
+// authorized tells if got is the token. An empty token authorizes nobody.
+func authorized(token, got string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(got)) == 1
+}
+
+// authenticate reads the token that a client presents on its connection
+// before its first call, as a single line.
+func authenticate(conn net.Conn, token string) error {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+	// read a byte at a time, so that nothing past the token is consumed
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return fmt.Errorf("reading token: %v", err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if len(line) == maxTokenLen {
+			return errors.New("token is too long")
+		}
+		line = append(line, b[0])
+	}
+	if !authorized(token, string(line)) {
+		return errUnauthorized
+	}
+	if _, err := io.WriteString(conn, handshakeOK); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// present the token on a connection to the daemon.
+func present(conn net.Conn, token string) error {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(conn, token+"\n"); err != nil {
+		return fmt.Errorf("sending token: %v", err)
+	}
+	reply := make([]byte, len(handshakeOK))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != handshakeOK {
+		return errors.New("daemon refused the token")
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// Confine the local file name to the directory dir, returning the name
+// with its symlinks resolved. A relative name is relative to dir. It's an
+// error for the name, once resolved, to be outside of dir, so that a job
+// can't read or overwrite files elsewhere, even through a symlink.
+func Confine(dir, name string) (string, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("resolving jobs directory: %v", err)
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return "", fmt.Errorf("resolving jobs directory: %v", err)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	resolved, err := resolve(filepath.Clean(name))
+	if err != nil {
+		return "", fmt.Errorf("resolving %q: %v", name, err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is outside of the jobs directory %q", name, dir)
+	}
+	return resolved, nil
+}
+
+// resolve the symlinks of a clean, absolute name, of which only a parent
+// directory might exist.
+func resolve(name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if err == nil || !os.IsNotExist(err) {
+		return resolved, err
+	}
+	parent := filepath.Dir(name)
+	if parent == name {
+		return name, nil
+	}
+	if resolved, err = resolve(parent); err != nil {
+		return "", err
+	}
+	return filepath.Join(resolved, filepath.Base(name)), nil
+}
@@ -0,0 +1,286 @@
+// Package daemon runs many sync jobs in a long running process, which can
+// be driven remotely to submit jobs, follow their progress, pause, resume
+// or cancel them and fetch their results.
+package daemon
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	cmdsync "github.com/Shopify/brigade/cmd/sync"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The states of a job.
+const (
+	Running   = "running"
+	Done      = "done"
+	Failed    = "failed"
+	Cancelled = "cancelled"
+)
+
+// JobSpec describes a sync to perform. A daemon confines the local files
+// that a job names to its jobs directory, see Confine.
+type JobSpec struct {
+	// Source and Destination are URLs of the form s3://bucket/.
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Input is the name of a gzip'd key listing, a local file or an s3://
+	// URL.
+	Input string `json:"input"`
+	// Success and Failure are the names of the gzip'd outputs of the sync.
+	Success     string `json:"success"`
+	Failure     string `json:"failure"`
+	Concurrency int    `json:"concurrency"`
+	// Config is the name of a local config file with the AWS keys of the
+	// job, which otherwise uses those of the daemon.
+	Config string `json:"config,omitempty"`
+}
+
+// JobStatus is a snapshot of the state of a job.
+type JobStatus struct {
+	ID       string           `json:"id"`
+	Spec     JobSpec          `json:"spec"`
+	State    string           `json:"state"`
+	Error    string           `json:"error,omitempty"`
+	Progress cmdsync.Progress `json:"progress"`
+	Started  time.Time        `json:"started"`
+	Ended    time.Time        `json:"ended,omitempty"`
+}
+
+// PrepareFunc creates the sync task of a job, and a function that runs it to
+// completion. It returns the spec as the task runs it, such as with its
+// local files confined, which is the spec the job keeps.
+type PrepareFunc func(spec JobSpec) (prepared JobSpec, task *cmdsync.SyncTask, run func() error, err error)
+
+type job struct {
+	id      string
+	spec    JobSpec
+	task    *cmdsync.SyncTask
+	started time.Time
+	done    chan struct{}
+
+	// guarded by the manager's lock
+	state string
+	err   error
+	ended time.Time
+}
+
+// Manager keeps track of the jobs of the daemon.
+type Manager struct {
+	prepare PrepareFunc
+	nextID  uint64
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// NewManager creates a manager that prepares its jobs with prepare.
+func NewManager(prepare PrepareFunc) *Manager {
+	return &Manager{
+		prepare: prepare,
+		jobs:    make(map[string]*job),
+	}
+}
+
+// Submit a job, which starts running right away. Returns the ID of the job.
+func (m *Manager) Submit(spec JobSpec) (string, error) {
+	spec, task, run, err := m.prepare(spec)
+	if err != nil {
+		return "", fmt.Errorf("preparing job: %v", err)
+	}
+
+	j := &job{
+		id:      fmt.Sprintf("job-%d", atomic.AddUint64(&m.nextID, 1)),
+		spec:    spec,
+		task:    task,
+		started: time.Now(),
+		done:    make(chan struct{}),
+		state:   Running,
+	}
+	m.mu.Lock()
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"job_id": j.id,
+		"source": spec.Source,
+		"dest":   spec.Destination,
+		"input":  spec.Input,
+	}).Info("starting job")
+
+	go func() {
+		defer close(j.done)
+		err := run()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		j.ended = time.Now()
+		j.err = err
+		switch {
+		case errors.Is(err, cmdsync.ErrCancelled):
+			j.state = Cancelled
+		case err != nil:
+			j.state = Failed
+		default:
+			j.state = Done
+		}
+		logrus.WithFields(logrus.Fields{
+			"job_id":   j.id,
+			"state":    j.state,
+			"duration": j.ended.Sub(j.started),
+			"error":    err,
+		}).Info("job ended")
+	}()
+	return j.id, nil
+}
+
+func (m *Manager) get(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("no job with ID %q", id)
+	}
+	return j, nil
+}
+
+func (m *Manager) status(j *job) JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := JobStatus{
+		ID:       j.id,
+		Spec:     j.spec,
+		State:    j.state,
+		Progress: j.task.Progress(),
+		Started:  j.started,
+		Ended:    j.ended,
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	return st
+}
+
+// Status of a job.
+func (m *Manager) Status(id string) (JobStatus, error) {
+	j, err := m.get(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	return m.status(j), nil
+}
+
+// List the status of all the jobs, in the order they were submitted.
+func (m *Manager) List() []JobStatus {
+	m.mu.Lock()
+	jobs := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, j)
+	}
+	m.mu.Unlock()
+
+	sort.Sort(byStart(jobs))
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		statuses = append(statuses, m.status(j))
+	}
+	return statuses
+}
+
+// Pause a running job.
+func (m *Manager) Pause(id string) (JobStatus, error) {
+	return m.control(id, (*cmdsync.SyncTask).Pause)
+}
+
+// Resume a paused job.
+func (m *Manager) Resume(id string) (JobStatus, error) {
+	return m.control(id, (*cmdsync.SyncTask).Resume)
+}
+
+// Cancel a job.
+func (m *Manager) Cancel(id string) (JobStatus, error) {
+	return m.control(id, (*cmdsync.SyncTask).Cancel)
+}
+
+func (m *Manager) control(id string, f func(*cmdsync.SyncTask)) (JobStatus, error) {
+	j, err := m.get(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	f(j.task)
+	return m.status(j), nil
+}
+
+// Wait until a job ends, or until timeout elapses.
+func (m *Manager) Wait(id string, timeout time.Duration) (JobStatus, error) {
+	j, err := m.get(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	select {
+	case <-j.done:
+	case <-time.After(timeout):
+	}
+	return m.status(j), nil
+}
+
+// FailedKeys streams the keys that a job failed to sync to f. The job must
+// have ended, since its failure output is only complete at that point.
+func (m *Manager) FailedKeys(id string, f func(s3.Key) error) error {
+	j, err := m.get(id)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-j.done:
+	default:
+		return fmt.Errorf("job %q is still running", id)
+	}
+	if j.spec.Failure == "" {
+		return fmt.Errorf("job %q has no failure output", id)
+	}
+	file, err := os.Open(j.spec.Failure)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("reading gzip of %q: %v", j.spec.Failure, err)
+	}
+	defer func() { _ = gzr.Close() }()
+
+	// failures are read as the keys that failed, whatever their format
+	rd, err := listing.NewReader(gzr)
+	if err != nil {
+		return fmt.Errorf("reading %q: %v", j.spec.Failure, err)
+	}
+	for {
+		var key s3.Key
+		switch err := rd.Read(&key); err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return fmt.Errorf("decoding key from %q: %v", j.spec.Failure, err)
+		}
+		if err := f(key); err != nil {
+			return err
+		}
+	}
+}
+
+type byStart []*job
+
+func (b byStart) Len() int           { return len(b) }
+func (b byStart) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byStart) Less(i, j int) bool { return b[i].started.Before(b[j].started) }
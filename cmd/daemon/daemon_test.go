@@ -0,0 +1,314 @@
+package daemon_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"github.com/Shopify/brigade/cmd/daemon"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	sync.ACLForKey = sync.MockACLForKey
+}
+
+// preparer prepares jobs that sync keys from the mock bucket, writing their
+// failures to the job's failure file, confined to jobsDir if it's set.
+func preparer(mocks3 *s3mock.MockS3, bktName string, keys []s3.Key, syncer sync.SyncerFunc, jobsDir string) daemon.PrepareFunc {
+	return func(spec daemon.JobSpec) (daemon.JobSpec, *sync.SyncTask, func() error, error) {
+		if jobsDir != "" && spec.Failure != "" {
+			failure, err := daemon.Confine(jobsDir, spec.Failure)
+			if err != nil {
+				return spec, nil, nil, err
+			}
+			spec.Failure = failure
+		}
+
+		dst := mocks3.S3().Bucket("dst-bucket")
+		dst.PutBucket(s3.Private) // create it
+
+		task, err := sync.NewSyncTask(mocks3.S3().Bucket(bktName), dst)
+		if err != nil {
+			return spec, nil, nil, err
+		}
+		task.SyncPara = spec.Concurrency
+		task.RetryBase = time.Millisecond
+		task.MaxRetry = 1
+		if syncer != nil {
+			task.Sync = syncer
+		}
+
+		var input bytes.Buffer
+		enc := json.NewEncoder(&input)
+		for _, key := range keys {
+			if err := enc.Encode(key); err != nil {
+				return spec, nil, nil, err
+			}
+		}
+		run := func() error {
+			if spec.Failure == "" {
+				return task.Start(&input, ioutil.Discard, ioutil.Discard)
+			}
+			file, err := os.Create(spec.Failure)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			gzw := gzip.NewWriter(file)
+			defer gzw.Close()
+			return task.Start(&input, ioutil.Discard, gzw)
+		}
+		return spec, task, run, nil
+	}
+}
+
+func TestSubmitAndFollowJob(t *testing.T) {
+	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	prepare := preparer(mocks3, mockbkt.Name(), mockbkt.Keys(), nil, "")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	defer l.Close()
+	go daemon.Serve(l, daemon.NewManager(prepare), "secret")
+
+	if _, err := daemon.Dial(l.Addr().String(), "guess"); err == nil {
+		t.Fatal("want a client with the wrong token to be refused")
+	}
+
+	client, err := daemon.Dial(l.Addr().String(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	id, err := client.Submit(daemon.JobSpec{Concurrency: 3})
+	if err != nil {
+		t.Fatalf("can't submit job: %v", err)
+	}
+
+	status, err := client.Follow(id, 10*time.Millisecond, func(daemon.JobStatus) {})
+	if err != nil {
+		t.Fatalf("can't follow job: %v", err)
+	}
+	if status.State != daemon.Done {
+		t.Fatalf("want job %q, got %q: %s", daemon.Done, status.State, status.Error)
+	}
+	if want, got := int64(len(mockbkt.Keys())), status.Progress.Synced; want != got {
+		t.Errorf("want %d keys synced, got %d", want, got)
+	}
+
+	jobs, err := client.List()
+	if err != nil {
+		t.Fatalf("can't list jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != id {
+		t.Errorf("want only job %q, got %#v", id, jobs)
+	}
+
+	if _, err := client.Status("no-such-job"); err == nil {
+		t.Errorf("want an error for an unknown job")
+	}
+}
+
+func TestRESTJobs(t *testing.T) {
+	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	dir, err := ioutil.TempDir("", "brigade-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := mockbkt.Keys()[:5]
+	failAll := func(src, dst *s3.Bucket, key s3.Key) error { return errors.New("nope") }
+	srv := httptest.NewServer(daemon.NewHandler(daemon.NewManager(preparer(mocks3, mockbkt.Name(), keys, failAll, "")), "secret"))
+	defer srv.Close()
+
+	do := func(method, path, token string, body io.Reader) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+path, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	spec := `{"concurrency": 2, "failure": "` + filepath.Join(dir, "failed.json.gz") + `"}`
+	for _, token := range []string{"", "guess"} {
+		resp := do("POST", "/jobs", token, strings.NewReader(spec))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("want status %d with token %q, got %d", http.StatusUnauthorized, token, resp.StatusCode)
+		}
+	}
+	var statuses []daemon.JobStatus
+	decodeResponse(t, do("GET", "/jobs", "secret", nil), http.StatusOK, &statuses)
+	if len(statuses) != 0 {
+		t.Fatalf("want no job submitted without the token, got %#v", statuses)
+	}
+
+	resp := do("POST", "/jobs", "secret", strings.NewReader(spec))
+	var status daemon.JobStatus
+	decodeResponse(t, resp, http.StatusCreated, &status)
+
+	for status.State == daemon.Running {
+		time.Sleep(5 * time.Millisecond)
+		resp := do("GET", "/jobs/"+status.ID, "secret", nil)
+		decodeResponse(t, resp, http.StatusOK, &status)
+	}
+	if status.Progress.Failed != int64(len(keys)) {
+		t.Errorf("want %d keys failed, got %d", len(keys), status.Progress.Failed)
+	}
+
+	resp = do("GET", "/jobs/"+status.ID+"/failed", "secret", nil)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	dec := json.NewDecoder(resp.Body)
+	got := 0
+	for dec.More() {
+		var key s3.Key
+		if err := dec.Decode(&key); err != nil {
+			t.Fatal(err)
+		}
+		got++
+	}
+	if got != len(keys) {
+		t.Errorf("want %d failed keys, got %d", len(keys), got)
+	}
+
+	resp = do("GET", "/jobs/no-such-job", "secret", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("want status %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestFailedKeysOfConfinedJob(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	dir, err := ioutil.TempDir("", "brigade-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := mockbkt.Keys()[:5]
+	failAll := func(src, dst *s3.Bucket, key s3.Key) error { return errors.New("nope") }
+	m := daemon.NewManager(preparer(mocks3, mockbkt.Name(), keys, failAll, dir))
+
+	// relative to the jobs directory, not to the working directory
+	id, err := m.Submit(daemon.JobSpec{Concurrency: 2, Failure: "failed.json.gz"})
+	if err != nil {
+		t.Fatalf("can't submit job: %v", err)
+	}
+	status, err := m.Wait(id, 5*time.Second)
+	if err != nil {
+		t.Fatalf("can't wait for job: %v", err)
+	}
+	if status.State == daemon.Running {
+		t.Fatalf("want job to have ended")
+	}
+
+	got := 0
+	if err := m.FailedKeys(id, func(s3.Key) error { got++; return nil }); err != nil {
+		t.Fatalf("can't read failed keys: %v", err)
+	}
+	if got != len(keys) {
+		t.Errorf("want %d failed keys, got %d", len(keys), got)
+	}
+}
+
+func TestConfine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "brigade-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jobs := filepath.Join(dir, "jobs")
+	if err := os.MkdirAll(filepath.Join(jobs, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(jobs, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(jobs, "sub"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	root, err := filepath.EvalSymlinks(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"list.json.gz":                         filepath.Join(root, "list.json.gz"),
+		"sub/../out.json.gz":                   filepath.Join(root, "out.json.gz"),
+		filepath.Join(jobs, "sub/out.json.gz"): filepath.Join(root, "sub/out.json.gz"),
+		filepath.Join(dir, "link/out.json.gz"): filepath.Join(root, "sub/out.json.gz"),
+	} {
+		got, err := daemon.Confine(jobs, name)
+		if err != nil {
+			t.Errorf("%q: %v", name, err)
+		} else if got != want {
+			t.Errorf("%q: want %q, got %q", name, want, got)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"..",
+		"../list.json.gz",
+		"/etc/passwd",
+		filepath.Join(dir, "list.json.gz"),
+		"escape/list.json.gz",
+	} {
+		if got, err := daemon.Confine(jobs, name); err == nil {
+			t.Errorf("%q: want an error, got %q", name, got)
+		}
+	}
+}
+
+func decodeResponse(t *testing.T, resp *http.Response, code int, v interface{}) {
+	defer resp.Body.Close()
+	if resp.StatusCode != code {
+		t.Fatalf("want status %d, got %d", code, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("can't decode response: %v", err)
+	}
+}
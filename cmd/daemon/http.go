@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strings"
+)
+
+// NewHandler exposes a manager over a REST API:
+//
+//	POST /jobs                submit a JobSpec, replies with the job's status
+//	GET  /jobs                list the status of all jobs
+//	GET  /jobs/{id}           status of a job
+//	POST /jobs/{id}/pause     pause a job
+//	POST /jobs/{id}/resume    resume a job
+//	POST /jobs/{id}/cancel    cancel a job
+//	GET  /jobs/{id}/failed    keys that an ended job failed to sync, as a
+//	                          listing of JSON keys, one per line
+//
+// Requests must carry the token in an "Authorization: Bearer <token>"
+// header. An empty token authorizes no request.
+func NewHandler(m *Manager, token string) http.Handler {
+	h := &handler{m: m, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", h.authorize(h.jobs))
+	mux.HandleFunc("/jobs/", h.authorize(h.job))
+	return mux
+}
+
+type handler struct {
+	m     *Manager
+	token string
+}
+
+func (h *handler) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get("Authorization")
+		if !strings.HasPrefix(got, "Bearer ") || !authorized(h.token, strings.TrimPrefix(got, "Bearer ")) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errUnauthorized.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *handler) jobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, h.m.List())
+	case "POST":
+		var spec JobSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid job spec: "+err.Error())
+			return
+		}
+		id, err := h.m.Submit(spec)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		status, err := h.m.Status(id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, status)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *handler) job(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	id := parts[0]
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	if id == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var control func(string) (JobStatus, error)
+	switch action {
+	case "":
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		status, err := h.m.Status(id)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+		return
+	case "failed":
+		h.failed(w, r, id)
+		return
+	case "pause":
+		control = h.m.Pause
+	case "resume":
+		control = h.m.Resume
+	case "cancel":
+		control = h.m.Cancel
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	status, err := control(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (h *handler) failed(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	status, err := h.m.Status(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if status.State == Running {
+		writeError(w, http.StatusConflict, "job is still running")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	wroteHeader := false
+	err = h.m.FailedKeys(id, func(key s3.Key) error {
+		wroteHeader = true
+		return enc.Encode(key)
+	})
+	if err != nil {
+		if !wroteHeader {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		logrus.WithFields(logrus.Fields{
+			"job_id": id,
+			"error":  err,
+		}).Error("failed to stream failed keys")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithField("error", err).Error("failed to write response")
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{msg})
+}
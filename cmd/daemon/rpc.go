@@ -0,0 +1,181 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"net"
+	"net/rpc"
+	"time"
+)
+
+// serviceName under which the manager is registered.
+const serviceName = "Brigade"
+
+// Service exposes a manager over net/rpc.
+type Service struct {
+	m *Manager
+}
+
+// WaitArgs are the arguments of a Wait call.
+type WaitArgs struct {
+	ID      string
+	Timeout time.Duration
+}
+
+// Submit a job, replying with its ID.
+func (s *Service) Submit(spec JobSpec, id *string) (err error) {
+	*id, err = s.m.Submit(spec)
+	return err
+}
+
+// Status of a job.
+func (s *Service) Status(id string, status *JobStatus) (err error) {
+	*status, err = s.m.Status(id)
+	return err
+}
+
+// List all the jobs.
+func (s *Service) List(_ struct{}, statuses *[]JobStatus) error {
+	*statuses = s.m.List()
+	return nil
+}
+
+// Pause a job.
+func (s *Service) Pause(id string, status *JobStatus) (err error) {
+	*status, err = s.m.Pause(id)
+	return err
+}
+
+// Resume a job.
+func (s *Service) Resume(id string, status *JobStatus) (err error) {
+	*status, err = s.m.Resume(id)
+	return err
+}
+
+// Cancel a job.
+func (s *Service) Cancel(id string, status *JobStatus) (err error) {
+	*status, err = s.m.Cancel(id)
+	return err
+}
+
+// Wait for a job to end, or for the timeout to elapse.
+func (s *Service) Wait(args WaitArgs, status *JobStatus) (err error) {
+	*status, err = s.m.Wait(args.ID, args.Timeout)
+	return err
+}
+
+// FailedKeys of a job that has ended.
+func (s *Service) FailedKeys(id string, keys *[]s3.Key) error {
+	return s.m.FailedKeys(id, func(key s3.Key) error {
+		*keys = append(*keys, key)
+		return nil
+	})
+}
+
+// Serve the manager on l until l is closed, to the clients that present the
+// token when they connect.
+func Serve(l net.Listener, m *Manager, token string) error {
+	if token == "" {
+		return errors.New("serving the control api requires a token")
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, &Service{m: m}); err != nil {
+		return fmt.Errorf("registering rpc service: %v", err)
+	}
+	logrus.WithField("addr", l.Addr().String()).Info("serving control api")
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := authenticate(conn, token); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"remote": conn.RemoteAddr().String(),
+					"error":  err,
+				}).Warn("rejected control api connection")
+				conn.Close()
+				return
+			}
+			srv.ServeConn(conn)
+		}()
+	}
+}
+
+// Client controls a remote daemon.
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial the daemon listening at addr, presenting it the token.
+func Dial(addr, token string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing daemon at %q: %v", addr, err)
+	}
+	if err := present(conn, token); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("authenticating to daemon at %q: %v", addr, err)
+	}
+	return &Client{rpc: rpc.NewClient(conn)}, nil
+}
+
+// Close the connection to the daemon.
+func (c *Client) Close() error { return c.rpc.Close() }
+
+// Submit a job, returning its ID.
+func (c *Client) Submit(spec JobSpec) (string, error) {
+	var id string
+	err := c.rpc.Call(serviceName+".Submit", spec, &id)
+	return id, err
+}
+
+// Status of a job.
+func (c *Client) Status(id string) (JobStatus, error) { return c.call("Status", id) }
+
+// Pause a job.
+func (c *Client) Pause(id string) (JobStatus, error) { return c.call("Pause", id) }
+
+// Resume a job.
+func (c *Client) Resume(id string) (JobStatus, error) { return c.call("Resume", id) }
+
+// Cancel a job.
+func (c *Client) Cancel(id string) (JobStatus, error) { return c.call("Cancel", id) }
+
+// List all the jobs of the daemon.
+func (c *Client) List() ([]JobStatus, error) {
+	var statuses []JobStatus
+	err := c.rpc.Call(serviceName+".List", struct{}{}, &statuses)
+	return statuses, err
+}
+
+// Follow the progress of a job until it ends, calling f with a new status
+// every interval.
+func (c *Client) Follow(id string, interval time.Duration, f func(JobStatus)) (JobStatus, error) {
+	for {
+		var status JobStatus
+		err := c.rpc.Call(serviceName+".Wait", WaitArgs{ID: id, Timeout: interval}, &status)
+		if err != nil {
+			return status, err
+		}
+		f(status)
+		if status.State != Running {
+			return status, nil
+		}
+	}
+}
+
+// FailedKeys of a job that has ended.
+func (c *Client) FailedKeys(id string) ([]s3.Key, error) {
+	var keys []s3.Key
+	err := c.rpc.Call(serviceName+".FailedKeys", id, &keys)
+	return keys, err
+}
+
+func (c *Client) call(method, id string) (JobStatus, error) {
+	var status JobStatus
+	err := c.rpc.Call(serviceName+"."+method, id, &status)
+	return status, err
+}
@@ -1,23 +1,25 @@
 package diff
 
 import (
-	"bufio"
 	"encoding/json"
 	"expvar"
 	"fmt"
+	"github.com/Shopify/brigade/cmd/compare"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
 	"github.com/Sirupsen/logrus"
 	"github.com/pushrax/goamz/s3"
 	"io"
 	"runtime"
-	"sync"
 	"time"
 )
 
-var (
-	bufferFactor = 10
-)
-
-type diffTask struct{}
+type diffTask struct {
+	tolerance compare.Tolerance
+	// byName are the keys of the old list that a new key of the same name
+	// can match despite another ETag, when the tolerance allows it
+	byName map[string]s3.Key
+}
 
 var metrics = struct {
 	oldKeys     *expvar.Int
@@ -35,8 +37,20 @@ var metrics = struct {
 // keys have changed from the old to the new one, writing those keys
 // to the output writer (in JSON as well).
 func Diff(oldList, newList io.Reader, output io.Writer) error {
+	return DiffWith(oldList, newList, output, compare.Tolerance{})
+}
 
-	var differ diffTask
+// DiffWith is like Diff, tolerating some differences between the keys of
+// the old and new list. Keys are told apart by their ETag, so only the
+// tolerance of multipart ETags matters: a new key whose ETag isn't known is
+// still unchanged when the old list has a key of the same name and size,
+// either of their ETags being of a multipart upload.
+func DiffWith(oldList, newList io.Reader, output io.Writer, tolerance compare.Tolerance) error {
+
+	differ := diffTask{tolerance: tolerance}
+	if tolerance.Multipart {
+		differ.byName = make(map[string]s3.Key)
+	}
 
 	start := time.Now()
 
@@ -113,78 +127,60 @@ func (dt *diffTask) computeDifference(oldList, newList io.Reader) ([]s3.Key, err
 
 func (dt *diffTask) readOldList(src io.Reader) (keySet, error) {
 	keyset := newKeyMap()
-	decoders := make(chan []byte, runtime.NumCPU()*bufferFactor)
-	keys := make(chan s3.Key, runtime.NumCPU()*bufferFactor)
-
-	doneSrcA := make(chan struct{})
-	go func() {
-		defer close(doneSrcA)
-		for key := range keys {
-			metrics.oldKeys.Add(1)
-			// stores the etag, which will differ if files have changed
-			keyset.Add(key.ETag)
+	err := dt.readKeys(src, func(key s3.Key) {
+		metrics.oldKeys.Add(1)
+		// stores the etag, which will differ if files have changed
+		keyset.Add(key.ETag)
+		if dt.byName != nil {
+			dt.byName[key.Key] = s3.Key{Size: key.Size, ETag: key.ETag}
 		}
-	}()
-
-	wg := sync.WaitGroup{}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		wg.Add(1)
-		go dt.decode(&wg, decoders, keys)
-	}
-
-	if err := dt.readLines(src, decoders); err != nil {
-		wg.Wait()
-		close(keys)
-		<-doneSrcA
-		return keyset, err
-	}
-	wg.Wait()
-	close(keys)
-	<-doneSrcA
-
-	return keyset, nil
+	})
+	return keyset, err
 }
 
 func (dt *diffTask) filterNewKeys(src io.Reader, keyset keySet) ([]s3.Key, int, error) {
-
-	decoders := make(chan []byte, runtime.NumCPU()*bufferFactor)
-	keys := make(chan s3.Key, runtime.NumCPU()*bufferFactor)
-
 	var diffKeys []s3.Key
 	var newKeys int
-
-	doneDiffing := make(chan struct{})
-	go func() {
-		defer close(doneDiffing)
-		for key := range keys {
-			metrics.newKeys.Add(1)
-			newKeys++
-			// only add ETags that aren't known from the old list
-			if !keyset.Contains(key.ETag) {
-				metrics.diffKeys.Add(1)
-				diffKeys = append(diffKeys, key)
-			}
+	err := dt.readKeys(src, func(key s3.Key) {
+		metrics.newKeys.Add(1)
+		newKeys++
+		// only add ETags that aren't known from the old list
+		if !keyset.Contains(key.ETag) && !dt.tolerated(key) {
+			metrics.diffKeys.Add(1)
+			diffKeys = append(diffKeys, key)
 		}
-	}()
+	})
+	return diffKeys, newKeys, err
+}
 
-	wg := sync.WaitGroup{}
-	for i := 0; i < runtime.NumCPU(); i++ {
-		wg.Add(1)
-		go dt.decode(&wg, decoders, keys)
-	}
+// readKeys decodes the keys of a listing with a decoder per CPU, calling
+// each on every key, from a single goroutine.
+// tolerated tells whether a new key whose ETag isn't known is the key of
+// the same name in the old list, by the tolerance of the diff.
+func (dt *diffTask) tolerated(key s3.Key) bool {
+	old, ok := dt.byName[key.Key]
+	return ok && old.Size == key.Size && dt.tolerance.SameETag(old.ETag, key.ETag)
+}
 
-	if err := dt.readLines(src, decoders); err != nil {
-		wg.Wait()
-		close(keys)
-		<-doneDiffing
-		return diffKeys, newKeys, err
-	}
+func (dt *diffTask) readKeys(src io.Reader, each func(s3.Key)) error {
+	lines := make(chan *[]byte, runtime.NumCPU()*pipeline.BufferFactor)
+	keys := make(chan s3.Key, runtime.NumCPU()*pipeline.BufferFactor)
 
-	wg.Wait()
-	close(keys)
-	<-doneDiffing
+	var consumer pipeline.Workers
+	consumer.Start(1, func(int) {
+		for key := range keys {
+			each(key)
+		}
+	})
+	var decoders pipeline.Workers
+	decoders.Start(runtime.NumCPU(), func(int) { dt.decode(lines, keys) })
 
-	return diffKeys, newKeys, nil
+	err := pipeline.ReadLines(src, lines, nil, nil)
+	close(lines)
+	decoders.Wait()
+	close(keys)
+	consumer.Wait()
+	return err
 }
 
 func (dt *diffTask) writeDiff(w io.Writer, keys []s3.Key) error {
@@ -199,29 +195,12 @@ func (dt *diffTask) writeDiff(w io.Writer, keys []s3.Key) error {
 	return nil
 }
 
-func (dt *diffTask) readLines(r io.Reader, decoders chan<- []byte) error {
-	defer close(decoders)
-	rd := bufio.NewReader(r)
-	for {
-		line, err := rd.ReadBytes('\n')
-		switch err {
-		case io.EOF:
-			return nil
-		case nil:
-		default:
-			return err
-		}
-
-		decoders <- line
-	}
-}
-
 // decodes s3.Keys from a channel of bytes, each byte containing a full key
-func (dt *diffTask) decode(wg *sync.WaitGroup, lines <-chan []byte, keys chan<- s3.Key) {
-	defer wg.Done()
+func (dt *diffTask) decode(lines <-chan *[]byte, keys chan<- s3.Key) {
 	var key s3.Key
 	for line := range lines {
-		err := json.Unmarshal(line, &key)
+		err := listing.UnmarshalKey(*line, &key)
+		pipeline.Release(line)
 		if err != nil {
 			logrus.WithField("error", err).Error("failed to unmarshal s3.Key from line")
 		} else {
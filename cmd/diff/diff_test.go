@@ -3,6 +3,7 @@ package diff_test
 import (
 	"bytes"
 	"encoding/json"
+	"github.com/Shopify/brigade/cmd/compare"
 	"github.com/Shopify/brigade/cmd/diff"
 	"github.com/Sirupsen/logrus"
 	"github.com/kr/pretty"
@@ -114,6 +115,30 @@ func TestCanDiffWhenNewlistIsCorrupted(t *testing.T) {
 	checkKeys(t, want, got)
 }
 
+func TestCanDiffToleratingMultipartETags(t *testing.T) {
+	oldKeys := []s3.Key{
+		{Key: "copied", Size: 3, ETag: `"aaa-2"`},
+		{Key: "resized", Size: 3, ETag: `"bbb-2"`},
+		{Key: "changed", Size: 3, ETag: `"ccc"`},
+	}
+	newKeys := []s3.Key{
+		{Key: "copied", Size: 3, ETag: `"ddd"`},
+		{Key: "resized", Size: 4, ETag: `"eee"`},
+		{Key: "changed", Size: 3, ETag: `"fff"`},
+		{Key: "added", Size: 3, ETag: `"ggg-2"`},
+	}
+	output := bytes.NewBuffer(nil)
+	err := diff.DiffWith(encodeKeys(oldKeys), encodeKeys(newKeys), output, compare.Tolerance{Multipart: true})
+	if err != nil {
+		t.Fatalf("failed to diff: %v", err)
+	}
+	want := []s3.Key{{ETag: `"eee"`}, {ETag: `"fff"`}, {ETag: `"ggg-2"`}}
+	checkKeys(t, want, sortKeys(decodeKeys(output)))
+
+	// without the tolerance, the copy of a multipart upload changed
+	testDiff(t, oldKeys, newKeys, []s3.Key{{ETag: `"ddd"`}, {ETag: `"eee"`}, {ETag: `"fff"`}, {ETag: `"ggg-2"`}})
+}
+
 // context builders
 
 func testDiff(t *testing.T, oldKeys, newKeys, want []s3.Key) {
@@ -0,0 +1,164 @@
+// Package drift compares the keys a sync copied to the latest S3 Inventory
+// report of the destination, to find the keys that drifted since: deleted,
+// or overwritten with other content. It checks that a migration still holds
+// long after it's done, without listing the destination again.
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"sort"
+	"strings"
+)
+
+// The drifts of keys.
+const (
+	// Missing keys were synced but aren't in the inventory.
+	Missing = "missing"
+	// Changed keys are in the inventory with another size or ETag than the
+	// ones they were synced with.
+	Changed = "changed"
+	// Extra keys are in the inventory but weren't synced, which is only
+	// reported when asked to, as destinations often hold other keys.
+	Extra = "extra"
+)
+
+// Object is a version of a key, as listed or inventoried.
+type Object struct {
+	Size         int64  `json:"size"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func objectOf(key s3.Key) Object {
+	return Object{Size: key.Size, ETag: key.ETag, LastModified: key.LastModified}
+}
+
+// Finding is a key that drifted, written as a line of JSON.
+type Finding struct {
+	Key       string  `json:"key"`
+	Drift     string  `json:"drift"`
+	Listed    *Object `json:"listed,omitempty"`
+	Inventory *Object `json:"inventory,omitempty"`
+}
+
+// Report counts the keys compared, and those that drifted.
+type Report struct {
+	Listed      int64 `json:"listed"`
+	Inventoried int64 `json:"inventoried"`
+	Missing     int64 `json:"missing"`
+	Changed     int64 `json:"changed"`
+	Extra       int64 `json:"extra"`
+}
+
+// Drifted is the count of keys that drifted.
+func (r Report) Drifted() int64 { return r.Missing + r.Changed + r.Extra }
+
+// Detector finds the drift between the listings of the synced keys and the
+// files of an inventory. The listed keys are held in memory, by the name
+// they were synced under, while the inventory is streamed.
+type Detector struct {
+	enc    *json.Encoder
+	extra  bool
+	listed map[string]Object
+	report Report
+}
+
+// NewDetector creates a detector writing its findings to w, including the
+// Extra keys if extra is set.
+func NewDetector(w io.Writer, extra bool) *Detector {
+	return &Detector{
+		enc:    json.NewEncoder(w),
+		extra:  extra,
+		listed: make(map[string]Object),
+	}
+}
+
+// Listed reads the keys of a listing that were synced, mapped to their name
+// at the destination by mapKey if it's set.
+func (d *Detector) Listed(r listing.Reader, mapKey func(string) string) error {
+	for {
+		var key s3.Key
+		if err := r.Read(&key); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading listing: %v", err)
+		}
+		name := key.Key
+		if mapKey != nil {
+			name = mapKey(name)
+		}
+		if _, ok := d.listed[name]; !ok {
+			d.report.Listed++
+		}
+		d.listed[name] = objectOf(key)
+	}
+}
+
+// Inventory reads the keys of a file of the inventory, comparing them to
+// the listed keys. Each listed key found in the inventory is done with.
+func (d *Detector) Inventory(r listing.Reader) error {
+	for {
+		var key s3.Key
+		if err := r.Read(&key); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading inventory: %v", err)
+		}
+		d.report.Inventoried++
+		inventory := objectOf(key)
+		listed, ok := d.listed[key.Key]
+		switch {
+		case !ok && d.extra:
+			d.report.Extra++
+			if err := d.enc.Encode(Finding{Key: key.Key, Drift: Extra, Inventory: &inventory}); err != nil {
+				return err
+			}
+		case ok:
+			delete(d.listed, key.Key)
+			if same(listed, inventory) {
+				continue
+			}
+			d.report.Changed++
+			if err := d.enc.Encode(Finding{Key: key.Key, Drift: Changed, Listed: &listed, Inventory: &inventory}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Finish writes the listed keys that weren't in the inventory, in order, and
+// reports on the drift.
+func (d *Detector) Finish() (Report, error) {
+	names := make([]string, 0, len(d.listed))
+	for name := range d.listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		listed := d.listed[name]
+		d.report.Missing++
+		if err := d.enc.Encode(Finding{Key: name, Drift: Missing, Listed: &listed}); err != nil {
+			return d.report, err
+		}
+	}
+	d.listed = make(map[string]Object)
+	return d.report, nil
+}
+
+// same tells whether a key has the content it was synced with. The ETag of
+// a multipart upload isn't the MD5 of the content, and a copy of it doesn't
+// keep it, so those keys are only compared by size.
+func same(listed, inventory Object) bool {
+	if listed.Size != inventory.Size {
+		return false
+	}
+	a, b := strings.Trim(listed.ETag, `"`), strings.Trim(inventory.ETag, `"`)
+	if strings.Contains(a, "-") || strings.Contains(b, "-") {
+		return true
+	}
+	return a == b
+}
@@ -0,0 +1,144 @@
+package drift_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/drift"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const inventoryManifest = `{
+  "sourceBucket": "dst-bucket",
+  "destinationBucket": "arn:aws:s3:::inventory-bucket",
+  "version": "2016-11-30",
+  "fileFormat": "CSV",
+  "fileSchema": "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, ETag",
+  "files": [{"key": "inventory/dst-bucket/daily/data/1.csv.gz", "size": 100, "MD5checksum": "abc"}]
+}`
+
+const inventoryCSV = `"dst-bucket","shard-1/same","","true","false","3","2016-12-01T00:00:00.000Z","aaa"
+"dst-bucket","shard-1/changed","","true","false","3","2016-12-01T00:00:00.000Z","bbb"
+"dst-bucket","shard-1/changed","v1","false","false","3","2016-11-01T00:00:00.000Z","ccc"
+"dst-bucket","shard-1/deleted","","true","true","","2016-12-01T00:00:00.000Z",""
+"dst-bucket","shard-1/multi+part%2Fkey","","true","false","10","2016-12-01T00:00:00.000Z","ddd-2"
+"dst-bucket","other","","true","false","1","2016-12-01T00:00:00.000Z","eee"
+`
+
+func listingOf(t *testing.T, keys []s3.Key) listing.Reader {
+	var buf bytes.Buffer
+	w, err := listing.NewWriter(&buf, "json")
+	if err != nil {
+		t.Fatalf("can't create listing: %v", err)
+	}
+	for _, k := range keys {
+		if err := w.Write(k); err != nil {
+			t.Fatalf("can't write listing: %v", err)
+		}
+	}
+	r, err := listing.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+	return r
+}
+
+func TestDrift(t *testing.T) {
+	m, err := drift.ParseManifest(strings.NewReader(inventoryManifest))
+	if err != nil {
+		t.Fatalf("can't parse manifest: %v", err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Key != "inventory/dst-bucket/daily/data/1.csv.gz" {
+		t.Errorf("want the file of the inventory, got %+v", m.Files)
+	}
+	synced := []s3.Key{
+		{Key: "legacy/same", Size: 3, ETag: `"aaa"`},
+		{Key: "legacy/changed", Size: 3, ETag: `"ccc"`},
+		{Key: "legacy/deleted", Size: 4, ETag: `"fff"`},
+		// only compared by size, as its ETag is of a multipart upload
+		{Key: "legacy/multi part/key", Size: 10, ETag: `"ggg"`},
+	}
+	mapKey := func(key string) string { return "shard-1/" + strings.TrimPrefix(key, "legacy/") }
+
+	for _, extra := range []bool{false, true} {
+		inventory, err := drift.NewInventoryReader(strings.NewReader(inventoryCSV), m.Schema())
+		if err != nil {
+			t.Fatalf("can't read inventory: %v", err)
+		}
+		var buf bytes.Buffer
+		d := drift.NewDetector(&buf, extra)
+		if err := d.Listed(listingOf(t, synced), mapKey); err != nil {
+			t.Fatalf("can't read listed keys: %v", err)
+		}
+		if err := d.Inventory(inventory); err != nil {
+			t.Fatalf("can't read inventory: %v", err)
+		}
+		report, err := d.Finish()
+		if err != nil {
+			t.Fatalf("can't finish: %v", err)
+		}
+
+		want := drift.Report{Listed: 4, Inventoried: 4, Missing: 1, Changed: 1}
+		wantDrifts := map[string]string{"shard-1/changed": drift.Changed, "shard-1/deleted": drift.Missing}
+		if extra {
+			want.Extra = 1
+			wantDrifts["other"] = drift.Extra
+		}
+		if report != want {
+			t.Errorf("want report %+v, got %+v", want, report)
+		}
+		gotDrifts := make(map[string]string)
+		dec := json.NewDecoder(&buf)
+		for {
+			var f drift.Finding
+			if err := dec.Decode(&f); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("can't decode finding: %v", err)
+			}
+			gotDrifts[f.Key] = f.Drift
+		}
+		if !reflect.DeepEqual(wantDrifts, gotDrifts) {
+			t.Errorf("want drifts %v, got %v", wantDrifts, gotDrifts)
+		}
+	}
+
+	if _, err := drift.ParseManifest(strings.NewReader(`{"fileFormat": "ORC"}`)); err == nil {
+		t.Errorf("want only CSV inventories")
+	}
+}
+
+func TestLatestManifest(t *testing.T) {
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+	bkt := mocks3.S3().Bucket("inventory-bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	for _, key := range []string{
+		"inventory/dst-bucket/daily/2016-11-30T00-00Z/manifest.json",
+		"inventory/dst-bucket/daily/2016-12-01T00-00Z/manifest.json",
+		"inventory/dst-bucket/daily/data/1.csv.gz",
+		"inventory/dst-bucket/daily/hive/dt=2016-12-01-00-00/symlink.txt",
+	} {
+		if err := bkt.Put(key, []byte("{}"), "application/json", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+	}
+
+	got, err := drift.LatestManifest(bkt, "inventory/dst-bucket/daily")
+	if err != nil {
+		t.Fatalf("can't find latest manifest: %v", err)
+	}
+	if want := "inventory/dst-bucket/daily/2016-12-01T00-00Z/manifest.json"; got != want {
+		t.Errorf("want manifest %q, got %q", want, got)
+	}
+	if _, err := drift.LatestManifest(bkt, "inventory/other-bucket/daily/"); err == nil {
+		t.Errorf("want no report found")
+	}
+}
@@ -0,0 +1,149 @@
+package drift
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManifestName is the name of the manifest of each inventory report.
+const ManifestName = "manifest.json"
+
+// Manifest of an S3 Inventory report, listing the files of the report.
+type Manifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []File `json:"files"`
+}
+
+// File of an inventory report, a gzip'd CSV file in the bucket of the
+// inventory.
+type File struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	MD5  string `json:"MD5checksum"`
+}
+
+// ParseManifest parses the manifest of an inventory report. Only the
+// reports in CSV can be read.
+func ParseManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("decoding inventory manifest: %v", err)
+	}
+	if m.FileFormat != "CSV" {
+		return nil, fmt.Errorf("inventory is in %q, only CSV can be read", m.FileFormat)
+	}
+	return m, nil
+}
+
+// Schema is the name of the columns of the files of the report.
+func (m *Manifest) Schema() []string {
+	columns := strings.Split(m.FileSchema, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
+
+// InventoryReader reads the keys of a file of an inventory report. It's a
+// listing.Reader, the keys having their size and ETag if the report has
+// them. Only the current version of the keys is read.
+type InventoryReader struct {
+	rd      *csv.Reader
+	columns map[string]int
+}
+
+// NewInventoryReader reads a decompressed inventory file with the schema of
+// its manifest.
+func NewInventoryReader(r io.Reader, schema []string) (*InventoryReader, error) {
+	columns := make(map[string]int, len(schema))
+	for i, name := range schema {
+		columns[name] = i
+	}
+	if _, ok := columns["Key"]; !ok {
+		return nil, fmt.Errorf("inventory has no Key column, got %q", schema)
+	}
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = len(schema)
+	return &InventoryReader{rd: rd, columns: columns}, nil
+}
+
+// Read the next key, io.EOF once done.
+func (r *InventoryReader) Read(key *s3.Key) error {
+	for {
+		record, err := r.rd.Read()
+		if err != nil {
+			return err
+		}
+		// noncurrent versions and delete markers of versioned buckets
+		if r.field(record, "IsLatest") == "false" || r.field(record, "IsDeleteMarker") == "true" {
+			continue
+		}
+		// keys are URL encoded, since they can hold anything
+		name, err := url.QueryUnescape(r.field(record, "Key"))
+		if err != nil {
+			return fmt.Errorf("decoding key %q: %v", r.field(record, "Key"), err)
+		}
+		*key = s3.Key{Key: name, ETag: r.field(record, "ETag"), LastModified: r.field(record, "LastModifiedDate")}
+		if size := r.field(record, "Size"); size != "" {
+			if key.Size, err = strconv.ParseInt(size, 10, 64); err != nil {
+				return fmt.Errorf("size of key %q: %v", name, err)
+			}
+		}
+		return nil
+	}
+}
+
+func (r *InventoryReader) field(record []string, name string) string {
+	if i, ok := r.columns[name]; ok {
+		return record[i]
+	}
+	return ""
+}
+
+// LatestManifest finds the key of the manifest of the latest report of an
+// inventory, given its prefix in the bucket of the inventory, which S3
+// names prefix/source-bucket/inventory-id/. The reports are in folders
+// named after the time they were made at.
+func LatestManifest(bkt *s3.Bucket, prefix string) (string, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var reports []string
+	marker := ""
+	for {
+		resp, err := bkt.List(prefix, "/", marker, 1000)
+		if err != nil {
+			return "", fmt.Errorf("listing inventory reports: %v", err)
+		}
+		for _, p := range resp.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(p, prefix), "/")
+			if _, err := time.Parse("2006-01-02T15-04Z", name); err == nil {
+				reports = append(reports, p)
+			}
+		}
+		if !resp.IsTruncated {
+			break
+		}
+		marker = resp.NextMarker
+		if marker == "" && len(resp.CommonPrefixes) > 0 {
+			marker = resp.CommonPrefixes[len(resp.CommonPrefixes)-1]
+		}
+	}
+	if len(reports) == 0 {
+		return "", fmt.Errorf("no inventory report under %q", prefix)
+	}
+	// the names of the reports sort by time
+	sort.Strings(reports)
+	return reports[len(reports)-1] + ManifestName, nil
+}
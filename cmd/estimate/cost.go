@@ -0,0 +1,98 @@
+package estimate
+
+import "sort"
+
+// Storage classes of S3 keys.
+const (
+	Standard          = "STANDARD"
+	ReducedRedundancy = "REDUCED_REDUNDANCY"
+	StandardIA        = "STANDARD_IA"
+	Glacier           = "GLACIER"
+)
+
+// Prices of S3, in USD. Requests are priced per 1000.
+type Prices struct {
+	// Write is the price of PUT, COPY and LIST requests to standard keys,
+	// which is the class of the copies made by sync.
+	Write float64
+	// Read is the price of GET requests by storage class of the source key.
+	Read map[string]float64
+	// TransferPerGB is the price of transferring data between regions.
+	TransferPerGB float64
+}
+
+// DefaultPrices are the prices of the us-east-1 region at the time of
+// writing, check them against the pricing page of S3 before relying on an
+// estimate.
+var DefaultPrices = Prices{
+	Write: 0.005,
+	Read: map[string]float64{
+		Standard:          0.0004,
+		ReducedRedundancy: 0.0004,
+		StandardIA:        0.001,
+	},
+	TransferPerGB: 0.02,
+}
+
+// Operation whose cost is estimated.
+type Operation struct {
+	// List is set when the source bucket is listed for the sync.
+	List bool
+	// GetPut is set when keys are sync'd with a GET and a PUT instead of a
+	// copy.
+	GetPut bool
+	// CrossRegion is set when the buckets are in different regions.
+	CrossRegion bool
+}
+
+// Cost of an operation.
+type Cost struct {
+	Lists  int64 `json:"lists"`
+	Copies int64 `json:"copies"`
+	Gets   int64 `json:"gets"`
+	Puts   int64 `json:"puts"`
+	// TransferBytes is the data that crosses regions.
+	TransferBytes int64 `json:"transfer_bytes"`
+
+	RequestsUSD float64 `json:"requests_usd"`
+	TransferUSD float64 `json:"transfer_usd"`
+	TotalUSD    float64 `json:"total_usd"`
+
+	// Unpriced are the storage classes with no read price, like GLACIER
+	// whose keys must be restored before they can be sync'd. Their keys
+	// aren't in the cost.
+	Unpriced []string `json:"unpriced,omitempty"`
+}
+
+// EstimateCost of an operation on the keys of a report.
+func (r Report) EstimateCost(op Operation, prices Prices) Cost {
+	var c Cost
+	if op.List {
+		// a LIST request returns up to 1000 keys
+		c.Lists = (r.Keys + 999) / 1000
+		c.RequestsUSD += float64(c.Lists) * prices.Write / 1000
+	}
+	for class, count := range r.StorageClasses {
+		read, ok := prices.Read[class]
+		if !ok {
+			c.Unpriced = append(c.Unpriced, class)
+			continue
+		}
+		if op.GetPut {
+			c.Gets += count.Keys
+			c.Puts += count.Keys
+			c.RequestsUSD += float64(count.Keys) * (read + prices.Write) / 1000
+		} else {
+			// a copy is charged like a PUT to the destination
+			c.Copies += count.Keys
+			c.RequestsUSD += float64(count.Keys) * prices.Write / 1000
+		}
+		if op.CrossRegion {
+			c.TransferBytes += count.Bytes
+		}
+	}
+	sort.Strings(c.Unpriced)
+	c.TransferUSD = float64(c.TransferBytes) / (1 << 30) * prices.TransferPerGB
+	c.TotalUSD = c.RequestsUSD + c.TransferUSD
+	return c
+}
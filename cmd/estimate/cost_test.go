@@ -0,0 +1,66 @@
+package estimate_test
+
+import (
+	"github.com/Shopify/brigade/cmd/estimate"
+	"github.com/pushrax/goamz/s3"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEstimateCost(t *testing.T) {
+	e := estimate.NewEstimator()
+	for i := 0; i < 1500; i++ {
+		e.Write(s3.Key{Key: "standard", Size: 1 << 20})
+	}
+	for i := 0; i < 500; i++ {
+		e.Write(s3.Key{Key: "ia", Size: 1 << 20, StorageClass: estimate.StandardIA})
+	}
+	e.Write(s3.Key{Key: "archived", Size: 1 << 30, StorageClass: estimate.Glacier})
+	r := e.Report()
+
+	prices := estimate.Prices{
+		Write:         10,
+		Read:          map[string]float64{estimate.Standard: 1, estimate.StandardIA: 2},
+		TransferPerGB: 0.5,
+	}
+	for _, tt := range []struct {
+		op   estimate.Operation
+		want estimate.Cost
+	}{
+		{
+			op: estimate.Operation{List: true},
+			want: estimate.Cost{
+				Lists: 3, Copies: 2000,
+				RequestsUSD: 3*0.01 + 2000*0.01,
+				Unpriced:    []string{estimate.Glacier},
+			},
+		},
+		{
+			op: estimate.Operation{GetPut: true, CrossRegion: true},
+			want: estimate.Cost{
+				Gets: 2000, Puts: 2000,
+				TransferBytes: 2000 << 20,
+				RequestsUSD:   1500*0.011 + 500*0.012,
+				TransferUSD:   2000.0 / 1024 * 0.5,
+				Unpriced:      []string{estimate.Glacier},
+			},
+		},
+	} {
+		tt.want.TotalUSD = tt.want.RequestsUSD + tt.want.TransferUSD
+		got := r.EstimateCost(tt.op, prices)
+		// compare the prices with some tolerance for rounding
+		for _, usd := range []struct{ want, got *float64 }{
+			{&tt.want.RequestsUSD, &got.RequestsUSD},
+			{&tt.want.TransferUSD, &got.TransferUSD},
+			{&tt.want.TotalUSD, &got.TotalUSD},
+		} {
+			if math.Abs(*usd.want-*usd.got) < 1e-9 {
+				*usd.got = *usd.want
+			}
+		}
+		if !reflect.DeepEqual(tt.want, got) {
+			t.Errorf("%+v: want %+v, got %+v", tt.op, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package estimate sums up the keys of a listing, to size a sync before
+// deciding on its parallelism and timeline.
+package estimate
+
+import (
+	"github.com/Shopify/brigade/cmd/plan"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+)
+
+// MaxPrefixes is the number of top-level prefixes that are counted on their
+// own. The keys of the prefixes seen after that are counted together, under
+// OtherPrefixes.
+const MaxPrefixes = 10000
+
+// OtherPrefixes is the prefix under which the keys of the prefixes past
+// MaxPrefixes are counted.
+const OtherPrefixes = "(other prefixes)"
+
+// sizeLimits are the upper bounds of the size classes of the histogram. 5GB
+// is the largest key that can be copied in a single request.
+var sizeLimits = []struct {
+	limit int64
+	label string
+}{
+	{0, "0B"},
+	{1 << 10, "1KB"},
+	{10 << 10, "10KB"},
+	{100 << 10, "100KB"},
+	{1 << 20, "1MB"},
+	{10 << 20, "10MB"},
+	{100 << 20, "100MB"},
+	{1 << 30, "1GB"},
+	{5 << 30, "5GB"},
+}
+
+// Count of keys and their bytes.
+type Count struct {
+	Keys  int64 `json:"keys"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (c *Count) add(key s3.Key) {
+	c.Keys++
+	c.Bytes += key.Size
+}
+
+// SizeClass of the histogram, counting the keys of at most UpTo bytes that
+// aren't in a smaller class. The last class has no upper bound.
+type SizeClass struct {
+	UpTo string `json:"up_to"`
+	Count
+}
+
+// Prefix counts the keys under a top-level prefix.
+type Prefix struct {
+	Prefix string `json:"prefix"`
+	Count
+}
+
+// Report of an estimate.
+type Report struct {
+	Count
+	Sizes          []SizeClass      `json:"sizes"`
+	StorageClasses map[string]Count `json:"storage_classes"`
+	Prefixes       []Prefix         `json:"prefixes"`
+	Cost           *Cost            `json:"cost,omitempty"`
+}
+
+// Estimator counts the keys written to it. It's a listing.Writer, so that
+// it can be fed by a listing as well as by the listing of a bucket.
+type Estimator struct {
+	total    Count
+	sizes    []Count
+	classes  map[string]*Count
+	prefixes map[string]*Count
+}
+
+// NewEstimator creates an estimator with no keys.
+func NewEstimator() *Estimator {
+	return &Estimator{
+		sizes:    make([]Count, len(sizeLimits)+1),
+		classes:  make(map[string]*Count),
+		prefixes: make(map[string]*Count),
+	}
+}
+
+// Write counts a key.
+func (e *Estimator) Write(key s3.Key) error {
+	e.total.add(key)
+
+	class := len(sizeLimits)
+	for i, s := range sizeLimits {
+		if key.Size <= s.limit {
+			class = i
+			break
+		}
+	}
+	e.sizes[class].add(key)
+
+	storageClass := key.StorageClass
+	if storageClass == "" {
+		storageClass = Standard
+	}
+	if _, ok := e.classes[storageClass]; !ok {
+		e.classes[storageClass] = &Count{}
+	}
+	e.classes[storageClass].add(key)
+
+	prefix := plan.TopLevelPrefix(key.Key)
+	c, ok := e.prefixes[prefix]
+	if !ok {
+		if len(e.prefixes) >= MaxPrefixes {
+			prefix = OtherPrefixes
+			c, ok = e.prefixes[prefix]
+		}
+		if !ok {
+			c = &Count{}
+			e.prefixes[prefix] = c
+		}
+	}
+	c.add(key)
+	return nil
+}
+
+// Flush does nothing, keys are counted as they're written.
+func (e *Estimator) Flush() error { return nil }
+
+// Report the counts of the keys written so far. Prefixes are sorted by
+// decreasing bytes.
+func (e *Estimator) Report() Report {
+	r := Report{Count: e.total}
+	for i, c := range e.sizes {
+		class := SizeClass{UpTo: "more", Count: c}
+		if i < len(sizeLimits) {
+			class.UpTo = sizeLimits[i].label
+		}
+		r.Sizes = append(r.Sizes, class)
+	}
+	r.StorageClasses = make(map[string]Count, len(e.classes))
+	for class, c := range e.classes {
+		r.StorageClasses[class] = *c
+	}
+	for prefix, c := range e.prefixes {
+		r.Prefixes = append(r.Prefixes, Prefix{Prefix: prefix, Count: *c})
+	}
+	sort.Sort(byBytes(r.Prefixes))
+	return r
+}
+
+type byBytes []Prefix
+
+func (p byBytes) Len() int      { return len(p) }
+func (p byBytes) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byBytes) Less(i, j int) bool {
+	if p[i].Bytes != p[j].Bytes {
+		return p[i].Bytes > p[j].Bytes
+	}
+	return p[i].Prefix < p[j].Prefix
+}
@@ -0,0 +1,63 @@
+package estimate_test
+
+import (
+	"github.com/Shopify/brigade/cmd/estimate"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestEstimator(t *testing.T) {
+	e := estimate.NewEstimator()
+	for _, key := range []s3.Key{
+		{Key: "a/empty", Size: 0},
+		{Key: "a/small", Size: 1024},
+		{Key: "b/medium", Size: 5 << 20},
+		{Key: "b/huge", Size: 6 << 30},
+		{Key: "root", Size: 2000},
+	} {
+		if err := e.Write(key); err != nil {
+			t.Fatalf("can't count key: %v", err)
+		}
+	}
+	r := e.Report()
+
+	if r.Keys != 5 || r.Bytes != 1024+5<<20+6<<30+2000 {
+		t.Errorf("want 5 keys and %d bytes, got %+v", 1024+5<<20+6<<30+2000, r.Count)
+	}
+
+	sizes := make(map[string]int64)
+	for _, class := range r.Sizes {
+		sizes[class.UpTo] = class.Keys
+	}
+	want := map[string]int64{
+		"0B": 1, "1KB": 1, "10KB": 1, "100KB": 0, "1MB": 0, "10MB": 1,
+		"100MB": 0, "1GB": 0, "5GB": 0, "more": 1,
+	}
+	if !reflect.DeepEqual(want, sizes) {
+		t.Errorf("want size classes %v, got %v", want, sizes)
+	}
+
+	var prefixes []string
+	for _, p := range r.Prefixes {
+		prefixes = append(prefixes, p.Prefix)
+	}
+	if want := []string{"b/", "", "a/"}; !reflect.DeepEqual(want, prefixes) {
+		t.Errorf("want prefixes by decreasing bytes %q, got %q", want, prefixes)
+	}
+}
+
+func TestEstimatorCapsPrefixes(t *testing.T) {
+	e := estimate.NewEstimator()
+	for i := 0; i < estimate.MaxPrefixes+10; i++ {
+		e.Write(s3.Key{Key: strconv.Itoa(i) + "/key", Size: 1})
+	}
+	r := e.Report()
+	if len(r.Prefixes) != estimate.MaxPrefixes+1 {
+		t.Fatalf("want %d prefixes, got %d", estimate.MaxPrefixes+1, len(r.Prefixes))
+	}
+	if first := r.Prefixes[0]; first.Prefix != estimate.OtherPrefixes || first.Keys != 10 {
+		t.Errorf("want the 10 last prefixes counted together, got %+v", first)
+	}
+}
@@ -0,0 +1,152 @@
+// Package events pushes an event for each key a sync is done with, synced
+// or failed, to a stream that downstream systems such as indexers or cache
+// invalidators consume to react to the sync as it happens.
+//
+// Events are published in batches, in the background, and are delivered at
+// least once: a batch that failed to publish is retried as a whole, and is
+// dropped once its retries are exhausted.
+package events
+
+import (
+	"expvar"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"sync"
+	"time"
+)
+
+// The types of events.
+const (
+	Synced = "synced"
+	Failed = "failed"
+)
+
+// Event about a key that a sync is done with.
+type Event struct {
+	Type        string    `json:"type"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Key         s3.Key    `json:"key"`
+	Time        time.Time `json:"time"`
+	// Error and ErrorCode of the keys that failed to sync, the code being
+	// the one of S3 errors.
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	// RunID of the sync, when it has one.
+	RunID string `json:"run_id,omitempty"`
+}
+
+// Sink receives the events of a sync.
+type Sink interface {
+	Send(ev Event)
+}
+
+// Publisher publishes batches of events to a stream.
+type Publisher interface {
+	// MaxBatch is the most events a call to Publish accepts.
+	MaxBatch() int
+	// Publish a batch of events.
+	Publish(batch []Event) error
+}
+
+var metrics = struct {
+	published *expvar.Int
+	retries   *expvar.Int
+	dropped   *expvar.Int
+}{
+	published: expvar.NewInt("brigade.events.published"),
+	retries:   expvar.NewInt("brigade.events.retries"),
+	dropped:   expvar.NewInt("brigade.events.dropped"),
+}
+
+// Emitter is a Sink that publishes the events it's sent in batches, in the
+// background. Sending blocks when the emitter can't keep up with the sync,
+// rather than losing events.
+type Emitter struct {
+	// MaxRetry is how many times a batch is retried before it's dropped,
+	// sleeping RetryBase times the retry between each. They can only be
+	// changed before the first event is sent.
+	MaxRetry  int
+	RetryBase time.Duration
+
+	pub        Publisher
+	flushEvery time.Duration
+	start      sync.Once
+	events     chan Event
+	done       chan struct{}
+}
+
+// NewEmitter creates an emitter that publishes batches as soon as they're
+// full, and at least every flushEvery.
+func NewEmitter(pub Publisher, flushEvery time.Duration) *Emitter {
+	return &Emitter{
+		MaxRetry:   5,
+		RetryBase:  time.Second,
+		pub:        pub,
+		flushEvery: flushEvery,
+		events:     make(chan Event, 10*pub.MaxBatch()),
+		done:       make(chan struct{}),
+	}
+}
+
+// Send an event, to be published with the next batch.
+func (e *Emitter) Send(ev Event) {
+	e.start.Do(func() { go e.run() })
+	e.events <- ev
+}
+
+// Close publishes the events that were sent, then stops the emitter. No
+// event can be sent once it's closed.
+func (e *Emitter) Close() {
+	e.start.Do(func() { go e.run() })
+	close(e.events)
+	<-e.done
+}
+
+func (e *Emitter) run() {
+	defer close(e.done)
+	tick := time.NewTicker(e.flushEvery)
+	defer tick.Stop()
+
+	batch := make([]Event, 0, e.pub.MaxBatch())
+	for {
+		select {
+		case ev, ok := <-e.events:
+			if !ok {
+				e.publish(batch)
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) < cap(batch) {
+				continue
+			}
+		case <-tick.C:
+		}
+		e.publish(batch)
+		batch = batch[:0]
+	}
+}
+
+// publish a batch, retrying it on errors.
+func (e *Emitter) publish(batch []Event) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for retry := 0; retry <= e.MaxRetry; retry++ {
+		if retry > 0 {
+			metrics.retries.Add(1)
+			time.Sleep(e.RetryBase * time.Duration(retry))
+		}
+		if err = e.pub.Publish(batch); err == nil {
+			metrics.published.Add(int64(len(batch)))
+			return
+		}
+	}
+	metrics.dropped.Add(int64(len(batch)))
+	logrus.WithFields(logrus.Fields{
+		"error":   err,
+		"events":  len(batch),
+		"retries": e.MaxRetry,
+	}).Error("failed to publish events, dropped them")
+}
@@ -0,0 +1,175 @@
+package events_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memPublisher keeps the batches it publishes, failing the first ones.
+type memPublisher struct {
+	mu      sync.Mutex
+	max     int
+	fail    int
+	batches [][]events.Event
+}
+
+func (m *memPublisher) MaxBatch() int { return m.max }
+
+func (m *memPublisher) Publish(batch []events.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("unavailable")
+	}
+	m.batches = append(m.batches, append([]events.Event(nil), batch...))
+	return nil
+}
+
+func sendKeys(e *events.Emitter, n int) {
+	for i := 0; i < n; i++ {
+		e.Send(events.Event{Type: events.Synced, Key: s3.Key{Key: strconv.Itoa(i)}})
+	}
+}
+
+func TestEmitterBatches(t *testing.T) {
+	pub := &memPublisher{max: 3, fail: 1}
+	e := events.NewEmitter(pub, time.Hour)
+	e.RetryBase = time.Millisecond
+	sendKeys(e, 7)
+	e.Close()
+
+	var sizes []int
+	var keys []string
+	for _, batch := range pub.batches {
+		sizes = append(sizes, len(batch))
+		for _, ev := range batch {
+			keys = append(keys, ev.Key.Key)
+		}
+	}
+	if want := []int{3, 3, 1}; !reflect.DeepEqual(want, sizes) {
+		t.Errorf("want batches of %v events, got %v", want, sizes)
+	}
+	if want := []string{"0", "1", "2", "3", "4", "5", "6"}; !reflect.DeepEqual(want, keys) {
+		t.Errorf("want events of keys %v in order, got %v", want, keys)
+	}
+}
+
+func TestEmitterFlushesAndDrops(t *testing.T) {
+	pub := &memPublisher{max: 100}
+	e := events.NewEmitter(pub, 10*time.Millisecond)
+	sendKeys(e, 2)
+	time.Sleep(50 * time.Millisecond)
+	pub.mu.Lock()
+	flushed := len(pub.batches)
+	pub.mu.Unlock()
+	if flushed != 1 {
+		t.Errorf("want the partial batch flushed, got %d batches", flushed)
+	}
+	e.Close()
+
+	// a batch that keeps failing is dropped
+	pub = &memPublisher{max: 100, fail: 3}
+	e = events.NewEmitter(pub, time.Hour)
+	e.MaxRetry, e.RetryBase = 2, time.Millisecond
+	sendKeys(e, 2)
+	e.Close()
+	if len(pub.batches) != 0 {
+		t.Errorf("want the batch dropped, got %d batches", len(pub.batches))
+	}
+}
+
+func TestSQSPublish(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("can't parse form: %v", err)
+		}
+		if action := r.Form.Get("Action"); action != "SendMessageBatch" {
+			t.Errorf("unexpected action %q", action)
+		}
+		for i := 1; r.Form.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			bodies = append(bodies, r.Form.Get(fmt.Sprintf("SendMessageBatchRequestEntry.%d.MessageBody", i)))
+		}
+		if len(bodies) > 1 {
+			fmt.Fprint(w, `<SendMessageBatchResponse><SendMessageBatchResult>
+<BatchResultErrorEntry><Id>1</Id><Code>InternalError</Code><Message>try again</Message></BatchResultErrorEntry>
+</SendMessageBatchResult></SendMessageBatchResponse>`)
+			return
+		}
+		fmt.Fprint(w, `<SendMessageBatchResponse><SendMessageBatchResult></SendMessageBatchResult></SendMessageBatchResponse>`)
+	}))
+	defer srv.Close()
+
+	pub := events.NewSQS(aws.Auth{AccessKey: "a", SecretKey: "b"}, aws.USEast, srv.URL)
+	ev := events.Event{Type: events.Failed, Key: s3.Key{Key: "a"}, ErrorCode: "AccessDenied"}
+	if err := pub.Publish([]events.Event{ev}); err != nil {
+		t.Fatalf("can't publish: %v", err)
+	}
+	var got events.Event
+	if len(bodies) != 1 || json.Unmarshal([]byte(bodies[0]), &got) != nil || got.Key.Key != "a" || got.ErrorCode != "AccessDenied" {
+		t.Errorf("want the event as a JSON message, got %q", bodies)
+	}
+
+	if err := pub.Publish([]events.Event{ev, ev}); err == nil {
+		t.Errorf("want an error when a message isn't sent")
+	}
+}
+
+func TestKinesisPublish(t *testing.T) {
+	var req struct {
+		StreamName string
+		Records    []struct {
+			Data         []byte
+			PartitionKey string
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target := r.Header.Get("X-Amz-Target"); target != "Kinesis_20131202.PutRecords" {
+			t.Errorf("unexpected target %q", target)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("request is not signed")
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("can't decode request: %v", err)
+		}
+		if len(req.Records) > 1 {
+			fmt.Fprint(w, `{"FailedRecordCount":1,"Records":[{"SequenceNumber":"1"},{"ErrorCode":"ProvisionedThroughputExceededException","ErrorMessage":"slow down"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"FailedRecordCount":0,"Records":[{"SequenceNumber":"1"}]}`)
+	}))
+	defer srv.Close()
+
+	region := aws.USEast
+	region.KinesisEndpoint = srv.URL
+	pub := events.NewKinesis(aws.Auth{AccessKey: "a", SecretKey: "b"}, region, "replication")
+	ev := events.Event{Type: events.Synced, Key: s3.Key{Key: "dir/a"}}
+	if err := pub.Publish([]events.Event{ev}); err != nil {
+		t.Fatalf("can't publish: %v", err)
+	}
+	var got events.Event
+	if req.StreamName != "replication" || len(req.Records) != 1 || req.Records[0].PartitionKey != "dir/a" {
+		t.Fatalf("want a record partitioned by key in the stream, got %+v", req)
+	}
+	if err := json.Unmarshal(req.Records[0].Data, &got); err != nil || got.Key.Key != "dir/a" {
+		t.Errorf("want the event as JSON data, got %q", req.Records[0].Data)
+	}
+
+	err := pub.Publish([]events.Event{ev, ev})
+	if kerr, ok := err.(*events.KinesisError); !ok || kerr.Code != "ProvisionedThroughputExceededException" {
+		t.Errorf("want the error of the record that wasn't put, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/queue"
+	"github.com/pushrax/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// SQS publishes each event as a message of an SQS queue, in JSON.
+type SQS struct {
+	q *queue.SQS
+}
+
+// NewSQS publishes to the SQS queue at queueURL.
+func NewSQS(auth aws.Auth, region aws.Region, queueURL string) *SQS {
+	return &SQS{q: queue.NewSQS(auth, region, queueURL)}
+}
+
+// MaxBatch of messages sent at once.
+func (s *SQS) MaxBatch() int { return queue.MaxSendBatch }
+
+// Publish the events as messages of the queue.
+func (s *SQS) Publish(batch []Event) error {
+	bodies := make([][]byte, 0, len(batch))
+	for _, ev := range batch {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("encoding event: %v", err)
+		}
+		bodies = append(bodies, body)
+	}
+	return s.q.SendBatch(bodies)
+}
+
+const (
+	kinesisTarget = "Kinesis_20131202.PutRecords"
+	// maxKinesisBatch is the most records a PutRecords call accepts.
+	maxKinesisBatch = 500
+)
+
+// Kinesis publishes each event as a record of a Kinesis stream, in JSON.
+// Records are partitioned by key, so that the events of a key are in order.
+type Kinesis struct {
+	stream   string
+	endpoint string
+	signer   *aws.V4Signer
+	client   *http.Client
+}
+
+// KinesisError is an error returned by Kinesis.
+type KinesisError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *KinesisError) Error() string {
+	return fmt.Sprintf("kinesis: %s (%s, status %d)", e.Message, e.Code, e.StatusCode)
+}
+
+// NewKinesis publishes to a Kinesis stream of the region.
+func NewKinesis(auth aws.Auth, region aws.Region, stream string) *Kinesis {
+	endpoint := region.KinesisEndpoint
+	if endpoint == "" {
+		endpoint = "https://kinesis." + region.Name + ".amazonaws.com"
+	}
+	return &Kinesis{
+		stream:   stream,
+		endpoint: endpoint,
+		signer:   aws.NewV4Signer(auth, "kinesis", region),
+		client:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+// MaxBatch of records put at once.
+func (k *Kinesis) MaxBatch() int { return maxKinesisBatch }
+
+// Publish the events as records of the stream.
+func (k *Kinesis) Publish(batch []Event) error {
+	type record struct {
+		// encoded in base64, as the API expects
+		Data         []byte
+		PartitionKey string
+	}
+	req := struct {
+		StreamName string
+		Records    []record
+	}{StreamName: k.stream}
+	for _, ev := range batch {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("encoding event: %v", err)
+		}
+		// partition keys can't be empty nor longer than 256 characters
+		partition := ev.Key.Key
+		if runes := []rune(partition); len(runes) > 256 {
+			partition = string(runes[:256])
+		}
+		if partition == "" {
+			partition = "/"
+		}
+		req.Records = append(req.Records, record{Data: data, PartitionKey: partition})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	hreq, err := http.NewRequest("POST", k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("preparing kinesis request: %v", err)
+	}
+	hreq.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	hreq.Header.Set("X-Amz-Target", kinesisTarget)
+	k.signer.Sign(hreq)
+
+	hresp, err := k.client.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = hresp.Body.Close() }()
+	respBody, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if hresp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(respBody, &errResp)
+		return &KinesisError{StatusCode: hresp.StatusCode, Code: errResp.Type, Message: errResp.Message}
+	}
+	var resp struct {
+		FailedRecordCount int
+		Records           []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return fmt.Errorf("decoding kinesis response: %v", err)
+	}
+	if resp.FailedRecordCount == 0 {
+		return nil
+	}
+	for _, r := range resp.Records {
+		if r.ErrorCode != "" {
+			return &KinesisError{
+				StatusCode: hresp.StatusCode,
+				Code:       r.ErrorCode,
+				Message:    fmt.Sprintf("%d of %d records not put, first: %s", resp.FailedRecordCount, len(batch), r.ErrorMessage),
+			}
+		}
+	}
+	return &KinesisError{StatusCode: hresp.StatusCode, Message: fmt.Sprintf("%d of %d records not put", resp.FailedRecordCount, len(batch))}
+}
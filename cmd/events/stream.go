@@ -0,0 +1,220 @@
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamBuffer is the number of messages a client of a stream can fall
+// behind by before the messages it can't keep up with are dropped.
+const streamBuffer = 256
+
+var streamMetrics = struct {
+	clients *expvar.Int
+	dropped *expvar.Int
+}{
+	clients: expvar.NewInt("brigade.events.stream_clients"),
+	dropped: expvar.NewInt("brigade.events.stream_dropped"),
+}
+
+// Stream is a Sink that broadcasts the events it's sent, and any other
+// message published to it such as the progress of a sync, to the clients
+// following it over Server-Sent Events, so that a dashboard or the watch
+// command can follow a run as it happens. Unlike an Emitter, a stream never
+// holds back the sync: the messages a client can't keep up with are dropped,
+// and nothing is encoded while no client follows the stream.
+type Stream struct {
+	mu sync.Mutex
+	// the messages of each client, and closed once the client is gone
+	clients map[chan message]chan struct{}
+	closed  bool
+}
+
+type message struct {
+	event string
+	data  []byte
+}
+
+// NewStream creates a stream without clients.
+func NewStream() *Stream {
+	return &Stream{clients: make(map[chan message]chan struct{})}
+}
+
+// Clients is the number of clients following the stream.
+func (s *Stream) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
+
+// Send the event of a key to the clients, as an event of its type.
+func (s *Stream) Send(ev Event) { s.Publish(ev.Type, ev) }
+
+// Publish v to the clients in JSON, as an event of the given name.
+func (s *Stream) Publish(event string, v interface{}) {
+	if s.Clients() == 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"event": event,
+		}).Error("can't encode message of the event stream")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		select {
+		case c <- message{event: event, data: data}:
+		default:
+			streamMetrics.dropped.Add(1)
+		}
+	}
+}
+
+// subscribe a client, unless the stream is closed.
+func (s *Stream) subscribe() (c chan message, gone chan struct{}, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, false
+	}
+	c, gone = make(chan message, streamBuffer), make(chan struct{})
+	s.clients[c] = gone
+	streamMetrics.clients.Add(1)
+	return c, gone, true
+}
+
+func (s *Stream) unsubscribe(c chan message, gone chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+	close(gone)
+	streamMetrics.clients.Add(-1)
+}
+
+// Close the stream once the clients were sent the messages published so
+// far, or after timeout, so that they see the end of the run rather than a
+// broken connection.
+func (s *Stream) Close(timeout time.Duration) {
+	s.mu.Lock()
+	s.closed = true
+	var gone []chan struct{}
+	for c, g := range s.clients {
+		delete(s.clients, c)
+		close(c)
+		gone = append(gone, g)
+	}
+	s.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, g := range gone {
+		select {
+		case <-g:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// ServeHTTP follows the stream for a client, as Server-Sent Events, until
+// the client goes away or the stream is closed.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	c, gone, ok := s.subscribe()
+	if !ok {
+		http.Error(w, "the run is done", http.StatusGone)
+		return
+	}
+	defer s.unsubscribe(c, gone)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	// a comment, so that the client sees the response before the first
+	// event
+	fmt.Fprint(w, ": brigade events\n\n")
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-c:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.event, msg.data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// ReadStream reads the Server-Sent Events of r, calling fn with the name
+// and the data of each, until r is done or fn returns an error. Events
+// without a name are named "message", as they are by browsers.
+func ReadStream(r io.Reader, fn func(event string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		switch {
+		case len(line) == 0:
+			if data.Len() == 0 {
+				event = ""
+				continue
+			}
+			if event == "" {
+				event = "message"
+			}
+			// the data of the event without the newline of its last line
+			if err := fn(event, bytes.TrimSuffix(data.Bytes(), []byte("\n"))); err != nil {
+				return err
+			}
+			event = ""
+			data.Reset()
+		case line[0] == ':':
+			// a comment
+		default:
+			field, value := line, []byte(nil)
+			if i := bytes.IndexByte(line, ':'); i >= 0 {
+				field, value = line[:i], bytes.TrimPrefix(line[i+1:], []byte(" "))
+			}
+			switch string(field) {
+			case "event":
+				event = string(value)
+			case "data":
+				data.Write(value)
+				data.WriteByte('\n')
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Tee is a Sink sending the events to all of sinks, in order.
+type Tee []Sink
+
+// Send the event to the sinks.
+func (t Tee) Send(ev Event) {
+	for _, sink := range t {
+		sink.Send(ev)
+	}
+}
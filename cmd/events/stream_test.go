@@ -0,0 +1,88 @@
+package events_test
+
+import (
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+
+	stream := events.NewStream()
+	// nothing to follow yet, the events are dropped
+	stream.Send(events.Event{Type: events.Synced, Key: s3.Key{Key: "lost"}})
+
+	srv := httptest.NewServer(stream)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("can't follow the stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("want an event stream, got %q", ct)
+	}
+	for stream.Clients() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	stream.Send(events.Event{Type: events.Synced, Key: s3.Key{Key: "a"}})
+	stream.Send(events.Event{Type: events.Failed, Key: s3.Key{Key: "b"}, ErrorCode: "AccessDenied"})
+	stream.Publish("progress", map[string]int{"synced": 1})
+
+	var got []string
+	read := make(chan error)
+	go func() {
+		read <- events.ReadStream(resp.Body, func(event string, data []byte) error {
+			if event == "progress" {
+				got = append(got, event+" "+string(data))
+				return nil
+			}
+			var ev events.Event
+			if err := json.Unmarshal(data, &ev); err != nil {
+				return err
+			}
+			got = append(got, event+" "+ev.Key.Key+" "+ev.ErrorCode)
+			return nil
+		})
+	}()
+	// the clients are sent what was published before the stream ends
+	stream.Close(5 * time.Second)
+	if err := <-read; err != nil {
+		t.Fatalf("want the stream read until it's closed, got %v", err)
+	}
+	want := []string{"synced a ", "failed b AccessDenied", `progress {"synced":1}`}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want events %q, got %q", want, got)
+	}
+
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("can't get the closed stream: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("want the closed stream gone, got %s", resp.Status)
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	input := ": comment\n\ndata: one\ndata: two\n\nevent: custom\ndata:three\n\nevent: empty\n\n"
+	var got []string
+	err := events.ReadStream(strings.NewReader(input), func(event string, data []byte) error {
+		got = append(got, event+"="+string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't read stream: %v", err)
+	}
+	if want := []string{"message=one\ntwo", "custom=three"}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("want events %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultClient makes the requests of the manifests without a client of
+// their own. Files can be large, it only times out on their headers.
+var defaultClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	ResponseHeaderTimeout: time.Minute,
+}}
+
+// HTTPManifest is a source of the files listed by a manifest served over
+// HTTP or HTTPS. The manifest has the URL of a file on each line, relative
+// to the manifest or not, optionally followed by its size in bytes. Blank
+// lines and lines starting with # are skipped. The keys of the files are
+// their host and path, such as files.example.com/data/2016/a.csv, and their
+// query if they have one.
+type HTTPManifest struct {
+	URL    string
+	Client *http.Client
+}
+
+func (m *HTTPManifest) client() *http.Client {
+	if m.Client == nil {
+		return defaultClient
+	}
+	return m.Client
+}
+
+// List the files of the manifest.
+func (m *HTTPManifest) List(fn func(s3.Key) error) error {
+	base, err := url.Parse(m.URL)
+	if err != nil {
+		return fmt.Errorf("invalid manifest URL %q: %v", m.URL, err)
+	}
+	resp, err := m.client().Get(m.URL)
+	if err != nil {
+		return fmt.Errorf("getting manifest %q: %v", m.URL, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting manifest %q: %s", m.URL, resp.Status)
+	}
+
+	scan := bufio.NewScanner(resp.Body)
+	for line := 1; scan.Scan(); line++ {
+		fields := strings.Fields(scan.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return fmt.Errorf("line %d of manifest %q: want a URL and an optional size, got %q", line, m.URL, scan.Text())
+		}
+		ref, err := url.Parse(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d of manifest %q: %v", line, m.URL, err)
+		}
+		u := base.ResolveReference(ref)
+		if u.Scheme != base.Scheme {
+			return fmt.Errorf("line %d of manifest %q: file %q isn't served over %s like the manifest", line, m.URL, u, base.Scheme)
+		}
+		key := s3.Key{Key: u.Host + u.Path}
+		if u.RawQuery != "" {
+			key.Key += "?" + u.RawQuery
+		}
+		if len(fields) == 2 {
+			if key.Size, err = strconv.ParseInt(fields[1], 10, 64); err != nil || key.Size < 0 {
+				return fmt.Errorf("line %d of manifest %q: invalid size %q", line, m.URL, fields[1])
+			}
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return fmt.Errorf("reading manifest %q: %v", m.URL, err)
+	}
+	return nil
+}
+
+// fileURL is the URL of the file of a key.
+func (m *HTTPManifest) fileURL(key string) (*url.URL, error) {
+	base, err := url.Parse(m.URL)
+	if err != nil {
+		return nil, err
+	}
+	u := &url.URL{Scheme: base.Scheme}
+	u.Host, u.Path = key, "/"
+	if i := strings.Index(key, "/"); i >= 0 {
+		u.Host, u.Path = key[:i], key[i:]
+	}
+	if i := strings.LastIndex(u.Path, "?"); i >= 0 {
+		u.Path, u.RawQuery = u.Path[:i], u.Path[i+1:]
+	}
+	return u, nil
+}
+
+// Open a file of the manifest. Files served without their length are
+// spooled to a temporary file first, to upload them with it.
+func (m *HTTPManifest) Open(key s3.Key) (*File, error) {
+	u, err := m.fileURL(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client().Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	switch code := resp.StatusCode; {
+	case code == http.StatusOK:
+	case code == http.StatusNotFound || code == http.StatusGone:
+		_ = resp.Body.Close()
+		return nil, errNotFound(u.String())
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		_ = resp.Body.Close()
+		return nil, errDenied(u.String())
+	case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+		_ = resp.Body.Close()
+		return nil, &s3.Error{StatusCode: code, Code: s3.ErrSlowDown, Message: "getting " + u.String() + ": " + resp.Status}
+	case code >= 500:
+		_ = resp.Body.Close()
+		return nil, &s3.Error{StatusCode: code, Code: s3.ErrInternalError, Message: "getting " + u.String() + ": " + resp.Status}
+	default:
+		_ = resp.Body.Close()
+		return nil, &s3.Error{StatusCode: code, Code: "UnexpectedStatus", Message: "getting " + u.String() + ": " + resp.Status}
+	}
+	f := &File{ReadCloser: resp.Body, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if f.Size < 0 {
+		defer func() { _ = resp.Body.Close() }()
+		return spool(resp.Body, f.ContentType)
+	}
+	return f, nil
+}
+
+// Close the manifest, there's nothing to close.
+func (m *HTTPManifest) Close() error { return nil }
+
+// spool a file of unknown length to a temporary file, removed once closed.
+func spool(r io.Reader, contentType string) (*File, error) {
+	tmp, err := ioutil.TempFile("", "brigade-ingest-")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	return &File{ReadCloser: spooled{tmp}, Size: size, ContentType: contentType}, nil
+}
+
+type spooled struct{ *os.File }
+
+func (s spooled) Close() error {
+	err := s.File.Close()
+	if rmErr := os.Remove(s.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
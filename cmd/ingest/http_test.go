@@ -0,0 +1,104 @@
+package ingest_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/ingest"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPManifest(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/data/manifest.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "# legacy exports\na.csv 5\n\nsub/b.txt\n/data/gone.txt\n/data/denied.txt\n")
+	})
+	mux.HandleFunc("/data/a.csv", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		fmt.Fprint(w, "a,b,c")
+	})
+	mux.HandleFunc("/data/sub/b.txt", func(w http.ResponseWriter, r *http.Request) {
+		// without a length, spooled before the upload
+		fmt.Fprint(w, "hello ")
+		w.(http.Flusher).Flush()
+		fmt.Fprint(w, "world")
+	})
+	mux.HandleFunc("/data/denied.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	src, err := ingest.NewSource(srv.URL+"/data/manifest.txt", nil)
+	if err != nil {
+		t.Fatalf("can't create source: %v", err)
+	}
+	var input bytes.Buffer
+	w, _ := listing.NewWriter(&input, listing.JSON)
+	var names []string
+	if err := src.List(func(key s3.Key) error {
+		names = append(names, fmt.Sprintf("%s:%d", key.Key, key.Size))
+		return w.Write(key)
+	}); err != nil {
+		t.Fatalf("can't list manifest: %v", err)
+	}
+	want := fmt.Sprintf("%[1]s/data/a.csv:5 %[1]s/data/sub/b.txt:0 %[1]s/data/gone.txt:0 %[1]s/data/denied.txt:0", host)
+	if got := strings.Join(names, " "); got != want {
+		t.Fatalf("want keys %s, got %s", want, got)
+	}
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+	dst := mocks3.S3().Bucket("dst-bucket")
+	if err := dst.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	task, err := sync.NewSyncTask(dst, dst,
+		sync.WithCopier(ingest.Copier(src)),
+		sync.WithMapping(sync.Mapping{From: host + "/data/", To: "legacy/"}),
+		sync.WithRetry(2, time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	var synced, failed bytes.Buffer
+	if err := task.Start(&input, &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	objects := mocks3.ListBuckets()["dst-bucket"].Objects
+	if len(objects) != 2 || string(objects["legacy/a.csv"].Data) != "a,b,c" || string(objects["legacy/sub/b.txt"].Data) != "hello world" {
+		t.Errorf("want the files of the manifest ingested, got %v", objects)
+	}
+	// files gone since listed are like keys deleted since, the others fail
+	if p := task.Progress(); p.Synced != 3 || p.Failed != 1 {
+		t.Errorf("want 3 keys synced and 1 failed, got %+v", p)
+	}
+	if !strings.Contains(failed.String(), "denied.txt") {
+		t.Errorf("want the denied file failed, got %s", failed.String())
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	for rawurl, ok := range map[string]bool{
+		"sftp://user@legacy.example.com:2222/exports": true,
+		"https://files.example.com/manifest.txt":      true,
+		"sftp:///exports":                             false,
+		"s3://bucket/key":                             false,
+		"ftp://legacy.example.com/exports":            false,
+	} {
+		if _, err := ingest.NewSource(rawurl, nil); (err == nil) != ok {
+			t.Errorf("%s: want ok %v, got %v", rawurl, ok, err)
+		}
+	}
+}
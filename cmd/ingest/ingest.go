@@ -0,0 +1,90 @@
+// Package ingest reads the files of sources other than S3, SFTP servers and
+// manifests of files served over HTTP, so that legacy data behind those
+// protocols is ingested into S3 by the sync pipeline, with its retries and
+// reports. The sources are read only: nothing is ever written to them.
+package ingest
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/url"
+	"time"
+)
+
+// Source of files, named as keys.
+type Source interface {
+	// List the files of the source, passing them to fn as keys, stopping
+	// at the first error fn returns.
+	List(fn func(s3.Key) error) error
+	// Open a file listed by the source.
+	Open(key s3.Key) (*File, error)
+	// Close the source.
+	Close() error
+}
+
+// File of a source being read.
+type File struct {
+	io.ReadCloser
+	// Size of the file, as read.
+	Size int64
+	// ContentType of the file, if the source knows it.
+	ContentType string
+}
+
+// lastModified formats the time a file was modified like S3 does in its
+// listings.
+func lastModified(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// The errors of the sources are S3 errors for the sync tasks to handle them
+// like those of S3: files gone since listed are keys deleted since listed,
+// and files that can't be read aren't retried.
+func errNotFound(what string) error {
+	return &s3.Error{StatusCode: 404, Code: s3.ErrNoSuchKey, Message: what + " not found"}
+}
+
+func errDenied(what string) error {
+	return &s3.Error{StatusCode: 403, Code: "AccessDenied", Message: "access to " + what + " denied"}
+}
+
+// Copier copies the files of a source to their key at the destination, as a
+// copier of a sync task syncing the listing of the source. The source
+// bucket of the task isn't read.
+func Copier(src Source) sync.CopyFunc {
+	return func(_, dst *s3.Bucket, key s3.Key, dstKey string) error {
+		f, err := src.Open(key)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		return dst.PutReader(dstKey, bufio.NewReader(f), f.Size, f.ContentType, s3.Private, s3.Options{})
+	}
+}
+
+// NewSource creates the source of a URL: sftp://user@host:port/path for the
+// files under a path of an SFTP server, reached with sshCommand, or the URL
+// of a manifest of files served over HTTP or HTTPS.
+func NewSource(rawurl string, sshCommand []string) (Source, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source %q: %v", rawurl, err)
+	}
+	switch u.Scheme {
+	case "sftp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("need the host of SFTP source %q", rawurl)
+		}
+		root := u.Path
+		if root == "" {
+			root = "."
+		}
+		return &SFTP{Dial: SSH(sshCommand, u), Root: root}, nil
+	case "http", "https":
+		return &HTTPManifest{URL: rawurl}, nil
+	}
+	return nil, fmt.Errorf("unknown source %q, want an sftp:// URL or the http(s):// URL of a manifest", rawurl)
+}
@@ -0,0 +1,597 @@
+package ingest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SFTP is a source of the files under Root on an SFTP server, through the
+// connections Dial makes, such as an ssh command running the sftp subsystem.
+// The keys of the files are their path under Root. A connection that failed
+// is dialed again by the next call, so that the retries of the sync tasks
+// get through the server restarting or the network failing.
+type SFTP struct {
+	Dial func() (io.ReadWriteCloser, error)
+	Root string
+
+	mu     sync.Mutex
+	client *sftpClient
+}
+
+// SSH dials the SFTP server of a URL by running an ssh command with the
+// sftp subsystem, such as ssh -p 2222 -s -- user@host sftp, and speaking SFTP
+// over its stdin and stdout. The command authenticates as ssh does, with
+// the keys of the agent or the options given, non interactively.
+func SSH(command []string, u *url.URL) func() (io.ReadWriteCloser, error) {
+	if len(command) == 0 {
+		command = []string{"ssh"}
+	}
+	return func() (io.ReadWriteCloser, error) {
+		args := append([]string{}, command[1:]...)
+		args = append(args, "-o", "BatchMode=yes")
+		if port := u.Port(); port != "" {
+			args = append(args, "-p", port)
+		}
+		host := u.Hostname()
+		if u.User != nil {
+			host = u.User.Username() + "@" + host
+		}
+		// the host ends the options, so that a host such as
+		// -oProxyCommand=... isn't read as one
+		args = append(args, "-s", "--", host, "sftp")
+		cmd := exec.Command(command[0], args...)
+		cmd.Stderr = os.Stderr
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("running %s: %v", command[0], err)
+		}
+		return &sshConn{cmd: cmd, WriteCloser: stdin, r: stdout}, nil
+	}
+}
+
+// sshConn is the stdin and stdout of an ssh command.
+type sshConn struct {
+	cmd *exec.Cmd
+	io.WriteCloser
+	r io.Reader
+}
+
+func (c *sshConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+func (c *sshConn) Close() error {
+	_ = c.WriteCloser.Close()
+	return c.cmd.Wait()
+}
+
+// conn returns the client of the current connection, dialing a new one if
+// there's none or it failed.
+func (s *SFTP) conn() (*sftpClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		if s.client.failed() == nil {
+			return s.client, nil
+		}
+		logrus.WithField("error", s.client.failed()).Warn("SFTP connection failed, connecting again")
+		_ = s.client.close()
+		s.client = nil
+	}
+	conn, err := s.Dial()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SFTP server: %v", err)
+	}
+	c, err := newSFTPClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("connecting to SFTP server: %v", err)
+	}
+	s.client = c
+	return c, nil
+}
+
+// List the regular files under Root, depth first, with the entries of each
+// directory in order. Symbolic links aren't followed.
+func (s *SFTP) List(fn func(s3.Key) error) error {
+	c, err := s.conn()
+	if err != nil {
+		return err
+	}
+	return s.walk(c, "", fn)
+}
+
+func (s *SFTP) walk(c *sftpClient, dir string, fn func(s3.Key) error) error {
+	entries, err := c.readDir(path.Join(s.Root, dir))
+	if err != nil {
+		return fmt.Errorf("listing %q: %v", path.Join(s.Root, dir), err)
+	}
+	for _, e := range entries {
+		name := path.Join(dir, e.name)
+		switch {
+		case e.attrs.isDir():
+			if err := s.walk(c, name, fn); err != nil {
+				return err
+			}
+		case e.attrs.isRegular():
+			key := s3.Key{Key: name, Size: int64(e.attrs.size)}
+			if e.attrs.flags&attrACModTime != 0 {
+				key.LastModified = lastModified(time.Unix(int64(e.attrs.mtime), 0))
+			}
+			if err := fn(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Open a file under Root.
+func (s *SFTP) Open(key s3.Key) (*File, error) {
+	c, err := s.conn()
+	if err != nil {
+		return nil, err
+	}
+	name := path.Join(s.Root, key.Key)
+	handle, err := c.open(name)
+	if err != nil {
+		return nil, sftpError(name, err)
+	}
+	attrs, err := c.fstat(handle)
+	if err != nil {
+		_ = c.closeHandle(handle)
+		return nil, sftpError(name, err)
+	}
+	f := &sftpFile{c: c, handle: handle}
+	return &File{ReadCloser: f, Size: int64(attrs.size)}, nil
+}
+
+// Close the connection.
+func (s *SFTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.close()
+	s.client = nil
+	return err
+}
+
+// sftpError is the S3 error of an error of the server, for the sync tasks.
+func sftpError(name string, err error) error {
+	if e, ok := err.(*statusError); ok {
+		switch e.code {
+		case fxNoSuchFile:
+			return errNotFound(name)
+		case fxPermissionDenied:
+			return errDenied(name)
+		}
+	}
+	return fmt.Errorf("reading %q: %v", name, err)
+}
+
+// sftpFile reads a file, a request at a time.
+type sftpFile struct {
+	c      *sftpClient
+	handle string
+	offset uint64
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if len(p) > maxRead {
+		p = p[:maxRead]
+	}
+	data, err := f.c.read(f.handle, f.offset, uint32(len(p)))
+	n := copy(p, data)
+	f.offset += uint64(n)
+	return n, err
+}
+
+func (f *sftpFile) Close() error { return f.c.closeHandle(f.handle) }
+
+// The SFTP protocol, version 3, as most servers speak it, see
+// draft-ietf-secsh-filexfer-02.
+const (
+	fxpInit    = 1
+	fxpVersion = 2
+	fxpOpen    = 3
+	fxpClose   = 4
+	fxpRead    = 5
+	fxpFstat   = 8
+	fxpOpendir = 11
+	fxpReaddir = 12
+	fxpStatus  = 101
+	fxpHandle  = 102
+	fxpData    = 103
+	fxpName    = 104
+	fxpAttrs   = 105
+
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+
+	attrSize        = 0x1
+	attrUIDGID      = 0x2
+	attrPermissions = 0x4
+	attrACModTime   = 0x8
+	attrExtended    = 0x80000000
+
+	fxfRead = 0x1
+
+	// the most data servers return for a read
+	maxRead = 32768
+	// the largest packet read, data and names included
+	maxPacket = 256 * 1024
+)
+
+// statusError is a status of the server other than OK and EOF.
+type statusError struct {
+	code uint32
+	msg  string
+}
+
+func (e *statusError) Error() string { return fmt.Sprintf("sftp status %d: %s", e.code, e.msg) }
+
+// attrs of a file.
+type attrs struct {
+	flags uint32
+	size  uint64
+	perm  uint32
+	mtime uint32
+}
+
+func (a attrs) isDir() bool     { return a.flags&attrPermissions != 0 && a.perm&0170000 == 0040000 }
+func (a attrs) isRegular() bool { return a.flags&attrPermissions != 0 && a.perm&0170000 == 0100000 }
+
+type dirEntry struct {
+	name  string
+	attrs attrs
+}
+
+// sftpClient makes requests to an SFTP server over a connection, many at
+// once, matching the responses to the requests by their id.
+type sftpClient struct {
+	conn io.ReadWriteCloser
+	wmu  sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan response
+	// err the connection failed with, all the calls fail with it
+	err error
+}
+
+type response struct {
+	typ  byte
+	data []byte
+	err  error
+}
+
+// newSFTPClient negotiates the version of the protocol with the server.
+func newSFTPClient(conn io.ReadWriteCloser) (*sftpClient, error) {
+	c := &sftpClient{conn: conn, pending: make(map[uint32]chan response)}
+	hello := appendUint32([]byte{0, 0, 0, 0, fxpInit}, 3)
+	if err := writePacket(conn, hello); err != nil {
+		return nil, err
+	}
+	typ, data, err := readPacket(conn)
+	if err != nil {
+		return nil, err
+	}
+	if typ != fxpVersion || len(data) < 4 {
+		return nil, fmt.Errorf("want the version of the server, got packet %d", typ)
+	}
+	if v := binary.BigEndian.Uint32(data); v != 3 {
+		return nil, fmt.Errorf("server speaks SFTP version %d, want 3", v)
+	}
+	go c.loop()
+	return c, nil
+}
+
+// loop passes the responses to the requests waiting for them, until the
+// connection fails.
+func (c *sftpClient) loop() {
+	for {
+		typ, data, err := readPacket(c.conn)
+		if err == nil && len(data) < 4 {
+			err = fmt.Errorf("packet %d too short", typ)
+		}
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		id := binary.BigEndian.Uint32(data)
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- response{typ: typ, data: data[4:]}
+		}
+	}
+}
+
+func (c *sftpClient) fail(err error) {
+	if err == io.EOF {
+		err = errors.New("connection closed by the server")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		ch <- response{err: c.err}
+		delete(c.pending, id)
+	}
+}
+
+func (c *sftpClient) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *sftpClient) close() error {
+	c.fail(errors.New("connection closed"))
+	return c.conn.Close()
+}
+
+// call the server with a request of typ, whose payload after its id is
+// appended by payload.
+func (c *sftpClient) call(typ byte, payload func([]byte) []byte) (byte, []byte, error) {
+	ch := make(chan response, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	p := appendUint32([]byte{0, 0, 0, 0, typ}, id)
+	c.wmu.Lock()
+	err := writePacket(c.conn, payload(p))
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+	}
+	resp := <-ch
+	return resp.typ, resp.data, resp.err
+}
+
+// status of a response that should be one.
+func status(typ byte, data []byte) error {
+	if typ != fxpStatus {
+		return fmt.Errorf("unexpected packet %d", typ)
+	}
+	d := decoder{b: data}
+	code, msg := d.uint32(), d.string()
+	switch {
+	case d.err != nil:
+		return d.err
+	case code == fxOK:
+		return nil
+	case code == fxEOF:
+		return io.EOF
+	}
+	return &statusError{code: code, msg: msg}
+}
+
+func (c *sftpClient) handle(typ byte, payload func([]byte) []byte) (string, error) {
+	typ, data, err := c.call(typ, payload)
+	if err != nil {
+		return "", err
+	}
+	if typ != fxpHandle {
+		return "", status(typ, data)
+	}
+	d := decoder{b: data}
+	h := d.string()
+	return h, d.err
+}
+
+func (c *sftpClient) open(name string) (string, error) {
+	return c.handle(fxpOpen, func(b []byte) []byte {
+		b = appendString(b, name)
+		b = appendUint32(b, fxfRead)
+		// no attrs
+		return appendUint32(b, 0)
+	})
+}
+
+func (c *sftpClient) closeHandle(handle string) error {
+	typ, data, err := c.call(fxpClose, func(b []byte) []byte { return appendString(b, handle) })
+	if err != nil {
+		return err
+	}
+	return status(typ, data)
+}
+
+func (c *sftpClient) fstat(handle string) (attrs, error) {
+	typ, data, err := c.call(fxpFstat, func(b []byte) []byte { return appendString(b, handle) })
+	if err != nil {
+		return attrs{}, err
+	}
+	if typ != fxpAttrs {
+		return attrs{}, status(typ, data)
+	}
+	d := decoder{b: data}
+	a := d.attrs()
+	return a, d.err
+}
+
+func (c *sftpClient) read(handle string, offset uint64, n uint32) ([]byte, error) {
+	typ, data, err := c.call(fxpRead, func(b []byte) []byte {
+		b = appendString(b, handle)
+		b = appendUint64(b, offset)
+		return appendUint32(b, n)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if typ != fxpData {
+		return nil, status(typ, data)
+	}
+	d := decoder{b: data}
+	out := d.string()
+	return []byte(out), d.err
+}
+
+// readDir reads the entries of a directory, but . and .., sorted by name.
+func (c *sftpClient) readDir(dir string) ([]dirEntry, error) {
+	handle, err := c.handle(fxpOpendir, func(b []byte) []byte { return appendString(b, dir) })
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = c.closeHandle(handle) }()
+	var entries []dirEntry
+	for {
+		typ, data, err := c.call(fxpReaddir, func(b []byte) []byte { return appendString(b, handle) })
+		if err != nil {
+			return nil, err
+		}
+		if typ != fxpName {
+			if err := status(typ, data); err != io.EOF {
+				if err == nil {
+					err = errors.New("unexpected status OK")
+				}
+				return nil, err
+			}
+			break
+		}
+		d := decoder{b: data}
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			name := d.string()
+			_ = d.string() // long name, as ls -l shows it
+			a := d.attrs()
+			if name != "." && name != ".." && !strings.Contains(name, "/") {
+				entries = append(entries, dirEntry{name: name, attrs: a})
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	return entries, nil
+}
+
+// writePacket writes a packet whose first 4 bytes are left for its length.
+func writePacket(w io.Writer, p []byte) error {
+	binary.BigEndian.PutUint32(p, uint32(len(p)-4))
+	_, err := w.Write(p)
+	return err
+}
+
+// readPacket reads the type and data of a packet.
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:4])
+	if n < 1 || n > maxPacket {
+		return 0, nil, fmt.Errorf("invalid packet length %d", n)
+	}
+	data := make([]byte, n-1)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+	return head[4], data, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// decoder reads the fields of a packet, until one is missing.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 4 {
+		d.err = errors.New("packet too short")
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	return uint64(d.uint32())<<32 | uint64(d.uint32())
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	if d.err != nil {
+		return ""
+	}
+	if uint32(len(d.b)) < n {
+		d.err = errors.New("packet too short")
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *decoder) attrs() attrs {
+	a := attrs{flags: d.uint32()}
+	if a.flags&attrSize != 0 {
+		a.size = d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.perm = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		d.uint32()
+		a.mtime = d.uint32()
+	}
+	if a.flags&attrExtended != 0 {
+		for n := d.uint32(); n > 0 && d.err == nil; n-- {
+			d.string()
+			d.string()
+		}
+	}
+	return a
+}
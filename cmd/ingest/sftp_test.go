@@ -0,0 +1,261 @@
+package ingest_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/ingest"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sftpServer serves the files under a directory over SFTP, as much of it
+// as the source speaks.
+type sftpServer struct {
+	t       *testing.T
+	root    string
+	handles map[string]interface{}
+	next    int
+}
+
+func (s *sftpServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	for {
+		var head [5]byte
+		if _, err := io.ReadFull(conn, head[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(head[:4])-1)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return
+		}
+		if head[4] == 1 { // init
+			s.write(conn, 2, 3)
+			continue
+		}
+		id, data := binary.BigEndian.Uint32(data), data[4:]
+		str := func() string {
+			n := binary.BigEndian.Uint32(data)
+			v := string(data[4 : 4+n])
+			data = data[4+n:]
+			return v
+		}
+		switch head[4] {
+		case 3, 11: // open, opendir
+			name := filepath.Join(s.root, str())
+			var h interface{}
+			var err error
+			if head[4] == 3 {
+				h, err = os.Open(name)
+			} else {
+				h, err = ioutil.ReadDir(name)
+			}
+			if err != nil {
+				s.status(conn, id, err)
+				continue
+			}
+			s.next++
+			handle := fmt.Sprint(s.next)
+			s.handles[handle] = h
+			s.write(conn, 102, id, handle)
+		case 4: // close
+			if f, ok := s.handles[str()].(*os.File); ok {
+				_ = f.Close()
+			}
+			s.status(conn, id, nil)
+		case 5: // read
+			f := s.handles[str()].(*os.File)
+			offset := binary.BigEndian.Uint64(data)
+			b := make([]byte, binary.BigEndian.Uint32(data[8:]))
+			n, err := f.ReadAt(b, int64(offset))
+			if n == 0 {
+				s.status(conn, id, err)
+				continue
+			}
+			s.write(conn, 103, id, string(b[:n]))
+		case 8: // fstat
+			fi, _ := s.handles[str()].(*os.File).Stat()
+			s.write(conn, 105, append([]interface{}{id}, attrs(fi)...)...)
+		case 12: // readdir
+			handle := str()
+			infos, _ := s.handles[handle].([]os.FileInfo)
+			if infos == nil {
+				s.status(conn, id, io.EOF)
+				continue
+			}
+			s.handles[handle] = []os.FileInfo(nil)
+			fields := []interface{}{id, uint32(len(infos) + 1), ".", "drwxr-xr-x .", uint32(0)}
+			for _, fi := range infos {
+				fields = append(fields, fi.Name(), "-rw-r--r-- "+fi.Name())
+				fields = append(fields, attrs(fi)...)
+			}
+			s.write(conn, 104, fields...)
+		default:
+			s.t.Errorf("unexpected SFTP request %d", head[4])
+			return
+		}
+	}
+}
+
+func attrs(fi os.FileInfo) []interface{} {
+	perm := uint32(0100644)
+	switch {
+	case fi.IsDir():
+		perm = 040755
+	case fi.Mode()&os.ModeSymlink != 0:
+		perm = 0120777
+	}
+	return []interface{}{uint32(0x1 | 0x4 | 0x8), uint64(fi.Size()), perm, uint32(fi.ModTime().Unix()), uint32(fi.ModTime().Unix())}
+}
+
+func (s *sftpServer) status(conn net.Conn, id uint32, err error) {
+	code := uint32(0)
+	switch {
+	case err == io.EOF:
+		code = 1
+	case os.IsNotExist(err):
+		code = 2
+	case os.IsPermission(err):
+		code = 3
+	case err != nil:
+		code = 4
+	}
+	s.write(conn, 101, id, code, fmt.Sprint(err), "")
+}
+
+func (s *sftpServer) write(conn net.Conn, typ byte, fields ...interface{}) {
+	var b bytes.Buffer
+	b.WriteByte(typ)
+	for _, f := range fields {
+		switch v := f.(type) {
+		case int:
+			_ = binary.Write(&b, binary.BigEndian, uint32(v))
+		case string:
+			_ = binary.Write(&b, binary.BigEndian, uint32(len(v)))
+			b.WriteString(v)
+		default:
+			_ = binary.Write(&b, binary.BigEndian, v)
+		}
+	}
+	p := make([]byte, 4, 4+b.Len())
+	binary.BigEndian.PutUint32(p, uint32(b.Len()))
+	_, _ = conn.Write(append(p, b.Bytes()...))
+}
+
+func TestSFTP(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	root, err := ioutil.TempDir("", "brigade-sftp-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(root) }()
+	big := strings.Repeat("0123456789", 10000)
+	for name, data := range map[string]string{
+		"exports/a.csv":         "a,b,c",
+		"exports/2016/big.bin":  big,
+		"exports/2016/empty":    "",
+		"elsewhere/secret.txt":  "not exported",
+		"exports/2015/.keep":    "",
+		"exports/2015/z/y/x.gz": "x",
+	} {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// links aren't followed out of the exports
+	if err := os.Symlink("../elsewhere", filepath.Join(root, "exports/link")); err != nil {
+		t.Fatal(err)
+	}
+
+	var servers []net.Conn
+	src := &ingest.SFTP{Root: "exports", Dial: func() (io.ReadWriteCloser, error) {
+		client, server := net.Pipe()
+		servers = append(servers, server)
+		srv := &sftpServer{t: t, root: root, handles: make(map[string]interface{})}
+		go srv.serve(server)
+		return client, nil
+	}}
+	defer func() { _ = src.Close() }()
+
+	var keys []string
+	if err := src.List(func(key s3.Key) error {
+		keys = append(keys, fmt.Sprintf("%s:%d", key.Key, key.Size))
+		if key.LastModified == "" {
+			t.Errorf("want the time %s was modified", key.Key)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("can't list: %v", err)
+	}
+	want := "2015/.keep:0 2015/z/y/x.gz:1 2016/big.bin:100000 2016/empty:0 a.csv:5"
+	if got := strings.Join(keys, " "); got != want {
+		t.Fatalf("want keys %s, got %s", want, got)
+	}
+
+	read := func(key string) (string, error) {
+		f, err := src.Open(s3.Key{Key: key})
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = f.Close() }()
+		data, err := ioutil.ReadAll(f)
+		if err == nil && int64(len(data)) != f.Size {
+			err = fmt.Errorf("read %d bytes of %d", len(data), f.Size)
+		}
+		return string(data), err
+	}
+	if data, err := read("2016/big.bin"); err != nil || data != big {
+		t.Errorf("want the big file read whole, got %d bytes: %v", len(data), err)
+	}
+
+	// the server going away, the next call connects again
+	_ = servers[0].Close()
+	time.Sleep(10 * time.Millisecond)
+	if data, err := read("a.csv"); err != nil || data != "a,b,c" {
+		t.Errorf("want a.csv read on a new connection, got %q: %v", data, err)
+	}
+	if len(servers) != 2 {
+		t.Errorf("want 2 connections, got %d", len(servers))
+	}
+
+	_, err = read("gone.csv")
+	if e, ok := err.(*s3.Error); !ok || e.Code != s3.ErrNoSuchKey {
+		t.Errorf("want files gone since listed not found, got %v", err)
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	u, err := url.Parse("sftp://-oProxyCommand=evil:2222/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a command that prints its arguments rather than connecting
+	conn, err := ingest.SSH([]string{"sh", "-c", `printf '%s\n' "$@"`, "ssh"}, u)()
+	if err != nil {
+		t.Fatalf("can't run command: %v", err)
+	}
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "-o BatchMode=yes -p 2222 -s -- -oProxyCommand=evil sftp"
+	if got := strings.Join(strings.Fields(string(out)), " "); got != want {
+		t.Errorf("want arguments %q, got %q", want, got)
+	}
+}
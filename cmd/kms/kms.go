@@ -0,0 +1,125 @@
+// Package kms generates and decrypts the data keys of AWS KMS, with which
+// files are encrypted at rest without keeping the key itself around: only
+// the data key encrypted by KMS is stored next to the data, and KMS is asked
+// to decrypt it when the file is read again.
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// dataKeySpec is the kind of the data keys generated, for AES-256.
+const dataKeySpec = "AES_256"
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Client of KMS, which generates data keys under KeyID, and decrypts the
+// data keys it generated.
+type Client struct {
+	Credentials aws.CredentialsProvider
+	// Region of KMS, and Endpoint, https://kms.<region>.amazonaws.com when
+	// empty.
+	Region   string
+	Endpoint string
+	// KeyID is the ID, ARN or alias of the key that encrypts the data keys.
+	// It's only needed to generate them, KMS finds it in the data keys it
+	// decrypts.
+	KeyID  string
+	Client *http.Client
+}
+
+type kmsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// GenerateDataKey generates a new data key, returned as is, to encrypt
+// data with, and encrypted by KMS, to store along with the data.
+func (c Client) GenerateDataKey() (key, encrypted []byte, err error) {
+	if c.KeyID == "" {
+		return nil, nil, errors.New("need a key to generate data keys under")
+	}
+	var resp struct {
+		Plaintext      []byte
+		CiphertextBlob []byte
+	}
+	req := map[string]string{"KeyId": c.KeyID, "KeySpec": dataKeySpec}
+	if err := c.do("GenerateDataKey", req, &resp); err != nil {
+		return nil, nil, fmt.Errorf("generating data key under %q: %v", c.KeyID, err)
+	}
+	return resp.Plaintext, resp.CiphertextBlob, nil
+}
+
+// Decrypt a data key that GenerateDataKey encrypted.
+func (c Client) Decrypt(encrypted []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte
+	}
+	req := map[string][]byte{"CiphertextBlob": encrypted}
+	if err := c.do("Decrypt", req, &resp); err != nil {
+		return nil, fmt.Errorf("decrypting data key: %v", err)
+	}
+	return resp.Plaintext, nil
+}
+
+// do calls an action of the JSON API of KMS, signed with the credentials of
+// the client.
+func (c Client) do(action string, in, out interface{}) error {
+	if c.Credentials == nil {
+		return errors.New("need credentials to call KMS")
+	}
+	auth, err := c.Credentials.Credentials()
+	if err != nil {
+		return err
+	}
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		if c.Region == "" {
+			return errors.New("need the region of KMS")
+		}
+		endpoint = "https://kms." + c.Region + ".amazonaws.com"
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	if token := auth.SessionToken(); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	aws.NewV4Signer(auth, "kms", aws.Region{Name: c.Region}).Sign(req)
+
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e kmsError
+		if json.Unmarshal(data, &e) != nil || e.Type == "" {
+			return fmt.Errorf("%s: %s", action, resp.Status)
+		}
+		return fmt.Errorf("%s: %s: %s", action, e.Type, e.Message)
+	}
+	return json.Unmarshal(data, out)
+}
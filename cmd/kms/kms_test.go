@@ -0,0 +1,73 @@
+package kms_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/creds"
+	"github.com/Shopify/brigade/cmd/kms"
+	"github.com/pushrax/goamz/aws"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/kms/aws4_request") {
+			t.Errorf("want the request signed for kms, got %q", r.Header.Get("Authorization"))
+		}
+		var req map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("can't decode request: %v", err)
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GenerateDataKey":
+			if req["KeyId"] != "alias/state" || req["KeySpec"] != "AES_256" {
+				t.Errorf("want an AES-256 data key under alias/state, got %v", req)
+			}
+			// base64 of "plain" and "blob"
+			fmt.Fprint(w, `{"Plaintext":"cGxhaW4=","CiphertextBlob":"YmxvYg==","KeyId":"arn"}`)
+		case "TrentService.Decrypt":
+			if req["CiphertextBlob"] != "YmxvYg==" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"__type":"InvalidCiphertextException","message":"bad blob"}`)
+				return
+			}
+			fmt.Fprint(w, `{"Plaintext":"cGxhaW4=","KeyId":"arn"}`)
+		default:
+			t.Errorf("unexpected target %q", r.Header.Get("X-Amz-Target"))
+		}
+	}))
+	defer srv.Close()
+
+	client := kms.Client{
+		Credentials: creds.Static{Auth: aws.Auth{AccessKey: "key", SecretKey: "secret"}},
+		Region:      "us-east-1",
+		Endpoint:    srv.URL,
+		KeyID:       "alias/state",
+	}
+	key, encrypted, err := client.GenerateDataKey()
+	if err != nil {
+		t.Fatalf("can't generate data key: %v", err)
+	}
+	if string(key) != "plain" || string(encrypted) != "blob" {
+		t.Errorf("want the data key and its encrypted blob, got %q and %q", key, encrypted)
+	}
+	key, err = client.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("can't decrypt data key: %v", err)
+	}
+	if string(key) != "plain" {
+		t.Errorf("want the decrypted data key, got %q", key)
+	}
+
+	if _, err := client.Decrypt([]byte("nope")); err == nil || !strings.Contains(err.Error(), "InvalidCiphertextException") {
+		t.Errorf("want the error of KMS, got %v", err)
+	}
+	client.KeyID = ""
+	if _, _, err := client.GenerateDataKey(); err == nil {
+		t.Error("want an error generating a data key without a key")
+	}
+}
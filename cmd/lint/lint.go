@@ -0,0 +1,139 @@
+// Package lint finds the keys of a listing that are likely to cause problems
+// when syncing them, or when using them once synced, so that they can be
+// dealt with before a sync rather than in its failures.
+package lint
+
+import (
+	"encoding/json"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxKeyLength is the length of the longest key S3 accepts, in bytes.
+const MaxKeyLength = 1024
+
+// The problems of keys.
+const (
+	// ControlChars are keys with control characters, which many tools and
+	// XML parsers choke on.
+	ControlChars = "control_characters"
+	// TrailingSpace are keys with a segment that ends in white space, which
+	// is easily lost by the tools that trim it.
+	TrailingSpace = "trailing_space"
+	// TooLong are keys longer than MaxKeyLength bytes.
+	TooLong = "too_long"
+	// InvalidUTF8 are keys that aren't valid UTF-8, which JSON listings and
+	// S3 itself can't represent.
+	InvalidUTF8 = "invalid_utf8"
+)
+
+// Check returns the problems of a key, none if it's fine.
+func Check(key string) []string {
+	var problems []string
+	if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		problems = append(problems, ControlChars)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if last, _ := utf8.DecodeLastRuneInString(segment); segment != "" && unicode.IsSpace(last) {
+			problems = append(problems, TrailingSpace)
+			break
+		}
+	}
+	if len(key) > MaxKeyLength {
+		problems = append(problems, TooLong)
+	}
+	if !utf8.ValidString(key) {
+		problems = append(problems, InvalidUTF8)
+	}
+	return problems
+}
+
+// Normalize a key so that Check finds no problem with it: the invalid UTF-8
+// is replaced by U+FFFD, the control characters and the white space at the
+// end of the segments are dropped, and the key is cut to MaxKeyLength
+// bytes. Keys can normalize to the same name.
+func Normalize(key string) string {
+	key = strings.ToValidUTF8(key, string(utf8.RuneError))
+	key = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, key)
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = strings.TrimRightFunc(segment, unicode.IsSpace)
+	}
+	key = strings.Join(segments, "/")
+	for len(key) > MaxKeyLength {
+		_, size := utf8.DecodeLastRuneInString(key)
+		key = key[:len(key)-size]
+	}
+	return key
+}
+
+// Finding is a key that has problems. Quoted is the key in Go syntax, with
+// its control characters and invalid bytes escaped. Normalized is the name
+// the key would be synced under when normalizing.
+type Finding struct {
+	Key        string   `json:"key"`
+	Quoted     string   `json:"quoted"`
+	Problems   []string `json:"problems"`
+	Normalized string   `json:"normalized,omitempty"`
+}
+
+// Report of the keys linted.
+type Report struct {
+	Keys int64 `json:"keys"`
+	// Findings is the number of keys that have problems.
+	Findings int64 `json:"findings"`
+	// Problems counts the keys that have each problem.
+	Problems map[string]int64 `json:"problems"`
+}
+
+// Linter checks the keys written to it, writing a Finding as a line of JSON
+// for each key that has problems. It's a listing.Writer, so that it can be
+// fed by a listing as well as by the listing of a bucket.
+type Linter struct {
+	normalize func(string) string
+	enc       *json.Encoder
+	report    Report
+}
+
+// NewLinter creates a linter writing its findings to w. When normalize is
+// set, the findings have the name the keys are normalized to with it.
+func NewLinter(w io.Writer, normalize func(string) string) *Linter {
+	return &Linter{
+		normalize: normalize,
+		enc:       json.NewEncoder(w),
+		report:    Report{Problems: make(map[string]int64)},
+	}
+}
+
+// Write checks a key.
+func (l *Linter) Write(key s3.Key) error {
+	l.report.Keys++
+	problems := Check(key.Key)
+	if len(problems) == 0 {
+		return nil
+	}
+	l.report.Findings++
+	for _, p := range problems {
+		l.report.Problems[p]++
+	}
+	f := Finding{Key: key.Key, Quoted: strconv.QuoteToASCII(key.Key), Problems: problems}
+	if l.normalize != nil {
+		f.Normalized = l.normalize(key.Key)
+	}
+	return l.enc.Encode(f)
+}
+
+// Flush does nothing, findings are written as they're found.
+func (l *Linter) Flush() error { return nil }
+
+// Report the keys linted so far.
+func (l *Linter) Report() Report { return l.report }
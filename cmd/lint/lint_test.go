@@ -0,0 +1,74 @@
+package lint_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/lint"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	long := strings.Repeat("é", lint.MaxKeyLength/2) + "x"
+	for key, want := range map[string][]string{
+		"fine/key.txt":       nil,
+		"with space/in it":   nil,
+		"bell\a":             {lint.ControlChars},
+		"trailing ":          {lint.TrailingSpace},
+		"dir /key":           {lint.TrailingSpace},
+		long:                 {lint.TooLong},
+		"latin1-\xe9":        {lint.InvalidUTF8},
+		"tab\t/latin1-\xe9 ": {lint.ControlChars, lint.TrailingSpace, lint.InvalidUTF8},
+	} {
+		if got := lint.Check(key); !reflect.DeepEqual(want, got) {
+			t.Errorf("%q: want problems %v, got %v", key, want, got)
+		}
+		if got := lint.Check(lint.Normalize(key)); len(got) != 0 {
+			t.Errorf("%q: want no problems once normalized, got %v", key, got)
+		}
+	}
+	if got := lint.Normalize("tab\t/latin1-\xe9 "); got != "tab/latin1-�" {
+		t.Errorf("want the key normalized, got %q", got)
+	}
+	if got := lint.Normalize(long); len(got) != lint.MaxKeyLength || got != long[:lint.MaxKeyLength] {
+		t.Errorf("want the key cut on a rune, got %d bytes", len(got))
+	}
+}
+
+func TestLinter(t *testing.T) {
+	var out bytes.Buffer
+	l := lint.NewLinter(&out, func(key string) string { return "new/" + lint.Normalize(key) })
+	for _, key := range []string{"ok", "trailing ", "bell\a ", "also/ok"} {
+		if err := l.Write(s3.Key{Key: key}); err != nil {
+			t.Fatalf("can't lint key: %v", err)
+		}
+	}
+
+	want := lint.Report{
+		Keys:     4,
+		Findings: 2,
+		Problems: map[string]int64{lint.ControlChars: 1, lint.TrailingSpace: 2},
+	}
+	if got := l.Report(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want report %+v, got %+v", want, got)
+	}
+
+	var findings []lint.Finding
+	scan := bufio.NewScanner(&out)
+	for scan.Scan() {
+		var f lint.Finding
+		if err := json.Unmarshal(scan.Bytes(), &f); err != nil {
+			t.Fatalf("can't decode finding: %v", err)
+		}
+		findings = append(findings, f)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("want 2 findings, got %+v", findings)
+	}
+	if f := findings[1]; f.Key != "bell\a " || f.Quoted != `"bell\a "` || f.Normalized != "new/bell" {
+		t.Errorf("want the key, quoted and normalized, got %+v", f)
+	}
+}
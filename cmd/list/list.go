@@ -31,15 +31,16 @@
 package list
 
 import (
-	"encoding/json"
 	"expvar"
 	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
 	"github.com/Sirupsen/logrus"
 	"github.com/pushrax/goamz/s3"
 	"io"
 	"math"
 	"net/url"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -72,7 +73,10 @@ var (
 	}("/")
 )
 
-type listTask struct{}
+type listTask struct {
+	// requests made to list the bucket, including retries
+	requests int64
+}
 
 var metrics = struct {
 	workToDo        *expvar.Int
@@ -104,16 +108,21 @@ var metrics = struct {
 
 // List an s3 bucket and write the keys in JSON form to dst.
 func List(sss *s3.S3, bucket, prefix string, dst io.Writer) error {
+	w, _ := listing.NewWriter(dst, listing.JSON)
+	return ListTo(sss, bucket, prefix, w)
+}
+
+// ListTo lists an s3 bucket and writes the keys to a listing writer.
+func ListTo(sss *s3.S3, bucket, prefix string, dst listing.Writer) error {
 	keys := make(chan s3.Key, Concurrency)
 
 	// Start a key encoder, which writes to the file concurrently
 	encDone := make(chan struct{})
-	go func(w io.Writer) {
+	go func(w listing.Writer) {
 		logrus.Info("start encoding keys to destination writer")
 		defer close(encDone)
-		enc := json.NewEncoder(w)
 		for k := range keys {
-			if err := enc.Encode(k); err != nil {
+			if err := w.Write(k); err != nil {
 				logrus.WithFields(logrus.Fields{
 					"key":   k,
 					"error": err,
@@ -121,6 +130,9 @@ func List(sss *s3.S3, bucket, prefix string, dst io.Writer) error {
 				return
 			}
 		}
+		if err := w.Flush(); err != nil {
+			logrus.WithField("error", err).Fatal("couldn't flush keys to destination")
+		}
 		logrus.Info("done encoding keys to dst file")
 	}(dst)
 
@@ -159,6 +171,13 @@ func (l *listTask) listAllKeys(sss *s3.S3, bucket, prefix string, f func(key s3.
 		"duration":            time.Since(start),
 		"bucket_total_size":   size,
 	}).Info("done visiting bucket")
+	if sss.RequesterPays {
+		logrus.WithFields(logrus.Fields{
+			"bucket_source":  bucket,
+			"billed_lists":   atomic.LoadInt64(&l.requests),
+			"requester_pays": true,
+		}).Warn("source bucket is requester pays, its list requests are billed to this account")
+	}
 
 	return err
 }
@@ -179,11 +198,8 @@ func (l *listTask) walkPath(bkt *s3.Bucket, root string, keyVisitor func(key s3.
 	fringe <- firstJob
 
 	// Start the workers, which expands the edges of the fringe
-	wg := sync.WaitGroup{}
-	for i := 0; i < Concurrency; i++ {
-		wg.Add(1)
-		go l.listWorker(&wg, bkt, fringe, result)
-	}
+	var workers pipeline.Workers
+	workers.Start(Concurrency, func(int) { l.listWorker(bkt, fringe, result) })
 
 	for !workSet.IsEmpty() {
 
@@ -262,7 +278,7 @@ func (l *listTask) walkPath(bkt *s3.Bucket, root string, keyVisitor func(key s3.
 	// results
 	close(result)
 	logrus.Info("waiting for workers")
-	wg.Wait()
+	workers.Wait()
 	logrus.Info("workers stopped")
 
 	return nil
@@ -303,8 +319,7 @@ func (l *listTask) jobsFromFollowers(newFollowers []string, workset *jobSet, vis
 
 // list workers receives jobs and LIST the path in those jobs, sleeping between
 // retryable errors before re-enqueing them.
-func (l *listTask) listWorker(wg *sync.WaitGroup, bkt *s3.Bucket, jobs <-chan *Job, out chan<- *Job) {
-	defer wg.Done()
+func (l *listTask) listWorker(bkt *s3.Bucket, jobs <-chan *Job, out chan<- *Job) {
 	for job := range jobs {
 		// track duration
 		start := time.Now()
@@ -312,6 +327,7 @@ func (l *listTask) listWorker(wg *sync.WaitGroup, bkt *s3.Bucket, jobs <-chan *J
 
 		for {
 			metrics.inflight.Add(1)
+			atomic.AddInt64(&l.requests, 1)
 
 			// list this path on the bkt
 			res, err := bkt.List(job.path, "/", marker, MaxList)
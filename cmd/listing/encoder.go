@@ -0,0 +1,219 @@
+package listing
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Encoders of outputs, on top of the formats of listings.
+const (
+	// CSV writes a header, then a line per key, with the error of the
+	// failures.
+	CSV = "csv"
+	// Protobuf writes a KeyRecord message per key, each preceded by its
+	// length as a uvarint, like the delimited streams of the protobuf
+	// libraries:
+	//	message KeyRecord {
+	//	  string key = 1;
+	//	  string last_modified = 2;
+	//	  int64 size = 3;
+	//	  string etag = 4;
+	//	  string storage_class = 5;
+	//	  string error_code = 6;
+	//	  string error = 7;
+	//	  int64 retries = 8;
+	//	  int64 time_unix_nano = 9;
+	//	  string run_id = 10;
+	//	}
+	Protobuf = "protobuf"
+	// Null writes nothing.
+	Null = "null"
+)
+
+// Encoder writes the keys of an output of a task: the keys it synced,
+// deleted, or those that failed with their failure. Encoders are registered
+// by name with RegisterEncoder, and chosen for each output by NewEncoder,
+// so that new sinks don't need the tasks to know about them.
+type Encoder interface {
+	EncodeKey(key s3.Key) error
+	EncodeFailure(f Failure) error
+	// Flush the keys buffered by the encoder.
+	Flush() error
+}
+
+// EncoderFunc creates an encoder writing to w.
+type EncoderFunc func(w io.Writer) (Encoder, error)
+
+var encoders = struct {
+	sync.Mutex
+	byName map[string]EncoderFunc
+}{byName: make(map[string]EncoderFunc)}
+
+func init() {
+	for _, format := range []string{JSON, Binary, MsgPack} {
+		format := format
+		RegisterEncoder(format, func(w io.Writer) (Encoder, error) {
+			fw, err := NewFailureWriter(w, format)
+			if err != nil {
+				return nil, err
+			}
+			return writerEncoder{fw.(failureWriter)}, nil
+		})
+	}
+	RegisterEncoder(CSV, func(w io.Writer) (Encoder, error) { return &csvEncoder{w: csv.NewWriter(w)}, nil })
+	RegisterEncoder(Protobuf, func(w io.Writer) (Encoder, error) { return &protobufEncoder{w: w}, nil })
+	RegisterEncoder(Null, func(io.Writer) (Encoder, error) { return nullEncoder{}, nil })
+}
+
+// RegisterEncoder makes an encoder available by name to NewEncoder. It
+// panics if the name is already taken.
+func RegisterEncoder(name string, newEncoder EncoderFunc) {
+	encoders.Lock()
+	defer encoders.Unlock()
+	if _, ok := encoders.byName[name]; ok {
+		panic(fmt.Sprintf("encoder %q registered twice", name))
+	}
+	encoders.byName[name] = newEncoder
+}
+
+// Encoders are the names of the registered encoders, sorted.
+func Encoders() []string {
+	encoders.Lock()
+	defer encoders.Unlock()
+	var names []string
+	for name := range encoders.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEncoder creates the encoder of an output. When the output is an
+// Encoder itself, such as a queue, it's its own encoder whatever the name.
+// Otherwise, the encoder is the one registered under name, JSON when empty.
+func NewEncoder(w io.Writer, name string) (Encoder, error) {
+	if enc, ok := w.(Encoder); ok {
+		return enc, nil
+	}
+	if name == "" {
+		name = JSON
+	}
+	encoders.Lock()
+	newEncoder, ok := encoders.byName[name]
+	encoders.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown encoder %q, want one of %s", name, strings.Join(Encoders(), ", "))
+	}
+	return newEncoder(w)
+}
+
+// writerEncoder encodes with the writer of a listing format.
+type writerEncoder struct{ w failureWriter }
+
+func (e writerEncoder) EncodeKey(key s3.Key) error    { return e.w.Write(key) }
+func (e writerEncoder) EncodeFailure(f Failure) error { return e.w.WriteFailure(f) }
+func (e writerEncoder) Flush() error                  { return e.w.Flush() }
+
+// csvHeader are the columns of the CSV encoder.
+var csvHeader = []string{"key", "last_modified", "size", "etag", "storage_class", "error_code", "error", "retries", "time", "run_id"}
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) EncodeKey(key s3.Key) error { return e.EncodeFailure(Failure{Key: key}) }
+
+func (e *csvEncoder) EncodeFailure(f Failure) error {
+	if !e.header {
+		e.header = true
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	var retries, when string
+	if f.Error != "" {
+		retries = strconv.Itoa(f.Retries)
+		when = f.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return e.w.Write([]string{
+		f.Key.Key, f.Key.LastModified, strconv.FormatInt(f.Key.Size, 10), f.Key.ETag, f.Key.StorageClass,
+		f.ErrorCode, f.Error, retries, when, f.RunID,
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type protobufEncoder struct {
+	w   io.Writer
+	msg []byte
+	rec []byte
+}
+
+func (e *protobufEncoder) EncodeKey(key s3.Key) error { return e.EncodeFailure(Failure{Key: key}) }
+
+// EncodeFailure writes the message with a single call to the underlying
+// writer, like the binary format, so that messages are never split.
+func (e *protobufEncoder) EncodeFailure(f Failure) error {
+	m := e.msg[:0]
+	m = pbAppendString(m, 1, f.Key.Key)
+	m = pbAppendString(m, 2, f.Key.LastModified)
+	m = pbAppendVarint(m, 3, uint64(f.Key.Size))
+	m = pbAppendString(m, 4, f.Key.ETag)
+	m = pbAppendString(m, 5, f.Key.StorageClass)
+	m = pbAppendString(m, 6, f.ErrorCode)
+	m = pbAppendString(m, 7, f.Error)
+	m = pbAppendVarint(m, 8, uint64(f.Retries))
+	if !f.Time.IsZero() {
+		m = pbAppendVarint(m, 9, uint64(f.Time.UnixNano()))
+	}
+	m = pbAppendString(m, 10, f.RunID)
+	e.msg = m
+	e.rec = append(appendUvarint(e.rec[:0], uint64(len(m))), m...)
+	_, err := e.w.Write(e.rec)
+	return err
+}
+
+func (e *protobufEncoder) Flush() error { return nil }
+
+// wire types of protobuf fields
+const (
+	pbVarint = 0
+	pbBytes  = 2
+)
+
+// pbAppendVarint appends a varint field, unless it's 0, the default value.
+func pbAppendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(field<<3|pbVarint))
+	return appendUvarint(b, v)
+}
+
+// pbAppendString appends a string field, unless it's empty, the default
+// value.
+func pbAppendString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendUvarint(b, uint64(field<<3|pbBytes))
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+type nullEncoder struct{}
+
+func (nullEncoder) EncodeKey(s3.Key) error      { return nil }
+func (nullEncoder) EncodeFailure(Failure) error { return nil }
+func (nullEncoder) Flush() error                { return nil }
@@ -0,0 +1,101 @@
+package listing
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pushrax/goamz/s3"
+	"io"
+)
+
+// Enriched is a key with the metadata of its object, as read by a HEAD on
+// it, so that filters and syncs don't need to read it again. The storage
+// class is the one of the key. In JSON, it's an envelope around the key,
+// like a Failure, that readers of listings unwrap.
+type Enriched struct {
+	Key         s3.Key `json:"key"`
+	ContentType string `json:"content_type,omitempty"`
+	// Metadata is the user metadata of the object, by name, without the
+	// x-amz-meta- prefix of its headers.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// EnrichedWriter writes enriched keys to a listing.
+type EnrichedWriter interface {
+	WriteEnriched(e Enriched) error
+	// Flush the keys buffered by the writer.
+	Flush() error
+}
+
+// NewEnrichedWriter creates a writer of enriched keys in a format. The
+// binary and msgpack formats only have room for keys, their metadata is
+// dropped.
+func NewEnrichedWriter(w io.Writer, format string) (EnrichedWriter, error) {
+	kw, err := NewWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+	if jw, ok := kw.(jsonWriter); ok {
+		return enrichedWriter{Writer: kw, enc: jw.enc}, nil
+	}
+	return enrichedWriter{Writer: kw}, nil
+}
+
+type enrichedWriter struct {
+	Writer
+	// enc of the JSON envelopes, nil for the other formats
+	enc *json.Encoder
+}
+
+func (w enrichedWriter) WriteEnriched(e Enriched) error {
+	if w.enc == nil {
+		return w.Write(e.Key)
+	}
+	return w.enc.Encode(e)
+}
+
+// UnmarshalEnriched decodes the JSON line of an enriched key. Lines of
+// plain keys, or of failures, are enriched keys without metadata.
+func UnmarshalEnriched(line []byte, e *Enriched) error {
+	*e = Enriched{}
+	if !bytes.HasPrefix(bytes.TrimLeft(line, " \t"), envelopePrefix) {
+		return json.Unmarshal(line, &e.Key)
+	}
+	return json.Unmarshal(line, e)
+}
+
+// ReadEnriched reads the next key of a listing with its metadata, or io.EOF
+// at the end. Only JSON listings hold metadata, the keys of the others are
+// read without.
+func ReadEnriched(r Reader, e *Enriched) error {
+	jr, ok := r.(jsonReader)
+	if !ok {
+		*e = Enriched{}
+		return r.Read(&e.Key)
+	}
+	var line json.RawMessage
+	if err := jr.dec.Decode(&line); err != nil {
+		return err
+	}
+	return UnmarshalEnriched(line, e)
+}
+
+// CopyEnriched copies all the keys of a listing to a writer with their
+// metadata, returning how many there were.
+func CopyEnriched(w EnrichedWriter, r Reader) (int64, error) {
+	var n int64
+	var e Enriched
+	for {
+		err := ReadEnriched(r, &e)
+		if err == io.EOF {
+			return n, w.Flush()
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := w.WriteEnriched(e); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
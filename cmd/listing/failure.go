@@ -0,0 +1,76 @@
+package listing
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"time"
+)
+
+// envelopePrefix starts the JSON lines of failures, which are told apart
+// from the ones of keys without decoding them.
+var envelopePrefix = []byte(`{"key":{`)
+
+// Failure is a key that failed to sync, with why it failed. In JSON, it's an
+// envelope around the key, one per line, which JSON readers of listings
+// unwrap: a listing of failures can be synced again like any other.
+type Failure struct {
+	Key       s3.Key    `json:"key"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	Error     string    `json:"error"`
+	Retries   int       `json:"retries"`
+	Time      time.Time `json:"time"`
+	// RunID of the run the key failed in, to tell apart the failures of
+	// runs that share an output or a queue.
+	RunID string `json:"run_id,omitempty"`
+}
+
+// FailureWriter writes failures to a listing.
+type FailureWriter interface {
+	WriteFailure(f Failure) error
+	// Flush the failures buffered by the writer.
+	Flush() error
+}
+
+// NewFailureWriter creates a writer of failures in a format. The binary and
+// msgpack formats only have room for keys, their failures are written as
+// the failed keys alone.
+func NewFailureWriter(w io.Writer, format string) (FailureWriter, error) {
+	kw, err := NewWriter(w, format)
+	if err != nil {
+		return nil, err
+	}
+	if jw, ok := kw.(jsonWriter); ok {
+		return failureWriter{Writer: kw, enc: jw.enc}, nil
+	}
+	return failureWriter{Writer: kw}, nil
+}
+
+type failureWriter struct {
+	Writer
+	// enc of the JSON envelopes, nil for the other formats
+	enc *json.Encoder
+}
+
+func (w failureWriter) WriteFailure(f Failure) error {
+	if w.enc == nil {
+		return w.Write(f.Key)
+	}
+	return w.enc.Encode(f)
+}
+
+// UnmarshalKey decodes the JSON line of a key, or of a Failure or an
+// Enriched key, in which case it's the key of the envelope.
+func UnmarshalKey(line []byte, key *s3.Key) error {
+	*key = s3.Key{}
+	if !bytes.HasPrefix(bytes.TrimLeft(line, " \t"), envelopePrefix) {
+		return json.Unmarshal(line, key)
+	}
+	var f Failure
+	if err := json.Unmarshal(line, &f); err != nil {
+		return err
+	}
+	*key = f.Key
+	return nil
+}
@@ -0,0 +1,310 @@
+package listing_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func perfKeys(t *testing.T) []s3.Key {
+	bkt := s3mock.NewPerfBucket(t)
+	keys := bkt.Keys()
+	sort.Sort(byName(keys))
+	return keys
+}
+
+type byName []s3.Key
+
+func (k byName) Len() int           { return len(k) }
+func (k byName) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k byName) Less(i, j int) bool { return k[i].Key < k[j].Key }
+
+func write(t *testing.T, format string, keys []s3.Key) *bytes.Buffer {
+	var buf bytes.Buffer
+	w, err := listing.NewWriter(&buf, format)
+	if err != nil {
+		t.Fatalf("can't create writer: %v", err)
+	}
+	for _, key := range keys {
+		if err := w.Write(key); err != nil {
+			t.Fatalf("can't write key: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("can't flush keys: %v", err)
+	}
+	return &buf
+}
+
+func read(t *testing.T, r io.Reader) []s3.Key {
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		t.Fatalf("can't create reader: %v", err)
+	}
+	var keys []s3.Key
+	for {
+		var key s3.Key
+		err := rd.Read(&key)
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatalf("can't read key %d: %v", len(keys), err)
+		}
+		keys = append(keys, key)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := append(perfKeys(t),
+		s3.Key{},
+		s3.Key{Key: "odd date", LastModified: "yesterday", ETag: `"not-an-md5"`},
+		s3.Key{Key: "multipart", LastModified: "1969-12-31T23:59:59.999Z", ETag: `"1701ca52640a2c79d4a5eb0b4d3e3d8f-3"`, Size: -1},
+		s3.Key{Key: "upper case etag", ETag: `"1701CA52640A2C79D4A5EB0B4D3E3D8F"`},
+		s3.Key{Key: "unicode ü 日本", StorageClass: "GLACIER", Owner: s3.Owner{ID: "other"}},
+		s3.Key{Key: strings.Repeat("long", 20000), Size: 1 << 40},
+		s3.Key{Key: "small sizes", Size: -33},
+		s3.Key{Key: "int32 size", Size: 1 << 20},
+	)
+	for _, format := range []string{listing.JSON, listing.Binary, listing.MsgPack} {
+		got := read(t, write(t, format, keys))
+		if !reflect.DeepEqual(keys, got) {
+			t.Errorf("%s: keys don't round trip", format)
+			for i := range keys {
+				if i < len(got) && !reflect.DeepEqual(keys[i], got[i]) {
+					t.Errorf("want %+v, got %+v", keys[i], got[i])
+				}
+			}
+		}
+	}
+}
+
+func TestBinaryIsSmaller(t *testing.T) {
+	keys := perfKeys(t)
+	jsonSize := write(t, listing.JSON, keys).Len()
+	binarySize := write(t, listing.Binary, keys).Len()
+	if binarySize*4 > jsonSize {
+		t.Errorf("want binary listing 4 times smaller than JSON, got %d and %d bytes", binarySize, jsonSize)
+	}
+}
+
+func TestCopyAndCorruption(t *testing.T) {
+	keys := perfKeys(t)
+	rd, err := listing.NewReader(write(t, listing.Binary, keys))
+	if err != nil {
+		t.Fatalf("can't create reader: %v", err)
+	}
+	var buf bytes.Buffer
+	w, _ := listing.NewWriter(&buf, listing.JSON)
+	n, err := listing.Copy(w, rd)
+	if err != nil || n != int64(len(keys)) {
+		t.Fatalf("want %d keys copied, got %d: %v", len(keys), n, err)
+	}
+	if got := read(t, &buf); !reflect.DeepEqual(keys, got) {
+		t.Errorf("keys don't survive a conversion")
+	}
+
+	bin := write(t, listing.Binary, keys[:3]).Bytes()
+	truncated := bin[:len(bin)-5]
+	rd, _ = listing.NewReader(bytes.NewReader(truncated))
+	var key s3.Key
+	for err == nil {
+		err = rd.Read(&key)
+	}
+	if err == io.EOF {
+		t.Errorf("want an error reading a truncated listing")
+	}
+}
+
+func TestMsgPackIsSmaller(t *testing.T) {
+	keys := perfKeys(t)
+	jsonSize := write(t, listing.JSON, keys).Len()
+	msgpackSize := write(t, listing.MsgPack, keys).Len()
+	if msgpackSize >= jsonSize {
+		t.Errorf("want msgpack listing smaller than JSON, got %d and %d bytes", msgpackSize, jsonSize)
+	}
+}
+
+func TestReadForeignMsgPack(t *testing.T) {
+	// written by another library: fields in another order, a map16, an
+	// unknown field, a uint16 size and a nil owner
+	b := []byte{0xde, 0x00, 0x04,
+		0xa4, 'S', 'i', 'z', 'e', 0xcd, 0x01, 0x00,
+		0xa5, 'E', 'x', 't', 'r', 'a', 0x92, 0xc3, 0xcb, 0, 0, 0, 0, 0, 0, 0, 0,
+		0xa3, 'K', 'e', 'y', 0xc4, 0x01, 'k',
+		0xa5, 'O', 'w', 'n', 'e', 'r', 0xc0,
+	}
+	got := read(t, bytes.NewReader(b))
+	want := []s3.Key{{Key: "k", Size: 256}}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	rd, _ := listing.NewReader(bytes.NewReader(b[:len(b)-3]))
+	var key s3.Key
+	if err := rd.Read(&key); err == nil || err == io.EOF {
+		t.Errorf("want an error reading a truncated key, got %v", err)
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	for filename, want := range map[string]string{
+		"bucket_list.json.gz":    listing.JSON,
+		"bucket_list.bin.gz":     listing.Binary,
+		"bucket_list.brgl":       listing.Binary,
+		"synced.msgpack.gz":      listing.MsgPack,
+		"dir.json/synced.MPK.GZ": listing.MsgPack,
+		"synced.gz":              "",
+		"noext":                  "",
+	} {
+		if got := listing.FormatOf(filename); got != want {
+			t.Errorf("%s: want %q, got %q", filename, want, got)
+		}
+	}
+}
+
+func TestFailures(t *testing.T) {
+	keys := []s3.Key{
+		{Key: "a/1", Size: 1, ETag: `"0cc175b9c0f1b6a831c399e269772661"`, LastModified: "2014-01-02T03:04:05.000Z"},
+		{Key: `b/"quoted"`, Size: 2},
+	}
+	for _, format := range []string{listing.JSON, listing.Binary, listing.MsgPack} {
+		var buf bytes.Buffer
+		w, err := listing.NewFailureWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: can't create writer: %v", format, err)
+		}
+		for _, key := range keys {
+			f := listing.Failure{Key: key, ErrorCode: "AccessDenied", Error: "Access Denied", Retries: 3, Time: time.Now()}
+			if err := w.WriteFailure(f); err != nil {
+				t.Fatalf("%s: can't write failure: %v", format, err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("%s: can't flush: %v", format, err)
+		}
+		if format == listing.JSON && !strings.Contains(buf.String(), `"error_code":"AccessDenied"`) {
+			t.Errorf("want the error in the JSON failures, got %s", buf.String())
+		}
+
+		// failures are read as their keys
+		rd, err := listing.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("%s: can't create reader: %v", format, err)
+		}
+		var got []s3.Key
+		for {
+			var key s3.Key
+			if err := rd.Read(&key); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: can't read key: %v", format, err)
+			}
+			got = append(got, key)
+		}
+		if !reflect.DeepEqual(got, keys) {
+			t.Errorf("%s: want keys %v, got %v", format, keys, got)
+		}
+	}
+
+	var key s3.Key
+	if err := listing.UnmarshalKey([]byte(`{"Key":"plain","Size":4}`), &key); err != nil || key.Key != "plain" || key.Size != 4 {
+		t.Errorf("want a plain key decoded, got %+v, %v", key, err)
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	key := s3.Key{Key: "a,1", Size: 300, ETag: `"0cc175b9c0f1b6a831c399e269772661"`}
+	f := listing.Failure{Key: s3.Key{Key: "b"}, ErrorCode: "AccessDenied", Error: "Access Denied", Retries: 2, Time: time.Now(), RunID: "run-1"}
+	encode := func(name string) string {
+		var buf bytes.Buffer
+		enc, err := listing.NewEncoder(&buf, name)
+		if err != nil {
+			t.Fatalf("%s: can't create encoder: %v", name, err)
+		}
+		if err := enc.EncodeKey(key); err != nil {
+			t.Fatalf("%s: can't encode key: %v", name, err)
+		}
+		if err := enc.EncodeFailure(f); err != nil {
+			t.Fatalf("%s: can't encode failure: %v", name, err)
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatalf("%s: can't flush: %v", name, err)
+		}
+		return buf.String()
+	}
+
+	for _, name := range []string{listing.JSON, listing.Binary, listing.MsgPack, listing.CSV, listing.Protobuf, listing.Null} {
+		found := false
+		for _, registered := range listing.Encoders() {
+			found = found || registered == name
+		}
+		if !found {
+			t.Errorf("want encoder %q registered, got %v", name, listing.Encoders())
+		}
+	}
+	if got := encode(""); got != encode(listing.JSON) || !strings.Contains(got, `"error_code":"AccessDenied"`) {
+		t.Errorf("want JSON lines by default, got %s", got)
+	}
+	want := "key,last_modified,size,etag,storage_class,error_code,error,retries,time,run_id\n" +
+		`"a,1",,300,"""0cc175b9c0f1b6a831c399e269772661""",,,,,,` + "\n" +
+		"b,,0,,,AccessDenied,Access Denied,2," + f.Time.UTC().Format(time.RFC3339Nano) + ",run-1\n"
+	if got := encode(listing.CSV); got != want {
+		t.Errorf("want CSV\n%s\ngot\n%s", want, got)
+	}
+	// the key is field 1, a string, and the size field 3, a varint
+	pb := encode(listing.Protobuf)
+	if !strings.HasPrefix(pb, "\x2c\x0a\x03a,1\x18\xac\x02") {
+		t.Errorf("want a delimited KeyRecord, got %q", pb)
+	}
+	if got := encode(listing.Null); got != "" {
+		t.Errorf("want nothing written by the null encoder, got %q", got)
+	}
+	if _, err := listing.NewEncoder(nil, "xml"); err == nil || !strings.Contains(err.Error(), "protobuf") {
+		t.Errorf("want an unknown encoder listing the known ones, got %v", err)
+	}
+}
+
+func TestEnriched(t *testing.T) {
+	e := listing.Enriched{
+		Key:         s3.Key{Key: "a/1", Size: 1, StorageClass: "STANDARD"},
+		ContentType: "image/png",
+		Metadata:    map[string]string{"owner": "storage"},
+		Tags:        map[string]string{"env": "prod"},
+	}
+	for _, format := range []string{listing.JSON, listing.Binary, listing.MsgPack} {
+		var buf bytes.Buffer
+		w, err := listing.NewEnrichedWriter(&buf, format)
+		if err != nil {
+			t.Fatalf("%s: can't create writer: %v", format, err)
+		}
+		if err := w.WriteEnriched(e); err != nil {
+			t.Fatalf("%s: can't write enriched key: %v", format, err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("%s: can't flush: %v", format, err)
+		}
+		if format == listing.JSON {
+			var got listing.Enriched
+			if err := listing.UnmarshalEnriched(buf.Bytes(), &got); err != nil || !reflect.DeepEqual(got, e) {
+				t.Errorf("want %+v decoded, got %+v, %v", e, got, err)
+			}
+		}
+		// enriched keys are read as their keys
+		if got := read(t, &buf); !reflect.DeepEqual(got, []s3.Key{e.Key}) {
+			t.Errorf("%s: want key %v, got %v", format, e.Key, got)
+		}
+	}
+
+	var got listing.Enriched
+	if err := listing.UnmarshalEnriched([]byte(`{"Key":"plain","Size":4}`), &got); err != nil || got.Key.Key != "plain" || got.Metadata != nil {
+		t.Errorf("want a plain key decoded without metadata, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,364 @@
+package listing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"math"
+)
+
+// In the msgpack format, each key is a map with the same fields as in the
+// JSON format, so that msgpack listings can be read by any msgpack library.
+
+// msgpack type markers.
+const (
+	mpFixMapMask = 0x80
+	mpFixStrMask = 0xa0
+	mpNil        = 0xc0
+	mpFalse      = 0xc2
+	mpTrue       = 0xc3
+	mpBin8       = 0xc4
+	mpBin16      = 0xc5
+	mpBin32      = 0xc6
+	mpFloat32    = 0xca
+	mpFloat64    = 0xcb
+	mpUint8      = 0xcc
+	mpUint16     = 0xcd
+	mpUint32     = 0xce
+	mpUint64     = 0xcf
+	mpInt8       = 0xd0
+	mpInt16      = 0xd1
+	mpInt32      = 0xd2
+	mpInt64      = 0xd3
+	mpStr8       = 0xd9
+	mpStr16      = 0xda
+	mpStr32      = 0xdb
+	mpArray16    = 0xdc
+	mpArray32    = 0xdd
+	mpMap16      = 0xde
+	mpMap32      = 0xdf
+)
+
+// isMsgpackMap is true for the first byte of a msgpack map.
+func isMsgpackMap(b byte) bool {
+	return b&0xf0 == mpFixMapMask || b == mpMap16 || b == mpMap32
+}
+
+type msgpackWriter struct {
+	w   io.Writer
+	rec []byte
+}
+
+// Write a key with a single call to the underlying writer, so that keys are
+// never split if the listing is cut short.
+func (w *msgpackWriter) Write(key s3.Key) error {
+	b := append(w.rec[:0], mpFixMapMask|6)
+	b = mpAppendStr(b, "Key")
+	b = mpAppendStr(b, key.Key)
+	b = mpAppendStr(b, "LastModified")
+	b = mpAppendStr(b, key.LastModified)
+	b = mpAppendStr(b, "Size")
+	b = mpAppendInt(b, key.Size)
+	b = mpAppendStr(b, "ETag")
+	b = mpAppendStr(b, key.ETag)
+	b = mpAppendStr(b, "StorageClass")
+	b = mpAppendStr(b, key.StorageClass)
+	b = mpAppendStr(b, "Owner")
+	b = append(b, mpFixMapMask|2)
+	b = mpAppendStr(b, "ID")
+	b = mpAppendStr(b, key.Owner.ID)
+	b = mpAppendStr(b, "DisplayName")
+	b = mpAppendStr(b, key.Owner.DisplayName)
+	w.rec = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+func (w *msgpackWriter) Flush() error { return nil }
+
+func mpAppendStr(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, mpFixStrMask|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, mpStr8, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, mpStr16, byte(n>>8), byte(n))
+	default:
+		b = append(b, mpStr32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
+
+func mpAppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v < 128:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return append(b, mpInt32, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+	b = append(b, mpInt64)
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(v))
+	return append(b, buf[:]...)
+}
+
+var errMsgpackType = errors.New("unexpected msgpack type in listing")
+
+type msgpackReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (r *msgpackReader) Read(key *s3.Key) error {
+	*key = s3.Key{}
+	c, err := r.r.ReadByte()
+	if err != nil {
+		// a listing can only end between two keys
+		return err
+	}
+	if err := r.r.UnreadByte(); err != nil {
+		return err
+	}
+	if !isMsgpackMap(c) {
+		return fmt.Errorf("msgpack listing: want a map, got type 0x%02x", c)
+	}
+	err = r.readMap(func(field string) error {
+		var err error
+		switch field {
+		case "Key":
+			key.Key, err = r.readStr()
+		case "LastModified":
+			key.LastModified, err = r.readStr()
+		case "Size":
+			key.Size, err = r.readInt()
+		case "ETag":
+			key.ETag, err = r.readStr()
+		case "StorageClass":
+			key.StorageClass, err = r.readStr()
+		case "Owner":
+			err = r.readMap(func(field string) error {
+				var err error
+				switch field {
+				case "ID":
+					key.Owner.ID, err = r.readStr()
+				case "DisplayName":
+					key.Owner.DisplayName, err = r.readStr()
+				default:
+					err = r.skip()
+				}
+				return err
+			})
+		default:
+			err = r.skip()
+		}
+		return err
+	})
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("msgpack listing: %v", err)
+	}
+	return nil
+}
+
+// readMap calls field to read the value of each field of a map. A nil is
+// an empty map.
+func (r *msgpackReader) readMap(field func(name string) error) error {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	var n uint32
+	switch {
+	case c == mpNil:
+		return nil
+	case c&0xf0 == mpFixMapMask:
+		n = uint32(c & 0x0f)
+	case c == mpMap16:
+		n, err = r.readUint(2)
+	case c == mpMap32:
+		n, err = r.readUint(4)
+	default:
+		return errMsgpackType
+	}
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		name, err := r.readStr()
+		if err != nil {
+			return err
+		}
+		if err := field(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readStr reads a string or binary value, a nil is an empty string.
+func (r *msgpackReader) readStr() (string, error) {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n uint32
+	switch {
+	case c == mpNil:
+		return "", nil
+	case c&0xe0 == mpFixStrMask:
+		n = uint32(c & 0x1f)
+	case c == mpStr8 || c == mpBin8:
+		n, err = r.readUint(1)
+	case c == mpStr16 || c == mpBin16:
+		n, err = r.readUint(2)
+	case c == mpStr32 || c == mpBin32:
+		n, err = r.readUint(4)
+	default:
+		return "", errMsgpackType
+	}
+	if err != nil {
+		return "", err
+	}
+	if uint32(cap(r.buf)) < n {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		return "", err
+	}
+	return string(r.buf), nil
+}
+
+// readInt reads an integer of any size, a nil is 0.
+func (r *msgpackReader) readInt() (int64, error) {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c == mpNil:
+		return 0, nil
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	}
+	var size int
+	switch c {
+	case mpUint8, mpInt8:
+		size = 1
+	case mpUint16, mpInt16:
+		size = 2
+	case mpUint32, mpInt32:
+		size = 4
+	case mpUint64, mpInt64:
+		size = 8
+	default:
+		return 0, errMsgpackType
+	}
+	var buf [8]byte
+	if _, err := io.ReadFull(r.r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	v := binary.BigEndian.Uint64(buf[:])
+	switch c {
+	case mpInt8:
+		return int64(int8(v)), nil
+	case mpInt16:
+		return int64(int16(v)), nil
+	case mpInt32:
+		return int64(int32(v)), nil
+	case mpUint64:
+		if v > math.MaxInt64 {
+			return 0, errMsgpackType
+		}
+	}
+	return int64(v), nil
+}
+
+func (r *msgpackReader) readUint(size int) (uint32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r.r, buf[4-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
+
+// skip the next value, whatever its type.
+func (r *msgpackReader) skip() error {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	var n uint32
+	switch {
+	case c < 0x80 || c >= 0xe0 || c == mpNil || c == mpFalse || c == mpTrue:
+		return nil
+	case c&0xf0 == mpFixMapMask:
+		return r.skipN(2 * uint32(c&0x0f))
+	case c&0xf0 == 0x90: // fixarray
+		return r.skipN(uint32(c & 0x0f))
+	case c&0xe0 == mpFixStrMask:
+		return r.discard(uint32(c & 0x1f))
+	}
+	switch c {
+	case mpUint8, mpInt8:
+		return r.discard(1)
+	case mpUint16, mpInt16:
+		return r.discard(2)
+	case mpUint32, mpInt32, mpFloat32:
+		return r.discard(4)
+	case mpUint64, mpInt64, mpFloat64:
+		return r.discard(8)
+	case mpStr8, mpBin8:
+		n, err = r.readUint(1)
+	case mpStr16, mpBin16:
+		n, err = r.readUint(2)
+	case mpStr32, mpBin32:
+		n, err = r.readUint(4)
+	case mpArray16, mpMap16:
+		if n, err = r.readUint(2); err == nil {
+			if c == mpMap16 {
+				n *= 2
+			}
+			return r.skipN(n)
+		}
+		return err
+	case mpArray32, mpMap32:
+		if n, err = r.readUint(4); err == nil {
+			if c == mpMap32 {
+				n *= 2
+			}
+			return r.skipN(n)
+		}
+		return err
+	default:
+		return errMsgpackType
+	}
+	if err != nil {
+		return err
+	}
+	return r.discard(n)
+}
+
+func (r *msgpackReader) skipN(n uint32) error {
+	for i := uint32(0); i < n; i++ {
+		if err := r.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *msgpackReader) discard(n uint32) error {
+	_, err := r.r.Discard(int(n))
+	return err
+}
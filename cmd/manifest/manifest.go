@@ -0,0 +1,168 @@
+// Package manifest records how a run of brigade was configured, down to the
+// checksums of its inputs, and how it ended, so that a run can be repeated
+// identically long after, and a repeat can tell whether it is.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// The status of a run.
+const (
+	Running   = "running"
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+// Input of a run, such as a listing, and the checksum of its content.
+type Input struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Checksum reads an input until EOF to checksum it.
+func Checksum(name string, r io.Reader) (Input, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return Input{}, fmt.Errorf("checksumming %q: %v", name, err)
+	}
+	return Input{Name: name, Size: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Manifest of a run. Version and Commit tie the run to the build of brigade
+// that ran it. Flags has the value of every flag of the command, the
+// defaults included, so that a later version with other defaults can still
+// repeat the run. Results are whatever the command reports once done, such
+// as the summary of each of its tasks.
+type Manifest struct {
+	// RunID tells the run apart from the others, and isn't compared by
+	// Diff.
+	RunID     string                 `json:"run_id,omitempty"`
+	Command   string                 `json:"command"`
+	Version   string                 `json:"version"`
+	Commit    string                 `json:"commit,omitempty"`
+	GoVersion string                 `json:"go_version,omitempty"`
+	Args      []string               `json:"args"`
+	Flags     map[string]string      `json:"flags"`
+	Inputs    []Input                `json:"inputs"`
+	Host      string                 `json:"host"`
+	Started   time.Time              `json:"started"`
+	Finished  *time.Time             `json:"finished,omitempty"`
+	Status    string                 `json:"status"`
+	Error     string                 `json:"error,omitempty"`
+	Results   map[string]interface{} `json:"results,omitempty"`
+}
+
+// Finish the run, failed if err is set.
+func (m *Manifest) Finish(now time.Time, err error) {
+	m.Finished = &now
+	m.Status = Succeeded
+	if err != nil {
+		m.Status = Failed
+		m.Error = err.Error()
+	}
+}
+
+// Diff tells how a run differs from an earlier one, in its command, its
+// version and commit, its flags other than the ignored ones, and the content of its
+// inputs. It's empty if the run repeats the earlier one.
+func Diff(prev, cur *Manifest, ignore ...string) []string {
+	var diffs []string
+	if prev.Command != cur.Command {
+		diffs = append(diffs, fmt.Sprintf("command: %q, was %q", cur.Command, prev.Command))
+	}
+	if prev.Version != cur.Version {
+		diffs = append(diffs, fmt.Sprintf("version: %q, was %q", cur.Version, prev.Version))
+	}
+	if prev.Commit != cur.Commit {
+		diffs = append(diffs, fmt.Sprintf("commit: %q, was %q", cur.Commit, prev.Commit))
+	}
+
+	ignored := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		ignored[name] = true
+	}
+	names := make(map[string]bool)
+	for name := range prev.Flags {
+		names[name] = true
+	}
+	for name := range cur.Flags {
+		names[name] = true
+	}
+	var sorted []string
+	for name := range names {
+		if !ignored[name] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		was, wasSet := prev.Flags[name]
+		is, isSet := cur.Flags[name]
+		switch {
+		case !wasSet:
+			diffs = append(diffs, fmt.Sprintf("flag -%s: %q, didn't exist", name, is))
+		case !isSet:
+			diffs = append(diffs, fmt.Sprintf("flag -%s: doesn't exist, was %q", name, was))
+		case was != is:
+			diffs = append(diffs, fmt.Sprintf("flag -%s: %q, was %q", name, is, was))
+		}
+	}
+
+	if len(prev.Inputs) != len(cur.Inputs) {
+		return append(diffs, fmt.Sprintf("inputs: %d, were %d", len(cur.Inputs), len(prev.Inputs)))
+	}
+	for i, in := range cur.Inputs {
+		if was := prev.Inputs[i]; in.SHA256 != was.SHA256 || in.Size != was.Size {
+			diffs = append(diffs, fmt.Sprintf("input %q: %d bytes with sha256 %s, was %q of %d bytes with sha256 %s",
+				in.Name, in.Size, in.SHA256, was.Name, was.Size, was.SHA256))
+		}
+	}
+	return diffs
+}
+
+// Read a manifest.
+func Read(filename string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Write a manifest to filename, atomically replacing the previous one.
+func Write(filename string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,89 @@
+package manifest_test
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/manifest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatalf("can't create dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "run.json")
+
+	in, err := manifest.Checksum("list.json.gz", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("can't checksum: %v", err)
+	}
+	if in.Size != 5 || in.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("want the size and sha256 of the input, got %+v", in)
+	}
+
+	started := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := &manifest.Manifest{
+		Command: "sync",
+		Version: "v1.2.0",
+		Commit:  "abc123",
+		Args:    []string{"brigade", "sync", "-src", "s3://src/"},
+		Flags:   map[string]string{"src": "s3://src/", "concurrency": "200", "manifest": filename},
+		Inputs:  []manifest.Input{in},
+		Started: started,
+		Status:  manifest.Running,
+	}
+	if err := manifest.Write(filename, m); err != nil {
+		t.Fatalf("can't write manifest: %v", err)
+	}
+	m.Finish(started.Add(time.Hour), errors.New("too many keys failed to sync"))
+	m.Results = map[string]interface{}{"dst": map[string]interface{}{"synced": 10.0}}
+	if err := manifest.Write(filename, m); err != nil {
+		t.Fatalf("can't rewrite manifest: %v", err)
+	}
+	got, err := manifest.Read(filename)
+	if err != nil {
+		t.Fatalf("can't read manifest: %v", err)
+	}
+	if !reflect.DeepEqual(m, got) {
+		t.Errorf("want manifest\n%+v\ngot\n%+v", m, got)
+	}
+	if got.Status != manifest.Failed || got.Error != "too many keys failed to sync" {
+		t.Errorf("want the run failed, got %q: %q", got.Status, got.Error)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("want no temp file left behind, got %d files", len(files))
+	}
+
+	// a repeat with the same flags and inputs, but another manifest file
+	repeat := *m
+	repeat.Flags = map[string]string{"src": "s3://src/", "concurrency": "200", "manifest": "repeat.json"}
+	if diffs := manifest.Diff(m, &repeat, "manifest"); len(diffs) != 0 {
+		t.Errorf("want no difference, got %q", diffs)
+	}
+	repeat.Flags = map[string]string{"src": "s3://src/", "concurrency": "100", "delta": "true"}
+	changed, _ := manifest.Checksum("list.json.gz", strings.NewReader("hello!"))
+	repeat.Inputs = []manifest.Input{changed}
+	repeat.Commit = "def456"
+	want := []string{
+		`commit: "def456", was "abc123"`,
+		`flag -concurrency: "100", was "200"`,
+		`flag -delta: "true", didn't exist`,
+		`input "list.json.gz": 6 bytes`,
+	}
+	diffs := manifest.Diff(m, &repeat, "manifest")
+	if len(diffs) != len(want) {
+		t.Fatalf("want %d differences, got %q", len(want), diffs)
+	}
+	for i, d := range diffs {
+		if !strings.HasPrefix(d, want[i]) {
+			t.Errorf("want difference %q, got %q", want[i], d)
+		}
+	}
+}
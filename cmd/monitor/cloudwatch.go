@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/aws"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cloudWatchAPIVersion = "2010-08-01"
+	// maxDatumPerPut is the most metrics a PutMetricData call accepts.
+	maxDatumPerPut = 20
+)
+
+// The units of CloudWatch metrics used by brigade.
+const (
+	Count        = "Count"
+	Bytes        = "Bytes"
+	Milliseconds = "Milliseconds"
+)
+
+// Datum is the value of a metric at some point in time.
+type Datum struct {
+	Name  string
+	Value float64
+	Unit  string
+}
+
+// CloudWatch puts custom metrics in a CloudWatch namespace, using the query
+// API.
+type CloudWatch struct {
+	// Dimensions added to all the metrics, for instance to tell apart the
+	// metrics of many sync jobs.
+	Dimensions map[string]string
+
+	namespace string
+	url       string
+	signer    *aws.V4Signer
+	client    *http.Client
+}
+
+// NewCloudWatch creates a client that puts metrics in the namespace, in the
+// given region.
+func NewCloudWatch(auth aws.Auth, region aws.Region, namespace string) *CloudWatch {
+	return &CloudWatch{
+		namespace: namespace,
+		url:       region.CloudWatchServicepoint.Endpoint,
+		signer:    aws.NewV4Signer(auth, "monitoring", region),
+		client:    &http.Client{Timeout: time.Minute},
+	}
+}
+
+// CloudWatchError is an error returned by CloudWatch.
+type CloudWatchError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *CloudWatchError) Error() string {
+	return fmt.Sprintf("cloudwatch: %s (%s, status %d)", e.Message, e.Code, e.StatusCode)
+}
+
+// Put the data, all with the timestamp ts.
+func (c *CloudWatch) Put(ts time.Time, data []Datum) error {
+	for len(data) != 0 {
+		n := len(data)
+		if n > maxDatumPerPut {
+			n = maxDatumPerPut
+		}
+		if err := c.put(ts, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (c *CloudWatch) put(ts time.Time, data []Datum) error {
+	dims := make([]string, 0, len(c.Dimensions))
+	for name := range c.Dimensions {
+		dims = append(dims, name)
+	}
+	sort.Strings(dims)
+
+	params := url.Values{
+		"Action":    {"PutMetricData"},
+		"Version":   {cloudWatchAPIVersion},
+		"Namespace": {c.namespace},
+	}
+	for i, d := range data {
+		prefix := "MetricData.member." + strconv.Itoa(i+1) + "."
+		params.Set(prefix+"MetricName", d.Name)
+		params.Set(prefix+"Value", strconv.FormatFloat(d.Value, 'f', -1, 64))
+		params.Set(prefix+"Unit", d.Unit)
+		params.Set(prefix+"Timestamp", ts.UTC().Format(time.RFC3339))
+		for j, name := range dims {
+			dimPrefix := prefix + "Dimensions.member." + strconv.Itoa(j+1) + "."
+			params.Set(dimPrefix+"Name", name)
+			params.Set(dimPrefix+"Value", c.Dimensions[name])
+		}
+	}
+
+	req, err := http.NewRequest("POST", c.url, strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("preparing cloudwatch request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.signer.Sign(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		_ = xml.NewDecoder(resp.Body).Decode(&errResp)
+		return &CloudWatchError{
+			StatusCode: resp.StatusCode,
+			Code:       errResp.Code,
+			Message:    errResp.Message,
+		}
+	}
+	return nil
+}
+
+// Publish the data returned by collect to CloudWatch every interval, until
+// stop is closed. The data is collected one last time when stop is closed.
+// Errors are logged and don't stop the publishing.
+func Publish(c *CloudWatch, interval time.Duration, collect func() []Datum, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var ts time.Time
+		select {
+		case ts = <-ticker.C:
+		case <-stop:
+			ts = time.Now()
+		}
+		if err := c.Put(ts, collect()); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":     err,
+				"namespace": c.namespace,
+			}).Error("failed to publish metrics to cloudwatch")
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
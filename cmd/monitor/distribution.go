@@ -0,0 +1,229 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Distribution aggregates durations to answer their quantiles. Durations
+// can be recorded concurrently.
+type Distribution interface {
+	// Record a duration.
+	Record(d time.Duration)
+	// RecordCensored records a duration that is only a lower bound, see
+	// Histogram.RecordCensored.
+	RecordCensored(d time.Duration)
+	// Count of durations recorded.
+	Count() int64
+	// Censored is the count of durations recorded that are lower bounds.
+	Censored() int64
+	// Max duration recorded.
+	Max() time.Duration
+	// Quantile returns the duration under which a fraction q of the
+	// recorded durations fall. It's zero if nothing was recorded.
+	Quantile(q float64) time.Duration
+	// Summarize the distribution.
+	Summarize() Summary
+	// Buckets of the distribution that are not empty, in increasing order.
+	Buckets() []Bucket
+}
+
+// The backends of distributions.
+const (
+	// HDR is the default backend, a Histogram, whose quantiles are within
+	// 1/32 of the actual ones, at any quantile, in a fixed size.
+	HDR = "hdr"
+	// Digest is a TDigest, whose quantiles are the most accurate at the
+	// tails, especially for durations that spread over few buckets of a
+	// Histogram, in a size that depends on its compression.
+	Digest = "tdigest"
+)
+
+// DigestCompression is the compression of the digests of the Digest backend.
+const DigestCompression = 100
+
+// NewDistribution returns the func creating the distributions of a backend.
+func NewDistribution(backend string) (func() Distribution, error) {
+	switch backend {
+	case HDR:
+		return func() Distribution { return NewHistogram() }, nil
+	case Digest:
+		return func() Distribution { return NewTDigest(DigestCompression) }, nil
+	}
+	return nil, fmt.Errorf("unknown distribution backend %q, want %s or %s", backend, HDR, Digest)
+}
+
+func summarize(d Distribution) Summary {
+	return Summary{
+		Count:    d.Count(),
+		Censored: d.Censored(),
+		P50:      d.Quantile(0.50),
+		P95:      d.Quantile(0.95),
+		P99:      d.Quantile(0.99),
+		P999:     d.Quantile(0.999),
+		Max:      d.Max(),
+	}
+}
+
+// TDigest is a merging t-digest: durations are clustered in centroids that
+// are the smallest near the extreme quantiles, which are then the most
+// accurate. The number of centroids is in the order of the compression.
+type TDigest struct {
+	compression float64
+
+	mu        sync.Mutex
+	centroids []centroid
+	buffer    []centroid
+	count     int64
+	censored  int64
+	min, max  time.Duration
+}
+
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+// NewTDigest creates an empty digest. 100 is a common compression.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{compression: compression}
+}
+
+// Record a duration.
+func (t *TDigest) Record(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(d)
+}
+
+func (t *TDigest) record(d time.Duration) {
+	if t.count == 0 || d < t.min {
+		t.min = d
+	}
+	if d > t.max {
+		t.max = d
+	}
+	t.count++
+	t.buffer = append(t.buffer, centroid{mean: float64(d), count: 1})
+	if len(t.buffer) >= 5*int(t.compression) {
+		t.merge()
+	}
+}
+
+// RecordCensored records a duration that is a lower bound, see
+// Histogram.RecordCensored.
+func (t *TDigest) RecordCensored(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.record(d)
+	t.censored++
+}
+
+// merge the buffered durations into the centroids.
+func (t *TDigest) merge() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.buffer, t.centroids...)
+	sort.Sort(byMean(all))
+	total := float64(t.count)
+
+	merged := all[:1]
+	var before float64
+	for _, c := range all[1:] {
+		cur := &merged[len(merged)-1]
+		// centroids can hold more durations the further they are from
+		// the tails
+		q := (before + (cur.count+c.count)/2) / total
+		if cur.count+c.count <= 4*total*q*(1-q)/t.compression {
+			cur.mean += (c.mean - cur.mean) * c.count / (cur.count + c.count)
+			cur.count += c.count
+			continue
+		}
+		before += cur.count
+		merged = append(merged, c)
+	}
+	t.centroids = append([]centroid(nil), merged...)
+	t.buffer = t.buffer[:0]
+}
+
+// Count of durations recorded.
+func (t *TDigest) Count() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// Censored is the count of durations recorded that are lower bounds.
+func (t *TDigest) Censored() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.censored
+}
+
+// Max duration recorded.
+func (t *TDigest) Max() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.max
+}
+
+// Quantile returns the duration under which a fraction q of the recorded
+// durations fall, interpolated between the centroids around it. It's zero
+// if nothing was recorded.
+func (t *TDigest) Quantile(q float64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.merge()
+	if t.count == 0 {
+		return 0
+	}
+	rank := q * float64(t.count)
+	// each centroid is centered on the middle of its durations, the
+	// smallest and largest durations bound the first and last ones
+	prevRank, prevMean := 0.0, float64(t.min)
+	var seen float64
+	for _, c := range t.centroids {
+		center := seen + c.count/2
+		if rank < center {
+			return time.Duration(interpolate(rank, prevRank, center, prevMean, c.mean))
+		}
+		prevRank, prevMean = center, c.mean
+		seen += c.count
+	}
+	return time.Duration(interpolate(rank, prevRank, seen, prevMean, float64(t.max)))
+}
+
+type byMean []centroid
+
+func (c byMean) Len() int           { return len(c) }
+func (c byMean) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byMean) Less(i, j int) bool { return c[i].mean < c[j].mean }
+
+func interpolate(x, x0, x1, y0, y1 float64) float64 {
+	if x1 <= x0 {
+		return y1
+	}
+	if x > x1 {
+		x = x1
+	}
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
+
+// Summarize the digest.
+func (t *TDigest) Summarize() Summary { return summarize(t) }
+
+// Buckets of the digest: its centroids, each counting its durations up to
+// its mean, which is an approximation of where they actually fall.
+func (t *TDigest) Buckets() []Bucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.merge()
+	buckets := make([]Bucket, 0, len(t.centroids))
+	for _, c := range t.centroids {
+		buckets = append(buckets, Bucket{UpTo: time.Duration(c.mean), Count: int64(c.count)})
+	}
+	return buckets
+}
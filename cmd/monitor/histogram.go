@@ -0,0 +1,252 @@
+// Package monitor tracks latencies and publishes the metrics of brigade to
+// external monitoring systems.
+package monitor
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// values under linearMax are counted in their own bucket, values above
+	// are counted in buckets that are subBuckets-per-power-of-two wide,
+	// which keeps the error of a quantile under 1/subBuckets.
+	subBucketBits = 5
+	subBuckets    = 1 << subBucketBits
+	linearMax     = 2 * subBuckets
+	bucketCount   = linearMax + (64-subBucketBits-1)*subBuckets
+)
+
+// Histogram counts durations, with microsecond resolution, in log-linear
+// buckets. Durations can be recorded concurrently.
+type Histogram struct {
+	counts   [bucketCount]int64
+	total    int64
+	max      int64
+	censored int64
+
+	// registry of the histogram, if any
+	reg *Registry
+}
+
+// NewHistogram creates an empty histogram.
+func NewHistogram() *Histogram { return &Histogram{} }
+
+// Record a duration.
+func (h *Histogram) Record(d time.Duration) {
+	defer h.reg.update()()
+	h.record(d)
+}
+
+func (h *Histogram) record(d time.Duration) {
+	us := int64(d / time.Microsecond)
+	if us < 0 {
+		us = 0
+	}
+	atomic.AddInt64(&h.counts[bucketOf(uint64(us))], 1)
+	atomic.AddInt64(&h.total, 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if us <= max || atomic.CompareAndSwapInt64(&h.max, max, us) {
+			return
+		}
+	}
+}
+
+// RecordCensored records a duration that is only a lower bound of the
+// actual one, such as the time after which an operation was given up on. It
+// is counted like any other, so that quantiles don't ignore the slowest
+// operations, and also counted as censored.
+func (h *Histogram) RecordCensored(d time.Duration) {
+	defer h.reg.update()()
+	h.record(d)
+	atomic.AddInt64(&h.censored, 1)
+}
+
+// Censored is the count of durations recorded that are lower bounds.
+func (h *Histogram) Censored() int64 { return atomic.LoadInt64(&h.censored) }
+
+// Count of durations recorded.
+func (h *Histogram) Count() int64 { return atomic.LoadInt64(&h.total) }
+
+// Max duration recorded.
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max)) * time.Microsecond
+}
+
+// Quantile returns the duration under which a fraction q of the recorded
+// durations fall. It's zero if nothing was recorded.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i := range h.counts {
+		seen += atomic.LoadInt64(&h.counts[i])
+		if seen >= rank {
+			// the upper bound of the bucket can't be more than what was
+			// actually recorded
+			if d := time.Duration(upperBound(i)) * time.Microsecond; d < h.Max() {
+				return d
+			}
+			break
+		}
+	}
+	return h.Max()
+}
+
+// Bucket of a histogram, counting the durations up to and including UpTo
+// that were not counted in the previous bucket.
+type Bucket struct {
+	UpTo  time.Duration `json:"up_to_ns"`
+	Count int64         `json:"count"`
+}
+
+// Buckets of the histogram that are not empty, in increasing order.
+func (h *Histogram) Buckets() []Bucket {
+	var buckets []Bucket
+	for i := range h.counts {
+		if n := atomic.LoadInt64(&h.counts[i]); n != 0 {
+			buckets = append(buckets, Bucket{
+				UpTo:  time.Duration(upperBound(i)) * time.Microsecond,
+				Count: n,
+			})
+		}
+	}
+	return buckets
+}
+
+// Summary of the quantiles of a histogram.
+type Summary struct {
+	Count    int64
+	Censored int64
+	P50      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	P999     time.Duration
+	Max      time.Duration
+}
+
+// Summarize the histogram.
+func (h *Histogram) Summarize() Summary { return summarize(h) }
+
+// Millis returns the quantiles of the summary in milliseconds, keyed by
+// name, which is friendlier to consumers of JSON than nanoseconds.
+func (s Summary) Millis() map[string]float64 {
+	ms := func(d time.Duration) float64 { return d.Seconds() * 1000 }
+	return map[string]float64{
+		"count":    float64(s.Count),
+		"censored": float64(s.Censored),
+		"p50_ms":   ms(s.P50),
+		"p95_ms":   ms(s.P95),
+		"p99_ms":   ms(s.P99),
+		"p999_ms":  ms(s.P999),
+		"max_ms":   ms(s.Max),
+	}
+}
+
+// Recorder records durations in a distribution covering all the durations
+// ever recorded, and in distributions covering fixed intervals of time.
+type Recorder struct {
+	overall  Distribution
+	interval time.Duration
+	newDist  func() Distribution
+
+	mu      sync.Mutex
+	start   time.Time
+	current Distribution
+	last    Distribution
+}
+
+// NewRecorder creates a recorder with intervals of the given length, whose
+// distributions are histograms.
+func NewRecorder(interval time.Duration) *Recorder {
+	return NewRecorderWith(interval, func() Distribution { return NewHistogram() })
+}
+
+// NewRecorderWith creates a recorder with intervals of the given length,
+// whose distributions are created by newDist, see NewDistribution.
+func NewRecorderWith(interval time.Duration, newDist func() Distribution) *Recorder {
+	return &Recorder{
+		overall:  newDist(),
+		interval: interval,
+		newDist:  newDist,
+		start:    time.Now(),
+		current:  newDist(),
+		last:     newDist(),
+	}
+}
+
+// Record a duration.
+func (r *Recorder) Record(d time.Duration) {
+	r.overall.Record(d)
+	r.mu.Lock()
+	r.rotate(time.Now())
+	current := r.current
+	r.mu.Unlock()
+	current.Record(d)
+}
+
+// RecordCensored records a duration that is a lower bound, see
+// Histogram.RecordCensored.
+func (r *Recorder) RecordCensored(d time.Duration) {
+	r.overall.RecordCensored(d)
+	r.mu.Lock()
+	r.rotate(time.Now())
+	current := r.current
+	r.mu.Unlock()
+	current.RecordCensored(d)
+}
+
+// Overall is the distribution of all the durations ever recorded.
+func (r *Recorder) Overall() Distribution { return r.overall }
+
+// LastInterval is the distribution of the last complete interval. It's
+// empty if nothing was recorded during that interval.
+func (r *Recorder) LastInterval() Distribution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate(time.Now())
+	return r.last
+}
+
+func (r *Recorder) rotate(now time.Time) {
+	elapsed := now.Sub(r.start)
+	if elapsed < r.interval {
+		return
+	}
+	if elapsed < 2*r.interval {
+		r.last = r.current
+	} else {
+		// nothing was recorded during the last interval
+		r.last = r.newDist()
+	}
+	r.current = r.newDist()
+	r.start = r.start.Add(elapsed - elapsed%r.interval)
+}
+
+func bucketOf(v uint64) int {
+	if v < linearMax {
+		return int(v)
+	}
+	shift := uint(bits.Len64(v)) - subBucketBits - 1
+	return linearMax + int(shift-1)*subBuckets + int(v>>shift) - subBuckets
+}
+
+// upperBound is the largest value counted in bucket i.
+func upperBound(i int) uint64 {
+	if i < linearMax {
+		return uint64(i)
+	}
+	i -= linearMax
+	shift := uint(i/subBuckets) + 1
+	sub := uint64(i%subBuckets) + subBuckets
+	return (sub+1)<<shift - 1
+}
@@ -0,0 +1,240 @@
+package monitor_test
+
+import (
+	"fmt"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/pushrax/goamz/aws"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantiles(t *testing.T) {
+	h := monitor.NewHistogram()
+	if h.Quantile(0.5) != 0 {
+		t.Errorf("want 0 for an empty histogram, got %v", h.Quantile(0.5))
+	}
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	if h.Count() != 1000 {
+		t.Errorf("want 1000 durations, got %d", h.Count())
+	}
+
+	tcs := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.50, 500 * time.Millisecond},
+		{0.95, 950 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+		{1, 1000 * time.Millisecond},
+	}
+	for _, tc := range tcs {
+		got := h.Quantile(tc.q)
+		// buckets are at most 1/32 wide, and quantiles are their upper bound
+		if got < tc.want || got > tc.want+tc.want/32 {
+			t.Errorf("q%v: want about %v, got %v", tc.q, tc.want, got)
+		}
+	}
+}
+
+func TestHistogramMaxAndBuckets(t *testing.T) {
+	h := monitor.NewHistogram()
+	h.Record(3 * time.Millisecond)
+	h.Record(3 * time.Millisecond)
+	h.Record(2 * time.Second)
+
+	if h.Max() != 2*time.Second {
+		t.Errorf("want max %v, got %v", 2*time.Second, h.Max())
+	}
+	if got := h.Quantile(0.999); got != 2*time.Second {
+		t.Errorf("want p999 capped to the max %v, got %v", 2*time.Second, got)
+	}
+	buckets := h.Buckets()
+	if len(buckets) != 2 || buckets[0].Count != 2 || buckets[1].Count != 1 {
+		t.Fatalf("want buckets of 2 and 1 durations, got %v", buckets)
+	}
+	if buckets[0].UpTo < 3*time.Millisecond || buckets[1].UpTo < 2*time.Second {
+		t.Errorf("buckets don't cover their durations: %v", buckets)
+	}
+}
+
+func TestHistogramCensored(t *testing.T) {
+	h := monitor.NewHistogram()
+	h.Record(time.Millisecond)
+	h.RecordCensored(time.Second)
+
+	sum := h.Summarize()
+	if sum.Count != 2 || sum.Censored != 1 {
+		t.Errorf("want 2 durations with 1 censored, got %+v", sum)
+	}
+	if sum.Max != time.Second {
+		t.Errorf("want the censored duration counted in the max, got %v", sum.Max)
+	}
+}
+
+func TestRecorderIntervals(t *testing.T) {
+	r := monitor.NewRecorder(50 * time.Millisecond)
+	r.Record(time.Millisecond)
+	if r.LastInterval().Count() != 0 {
+		t.Errorf("want no complete interval yet")
+	}
+	time.Sleep(60 * time.Millisecond)
+	r.Record(2 * time.Millisecond)
+	if got := r.LastInterval().Count(); got != 1 {
+		t.Errorf("want 1 duration in the last interval, got %d", got)
+	}
+	if got := r.Overall().Count(); got != 2 {
+		t.Errorf("want 2 durations overall, got %d", got)
+	}
+	time.Sleep(110 * time.Millisecond)
+	if got := r.LastInterval().Count(); got != 0 {
+		t.Errorf("want an empty last interval, got %d durations", got)
+	}
+}
+
+func TestTDigestQuantiles(t *testing.T) {
+	d := monitor.NewTDigest(100)
+	if d.Quantile(0.5) != 0 {
+		t.Errorf("want 0 for an empty digest, got %v", d.Quantile(0.5))
+	}
+	// shuffled, so that the digest doesn't merge them in order
+	rnd := rand.New(rand.NewSource(42))
+	for _, i := range rnd.Perm(100000) {
+		d.Record(time.Duration(i+1) * time.Microsecond)
+	}
+	d.RecordCensored(time.Second)
+
+	sum := d.Summarize()
+	if sum.Count != 100001 || sum.Censored != 1 || sum.Max != time.Second {
+		t.Errorf("want 100001 durations with 1 censored, got %+v", sum)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{0.999, 99900 * time.Microsecond},
+	} {
+		got := d.Quantile(tc.q)
+		// the digest is the most accurate at the tails
+		if diff := math.Abs(float64(got-tc.want)) / float64(tc.want); diff > 0.01 {
+			t.Errorf("q%v: want about %v, got %v", tc.q, tc.want, got)
+		}
+	}
+	var counted int64
+	for _, b := range d.Buckets() {
+		counted += b.Count
+	}
+	if buckets := len(d.Buckets()); counted != 100001 || buckets > 1000 {
+		t.Errorf("want all durations in a bounded number of centroids, got %d in %d", counted, buckets)
+	}
+}
+
+func TestRecorderBackends(t *testing.T) {
+	for _, backend := range []string{monitor.HDR, monitor.Digest} {
+		newDist, err := monitor.NewDistribution(backend)
+		if err != nil {
+			t.Fatalf("can't create %s backend: %v", backend, err)
+		}
+		r := monitor.NewRecorderWith(time.Minute, newDist)
+		for i := 1; i <= 100; i++ {
+			r.Record(time.Duration(i) * time.Millisecond)
+		}
+		if sum := r.Overall().Summarize(); sum.Count != 100 || sum.Max != 100*time.Millisecond || sum.P50 < 45*time.Millisecond || sum.P50 > 55*time.Millisecond {
+			t.Errorf("%s: want 100 durations with a p50 of about 50ms, got %+v", backend, sum)
+		}
+	}
+	if _, err := monitor.NewDistribution("perks"); err == nil {
+		t.Errorf("want unknown backends refused")
+	}
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	reg := monitor.NewRegistry()
+	started, done := reg.Counter("started"), reg.Counter("done")
+	inflight := reg.Gauge("inflight")
+	latency := reg.Histogram("latency")
+	if reg.Counter("started") != started {
+		t.Fatalf("want the same counter for the same name")
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		for i := 0; i < 10000; i++ {
+			started.Add(1)
+			inflight.Add(1)
+			latency.Record(time.Millisecond)
+			inflight.Add(-1)
+			done.Add(1)
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-stop:
+			running = false
+		default:
+		}
+		// updates are made in order, a snapshot must never see a later one
+		// without the earlier ones
+		snap := reg.Snapshot()
+		s, d, n := snap.Counters["started"], snap.Counters["done"], snap.Gauges["inflight"]
+		if d > s || n < 0 || n > s-d || snap.Histograms["latency"].Count > s {
+			t.Fatalf("want a consistent snapshot, got %+v", snap)
+		}
+	}
+
+	snap := reg.Snapshot()
+	if snap.Counters["started"] != 10000 || snap.Counters["done"] != 10000 || snap.Gauges["inflight"] != 0 {
+		t.Errorf("want all the updates in the last snapshot, got %+v", snap)
+	}
+	if sum := snap.Histograms["latency"]; sum.Count != 10000 || sum.P50 != time.Millisecond {
+		t.Errorf("want the latencies in the last snapshot, got %+v", sum)
+	}
+}
+
+func TestCloudWatchPut(t *testing.T) {
+	var puts []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("can't parse form: %v", err)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("request is not signed")
+		}
+		if ns := r.Form.Get("Namespace"); ns != "brigade" {
+			t.Errorf("want namespace %q, got %q", "brigade", ns)
+		}
+		if dim := r.Form.Get("MetricData.member.1.Dimensions.member.1.Value"); dim != "my-bucket" {
+			t.Errorf("want dimension %q, got %q", "my-bucket", dim)
+		}
+		n := 0
+		for r.Form.Get(fmt.Sprintf("MetricData.member.%d.MetricName", n+1)) != "" {
+			n++
+		}
+		puts = append(puts, n)
+	}))
+	defer srv.Close()
+
+	region := aws.USEast
+	region.CloudWatchServicepoint.Endpoint = srv.URL
+	cw := monitor.NewCloudWatch(aws.Auth{AccessKey: "a", SecretKey: "b"}, region, "brigade")
+	cw.Dimensions = map[string]string{"Source": "my-bucket"}
+
+	var data []monitor.Datum
+	for i := 0; i < 25; i++ {
+		data = append(data, monitor.Datum{Name: fmt.Sprint("metric", i), Value: float64(i), Unit: monitor.Count})
+	}
+	if err := cw.Put(time.Now(), data); err != nil {
+		t.Fatalf("can't put metrics: %v", err)
+	}
+	if len(puts) != 2 || puts[0] != 20 || puts[1] != 5 {
+		t.Errorf("want puts of 20 and 5 metrics, got %v", puts)
+	}
+}
@@ -0,0 +1,140 @@
+package monitor
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds the counters, gauges and histograms of a task. They can be
+// updated concurrently, and are read together with Snapshot, which waits for
+// the updates under way and holds the others, so that all the values of a
+// snapshot are from the same instant rather than read one after the other.
+type Registry struct {
+	// updates hold the read lock, so that they don't wait on each other,
+	// and snapshots the write lock
+	mu         sync.RWMutex
+	counters   map[string]*Counter
+	gauges     map[string]*Gauge
+	histograms map[string]*Histogram
+}
+
+// NewRegistry creates a registry with no metrics.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*Counter),
+		gauges:     make(map[string]*Gauge),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// update holds the registry while a metric is updated, returning the func
+// releasing it. Metrics without a registry are updated as they are.
+func (r *Registry) update() func() {
+	if r == nil {
+		return func() {}
+	}
+	r.mu.RLock()
+	return r.mu.RUnlock
+}
+
+// Counter returns the counter of a name, created the first time.
+func (r *Registry) Counter(name string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counters[name]
+	if !ok {
+		c = &Counter{reg: r}
+		r.counters[name] = c
+	}
+	return c
+}
+
+// Gauge returns the gauge of a name, created the first time.
+func (r *Registry) Gauge(name string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.gauges[name]
+	if !ok {
+		g = &Gauge{reg: r}
+		r.gauges[name] = g
+	}
+	return g
+}
+
+// Histogram returns the histogram of a name, created the first time.
+func (r *Registry) Histogram(name string) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.histograms[name]
+	if !ok {
+		h = &Histogram{reg: r}
+		r.histograms[name] = h
+	}
+	return h
+}
+
+// Snapshot of the metrics of a registry, by name.
+type Snapshot struct {
+	Counters   map[string]int64
+	Gauges     map[string]int64
+	Histograms map[string]Summary
+}
+
+// Snapshot reads all the metrics of the registry at once, waiting for the
+// updates under way.
+func (r *Registry) Snapshot() Snapshot {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snap := Snapshot{
+		Counters:   make(map[string]int64, len(r.counters)),
+		Gauges:     make(map[string]int64, len(r.gauges)),
+		Histograms: make(map[string]Summary, len(r.histograms)),
+	}
+	for name, c := range r.counters {
+		snap.Counters[name] = c.Value()
+	}
+	for name, g := range r.gauges {
+		snap.Gauges[name] = g.Value()
+	}
+	for name, h := range r.histograms {
+		snap.Histograms[name] = h.Summarize()
+	}
+	return snap
+}
+
+// Counter is a count that only goes up.
+type Counter struct {
+	v   int64
+	reg *Registry
+}
+
+// Add n to the counter, returning its new value.
+func (c *Counter) Add(n int64) int64 {
+	defer c.reg.update()()
+	return atomic.AddInt64(&c.v, n)
+}
+
+// Value of the counter, which may be outdated as soon as it's read, use a
+// Snapshot to read many metrics.
+func (c *Counter) Value() int64 { return atomic.LoadInt64(&c.v) }
+
+// Gauge is a value that goes up and down.
+type Gauge struct {
+	v   int64
+	reg *Registry
+}
+
+// Add n to the gauge, which can be negative, returning its new value.
+func (g *Gauge) Add(n int64) int64 {
+	defer g.reg.update()()
+	return atomic.AddInt64(&g.v, n)
+}
+
+// Set the gauge.
+func (g *Gauge) Set(v int64) {
+	defer g.reg.update()()
+	atomic.StoreInt64(&g.v, v)
+}
+
+// Value of the gauge, see Counter.Value.
+func (g *Gauge) Value() int64 { return atomic.LoadInt64(&g.v) }
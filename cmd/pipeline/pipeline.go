@@ -0,0 +1,164 @@
+// Package pipeline has the plumbing of the stages that listings go through:
+// lines read from an input, decoded into keys by many workers, worked on by
+// many workers, and written to outputs, with buffered channels between the
+// stages. The stages themselves are up to the commands.
+package pipeline
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// BufferFactor of the channels between stages, which are BufferFactor times
+// bigger than the number of workers that read them.
+const BufferFactor = 10
+
+// ErrStopped is returned by ReadLines when it's stopped before the end of
+// its input.
+var ErrStopped = errors.New("pipeline stopped")
+
+// LineTooLongError is returned by ReadLinesMax for a line longer than the
+// most it reads, which tells of a corrupt input more often than not.
+type LineTooLongError struct {
+	Size, Max int64
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("line of %d bytes is longer than the most of %d", e.Size, e.Max)
+}
+
+// Workers is a group of goroutines, started by Start and waited on by Wait.
+// Workers can be started while others run, until Wait returned.
+type Workers struct {
+	wg sync.WaitGroup
+}
+
+// Start n workers, each calling work with its number, from 0 to n-1.
+func (w *Workers) Start(n int, work func(worker int)) {
+	for i := 0; i < n; i++ {
+		w.wg.Add(1)
+		go func(worker int) {
+			defer w.wg.Done()
+			work(worker)
+		}(i)
+	}
+}
+
+// Wait until all the workers returned.
+func (w *Workers) Wait() { w.wg.Wait() }
+
+// Run n workers calling work, and wait until they all returned.
+func Run(n int, work func(worker int)) {
+	var w Workers
+	w.Start(n, work)
+	w.Wait()
+}
+
+// FirstError keeps the first error of many workers, which can check whether
+// one failed already to stop early. The zero value has no error.
+type FirstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+// Set the error, unless there's one already or err is nil.
+func (f *FirstError) Set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// Err is the first error set, if any.
+func (f *FirstError) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// linePool recycles the buffers of the lines read by ReadLines, which are
+// handed from the reader to the decoders.
+var linePool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	},
+}
+
+// Release a line received from ReadLines, once done with it, so that its
+// buffer is reused.
+func Release(line *[]byte) { linePool.Put(line) }
+
+// ReadLines reads the \n separated lines of r, keeping their \n, and sends
+// them to lines until EOF or the first error. It stops with ErrStopped if
+// stop is closed first. sent, if not nil, is called for each line sent.
+// The receivers of the lines must Release them.
+func ReadLines(r io.Reader, lines chan<- *[]byte, stop <-chan struct{}, sent func()) error {
+	return ReadLinesMax(r, lines, stop, sent, 0, nil)
+}
+
+// ReadLinesMax is ReadLines, with the lines longer than max bytes, their \n
+// included, read through without being buffered whole, unless max is 0.
+// Such a line isn't sent: tooLong is called with its size instead, and the
+// reading goes on unless it returns an error. Without tooLong, the reading
+// stops with a *LineTooLongError.
+func ReadLinesMax(r io.Reader, lines chan<- *[]byte, stop <-chan struct{}, sent func(), max int64, tooLong func(size int64) error) error {
+	rd := bufio.NewReader(r)
+	for {
+		line := linePool.Get().(*[]byte)
+		*line = (*line)[:0]
+		var err error
+		var size int64
+		for {
+			var chunk []byte
+			chunk, err = rd.ReadSlice('\n')
+			size += int64(len(chunk))
+			if max == 0 || size <= max {
+				*line = append(*line, chunk...)
+			}
+			if err != bufio.ErrBufferFull {
+				break
+			}
+		}
+		if max > 0 && size > max {
+			linePool.Put(line)
+			if tooLong == nil {
+				return &LineTooLongError{Size: size, Max: max}
+			}
+			if skipErr := tooLong(size); skipErr != nil {
+				return skipErr
+			}
+			switch err {
+			case io.EOF:
+				return nil
+			case nil:
+				continue
+			default:
+				return err
+			}
+		}
+		switch err {
+		case io.EOF:
+			linePool.Put(line)
+			return nil
+		case nil:
+		default:
+			linePool.Put(line)
+			return err
+		}
+
+		select {
+		case lines <- line:
+		case <-stop:
+			linePool.Put(line)
+			return ErrStopped
+		}
+		if sent != nil {
+			sent()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWorkers(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		workers []int
+		w       pipeline.Workers
+	)
+	record := func(worker int) {
+		mu.Lock()
+		defer mu.Unlock()
+		workers = append(workers, worker)
+	}
+	w.Start(3, record)
+	w.Start(2, func(worker int) { record(10 + worker) })
+	w.Wait()
+	sort.Ints(workers)
+	if want := []int{0, 1, 2, 10, 11}; !reflect.DeepEqual(want, workers) {
+		t.Errorf("want workers %v to have run, got %v", want, workers)
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	var first pipeline.FirstError
+	first.Set(nil)
+	if first.Err() != nil {
+		t.Errorf("want no error, got %v", first.Err())
+	}
+	errA, errB := errors.New("a"), errors.New("b")
+	pipeline.Run(1, func(int) { first.Set(errA) })
+	first.Set(errB)
+	if first.Err() != errA {
+		t.Errorf("want the first error %v, got %v", errA, first.Err())
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	input := "a\n" + long + "\nb\n"
+	lines := make(chan *[]byte, 10)
+	var sent int
+	if err := pipeline.ReadLines(strings.NewReader(input), lines, nil, func() { sent++ }); err != nil {
+		t.Fatalf("can't read lines: %v", err)
+	}
+	close(lines)
+	var got []string
+	for line := range lines {
+		got = append(got, string(*line))
+		pipeline.Release(line)
+	}
+	if want := []string{"a\n", long + "\n", "b\n"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("want lines %q, got %d lines", want[:1], len(got))
+	}
+	if sent != 3 {
+		t.Errorf("want 3 lines counted, got %d", sent)
+	}
+
+	// nobody reads the lines, stopping unblocks the reader
+	stop := make(chan struct{})
+	close(stop)
+	err := pipeline.ReadLines(bytes.NewBufferString(input), make(chan *[]byte), stop, nil)
+	if err != pipeline.ErrStopped {
+		t.Errorf("want %v, got %v", pipeline.ErrStopped, err)
+	}
+}
+
+func TestReadLinesMax(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	input := "a\n" + long + "\nb\n" + long
+	lines := make(chan *[]byte, 10)
+	var skipped []int64
+	err := pipeline.ReadLinesMax(strings.NewReader(input), lines, nil, nil, 100, func(size int64) error {
+		skipped = append(skipped, size)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't read lines: %v", err)
+	}
+	close(lines)
+	var got []string
+	for line := range lines {
+		got = append(got, string(*line))
+		pipeline.Release(line)
+	}
+	if want := []string{"a\n", "b\n"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("want lines %q, got %q", want, got)
+	}
+	if want := []int64{10001, 10000}; !reflect.DeepEqual(want, skipped) {
+		t.Errorf("want the long lines skipped, got sizes %v", skipped)
+	}
+
+	// without a callback, the first long line stops the reading
+	err = pipeline.ReadLinesMax(strings.NewReader(input), make(chan *[]byte, 10), nil, nil, 100, nil)
+	if e, ok := err.(*pipeline.LineTooLongError); !ok || e.Size != 10001 || e.Max != 100 {
+		t.Errorf("want a line too long, got %v", err)
+	}
+}
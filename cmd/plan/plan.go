@@ -0,0 +1,304 @@
+// Package plan splits a key listing into partitions by top-level prefix,
+// which are sync'd independently, each with its own listing, worker budget
+// and state file. A partition that completed isn't sync'd again when its
+// plan is executed anew, and partitions that were interrupted resume from
+// their state file.
+package plan
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Partition of a plan, holding the keys under a top-level prefix.
+type Partition struct {
+	// Prefix shared by the keys, up to and including the first '/'. Keys
+	// without a '/' are in the partition with an empty prefix.
+	Prefix string `json:"prefix"`
+	// Name of the partition's files, relative to the plan's directory.
+	Name    string `json:"name"`
+	Keys    int64  `json:"keys"`
+	Bytes   int64  `json:"bytes"`
+	Workers int    `json:"workers"`
+
+	// Done is set once the partition was sync'd to the end, along with the
+	// counts of its keys that were synced and that failed.
+	Done   bool  `json:"done"`
+	Synced int64 `json:"synced"`
+	Failed int64 `json:"failed"`
+}
+
+// Listing is the file of the keys of the partition.
+func (p *Partition) Listing(dir string) string { return filepath.Join(dir, p.Name+".listing.gz") }
+
+// State is the state file of the partition's sync.
+func (p *Partition) State(dir string) string { return filepath.Join(dir, p.Name+".state") }
+
+// SyncedFile is the file of the keys of the partition that were synced.
+func (p *Partition) SyncedFile(dir string) string { return filepath.Join(dir, p.Name+".synced.json.gz") }
+
+// FailedFile is the file of the keys of the partition that failed to sync.
+func (p *Partition) FailedFile(dir string) string { return filepath.Join(dir, p.Name+".failed.json.gz") }
+
+// Plan of a sync split in partitions. It's saved as plan.json in its
+// directory, along with the files of its partitions.
+type Plan struct {
+	Dir        string       `json:"-"`
+	Partitions []*Partition `json:"partitions"`
+
+	mu sync.Mutex
+}
+
+// manifest is the name of the file of a plan in its directory.
+const manifest = "plan.json"
+
+// TopLevelPrefix of a key, up to and including its first '/'.
+func TopLevelPrefix(key string) string {
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		return key[:i+1]
+	}
+	return ""
+}
+
+// Split the keys of a listing in partitions by top-level prefix, writing
+// their listings to dir in a format. At most maxPartitions are created, as
+// many files are open at once. The workers are shared among the partitions
+// in proportion of their keys, each getting at least one.
+func Split(r listing.Reader, dir, format string, maxPartitions, workers int) (*Plan, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifest)); err == nil {
+		return nil, fmt.Errorf("%s already has a plan", dir)
+	}
+
+	plan := &Plan{Dir: dir}
+	outputs := make(map[string]*output)
+	closeAll := func() error {
+		var cerr error
+		for _, out := range outputs {
+			if err := out.Close(); err != nil && cerr == nil {
+				cerr = err
+			}
+		}
+		return cerr
+	}
+
+	var key s3.Key
+	for {
+		err := r.Read(&key)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = closeAll()
+			return nil, fmt.Errorf("reading listing: %v", err)
+		}
+
+		prefix := TopLevelPrefix(key.Key)
+		out, ok := outputs[prefix]
+		if !ok {
+			if len(outputs) == maxPartitions {
+				_ = closeAll()
+				return nil, fmt.Errorf("more than %d top-level prefixes in listing", maxPartitions)
+			}
+			part := &Partition{Prefix: prefix, Name: fmt.Sprintf("part-%04d", len(outputs))}
+			out, err = createOutput(part, dir, format)
+			if err != nil {
+				_ = closeAll()
+				return nil, err
+			}
+			outputs[prefix] = out
+			plan.Partitions = append(plan.Partitions, part)
+		}
+		if err := out.w.Write(key); err != nil {
+			_ = closeAll()
+			return nil, fmt.Errorf("writing partition %q: %v", prefix, err)
+		}
+		out.part.Keys++
+		out.part.Bytes += key.Size
+	}
+	if err := closeAll(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(byPrefix(plan.Partitions))
+	plan.shareWorkers(workers)
+	return plan, plan.Save()
+}
+
+// shareWorkers gives each partition a number of workers in proportion of
+// its keys, with at least one worker.
+func (p *Plan) shareWorkers(workers int) {
+	var total int64
+	for _, part := range p.Partitions {
+		total += part.Keys
+	}
+	for _, part := range p.Partitions {
+		part.Workers = 1
+		if total > 0 {
+			if n := int(int64(workers) * part.Keys / total); n > 1 {
+				part.Workers = n
+			}
+		}
+	}
+}
+
+type byPrefix []*Partition
+
+func (p byPrefix) Len() int           { return len(p) }
+func (p byPrefix) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPrefix) Less(i, j int) bool { return p[i].Prefix < p[j].Prefix }
+
+// output writes the listing of a partition.
+type output struct {
+	part *Partition
+	file *os.File
+	gz   *gzip.Writer
+	w    listing.Writer
+}
+
+func createOutput(part *Partition, dir, format string) (*output, error) {
+	file, err := os.Create(part.Listing(dir))
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	w, err := listing.NewWriter(gz, format)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &output{part: part, file: file, gz: gz, w: w}, nil
+}
+
+func (o *output) Close() error {
+	if err := o.w.Flush(); err != nil {
+		_ = o.file.Close()
+		return fmt.Errorf("flushing partition %q: %v", o.part.Prefix, err)
+	}
+	if err := o.gz.Close(); err != nil {
+		_ = o.file.Close()
+		return fmt.Errorf("closing gzip of partition %q: %v", o.part.Prefix, err)
+	}
+	return o.file.Close()
+}
+
+// Load the plan in dir.
+func Load(dir string) (*Plan, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifest))
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Dir: dir}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("decoding plan: %v", err)
+	}
+	return plan, nil
+}
+
+// Save the plan in its directory, atomically replacing the previous one.
+func (p *Plan) Save() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.save()
+}
+
+func (p *Plan) save() error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(p.Dir, manifest+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(p.Dir, manifest))
+}
+
+// RunFunc syncs a partition, setting its counts of synced and failed keys.
+type RunFunc func(part *Partition) error
+
+// Execute the partitions that aren't done, running up to parallel of them
+// at once. Each partition is marked done and the plan saved once it's
+// sync'd. The first error stops new partitions from starting, and is
+// returned once the running ones are done.
+func (p *Plan) Execute(parallel int, run RunFunc) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+	var pending []*Partition
+	for _, part := range p.Partitions {
+		if !part.Done {
+			pending = append(pending, part)
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"partitions": len(p.Partitions),
+		"pending":    len(pending),
+		"parallel":   parallel,
+	}).Info("executing plan")
+
+	parts := make(chan *Partition, len(pending))
+	for _, part := range pending {
+		parts <- part
+	}
+	close(parts)
+
+	var firstErr pipeline.FirstError
+	pipeline.Run(parallel, func(int) {
+		for part := range parts {
+			if firstErr.Err() != nil {
+				return
+			}
+			firstErr.Set(p.execute(part, run))
+		}
+	})
+	return firstErr.Err()
+}
+
+func (p *Plan) execute(part *Partition, run RunFunc) error {
+	logrus.WithFields(logrus.Fields{
+		"prefix":  part.Prefix,
+		"keys":    part.Keys,
+		"workers": part.Workers,
+	}).Info("syncing partition")
+	p.mu.Lock()
+	// the counts are only read under the lock of the plan
+	work := *part
+	p.mu.Unlock()
+	if err := run(&work); err != nil {
+		return fmt.Errorf("syncing partition %q: %v", part.Prefix, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	part.Synced, part.Failed, part.Done = work.Synced, work.Failed, true
+	logrus.WithFields(logrus.Fields{
+		"prefix": part.Prefix,
+		"synced": part.Synced,
+		"failed": part.Failed,
+	}).Info("done syncing partition")
+	return p.save()
+}
@@ -0,0 +1,159 @@
+package plan_test
+
+import (
+	"compress/gzip"
+	"errors"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/plan"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// keysReader reads keys from a slice, as a listing.
+type keysReader []s3.Key
+
+func (r *keysReader) Read(key *s3.Key) error {
+	if len(*r) == 0 {
+		return io.EOF
+	}
+	*key = (*r)[0]
+	*r = (*r)[1:]
+	return nil
+}
+
+func readPartition(t *testing.T, filename string) []s3.Key {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("can't open partition: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("can't read gzip: %v", err)
+	}
+	rd, err := listing.NewReader(gz)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+	var keys []s3.Key
+	for {
+		var key s3.Key
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			return keys
+		case nil:
+			keys = append(keys, key)
+		default:
+			t.Fatalf("can't read key: %v", err)
+		}
+	}
+}
+
+func TestSplitAndExecute(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keys := []s3.Key{
+		{Key: "tenant-b/1", Size: 10},
+		{Key: "tenant-a/1", Size: 1},
+		{Key: "root", Size: 100},
+		{Key: "tenant-b/dir/2", Size: 20},
+		{Key: "tenant-b/3", Size: 30},
+	}
+	r := keysReader(keys)
+	p, err := plan.Split(&r, dir, listing.Binary, 10, 8)
+	if err != nil {
+		t.Fatalf("can't split listing: %v", err)
+	}
+
+	want := []struct {
+		prefix  string
+		keys    []s3.Key
+		bytes   int64
+		workers int
+	}{
+		{"", keys[2:3], 100, 1},
+		{"tenant-a/", keys[1:2], 1, 1},
+		{"tenant-b/", []s3.Key{keys[0], keys[3], keys[4]}, 60, 4},
+	}
+	if len(p.Partitions) != len(want) {
+		t.Fatalf("want %d partitions, got %d", len(want), len(p.Partitions))
+	}
+	for i, w := range want {
+		part := p.Partitions[i]
+		if part.Prefix != w.prefix || part.Keys != int64(len(w.keys)) || part.Bytes != w.bytes || part.Workers != w.workers {
+			t.Errorf("partition %d: want %q with %d keys, %d bytes and %d workers, got %+v", i, w.prefix, len(w.keys), w.bytes, w.workers, part)
+		}
+		if got := readPartition(t, part.Listing(dir)); !reflect.DeepEqual(w.keys, got) {
+			t.Errorf("partition %q: want keys %v, got %v", w.prefix, w.keys, got)
+		}
+	}
+
+	if _, err := plan.Split(&r, dir, listing.JSON, 10, 8); err == nil {
+		t.Errorf("want an error splitting over an existing plan")
+	}
+
+	// the first execution fails on a partition, the next one only runs the
+	// partitions that aren't done
+	var ran []string
+	errBroken := errors.New("broken")
+	err = p.Execute(1, func(part *plan.Partition) error {
+		ran = append(ran, part.Prefix)
+		if part.Prefix == "tenant-a/" {
+			return errBroken
+		}
+		part.Synced = part.Keys
+		return nil
+	})
+	if err == nil {
+		t.Errorf("want the error of the partition")
+	}
+
+	p, err = plan.Load(dir)
+	if err != nil {
+		t.Fatalf("can't load plan: %v", err)
+	}
+	ran = nil
+	err = p.Execute(2, func(part *plan.Partition) error {
+		ran = append(ran, part.Prefix)
+		part.Synced, part.Failed = part.Keys-1, 1
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("can't execute plan: %v", err)
+	}
+	if want := []string{"tenant-a/", "tenant-b/"}; !reflect.DeepEqual(want, ran) {
+		t.Errorf("want partitions %v executed again, got %v", want, ran)
+	}
+
+	p, err = plan.Load(dir)
+	if err != nil {
+		t.Fatalf("can't load plan: %v", err)
+	}
+	for i, want := range []struct{ synced, failed int64 }{{1, 0}, {0, 1}, {2, 1}} {
+		part := p.Partitions[i]
+		if !part.Done || part.Synced != want.synced || part.Failed != want.failed {
+			t.Errorf("partition %q: want done with %d synced and %d failed, got %+v", part.Prefix, want.synced, want.failed, part)
+		}
+	}
+}
+
+func TestSplitTooManyPrefixes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := keysReader{{Key: "a/1"}, {Key: "b/1"}, {Key: "c/1"}}
+	if _, err := plan.Split(&r, dir, listing.JSON, 2, 8); err == nil {
+		t.Errorf("want an error with more prefixes than partitions")
+	}
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"sync"
+)
+
+// Sender sends batches of messages, such as SQS.
+type Sender interface {
+	// SendBatch sends at most MaxSendBatch messages in a single call.
+	SendBatch(bodies [][]byte) error
+}
+
+// Output sends the keys of an output of a task to a queue, a message per
+// key, so that another process can act on them as they're synced. It's
+// both a listing.Encoder, whose keys and failures are sent as JSON lines
+// whatever the format of the output, and an io.Writer, whose lines are sent
+// as they're written. Messages are sent in batches of MaxSendBatch, the
+// last one once flushed or closed.
+type Output struct {
+	mu      sync.Mutex
+	sender  Sender
+	enc     listing.Encoder
+	buf     bytes.Buffer
+	pending [][]byte
+}
+
+// NewOutput creates an output sending its keys with sender.
+func NewOutput(sender Sender) *Output {
+	o := &Output{sender: sender}
+	o.enc, _ = listing.NewEncoder(&o.buf, listing.JSON)
+	return o
+}
+
+// EncodeKey sends a key.
+func (o *Output) EncodeKey(key s3.Key) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.enc.EncodeKey(key); err != nil {
+		return err
+	}
+	return o.encoded()
+}
+
+// EncodeFailure sends a failure.
+func (o *Output) EncodeFailure(f listing.Failure) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.enc.EncodeFailure(f); err != nil {
+		return err
+	}
+	return o.encoded()
+}
+
+func (o *Output) encoded() error {
+	if err := o.enc.Flush(); err != nil {
+		return err
+	}
+	return o.queueLines()
+}
+
+// Write sends the lines of p, keeping the last one until it's complete.
+func (o *Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf.Write(p)
+	return len(p), o.queueLines()
+}
+
+// queueLines queues the complete lines of the buffer, and sends them once
+// there's a batch of them.
+func (o *Output) queueLines() error {
+	for {
+		i := bytes.IndexByte(o.buf.Bytes(), '\n')
+		if i < 0 {
+			return nil
+		}
+		line := make([]byte, i)
+		copy(line, o.buf.Next(i+1))
+		if len(line) == 0 {
+			continue
+		}
+		o.pending = append(o.pending, line)
+		if len(o.pending) == MaxSendBatch {
+			if err := o.send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (o *Output) send() error {
+	if len(o.pending) == 0 {
+		return nil
+	}
+	err := o.sender.SendBatch(o.pending)
+	o.pending = o.pending[:0]
+	return err
+}
+
+// Flush sends the messages waiting for a batch to fill.
+func (o *Output) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.send()
+}
+
+// Close sends the messages left, including an incomplete last line.
+func (o *Output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.buf.Len() > 0 {
+		o.pending = append(o.pending, append([]byte(nil), o.buf.Bytes()...))
+		o.buf.Reset()
+	}
+	return o.send()
+}
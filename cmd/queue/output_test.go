@@ -0,0 +1,62 @@
+package queue_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/queue"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"testing"
+)
+
+// memSender records the batches sent
+type memSender struct {
+	batches [][]string
+	err     error
+}
+
+func (m *memSender) SendBatch(bodies [][]byte) error {
+	var batch []string
+	for _, body := range bodies {
+		batch = append(batch, string(body))
+	}
+	m.batches = append(m.batches, batch)
+	return m.err
+}
+
+func TestOutput(t *testing.T) {
+	sender := &memSender{}
+	out := queue.NewOutput(sender)
+	for i := 0; i < queue.MaxSendBatch+2; i++ {
+		if err := out.EncodeKey(s3.Key{Key: fmt.Sprintf("key-%d", i)}); err != nil {
+			t.Fatalf("can't encode key: %v", err)
+		}
+	}
+	if len(sender.batches) != 1 || len(sender.batches[0]) != queue.MaxSendBatch {
+		t.Fatalf("want a full batch sent, got %v", sender.batches)
+	}
+	if err := out.Flush(); err != nil {
+		t.Fatalf("can't flush: %v", err)
+	}
+	if len(sender.batches) != 2 || len(sender.batches[1]) != 2 {
+		t.Fatalf("want the rest sent once flushed, got %v", sender.batches)
+	}
+	if got := sender.batches[0][0]; !strings.HasPrefix(got, `{"Key":"key-0",`) || strings.HasSuffix(got, "\n") {
+		t.Errorf("want a JSON key per message, got %s", got)
+	}
+
+	// lines written are messages, once complete
+	if _, err := out.Write([]byte("one\ntw")); err != nil {
+		t.Fatalf("can't write: %v", err)
+	}
+	if _, err := out.Write([]byte("o\nthree")); err != nil {
+		t.Fatalf("can't write: %v", err)
+	}
+	sender.err = errors.New("queue is gone")
+	if err := out.Close(); err == nil {
+		t.Errorf("want the error of the queue")
+	}
+	if got := fmt.Sprint(sender.batches[2]); got != "[one two three]" {
+		t.Errorf("want a message per line, got %s", got)
+	}
+}
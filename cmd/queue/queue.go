@@ -0,0 +1,161 @@
+// Package queue distributes the keys of a listing over a shared work queue,
+// so that many brigade processes on many hosts can sync a single listing.
+//
+// A coordinator reads the listing and pushes batches of keys on the queue,
+// each batch being a message made of JSON keys, one per line. Workers
+// receive batches, sync the keys they contain and delete the messages once
+// done. If a worker dies while holding a batch, the queue makes it visible
+// again after a timeout and another worker picks it up.
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"expvar"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"time"
+)
+
+// MaxMessageSize is the largest message body pushed on a queue, which is
+// a bit less than the 256KiB limit of SQS.
+var MaxMessageSize = 250 * 1024
+
+// Message is a batch of keys received from a queue.
+type Message struct {
+	ID     string
+	Handle string
+	Body   []byte
+}
+
+// Queue holds batches of keys waiting to be sync'd.
+type Queue interface {
+	// Push a batch on the queue.
+	Push(body []byte) error
+	// Receive at most max batches from the queue, which are hidden from
+	// other workers until deleted or until their visibility times out.
+	Receive(max int) ([]Message, error)
+	// Delete a batch that was fully processed.
+	Delete(msg Message) error
+}
+
+var metrics = struct {
+	batchesPushed   *expvar.Int
+	keysPushed      *expvar.Int
+	batchesReceived *expvar.Int
+	batchesDone     *expvar.Int
+	batchesFailed   *expvar.Int
+}{
+	batchesPushed:   expvar.NewInt("brigade.queue.batchesPushed"),
+	keysPushed:      expvar.NewInt("brigade.queue.keysPushed"),
+	batchesReceived: expvar.NewInt("brigade.queue.batchesReceived"),
+	batchesDone:     expvar.NewInt("brigade.queue.batchesDone"),
+	batchesFailed:   expvar.NewInt("brigade.queue.batchesFailed"),
+}
+
+// Distribute reads the keys of a listing, one JSON key per line, and pushes
+// them on the queue in batches of at most batchSize keys. It returns the
+// number of keys that were pushed.
+func Distribute(q Queue, input io.Reader, batchSize int) (int, error) {
+	if batchSize < 1 {
+		return 0, fmt.Errorf("need a batch size of at least 1, got %d", batchSize)
+	}
+
+	var (
+		batch     bytes.Buffer
+		batchLen  int
+		keyCount  int
+		rd        = bufio.NewReader(input)
+		pushBatch = func() error {
+			if batchLen == 0 {
+				return nil
+			}
+			if err := q.Push(batch.Bytes()); err != nil {
+				return fmt.Errorf("pushing batch of %d keys: %v", batchLen, err)
+			}
+			metrics.batchesPushed.Add(1)
+			metrics.keysPushed.Add(int64(batchLen))
+			keyCount += batchLen
+			batch.Reset()
+			batchLen = 0
+			return nil
+		}
+	)
+
+	for {
+		line, err := rd.ReadBytes('\n')
+		if len(line) != 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			if len(line) > MaxMessageSize {
+				return keyCount, fmt.Errorf("line of %d bytes is too large to fit in a message", len(line))
+			}
+			if batch.Len()+len(line) > MaxMessageSize {
+				if perr := pushBatch(); perr != nil {
+					return keyCount, perr
+				}
+			}
+			batch.Write(line)
+			batchLen++
+			if batchLen >= batchSize {
+				if perr := pushBatch(); perr != nil {
+					return keyCount, perr
+				}
+			}
+		}
+		switch err {
+		case nil:
+		case io.EOF:
+			return keyCount, pushBatch()
+		default:
+			return keyCount, err
+		}
+	}
+}
+
+// Work receives batches from the queue and gives them to process, deleting
+// each batch from the queue once processed without error. Work stops after
+// `idle` consecutive receives return no batch.
+func Work(q Queue, idle int, process func(batch io.Reader) error) error {
+	emptyReceives := 0
+	for emptyReceives < idle {
+		msgs, err := q.Receive(10)
+		if err != nil {
+			logrus.WithField("error", err).Error("receiving batches from queue")
+			emptyReceives++
+			time.Sleep(time.Second)
+			continue
+		}
+		if len(msgs) == 0 {
+			emptyReceives++
+			logrus.WithFields(logrus.Fields{
+				"empty_receives": emptyReceives,
+				"idle_limit":     idle,
+			}).Info("no batch waiting on queue")
+			continue
+		}
+		emptyReceives = 0
+		metrics.batchesReceived.Add(int64(len(msgs)))
+
+		for _, msg := range msgs {
+			if err := process(bytes.NewReader(msg.Body)); err != nil {
+				// leave the batch on the queue, it will be visible again
+				// after its visibility timeout
+				metrics.batchesFailed.Add(1)
+				logrus.WithFields(logrus.Fields{
+					"error":      err,
+					"message_id": msg.ID,
+				}).Error("failed to process batch, leaving it on the queue")
+				continue
+			}
+			if err := q.Delete(msg); err != nil {
+				return fmt.Errorf("deleting processed batch %q: %v", msg.ID, err)
+			}
+			metrics.batchesDone.Add(1)
+		}
+	}
+	logrus.WithField("batches_done", metrics.batchesDone.String()).Info("queue is drained")
+	return nil
+}
@@ -0,0 +1,146 @@
+package queue_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/queue"
+	"github.com/pushrax/goamz/aws"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// memQueue is a queue where received batches are deleted right away
+type memQueue struct {
+	pending [][]byte
+	deleted int
+}
+
+func (m *memQueue) Push(body []byte) error {
+	m.pending = append(m.pending, append([]byte(nil), body...))
+	return nil
+}
+
+func (m *memQueue) Receive(max int) ([]queue.Message, error) {
+	var msgs []queue.Message
+	for len(m.pending) != 0 && len(msgs) < max {
+		msgs = append(msgs, queue.Message{ID: fmt.Sprint(len(m.pending)), Body: m.pending[0]})
+		m.pending = m.pending[1:]
+	}
+	return msgs, nil
+}
+
+func (m *memQueue) Delete(msg queue.Message) error {
+	m.deleted++
+	return nil
+}
+
+func listing(n int) string {
+	var buf bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "{\"Key\":\"key-%d\"}\n", i)
+	}
+	return buf.String()
+}
+
+func TestDistributeAndWork(t *testing.T) {
+	q := &memQueue{}
+	input := listing(25)
+
+	n, err := queue.Distribute(q, strings.NewReader(input), 10)
+	if err != nil {
+		t.Fatalf("can't distribute: %v", err)
+	}
+	if n != 25 {
+		t.Errorf("want 25 keys pushed, got %d", n)
+	}
+	if len(q.pending) != 3 {
+		t.Fatalf("want 3 batches, got %d", len(q.pending))
+	}
+
+	var got bytes.Buffer
+	calls := 0
+	err = queue.Work(q, 1, func(batch io.Reader) error {
+		calls++
+		if calls == 2 {
+			return errors.New("a failing batch stays on the queue")
+		}
+		_, err := io.Copy(&got, batch)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("can't work: %v", err)
+	}
+	if q.deleted != 2 {
+		t.Errorf("want 2 batches deleted, got %d", q.deleted)
+	}
+	want := listing(10) + strings.Join(strings.SplitAfter(input, "\n")[20:], "")
+	if got.String() != want {
+		t.Errorf("want keys\n%s\ngot\n%s", want, got.String())
+	}
+}
+
+func TestDistributeSplitsLargeBatches(t *testing.T) {
+	defer func(size int) { queue.MaxMessageSize = size }(queue.MaxMessageSize)
+	queue.MaxMessageSize = 40
+
+	q := &memQueue{}
+	if _, err := queue.Distribute(q, strings.NewReader(listing(4)), 100); err != nil {
+		t.Fatalf("can't distribute: %v", err)
+	}
+	if len(q.pending) != 2 {
+		t.Fatalf("want 2 batches, got %d", len(q.pending))
+	}
+	for _, body := range q.pending {
+		if len(body) > queue.MaxMessageSize {
+			t.Errorf("batch of %d bytes is larger than max size", len(body))
+		}
+	}
+}
+
+func TestSQSReceive(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("can't parse form: %v", err)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("request is not signed")
+		}
+		switch action := r.Form.Get("Action"); action {
+		case "ReceiveMessage":
+			fmt.Fprint(w, `<ReceiveMessageResponse><ReceiveMessageResult>
+<Message><MessageId>id-1</MessageId><ReceiptHandle>handle-1</ReceiptHandle><Body>{"Key":"a"}
+</Body></Message>
+</ReceiveMessageResult></ReceiveMessageResponse>`)
+		case "DeleteMessage":
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>ReceiptHandleIsInvalid</Code><Message>bad handle</Message></Error></ErrorResponse>`)
+		default:
+			t.Errorf("unexpected action %q", action)
+		}
+		_, _ = io.Copy(ioutil.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	q := queue.NewSQS(aws.Auth{AccessKey: "a", SecretKey: "b"}, aws.USEast, srv.URL)
+	msgs, err := q.Receive(10)
+	if err != nil {
+		t.Fatalf("can't receive: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Handle != "handle-1" || string(msgs[0].Body) != "{\"Key\":\"a\"}\n" {
+		t.Fatalf("unexpected messages: %#v", msgs)
+	}
+
+	err = q.Delete(msgs[0])
+	sqsErr, ok := err.(*queue.SQSError)
+	if !ok {
+		t.Fatalf("want an SQS error, got %#v", err)
+	}
+	if sqsErr.Code != "ReceiptHandleIsInvalid" {
+		t.Errorf("want error code %q, got %q", "ReceiptHandleIsInvalid", sqsErr.Code)
+	}
+}
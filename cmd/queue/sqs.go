@@ -0,0 +1,166 @@
+package queue
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sqsAPIVersion = "2012-11-05"
+
+// SQS is a queue backed by an Amazon SQS queue, using the query API.
+type SQS struct {
+	// WaitTime is how long a receive call waits for batches to arrive.
+	WaitTime time.Duration
+
+	url    string
+	signer *aws.V4Signer
+	client *http.Client
+}
+
+// NewSQS creates a queue that uses the SQS queue at queueURL.
+func NewSQS(auth aws.Auth, region aws.Region, queueURL string) *SQS {
+	return &SQS{
+		WaitTime: 20 * time.Second,
+		url:      queueURL,
+		signer:   aws.NewV4Signer(auth, "sqs", region),
+		client:   &http.Client{Timeout: time.Minute},
+	}
+}
+
+// SQSError is an error returned by SQS.
+type SQSError struct {
+	StatusCode int
+	Type       string
+	Code       string
+	Message    string
+}
+
+func (e *SQSError) Error() string {
+	return fmt.Sprintf("sqs: %s (%s, status %d)", e.Message, e.Code, e.StatusCode)
+}
+
+// Push a batch on the queue.
+func (s *SQS) Push(body []byte) error {
+	params := url.Values{
+		"Action":      {"SendMessage"},
+		"MessageBody": {string(body)},
+	}
+	var resp struct {
+		MessageID string `xml:"SendMessageResult>MessageId"`
+	}
+	return s.query(params, &resp)
+}
+
+// MaxSendBatch is the most messages a SendBatch call accepts.
+const MaxSendBatch = 10
+
+// SendBatch sends at most MaxSendBatch messages in a single call. It fails if
+// any of the messages wasn't sent, in which case some of them may have been.
+func (s *SQS) SendBatch(bodies [][]byte) error {
+	if len(bodies) > MaxSendBatch {
+		return fmt.Errorf("can't send more than %d messages in a batch, got %d", MaxSendBatch, len(bodies))
+	}
+	params := url.Values{"Action": {"SendMessageBatch"}}
+	for i, body := range bodies {
+		entry := "SendMessageBatchRequestEntry." + strconv.Itoa(i+1)
+		params.Set(entry+".Id", strconv.Itoa(i))
+		params.Set(entry+".MessageBody", string(body))
+	}
+	var resp struct {
+		Failed []struct {
+			ID      string `xml:"Id"`
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"SendMessageBatchResult>BatchResultErrorEntry"`
+	}
+	if err := s.query(params, &resp); err != nil {
+		return err
+	}
+	if len(resp.Failed) != 0 {
+		f := resp.Failed[0]
+		return &SQSError{
+			StatusCode: http.StatusOK,
+			Code:       f.Code,
+			Message:    fmt.Sprintf("%d of %d messages not sent, first: %s", len(resp.Failed), len(bodies), f.Message),
+		}
+	}
+	return nil
+}
+
+// Receive at most max batches from the queue, waiting for at most WaitTime.
+func (s *SQS) Receive(max int) ([]Message, error) {
+	params := url.Values{
+		"Action":              {"ReceiveMessage"},
+		"MaxNumberOfMessages": {strconv.Itoa(max)},
+		"WaitTimeSeconds":     {strconv.Itoa(int(s.WaitTime.Seconds()))},
+	}
+	var resp struct {
+		Messages []struct {
+			MessageID     string `xml:"MessageId"`
+			ReceiptHandle string `xml:"ReceiptHandle"`
+			Body          string `xml:"Body"`
+		} `xml:"ReceiveMessageResult>Message"`
+	}
+	if err := s.query(params, &resp); err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, 0, len(resp.Messages))
+	for _, m := range resp.Messages {
+		msgs = append(msgs, Message{
+			ID:     m.MessageID,
+			Handle: m.ReceiptHandle,
+			Body:   []byte(m.Body),
+		})
+	}
+	return msgs, nil
+}
+
+// Delete a batch from the queue.
+func (s *SQS) Delete(msg Message) error {
+	params := url.Values{
+		"Action":        {"DeleteMessage"},
+		"ReceiptHandle": {msg.Handle},
+	}
+	return s.query(params, nil)
+}
+
+func (s *SQS) query(params url.Values, resp interface{}) error {
+	params.Set("Version", sqsAPIVersion)
+	req, err := http.NewRequest("POST", s.url, strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("preparing sqs request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s.signer.Sign(req)
+
+	hresp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = hresp.Body.Close() }()
+
+	if hresp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Type    string `xml:"Error>Type"`
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		_ = xml.NewDecoder(hresp.Body).Decode(&errResp)
+		return &SQSError{
+			StatusCode: hresp.StatusCode,
+			Type:       errResp.Type,
+			Code:       errResp.Code,
+			Message:    errResp.Message,
+		}
+	}
+	if resp == nil {
+		return nil
+	}
+	return xml.NewDecoder(hresp.Body).Decode(resp)
+}
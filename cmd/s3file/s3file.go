@@ -0,0 +1,132 @@
+// Package s3file streams objects of S3, such as key listings, resuming from
+// where they stopped when the connection fails, so that large objects can
+// be read without copying them locally first. Objects can also be written
+// as they're produced, with multipart uploads.
+package s3file
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader streams an object. When reading fails, the object is requested
+// again from the offset where it failed, at most MaxRetry times in a row.
+// The object must not change while it's read.
+type Reader struct {
+	MaxRetry  int
+	RetryBase time.Duration
+
+	bkt    *s3.Bucket
+	key    string
+	etag   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// Parse an s3://bucket/key URL. ok is false if name isn't such a URL.
+func Parse(name string) (bucket, key string, ok bool) {
+	u, err := url.Parse(name)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", false
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), true
+}
+
+// Open starts reading a key of a bucket.
+func Open(bkt *s3.Bucket, key string) (*Reader, error) {
+	r := &Reader{
+		MaxRetry:  10,
+		RetryBase: time.Second,
+		bkt:       bkt,
+		key:       key,
+	}
+	resp, err := bkt.GetResponse(key)
+	if err != nil {
+		return nil, fmt.Errorf("getting s3://%s/%s: %v", bkt.Name, key, err)
+	}
+	r.body = resp.Body
+	r.size = resp.ContentLength
+	r.etag = resp.Header.Get("ETag")
+	return r, nil
+}
+
+// Size of the object, -1 if unknown.
+func (r *Reader) Size() int64 { return r.size }
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for retry := 0; ; retry++ {
+		if r.body == nil {
+			time.Sleep(r.RetryBase * time.Duration(retry))
+			if err := r.resume(); err != nil {
+				if retry >= r.MaxRetry {
+					return 0, err
+				}
+				logrus.WithFields(logrus.Fields{
+					"error": err,
+					"retry": retry + 1,
+				}).Warn("failed to resume reading from s3")
+				continue
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		if err == nil || r.done(err) {
+			return n, err
+		}
+		// the connection failed, or was closed before the end of the object
+		_ = r.body.Close()
+		r.body = nil
+		if n > 0 {
+			return n, nil
+		}
+		if retry >= r.MaxRetry {
+			return 0, fmt.Errorf("reading s3://%s/%s at offset %d: %v", r.bkt.Name, r.key, r.offset, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"error":  err,
+			"key":    r.key,
+			"offset": r.offset,
+		}).Warn("failed reading from s3, resuming")
+	}
+}
+
+// done is true if err ends the object: EOF once all of it was read.
+func (r *Reader) done(err error) bool {
+	return err == io.EOF && (r.size < 0 || r.offset >= r.size)
+}
+
+// resume requests the rest of the object, from the offset reached so far.
+func (r *Reader) resume() error {
+	headers := http.Header{"Range": {"bytes=" + strconv.FormatInt(r.offset, 10) + "-"}}
+	if r.etag != "" {
+		// fail rather than splice two versions of the object
+		headers.Set("If-Match", r.etag)
+	}
+	resp, err := r.bkt.GetResponseWithHeaders(r.key, headers)
+	if err != nil {
+		return fmt.Errorf("resuming s3://%s/%s at offset %d: %v", r.bkt.Name, r.key, r.offset, err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		_ = resp.Body.Close()
+		return fmt.Errorf("resuming s3://%s/%s at offset %d: want a partial content, got status %d", r.bkt.Name, r.key, r.offset, resp.StatusCode)
+	}
+	r.body = resp.Body
+	return nil
+}
+
+// Close the object.
+func (r *Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
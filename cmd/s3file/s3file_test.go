@@ -0,0 +1,207 @@
+package s3file_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/s3file"
+	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name, bucket, key string
+		ok                bool
+	}{
+		{"s3://listings/2016/bucket.json.gz", "listings", "2016/bucket.json.gz", true},
+		{"bucket.json.gz", "", "", false},
+		{"/tmp/bucket.json.gz", "", "", false},
+		{"s3:///bucket.json.gz", "", "", false},
+	} {
+		bucket, key, ok := s3file.Parse(tt.name)
+		if bucket != tt.bucket || key != tt.key || ok != tt.ok {
+			t.Errorf("%q: want %q, %q, %v, got %q, %q, %v", tt.name, tt.bucket, tt.key, tt.ok, bucket, key, ok)
+		}
+	}
+}
+
+// flakyObject serves an object, dropping the connection after `chunk` bytes
+// of each response.
+type flakyObject struct {
+	t      *testing.T
+	data   []byte
+	chunk  int
+	ranges []string
+}
+
+func (f *flakyObject) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := 0
+	if rg := r.Header.Get("Range"); rg != "" {
+		f.ranges = append(f.ranges, rg)
+		if r.Header.Get("If-Match") != `"etag"` {
+			f.t.Errorf("want resumed requests to match the etag, got %q", r.Header.Get("If-Match"))
+		}
+		var err error
+		start, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rg, "bytes="), "-"))
+		if err != nil {
+			f.t.Fatalf("bad range %q: %v", rg, err)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(f.data)-1, len(f.data)))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(f.data)-start))
+	w.Header().Set("ETag", `"etag"`)
+	if start == 0 {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	end := start + f.chunk
+	if end > len(f.data) {
+		end = len(f.data)
+	}
+	_, _ = w.Write(f.data[start:end])
+	if end == len(f.data) {
+		return
+	}
+	w.(http.Flusher).Flush()
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		f.t.Fatalf("can't hijack connection: %v", err)
+	}
+	_ = conn.Close()
+}
+
+func TestReaderResumes(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	obj := &flakyObject{t: t, data: data, chunk: 3000}
+	srv := httptest.NewServer(obj)
+	defer srv.Close()
+
+	region := aws.USEast
+	region.S3Endpoint = srv.URL
+	bkt := s3.New(aws.Auth{AccessKey: "a", SecretKey: "b"}, region).Bucket("listings")
+
+	r, err := s3file.Open(bkt, "bucket.json.gz")
+	if err != nil {
+		t.Fatalf("can't open object: %v", err)
+	}
+	r.RetryBase = time.Millisecond
+	if r.Size() != int64(len(data)) {
+		t.Errorf("want size %d, got %d", len(data), r.Size())
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("can't read object: %v", err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Errorf("want the %d bytes of the object, got %d bytes", len(data), len(got))
+	}
+	want := []string{"bytes=3000-", "bytes=6000-", "bytes=9000-"}
+	if fmt.Sprint(want) != fmt.Sprint(obj.ranges) {
+		t.Errorf("want resumed ranges %v, got %v", want, obj.ranges)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("can't close object: %v", err)
+	}
+}
+
+// multipartObject serves a multipart upload, keeping its parts, and fails
+// the uploads of parts numbered `fail`.
+type multipartObject struct {
+	t         *testing.T
+	fail      int
+	parts     map[int][]byte
+	completed []byte
+	aborted   bool
+}
+
+func (m *multipartObject) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch {
+	case r.Method == "POST" && len(q["uploads"]) > 0:
+		m.parts = map[int][]byte{}
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == "PUT" && q.Get("uploadId") == "upload":
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		if n == m.fail {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>denied</Message></Error>`)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		m.parts[n] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == "POST" && q.Get("uploadId") == "upload":
+		var ns []int
+		for n := range m.parts {
+			ns = append(ns, n)
+		}
+		sort.Ints(ns)
+		for _, n := range ns {
+			m.completed = append(m.completed, m.parts[n]...)
+		}
+		fmt.Fprint(w, `<CompleteMultipartUploadResult></CompleteMultipartUploadResult>`)
+	case r.Method == "DELETE" && q.Get("uploadId") == "upload":
+		m.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		m.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	}
+}
+
+func TestWriterUploadsParts(t *testing.T) {
+	obj := &multipartObject{t: t}
+	srv := httptest.NewServer(obj)
+	defer srv.Close()
+
+	region := aws.USEast
+	region.S3Endpoint = srv.URL
+	bkt := s3.New(aws.Auth{AccessKey: "a", SecretKey: "b"}, region).Bucket("outputs")
+
+	w, err := s3file.Create(bkt, "synced.json.gz", "application/x-gzip")
+	if err != nil {
+		t.Fatalf("can't start upload: %v", err)
+	}
+	w.PartSize = 10
+	data := bytes.Repeat([]byte("0123456"), 5)
+	for i := 0; i < len(data); i += 7 {
+		if _, err := w.Write(data[i : i+7]); err != nil {
+			t.Fatalf("can't write: %v", err)
+		}
+	}
+	if len(obj.parts) != 3 {
+		t.Errorf("want the full parts uploaded while writing, got %d parts", len(obj.parts))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("can't complete upload: %v", err)
+	}
+	if !bytes.Equal(data, obj.completed) {
+		t.Errorf("want object %q, got %q", data, obj.completed)
+	}
+
+	// a part that fails aborts the upload
+	obj = &multipartObject{t: t, fail: 2}
+	srv.Config.Handler = obj
+	w, err = s3file.Create(bkt, "synced.json.gz", "application/x-gzip")
+	if err != nil {
+		t.Fatalf("can't start upload: %v", err)
+	}
+	w.PartSize = 10
+	if _, err := w.Write(data); err == nil {
+		t.Errorf("want an error when a part fails to upload")
+	}
+	if err := w.Close(); err == nil {
+		t.Errorf("want an error when closing a failed upload")
+	}
+	if !obj.aborted || obj.completed != nil {
+		t.Errorf("want the failed upload aborted, not completed")
+	}
+}
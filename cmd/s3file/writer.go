@@ -0,0 +1,96 @@
+package s3file
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+)
+
+// MinPartSize is the smallest size of the parts of a multipart upload, but
+// for the last one.
+const MinPartSize = 5 << 20
+
+// Writer uploads an object as it's written, in parts of PartSize, using a
+// multipart upload. The object only appears in the bucket once the writer
+// is closed. Until then, the parts uploaded so far are kept by S3 under the
+// upload ID, and can be completed by hand if the process dies.
+type Writer struct {
+	PartSize int
+
+	multi *s3.Multi
+	buf   bytes.Buffer
+	parts []s3.Part
+	err   error
+}
+
+// Create starts a multipart upload to a key of a bucket.
+func Create(bkt *s3.Bucket, key, contentType string) (*Writer, error) {
+	multi, err := bkt.InitMulti(key, contentType, s3.Private)
+	if err != nil {
+		return nil, fmt.Errorf("starting upload to s3://%s/%s: %v", bkt.Name, key, err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"bucket":    bkt.Name,
+		"key":       key,
+		"upload_id": multi.UploadId,
+	}).Info("started multipart upload")
+	return &Writer{PartSize: MinPartSize, multi: multi}, nil
+}
+
+// Write buffers p, uploading a part each time PartSize bytes are buffered.
+// Once an upload failed, all writes fail.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, _ := w.buf.Write(p)
+	for w.buf.Len() >= w.PartSize {
+		if err := w.upload(w.buf.Next(w.PartSize)); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// upload data as the next part.
+func (w *Writer) upload(data []byte) error {
+	n := len(w.parts) + 1
+	part, err := w.multi.PutPart(n, bytes.NewReader(data))
+	if err != nil {
+		w.err = fmt.Errorf("uploading part %d of s3://%s/%s: %v", n, w.multi.Bucket.Name, w.multi.Key, err)
+		return w.err
+	}
+	w.parts = append(w.parts, part)
+	return nil
+}
+
+// Close uploads the last part and completes the upload. If the upload
+// failed, it's aborted instead, deleting its parts.
+func (w *Writer) Close() error {
+	if w.err == nil && (w.buf.Len() > 0 || len(w.parts) == 0) {
+		// an upload needs at least one part, even if empty
+		_ = w.upload(w.buf.Next(w.buf.Len()))
+	}
+	if w.err != nil {
+		if err := w.Abort(); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":     err,
+				"upload_id": w.multi.UploadId,
+			}).Error("failed to abort multipart upload")
+		}
+		return w.err
+	}
+	if err := w.multi.Complete(w.parts); err != nil {
+		return fmt.Errorf("completing upload to s3://%s/%s: %v", w.multi.Bucket.Name, w.multi.Key, err)
+	}
+	return nil
+}
+
+// Abort the upload, deleting the parts uploaded so far.
+func (w *Writer) Abort() error {
+	if w.err == nil {
+		w.err = fmt.Errorf("upload to s3://%s/%s aborted", w.multi.Bucket.Name, w.multi.Key)
+	}
+	return w.multi.Abort()
+}
@@ -0,0 +1,502 @@
+// Package soak syncs a small synthetic workload between buckets over and
+// over, for hours, while injecting errors and latency in the sync calls,
+// restarting the syncs midway and rotating their credentials, and checks
+// after every round that no key was lost or synced twice. It's the
+// confidence to gain on the actual buckets before trusting brigade with a
+// production migration.
+package soak
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The kinds of invariant violations.
+const (
+	// Lost is a key reported synced whose copy is missing.
+	Lost = "lost"
+	// Stale is a key reported synced whose copy differs from it.
+	Stale = "stale"
+	// Duplicate is a key reported synced more than once in a round, though
+	// only the keys not reported yet are synced again after a restart.
+	Duplicate = "duplicate"
+	// Orphan is a copy without a key in the source: the source keys are
+	// never deleted, so every copy must have one.
+	Orphan = "orphan"
+)
+
+// maxRetry of the sync calls: a sync with more faults than that many
+// retries get through fails keys rather than going on forever.
+const maxRetry = 10
+
+// Config of a soak test.
+type Config struct {
+	// Prefix under which the keys are written, in both buckets. It must
+	// hold no keys: the synthetic keys are under its keys/ prefix, and
+	// their copies under its copies/ prefix.
+	Prefix string
+	// Keys written each round, of Size bytes each: new keys, and as many
+	// overwrites of the keys of the previous rounds, up to half of them.
+	Keys int
+	Size int64
+	// Duration of the soak test, and the most Rounds it runs, whichever
+	// comes first. Zero is no limit, but one of them must be set.
+	Duration time.Duration
+	Rounds   int
+	// Concurrency of the syncs, and of the writes of the keys.
+	Concurrency int
+	// Faults injected in the sync calls.
+	Faults sync.Faults
+	// RestartRate is the fraction of the syncs cancelled once a random
+	// number of their keys are synced, and started again with the keys they
+	// didn't report as synced.
+	RestartRate float64
+	// RotateRate is the fraction of the syncs during which Rotate is
+	// called, once a random number of their keys are synced.
+	RotateRate float64
+	Rotate     func() `json:"-"`
+	// Attempts at syncing the keys of a round, restarts included. The keys
+	// still not synced after them count as failed, not as lost.
+	Attempts int
+	// RetryBase of the retries of the sync calls.
+	RetryBase time.Duration
+	// Seed of the workload, the restarts and the rotations.
+	Seed int64
+}
+
+// Validate the config.
+func (c Config) Validate() error {
+	switch {
+	case c.Prefix == "" || !strings.HasSuffix(c.Prefix, "/"):
+		return fmt.Errorf("need a scratch prefix ending with /, got %q", c.Prefix)
+	case c.Keys < 1:
+		return fmt.Errorf("need at least a key, got %d", c.Keys)
+	case c.Size < 0:
+		return fmt.Errorf("size of the keys can't be negative, got %d", c.Size)
+	case c.Duration <= 0 && c.Rounds <= 0:
+		return errors.New("need a duration or a number of rounds")
+	case c.Concurrency < 1:
+		return fmt.Errorf("concurrency must be at least 1, got %d", c.Concurrency)
+	case c.RestartRate < 0 || c.RestartRate > 1:
+		return fmt.Errorf("restart rate must be between 0 and 1, got %v", c.RestartRate)
+	case c.RotateRate < 0 || c.RotateRate > 1:
+		return fmt.Errorf("rotate rate must be between 0 and 1, got %v", c.RotateRate)
+	case c.RotateRate > 0 && c.Rotate == nil:
+		return errors.New("need a func rotating the credentials to rotate them")
+	case c.Attempts < 1:
+		return fmt.Errorf("need at least 1 attempt, got %d", c.Attempts)
+	case c.RetryBase <= 0:
+		return fmt.Errorf("need a positive retry base, got %v", c.RetryBase)
+	}
+	return nil
+}
+
+// Violation of an invariant, found by a round.
+type Violation struct {
+	Round  int    `json:"round"`
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Round of a soak test: a write of the workload, and a sync of the source
+// keys.
+type Round struct {
+	Round int `json:"round"`
+	// Written keys, and those that failed to be written.
+	Written     int64 `json:"written"`
+	WriteFailed int64 `json:"write_failed"`
+	// Keys in the source, the keys Synced, and the keys Failed that were
+	// still not synced after all the attempts.
+	Keys      int64   `json:"keys"`
+	Synced    int64   `json:"synced"`
+	Failed    int64   `json:"failed"`
+	Attempts  int     `json:"attempts"`
+	Restarts  int     `json:"restarts"`
+	Rotations int     `json:"rotations"`
+	Seconds   float64 `json:"seconds"`
+	// Violations of the invariants found once the round is done.
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// Summary of a soak test.
+type Summary struct {
+	Rounds     int         `json:"rounds"`
+	Written    int64       `json:"written"`
+	Synced     int64       `json:"synced"`
+	Failed     int64       `json:"failed"`
+	Restarts   int         `json:"restarts"`
+	Rotations  int         `json:"rotations"`
+	Seconds    float64     `json:"seconds"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+func (s *Summary) add(r Round) {
+	s.Rounds++
+	s.Written += r.Written
+	s.Synced += r.Synced
+	s.Failed += r.Failed
+	s.Restarts += r.Restarts
+	s.Rotations += r.Rotations
+	s.Violations = append(s.Violations, r.Violations...)
+}
+
+// Soak runs the rounds of a soak test on a source and a destination, which
+// can be the same bucket.
+type Soak struct {
+	cfg      Config
+	src, dst *s3.Bucket
+	rnd      *rand.Rand
+	// keys written so far, the next one is named after written
+	written int
+	round   int
+}
+
+// New creates a soak test of the buckets.
+func New(src, dst *s3.Bucket, cfg Config) (*Soak, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &Soak{cfg: cfg, src: src, dst: dst, rnd: rand.New(rand.NewSource(cfg.Seed))}, nil
+}
+
+func (s *Soak) keysPrefix() string   { return s.cfg.Prefix + "keys/" }
+func (s *Soak) copiesPrefix() string { return s.cfg.Prefix + "copies/" }
+
+// Run the soak test: check that the scratch prefix holds no keys, run
+// rounds until the duration or the number of rounds of the config is
+// reached, and clean up. The rounds are passed to progress as they end, if
+// it's set. The keys written are deleted even when a round fails, and the
+// violations found don't fail the test, they're in the summary.
+func (s *Soak) Run(progress func(Round)) (Summary, error) {
+	var sum Summary
+	for _, bkt := range []*s3.Bucket{s.src, s.dst} {
+		resp, err := bkt.List(s.cfg.Prefix, "", "", 1)
+		if err != nil {
+			return sum, fmt.Errorf("listing scratch prefix %q of bucket %q: %v", s.cfg.Prefix, bkt.Name, err)
+		}
+		if len(resp.Contents) > 0 {
+			return sum, fmt.Errorf("scratch prefix %q of bucket %q already holds keys, need an empty one", s.cfg.Prefix, bkt.Name)
+		}
+	}
+
+	start := time.Now()
+	var err error
+	for s.cfg.Rounds <= 0 || sum.Rounds < s.cfg.Rounds {
+		if s.cfg.Duration > 0 && time.Since(start) >= s.cfg.Duration {
+			break
+		}
+		var r Round
+		r, err = s.Round()
+		sum.add(r)
+		if progress != nil {
+			progress(r)
+		}
+		if err != nil {
+			break
+		}
+	}
+	sum.Seconds = time.Since(start).Seconds()
+
+	if cleanErr := s.Cleanup(); err == nil {
+		err = cleanErr
+	}
+	return sum, err
+}
+
+// Round writes the workload, syncs the source keys, restarting the sync and
+// rotating the credentials at random, and checks the invariants.
+func (s *Soak) Round() (r Round, err error) {
+	s.round++
+	r = Round{Round: s.round}
+	start := time.Now()
+	defer func() { r.Seconds = time.Since(start).Seconds() }()
+
+	r.Written, r.WriteFailed = s.write()
+	keys, err := list(s.src, s.keysPrefix())
+	if err != nil {
+		return r, err
+	}
+	r.Keys = int64(len(keys))
+
+	// the keys are synced again until they're all reported synced, the
+	// ones reported twice are duplicates
+	reported := make(map[string]int, len(keys))
+	pending := keys
+	for r.Attempts < s.cfg.Attempts && len(pending) > 0 {
+		r.Attempts++
+		synced, restarted, rotated, err := s.sync(pending)
+		if err != nil {
+			return r, err
+		}
+		if restarted {
+			r.Restarts++
+		}
+		if rotated {
+			r.Rotations++
+		}
+		for _, key := range synced {
+			reported[key.Key]++
+			if reported[key.Key] == 2 {
+				r.Violations = append(r.Violations, Violation{Round: r.Round, Kind: Duplicate, Key: key.Key,
+					Detail: fmt.Sprintf("reported synced again by attempt %d", r.Attempts)})
+			}
+		}
+		var left []s3.Key
+		for _, key := range pending {
+			if reported[key.Key] == 0 {
+				left = append(left, key)
+			}
+		}
+		pending = left
+	}
+	r.Synced = int64(len(keys) - len(pending))
+	r.Failed = int64(len(pending))
+
+	violations, err := s.check(keys, reported)
+	for i := range violations {
+		violations[i].Round = r.Round
+	}
+	r.Violations = append(r.Violations, violations...)
+	return r, err
+}
+
+// write the keys of a round, new ones and overwrites of the older ones,
+// each of random content so that the copies of an overwritten key differ.
+func (s *Soak) write() (written, failed int64) {
+	type put struct {
+		name string
+		data []byte
+	}
+	overwrites := s.cfg.Keys / 2
+	if overwrites > s.written {
+		overwrites = s.written
+	}
+	puts := make([]put, 0, s.cfg.Keys)
+	for i := 0; i < s.cfg.Keys; i++ {
+		n := s.written
+		if i < overwrites {
+			n = s.rnd.Intn(s.written)
+		} else {
+			s.written++
+		}
+		data := make([]byte, s.cfg.Size)
+		_, _ = s.rnd.Read(data)
+		puts = append(puts, put{name: fmt.Sprintf("%s%08d", s.keysPrefix(), n), data: data})
+	}
+
+	todo := make(chan put, len(puts))
+	for _, p := range puts {
+		todo <- p
+	}
+	close(todo)
+	var workers pipeline.Workers
+	workers.Start(s.cfg.Concurrency, func(int) {
+		for p := range todo {
+			if err := s.src.Put(p.name, p.data, "application/octet-stream", s3.Private, s3.Options{}); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"key":   p.name,
+					"error": err,
+				}).Warn("couldn't put synthetic key")
+				atomic.AddInt64(&failed, 1)
+				continue
+			}
+			atomic.AddInt64(&written, 1)
+		}
+	})
+	workers.Wait()
+	return written, failed
+}
+
+// sync keys to their copies, with the faults of the config. The sync is
+// cancelled, or the credentials rotated, once a random number of the keys
+// are synced, as the rates of the config say.
+func (s *Soak) sync(keys []s3.Key) (synced []s3.Key, restarted, rotated bool, err error) {
+	var input bytes.Buffer
+	w, _ := listing.NewWriter(&input, listing.JSON)
+	for _, key := range keys {
+		if err := w.Write(key); err != nil {
+			return nil, false, false, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, false, false, err
+	}
+	task, err := sync.NewSyncTask(s.src, s.dst,
+		sync.WithConcurrency(s.cfg.Concurrency),
+		sync.WithMapping(sync.Mapping{From: s.keysPrefix(), To: s.copiesPrefix()}),
+		sync.WithCopier(sync.PutCopy),
+		sync.WithFaults(s.cfg.Faults),
+		sync.WithRetry(maxRetry, s.cfg.RetryBase),
+	)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	// the sync is restarted, or the credentials rotated, by the call that
+	// copies the nth key, so that at least one key is copied by every
+	// attempt. 0 is never.
+	var restartAt, rotateAt int64
+	if s.rnd.Float64() < s.cfg.RestartRate {
+		restartAt = 1 + s.rnd.Int63n(int64(len(keys)))
+	}
+	if s.rnd.Float64() < s.cfg.RotateRate {
+		rotateAt = 1 + s.rnd.Int63n(int64(len(keys)))
+	}
+	var copied int64
+	var restartedN, rotatedN int32
+	copyKey := task.Sync
+	task.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		err := copyKey(src, dst, key)
+		if err != nil {
+			return err
+		}
+		n := atomic.AddInt64(&copied, 1)
+		if n == rotateAt {
+			s.cfg.Rotate()
+			atomic.StoreInt32(&rotatedN, 1)
+		}
+		if n == restartAt {
+			task.Cancel()
+			atomic.StoreInt32(&restartedN, 1)
+		}
+		return nil
+	}
+
+	var out bytes.Buffer
+	err = task.Start(&input, &out, ioutil.Discard)
+	restarted, rotated = atomic.LoadInt32(&restartedN) == 1, atomic.LoadInt32(&rotatedN) == 1
+	if restarted && errors.Is(err, sync.ErrCancelled) {
+		err = nil
+	}
+	if err != nil {
+		return nil, restarted, rotated, fmt.Errorf("syncing keys: %v", err)
+	}
+	synced, err = decode(&out)
+	return synced, restarted, rotated, err
+}
+
+// check that every key reported synced has a copy of the same content, and
+// that every copy has a key in the source.
+func (s *Soak) check(keys []s3.Key, reported map[string]int) ([]Violation, error) {
+	copies, err := list(s.dst, s.copiesPrefix())
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]s3.Key, len(copies))
+	for _, c := range copies {
+		byName[c.Key] = c
+	}
+	var violations []Violation
+	for _, key := range keys {
+		name := s.copiesPrefix() + strings.TrimPrefix(key.Key, s.keysPrefix())
+		c, ok := byName[name]
+		delete(byName, name)
+		switch {
+		case reported[key.Key] == 0:
+			// failed, whichever state its copy is in
+		case !ok:
+			violations = append(violations, Violation{Kind: Lost, Key: key.Key, Detail: "no copy " + name})
+		case c.ETag != key.ETag || c.Size != key.Size:
+			violations = append(violations, Violation{Kind: Stale, Key: key.Key,
+				Detail: fmt.Sprintf("copy %s has ETag %s and %d bytes, want %s and %d bytes", name, c.ETag, c.Size, key.ETag, key.Size)})
+		}
+	}
+	for name := range byName {
+		violations = append(violations, Violation{Kind: Orphan, Key: name, Detail: "no source key"})
+	}
+	return violations, nil
+}
+
+// Cleanup deletes the synthetic keys and their copies.
+func (s *Soak) Cleanup() error {
+	for _, target := range []struct {
+		bkt    *s3.Bucket
+		prefix string
+	}{{s.src, s.keysPrefix()}, {s.dst, s.copiesPrefix()}} {
+		keys, err := list(target.bkt, target.prefix)
+		if err != nil {
+			return err
+		}
+		if err := s.delete(target.bkt, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete keys from a bucket with a DeleteTask.
+func (s *Soak) delete(bkt *s3.Bucket, keys []s3.Key) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	var input bytes.Buffer
+	w, _ := listing.NewWriter(&input, listing.JSON)
+	for _, key := range keys {
+		if err := w.Write(key); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	task, err := sync.NewDeleteTask(bkt)
+	if err != nil {
+		return err
+	}
+	task.DeletePara = s.cfg.Concurrency
+	task.RetryBase = s.cfg.RetryBase
+	if err := task.Start(&input, ioutil.Discard, ioutil.Discard); err != nil {
+		return fmt.Errorf("deleting %d keys from bucket %q: %v", len(keys), bkt.Name, err)
+	}
+	return nil
+}
+
+// list the keys under a prefix, a page at a time.
+func list(bkt *s3.Bucket, prefix string) ([]s3.Key, error) {
+	var keys []s3.Key
+	marker := ""
+	for {
+		resp, err := bkt.List(prefix, "", marker, 1000)
+		if err != nil {
+			return keys, fmt.Errorf("listing %q of bucket %q: %v", prefix, bkt.Name, err)
+		}
+		keys = append(keys, resp.Contents...)
+		if !resp.IsTruncated || len(resp.Contents) == 0 {
+			return keys, nil
+		}
+		marker = resp.Contents[len(resp.Contents)-1].Key
+	}
+}
+
+// decode the keys of a JSON listing.
+func decode(r *bytes.Buffer) ([]s3.Key, error) {
+	var keys []s3.Key
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var key s3.Key
+		err := rd.Read(&key)
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return keys, fmt.Errorf("decoding synced keys: %v", err)
+		}
+		keys = append(keys, key)
+	}
+}
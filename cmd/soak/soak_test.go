@@ -0,0 +1,104 @@
+package soak_test
+
+import (
+	"github.com/Shopify/brigade/cmd/soak"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSoak(t *testing.T) {
+	defer time.AfterFunc(time.Second*30, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src, dst := mocks3.S3().Bucket("src-bucket"), mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	var rotated int32
+	s, err := soak.New(src, dst, soak.Config{
+		Prefix:      "soak/",
+		Keys:        20,
+		Size:        64,
+		Rounds:      3,
+		Concurrency: 4,
+		Faults:      sync.Faults{ErrorRate: 0.1, Errors: []string{s3.ErrInternalError}, DropRate: 0.05, Seed: 42},
+		RestartRate: 1,
+		RotateRate:  1,
+		Rotate:      func() { atomic.AddInt32(&rotated, 1) },
+		Attempts:    10,
+		RetryBase:   time.Millisecond,
+		Seed:        1,
+	})
+	if err != nil {
+		t.Fatalf("can't create soak test: %v", err)
+	}
+	var rounds []soak.Round
+	sum, err := s.Run(func(r soak.Round) { rounds = append(rounds, r) })
+	if err != nil {
+		t.Fatalf("can't soak: %v", err)
+	}
+	if len(sum.Violations) != 0 {
+		t.Errorf("want no violations, got %+v", sum.Violations)
+	}
+	// 20 keys, then 10 new ones and 10 overwrites each round
+	if len(rounds) != 3 || rounds[0].Keys != 20 || rounds[1].Keys != 30 || rounds[2].Keys != 40 {
+		t.Fatalf("want rounds of 20, 30 and 40 keys, got %+v", rounds)
+	}
+	if sum.Written != 60 || sum.Synced != 90 || sum.Failed != 0 {
+		t.Errorf("want 60 keys written and 90 synced, got %+v", sum)
+	}
+	if sum.Restarts < 3 || sum.Rotations == 0 || int(atomic.LoadInt32(&rotated)) != sum.Rotations {
+		t.Errorf("want every round restarted and the credentials rotated, got %+v and %d rotations", sum, rotated)
+	}
+	for name, bkt := range mocks3.ListBuckets() {
+		if len(bkt.Objects) != 0 {
+			t.Errorf("want the keys of bucket %q cleaned up, got %d", name, len(bkt.Objects))
+		}
+	}
+}
+
+func TestSoakViolations(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	bkt := mocks3.S3().Bucket("bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	s, err := soak.New(bkt, bkt, soak.Config{Prefix: "soak/", Keys: 5, Rounds: 2, Concurrency: 2, Attempts: 1, RetryBase: time.Millisecond})
+	if err != nil {
+		t.Fatalf("can't create soak test: %v", err)
+	}
+	if r, err := s.Round(); err != nil || len(r.Violations) != 0 {
+		t.Fatalf("want a round without violations, got %+v, %v", r, err)
+	}
+
+	// a copy of a key that was never written
+	if err := bkt.Put("soak/copies/stray", []byte("stray"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Round()
+	if err != nil {
+		t.Fatalf("can't run round: %v", err)
+	}
+	if len(r.Violations) != 1 || r.Violations[0].Kind != soak.Orphan || r.Violations[0].Key != "soak/copies/stray" || r.Violations[0].Round != 2 {
+		t.Errorf("want the stray copy found, got %+v", r.Violations)
+	}
+
+	// the scratch prefix must be empty to start
+	if _, err := s.Run(nil); err == nil || !strings.Contains(err.Error(), "already holds keys") {
+		t.Errorf("want a scratch prefix holding keys refused, got %v", err)
+	}
+}
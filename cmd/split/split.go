@@ -0,0 +1,124 @@
+// Package split partitions a key listing into listings that hold about the
+// same bytes, so that the machines or the runs that sync them each get an
+// equal share of the transfers, rather than an equal share of the keys.
+package split
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"os"
+)
+
+// Part of a split listing.
+type Part struct {
+	Filename string `json:"filename"`
+	Keys     int64  `json:"keys"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Split the keys of a listing into a listing per filename, gzip'd in a
+// format. Each key goes to the part holding the fewest bytes so far, or the
+// fewest keys between parts with as many bytes, so the bytes of the parts
+// differ by at most the size of the largest key, while the listing is only
+// read once.
+func Split(r listing.Reader, filenames []string, format string) ([]*Part, error) {
+	if len(filenames) < 2 {
+		return nil, fmt.Errorf("need to split in at least 2 parts, got %d", len(filenames))
+	}
+	parts := make([]*Part, 0, len(filenames))
+	outputs := make([]*output, 0, len(filenames))
+	closeAll := func() error {
+		var cerr error
+		for _, out := range outputs {
+			if err := out.Close(); err != nil && cerr == nil {
+				cerr = err
+			}
+		}
+		return cerr
+	}
+	for _, filename := range filenames {
+		part := &Part{Filename: filename}
+		out, err := createOutput(part, format)
+		if err != nil {
+			_ = closeAll()
+			return nil, err
+		}
+		parts = append(parts, part)
+		outputs = append(outputs, out)
+	}
+
+	var key s3.Key
+	for {
+		err := r.Read(&key)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = closeAll()
+			return nil, fmt.Errorf("reading listing: %v", err)
+		}
+		out := outputs[lightest(parts)]
+		if err := out.w.Write(key); err != nil {
+			_ = closeAll()
+			return nil, fmt.Errorf("writing %q: %v", out.part.Filename, err)
+		}
+		out.part.Keys++
+		out.part.Bytes += key.Size
+	}
+	if err := closeAll(); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// lightest is the index of the part with the fewest bytes, then the fewest
+// keys.
+func lightest(parts []*Part) int {
+	min := 0
+	for i, part := range parts[1:] {
+		switch {
+		case part.Bytes < parts[min].Bytes:
+			min = i + 1
+		case part.Bytes == parts[min].Bytes && part.Keys < parts[min].Keys:
+			min = i + 1
+		}
+	}
+	return min
+}
+
+// output writes the listing of a part.
+type output struct {
+	part *Part
+	file *os.File
+	gz   *gzip.Writer
+	w    listing.Writer
+}
+
+func createOutput(part *Part, format string) (*output, error) {
+	file, err := os.Create(part.Filename)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(file)
+	w, err := listing.NewWriter(gz, format)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &output{part: part, file: file, gz: gz, w: w}, nil
+}
+
+func (o *output) Close() error {
+	if err := o.w.Flush(); err != nil {
+		_ = o.file.Close()
+		return fmt.Errorf("flushing %q: %v", o.part.Filename, err)
+	}
+	if err := o.gz.Close(); err != nil {
+		_ = o.file.Close()
+		return fmt.Errorf("closing gzip of %q: %v", o.part.Filename, err)
+	}
+	return o.file.Close()
+}
@@ -0,0 +1,130 @@
+package split_test
+
+import (
+	"compress/gzip"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/split"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// keysReader reads keys from a slice, as a listing.
+type keysReader []s3.Key
+
+func (r *keysReader) Read(key *s3.Key) error {
+	if len(*r) == 0 {
+		return io.EOF
+	}
+	*key = (*r)[0]
+	*r = (*r)[1:]
+	return nil
+}
+
+func readPart(t *testing.T, filename string) []s3.Key {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("can't open part: %v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("can't read gzip: %v", err)
+	}
+	rd, err := listing.NewReader(gz)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+	var keys []s3.Key
+	for {
+		var key s3.Key
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			return keys
+		case nil:
+			keys = append(keys, key)
+		default:
+			t.Fatalf("can't read key: %v", err)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a few large keys among many small ones, which a split by key count
+	// would put all in the first part
+	var keys []s3.Key
+	var largest int64
+	for i := 0; i < 1000; i++ {
+		size := int64(i%7) * 10
+		if i%100 == 0 {
+			size = 5000
+		}
+		if size > largest {
+			largest = size
+		}
+		keys = append(keys, s3.Key{Key: "key-" + strconv.Itoa(i), Size: size})
+	}
+	filenames := []string{
+		filepath.Join(dir, "0_list.bin.gz"),
+		filepath.Join(dir, "1_list.bin.gz"),
+		filepath.Join(dir, "2_list.bin.gz"),
+	}
+	rd := keysReader(keys)
+	parts, err := split.Split(&rd, filenames, listing.Binary)
+	if err != nil {
+		t.Fatalf("can't split: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	min, max := parts[0].Bytes, parts[0].Bytes
+	for i, part := range parts {
+		if part.Filename != filenames[i] {
+			t.Errorf("want part %d in %q, got %q", i, filenames[i], part.Filename)
+		}
+		var bytes int64
+		got := readPart(t, part.Filename)
+		for _, key := range got {
+			if seen[key.Key] {
+				t.Errorf("key %q in many parts", key.Key)
+			}
+			seen[key.Key] = true
+			bytes += key.Size
+		}
+		if int64(len(got)) != part.Keys || bytes != part.Bytes {
+			t.Errorf("want part %d to hold %d keys of %d bytes, got %d of %d", i, part.Keys, part.Bytes, len(got), bytes)
+		}
+		if part.Bytes < min {
+			min = part.Bytes
+		}
+		if part.Bytes > max {
+			max = part.Bytes
+		}
+	}
+	if len(seen) != len(keys) {
+		t.Errorf("want all %d keys split, got %d", len(keys), len(seen))
+	}
+	if max-min > largest {
+		t.Errorf("want the parts within %d bytes of each other, got %+v %+v %+v", largest, *parts[0], *parts[1], *parts[2])
+	}
+
+	// keys without a size are split by count
+	empty := keysReader(make([]s3.Key, 10))
+	parts, err = split.Split(&empty, filenames[:2], listing.JSON)
+	if err != nil || parts[0].Keys != 5 || parts[1].Keys != 5 {
+		t.Errorf("want 5 keys in each part, got %v", err)
+	}
+
+	if _, err := split.Split(&empty, filenames[:1], listing.JSON); err == nil {
+		t.Errorf("want at least 2 parts")
+	}
+}
@@ -0,0 +1,191 @@
+package state
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// cipherName is the cipher of the records of an encrypted journal.
+	cipherName = "aes-256-gcm"
+	// kdfIterations of PBKDF2 that derive the key of a journal from a
+	// passphrase.
+	kdfIterations = 200000
+	keySize       = 32
+	saltSize      = 16
+	// checkValue is sealed in the header of an encrypted journal, to tell a
+	// wrong passphrase or key apart from a corrupt record.
+	checkValue = "brigade state"
+)
+
+// Encryption of the records of a store at rest, for the syncs of keys whose
+// names are themselves sensitive. The key of the records is derived from
+// Passphrase, or is a data key generated by KMS, and only the data key
+// encrypted by KMS is stored in the journal.
+type Encryption struct {
+	Passphrase string
+	KMS        KeyService
+}
+
+// KeyService generates the data keys of the journals, and decrypts them,
+// such as kms.Client.
+type KeyService interface {
+	GenerateDataKey() (key, encrypted []byte, err error)
+	Decrypt(encrypted []byte) ([]byte, error)
+}
+
+// header is the first line of an encrypted journal, which tells how to get
+// the key of its records. The records that follow it are sealed one per
+// line, so that the journal can still be appended to, and a truncated last
+// record ignored.
+type header struct {
+	Cipher     string `json:"encrypted"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	DataKey    []byte `json:"data_key,omitempty"`
+	Check      string `json:"check"`
+}
+
+// parseHeader is true when a line of a journal is the header of an
+// encrypted one.
+func parseHeader(line []byte) (header, bool) {
+	var h header
+	if json.Unmarshal(line, &h) != nil || h.Cipher == "" {
+		return header{}, false
+	}
+	return h, true
+}
+
+// newHeader creates the key of a new encrypted journal, and the header that
+// finds it again.
+func newHeader(enc Encryption) (header, cipher.AEAD, error) {
+	h := header{Cipher: cipherName}
+	var key []byte
+	switch {
+	case enc.Passphrase != "" && enc.KMS != nil:
+		return header{}, nil, errors.New("encrypt with either a passphrase or a KMS key, not both")
+	case enc.Passphrase != "":
+		h.Salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, h.Salt); err != nil {
+			return header{}, nil, err
+		}
+		h.Iterations = kdfIterations
+		key = pbkdf2([]byte(enc.Passphrase), h.Salt, h.Iterations, keySize)
+	case enc.KMS != nil:
+		var err error
+		if key, h.DataKey, err = enc.KMS.GenerateDataKey(); err != nil {
+			return header{}, nil, err
+		}
+	default:
+		return header{}, nil, errors.New("need a passphrase or a KMS key to encrypt with")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return header{}, nil, err
+	}
+	check, err := seal(aead, []byte(checkValue))
+	if err != nil {
+		return header{}, nil, err
+	}
+	h.Check = string(check)
+	return h, aead, nil
+}
+
+// open gets the key of the records of the journal of the header.
+func (h header) open(enc Encryption) (cipher.AEAD, error) {
+	if h.Cipher != cipherName {
+		return nil, fmt.Errorf("unknown cipher %q", h.Cipher)
+	}
+	var key []byte
+	switch {
+	case h.DataKey != nil:
+		if enc.KMS == nil {
+			return nil, errors.New("state file is encrypted with a KMS data key, need KMS to decrypt it")
+		}
+		var err error
+		if key, err = enc.KMS.Decrypt(h.DataKey); err != nil {
+			return nil, err
+		}
+	case enc.Passphrase == "":
+		return nil, errors.New("state file is encrypted with a passphrase, need the passphrase")
+	default:
+		key = pbkdf2([]byte(enc.Passphrase), h.Salt, h.Iterations, keySize)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if check, err := unseal(aead, []byte(h.Check)); err != nil || string(check) != checkValue {
+		return nil, errors.New("wrong passphrase or key for the state file")
+	}
+	return aead, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("want a key of %d bytes, got %d", keySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal a record, as a line of base64 of its nonce and ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(out, sealed)
+	return out, nil
+}
+
+// unseal a record that seal sealed.
+func unseal(aead cipher.AEAD, line []byte) ([]byte, error) {
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[:n]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed record too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// pbkdf2 derives a key from a password with HMAC-SHA256, see RFC 8018.
+func pbkdf2(password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		_ = binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
@@ -0,0 +1,393 @@
+package state
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+)
+
+// The records a store holds beyond those in memory are spilled to runs:
+// temporary files of entries sorted by the hash of their key, each a record
+// as it is written in the journal, sealed when the store is encrypted. A
+// run keeps the hash and offset of every indexEvery'th entry in memory, so
+// that finding a key reads a block of a run at most, and a bloom filter of
+// its keys, so that it's only read for a key it likely has. That's about
+// 1.5 bytes of memory a key of the runs. The newest runs are
+// merged in the background when they hold about as many entries as the
+// one before them, so that there are only a few runs, of growing sizes.
+//
+// The files of the runs are removed as soon as they're created, so that
+// they're gone once the store is closed, or the process dies.
+const (
+	indexEvery = 64
+	// bits of the bloom filters a key, and hashes of a key, for about 1%
+	// of false positives
+	bloomBits   = 10
+	bloomHashes = 7
+)
+
+// bloom filter of the hashes of the keys of a run.
+type bloom []uint64
+
+func newBloom(keys int) bloom {
+	return make(bloom, (keys*bloomBits+63)/64+1)
+}
+
+// positions of a hash, by double hashing its halves.
+func (b bloom) positions(hash uint64, fn func(uint64) bool) bool {
+	h1, h2 := hash, hash>>32|1
+	n := uint64(len(b)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		if !fn((h1 + i*h2) % n) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b bloom) add(hash uint64) {
+	b.positions(hash, func(p uint64) bool { b[p/64] |= 1 << (p % 64); return true })
+}
+
+func (b bloom) has(hash uint64) bool {
+	return b.positions(hash, func(p uint64) bool { return b[p/64]&(1<<(p%64)) != 0 })
+}
+
+// entry of a run: the hash of a key and its record as a journal line.
+type entry struct {
+	hash uint64
+	data []byte
+	// age of the source of the entry in a merge, the newest source has the
+	// highest
+	age int
+}
+
+type indexEntry struct {
+	hash   uint64
+	offset int64
+}
+
+type run struct {
+	file  *os.File
+	size  int64
+	count int
+	index []indexEntry
+	bloom bloom
+	// refs is the holders of the run, the store and its snapshots; the file
+	// is closed once there's none
+	refs int32
+}
+
+func (r *run) acquire() { atomic.AddInt32(&r.refs, 1) }
+
+func (r *run) release() {
+	if atomic.AddInt32(&r.refs, -1) == 0 {
+		_ = r.file.Close()
+	}
+}
+
+// source of entries, sorted by hash.
+type source interface {
+	next() (entry, bool, error)
+}
+
+// runReader reads the entries of a run from an offset on.
+type runReader struct {
+	rd *bufio.Reader
+}
+
+func (r *run) reader(offset int64) *runReader {
+	return &runReader{rd: bufio.NewReaderSize(io.NewSectionReader(r.file, offset, r.size-offset), 4096)}
+}
+
+func (r *runReader) next() (entry, bool, error) {
+	return r.nextFrom(0)
+}
+
+// nextFrom reads the next entry, but the data of those whose hash is lower
+// than from.
+func (r *runReader) nextFrom(from uint64) (entry, bool, error) {
+	for {
+		var head [12]byte
+		if _, err := io.ReadFull(r.rd, head[:]); err == io.EOF {
+			return entry{}, false, nil
+		} else if err != nil {
+			return entry{}, false, err
+		}
+		hash, size := binary.BigEndian.Uint64(head[:8]), int(binary.BigEndian.Uint32(head[8:]))
+		if hash < from {
+			if _, err := r.rd.Discard(size); err != nil {
+				return entry{}, false, err
+			}
+			continue
+		}
+		e := entry{hash: hash, data: make([]byte, size)}
+		if _, err := io.ReadFull(r.rd, e.data); err != nil {
+			return entry{}, false, err
+		}
+		return e, true, nil
+	}
+}
+
+// entries of the memory of a store, sorted by hash.
+type entries []entry
+
+func (e *entries) next() (entry, bool, error) {
+	if len(*e) == 0 {
+		return entry{}, false, nil
+	}
+	next := (*e)[0]
+	*e = (*e)[1:]
+	return next, true, nil
+}
+
+// memEntries are the records in memory as entries, sorted by hash.
+func (s *Store) memEntries() (entries, error) {
+	out := make(entries, 0, len(s.mem))
+	for name, rec := range s.mem {
+		data, err := s.encodeRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, entry{hash: s.hash(name), data: data})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].hash < out[j].hash })
+	return out, nil
+}
+
+// writeRun writes the entries that write passes to its func, sorted by
+// hash, to a new run next to the journal. The run is expected to have keys
+// entries at most.
+func (s *Store) writeRun(keys int, write func(func(entry) error) error) (*run, error) {
+	file, err := ioutil.TempFile(filepath.Dir(s.filename), filepath.Base(s.filename)+".run")
+	if err != nil {
+		return nil, fmt.Errorf("creating run of state file: %v", err)
+	}
+	_ = os.Remove(file.Name())
+	r := &run{file: file, bloom: newBloom(keys), refs: 1}
+	buf := bufio.NewWriterSize(file, 64*1024)
+	err = write(func(e entry) error {
+		r.bloom.add(e.hash)
+		if r.count%indexEvery == 0 {
+			r.index = append(r.index, indexEntry{hash: e.hash, offset: r.size})
+		}
+		var head [12]byte
+		binary.BigEndian.PutUint64(head[:8], e.hash)
+		binary.BigEndian.PutUint32(head[8:], uint32(len(e.data)))
+		if _, err := buf.Write(head[:]); err != nil {
+			return err
+		}
+		if _, err := buf.Write(e.data); err != nil {
+			return err
+		}
+		r.count++
+		r.size += int64(len(head) + len(e.data))
+		return nil
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("writing run of state file: %v", err)
+	}
+	return r, nil
+}
+
+// spill the records in memory to a run.
+func (s *Store) spill() error {
+	mem, err := s.memEntries()
+	if err != nil {
+		return err
+	}
+	r, err := s.writeRun(len(mem), func(fn func(entry) error) error {
+		for _, e := range mem {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, r)
+	s.mem = make(map[string]Record)
+	s.mergeRuns()
+	return nil
+}
+
+// mergeRuns merges the newest pair of runs of about the same size, in the
+// background, unless a merge is running already.
+func (s *Store) mergeRuns() {
+	if s.merging {
+		return
+	}
+	i := len(s.runs) - 2
+	for ; i >= 0; i-- {
+		if s.runs[i+1].count*2 >= s.runs[i].count {
+			break
+		}
+	}
+	if i < 0 {
+		return
+	}
+	older, newer := s.runs[i], s.runs[i+1]
+	older.acquire()
+	newer.acquire()
+	s.merging = true
+	s.merges.Add(1)
+	go func() {
+		defer s.merges.Done()
+		merged, err := s.writeRun(older.count+newer.count, func(fn func(entry) error) error {
+			return s.merge([]source{older.reader(0), newer.reader(0)}, fn)
+		})
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.merging = false
+		older.release()
+		newer.release()
+		if err != nil {
+			// the runs are left as they are, merging them again with the
+			// next spill
+			logrus.WithField("error", err).Error("couldn't merge runs of state file")
+			return
+		}
+		// runs spilled during the merge are after the pair
+		for j := range s.runs {
+			if s.runs[j] == older {
+				s.runs = append(s.runs[:j], append([]*run{merged}, s.runs[j+2:]...)...)
+				break
+			}
+		}
+		older.release()
+		newer.release()
+		if !s.closed {
+			s.mergeRuns()
+		}
+	}()
+}
+
+// lookup the record of a key in the runs, the newest first.
+func (s *Store) lookup(key string) (Record, bool, error) {
+	hash := s.hash(key)
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		r := s.runs[i]
+		if !r.bloom.has(hash) {
+			continue
+		}
+		// the entries of hash can start in the block before the first
+		// indexed at or after it
+		j := sort.Search(len(r.index), func(j int) bool { return r.index[j].hash >= hash })
+		if j > 0 {
+			j--
+		}
+		if len(r.index) == 0 || r.index[j].hash > hash {
+			continue
+		}
+		rd := r.reader(r.index[j].offset)
+		for {
+			e, ok, err := rd.nextFrom(hash)
+			if err != nil {
+				return Record{}, false, err
+			}
+			if !ok || e.hash > hash {
+				break
+			}
+			rec, err := s.decodeRecord(e.data)
+			if err != nil {
+				return Record{}, false, err
+			}
+			if rec.Key.Key == key {
+				return rec, true, nil
+			}
+		}
+	}
+	return Record{}, false, nil
+}
+
+// errStop stops a merge early.
+var errStop = errors.New("stop")
+
+// merge the sources, the oldest first, passing fn the entry of each key in
+// the order of their hash, the one of the newest source when many have it.
+func (s *Store) merge(srcs []source, fn func(entry) error) error {
+	heads := make([]*entry, len(srcs))
+	advance := func(i int) error {
+		e, ok, err := srcs[i].next()
+		if err != nil {
+			return err
+		}
+		heads[i] = nil
+		if ok {
+			e.age = i
+			heads[i] = &e
+		}
+		return nil
+	}
+	for i := range srcs {
+		if err := advance(i); err != nil {
+			return err
+		}
+	}
+	var group []entry
+	for {
+		var (
+			min   uint64
+			found bool
+		)
+		for _, h := range heads {
+			if h != nil && (!found || h.hash < min) {
+				min, found = h.hash, true
+			}
+		}
+		if !found {
+			return nil
+		}
+		group = group[:0]
+		for i := range srcs {
+			for heads[i] != nil && heads[i].hash == min {
+				group = append(group, *heads[i])
+				if err := advance(i); err != nil {
+					return err
+				}
+			}
+		}
+		if len(group) == 1 {
+			if err := fn(group[0]); err != nil {
+				return err
+			}
+			continue
+		}
+		// the same key in many sources, or keys of the same hash
+		newest := make(map[string]entry, len(group))
+		var names []string
+		for _, e := range group {
+			rec, err := s.decodeRecord(e.data)
+			if err != nil {
+				return err
+			}
+			name := rec.Key.Key
+			old, ok := newest[name]
+			if !ok {
+				names = append(names, name)
+			}
+			if !ok || e.age >= old.age {
+				newest[name] = e
+			}
+		}
+		for _, name := range names {
+			if err := fn(newest[name]); err != nil {
+				return err
+			}
+		}
+	}
+}
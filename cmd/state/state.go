@@ -0,0 +1,518 @@
+// Package state keeps a durable record of the status of each key of a sync,
+// so that a sync can be resumed exactly where it stopped, failed keys can be
+// re-driven and the outcome of a sync can be queried after the fact.
+//
+// The store is a journal of JSON records, one per line, appended to as the
+// status of keys change, flushed to disk every SyncEvery. When opened, the
+// journal is replayed and the last record of each key wins. The last
+// records are held in memory, up to a number of them, and the others are
+// spilled to temporary files sorted by a hash of their key, so that the
+// store holds listings of any size in bounded memory. Compact rewrites the
+// journal with only the last record of each key.
+//
+// A store opened with an Encryption keeps its records encrypted at rest: the
+// journal starts with a header line that tells how to get its key, and
+// each record is then sealed on a line of its own.
+package state
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"hash/maphash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMaxRecords is the most records a store holds in memory by default,
+// a few hundred MB of them.
+const DefaultMaxRecords = 500000
+
+// Status of a key in a sync.
+type Status string
+
+// The statuses a key goes through during a sync.
+const (
+	Pending = Status("pending")
+	Synced  = Status("synced")
+	Failed  = Status("failed")
+)
+
+// Record is the state of a key at some point in time.
+type Record struct {
+	Key       s3.Key    `json:"key"`
+	Status    Status    `json:"status"`
+	Retries   int       `json:"retries,omitempty"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Store persists the records of a sync to a journal file.
+type Store struct {
+	mu       sync.RWMutex
+	filename string
+	file     *os.File
+	buf      *bufio.Writer
+	// the last records, and the runs of those spilled from memory, the
+	// oldest first
+	mem        map[string]Record
+	maxRecords int
+	runs       []*run
+	seed       maphash.Seed
+	merging    bool
+	merges     sync.WaitGroup
+	// keys is the number of keys, in memory or not
+	keys   int
+	closed bool
+	done   chan struct{}
+	// header and aead are set when the records are encrypted.
+	header *header
+	aead   cipher.AEAD
+}
+
+// Option configures a Store when it's opened.
+type Option func(*Store)
+
+// WithMaxRecords holds at most n records in memory, rather than
+// DefaultMaxRecords, spilling the others to disk next to the journal.
+func WithMaxRecords(n int) Option {
+	return func(s *Store) {
+		if n > 0 {
+			s.maxRecords = n
+		}
+	}
+}
+
+// Open the store in filename, creating it if it doesn't exist. The records
+// already in the journal are replayed.
+func Open(filename string, opts ...Option) (*Store, error) {
+	return open(filename, nil, opts)
+}
+
+// OpenEncrypted opens the store in filename like Open, with its records
+// encrypted at rest. A new store is encrypted with the passphrase or a data
+// key of KMS, and an existing one must have been encrypted with the same
+// passphrase, or KMS must be able to decrypt its data key.
+func OpenEncrypted(filename string, enc Encryption, opts ...Option) (*Store, error) {
+	return open(filename, &enc, opts)
+}
+
+// IsEncrypted is true when the journal in filename is encrypted.
+func IsEncrypted(filename string) (bool, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = file.Close() }()
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	_, encrypted := parseHeader(line)
+	return encrypted, nil
+}
+
+func open(filename string, enc *Encryption, opts []Option) (*Store, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("opening state file %q: %v", filename, err)
+	}
+	s := &Store{
+		filename:   filename,
+		file:       file,
+		buf:        bufio.NewWriter(file),
+		mem:        make(map[string]Record),
+		maxRecords: DefaultMaxRecords,
+		seed:       maphash.MakeSeed(),
+		done:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// runs spilled while replaying are merged meanwhile
+	s.mu.Lock()
+	empty, err := s.replay(file, enc)
+	s.mu.Unlock()
+	if err != nil {
+		_ = s.Close()
+		return nil, fmt.Errorf("replaying state file %q: %v", filename, err)
+	}
+	if enc != nil && empty {
+		h, aead, err := newHeader(*enc)
+		if err == nil {
+			s.header, s.aead = &h, aead
+			err = s.writeHeader(s.buf)
+		}
+		if err == nil {
+			err = s.flush()
+		}
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("encrypting state file %q: %v", filename, err)
+		}
+	}
+	return s, nil
+}
+
+// replay the journal, ignoring a truncated last record, which can happen
+// if the process died in the middle of a write. It's true when the journal
+// is empty.
+func (s *Store) replay(r io.Reader, enc *Encryption) (bool, error) {
+	rd := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) != 0 {
+				logrus.WithField("line", line).Warn("ignoring truncated record at end of state file")
+			}
+			return line == 1, nil
+		} else if err != nil {
+			return false, err
+		}
+		data = data[:len(data)-1]
+
+		if line == 1 {
+			h, encrypted := parseHeader(data)
+			switch {
+			case encrypted && enc == nil:
+				return false, errors.New("state file is encrypted, need its passphrase or KMS key")
+			case encrypted:
+				if s.aead, err = h.open(*enc); err != nil {
+					return false, err
+				}
+				s.header = &h
+				continue
+			case enc != nil:
+				return false, errors.New("state file isn't encrypted")
+			}
+		}
+		rec, err := s.decodeRecord(data)
+		if err != nil {
+			return false, fmt.Errorf("record on line %d: %v", line, err)
+		}
+		if err := s.index(rec); err != nil {
+			return false, err
+		}
+	}
+}
+
+// decodeRecord decodes a line of the journal, unsealing it if it's
+// encrypted.
+func (s *Store) decodeRecord(data []byte) (Record, error) {
+	var rec Record
+	if s.aead != nil {
+		var err error
+		if data, err = unseal(s.aead, data); err != nil {
+			return rec, fmt.Errorf("decrypting record: %v", err)
+		}
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("decoding record: %v", err)
+	}
+	return rec, nil
+}
+
+// index a record as the last of its key, spilling the records in memory
+// once there are too many.
+func (s *Store) index(rec Record) error {
+	if _, ok := s.mem[rec.Key.Key]; !ok {
+		_, found, err := s.lookup(rec.Key.Key)
+		if err != nil {
+			return fmt.Errorf("looking up key %q: %v", rec.Key.Key, err)
+		}
+		if !found {
+			s.keys++
+		}
+	}
+	s.mem[rec.Key.Key] = rec
+	if len(s.mem) >= s.maxRecords {
+		return s.spill()
+	}
+	return nil
+}
+
+// hash of the name of a key, the order of the runs.
+func (s *Store) hash(key string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(s.seed)
+	_, _ = h.WriteString(key)
+	return h.Sum64()
+}
+
+// writeHeader writes the header of the journal, if it's encrypted.
+func (s *Store) writeHeader(w io.Writer) error {
+	if s.header == nil {
+		return nil
+	}
+	data, err := json.Marshal(s.header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// encodeRecord encodes a record as a line of the journal, sealed if it's
+// encrypted.
+func (s *Store) encodeRecord(rec Record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead != nil {
+		if data, err = seal(s.aead, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// Put a record in the store, replacing the previous record for the same key.
+func (s *Store) Put(rec Record) error {
+	if rec.Updated.IsZero() {
+		rec.Updated = time.Now().UTC()
+	}
+	data, err := s.encodeRecord(rec)
+	if err != nil {
+		return fmt.Errorf("encoding record for key %q: %v", rec.Key.Key, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.buf.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("appending record for key %q: %v", rec.Key.Key, err)
+	}
+	return s.index(rec)
+}
+
+// Get the last record of a key.
+func (s *Store) Get(key string) (Record, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if rec, ok := s.mem[key]; ok {
+		return rec, true
+	}
+	rec, ok, err := s.lookup(key)
+	if err != nil {
+		// the runs are temporary files the store wrote, they can't be
+		// read when the disk fails
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"key":   key,
+		}).Error("couldn't look up the record of key in state file")
+	}
+	return rec, ok
+}
+
+// Len is the number of keys in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+// Each calls f with the last record of every key, in no particular order,
+// until f returns false. It reads a snapshot of the store, which can be
+// written to meanwhile.
+func (s *Store) Each(f func(Record) bool) error {
+	srcs, release, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = s.merge(srcs, func(e entry) error {
+		rec, err := s.decodeRecord(e.data)
+		if err != nil {
+			return err
+		}
+		if !f(rec) {
+			return errStop
+		}
+		return nil
+	})
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+// snapshot of the records of the store, as the sources of a merge, which
+// must be released once read.
+func (s *Store) snapshot() ([]source, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem, err := s.memEntries()
+	if err != nil {
+		return nil, nil, err
+	}
+	runs := append([]*run(nil), s.runs...)
+	srcs := make([]source, 0, len(runs)+1)
+	for _, r := range runs {
+		r.acquire()
+		srcs = append(srcs, r.reader(0))
+	}
+	srcs = append(srcs, &mem)
+	release := func() {
+		for _, r := range runs {
+			r.release()
+		}
+	}
+	return srcs, release, nil
+}
+
+// SyncEvery flushes the journal and syncs it to disk every interval, until
+// the store is closed, so that a crash loses the records of the last
+// interval at most.
+func (s *Store) SyncEvery(every time.Duration) {
+	if every <= 0 {
+		return
+	}
+	go func() {
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-tick.C:
+			}
+			if err := s.Flush(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": s.filename,
+				}).Error("periodic fsync of state file")
+			}
+		}
+	}()
+}
+
+// Flush the journal and sync it to disk.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *Store) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return fmt.Errorf("flushing state file: %v", err)
+	}
+	return s.file.Sync()
+}
+
+// Compact rewrites the journal with only the last record of each key.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	tmpname := s.filename + ".compact"
+	tmp, err := os.Create(tmpname)
+	if err != nil {
+		return fmt.Errorf("creating compacted state file: %v", err)
+	}
+	mem, err := s.memEntries()
+	if err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing compacted state file: %v", err)
+	}
+	srcs := make([]source, 0, len(s.runs)+1)
+	for _, r := range s.runs {
+		srcs = append(srcs, r.reader(0))
+	}
+	if err := s.writeRecords(tmp, append(srcs, &mem)); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing compacted state file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("syncing compacted state file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing compacted state file: %v", err)
+	}
+	if err := os.Rename(tmpname, s.filename); err != nil {
+		return fmt.Errorf("replacing state file with compacted one: %v", err)
+	}
+
+	file, err := os.OpenFile(s.filename, os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("reopening compacted state file: %v", err)
+	}
+	_ = s.file.Close()
+	s.file = file
+	s.buf = bufio.NewWriter(file)
+	return nil
+}
+
+// Checkpoint writes the last record of each key to w, as a compacted
+// journal that Open replays, encrypted like the store, such as to keep a
+// copy of the store elsewhere while it's in use.
+// It writes a snapshot of the store, which can be written to meanwhile.
+func (s *Store) Checkpoint(w io.Writer) error {
+	srcs, release, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return s.writeRecords(w, srcs)
+}
+
+// writeRecords writes the header of the journal and the merged records of
+// srcs.
+func (s *Store) writeRecords(w io.Writer, srcs []source) error {
+	buf := bufio.NewWriter(w)
+	if err := s.writeHeader(buf); err != nil {
+		return err
+	}
+	err := s.merge(srcs, func(e entry) error {
+		_, err := buf.Write(append(e.data, '\n'))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// Close flushes the journal and closes the store.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+	s.merges.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseRuns()
+	if err := s.flush(); err != nil {
+		_ = s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// releaseRuns of the store, whose files are closed once the snapshots
+// reading them are done.
+func (s *Store) releaseRuns() {
+	for _, r := range s.runs {
+		r.release()
+	}
+	s.runs = nil
+}
@@ -0,0 +1,422 @@
+package state_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/state"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func withStateFile(t *testing.T, f func(filename string)) {
+	dir, err := ioutil.TempDir("", "brigade_state")
+	if err != nil {
+		t.Fatalf("can't create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	f(filepath.Join(dir, "sync.state"))
+}
+
+func TestStoreLastRecordWins(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		store, err := state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Pending})
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "b"}, Status: state.Pending})
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Synced})
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "b"}, Status: state.Failed, ErrorCode: "SlowDown", Retries: 3})
+		if err := store.Close(); err != nil {
+			t.Fatalf("can't close store: %v", err)
+		}
+
+		store, err = state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't reopen store: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+
+		if store.Len() != 2 {
+			t.Fatalf("want 2 keys, got %d", store.Len())
+		}
+		wantStatus(t, store, "a", state.Synced)
+		rec := wantStatus(t, store, "b", state.Failed)
+		if rec.ErrorCode != "SlowDown" || rec.Retries != 3 {
+			t.Errorf("want error code and retries to be kept, got %#v", rec)
+		}
+	})
+}
+
+func TestStoreIgnoresTruncatedRecord(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		data := `{"key":{"Key":"a"},"status":"synced"}` + "\n" + `{"key":{"Key":"b"},"sta`
+		if err := ioutil.WriteFile(filename, []byte(data), 0640); err != nil {
+			t.Fatalf("can't write state file: %v", err)
+		}
+		store, err := state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+
+		if store.Len() != 1 {
+			t.Fatalf("want 1 key, got %d", store.Len())
+		}
+		wantStatus(t, store, "a", state.Synced)
+	})
+}
+
+func TestStoreCompact(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		store, err := state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		for i := 0; i < 10; i++ {
+			mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Pending})
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Synced})
+		if err := store.Flush(); err != nil {
+			t.Fatalf("can't flush store: %v", err)
+		}
+		before, _ := os.Stat(filename)
+
+		if err := store.Compact(); err != nil {
+			t.Fatalf("can't compact store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "b"}, Status: state.Synced})
+		if err := store.Close(); err != nil {
+			t.Fatalf("can't close store: %v", err)
+		}
+		after, _ := os.Stat(filename)
+		if after.Size() >= before.Size() {
+			t.Errorf("want compacted file to be smaller, was %d bytes, now %d", before.Size(), after.Size())
+		}
+
+		store, err = state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't reopen store: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+		wantStatus(t, store, "a", state.Synced)
+		wantStatus(t, store, "b", state.Synced)
+	})
+}
+
+func TestStoreCheckpoint(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		store, err := state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Pending})
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Synced})
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "b"}, Status: state.Failed})
+
+		checkpoint := filename + ".checkpoint"
+		f, err := os.Create(checkpoint)
+		if err != nil {
+			t.Fatalf("can't create checkpoint file: %v", err)
+		}
+		if err := store.Checkpoint(f); err != nil {
+			t.Fatalf("can't checkpoint store: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("can't close checkpoint file: %v", err)
+		}
+		if err := store.Close(); err != nil {
+			t.Fatalf("can't close store: %v", err)
+		}
+
+		restored, err := state.Open(checkpoint)
+		if err != nil {
+			t.Fatalf("can't open checkpoint: %v", err)
+		}
+		defer func() { _ = restored.Close() }()
+		if restored.Len() != 2 {
+			t.Fatalf("want 2 keys, got %d", restored.Len())
+		}
+		wantStatus(t, restored, "a", state.Synced)
+		wantStatus(t, restored, "b", state.Failed)
+	})
+}
+
+func TestStoreEncrypted(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		enc := state.Encryption{Passphrase: "correct horse"}
+		store, err := state.OpenEncrypted(filename, enc)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "secret/a"}, Status: state.Pending})
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "secret/a"}, Status: state.Synced})
+		if err := store.Compact(); err != nil {
+			t.Fatalf("can't compact store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "secret/b"}, Status: state.Failed})
+		if err := store.Close(); err != nil {
+			t.Fatalf("can't close store: %v", err)
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("can't read state file: %v", err)
+		}
+		if bytes.Contains(data, []byte("secret/")) {
+			t.Errorf("want the key names encrypted, got %q", data)
+		}
+
+		if _, err := state.Open(filename); err == nil {
+			t.Error("want an error opening an encrypted store without its passphrase")
+		}
+		if _, err := state.OpenEncrypted(filename, state.Encryption{Passphrase: "wrong"}); err == nil {
+			t.Error("want an error opening an encrypted store with the wrong passphrase")
+		}
+
+		store, err = state.OpenEncrypted(filename, enc)
+		if err != nil {
+			t.Fatalf("can't reopen store: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+		wantStatus(t, store, "secret/a", state.Synced)
+		wantStatus(t, store, "secret/b", state.Failed)
+
+		// a checkpoint is encrypted with the same key
+		var checkpoint bytes.Buffer
+		if err := store.Checkpoint(&checkpoint); err != nil {
+			t.Fatalf("can't checkpoint store: %v", err)
+		}
+		restoredFilename := filename + ".checkpoint"
+		if err := ioutil.WriteFile(restoredFilename, checkpoint.Bytes(), 0640); err != nil {
+			t.Fatalf("can't write checkpoint: %v", err)
+		}
+		restored, err := state.OpenEncrypted(restoredFilename, enc)
+		if err != nil {
+			t.Fatalf("can't open checkpoint: %v", err)
+		}
+		defer func() { _ = restored.Close() }()
+		if restored.Len() != 2 || bytes.Contains(checkpoint.Bytes(), []byte("secret/")) {
+			t.Errorf("want 2 encrypted keys in the checkpoint, got %d", restored.Len())
+		}
+	})
+}
+
+// fakeKMS encrypts data keys by reversing them.
+type fakeKMS struct{ generated, decrypted int }
+
+func (k *fakeKMS) GenerateDataKey() ([]byte, []byte, error) {
+	k.generated++
+	key := []byte("0123456789abcdef0123456789abcdef")
+	return key, reverse(key), nil
+}
+
+func (k *fakeKMS) Decrypt(encrypted []byte) ([]byte, error) {
+	k.decrypted++
+	if len(encrypted) != 32 {
+		return nil, errors.New("not a data key")
+	}
+	return reverse(encrypted), nil
+}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+func TestStoreEncryptedWithKMS(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		kms := &fakeKMS{}
+		store, err := state.OpenEncrypted(filename, state.Encryption{KMS: kms})
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "secret/a"}, Status: state.Synced})
+		if err := store.Close(); err != nil {
+			t.Fatalf("can't close store: %v", err)
+		}
+
+		if _, err := state.OpenEncrypted(filename, state.Encryption{Passphrase: "nope"}); err == nil {
+			t.Error("want an error opening a store encrypted with KMS without it")
+		}
+		store, err = state.OpenEncrypted(filename, state.Encryption{KMS: kms})
+		if err != nil {
+			t.Fatalf("can't reopen store: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+		wantStatus(t, store, "secret/a", state.Synced)
+		if kms.generated != 1 || kms.decrypted != 1 {
+			t.Errorf("want a data key generated once and decrypted once, got %+v", kms)
+		}
+	})
+}
+
+func TestStoreRefusesToEncryptPlainJournal(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		store, err := state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Synced})
+		if err := store.Close(); err != nil {
+			t.Fatalf("can't close store: %v", err)
+		}
+		if _, err := state.OpenEncrypted(filename, state.Encryption{Passphrase: "late"}); err == nil {
+			t.Error("want an error encrypting a store that has plain records")
+		}
+	})
+}
+
+func TestStoreSpillsToDisk(t *testing.T) {
+	for _, enc := range []*state.Encryption{nil, {Passphrase: "correct horse"}} {
+		withStateFile(t, func(filename string) {
+			open := func(name string) *state.Store {
+				var (
+					store *state.Store
+					err   error
+				)
+				if enc == nil {
+					store, err = state.Open(name, state.WithMaxRecords(10))
+				} else {
+					store, err = state.OpenEncrypted(name, *enc, state.WithMaxRecords(10))
+				}
+				if err != nil {
+					t.Fatalf("can't open store: %v", err)
+				}
+				return store
+			}
+			check := func(store *state.Store) {
+				if store.Len() != 1000 {
+					t.Errorf("want 1000 keys, got %d", store.Len())
+				}
+				for i := 0; i < 1000; i++ {
+					want := state.Pending
+					if i%3 == 0 {
+						want = state.Synced
+					}
+					wantStatus(t, store, fmt.Sprintf("key/%d", i), want)
+				}
+				if _, ok := store.Get("key/1000"); ok {
+					t.Errorf("want a key never put not found")
+				}
+				seen := make(map[string]bool)
+				err := store.Each(func(rec state.Record) bool {
+					if seen[rec.Key.Key] {
+						t.Errorf("key %q seen twice", rec.Key.Key)
+					}
+					seen[rec.Key.Key] = true
+					return true
+				})
+				if err != nil || len(seen) != 1000 {
+					t.Errorf("want each of the 1000 keys once, got %d: %v", len(seen), err)
+				}
+			}
+
+			store := open(filename)
+			for i := 0; i < 1000; i++ {
+				mustPut(t, store, state.Record{Key: s3.Key{Key: fmt.Sprintf("key/%d", i)}, Status: state.Pending})
+			}
+			// overwrites of keys spilled long ago, while checkpoints read
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 5; i++ {
+					if err := store.Checkpoint(ioutil.Discard); err != nil {
+						t.Errorf("can't checkpoint store: %v", err)
+					}
+				}
+			}()
+			for i := 0; i < 1000; i += 3 {
+				mustPut(t, store, state.Record{Key: s3.Key{Key: fmt.Sprintf("key/%d", i)}, Status: state.Synced})
+			}
+			wg.Wait()
+			check(store)
+
+			var checkpoint bytes.Buffer
+			if err := store.Checkpoint(&checkpoint); err != nil {
+				t.Fatalf("can't checkpoint store: %v", err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatalf("can't close store: %v", err)
+			}
+			if files, _ := filepath.Glob(filename + ".run*"); len(files) != 0 {
+				t.Errorf("want the runs removed, got %v", files)
+			}
+			if lines := bytes.Count(checkpoint.Bytes(), []byte("\n")); enc == nil && lines != 1000 || enc != nil && lines != 1001 {
+				t.Errorf("want a line per key in the checkpoint, got %d", lines)
+			}
+
+			store = open(filename)
+			check(store)
+			if err := store.Compact(); err != nil {
+				t.Fatalf("can't compact store: %v", err)
+			}
+			check(store)
+			if err := store.Close(); err != nil {
+				t.Fatalf("can't close store: %v", err)
+			}
+
+			restored := filename + ".checkpoint"
+			if err := ioutil.WriteFile(restored, checkpoint.Bytes(), 0640); err != nil {
+				t.Fatalf("can't write checkpoint: %v", err)
+			}
+			store = open(restored)
+			defer func() { _ = store.Close() }()
+			check(store)
+		})
+	}
+}
+
+func TestStoreSyncEvery(t *testing.T) {
+	withStateFile(t, func(filename string) {
+		store, err := state.Open(filename)
+		if err != nil {
+			t.Fatalf("can't open store: %v", err)
+		}
+		defer func() { _ = store.Close() }()
+		store.SyncEvery(time.Millisecond)
+		mustPut(t, store, state.Record{Key: s3.Key{Key: "a"}, Status: state.Synced})
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("can't read state file: %v", err)
+			}
+			if bytes.Contains(data, []byte(`"Key":"a"`)) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("want the record on disk before the store is closed, got %q", data)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func mustPut(t *testing.T, store *state.Store, rec state.Record) {
+	if err := store.Put(rec); err != nil {
+		t.Fatalf("can't put record: %v", err)
+	}
+}
+
+func wantStatus(t *testing.T, store *state.Store, key string, want state.Status) state.Record {
+	rec, ok := store.Get(key)
+	if !ok {
+		t.Fatalf("key %q not found", key)
+	}
+	if rec.Status != want {
+		t.Errorf("key %q: want status %q, got %q", key, want, rec.Status)
+	}
+	return rec
+}
@@ -0,0 +1,245 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookTimeout is how long the webhook of an anomaly can take to answer.
+var webhookTimeout = 10 * time.Second
+
+// The kinds of anomalies.
+const (
+	AnomalyLatency  = "latency"
+	AnomalyFailures = "failures"
+)
+
+// Anomalies detects when the sync calls of a task get much slower, or fail
+// much more often, than they did so far in the run, such as when S3 has an
+// incident or throttles the task, and warns about it long before the task
+// times out. The calls are measured over windows of time, each compared to
+// a baseline of the windows before it, leaving out the anomalous ones.
+type Anomalies struct {
+	// Every is the length of the windows.
+	Every time.Duration
+	// Baseline is how many windows the baseline spans. Windows aren't
+	// judged until there are half that many.
+	Baseline int
+	// LatencyFactor is how many times the p95 latency of the baseline the
+	// p95 of a window must be to be anomalous.
+	LatencyFactor float64
+	// FailureRate is how much above the failure rate of the baseline, as
+	// the fraction of calls that failed, the rate of a window must be to be
+	// anomalous.
+	FailureRate float64
+	// MinCalls is how many calls a window needs to be judged, so that a
+	// few slow calls are not an anomaly.
+	MinCalls int64
+	// Webhook, when set, is POSTed each Anomaly as JSON, on top of the
+	// warning logged.
+	Webhook string
+}
+
+// Validate checks that anomalies can be detected.
+func (a Anomalies) Validate() error {
+	switch {
+	case a.Every <= 0:
+		return fmt.Errorf("anomaly window must be positive, got %v", a.Every)
+	case a.Baseline < 2:
+		return fmt.Errorf("anomaly baseline must span at least 2 windows, got %d", a.Baseline)
+	case a.LatencyFactor <= 1:
+		return fmt.Errorf("anomaly latency factor must be above 1, got %v", a.LatencyFactor)
+	case a.FailureRate <= 0 || a.FailureRate > 1:
+		return fmt.Errorf("anomaly failure rate must be in (0, 1], got %v", a.FailureRate)
+	case a.MinCalls < 1:
+		return fmt.Errorf("anomaly windows need at least 1 call, got %d", a.MinCalls)
+	}
+	return nil
+}
+
+// Anomaly is a window whose calls deviated from the baseline, or the first
+// window back to normal after it, which is Recovered.
+type Anomaly struct {
+	Kind      string    `json:"kind"`
+	Recovered bool      `json:"recovered"`
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Dest      string    `json:"dest"`
+	RunID     string    `json:"run_id,omitempty"`
+	Calls     int64     `json:"calls"`
+	// latencies are in milliseconds
+	P95                 float64 `json:"p95_ms"`
+	BaselineP95         float64 `json:"baseline_p95_ms"`
+	FailureRate         float64 `json:"failure_rate"`
+	BaselineFailureRate float64 `json:"baseline_failure_rate"`
+}
+
+// callWindow holds the calls of a window.
+type callWindow struct {
+	latency       *monitor.Histogram
+	calls, failed int64
+}
+
+// windowStats summarizes the calls of a window.
+type windowStats struct {
+	p95           time.Duration
+	calls, failed int64
+}
+
+// anomalyWatch measures the calls of a task and compares its windows to
+// their baseline.
+type anomalyWatch struct {
+	Anomalies
+
+	mu      sync.Mutex
+	current *callWindow
+	// baseline windows, the oldest first
+	baseline []windowStats
+	// kinds of anomalies under way
+	ongoing map[string]bool
+}
+
+func newAnomalyWatch(a Anomalies) *anomalyWatch {
+	return &anomalyWatch{
+		Anomalies: a,
+		current:   &callWindow{latency: monitor.NewHistogram()},
+		ongoing:   make(map[string]bool),
+	}
+}
+
+// record a sync call.
+func (w *anomalyWatch) record(elapsed time.Duration, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current.latency.Record(elapsed)
+	w.current.calls++
+	if failed {
+		w.current.failed++
+	}
+}
+
+// rotate starts a new window, returning the one that ended.
+func (w *anomalyWatch) rotate() windowStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ended := w.current
+	w.current = &callWindow{latency: monitor.NewHistogram()}
+	return windowStats{p95: ended.latency.Quantile(0.95), calls: ended.calls, failed: ended.failed}
+}
+
+// judge a window against the baseline, returning the anomalies that
+// started or recovered with it. Windows that are normal join the baseline.
+func (w *anomalyWatch) judge(win windowStats) []Anomaly {
+	if win.calls < w.MinCalls {
+		return nil
+	}
+	if len(w.baseline) < (w.Baseline+1)/2 {
+		w.baseline = append(w.baseline, win)
+		return nil
+	}
+	var p95, calls, failed int64
+	for _, b := range w.baseline {
+		p95 += int64(b.p95)
+		calls += b.calls
+		failed += b.failed
+	}
+	base := Anomaly{
+		Calls:               win.calls,
+		P95:                 ms(win.p95),
+		BaselineP95:         ms(time.Duration(p95 / int64(len(w.baseline)))),
+		FailureRate:         float64(win.failed) / float64(win.calls),
+		BaselineFailureRate: float64(failed) / float64(calls),
+	}
+	anomalous := map[string]bool{
+		AnomalyLatency:  base.P95 > base.BaselineP95*w.LatencyFactor,
+		AnomalyFailures: base.FailureRate > base.BaselineFailureRate+w.FailureRate,
+	}
+
+	var out []Anomaly
+	for _, kind := range []string{AnomalyLatency, AnomalyFailures} {
+		if anomalous[kind] == w.ongoing[kind] {
+			continue
+		}
+		w.ongoing[kind] = anomalous[kind]
+		a := base
+		a.Kind = kind
+		a.Recovered = !anomalous[kind]
+		out = append(out, a)
+	}
+	if !anomalous[AnomalyLatency] && !anomalous[AnomalyFailures] {
+		w.baseline = append(w.baseline, win)
+		if len(w.baseline) > w.Baseline {
+			w.baseline = w.baseline[1:]
+		}
+	}
+	return out
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// watchAnomalies judges the windows of calls of the task until done is
+// closed, warning about the anomalies.
+func (s *SyncTask) watchAnomalies(done <-chan struct{}) {
+	tick := time.NewTicker(s.anomalies.Every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick.C:
+			for _, a := range s.anomalies.judge(s.anomalies.rotate()) {
+				a.Time = now.UTC()
+				a.Source, a.Dest, a.RunID = s.src.Name, s.dst.Name, s.RunID
+				s.reportAnomaly(a)
+			}
+		}
+	}
+}
+
+func (s *SyncTask) reportAnomaly(a Anomaly) {
+	log := logrus.WithFields(logrus.Fields{
+		"kind":                  a.Kind,
+		"calls":                 a.Calls,
+		"p95_ms":                a.P95,
+		"baseline_p95_ms":       a.BaselineP95,
+		"failure_rate":          a.FailureRate,
+		"baseline_failure_rate": a.BaselineFailureRate,
+	})
+	if a.Recovered {
+		log.Info("sync calls are back to their baseline")
+	} else {
+		metrics.anomalies.Add(1)
+		log.Warn("sync calls deviate from their baseline, S3 may be having an incident or throttling")
+	}
+	if s.anomalies.Webhook != "" {
+		go postAnomaly(s.anomalies.Webhook, a)
+	}
+}
+
+// postAnomaly to a webhook, logging its errors.
+func postAnomaly(url string, a Anomaly) {
+	body, err := json.Marshal(a)
+	if err == nil {
+		client := http.Client{Timeout: webhookTimeout}
+		var resp *http.Response
+		resp, err = client.Post(url, "application/json", bytes.NewReader(body))
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				err = fmt.Errorf("webhook answered %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"kind":  a.Kind,
+		}).Error("failed to post anomaly to webhook")
+	}
+}
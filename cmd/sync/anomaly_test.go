@@ -0,0 +1,109 @@
+package sync_test
+
+import (
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestSyncAnomalies(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for i := 0; i < 200; i++ {
+		name := "key-" + strconv.Itoa(i)
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+
+	var mu gosync.Mutex
+	var posted []sync.Anomaly
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a sync.Anomaly
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("can't decode anomaly: %v", err)
+		}
+		mu.Lock()
+		posted = append(posted, a)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(10),
+		sync.WithAnomalies(sync.Anomalies{
+			Every:         100 * time.Millisecond,
+			Baseline:      4,
+			LatencyFactor: 5,
+			FailureRate:   0.5,
+			MinCalls:      3,
+			Webhook:       webhook.URL,
+		}),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+
+	// keys trickle in, and S3 slows down halfway through
+	input, feed := io.Pipe()
+	go func() {
+		for i, key := range keys {
+			if i == len(keys)/2 {
+				mocks3.SetBehavior(s3mock.Behavior{Latency: s3mock.FixedLatency(50 * time.Millisecond)})
+			}
+			_, _ = io.Copy(feed, encodeKeys([]s3.Key{key}))
+			time.Sleep(5 * time.Millisecond)
+		}
+		_ = feed.Close()
+	}()
+	if err := syncTask.Start(input, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	// the webhook is posted in the background
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) == 0 {
+		t.Fatalf("want the slow down posted to the webhook")
+	}
+	a := posted[0]
+	if a.Kind != sync.AnomalyLatency || a.Recovered || a.P95 <= a.BaselineP95*5 {
+		t.Errorf("want a latency anomaly, got %+v", a)
+	}
+	if a.Source != "src-bucket" || a.Dest != "dst-bucket" {
+		t.Errorf("want the buckets of the task in the anomaly, got %+v", a)
+	}
+
+	for _, bad := range []sync.Anomalies{
+		{Every: 0, Baseline: 4, LatencyFactor: 5, FailureRate: 0.5, MinCalls: 3},
+		{Every: time.Second, Baseline: 1, LatencyFactor: 5, FailureRate: 0.5, MinCalls: 3},
+		{Every: time.Second, Baseline: 4, LatencyFactor: 1, FailureRate: 0.5, MinCalls: 3},
+		{Every: time.Second, Baseline: 4, LatencyFactor: 5, FailureRate: 0, MinCalls: 3},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package sync
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The outcomes of the keys in the audit log.
+const (
+	AuditSynced  = "synced"
+	AuditFailed  = "failed"
+	AuditSkipped = "skipped"
+)
+
+// AuditRecord is what happened to a key, as written to an AuditLog.
+type AuditRecord struct {
+	Key s3.Key `json:"key"`
+	// Name of the key at the destination, when it's renamed.
+	Name    string    `json:"name,omitempty"`
+	Outcome string    `json:"outcome"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	// Attempts made to sync the key, and the worker that made them.
+	Attempts int `json:"attempts"`
+	Worker   int `json:"worker"`
+	// RequestID of the last response of S3 about the key at the destination,
+	// which AWS support asks for about a request.
+	RequestID string `json:"request_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	// RunID of the task, when it has one.
+	RunID string `json:"run_id,omitempty"`
+}
+
+// AuditLog writes a record of the outcome of each key, as JSON lines, to
+// tell where a key went long after the sync.
+type AuditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewAuditLog writes the records to w.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{enc: json.NewEncoder(w)}
+}
+
+// Record the outcome of a key. Safe to use from many workers.
+func (a *AuditLog) Record(rec AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enc.Encode(rec)
+}
+
+// audit records the outcome of a key in the audit log of the task, if it
+// has one, and counts it in the file of the input it came from.
+func (s *SyncTask) audit(rec AuditRecord) {
+	if s.inputs != nil {
+		s.inputs.done(rec.Key.Key, rec.Outcome)
+	}
+	if s.Audit == nil {
+		return
+	}
+	rec.End = time.Now().UTC()
+	rec.Start = rec.Start.UTC()
+	rec.RunID = s.RunID
+	if name := s.DestKey(rec.Key.Key); name != rec.Key.Key {
+		rec.Name = name
+	}
+	if err := s.Audit.Record(rec); err != nil {
+		metrics.auditErrors.Add(1)
+		logrus.WithFields(logrus.Fields{
+			"key":   rec.Key,
+			"error": err,
+		}).Error("couldn't write key to the audit log")
+	}
+}
+
+// requestIDs remembers the request ID of the last response about a key, for
+// the audit log.
+type requestIDs struct {
+	mu   sync.Mutex
+	last string
+}
+
+// track returns a copy of bkt whose responses are tracked by r.
+func (r *requestIDs) track(bkt *s3.Bucket) *s3.Bucket {
+	client := *bkt.S3
+	client.OnResponse = func(resp *http.Response) {
+		if id := resp.Header.Get("x-amz-request-id"); id != "" {
+			r.mu.Lock()
+			r.last = id
+			r.mu.Unlock()
+		}
+	}
+	return &s3.Bucket{S3: &client, Name: bkt.Name}
+}
+
+func (r *requestIDs) get() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
@@ -0,0 +1,88 @@
+package sync_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncAudit(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, name := range []string{"copied", "denied", "filtered"} {
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			if r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/denied") {
+				return &s3.Error{StatusCode: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}
+			}
+			return nil
+		},
+	})
+
+	var audit bytes.Buffer
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(1),
+		sync.WithFilter(func(key s3.Key) bool { return key.Key != "filtered" }),
+		sync.WithAudit(sync.NewAuditLog(&audit)),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	recs := make(map[string]sync.AuditRecord)
+	dec := json.NewDecoder(&audit)
+	for {
+		var rec sync.AuditRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("can't decode audit record: %v", err)
+		}
+		recs[rec.Key.Key] = rec
+	}
+	if len(recs) != 3 {
+		t.Fatalf("want a record per key, got %v", recs)
+	}
+
+	copied := recs["copied"]
+	if copied.Outcome != sync.AuditSynced || copied.Attempts != 1 || copied.RequestID == "" {
+		t.Errorf("want the copied key synced with a request ID, got %+v", copied)
+	}
+	if copied.Start.IsZero() || copied.End.Before(copied.Start) {
+		t.Errorf("want the copy timed, got %v to %v", copied.Start, copied.End)
+	}
+	denied := recs["denied"]
+	if denied.Outcome != sync.AuditFailed || denied.ErrorCode != "AccessDenied" || denied.RequestID == "" || denied.RequestID == copied.RequestID {
+		t.Errorf("want the denied key failed with its own request ID, got %+v", denied)
+	}
+	if filtered := recs["filtered"]; filtered.Outcome != sync.AuditSkipped || filtered.Attempts != 0 {
+		t.Errorf("want the filtered key skipped, got %+v", filtered)
+	}
+}
@@ -0,0 +1,119 @@
+package sync
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most bytes read from the source at once by a
+// throttled copy, so that its bytes are spread over time rather than
+// waited for in bursts.
+const throttleChunk = 32 << 10
+
+// Bandwidth caps the bytes a second that GET+PUT copies read from their
+// source, and so write to their destination, such as for a sync running on
+// a host that shares its network with production traffic.
+type Bandwidth struct {
+	// Total bytes a second of all the copies, 0 for no cap.
+	Total int64
+	// PerWorker bytes a second of each copy, which a sync worker makes one
+	// at a time, 0 for no cap.
+	PerWorker int64
+}
+
+// Validate checks that the bandwidth caps something.
+func (b Bandwidth) Validate() error {
+	switch {
+	case b.Total < 0 || b.PerWorker < 0:
+		return fmt.Errorf("bandwidth must be positive, got %d total and %d per worker", b.Total, b.PerWorker)
+	case b.Total == 0 && b.PerWorker == 0:
+		return fmt.Errorf("need a total bandwidth, or one per worker")
+	}
+	return nil
+}
+
+// rateLimiter lets units, such as bytes or calls, through at a rate, with
+// bursts of up to a tenth of a second of them. Its rate can change as it's
+// used, 0 letting everything through.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate / 10, last: time.Now()}
+}
+
+// setRate changes the rate, starting with a full burst.
+func (r *rateLimiter) setRate(rate float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rate, r.tokens, r.last = rate, rate/10, time.Now()
+}
+
+// reserve n units, returning how long to wait for them to be within the
+// rate.
+func (r *rateLimiter) reserve(n float64) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if burst := r.rate / 10; r.tokens > burst {
+		r.tokens = burst
+	}
+	r.last = now
+	// the units are taken right away, even if they're not there yet, so
+	// the ones that wait are let through in turn
+	r.tokens -= n
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// throttledReader reads from r within the rates.
+type throttledReader struct {
+	r     io.Reader
+	rates []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	for _, rate := range t.rates {
+		if delay := rate.reserve(float64(n)); delay > 0 {
+			metrics.secondsThrottled.Add(delay.Seconds())
+			time.Sleep(delay)
+		}
+	}
+	return n, err
+}
+
+// ThrottledGetPut is GetPut with the bytes of its copies read within the
+// bandwidth, the total one shared by all the copies of the returned copier.
+func ThrottledGetPut(b Bandwidth) CopyFunc {
+	var total *rateLimiter
+	if b.Total > 0 {
+		total = newRateLimiter(float64(b.Total))
+	}
+	return func(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+		var rates []*rateLimiter
+		if b.PerWorker > 0 {
+			rates = append(rates, newRateLimiter(float64(b.PerWorker)))
+		}
+		if total != nil {
+			rates = append(rates, total)
+		}
+		return getPut(src, dst, key, dstKey, func(r io.Reader) io.Reader {
+			return bufio.NewReader(&throttledReader{r: r, rates: rates})
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package sync_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestThrottledGetPut(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	data := bytes.Repeat([]byte("x"), 64<<10)
+	var keys []s3.Key
+	for i := 0; i < 3; i++ {
+		key := s3.Key{Key: fmt.Sprintf("key%d", i), Size: int64(len(data))}
+		if err := src.Put(key.Key, data, "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+		keys = append(keys, key)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		bandwidth sync.Bandwidth
+		min       time.Duration
+	}{
+		// 192KiB at 256KiB/s, less the first burst
+		{"total", sync.Bandwidth{Total: 256 << 10}, 500 * time.Millisecond},
+		// 64KiB at 128KiB/s for each of the copies, made at once
+		{"per worker", sync.Bandwidth{PerWorker: 128 << 10}, 400 * time.Millisecond},
+	} {
+		if err := tt.bandwidth.Validate(); err != nil {
+			t.Fatalf("%s: invalid bandwidth: %v", tt.name, err)
+		}
+		copier := sync.ThrottledGetPut(tt.bandwidth)
+		start := time.Now()
+		var wg gosync.WaitGroup
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key s3.Key) {
+				defer wg.Done()
+				if err := copier(src, dst, key, key.Key); err != nil {
+					t.Errorf("%s: can't copy %q: %v", tt.name, key.Key, err)
+				}
+			}(key)
+		}
+		wg.Wait()
+		if took := time.Since(start); took < tt.min {
+			t.Errorf("%s: want copies throttled to take at least %v, took %v", tt.name, tt.min, took)
+		}
+		for _, key := range keys {
+			got, err := dst.Get(key.Key)
+			if err != nil {
+				t.Fatalf("%s: can't get %q: %v", tt.name, key.Key, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("%s: want %d bytes of %q copied, got %d", tt.name, len(data), key.Key, len(got))
+			}
+		}
+	}
+
+	if err := (sync.Bandwidth{}).Validate(); err == nil {
+		t.Error("want an error for a bandwidth without a cap")
+	}
+}
@@ -0,0 +1,184 @@
+package sync
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportedPrefixes is how many prefixes are logged in the final report.
+const reportedPrefixes = 20
+
+// Stats of the keys handled by a worker, or found under a prefix.
+type Stats struct {
+	Keys     int64 `json:"keys"`
+	Bytes    int64 `json:"bytes"`
+	Failures int64 `json:"failures"`
+	// Calls made to sync the keys, including retries, and the total time
+	// spent in those calls.
+	Calls   int64         `json:"calls"`
+	Latency time.Duration `json:"latency"`
+}
+
+// MeanLatency of the calls made to sync the keys.
+func (s Stats) MeanLatency() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Calls)
+}
+
+// calls made to sync a key, and the time spent in them.
+type calls struct {
+	count   int
+	latency time.Duration
+}
+
+func (s *Stats) add(key s3.Key, c calls, failed bool) {
+	s.Keys++
+	s.Calls += int64(c.count)
+	s.Latency += c.latency
+	if failed {
+		s.Failures++
+	} else {
+		s.Bytes += key.Size
+	}
+}
+
+// Breakdown of the stats of a task per sync worker, and per top-level prefix
+// of the keys.
+type Breakdown struct {
+	Workers  []Stats          `json:"workers"`
+	Prefixes map[string]Stats `json:"prefixes"`
+}
+
+type breakdown struct {
+	mu       sync.Mutex
+	workers  []Stats
+	prefixes map[string]*Stats
+}
+
+func newBreakdown() *breakdown {
+	return &breakdown{prefixes: make(map[string]*Stats)}
+}
+
+// topLevelPrefix of a key, up to and including its first '/'. Keys without
+// a '/' are at the root, which has the empty prefix.
+func topLevelPrefix(key string) string {
+	i := strings.IndexByte(key, '/')
+	if i < 0 {
+		return ""
+	}
+	return key[:i+1]
+}
+
+func (b *breakdown) add(worker int, key s3.Key, c calls, failed bool) {
+	prefix := topLevelPrefix(key.Key)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.workers) <= worker {
+		b.workers = append(b.workers, Stats{})
+	}
+	b.workers[worker].add(key, c, failed)
+
+	stats, ok := b.prefixes[prefix]
+	if !ok {
+		stats = &Stats{}
+		b.prefixes[prefix] = stats
+	}
+	stats.add(key, c, failed)
+}
+
+func (b *breakdown) snapshot() Breakdown {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bd := Breakdown{
+		Workers:  append([]Stats(nil), b.workers...),
+		Prefixes: make(map[string]Stats, len(b.prefixes)),
+	}
+	for prefix, stats := range b.prefixes {
+		bd.Prefixes[prefix] = *stats
+	}
+	return bd
+}
+
+// Breakdown returns the stats of the task per worker and per prefix.
+func (s *SyncTask) Breakdown() Breakdown { return s.breakdown.snapshot() }
+
+// logBreakdown logs the prefixes on which the most time was spent, and how
+// evenly the keys were spread over the workers.
+func logBreakdown(bd Breakdown) {
+	prefixes := make([]string, 0, len(bd.Prefixes))
+	for prefix := range bd.Prefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Sort(byLatency{prefixes, bd.Prefixes})
+	if len(prefixes) > reportedPrefixes {
+		prefixes = prefixes[:reportedPrefixes]
+	}
+	for _, prefix := range prefixes {
+		stats := bd.Prefixes[prefix]
+		logrus.WithFields(logrus.Fields{
+			"prefix":       prefix,
+			"keys":         stats.Keys,
+			"bytes":        stats.Bytes,
+			"failures":     stats.Failures,
+			"calls":        stats.Calls,
+			"mean_latency": stats.MeanLatency(),
+		}).Info("prefix stats")
+	}
+
+	if len(bd.Workers) == 0 {
+		return
+	}
+	min, max := bd.Workers[0], bd.Workers[0]
+	for _, stats := range bd.Workers[1:] {
+		if stats.Keys < min.Keys {
+			min = stats
+		}
+		if stats.Keys > max.Keys {
+			max = stats
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"workers":           len(bd.Workers),
+		"min_keys":          min.Keys,
+		"min_keys_latency":  min.MeanLatency(),
+		"max_keys":          max.Keys,
+		"max_keys_latency":  max.MeanLatency(),
+		"prefixes":          len(bd.Prefixes),
+		"reported_prefixes": len(prefixes),
+	}).Info("worker stats")
+}
+
+// byLatency sorts prefixes by decreasing time spent syncing their keys.
+type byLatency struct {
+	prefixes []string
+	stats    map[string]Stats
+}
+
+func (b byLatency) Len() int      { return len(b.prefixes) }
+func (b byLatency) Swap(i, j int) { b.prefixes[i], b.prefixes[j] = b.prefixes[j], b.prefixes[i] }
+func (b byLatency) Less(i, j int) bool {
+	return b.stats[b.prefixes[i]].Latency > b.stats[b.prefixes[j]].Latency
+}
+
+// logRequesterPays warns that the calls made on a requester pays source, and
+// the bytes they transferred, are billed to the account that made them.
+func logRequesterPays(bucket string, bd Breakdown) {
+	var total Stats
+	for _, stats := range bd.Workers {
+		total.Calls += stats.Calls
+		total.Bytes += stats.Bytes
+	}
+	logrus.WithFields(logrus.Fields{
+		"bucket_source":  bucket,
+		"billed_calls":   total.Calls,
+		"billed_bytes":   total.Bytes,
+		"requester_pays": true,
+	}).Warn("source bucket is requester pays, its requests and data transfer are billed to this account")
+}
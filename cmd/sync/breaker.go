@@ -0,0 +1,116 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Breaker is a circuit breaker that pauses a sync task when too many of the
+// last keys failed to sync, instead of burning through the retries of every
+// key against a broken destination. The task is resumed after a cool-down.
+type Breaker struct {
+	// Window is the number of last keys the failure rates are measured on.
+	Window int
+	// MaxFailureRate trips the breaker when more than this fraction of the
+	// keys of the window failed.
+	MaxFailureRate float64
+	// MaxCodeRate, when not 0, trips the breaker when more than this
+	// fraction of the keys of the window failed with the same error code.
+	MaxCodeRate float64
+	// CoolDown is how long the task is paused once tripped.
+	CoolDown time.Duration
+}
+
+// breaker keeps the outcomes of the last keys of a task.
+type breaker struct {
+	Breaker
+
+	mu       sync.Mutex
+	outcomes []string // error codes of the window, empty for successes
+	next     int
+	full     bool
+	failures int
+	codes    map[string]int
+}
+
+// Validate checks that the breaker can trip.
+func (b Breaker) Validate() error {
+	switch {
+	case b.Window < 1:
+		return fmt.Errorf("breaker window must be at least 1 key, got %d", b.Window)
+	case b.MaxFailureRate <= 0 || b.MaxFailureRate > 1:
+		return fmt.Errorf("breaker failure rate must be in (0, 1], got %v", b.MaxFailureRate)
+	case b.MaxCodeRate < 0 || b.MaxCodeRate > 1:
+		return fmt.Errorf("breaker error code rate must be in [0, 1], got %v", b.MaxCodeRate)
+	case b.CoolDown <= 0:
+		return fmt.Errorf("breaker cool-down must be positive, got %v", b.CoolDown)
+	}
+	return nil
+}
+
+// record the outcome of a key, returning why the breaker trips if it does.
+// The window starts over when the breaker trips.
+func (b *breaker) record(err error) (reason string, tripped bool) {
+	code := ""
+	if err != nil {
+		code = errorClass(err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.outcomes == nil {
+		b.outcomes = make([]string, b.Window)
+		b.codes = make(map[string]int)
+	}
+	if old := b.outcomes[b.next]; b.full && old != "" {
+		b.failures--
+		b.codes[old]--
+	}
+	b.outcomes[b.next] = code
+	if code != "" {
+		b.failures++
+		b.codes[code]++
+	}
+	b.next++
+	if b.next == b.Window {
+		b.next, b.full = 0, true
+	}
+	if !b.full {
+		return "", false
+	}
+
+	switch {
+	case float64(b.failures) > b.MaxFailureRate*float64(b.Window):
+		reason = fmt.Sprintf("%d of the last %d keys failed", b.failures, b.Window)
+	case b.MaxCodeRate > 0 && float64(b.codes[code]) > b.MaxCodeRate*float64(b.Window):
+		reason = fmt.Sprintf("%d of the last %d keys failed with %s", b.codes[code], b.Window, code)
+	default:
+		return "", false
+	}
+	b.outcomes, b.next, b.full, b.failures, b.codes = nil, 0, false, 0, nil
+	return reason, true
+}
+
+// recordOutcome feeds the breaker of the task, if it has one, pausing the
+// task for the cool-down of the breaker when it trips.
+func (s *SyncTask) recordOutcome(err error) {
+	if s.breaker == nil {
+		return
+	}
+	reason, tripped := s.breaker.record(err)
+	if !tripped {
+		return
+	}
+	metrics.breakerTrips.Add(1)
+	logrus.WithFields(logrus.Fields{
+		"reason":    reason,
+		"cool_down": s.breaker.CoolDown,
+	}).Error("circuit breaker tripped, pausing the sync")
+	s.ctl.setPaused(true)
+	time.AfterFunc(s.breaker.CoolDown, func() {
+		logrus.WithField("cool_down", s.breaker.CoolDown).Warn("circuit breaker cooled down, resuming the sync")
+		s.ctl.setPaused(false)
+	})
+}
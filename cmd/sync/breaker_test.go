@@ -0,0 +1,121 @@
+package sync_test
+
+import (
+	"bytes"
+	"expvar"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func breakerTrips(t *testing.T) int64 {
+	n, err := strconv.ParseInt(expvar.Get("brigade.sync.breakerTrips").String(), 10, 64)
+	if err != nil {
+		t.Fatalf("can't read breaker trips: %v", err)
+	}
+	return n
+}
+
+func TestBreakerValidate(t *testing.T) {
+	valid := sync.Breaker{Window: 100, MaxFailureRate: 0.5, CoolDown: time.Minute}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("want %+v to be valid, got %v", valid, err)
+	}
+	for _, b := range []sync.Breaker{
+		{MaxFailureRate: 0.5, CoolDown: time.Minute},
+		{Window: 100, CoolDown: time.Minute},
+		{Window: 100, MaxFailureRate: 1.5, CoolDown: time.Minute},
+		{Window: 100, MaxFailureRate: 0.5, MaxCodeRate: -1, CoolDown: time.Minute},
+		{Window: 100, MaxFailureRate: 0.5},
+	} {
+		if err := b.Validate(); err == nil {
+			t.Errorf("want %+v to be invalid", b)
+		}
+	}
+}
+
+func TestBreakerPausesTask(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:20]
+	coolDown := 50 * time.Millisecond
+	for _, tt := range []struct {
+		name    string
+		breaker sync.Breaker
+		fail    func(i int) error
+		trips   int64
+	}{
+		{
+			name:    "failure rate",
+			breaker: sync.Breaker{Window: 4, MaxFailureRate: 0.5, CoolDown: coolDown},
+			fail: func(i int) error {
+				if i < 8 {
+					return &s3.Error{Code: "AccessDenied"}
+				}
+				return nil
+			},
+			trips: 2,
+		},
+		{
+			name:    "error code rate",
+			breaker: sync.Breaker{Window: 4, MaxFailureRate: 1, MaxCodeRate: 0.25, CoolDown: coolDown},
+			fail: func(i int) error {
+				switch i {
+				case 4, 6:
+					return &s3.Error{Code: "AccessDenied"}
+				case 8, 10, 12:
+					return &s3.Error{Code: "InvalidRequest" + strconv.Itoa(i)}
+				}
+				return nil
+			},
+			trips: 1,
+		},
+		{
+			name:    "no failures",
+			breaker: sync.Breaker{Window: 4, MaxFailureRate: 0.1, MaxCodeRate: 0.1, CoolDown: coolDown},
+			fail:    func(i int) error { return nil },
+		},
+	} {
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 1
+		syncTask.RetryBase = time.Millisecond
+		syncTask.Breaker = &tt.breaker
+		calls := 0
+		syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+			calls++
+			return tt.fail(calls - 1)
+		}
+
+		before := breakerTrips(t)
+		start := time.Now()
+		var synced, failed bytes.Buffer
+		if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+			t.Fatalf("%s: can't sync: %v", tt.name, err)
+		}
+		elapsed := time.Since(start)
+
+		if trips := breakerTrips(t) - before; trips != tt.trips {
+			t.Errorf("%s: want %d trips, got %d", tt.name, tt.trips, trips)
+		}
+		if min := time.Duration(tt.trips) * coolDown; elapsed < min {
+			t.Errorf("%s: want the task paused for %v, took %v", tt.name, min, elapsed)
+		}
+		if calls != len(keys) {
+			t.Errorf("%s: want all %d keys synced once paused, got %d", tt.name, len(keys), calls)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudget is returned by Start when the task was aborted because it
+// spent its retry budget.
+var ErrRetryBudget = errors.New("sync task spent its retry budget")
+
+// The actions taken when a task spent its retry budget.
+const (
+	// BudgetFail stops retrying: keys fail at their first error until
+	// enough keys synced to earn retries back.
+	BudgetFail = "fail"
+	// BudgetPause pauses the task for the cool-down of the budget, which
+	// then resumes with half its tokens.
+	BudgetPause = "pause"
+	// BudgetAbort cancels the task, which then returns an AbortError
+	// caused by a *BudgetExceededError, which is ErrRetryBudget.
+	BudgetAbort = "abort"
+)
+
+// RetryBudget caps the retries of a whole task, on top of the MaxRetry of
+// each key, like the client-side throttling of RPC clients: each retry
+// takes a token of the budget, and each key synced gives back a fraction
+// of a token. An outage of the destination makes every key fail, so the
+// budget is spent after a few keys, instead of every key being retried
+// MaxRetry times before the task notices.
+type RetryBudget struct {
+	// Tokens the budget starts with, and holds at most.
+	Tokens int
+	// Refill is the fraction of a token each key synced gives back.
+	Refill float64
+	// Action taken once the budget is spent, one of BudgetFail,
+	// BudgetPause or BudgetAbort.
+	Action string
+	// CoolDown is how long the task pauses with BudgetPause.
+	CoolDown time.Duration
+}
+
+// Validate checks that the budget allows retries, and earns them back.
+func (b RetryBudget) Validate() error {
+	switch {
+	case b.Tokens < 1:
+		return fmt.Errorf("retry budget must be at least 1 retry, got %d", b.Tokens)
+	case b.Refill <= 0 || b.Refill > 1:
+		return fmt.Errorf("retry budget refill must be in (0, 1], got %v", b.Refill)
+	}
+	switch b.Action {
+	case BudgetFail, BudgetAbort:
+	case BudgetPause:
+		if b.CoolDown <= 0 {
+			return fmt.Errorf("retry budget cool-down must be positive, got %v", b.CoolDown)
+		}
+	default:
+		return fmt.Errorf("unknown retry budget action %q, want fail, pause or abort", b.Action)
+	}
+	return nil
+}
+
+// budget holds the tokens of a RetryBudget.
+type budget struct {
+	RetryBudget
+
+	mu     sync.Mutex
+	tokens float64
+	// spent is set once the budget ran out, until it's refilled above half
+	spent bool
+}
+
+func newBudget(b RetryBudget) *budget {
+	return &budget{RetryBudget: b, tokens: float64(b.Tokens)}
+}
+
+// take a token for a retry, false if there's none left. ranOut is only true
+// for the retry that spent the budget.
+func (b *budget) take() (ok, ranOut bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens >= 1 && !b.spent {
+		b.tokens--
+		return true, false
+	}
+	ranOut = !b.spent
+	b.spent = true
+	return false, ranOut
+}
+
+// refill the budget by a key synced.
+func (b *budget) refill() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.Refill
+	if max := float64(b.Tokens); b.tokens > max {
+		b.tokens = max
+	}
+	if b.spent && b.tokens >= float64(b.Tokens)/2 {
+		logrus.WithField("tokens", b.tokens).Warn("retry budget earned back, retrying keys again")
+		b.spent = false
+	}
+}
+
+// resume the budget with half its tokens, once a pause is over.
+func (b *budget) resume() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = float64(b.Tokens) / 2
+	b.spent = false
+}
+
+// spendRetry takes a token of the budget of the task for a retry, if it has
+// one. When the budget runs out, the task takes the action of the budget,
+// and the key isn't retried.
+func (s *SyncTask) spendRetry() bool {
+	if s.budget == nil {
+		return true
+	}
+	ok, ranOut := s.budget.take()
+	if ok {
+		return true
+	}
+	metrics.budgetDenied.Add(1)
+	if !ranOut {
+		return false
+	}
+	metrics.budgetSpent.Add(1)
+	log := logrus.WithFields(logrus.Fields{
+		"tokens": s.budget.Tokens,
+		"action": s.budget.Action,
+	})
+	switch s.budget.Action {
+	case BudgetFail:
+		log.Error("retry budget spent, keys fail without retries")
+	case BudgetPause:
+		log.WithField("cool_down", s.budget.CoolDown).Error("retry budget spent, pausing the sync")
+		s.ctl.setPaused(true)
+		time.AfterFunc(s.budget.CoolDown, func() {
+			logrus.WithField("cool_down", s.budget.CoolDown).Warn("retry budget cooled down, resuming the sync")
+			s.budget.resume()
+			s.ctl.setPaused(false)
+		})
+	case BudgetAbort:
+		log.Error("retry budget spent, aborting the sync")
+		s.ctl.cancelWith(&BudgetExceededError{Tokens: s.budget.Tokens})
+	}
+	return false
+}
+
+// earnRetry gives back a fraction of a token for a key synced.
+func (s *SyncTask) earnRetry() {
+	if s.budget != nil {
+		s.budget.refill()
+	}
+}
@@ -0,0 +1,99 @@
+package sync_test
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSyncRetryBudget(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for i := 0; i < 20; i++ {
+		name := "key-" + strconv.Itoa(i)
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+	// the destination is down
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: s3mock.FailMethod("PUT", s3.Error{StatusCode: http.StatusInternalServerError, Code: s3.ErrInternalError, Message: "We encountered an internal error"}),
+	})
+
+	newTask := func(action string) *sync.SyncTask {
+		syncTask, err := sync.NewSyncTask(src, dst,
+			sync.WithConcurrency(1),
+			sync.WithRetry(10, time.Millisecond),
+			sync.WithRetryBudget(sync.RetryBudget{Tokens: 5, Refill: 0.1, Action: action}),
+		)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		return syncTask
+	}
+
+	syncTask := newTask(sync.BudgetFail)
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	progress := syncTask.Progress()
+	if progress.Failed != int64(len(keys)) {
+		t.Errorf("want all %d keys failed, got %d", len(keys), progress.Failed)
+	}
+	// rather than 9 retries per key
+	if progress.Retries != 5 {
+		t.Errorf("want the 5 retries of the budget, got %d", progress.Retries)
+	}
+
+	syncTask = newTask(sync.BudgetAbort)
+	err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard)
+	if !errors.Is(err, sync.ErrRetryBudget) {
+		t.Errorf("want the sync aborted with %v, got %v", sync.ErrRetryBudget, err)
+	}
+	var abort *sync.AbortError
+	var budget *sync.BudgetExceededError
+	var exhausted *sync.RetriesExhaustedError
+	var s3err *s3.Error
+	switch {
+	case !errors.As(err, &abort) || abort.Progress.Failed == 0:
+		t.Errorf("want an abort with the progress of the sync, got %#v", err)
+	case !errors.As(err, &budget) || budget.Tokens != 5:
+		t.Errorf("want the abort caused by the budget of 5 tokens, got %#v", abort.Cause)
+	case !errors.As(err, &exhausted) || exhausted.Key == "":
+		t.Errorf("want the last key that failed, got %#v", abort.LastFailure)
+	case !errors.As(err, &s3err) || s3err.Code != s3.ErrInternalError:
+		t.Errorf("want the S3 error of the last failure, got %#v", err)
+	}
+	if failed := syncTask.Progress().Failed; failed == int64(len(keys)) {
+		t.Errorf("want the sync aborted before all the keys failed")
+	}
+
+	for _, bad := range []sync.RetryBudget{
+		{Tokens: 0, Refill: 0.1, Action: sync.BudgetFail},
+		{Tokens: 10, Refill: 0, Action: sync.BudgetFail},
+		{Tokens: 10, Refill: 0.1, Action: "retry"},
+		{Tokens: 10, Refill: 0.1, Action: sync.BudgetPause},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// CallBudget caps the sync calls in flight across many tasks, such as the
+// jobs of a daemon sharing its connections to S3, on top of the concurrency
+// of each task. It's shared fairly: a call that ends hands its slot to the
+// waiting task with the fewest calls in flight, so a job with many workers
+// doesn't starve the others.
+type CallBudget struct {
+	max int
+
+	mu       sync.Mutex
+	inflight int
+	waiters  []*callWaiter
+}
+
+// callShare is the part of a budget a task holds.
+type callShare struct {
+	budget   *CallBudget
+	inflight int
+}
+
+type callWaiter struct {
+	share *callShare
+	ready chan struct{}
+}
+
+// NewCallBudget creates a budget of max sync calls in flight.
+func NewCallBudget(max int) (*CallBudget, error) {
+	if max < 1 {
+		return nil, fmt.Errorf("need a budget of at least 1 call, got %d", max)
+	}
+	return &CallBudget{max: max}, nil
+}
+
+// Max is the number of sync calls the budget allows in flight.
+func (b *CallBudget) Max() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.max
+}
+
+// SetMax changes the number of sync calls the budget allows in flight. When
+// it's lowered, the calls in flight go on, and no other call starts until
+// they're fewer than max.
+func (b *CallBudget) SetMax(max int) error {
+	if max < 1 {
+		return fmt.Errorf("need a budget of at least 1 call, got %d", max)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.max = max
+	for b.inflight < b.max && len(b.waiters) > 0 {
+		b.inflight++
+		b.handLocked()
+	}
+	return nil
+}
+
+// Inflight is the number of sync calls of all the tasks in flight.
+func (b *CallBudget) Inflight() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inflight
+}
+
+func (b *CallBudget) share() *callShare { return &callShare{budget: b} }
+
+// acquire a slot for a call, waiting for one to be free, or until ctx is
+// done.
+func (sh *callShare) acquire(ctx context.Context) error {
+	b := sh.budget
+	b.mu.Lock()
+	if b.inflight < b.max && len(b.waiters) == 0 {
+		b.inflight++
+		sh.inflight++
+		b.mu.Unlock()
+		return nil
+	}
+	w := &callWaiter{share: sh, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, other := range b.waiters {
+		if other == w {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// handed a slot while giving up on it
+	b.releaseLocked(sh)
+	return ctx.Err()
+}
+
+// release the slot of a call.
+func (sh *callShare) release() {
+	sh.budget.mu.Lock()
+	defer sh.budget.mu.Unlock()
+	sh.budget.releaseLocked(sh)
+}
+
+func (b *CallBudget) releaseLocked(sh *callShare) {
+	sh.inflight--
+	if len(b.waiters) == 0 || b.inflight > b.max {
+		b.inflight--
+		return
+	}
+	b.handLocked()
+}
+
+// handLocked hands a slot to a waiter.
+func (b *CallBudget) handLocked() {
+	// the slot goes to the task with the fewest calls in flight, the first
+	// to wait among them
+	next := 0
+	for i, w := range b.waiters {
+		if w.share.inflight < b.waiters[next].share.inflight {
+			next = i
+		}
+	}
+	w := b.waiters[next]
+	b.waiters = append(b.waiters[:next], b.waiters[next+1:]...)
+	w.share.inflight++
+	close(w.ready)
+}
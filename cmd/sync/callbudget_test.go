@@ -0,0 +1,85 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	gosync "sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCallBudget(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	budget, err := sync.NewCallBudget(2)
+	if err != nil {
+		t.Fatalf("can't create call budget: %v", err)
+	}
+	if _, err := sync.NewCallBudget(0); err == nil {
+		t.Errorf("want a budget of at least 1 call")
+	}
+
+	var inflight, maxInflight int64
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			max := atomic.LoadInt64(&maxInflight)
+			if n <= max || atomic.CompareAndSwapInt64(&maxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	// a big task, with many workers, and a small one started after it
+	big, small := mockbkt.Keys()[:200], mockbkt.Keys()[200:210]
+	var bigDone, smallDone time.Time
+	var wg gosync.WaitGroup
+	run := func(keys []s3.Key, done *time.Time) {
+		defer wg.Done()
+		syncTask, err := sync.NewSyncTask(src, dst,
+			sync.WithConcurrency(8),
+			sync.WithSyncer(syncer),
+			sync.WithCallBudget(budget),
+		)
+		if err != nil {
+			t.Errorf("can't create sync task: %v", err)
+			return
+		}
+		if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Errorf("can't sync: %v", err)
+		}
+		if p := syncTask.Progress(); p.Synced != int64(len(keys)) {
+			t.Errorf("want all %d keys synced, got %+v", len(keys), p)
+		}
+		*done = time.Now()
+	}
+	wg.Add(2)
+	go run(big, &bigDone)
+	time.Sleep(5 * time.Millisecond)
+	go run(small, &smallDone)
+	wg.Wait()
+
+	if max := atomic.LoadInt64(&maxInflight); max > 2 {
+		t.Errorf("want at most 2 calls in flight, got %d", max)
+	}
+	if !smallDone.Before(bigDone) {
+		t.Errorf("want the small task to get its share of the budget before the big one is done")
+	}
+	if n := budget.Inflight(); n != 0 {
+		t.Errorf("want the budget released, got %d calls in flight", n)
+	}
+}
@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"time"
+)
+
+// ConditionalCopy copies keys like PutCopy, but only if the source key
+// wasn't modified since it was listed, and if the destination key didn't
+// change since the destination was listed in destETags: the keys listed must
+// still have their ETag, the others must still not exist. Without destETags,
+// only the source is checked. A copy whose conditions don't hold fails with
+// PreconditionFailed, which a Conditional task reports as a conflict.
+func ConditionalCopy(destETags map[string]string) CopyFunc {
+	return func(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+		opts, err := copyOptions(src, key)
+		if err != nil {
+			return err
+		}
+		if modified, err := time.Parse(time.RFC3339, key.LastModified); err == nil {
+			opts.CopySourceIfUnmodifiedSince = modified
+		}
+		if destETags != nil {
+			if etag, ok := destETags[dstKey]; ok {
+				opts.IfMatch = etag
+			} else {
+				opts.IfNoneMatch = "*"
+			}
+		}
+		_, err = dst.PutCopy(dstKey, ACLForKey(src, key), opts, CopySource(src.Name, key.Key))
+		return err
+	}
+}
+
+// isConflict is true if err is a conflict of a Conditional task: the source
+// or destination key changed since it was listed.
+func (s *SyncTask) isConflict(err error) bool {
+	return s.Conditional && s3.IsS3Error(err, s3.ErrPreconditionFailed)
+}
+
+// logConflicts logs the keys that weren't copied because they changed since
+// they were listed.
+func (s *SyncTask) logConflicts() {
+	if !s.Conditional {
+		return
+	}
+	logrus.WithField("conflicts", s.stats.conflicts.Value()).Info("keys that changed since they were listed, not copied")
+}
@@ -0,0 +1,95 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestConditionalCopy(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, key := range []string{"a", "b", "c"} {
+		if err := src.Put(key, []byte(key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+		keys = append(keys, s3.Key{Key: key, LastModified: "2016-01-01T00:00:00.000Z"})
+	}
+
+	// "b" changed at the destination since it was listed
+	var mu gosync.Mutex
+	headers := make(map[string]http.Header)
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			if r.Method != "PUT" || r.Header.Get("x-amz-copy-source") == "" {
+				return nil
+			}
+			key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			mu.Lock()
+			headers[key] = r.Header
+			mu.Unlock()
+			if key == "b" {
+				return &s3.Error{StatusCode: http.StatusPreconditionFailed, Code: s3.ErrPreconditionFailed, Message: "changed"}
+			}
+			return nil
+		},
+	})
+
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 2
+	syncTask.RetryBase = time.Millisecond
+	syncTask.Conditional = true
+	syncTask.Sync = sync.Renamed(sync.ConditionalCopy(map[string]string{"b": `"old"`}), syncTask.DestKey)
+
+	var synced, failed bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if got := decodeKeys(&failed); len(got) != 1 || got[0].Key != "b" {
+		t.Errorf("want the key that changed failed, got %v", got)
+	}
+	if got := decodeKeys(&synced); len(got) != 2 {
+		t.Errorf("want the other keys synced, got %v", got)
+	}
+	progress := syncTask.Progress()
+	if progress.Conflicts != 1 || progress.Retries != 0 {
+		t.Errorf("want a conflict that isn't retried, got %d conflicts and %d retries", progress.Conflicts, progress.Retries)
+	}
+
+	want := map[string][2]string{
+		"a": {"If-None-Match", "*"},
+		"b": {"If-Match", `"old"`},
+	}
+	for key, header := range want {
+		h := headers[key]
+		if got := h.Get(header[0]); got != header[1] {
+			t.Errorf("%q: want %s %q, got %q", key, header[0], header[1], got)
+		}
+		if got := h.Get("x-amz-copy-source-if-unmodified-since"); got != "Fri, 01 Jan 2016 00:00:00 GMT" {
+			t.Errorf("%q: want the source unmodified since it was listed, got %q", key, got)
+		}
+	}
+	if got := headers["a"].Get("If-Match"); got != "" {
+		t.Errorf("want no If-Match for a key that wasn't listed, got %q", got)
+	}
+}
@@ -0,0 +1,314 @@
+package sync
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// ErrCancelled is returned by Start when the task was cancelled before all
+// the keys of the input were sync'd.
+var ErrCancelled = errors.New("sync task was cancelled")
+
+// ErrTooManyFailures is returned by Start when the task was stopped because
+// more keys failed to sync than its MaxFailures or MaxFailureRate allow.
+var ErrTooManyFailures = errors.New("too many keys failed to sync")
+
+// minKeysForFailureRate is the number of keys that must be done, synced or
+// failed, before MaxFailureRate is enforced, so that a few early failures
+// don't stop a task.
+const minKeysForFailureRate = 100
+
+// Progress is a snapshot of the counters of a single sync task, unlike the
+// expvar metrics which are shared by all the tasks of the process.
+type Progress struct {
+	Lines    int64 `json:"lines"`
+	Decoded  int64 `json:"decoded"`
+	Inflight int64 `json:"inflight"`
+	Synced   int64 `json:"synced"`
+	Bytes    int64 `json:"bytes"`
+	Failed   int64 `json:"failed"`
+	Skipped  int64 `json:"skipped"`
+	Retries  int64 `json:"retries"`
+	// Parked keys, waiting to be retried at the end of the task.
+	Parked int64 `json:"parked"`
+	// Retrying keys, handed to the retry workers, see RetryPara.
+	Retrying  int64 `json:"retrying"`
+	Paused    bool  `json:"paused"`
+	Cancelled bool  `json:"cancelled"`
+	// Collisions with the keys of the other sources of a fan-in.
+	Collisions int64 `json:"collisions"`
+	// Existing keys at the destination, handled by the Existing policy.
+	Existing int64 `json:"existing"`
+	// Conflicts of a Conditional task, keys that changed since listed.
+	Conflicts int64 `json:"conflicts"`
+	// Unchanged keys at the destination, skipped by the Existing policy.
+	Unchanged int64 `json:"unchanged"`
+	// Malformed lines of the input, skipped or not, see Malformed.
+	Malformed int64 `json:"malformed"`
+	// Panics the workers recovered from, see PanicError.
+	Panics int64 `json:"panics"`
+	// Markers are the directory markers synced, which aren't counted in
+	// Synced, MarkersSkipped those left out, and MarkersCreated those made
+	// at the destination, see Markers.
+	Markers        int64 `json:"markers"`
+	MarkersSkipped int64 `json:"markers_skipped"`
+	MarkersCreated int64 `json:"markers_created"`
+	// HookFailed is the number of synced keys whose hook command failed.
+	HookFailed int64 `json:"hook_failed"`
+	// Hedged is the number of sync calls that were hedged, see Hedging.
+	Hedged int64 `json:"hedged"`
+	// RateCapped is the number of sync calls that waited for the rate of
+	// their prefix, see PrefixRates.
+	RateCapped int64 `json:"rate_capped"`
+	// DiskQueued is the number of keys in the disk queue of the task.
+	DiskQueued int64 `json:"disk_queued"`
+	// OutputQueued is the number of keys waiting to be written to the
+	// synced and failed outputs, Spilled how many of the keys spilled to
+	// disk, and OutputWait the seconds the sync workers waited on the
+	// outputs.
+	OutputQueued int64   `json:"output_queued"`
+	Spilled      int64   `json:"spilled"`
+	OutputWait   float64 `json:"output_wait_s"`
+	// Reordering is the number of keys held to write the synced keys in
+	// the order of the listing, ReorderBytes roughly the memory they take,
+	// and ReorderWait the seconds the sync workers waited for room in the
+	// window, see OrderWindow.
+	Reordering   int64   `json:"reordering"`
+	ReorderBytes int64   `json:"reorder_bytes"`
+	ReorderWait  float64 `json:"reorder_wait_s"`
+	// Decoders is the size of the pool of JSON decoders, and DecodeQueue
+	// and SyncQueue how full, in percent, the channels of lines waiting
+	// for them and of keys waiting for the sync workers were last seen.
+	Decoders    int64 `json:"decoders"`
+	DecodeQueue int64 `json:"decode_queue_pct"`
+	SyncQueue   int64 `json:"sync_queue_pct"`
+	// Latency of the first sync call of each key of the task, and of the
+	// calls retrying them, in milliseconds by quantile.
+	Latency      map[string]float64 `json:"latency"`
+	RetryLatency map[string]float64 `json:"retry_latency"`
+}
+
+// taskStats are the metrics of a task, registered in reg under the names of
+// the fields of Progress.
+type taskStats struct {
+	reg *monitor.Registry
+
+	lines, decoded          *monitor.Counter
+	synced, failed, skipped *monitor.Counter
+	retries, bytes          *monitor.Counter
+	collisions, existing    *monitor.Counter
+	conflicts, unchanged    *monitor.Counter
+	hookFailed, malformed   *monitor.Counter
+	hedged, rateCapped      *monitor.Counter
+	spilled, panics         *monitor.Counter
+	markers, markersSkipped *monitor.Counter
+	markersCreated          *monitor.Counter
+	// nanoseconds
+	outputWait, reorderWait *monitor.Counter
+
+	inflight, parked          *monitor.Gauge
+	retrying                  *monitor.Gauge
+	outputQueued, diskQueued  *monitor.Gauge
+	decoders                  *monitor.Gauge
+	decodeQueue, syncQueue    *monitor.Gauge
+	reorderKeys, reorderBytes *monitor.Gauge
+	// keys the workers are handling
+	busy *monitor.Gauge
+
+	// latency of the first sync calls of the task, and of the retries
+	latency, retryLatency *monitor.Histogram
+}
+
+func newTaskStats() taskStats {
+	reg := monitor.NewRegistry()
+	return taskStats{
+		reg: reg,
+
+		lines:          reg.Counter("lines"),
+		decoded:        reg.Counter("decoded"),
+		synced:         reg.Counter("synced"),
+		failed:         reg.Counter("failed"),
+		skipped:        reg.Counter("skipped"),
+		retries:        reg.Counter("retries"),
+		bytes:          reg.Counter("bytes"),
+		collisions:     reg.Counter("collisions"),
+		existing:       reg.Counter("existing"),
+		conflicts:      reg.Counter("conflicts"),
+		unchanged:      reg.Counter("unchanged"),
+		hookFailed:     reg.Counter("hook_failed"),
+		malformed:      reg.Counter("malformed"),
+		hedged:         reg.Counter("hedged"),
+		rateCapped:     reg.Counter("rate_capped"),
+		spilled:        reg.Counter("spilled"),
+		panics:         reg.Counter("panics"),
+		markers:        reg.Counter("markers"),
+		markersSkipped: reg.Counter("markers_skipped"),
+		markersCreated: reg.Counter("markers_created"),
+		outputWait:     reg.Counter("output_wait"),
+		reorderWait:    reg.Counter("reorder_wait"),
+
+		inflight:     reg.Gauge("inflight"),
+		parked:       reg.Gauge("parked"),
+		retrying:     reg.Gauge("retrying"),
+		outputQueued: reg.Gauge("output_queued"),
+		diskQueued:   reg.Gauge("disk_queued"),
+		decoders:     reg.Gauge("decoders"),
+		decodeQueue:  reg.Gauge("decode_queue_pct"),
+		syncQueue:    reg.Gauge("sync_queue_pct"),
+		reorderKeys:  reg.Gauge("reordering"),
+		reorderBytes: reg.Gauge("reorder_bytes"),
+		busy:         reg.Gauge("busy"),
+
+		latency:      reg.Histogram("latency"),
+		retryLatency: reg.Histogram("retry_latency"),
+	}
+}
+
+// control lets workers be paused, resumed and cancelled.
+type control struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	paused    bool
+	cancelled bool
+	// cause of the cancellation, if it's not ErrCancelled
+	cause error
+	done  chan struct{}
+}
+
+func newControl() *control {
+	c := &control{done: make(chan struct{})}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// wait blocks while the task is paused. It returns false if the task was
+// cancelled.
+func (c *control) wait() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.paused && !c.cancelled {
+		c.cond.Wait()
+	}
+	return !c.cancelled
+}
+
+func (c *control) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = paused
+	c.cond.Broadcast()
+}
+
+func (c *control) cancel() { c.cancelWith(ErrCancelled) }
+
+// cancelWith cancels the task with the error Start returns, unless it was
+// already cancelled.
+func (c *control) cancelWith(cause error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.cancelled {
+		c.cancelled = true
+		c.cause = cause
+		close(c.done)
+	}
+	c.cond.Broadcast()
+}
+
+func (c *control) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cause
+}
+
+func (c *control) state() (paused, cancelled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused, c.cancelled
+}
+
+// Pause the task: workers finish the key they are syncing, then wait until
+// the task is resumed or cancelled.
+func (s *SyncTask) Pause() { s.ctl.setPaused(true) }
+
+// Resume a paused task.
+func (s *SyncTask) Resume() { s.ctl.setPaused(false) }
+
+// Cancel the task: the input stops being read and the keys that were read
+// but not yet sync'd are dropped. They are written to neither the synced
+// nor the failed output.
+func (s *SyncTask) Cancel() { s.ctl.cancel() }
+
+// checkFailures stops the task once more keys failed than MaxFailures or
+// MaxFailureRate allow.
+func (s *SyncTask) checkFailures(failed int64) {
+	synced := s.stats.synced.Value()
+	switch {
+	case s.MaxFailures > 0 && failed > s.MaxFailures:
+	case s.MaxFailureRate > 0 && failed+synced >= minKeysForFailureRate &&
+		float64(failed) > s.MaxFailureRate*float64(failed+synced):
+	default:
+		return
+	}
+	if _, cancelled := s.ctl.state(); cancelled {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"failed":           failed,
+		"synced":           synced,
+		"max_failures":     s.MaxFailures,
+		"max_failure_rate": s.MaxFailureRate,
+	}).Error("too many keys failed to sync, stopping the sync")
+	s.ctl.cancelWith(ErrTooManyFailures)
+}
+
+// Progress returns a snapshot of the counters of the task, all read at
+// once.
+func (s *SyncTask) Progress() Progress {
+	paused, cancelled := s.ctl.state()
+	snap := s.stats.reg.Snapshot()
+	return Progress{
+		Lines:     snap.Counters["lines"],
+		Decoded:   snap.Counters["decoded"],
+		Inflight:  snap.Gauges["inflight"],
+		Synced:    snap.Counters["synced"],
+		Bytes:     snap.Counters["bytes"],
+		Failed:    snap.Counters["failed"],
+		Skipped:   snap.Counters["skipped"],
+		Retries:   snap.Counters["retries"],
+		Parked:    snap.Gauges["parked"],
+		Retrying:  snap.Gauges["retrying"],
+		Paused:    paused,
+		Cancelled: cancelled,
+
+		Collisions:     snap.Counters["collisions"],
+		Existing:       snap.Counters["existing"],
+		Conflicts:      snap.Counters["conflicts"],
+		Unchanged:      snap.Counters["unchanged"],
+		HookFailed:     snap.Counters["hook_failed"],
+		Malformed:      snap.Counters["malformed"],
+		Panics:         snap.Counters["panics"],
+		Markers:        snap.Counters["markers"],
+		MarkersSkipped: snap.Counters["markers_skipped"],
+		MarkersCreated: snap.Counters["markers_created"],
+		Hedged:         snap.Counters["hedged"],
+		RateCapped:     snap.Counters["rate_capped"],
+		DiskQueued:     snap.Gauges["disk_queued"],
+
+		OutputQueued: snap.Gauges["output_queued"],
+		Spilled:      snap.Counters["spilled"],
+		OutputWait:   time.Duration(snap.Counters["output_wait"]).Seconds(),
+		Reordering:   snap.Gauges["reordering"],
+		ReorderBytes: snap.Gauges["reorder_bytes"],
+		ReorderWait:  time.Duration(snap.Counters["reorder_wait"]).Seconds(),
+
+		Decoders:    snap.Gauges["decoders"],
+		DecodeQueue: snap.Gauges["decode_queue_pct"],
+		SyncQueue:   snap.Gauges["sync_queue_pct"],
+
+		Latency:      snap.Histograms["latency"].Millis(),
+		RetryLatency: snap.Histograms["retry_latency"].Millis(),
+	}
+}
@@ -0,0 +1,27 @@
+package sync
+
+// CopySource is the value of the x-amz-copy-source header to copy a key
+// from a bucket. S3 URL-decodes the header, so keys with spaces, '+', '#',
+// '?', '%' or non-ASCII characters are copied from the wrong key, or not at
+// all, unless they're percent-encoded.
+func CopySource(bucket, key string) string {
+	return bucket + "/" + escapeKey(key)
+}
+
+// escapeKey percent-encodes every byte of a key except the unreserved
+// characters and '/', which separates the path segments.
+func escapeKey(key string) string {
+	const hexDigits = "0123456789ABCDEF"
+	buf := make([]byte, 0, len(key))
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			buf = append(buf, c)
+		default:
+			buf = append(buf, '%', hexDigits[c>>4], hexDigits[c&15])
+		}
+	}
+	return string(buf)
+}
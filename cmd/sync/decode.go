@@ -0,0 +1,268 @@
+package sync
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+)
+
+// maxInterned is the number of distinct values a KeyDecoder interns before
+// it starts over, in case a listing has more owners than expected.
+const maxInterned = 4096
+
+var errSlowPath = errors.New("line needs the slow path")
+
+// KeyDecoder decodes keys from the JSON lines of a listing. Lines in the
+// format written by the list command are decoded without reflection, with a
+// single allocation for the name, date and ETag of the key. The storage
+// class and owner, which repeat from line to line, are only allocated once.
+// Other lines, such as the failures of failed outputs, go through
+// encoding/json.
+//
+// A KeyDecoder isn't safe for concurrent use, use one per goroutine.
+type KeyDecoder struct {
+	interned map[string]string
+	buf      []byte
+	// values of the line that are unique to the key, allocated together
+	values []byte
+}
+
+// NewKeyDecoder creates a decoder of JSON lines.
+func NewKeyDecoder() *KeyDecoder {
+	return &KeyDecoder{interned: make(map[string]string)}
+}
+
+// Decode a line into key, overwriting all its fields.
+func (d *KeyDecoder) Decode(line []byte, key *s3.Key) error {
+	*key = s3.Key{}
+	if err := d.decodeFast(line, key); err == nil {
+		return nil
+	}
+	return listing.UnmarshalKey(line, key)
+}
+
+func (d *KeyDecoder) decodeFast(line []byte, key *s3.Key) error {
+	p := parser{d: d, b: line}
+	d.values = d.values[:0]
+	var name, modified, etag span
+	err := p.object(func(field []byte) error {
+		var err error
+		switch string(field) {
+		case "Key":
+			name, err = p.value()
+		case "LastModified":
+			modified, err = p.value()
+		case "ETag":
+			etag, err = p.value()
+		case "StorageClass":
+			key.StorageClass, err = p.interned()
+		case "Size":
+			key.Size, err = p.int()
+		case "Owner":
+			err = p.object(func(field []byte) error {
+				var err error
+				switch string(field) {
+				case "ID":
+					key.Owner.ID, err = p.interned()
+				case "DisplayName":
+					key.Owner.DisplayName, err = p.interned()
+				default:
+					err = errSlowPath
+				}
+				return err
+			})
+		default:
+			err = errSlowPath
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	p.space()
+	if p.i != len(p.b) {
+		return errSlowPath
+	}
+
+	values := string(d.values)
+	key.Key = values[name.start:name.end]
+	key.LastModified = values[modified.start:modified.end]
+	key.ETag = values[etag.start:etag.end]
+	return nil
+}
+
+// span of a value in KeyDecoder.values.
+type span struct{ start, end int }
+
+func (d *KeyDecoder) intern(b []byte) string {
+	// the conversion in the map lookup doesn't allocate
+	if s, ok := d.interned[string(b)]; ok {
+		return s
+	}
+	if len(d.interned) >= maxInterned {
+		d.interned = make(map[string]string)
+	}
+	s := string(b)
+	d.interned[s] = s
+	return s
+}
+
+// parser of the subset of JSON used by listings. Anything it doesn't
+// expect, like nulls or unicode escapes, sends the line to the slow path.
+type parser struct {
+	d *KeyDecoder
+	b []byte
+	i int
+}
+
+func (p *parser) space() {
+	for p.i < len(p.b) {
+		switch p.b[p.i] {
+		case ' ', '\t', '\r', '\n':
+			p.i++
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) consume(c byte) bool {
+	p.space()
+	if p.i < len(p.b) && p.b[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+// object parses an object, calling field to parse the value of each field.
+func (p *parser) object(field func(name []byte) error) error {
+	if !p.consume('{') {
+		return errSlowPath
+	}
+	if p.consume('}') {
+		return nil
+	}
+	for {
+		p.space()
+		name, err := p.raw()
+		if err != nil {
+			return err
+		}
+		if !p.consume(':') {
+			return errSlowPath
+		}
+		p.space()
+		if err := field(name); err != nil {
+			return err
+		}
+		if p.consume('}') {
+			return nil
+		}
+		if !p.consume(',') {
+			return errSlowPath
+		}
+	}
+}
+
+// raw returns the bytes of a string, unescaped in the decoder's buffer if
+// needed. They're only valid until the next call.
+func (p *parser) raw() ([]byte, error) {
+	if p.i >= len(p.b) || p.b[p.i] != '"' {
+		return nil, errSlowPath
+	}
+	p.i++
+	start := p.i
+	for p.i < len(p.b) {
+		switch c := p.b[p.i]; {
+		case c == '"':
+			p.i++
+			return p.b[start : p.i-1], nil
+		case c == '\\':
+			return p.escaped(start)
+		case c < ' ':
+			return nil, errSlowPath
+		}
+		p.i++
+	}
+	return nil, errSlowPath
+}
+
+// escaped finishes parsing a string that has escapes, only handling the
+// ones found in keys and ETags.
+func (p *parser) escaped(start int) ([]byte, error) {
+	buf := append(p.d.buf[:0], p.b[start:p.i]...)
+	for p.i < len(p.b) {
+		c := p.b[p.i]
+		p.i++
+		switch {
+		case c == '"':
+			p.d.buf = buf
+			return buf, nil
+		case c == '\\':
+			if p.i >= len(p.b) {
+				return nil, errSlowPath
+			}
+			switch e := p.b[p.i]; e {
+			case '"', '\\', '/':
+				buf = append(buf, e)
+			default:
+				return nil, errSlowPath
+			}
+			p.i++
+		case c < ' ':
+			return nil, errSlowPath
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return nil, errSlowPath
+}
+
+// value appends a string to the values of the line.
+func (p *parser) value() (span, error) {
+	b, err := p.raw()
+	if err != nil {
+		return span{}, err
+	}
+	start := len(p.d.values)
+	p.d.values = append(p.d.values, b...)
+	return span{start, len(p.d.values)}, nil
+}
+
+func (p *parser) interned() (string, error) {
+	b, err := p.raw()
+	if err != nil {
+		return "", err
+	}
+	return p.d.intern(b), nil
+}
+
+func (p *parser) int() (int64, error) {
+	neg := p.i < len(p.b) && p.b[p.i] == '-'
+	if neg {
+		p.i++
+	}
+	start := p.i
+	var n int64
+	for p.i < len(p.b) && '0' <= p.b[p.i] && p.b[p.i] <= '9' {
+		if n > (1<<63-1)/10 {
+			return 0, errSlowPath
+		}
+		n = n*10 + int64(p.b[p.i]-'0')
+		p.i++
+	}
+	if p.i == start || n < 0 {
+		return 0, errSlowPath
+	}
+	if p.i < len(p.b) {
+		switch p.b[p.i] {
+		case '.', 'e', 'E':
+			return 0, errSlowPath
+		}
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
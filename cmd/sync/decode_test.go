@@ -0,0 +1,138 @@
+package sync_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestKeyDecoderMatchesEncodingJSON(t *testing.T) {
+	lines := []string{
+		`{"Key":"a","LastModified":"2014-06-08T02:56:15.000Z","Size":338194,"ETag":"\"110d4bc899e5ecf1f64a5c9bddd99851\"","StorageClass":"STANDARD","Owner":{"ID":"2e42","DisplayName":"amazon-office"}}`,
+		`{"Key":"with \"quotes\" and \\ and \/","Size":0,"Owner":{}}`,
+		`{"Key":"unicode é😀","Size":-1}`,
+		`{"Key":"ünïcödé 日本","Size":9223372036854775807}`,
+		`  { "Key" : "spaced" , "Size" : 12 , "Owner" : { "ID" : "x" } }  ` + "\n",
+		`{"key":"lowercase field","size":3}`,
+		`{"Key":"unknown field","Extra":[1,2,{"a":null}]}`,
+		`{"Key":null,"Size":1000}`,
+		`{"Key":"dup","Key":"last wins"}`,
+		`{}`,
+	}
+	dec := sync.NewKeyDecoder()
+	for _, line := range lines {
+		var want, got s3.Key
+		if err := json.Unmarshal([]byte(line), &want); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		// dirty the key, to check it's overwritten
+		got = s3.Key{Key: "dirty", Size: 42, Owner: s3.Owner{ID: "dirty"}}
+		if err := dec.Decode([]byte(line), &got); err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %+v, got %+v", line, want, got)
+		}
+	}
+
+	for _, line := range []string{``, `{`, `{"Key":"a"`, `{"Key":"a"} trailing`, `[1]`} {
+		var key s3.Key
+		if err := dec.Decode([]byte(line), &key); err == nil {
+			t.Errorf("%q: want an error", line)
+		}
+	}
+}
+
+// perfLines are the lines of the listing of the perf bucket
+func perfLines(b *testing.B) [][]byte {
+	rd, ok := s3mock.GetBucketListJSON("bucket_list.json")
+	if !ok {
+		b.Fatal("no perf bucket listing")
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(rd); err != nil {
+		b.Fatal(err)
+	}
+	return bytes.SplitAfter(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+}
+
+func BenchmarkDecodeJSON(b *testing.B) {
+	lines := perfLines(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	var key s3.Key
+	for i := 0; i < b.N; i++ {
+		if err := json.Unmarshal(lines[i%len(lines)], &key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkKeyDecoder(b *testing.B) {
+	lines := perfLines(b)
+	dec := sync.NewKeyDecoder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	var key s3.Key
+	for i := 0; i < b.N; i++ {
+		if err := dec.Decode(lines[i%len(lines)], &key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodePool syncs a listing with a syncer that does nothing, so
+// that the decoders are the bottleneck, with a fixed pool of decoders and
+// with a pool that resizes itself.
+func BenchmarkDecodePool(b *testing.B) {
+	lines := perfLines(b)
+	mocks3 := s3mock.NewMock(b)
+	defer mocks3.Close()
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			b.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	nop := func(src, dst *s3.Bucket, key s3.Key) error { return nil }
+
+	for _, bb := range []struct {
+		name          string
+		decoders, max int
+	}{
+		{"fixed-1", 1, 1},
+		{"fixed-cpus", runtime.NumCPU(), runtime.NumCPU()},
+		{"tuned", 1, 4 * runtime.NumCPU()},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			var input bytes.Buffer
+			for i := 0; i < b.N; i++ {
+				input.Write(lines[i%len(lines)])
+				if lines[i%len(lines)][len(lines[i%len(lines)])-1] != '\n' {
+					input.WriteByte('\n')
+				}
+			}
+			syncTask, err := sync.NewSyncTask(src, dst,
+				sync.WithSyncer(nop),
+				sync.WithConcurrency(100),
+				sync.WithDecoders(bb.decoders),
+				sync.WithMaxDecoders(bb.max),
+			)
+			if err != nil {
+				b.Fatalf("can't create sync task: %v", err)
+			}
+			b.ResetTimer()
+			if err := syncTask.Start(&input, ioutil.Discard, ioutil.Discard); err != nil {
+				b.Fatalf("can't sync: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package sync
+
+import (
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"time"
+)
+
+var (
+	// decodeTuneEvery is how often the occupancy of the channels around the
+	// decoders is sampled, and decodeTuneWindow how many samples the decode
+	// pool looks at before it resizes.
+	decodeTuneEvery  = 100 * time.Millisecond
+	decodeTuneWindow = 10
+)
+
+// thresholds of the average occupancy of the channels around the decoders,
+// from 0 when empty to 1 when full.
+const (
+	// lines wait for the decoders, which are the bottleneck
+	decodeBacklogged = 0.75
+	// decoders wait for lines, the input is the bottleneck
+	decodeStarved = 0.25
+	// decoders wait for the sync workers, which are the bottleneck
+	syncBacklogged = 0.9
+)
+
+// decodePool is a pool of decoders that resizes itself, between 1 and max
+// decoders, by how full the channels of lines it reads and keys it writes
+// are: how long lines line up for decoding depends on their size and the
+// speed of the input, not on the number of CPUs.
+type decodePool struct {
+	task  *SyncTask
+	lines chan *[]byte
+	keys  chan s3.Key
+	max   int
+
+	workers pipeline.Workers
+	// retire a decoder, whichever takes it
+	retire chan struct{}
+	// decoders running
+	size int
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startDecodePool starts n decoders of the lines, and, if max is above n,
+// resizes the pool until stopped.
+func (s *SyncTask) startDecodePool(n, max int, lines chan *[]byte, keys chan s3.Key) *decodePool {
+	p := &decodePool{
+		task:   s,
+		lines:  lines,
+		keys:   keys,
+		max:    max,
+		retire: make(chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	p.grow(n)
+	if max > n {
+		go p.tune()
+	} else {
+		close(p.done)
+	}
+	return p
+}
+
+// wait until the lines are closed and decoded.
+func (p *decodePool) wait() {
+	close(p.stop)
+	<-p.done
+	p.workers.Wait()
+}
+
+func (p *decodePool) grow(n int) {
+	p.size += n
+	p.setSize()
+	p.workers.Start(n, func(int) { p.task.decode(p.lines, p.keys, p.retire) })
+}
+
+// shrink by a decoder, unless they're all busy.
+func (p *decodePool) shrink() bool {
+	select {
+	case p.retire <- struct{}{}:
+		p.size--
+		p.setSize()
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *decodePool) setSize() {
+	metrics.decoders.Set(int64(p.size))
+	p.task.stats.decoders.Set(int64(p.size))
+}
+
+// tune the size of the pool, from the average occupancy of the channels
+// over decodeTuneWindow samples.
+func (p *decodePool) tune() {
+	defer close(p.done)
+	tick := time.NewTicker(decodeTuneEvery)
+	defer tick.Stop()
+
+	var samples int
+	var lines, keys float64
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-tick.C:
+		}
+		lineOcc := float64(len(p.lines)) / float64(cap(p.lines))
+		keyOcc := float64(len(p.keys)) / float64(cap(p.keys))
+		p.task.stats.decodeQueue.Set(int64(lineOcc * 100))
+		p.task.stats.syncQueue.Set(int64(keyOcc * 100))
+
+		samples++
+		lines += lineOcc
+		keys += keyOcc
+		if samples < decodeTuneWindow {
+			continue
+		}
+		lines, keys = lines/float64(samples), keys/float64(samples)
+
+		from := p.size
+		switch {
+		case keys > syncBacklogged, lines < decodeStarved:
+			if p.size > 1 {
+				p.shrink()
+			}
+		case lines > decodeBacklogged && p.size < p.max:
+			// double, to catch up quickly with a backlog
+			n := p.size
+			if p.size+n > p.max {
+				n = p.max - p.size
+			}
+			p.grow(n)
+		}
+		if p.size != from {
+			logrus.WithFields(logrus.Fields{
+				"from":         from,
+				"to":           p.size,
+				"decode_queue": lines,
+				"sync_queue":   keys,
+			}).Info("resized decode pool")
+		}
+		samples, lines, keys = 0, 0, 0
+	}
+}
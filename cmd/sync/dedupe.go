@@ -0,0 +1,174 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Dedupe tracks the content of the keys a task syncs, by their size and
+// ETag, to report once it's done the keys of the destination that hold the
+// same content, such as the same files synced from many source buckets
+// consolidated into one. The ETag of a key uploaded whole is the MD5 of its
+// content, so keys of the same size and ETag are duplicates. Keys uploaded
+// in parts have the ETag of their parts, which only matches for the same
+// content uploaded in parts of the same size, so some of their duplicates
+// go unnoticed, but none is reported wrongly. Keys without an ETag, such as
+// those of the listings of other sources, aren't tracked.
+//
+// A key synced again to the same name holds the content it was synced with
+// last, and is counted once. The name of every key synced is held until the
+// task is done, about the size of the name and its ETag plus 100 bytes a
+// key.
+type Dedupe struct {
+	// MinSize is the smallest key tracked, in bytes, so that the many
+	// small keys holding the same few bytes, such as empty files, don't
+	// swamp the report.
+	MinSize int64
+}
+
+// Validate the smallest size tracked.
+func (d Dedupe) Validate() error {
+	if d.MinSize < 0 {
+		return fmt.Errorf("smallest key to dedupe can't be negative, got %d", d.MinSize)
+	}
+	return nil
+}
+
+// DuplicateSet is a set of keys of the destination holding the same content,
+// by name. Reclaimable is the bytes freed by keeping only one of them.
+type DuplicateSet struct {
+	Size        int64    `json:"size"`
+	ETag        string   `json:"etag"`
+	Keys        []string `json:"keys"`
+	Reclaimable int64    `json:"reclaimable_bytes"`
+}
+
+// DedupeReport is the outcome of DedupeKeys: the keys tracked and the sets
+// of those holding the same content, the most reclaimable bytes first.
+// Duplicates is the keys that are copies of another, all but one a set.
+type DedupeReport struct {
+	Tracked     int64          `json:"tracked"`
+	Untracked   int64          `json:"untracked"`
+	Duplicates  int64          `json:"duplicates"`
+	Reclaimable int64          `json:"reclaimable_bytes"`
+	Sets        []DuplicateSet `json:"sets,omitempty"`
+}
+
+type contentKey struct {
+	size int64
+	etag string
+}
+
+// dedupeKeys holds the names of the keys synced by their content, and the
+// content of each name, the zero contentKey for the keys not tracked.
+type dedupeKeys struct {
+	mu        sync.Mutex
+	names     map[contentKey]map[string]struct{}
+	contents  map[string]contentKey
+	untracked int64
+}
+
+// forget the content that name held, if any.
+func (d *dedupeKeys) forget(name string) {
+	c, ok := d.contents[name]
+	if !ok {
+		return
+	}
+	delete(d.contents, name)
+	if c == (contentKey{}) {
+		d.untracked--
+		return
+	}
+	if names := d.names[c]; len(names) == 1 {
+		delete(d.names, c)
+	} else {
+		delete(names, name)
+	}
+}
+
+// trackContent of a key synced to the destination as name.
+func (s *SyncTask) trackContent(key s3.Key, name string) {
+	if s.Dedupe == nil {
+		return
+	}
+	etag := strings.Trim(key.ETag, `"`)
+	s.dedupe.mu.Lock()
+	defer s.dedupe.mu.Unlock()
+	if s.dedupe.names == nil {
+		s.dedupe.names = make(map[contentKey]map[string]struct{})
+		s.dedupe.contents = make(map[string]contentKey)
+	}
+	// the name no longer holds what it was synced with before
+	s.dedupe.forget(name)
+	if etag == "" || key.Size < s.Dedupe.MinSize {
+		s.dedupe.contents[name] = contentKey{}
+		s.dedupe.untracked++
+		return
+	}
+	c := contentKey{size: key.Size, etag: etag}
+	names := s.dedupe.names[c]
+	if names == nil {
+		names = make(map[string]struct{}, 1)
+		s.dedupe.names[c] = names
+	}
+	names[name] = struct{}{}
+	s.dedupe.contents[name] = c
+}
+
+// DedupeKeys reports the keys synced by the task that hold the same content
+// at the destination, once the task is done. A key synced many times, such
+// as from many sources, is a single key, holding the content it was synced
+// with last.
+func (s *SyncTask) DedupeKeys() DedupeReport {
+	s.dedupe.mu.Lock()
+	r := DedupeReport{
+		Tracked:   int64(len(s.dedupe.contents)) - s.dedupe.untracked,
+		Untracked: s.dedupe.untracked,
+	}
+	for c, names := range s.dedupe.names {
+		if len(names) < 2 {
+			continue
+		}
+		keys := make([]string, 0, len(names))
+		for name := range names {
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		r.Sets = append(r.Sets, DuplicateSet{
+			Size:        c.size,
+			ETag:        c.etag,
+			Keys:        keys,
+			Reclaimable: c.size * int64(len(keys)-1),
+		})
+	}
+	s.dedupe.mu.Unlock()
+
+	sort.Sort(byReclaimable(r.Sets))
+	for _, set := range r.Sets {
+		r.Duplicates += int64(len(set.Keys) - 1)
+		r.Reclaimable += set.Reclaimable
+	}
+	metrics.duplicateKeys.Add(r.Duplicates)
+
+	// the summary only keeps the totals
+	totals := r
+	totals.Sets = nil
+	s.summary.mu.Lock()
+	s.summary.dedupe = &totals
+	s.summary.mu.Unlock()
+	return r
+}
+
+type byReclaimable []DuplicateSet
+
+func (b byReclaimable) Len() int      { return len(b) }
+func (b byReclaimable) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byReclaimable) Less(i, j int) bool {
+	if b[i].Reclaimable != b[j].Reclaimable {
+		return b[i].Reclaimable > b[j].Reclaimable
+	}
+	return b[i].Keys[0] < b[j].Keys[0]
+}
@@ -0,0 +1,131 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupeKeys(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	keys := []s3.Key{
+		{Key: "team-a/logo.png", Size: 5, ETag: `"5d41402abc4b2a76b9719d911017c592"`},
+		{Key: "team-b/logo.png", Size: 5, ETag: `"5d41402abc4b2a76b9719d911017c592"`},
+		{Key: "team-c/img/logo.png", Size: 5, ETag: "5d41402abc4b2a76b9719d911017c592"},
+		// listed twice, it's still a single key
+		{Key: "team-c/img/logo.png", Size: 5, ETag: "5d41402abc4b2a76b9719d911017c592"},
+		{Key: "team-a/big.bin", Size: 20, ETag: `"0ff1b5e8e1a6c2d3a4b5c6d7e8f90a1b-2"`},
+		{Key: "team-b/big.bin", Size: 20, ETag: `"0ff1b5e8e1a6c2d3a4b5c6d7e8f90a1b-2"`},
+		// same ETag, other size
+		{Key: "team-b/other.bin", Size: 21, ETag: `"0ff1b5e8e1a6c2d3a4b5c6d7e8f90a1b-2"`},
+		{Key: "team-a/unique", Size: 6, ETag: `"b1946ac92492d2347c6235b4d2611184"`},
+		// too small, and without an ETag
+		{Key: "team-a/.keep", ETag: `"d41d8cd98f00b204e9800998ecf8427e"`},
+		{Key: "team-b/.keep", ETag: `"d41d8cd98f00b204e9800998ecf8427e"`},
+		{Key: "team-c/no-etag", Size: 5},
+	}
+	for _, key := range keys {
+		if err := src.Put(key.Key, make([]byte, key.Size), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithDedupe(sync.Dedupe{MinSize: 1}))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	r := syncTask.DedupeKeys()
+	want := []sync.DuplicateSet{
+		{Size: 20, ETag: "0ff1b5e8e1a6c2d3a4b5c6d7e8f90a1b-2", Keys: []string{"team-a/big.bin", "team-b/big.bin"}, Reclaimable: 20},
+		{Size: 5, ETag: "5d41402abc4b2a76b9719d911017c592", Keys: []string{"team-a/logo.png", "team-b/logo.png", "team-c/img/logo.png"}, Reclaimable: 10},
+	}
+	if !reflect.DeepEqual(r.Sets, want) {
+		t.Errorf("want duplicate sets %+v, got %+v", want, r.Sets)
+	}
+	if r.Tracked != 7 || r.Untracked != 3 || r.Duplicates != 3 || r.Reclaimable != 30 {
+		t.Errorf("want 7 keys tracked, 3 untracked, 3 duplicates and 30 bytes reclaimable, got %+v", r)
+	}
+
+	sum := syncTask.Summary()
+	if sum.Dedupe == nil || sum.Dedupe.Sets != nil || !strings.Contains(sum.String(), "3 of 7 keys tracked hold the content of another") {
+		t.Errorf("want the totals of the duplicates in the summary, got %+v\n%s", sum.Dedupe, sum)
+	}
+
+	if _, err := sync.NewSyncTask(src, dst, sync.WithDedupe(sync.Dedupe{MinSize: -1})); err == nil {
+		t.Errorf("want a negative size refused")
+	}
+}
+
+func TestDedupeKeysSyncedAgain(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	// a.png is synced again once it changed, it no longer holds the
+	// content of b.png but that of c.png
+	keys := []s3.Key{
+		{Key: "a.png", Size: 5, ETag: `"5d41402abc4b2a76b9719d911017c592"`},
+		{Key: "b.png", Size: 5, ETag: `"5d41402abc4b2a76b9719d911017c592"`},
+		{Key: "a.png", Size: 6, ETag: `"b1946ac92492d2347c6235b4d2611184"`},
+		{Key: "c.png", Size: 6, ETag: `"b1946ac92492d2347c6235b4d2611184"`},
+		// d.png without an ETag, holding nothing tracked
+		{Key: "d.png", Size: 6, ETag: `"b1946ac92492d2347c6235b4d2611184"`},
+		{Key: "d.png", Size: 6},
+		// and e.png the other way around
+		{Key: "e.png", Size: 6},
+		{Key: "e.png", Size: 6, ETag: `"b1946ac92492d2347c6235b4d2611184"`},
+	}
+	for _, key := range keys {
+		if err := src.Put(key.Key, make([]byte, key.Size), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithDedupe(sync.Dedupe{}), sync.WithConcurrency(1))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	// the keys are synced in the order of the listing
+	syncTask.DecodePara = 1
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	r := syncTask.DedupeKeys()
+	want := []sync.DuplicateSet{
+		{Size: 6, ETag: "b1946ac92492d2347c6235b4d2611184", Keys: []string{"a.png", "c.png", "e.png"}, Reclaimable: 12},
+	}
+	if !reflect.DeepEqual(r.Sets, want) {
+		t.Errorf("want duplicate sets %+v, got %+v", want, r.Sets)
+	}
+	// keys rather than syncs
+	if r.Tracked != 4 || r.Untracked != 1 {
+		t.Errorf("want 4 keys tracked and 1 untracked, got %d and %d", r.Tracked, r.Untracked)
+	}
+}
@@ -0,0 +1,375 @@
+package sync
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"time"
+)
+
+// DeleteBatch is the most keys a DeleteObjects call deletes.
+const DeleteBatch = 1000
+
+var deleteMetrics = struct {
+	batches     *expvar.Int
+	deleted     *expvar.Int
+	failed      *expvar.Int
+	retries     *expvar.Int
+	quarantined *expvar.Int
+}{
+	batches:     expvar.NewInt("brigade.delete.batches"),
+	deleted:     expvar.NewInt("brigade.delete.deleted"),
+	failed:      expvar.NewInt("brigade.delete.failed"),
+	retries:     expvar.NewInt("brigade.delete.retries"),
+	quarantined: expvar.NewInt("brigade.delete.quarantined"),
+}
+
+// DeleteTask deletes the keys of a listing from a bucket, such as the keys
+// a diff found at a destination but not at its source. Keys are deleted in
+// batches with DeleteObjects, many batches at once, and each batch is
+// retried like the keys of a SyncTask, but only with its keys that failed.
+type DeleteTask struct {
+	RetryBase time.Duration
+	MaxRetry  int
+	// DeletePara is the number of batches deleted at once, and BatchSize
+	// the number of keys in a batch, at most DeleteBatch.
+	DeletePara int
+	BatchSize  int
+
+	// OutputFormat is the encoder of the deleted and failed outputs, see
+	// listing.NewEncoder, JSON when empty.
+	OutputFormat string
+
+	// RunID, when set, is attached to the failures of the task.
+	RunID string
+
+	// Quarantine, when set, is the prefix under which the keys are moved
+	// rather than deleted, such as the QuarantinePrefix of the run: each is
+	// copied under it first, and only deleted once copied. The keys already
+	// in the trash are left for a purge, and are neither moved nor deleted.
+	Quarantine string
+
+	bkt   *s3.Bucket
+	stats deleteStats
+	// abort is the S3 error that every call would get, which stops the
+	// task
+	abort pipeline.FirstError
+}
+
+// DeleteProgress counts the keys of a DeleteTask so far.
+type DeleteProgress struct {
+	Keys    int64 `json:"keys"`
+	Batches int64 `json:"batches"`
+	Deleted int64 `json:"deleted"`
+	Failed  int64 `json:"failed"`
+	Retries int64 `json:"retries"`
+	// Quarantined keys were moved to the trash before they were deleted,
+	// and Trashed keys were already in it.
+	Quarantined int64 `json:"quarantined,omitempty"`
+	Trashed     int64 `json:"trashed,omitempty"`
+}
+
+type deleteStats struct {
+	reg                  *monitor.Registry
+	keys, batches        *monitor.Counter
+	deleted, failed      *monitor.Counter
+	retries              *monitor.Counter
+	quarantined, trashed *monitor.Counter
+}
+
+func newDeleteStats() deleteStats {
+	reg := monitor.NewRegistry()
+	return deleteStats{
+		reg:         reg,
+		keys:        reg.Counter("keys"),
+		batches:     reg.Counter("batches"),
+		deleted:     reg.Counter("deleted"),
+		failed:      reg.Counter("failed"),
+		retries:     reg.Counter("retries"),
+		quarantined: reg.Counter("quarantined"),
+		trashed:     reg.Counter("trashed"),
+	}
+}
+
+// DeleteGuard refuses to delete more than MaxFraction of the keys of a
+// bucket at once, such as when the source listing of a mirror was truncated
+// and a diff against it has most of the destination to delete.
+type DeleteGuard struct {
+	MaxFraction float64
+}
+
+// Validate that the fraction is in (0, 1].
+func (g DeleteGuard) Validate() error {
+	if g.MaxFraction <= 0 || g.MaxFraction > 1 {
+		return fmt.Errorf("the fraction of the keys deleted must be in (0, 1], got %v", g.MaxFraction)
+	}
+	return nil
+}
+
+// Check that deleting deletes keys of a bucket holding keys is within the
+// guard. It returns a *TooManyDeletesError when it isn't.
+func (g DeleteGuard) Check(deletes, keys int64) error {
+	if float64(deletes) > g.MaxFraction*float64(keys) {
+		return &TooManyDeletesError{Deletes: deletes, Keys: keys, MaxFraction: g.MaxFraction}
+	}
+	return nil
+}
+
+// TooManyDeletesError is the refusal of a DeleteGuard.
+type TooManyDeletesError struct {
+	Deletes, Keys int64
+	MaxFraction   float64
+}
+
+func (e *TooManyDeletesError) Error() string {
+	return fmt.Sprintf("refusing to delete %d of the %d keys of the bucket, more than %.f%% of them", e.Deletes, e.Keys, e.MaxFraction*100)
+}
+
+// NewDeleteTask creates a task that deletes keys from bkt. It fails if the
+// bucket can't be listed.
+func NewDeleteTask(bkt *s3.Bucket) (*DeleteTask, error) {
+	if _, err := bkt.List("/", "/", "/", 1); err != nil {
+		return nil, fmt.Errorf("couldn't list bucket %q: %v", bkt.Name, err)
+	}
+	return &DeleteTask{
+		RetryBase:  time.Second,
+		MaxRetry:   50,
+		DeletePara: 10,
+		BatchSize:  DeleteBatch,
+		bkt:        bkt,
+		stats:      newDeleteStats(),
+	}, nil
+}
+
+// Progress of the task so far.
+func (d *DeleteTask) Progress() DeleteProgress {
+	snap := d.stats.reg.Snapshot()
+	return DeleteProgress{
+		Keys:    snap.Counters["keys"],
+		Batches: snap.Counters["batches"],
+		Deleted: snap.Counters["deleted"],
+		Failed:  snap.Counters["failed"],
+		Retries: snap.Counters["retries"],
+
+		Quarantined: snap.Counters["quarantined"],
+		Trashed:     snap.Counters["trashed"],
+	}
+}
+
+// Start reads the keys of the listing input, in any format, and deletes
+// them, writing the keys deleted to deleted and those that failed to
+// failed, with their error in JSON. It stops on an S3 error that every call
+// would get, such as a missing bucket, returning an *AbortError with it as
+// the Cause.
+func (d *DeleteTask) Start(input io.Reader, deleted, failed io.Writer) error {
+	switch {
+	case d.MaxRetry < 1:
+		return fmt.Errorf("need at least 1 attempt, got %d", d.MaxRetry)
+	case d.DeletePara < 1:
+		return fmt.Errorf("need at least 1 worker, got %d", d.DeletePara)
+	case d.BatchSize < 1 || d.BatchSize > DeleteBatch:
+		return fmt.Errorf("batch size must be in [1, %d], got %d", DeleteBatch, d.BatchSize)
+	}
+	okEnc, err := listing.NewEncoder(deleted, d.OutputFormat)
+	if err != nil {
+		return err
+	}
+	failEnc, err := listing.NewEncoder(failed, d.OutputFormat)
+	if err != nil {
+		return err
+	}
+	rd, err := listing.NewReader(input)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	batches := make(chan []s3.Key, d.DeletePara*BufferFactor)
+	keysOk := make(chan s3.Key, d.DeletePara*d.BatchSize)
+	keysFail := make(chan listing.Failure, d.DeletePara*d.BatchSize)
+
+	logrus.WithFields(logrus.Fields{
+		"delete_workers": d.DeletePara,
+		"batch_size":     d.BatchSize,
+	}).Info("starting key delete workers")
+	var delGroup pipeline.Workers
+	delGroup.Start(d.DeletePara, func(int) {
+		for batch := range batches {
+			// drain the batches read before the task aborted
+			if d.abort.Err() == nil {
+				d.deleteBatch(batch, keysOk, keysFail)
+			}
+		}
+	})
+
+	var encGroup pipeline.Workers
+	var encErr pipeline.FirstError
+	encGroup.Start(1, func(int) {
+		for key := range keysOk {
+			encErr.Set(okEnc.EncodeKey(key))
+		}
+		encErr.Set(okEnc.Flush())
+	})
+	encGroup.Start(1, func(int) {
+		for f := range keysFail {
+			encErr.Set(failEnc.EncodeFailure(f))
+		}
+		encErr.Set(failEnc.Flush())
+	})
+
+	err = d.readBatches(rd, batches)
+	close(batches)
+	delGroup.Wait()
+	close(keysOk)
+	close(keysFail)
+	encGroup.Wait()
+	if cause := d.abort.Err(); cause != nil {
+		err = &AbortError{Cause: cause}
+	}
+	if err == nil {
+		err = encErr.Err()
+	}
+
+	progress := d.Progress()
+	log := logrus.WithFields(logrus.Fields{
+		"since_start": time.Since(start),
+		"keys":        progress.Keys,
+		"batches":     progress.Batches,
+		"deleted":     progress.Deleted,
+		"failed":      progress.Failed,
+		"retries":     progress.Retries,
+	})
+	if d.Quarantine != "" {
+		log = log.WithFields(logrus.Fields{
+			"quarantine":  d.Quarantine,
+			"quarantined": progress.Quarantined,
+			"trashed":     progress.Trashed,
+		})
+	}
+	log.Info("done deleting keys")
+	return err
+}
+
+// readBatches reads the keys of rd in batches of BatchSize keys.
+func (d *DeleteTask) readBatches(rd listing.Reader, batches chan<- []s3.Key) error {
+	batch := make([]s3.Key, 0, d.BatchSize)
+	for {
+		var key s3.Key
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			if len(batch) > 0 {
+				batches <- batch
+			}
+			return nil
+		case nil:
+		default:
+			return err
+		}
+		d.stats.keys.Add(1)
+		batch = append(batch, key)
+		if len(batch) == d.BatchSize {
+			if d.abort.Err() != nil {
+				return nil
+			}
+			batches <- batch
+			batch = make([]s3.Key, 0, d.BatchSize)
+		}
+	}
+}
+
+// deleteBatch deletes the keys of a batch, retrying the keys that failed
+// with a retriable error MaxRetry times.
+func (d *DeleteTask) deleteBatch(batch []s3.Key, deleted chan<- s3.Key, failed chan<- listing.Failure) {
+	deleteMetrics.batches.Add(1)
+	d.stats.batches.Add(1)
+
+	pending := batch
+	if d.Quarantine != "" {
+		pending = d.quarantine(batch, failed)
+	}
+	// S3 rejects a DeleteObjects call without any key
+	if len(pending) == 0 {
+		return
+	}
+	for retry := 1; ; retry++ {
+		// keys that failed with a retriable error
+		var again []s3.Key
+		errs := d.deleteKeys(pending)
+		for _, key := range pending {
+			err, ok := errs[key.Key]
+			switch {
+			case !ok:
+				deleteMetrics.deleted.Add(1)
+				d.stats.deleted.Add(1)
+				deleted <- key
+			case retriable(err) && retry < d.MaxRetry:
+				again = append(again, key)
+			default:
+				deleteMetrics.failed.Add(1)
+				d.stats.failed.Add(1)
+				f := listing.Failure{Key: key, Error: err.Error(), Retries: retry - 1, Time: time.Now().UTC(), RunID: d.RunID}
+				if e, ok := err.(*s3.Error); ok {
+					f.ErrorCode = e.Code
+				}
+				logrus.WithFields(logrus.Fields{
+					"key":   key.Key,
+					"error": err,
+				}).Error("failed to delete key")
+				failed <- f
+			}
+		}
+		if len(again) == 0 {
+			return
+		}
+		deleteMetrics.retries.Add(int64(len(again)))
+		d.stats.retries.Add(int64(len(again)))
+		sleepFor := d.RetryBase * time.Duration(retry)
+		logrus.WithFields(logrus.Fields{
+			"sleep":     sleepFor,
+			"retry":     retry,
+			"max_retry": d.MaxRetry,
+			"keys":      len(again),
+		}).Debug("sleeping on retryable error")
+		time.Sleep(sleepFor)
+		pending = again
+	}
+}
+
+// deleteKeys deletes keys in a single call, returning the error of each
+// key that failed, by name.
+func (d *DeleteTask) deleteKeys(keys []s3.Key) map[string]error {
+	del := s3.Delete{Quiet: true, Objects: make([]s3.Object, len(keys))}
+	for i, key := range keys {
+		del.Objects[i] = s3.Object{Key: key.Key}
+	}
+	errs := make(map[string]error)
+	result, err := d.bkt.DelMultiResult(del)
+	if err != nil {
+		if shouldAbort(err) {
+			logrus.WithField("error", err).Error("abort worthy error, should not continue to delete before issue is resolved")
+			d.abort.Set(err)
+		}
+		for _, key := range keys {
+			errs[key.Key] = err
+		}
+		return errs
+	}
+	for _, e := range result.Errors {
+		errs[e.Key] = &s3.Error{StatusCode: 200, Code: e.Code, Message: e.Message, BucketName: d.bkt.Name}
+	}
+	return errs
+}
+
+// retriable is true for unexpected errors, such as network errors, and for
+// the S3 errors that should be retried.
+func retriable(err error) bool {
+	if _, ok := err.(*s3.Error); ok {
+		return shouldRetry(err)
+	}
+	return true
+}
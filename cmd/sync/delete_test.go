@@ -0,0 +1,131 @@
+package sync_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeleteTask(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	bkt := mocks3.S3().Bucket("dst-bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	var keys []s3.Key
+	for i := 0; i < 30; i++ {
+		name := "extraneous/" + strconv.Itoa(i)
+		// the last keys are already gone, which isn't an error
+		if i < 25 {
+			if err := bkt.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+				t.Fatalf("can't put %q: %v", name, err)
+			}
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+	if err := bkt.Put("kept", []byte("kept"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put kept key: %v", err)
+	}
+
+	// the first batch is throttled once
+	var posts int32
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			if r.Method == "POST" && atomic.AddInt32(&posts, 1) == 1 {
+				return &s3.Error{StatusCode: http.StatusServiceUnavailable, Code: s3.ErrSlowDown, Message: "Slow Down"}
+			}
+			return nil
+		},
+	})
+
+	task, err := sync.NewDeleteTask(bkt)
+	if err != nil {
+		t.Fatalf("can't create delete task: %v", err)
+	}
+	task.BatchSize = 10
+	task.DeletePara = 2
+	task.RetryBase = time.Millisecond
+
+	var deleted, failed bytes.Buffer
+	if err := task.Start(encodeKeys(keys), &deleted, &failed); err != nil {
+		t.Fatalf("can't delete: %v", err)
+	}
+	if got := decodeKeys(&deleted); len(got) != len(keys) {
+		t.Errorf("want %d deleted keys, got %d", len(keys), len(got))
+	}
+	if failed.Len() != 0 {
+		t.Errorf("want no failed keys, got %q", failed.String())
+	}
+	objects := mocks3.ListBuckets()["dst-bucket"].Objects
+	if len(objects) != 1 || objects["kept"] == nil {
+		t.Errorf("want only the kept key left, got %d keys", len(objects))
+	}
+	progress := task.Progress()
+	if progress.Batches != 3 || progress.Deleted != 30 || progress.Retries != 10 {
+		t.Errorf("want 3 batches, 30 deleted and 10 retries, got %+v", progress)
+	}
+
+	// keys that can't be deleted go to the failed output, with their error
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: s3mock.FailMethod("POST", s3.Error{StatusCode: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}),
+	})
+	task, err = sync.NewDeleteTask(bkt)
+	if err != nil {
+		t.Fatalf("can't create delete task: %v", err)
+	}
+	deleted.Reset()
+	failed.Reset()
+	if err := task.Start(encodeKeys([]s3.Key{{Key: "kept"}}), &deleted, &failed); err != nil {
+		t.Fatalf("can't delete: %v", err)
+	}
+	var f listing.Failure
+	if err := json.NewDecoder(&failed).Decode(&f); err != nil || f.Key.Key != "kept" || f.ErrorCode != "AccessDenied" {
+		t.Errorf("want kept to fail with AccessDenied, got %+v (%v)", f, err)
+	}
+	if deleted.Len() != 0 {
+		t.Errorf("want no deleted key, got %q", deleted.String())
+	}
+}
+
+func TestDeleteGuard(t *testing.T) {
+	guard := sync.DeleteGuard{MaxFraction: 0.1}
+	if err := guard.Validate(); err != nil {
+		t.Fatalf("invalid guard: %v", err)
+	}
+	for _, tt := range []struct {
+		deletes, keys int64
+		refused       bool
+	}{
+		{deletes: 10, keys: 100},
+		{deletes: 0, keys: 0},
+		{deletes: 11, keys: 100, refused: true},
+		// a truncated listing of the source has the whole destination to
+		// delete
+		{deletes: 100, keys: 100, refused: true},
+		{deletes: 1, keys: 0, refused: true},
+	} {
+		err := guard.Check(tt.deletes, tt.keys)
+		if refused, ok := err.(*sync.TooManyDeletesError); ok != tt.refused {
+			t.Errorf("want %d of %d keys refused %v, got %v", tt.deletes, tt.keys, tt.refused, err)
+		} else if ok && (refused.Deletes != tt.deletes || refused.Keys != tt.keys) {
+			t.Errorf("want the refusal of %d of %d keys, got %+v", tt.deletes, tt.keys, refused)
+		}
+	}
+	for _, bad := range []float64{0, -0.1, 1.5} {
+		if err := (sync.DeleteGuard{MaxFraction: bad}).Validate(); err == nil {
+			t.Errorf("want fraction %v invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The files of a disk queue, in its QueueDir.
+const (
+	queueFileName = "queue.json"
+	queueMetaName = "queue.meta.json"
+)
+
+// queueFlushEvery is how many keys the disk queue buffers before writing
+// them to its file, unless the sync workers are caught up with it.
+var queueFlushEvery = 1024
+
+// diskQueue holds the decoded keys on their way to the sync workers in a
+// file, so that the listing is read and decoded ahead of the sync workers
+// however far behind they are, rather than stalling once the channels
+// between them are full. Once the whole listing is queued, the queue is
+// marked complete, and a task restarted with the same QueueDir drains it
+// without reading the listing again.
+type diskQueue struct {
+	dir string
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+	// keys encoded in buf, not written to the file yet
+	pending []s3.Key
+	// keys written to the file, and read from it by the sync workers
+	flushed, drained int64
+	// whether the whole listing is queued, and the workers wait for keys
+	complete, waiting bool
+	// resumed is set when a complete queue was found in dir, partial when
+	// the listing couldn't be read to the end
+	resumed, partial bool
+	// err of the file, which then takes no more keys
+	err error
+}
+
+// queueMeta is written once a queue is complete.
+type queueMeta struct {
+	Keys     int64 `json:"keys"`
+	Complete bool  `json:"complete"`
+}
+
+// openDiskQueue opens the queue in dir, resuming it if it's complete, else
+// starting a new one.
+func openDiskQueue(dir string) (*diskQueue, error) {
+	q := &diskQueue{dir: dir}
+	q.cond = sync.NewCond(&q.mu)
+	var meta queueMeta
+	if data, err := ioutil.ReadFile(filepath.Join(dir, queueMetaName)); err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("decoding disk queue metadata: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if meta.Complete {
+		file, err := os.Open(filepath.Join(dir, queueFileName))
+		if err != nil {
+			return nil, err
+		}
+		q.file, q.flushed, q.complete, q.resumed = file, meta.Keys, true, true
+		return q, nil
+	}
+	file, err := os.Create(filepath.Join(dir, queueFileName))
+	if err != nil {
+		return nil, err
+	}
+	q.file = file
+	q.buf = bufio.NewWriter(file)
+	q.enc = json.NewEncoder(q.buf)
+	return q, nil
+}
+
+// put a key at the end of the queue. Once the queue failed, the keys it
+// couldn't queue are returned instead, for the caller to send them on.
+func (q *diskQueue) put(key s3.Key) []s3.Key {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return []s3.Key{key}
+	}
+	q.pending = append(q.pending, key)
+	if err := q.enc.Encode(key); err != nil {
+		return q.fail(err)
+	}
+	if len(q.pending) >= queueFlushEvery || q.waiting {
+		return q.flush()
+	}
+	return nil
+}
+
+// flush the pending keys to the file, waking up the sync workers waiting
+// for them.
+func (q *diskQueue) flush() []s3.Key {
+	if len(q.pending) == 0 {
+		return nil
+	}
+	if err := q.buf.Flush(); err != nil {
+		return q.fail(err)
+	}
+	q.flushed += int64(len(q.pending))
+	q.pending = q.pending[:0]
+	q.cond.Broadcast()
+	return nil
+}
+
+// fail the queue, returning its pending keys. The keys written to the file
+// are still drained.
+func (q *diskQueue) fail(err error) []s3.Key {
+	logrus.WithFields(logrus.Fields{
+		"dir":   q.dir,
+		"error": err,
+	}).Error("disk queue failed, sending the keys straight to the sync workers")
+	q.err = err
+	pending := q.pending
+	q.pending = nil
+	q.cond.Broadcast()
+	return pending
+}
+
+// finish marks the queue complete once the whole listing is queued, unless
+// it's partial, returning the keys it couldn't queue.
+func (q *diskQueue) finish() []s3.Key {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var unqueued []s3.Key
+	if q.err == nil {
+		unqueued = q.flush()
+	}
+	if q.err == nil && !q.partial {
+		if err := q.writeMeta(queueMeta{Keys: q.flushed, Complete: true}); err != nil {
+			unqueued = append(unqueued, q.fail(err)...)
+		}
+	}
+	q.complete = true
+	q.cond.Broadcast()
+	return unqueued
+}
+
+// partialListing marks the queue as holding part of the listing only, which
+// isn't resumed from.
+func (q *diskQueue) partialListing() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.partial = true
+}
+
+func (q *diskQueue) writeMeta(meta queueMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(q.dir, queueMetaName+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.dir, queueMetaName))
+}
+
+// drain the keys written to the queue to keys, until it's complete, or it
+// failed, and all of them are read.
+func (q *diskQueue) drain(keys chan<- s3.Key, queued func(int64)) error {
+	rd, err := os.Open(q.file.Name())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rd.Close() }()
+	// only decodes keys that are all written, so it never reads a partial
+	// one at the end of the file
+	dec := json.NewDecoder(rd)
+	for {
+		q.mu.Lock()
+		var unqueued []s3.Key
+		for q.drained == q.flushed && !q.complete && q.err == nil {
+			if len(q.pending) > 0 {
+				// the workers are caught up
+				unqueued = q.flush()
+				continue
+			}
+			q.waiting = true
+			q.cond.Wait()
+			q.waiting = false
+		}
+		n := q.flushed - q.drained
+		q.mu.Unlock()
+		for _, key := range unqueued {
+			keys <- key
+			queued(-1)
+		}
+		if n == 0 {
+			return nil
+		}
+		for i := int64(0); i < n; i++ {
+			var key s3.Key
+			if err := dec.Decode(&key); err != nil {
+				return fmt.Errorf("reading disk queue: %v", err)
+			}
+			keys <- key
+			queued(-1)
+		}
+		q.mu.Lock()
+		q.drained += n
+		q.mu.Unlock()
+	}
+}
+
+// close the queue, removing its files if the task is done with it.
+func (q *diskQueue) close(done bool) {
+	_ = q.file.Close()
+	if !done || q.err != nil {
+		logrus.WithField("dir", q.dir).Info("keeping the disk queue, to resume from it")
+		return
+	}
+	for _, name := range []string{queueFileName, queueMetaName} {
+		if err := os.Remove(filepath.Join(q.dir, name)); err != nil && !os.IsNotExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"error":    err,
+				"filename": name,
+			}).Warn("couldn't remove the disk queue")
+		}
+	}
+}
+
+// runQueue queues the decoded keys of in to disk, and drains them to out,
+// until in is closed and the queue is drained. The keys the queue couldn't
+// take once it failed go straight to out.
+func (s *SyncTask) runQueue(in <-chan s3.Key, out chan<- s3.Key) error {
+	if s.queue.resumed {
+		s.diskQueued(s.queue.flushed)
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- s.queue.drain(out, s.diskQueued) }()
+	direct := func(keys []s3.Key) {
+		for _, key := range keys {
+			out <- key
+			s.diskQueued(-1)
+		}
+	}
+	for key := range in {
+		s.diskQueued(1)
+		direct(s.queue.put(key))
+	}
+	direct(s.queue.finish())
+	return <-drained
+}
+
+func (s *SyncTask) diskQueued(n int64) {
+	metrics.diskQueued.Add(n)
+	s.stats.diskQueued.Add(n)
+}
@@ -0,0 +1,121 @@
+package sync_test
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestDiskQueue(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	dir, err := ioutil.TempDir("", "queue")
+	if err != nil {
+		t.Fatalf("can't create dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	queueFiles := func() int {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("can't read queue dir: %v", err)
+		}
+		return len(files)
+	}
+
+	keys := mockbkt.Keys()[:100]
+	var mu gosync.Mutex
+	synced := make(map[string]int)
+	release := make(chan struct{})
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		<-release
+		mu.Lock()
+		synced[key.Key]++
+		mu.Unlock()
+		return nil
+	}
+
+	// the sync workers are stuck while the whole listing is queued, then
+	// the task is cancelled
+	first, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(2),
+		sync.WithSyncer(syncer),
+		sync.WithQueueDir(dir),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	go func() {
+		// the queue and its metadata once it's complete
+		for queueFiles() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		first.Cancel()
+		close(release)
+	}()
+	if err := first.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); !errors.Is(err, sync.ErrCancelled) {
+		t.Fatalf("want the sync cancelled, got %v", err)
+	}
+	if got := queueFiles(); got != 2 {
+		t.Fatalf("want the queue kept, got %d files", got)
+	}
+
+	// the restarted task drains the queue without reading its listing
+	second, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(4),
+		sync.WithSyncer(syncer),
+		sync.WithQueueDir(dir),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := second.Start(strings.NewReader("not a listing"), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if p := second.Progress(); p.Synced != int64(len(keys)) || p.DiskQueued != 0 {
+		t.Errorf("want all %d keys synced from the queue, got %+v", len(keys), p)
+	}
+	mu.Lock()
+	for _, k := range keys {
+		if synced[k.Key] == 0 {
+			t.Errorf("want %q synced", k.Key)
+		}
+	}
+	mu.Unlock()
+	if got := queueFiles(); got != 0 {
+		t.Errorf("want the queue removed once done, got %d files", got)
+	}
+
+	// a task that isn't held up goes through the queue just the same
+	third, err := sync.NewSyncTask(src, dst,
+		sync.WithSyncer(syncer),
+		sync.WithQueueDir(dir),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := third.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if p := third.Progress(); p.Synced != int64(len(keys)) {
+		t.Errorf("want all %d keys synced, got %+v", len(keys), p)
+	}
+
+	if _, err := sync.NewSyncTask(src, dst, sync.WithQueueDir(dir+"/missing")); err == nil {
+		t.Errorf("want the queue dir to exist")
+	}
+}
@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"fmt"
+	"sync"
+)
+
+// AbortError is returned by Start when the task stopped before all the keys
+// of its input were synced: it was cancelled, failed too many keys, spent its
+// retry budget, stalled, read too many malformed lines or got an S3 error
+// that every call would get, such as bad credentials or a missing bucket.
+// The Cause is one of ErrCancelled, ErrTooManyFailures, ErrStalled, a
+// *BudgetExceededError, a *MalformedLineError or an *s3.Error, which
+// errors.Is and errors.As see through the AbortError. The Start of a
+// DeleteTask returns one for such an *s3.Error, with no progress.
+type AbortError struct {
+	Cause error
+	// LastFailure is the last key that failed before the task stopped, if
+	// any.
+	LastFailure *RetriesExhaustedError
+	// Progress of the task when it stopped.
+	Progress Progress
+}
+
+func (e *AbortError) Error() string {
+	if e.LastFailure != nil {
+		return fmt.Sprintf("%v, last failure: %v", e.Cause, e.LastFailure)
+	}
+	return e.Cause.Error()
+}
+
+// Unwrap returns the cause of the abort, and the last failure.
+func (e *AbortError) Unwrap() []error {
+	if e.LastFailure != nil {
+		return []error{e.Cause, e.LastFailure}
+	}
+	return []error{e.Cause}
+}
+
+// RetriesExhaustedError is the failure of a key that was retried as many
+// times as the task allows.
+type RetriesExhaustedError struct {
+	Key     string
+	Retries int
+	Err     error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("key %q failed after %d retries: %v", e.Key, e.Retries, e.Err)
+}
+
+// Unwrap returns the error of the last attempt, such as an *s3.Error.
+func (e *RetriesExhaustedError) Unwrap() error { return e.Err }
+
+// BudgetExceededError is the cause of the abort of a task that spent its
+// retry budget of Tokens. It is ErrRetryBudget to errors.Is.
+type BudgetExceededError struct {
+	Tokens int
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%v of %d tokens", ErrRetryBudget, e.Tokens)
+}
+
+// Is ErrRetryBudget.
+func (e *BudgetExceededError) Is(target error) bool { return target == ErrRetryBudget }
+
+// ListingError is returned by Start when the input listing can't be read,
+// after Lines of it were.
+type ListingError struct {
+	Lines int64
+	Err   error
+}
+
+func (e *ListingError) Error() string {
+	return fmt.Sprintf("reading listing after %d lines: %v", e.Lines, e.Err)
+}
+
+// Unwrap returns the error of the reader.
+func (e *ListingError) Unwrap() error { return e.Err }
+
+// lastFailure of a task, for its AbortError.
+type lastFailure struct {
+	mu  sync.Mutex
+	err *RetriesExhaustedError
+}
+
+func (l *lastFailure) set(err *RetriesExhaustedError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+}
+
+func (l *lastFailure) get() *RetriesExhaustedError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// aborted wraps the cause of the cancellation of the task.
+func (s *SyncTask) aborted(cause error) error {
+	return &AbortError{Cause: cause, LastFailure: s.lastFailure.get(), Progress: s.Progress()}
+}
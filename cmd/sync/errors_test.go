@@ -0,0 +1,141 @@
+package sync_test
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestAbortWorthyError(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	keys := []s3.Key{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	for _, key := range keys {
+		if err := src.Put(key.Key, []byte(key.Key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(1))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	// the buckets go away once the task is created
+	mocks3.SendErrors(0, 1.0, []s3.Error{{StatusCode: 404, Message: s3.ErrNoSuchBucket}})
+	err = syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard)
+
+	var abort *sync.AbortError
+	var s3err *s3.Error
+	switch {
+	case !errors.As(err, &abort):
+		t.Fatalf("want the task aborted, got %#v", err)
+	case !errors.As(abort.Cause, &s3err) || s3err.Code != s3.ErrNoSuchBucket:
+		t.Errorf("want the abort caused by %q, got %v", s3.ErrNoSuchBucket, abort.Cause)
+	case abort.Progress.Synced != 0:
+		t.Errorf("want no key synced, got %d", abort.Progress.Synced)
+	}
+}
+
+func TestDeleteAbortWorthyError(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	bkt := mocks3.S3().Bucket("dst-bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	var keys []s3.Key
+	for i := 0; i < 30; i++ {
+		name := strconv.Itoa(i)
+		if err := bkt.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+
+	task, err := sync.NewDeleteTask(bkt)
+	if err != nil {
+		t.Fatalf("can't create delete task: %v", err)
+	}
+	task.BatchSize = 10
+	task.DeletePara = 1
+	task.RetryBase = time.Millisecond
+	// the bucket goes away once the task is created
+	mocks3.SendErrors(0, 1.0, []s3.Error{{StatusCode: 404, Message: s3.ErrNoSuchBucket}})
+	err = task.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard)
+
+	var abort *sync.AbortError
+	var s3err *s3.Error
+	switch {
+	case !errors.As(err, &abort):
+		t.Fatalf("want the task aborted, got %#v", err)
+	case !errors.As(abort.Cause, &s3err) || s3err.Code != s3.ErrNoSuchBucket:
+		t.Errorf("want the abort caused by %q, got %v", s3.ErrNoSuchBucket, abort.Cause)
+	}
+	if progress := task.Progress(); progress.Deleted != 0 || progress.Batches == 3 {
+		t.Errorf("want the task to stop before deleting all its batches, got %+v", progress)
+	}
+}
+
+func TestListingError(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	keys := []s3.Key{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	for _, key := range keys {
+		if err := src.Put(key.Key, []byte(key.Key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	// the download of the listing breaks after its keys
+	broken := errors.New("connection reset by peer")
+	input := io.MultiReader(encodeKeys(keys), iotest.ErrReader(broken))
+	err = syncTask.Start(input, ioutil.Discard, ioutil.Discard)
+
+	var lerr *sync.ListingError
+	switch {
+	case !errors.As(err, &lerr):
+		t.Fatalf("want a listing error, got %#v", err)
+	case lerr.Lines != int64(len(keys)):
+		t.Errorf("want the listing read for %d lines, got %d", len(keys), lerr.Lines)
+	case !errors.Is(err, broken):
+		t.Errorf("want the error of the reader, got %v", lerr.Err)
+	}
+	var abort *sync.AbortError
+	if errors.As(err, &abort) {
+		t.Errorf("want no abort, the task wasn't cancelled")
+	}
+}
@@ -0,0 +1,174 @@
+package sync
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CollideRename copies keys that already exist at the destination under
+// another name, with the Suffix of the Existing policy.
+const CollideRename = "rename"
+
+// ErrExists is the error of a key that already exists at the destination,
+// with the CollideFail policy for existing keys.
+var ErrExists = errors.New("key already exists at the destination")
+
+// existingKeys counts the keys that already existed at the destination, by
+// the decision taken on them.
+var existingKeys = expvar.NewMap("brigade.sync.existing")
+
+// Existing decides what to do with the keys that already exist at the
+// destination: overwrite them, skip them, fail them with ErrExists or copy
+// them under their name with Suffix.
+type Existing struct {
+	Policy string
+	Suffix string
+	// Keys, when set, are the ETags of the keys of the destination by name,
+	// such as read by ReadETags from a listing of the destination.
+	// Otherwise, each key is HEAD'd at the destination before it's copied.
+	Keys map[string]string
+	// SkipUnchanged skips the existing keys that have the ETag of their
+	// source whatever the Policy, so that only the keys that are missing or
+	// different at the destination are synced. Keys uploaded in parts have
+	// ETags that their copies don't share, so they're never unchanged.
+	SkipUnchanged bool
+}
+
+// Validate the policy.
+func (e *Existing) Validate() error {
+	switch e.Policy {
+	case CollideOverwrite, CollideSkip, CollideFail:
+	case CollideRename:
+		if e.Suffix == "" {
+			return errors.New("need a suffix to rename existing keys")
+		}
+	default:
+		return fmt.Errorf("unknown policy for existing keys %q, want %s, %s, %s or %s", e.Policy, CollideOverwrite, CollideSkip, CollideFail, CollideRename)
+	}
+	return nil
+}
+
+// ReadETags reads the ETags of the keys of a listing, by name.
+func ReadETags(r io.Reader) (map[string]string, error) {
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	etags := make(map[string]string)
+	var key s3.Key
+	for {
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			return etags, nil
+		case nil:
+			etags[key.Key] = key.ETag
+		default:
+			return nil, err
+		}
+	}
+}
+
+// DestKey is the name of a key at the destination, after its Mapping and
+// the policy for existing keys, which the Sync of the task must copy the
+// key to when keys are renamed, see Renamed.
+func (s *SyncTask) DestKey(key string) string {
+	name := s.Mapping.Map(key)
+	if s.renamed(key) {
+		name += s.Existing.Suffix
+	}
+	return name
+}
+
+// renamed is true if the key is being renamed by the policy for existing
+// keys.
+func (s *SyncTask) renamed(key string) bool {
+	s.renamesMu.Lock()
+	defer s.renamesMu.Unlock()
+	return s.renames[key]
+}
+
+// setRenamed marks a key as renamed while it's synced.
+func (s *SyncTask) setRenamed(key string, renamed bool) {
+	s.renamesMu.Lock()
+	defer s.renamesMu.Unlock()
+	if s.renames == nil {
+		s.renames = make(map[string]bool)
+	}
+	if renamed {
+		s.renames[key] = true
+	} else {
+		delete(s.renames, key)
+	}
+}
+
+// exists checks whether the key already exists at the destination, and
+// decides what to do with it: whether to skip it, to rename it, or the
+// error to fail it with.
+func (s *SyncTask) exists(dst *s3.Bucket, key s3.Key) (skip, rename bool, err error) {
+	if s.Existing == nil || s.Existing.Policy == CollideOverwrite && s.Existing.Keys == nil && !s.Existing.SkipUnchanged {
+		// no need to HEAD the keys that are overwritten anyway
+		return false, false, nil
+	}
+	name := s.Mapping.Map(key.Key)
+	etag, found := s.Existing.Keys[name]
+	if s.Existing.Keys == nil {
+		resp, err := dst.Head(name, nil)
+		switch e := err.(type) {
+		case nil:
+			_ = resp.Body.Close()
+			etag, found = resp.Header.Get("ETag"), true
+		case *s3.Error:
+			if e.StatusCode != http.StatusNotFound {
+				return false, false, err
+			}
+		default:
+			return false, false, err
+		}
+	}
+	if !found {
+		return false, false, nil
+	}
+	if s.Existing.SkipUnchanged && sameETag(etag, key.ETag) {
+		existingKeys.Add("unchanged", 1)
+		s.stats.unchanged.Add(1)
+		return true, false, nil
+	}
+
+	existingKeys.Add(s.Existing.Policy, 1)
+	s.stats.existing.Add(1)
+	switch s.Existing.Policy {
+	case CollideSkip:
+		return true, false, nil
+	case CollideFail:
+		return false, false, ErrExists
+	case CollideRename:
+		return false, true, nil
+	}
+	return false, false, nil
+}
+
+// sameETag is true if both ETags are known and equal, whether they're
+// quoted or not.
+func sameETag(a, b string) bool {
+	a, b = strings.Trim(a, `"`), strings.Trim(b, `"`)
+	return a != "" && a == b
+}
+
+// logExisting logs the keys that already existed at the destination.
+func (s *SyncTask) logExisting() {
+	if s.Existing == nil {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"policy":    s.Existing.Policy,
+		"existing":  s.stats.existing.Value(),
+		"unchanged": s.stats.unchanged.Value(),
+	}).Info("keys that already existed at the destination")
+}
@@ -0,0 +1,213 @@
+package sync_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestExistingValidate(t *testing.T) {
+	for _, e := range []sync.Existing{
+		{Policy: sync.CollideSkip},
+		{Policy: sync.CollideRename, Suffix: ".old"},
+	} {
+		if err := e.Validate(); err != nil {
+			t.Errorf("want %+v to be valid, got %v", e, err)
+		}
+	}
+	for _, e := range []sync.Existing{
+		{Policy: sync.CollideRename},
+		{Policy: "merge"},
+	} {
+		if err := e.Validate(); err == nil {
+			t.Errorf("want %+v to be invalid", e)
+		}
+	}
+}
+
+func TestReadETags(t *testing.T) {
+	etags, err := sync.ReadETags(encodeKeys([]s3.Key{{Key: "a", ETag: `"1"`}, {Key: "b", ETag: `"2"`}}))
+	if err != nil {
+		t.Fatalf("can't read etags: %v", err)
+	}
+	if want := map[string]string{"a": `"1"`, "b": `"2"`}; !reflect.DeepEqual(want, etags) {
+		t.Errorf("want etags %v, got %v", want, etags)
+	}
+}
+
+func TestSyncExisting(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, key := range []string{"a", "b", "c"} {
+		if err := src.Put(key, []byte("new"), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+		keys = append(keys, s3.Key{Key: key})
+	}
+
+	for _, tt := range []struct {
+		existing sync.Existing
+		synced   []string
+		failed   []string
+		contents map[string]string
+	}{
+		{
+			existing: sync.Existing{Policy: sync.CollideSkip},
+			synced:   []string{"c"},
+			contents: map[string]string{"a": "old", "b": "old", "c": "new"},
+		},
+		{
+			existing: sync.Existing{Policy: sync.CollideFail},
+			synced:   []string{"c"},
+			failed:   []string{"a", "b"},
+			contents: map[string]string{"a": "old", "b": "old", "c": "new"},
+		},
+		{
+			existing: sync.Existing{Policy: sync.CollideRename, Suffix: ".new"},
+			synced:   []string{"a", "b", "c"},
+			contents: map[string]string{"a": "old", "b": "old", "a.new": "new", "b.new": "new", "c": "new"},
+		},
+		{
+			// the listing is trusted, "b" isn't checked
+			existing: sync.Existing{Policy: sync.CollideSkip, Keys: map[string]string{"a": `"old"`}},
+			synced:   []string{"b", "c"},
+			contents: map[string]string{"a": "old", "b": "new", "c": "new"},
+		},
+	} {
+		for key := range mocks3.ListBuckets()["dst-bucket"].Objects {
+			if err := dst.Del(key); err != nil {
+				t.Fatalf("can't delete %q: %v", key, err)
+			}
+		}
+		for _, key := range []string{"a", "b"} {
+			if err := dst.Put(key, []byte("old"), "", s3.Private, s3.Options{}); err != nil {
+				t.Fatalf("can't put %q: %v", key, err)
+			}
+		}
+
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 2
+		syncTask.RetryBase = time.Millisecond
+		existing := tt.existing
+		syncTask.Existing = &existing
+		syncTask.Sync = sync.Renamed(sync.PutCopy, syncTask.DestKey)
+
+		var synced, failed bytes.Buffer
+		if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+			t.Fatalf("%s: can't sync: %v", tt.existing.Policy, err)
+		}
+		names := func(buf *bytes.Buffer) []string {
+			var got []string
+			for _, key := range decodeKeys(buf) {
+				got = append(got, key.Key)
+			}
+			sort.Strings(got)
+			return got
+		}
+		if got := names(&synced); !reflect.DeepEqual(got, tt.synced) {
+			t.Errorf("%s: want keys %v synced, got %v", tt.existing.Policy, tt.synced, got)
+		}
+		if got := names(&failed); !reflect.DeepEqual(got, tt.failed) {
+			t.Errorf("%s: want keys %v failed, got %v", tt.existing.Policy, tt.failed, got)
+		}
+		got := make(map[string]string)
+		for key, obj := range mocks3.ListBuckets()["dst-bucket"].Objects {
+			got[key] = string(obj.Data)
+		}
+		if !reflect.DeepEqual(got, tt.contents) {
+			t.Errorf("%s: want destination %v, got %v", tt.existing.Policy, tt.contents, got)
+		}
+		if want := int64(2); tt.existing.Keys == nil && syncTask.Progress().Existing != want {
+			t.Errorf("%s: want %d existing keys, got %d", tt.existing.Policy, want, syncTask.Progress().Existing)
+		}
+	}
+}
+
+func TestSyncDelta(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	etag := func(data string) string { return fmt.Sprintf(`"%x"`, md5.Sum([]byte(data))) }
+	var keys []s3.Key
+	for _, key := range []string{"a", "b", "c"} {
+		if err := src.Put(key, []byte("new"), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+		keys = append(keys, s3.Key{Key: key, ETag: etag("new")})
+	}
+
+	for _, listing := range []map[string]string{
+		nil,
+		{"a": etag("new"), "b": etag("old")},
+	} {
+		for key := range mocks3.ListBuckets()["dst-bucket"].Objects {
+			if err := dst.Del(key); err != nil {
+				t.Fatalf("can't delete %q: %v", key, err)
+			}
+		}
+		// "a" is unchanged, "b" differs and "c" is missing
+		for key, data := range map[string]string{"a": "new", "b": "old"} {
+			if err := dst.Put(key, []byte(data), "", s3.Private, s3.Options{}); err != nil {
+				t.Fatalf("can't put %q: %v", key, err)
+			}
+		}
+
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 2
+		syncTask.RetryBase = time.Millisecond
+		syncTask.Existing = &sync.Existing{Policy: sync.CollideOverwrite, Keys: listing, SkipUnchanged: true}
+		syncTask.Sync = sync.PutCopySyncer
+
+		var synced, failed bytes.Buffer
+		if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		var got []string
+		for _, key := range decodeKeys(&synced) {
+			got = append(got, key.Key)
+		}
+		sort.Strings(got)
+		if want := []string{"b", "c"}; !reflect.DeepEqual(want, got) {
+			t.Errorf("listing %v: want keys %v synced, got %v", listing, want, got)
+		}
+		if progress := syncTask.Progress(); progress.Unchanged != 1 || progress.Skipped != 1 {
+			t.Errorf("listing %v: want 1 key skipped as unchanged, got %+v", listing, progress)
+		}
+		if data := string(mocks3.ListBuckets()["dst-bucket"].Objects["b"].Data); data != "new" {
+			t.Errorf("listing %v: want the different key overwritten, got %q", listing, data)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/lint"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Policies for the keys of a fan-in that many sources name the same at the
+// destination.
+const (
+	// CollideOverwrite syncs all of them, the last source synced wins.
+	CollideOverwrite = "overwrite"
+	// CollideSkip only syncs the first, the others are skipped.
+	CollideSkip = "skip"
+	// CollideFail only syncs the first, the others fail with ErrCollision.
+	CollideFail = "fail"
+)
+
+// ErrCollision is the error of a key that another source of a fan-in
+// already synced under the same name.
+var ErrCollision = errors.New("another source already synced a key of the same name")
+
+// Mapping renames the keys of a source of a fan-in at the destination: keys
+// under the From prefix are moved under the To prefix, others keep their
+// name. With Normalize, the names are then normalized, see lint.Normalize.
+type Mapping struct {
+	From      string
+	To        string
+	Normalize bool
+}
+
+// ParseMapping parses a mapping of the form "from=to", where both prefixes
+// can be empty.
+func ParseMapping(spec string) (Mapping, error) {
+	idx := strings.Index(spec, "=")
+	if idx < 0 {
+		return Mapping{}, fmt.Errorf("want a mapping of the form from=to, got %q", spec)
+	}
+	return Mapping{From: spec[:idx], To: spec[idx+1:]}, nil
+}
+
+// Map a key of the source to its name at the destination.
+func (m Mapping) Map(key string) string {
+	if strings.HasPrefix(key, m.From) {
+		key = m.To + key[len(m.From):]
+	}
+	if m.Normalize {
+		key = lint.Normalize(key)
+	}
+	return key
+}
+
+// Source of a fan-in: the task syncing the keys of one of the source buckets
+// to the destination, the listing of those keys, and the outputs of the keys
+// it synced and failed. The Mapping of the task tells the names of its keys
+// at the destination.
+type Source struct {
+	Task   *SyncTask
+	Input  io.Reader
+	Synced []io.Writer
+	Failed []io.Writer
+}
+
+// FanIn syncs the keys of many sources to a destination, one source after
+// the other, each with its own task. Keys that a source names like a key an
+// earlier source synced are handled according to the collision policy. The
+// names of the synced keys are kept in memory to detect the collisions. A
+// source that fails doesn't stop the others. Returns the first error of a
+// task.
+func FanIn(sources []Source, policy string) error {
+	switch policy {
+	case CollideOverwrite, CollideSkip, CollideFail:
+	default:
+		return fmt.Errorf("unknown collision policy %q, want %s, %s or %s", policy, CollideOverwrite, CollideSkip, CollideFail)
+	}
+	claims := &claims{policy: policy, owners: make(map[string]int)}
+
+	var err error
+	for i, src := range sources {
+		src.Task.claims = claims
+		src.Task.source = i
+		logrus.WithFields(logrus.Fields{
+			"source":  src.Task.src.Name,
+			"mapping": src.Task.Mapping,
+		}).Info("syncing source of fan-in")
+		if serr := src.Task.StartSharded(src.Input, src.Synced, src.Failed); serr != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  serr,
+				"source": src.Task.src.Name,
+			}).Error("failed to sync from source")
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+	return err
+}
+
+// claims tracks which source of a fan-in first synced each name of the
+// destination.
+type claims struct {
+	policy string
+	mu     sync.Mutex
+	owners map[string]int
+}
+
+// claim a name for a source, true if an other source claimed it first.
+func (c *claims) claim(name string, source int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	owner, ok := c.owners[name]
+	if !ok {
+		c.owners[name] = source
+	}
+	return ok && owner != source
+}
+
+// collides checks whether another source of a fan-in claimed the name of
+// the key at the destination, returning whether the key must be skipped,
+// or the error to fail it with.
+func (s *SyncTask) collides(key s3.Key) (bool, error) {
+	if s.claims == nil {
+		return false, nil
+	}
+	name := s.Mapping.Map(key.Key)
+	if !s.claims.claim(name, s.source) {
+		return false, nil
+	}
+	metrics.collisions.Add(1)
+	s.stats.collisions.Add(1)
+	logrus.WithFields(logrus.Fields{
+		"key":    key.Key,
+		"name":   name,
+		"policy": s.claims.policy,
+	}).Debug("key collides with the key of another source")
+	switch s.claims.policy {
+	case CollideSkip:
+		return true, nil
+	case CollideFail:
+		return false, ErrCollision
+	}
+	return false, nil
+}
@@ -0,0 +1,138 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMapping(t *testing.T) {
+	m, err := sync.ParseMapping("legacy/=shard-1/")
+	if err != nil {
+		t.Fatalf("can't parse mapping: %v", err)
+	}
+	for key, want := range map[string]string{
+		"legacy/a.png": "shard-1/a.png",
+		"other/a.png":  "other/a.png",
+	} {
+		if got := m.Map(key); got != want {
+			t.Errorf("%q: want %q, got %q", key, want, got)
+		}
+	}
+	if m, _ := sync.ParseMapping("=shard-1/"); m.Map("a.png") != "shard-1/a.png" {
+		t.Errorf("want all keys under the prefix, got %q", m.Map("a.png"))
+	}
+	if _, err := sync.ParseMapping("shard-1/"); err == nil {
+		t.Errorf("want an error for a mapping without =")
+	}
+	m.Normalize = true
+	if got := m.Map("legacy/dir /a\a.png"); got != "shard-1/dir/a.png" {
+		t.Errorf("want the key moved and normalized, got %q", got)
+	}
+}
+
+func TestFanIn(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+	srcKeys := map[string][]string{
+		"legacy-1": {"a", "b", "c"},
+		"legacy-2": {"b", "c", "d"},
+	}
+	for name, keys := range srcKeys {
+		bkt := mocks3.S3().Bucket(name)
+		bkt.PutBucket(s3.Private) // create it
+		for _, key := range keys {
+			if err := bkt.Put(key, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+				t.Fatalf("can't put %q: %v", key, err)
+			}
+		}
+	}
+
+	names := func(buf *bytes.Buffer) []string {
+		var got []string
+		for _, key := range decodeKeys(buf) {
+			got = append(got, key.Key)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	for _, tt := range []struct {
+		policy   string
+		mapping  string
+		failed   []string
+		contents map[string]string
+	}{
+		{sync.CollideOverwrite, "=", nil, map[string]string{"a": "legacy-1", "b": "legacy-2", "c": "legacy-2", "d": "legacy-2"}},
+		{sync.CollideSkip, "=", nil, map[string]string{"a": "legacy-1", "b": "legacy-1", "c": "legacy-1", "d": "legacy-2"}},
+		{sync.CollideFail, "=", []string{"b", "c"}, map[string]string{"a": "legacy-1", "b": "legacy-1", "c": "legacy-1", "d": "legacy-2"}},
+		{sync.CollideFail, "=2/", nil, map[string]string{"a": "legacy-1", "b": "legacy-1", "c": "legacy-1", "2/b": "legacy-2", "2/c": "legacy-2", "2/d": "legacy-2"}},
+	} {
+		for key := range mocks3.ListBuckets()["dst-bucket"].Objects {
+			if err := dst.Del(key); err != nil {
+				t.Fatalf("can't delete %q: %v", key, err)
+			}
+		}
+
+		var sources []sync.Source
+		var failed bytes.Buffer
+		for i, name := range []string{"legacy-1", "legacy-2"} {
+			var keys []s3.Key
+			for _, key := range srcKeys[name] {
+				keys = append(keys, s3.Key{Key: key})
+			}
+			syncTask, err := sync.NewSyncTask(mocks3.S3().Bucket(name), dst)
+			if err != nil {
+				t.Fatalf("can't create sync task: %v", err)
+			}
+			syncTask.SyncPara = 1
+			syncTask.RetryBase = time.Millisecond
+			mapping := sync.Mapping{}
+			if i == 1 {
+				mapping, _ = sync.ParseMapping(tt.mapping)
+			}
+			syncTask.Mapping = mapping
+			syncTask.Sync = sync.Renamed(sync.PutCopy, syncTask.DestKey)
+			src := sync.Source{
+				Task:   syncTask,
+				Input:  encodeKeys(keys),
+				Synced: []io.Writer{&bytes.Buffer{}},
+				Failed: []io.Writer{&bytes.Buffer{}},
+			}
+			if i == 1 {
+				src.Failed = []io.Writer{&failed}
+			}
+			sources = append(sources, src)
+		}
+
+		if err := sync.FanIn(sources, tt.policy); err != nil {
+			t.Fatalf("%s: can't fan in: %v", tt.policy, err)
+		}
+		if got := names(&failed); !reflect.DeepEqual(got, tt.failed) {
+			t.Errorf("%s %q: want keys %v failed, got %v", tt.policy, tt.mapping, tt.failed, got)
+		}
+		objects := mocks3.ListBuckets()["dst-bucket"].Objects
+		got := make(map[string]string)
+		for key, obj := range objects {
+			got[key] = string(obj.Data)
+		}
+		if !reflect.DeepEqual(got, tt.contents) {
+			t.Errorf("%s %q: want destination %v, got %v", tt.policy, tt.mapping, tt.contents, got)
+		}
+	}
+
+	if err := sync.FanIn(nil, "rename"); err == nil {
+		t.Errorf("want an error for an unknown policy")
+	}
+}
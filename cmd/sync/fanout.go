@@ -0,0 +1,99 @@
+package sync
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"io"
+)
+
+// Destination of a fan-out: the task syncing the keys to one of the
+// destination buckets, and the outputs of the keys it synced and failed.
+type Destination struct {
+	Task   *SyncTask
+	Synced []io.Writer
+	Failed []io.Writer
+}
+
+// errFanOutStopped is what the input of a destination returns once its task
+// stopped, so that it's not fed anymore.
+var errFanOutStopped = errors.New("sync task of the destination stopped")
+
+// BucketFileName derives the name of the output or state file of a bucket
+// of a fan-out or fan-in, inserting the bucket name before the extensions of
+// filename. For instance, the synced keys of "dr" for "synced.json.gz" are
+// named "synced-dr.json.gz".
+func BucketFileName(filename, bucket string) string {
+	return suffixName(filename, bucket)
+}
+
+// FanOut syncs the keys of the input to many destinations, reading the input
+// once. Each destination has its own task, and so its own retries, state and
+// outputs. The input is fed to the tasks at the pace of the slowest, which
+// holds back the others by no more than the buffers of its task. A task that
+// stops early isn't fed anymore, the others carry on. Returns the first
+// error of a task, or of reading the input.
+func FanOut(input io.Reader, dests []Destination) error {
+	pipes := make([]*io.PipeWriter, len(dests))
+	errs := make([]error, len(dests))
+	readers := make([]*io.PipeReader, len(dests))
+	for i := range dests {
+		readers[i], pipes[i] = io.Pipe()
+	}
+	var tasks pipeline.Workers
+	tasks.Start(len(dests), func(i int) {
+		errs[i] = dests[i].Task.StartSharded(readers[i], dests[i].Synced, dests[i].Failed)
+		_ = readers[i].CloseWithError(errFanOutStopped)
+	})
+	readErr := feed(input, pipes)
+	tasks.Wait()
+
+	var err error
+	for i, terr := range errs {
+		if terr == nil {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"error":       terr,
+			"destination": dests[i].Task.dst.Name,
+		}).Error("failed to sync to destination")
+		if err == nil {
+			err = terr
+		}
+	}
+	if err == nil {
+		err = readErr
+	}
+	return err
+}
+
+// feed copies the input to all the pipes, dropping those that can't be
+// written to anymore, then closes them.
+func feed(input io.Reader, pipes []*io.PipeWriter) error {
+	live := append([]*io.PipeWriter(nil), pipes...)
+	buf := make([]byte, 64<<10)
+	var err error
+	for err == nil {
+		var n int
+		n, err = input.Read(buf)
+		if n == 0 {
+			continue
+		}
+		for i, pw := range live {
+			if pw == nil {
+				continue
+			}
+			if _, werr := pw.Write(buf[:n]); werr != nil {
+				live[i] = nil
+			}
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	for _, pw := range pipes {
+		// readers see EOF when err is nil
+		_ = pw.CloseWithError(err)
+	}
+	return err
+}
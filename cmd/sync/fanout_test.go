@@ -0,0 +1,80 @@
+package sync_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBucketFileName(t *testing.T) {
+	for _, tt := range []struct{ filename, want string }{
+		{"synced.json.gz", "synced-dr.json.gz"},
+		{"out/state", "out/state-dr"},
+		{"s3://outputs/synced.json.gz", "s3://outputs/synced-dr.json.gz"},
+	} {
+		if got := sync.BucketFileName(tt.filename, "dr"); got != tt.want {
+			t.Errorf("%q: want %q, got %q", tt.filename, tt.want, got)
+		}
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	keys := mockbkt.Keys()
+
+	type output struct{ synced, failed bytes.Buffer }
+	var dests []sync.Destination
+	var outputs []*output
+	for _, name := range []string{"dr-bucket", "analytics-bucket"} {
+		dst := mocks3.S3().Bucket(name)
+		dst.PutBucket(s3.Private) // create it
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 3
+		syncTask.RetryBase = time.Millisecond
+		out := &output{}
+		outputs = append(outputs, out)
+		dests = append(dests, sync.Destination{
+			Task:   syncTask,
+			Synced: []io.Writer{&out.synced},
+			Failed: []io.Writer{&out.failed},
+		})
+	}
+	// analytics denies every key, and gives up after a few
+	dests[1].Task.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		return &s3.Error{StatusCode: 403, Code: "AccessDenied"}
+	}
+	dests[1].Task.MaxFailures = 3
+
+	err := sync.FanOut(encodeKeys(keys), dests)
+	if !errors.Is(err, sync.ErrTooManyFailures) {
+		t.Errorf("want the error of the destination that stopped, got %v", err)
+	}
+
+	// the destination that stopped doesn't hold back the other
+	if got := len(mocks3.ListBuckets()["dr-bucket"].Objects); got != len(keys) {
+		t.Errorf("want %d keys copied to dr, got %d", len(keys), got)
+	}
+	if got := decodeKeys(&outputs[0].synced); len(got) != len(keys) {
+		t.Errorf("want %d keys synced to dr, got %d", len(keys), len(got))
+	}
+	if got := decodeKeys(&outputs[1].failed); len(got) < 3 || len(got) == len(keys) {
+		t.Errorf("want the keys failed on analytics until it stopped, got %d", len(got))
+	}
+	if outputs[1].synced.Len() != 0 {
+		t.Errorf("want no key synced to analytics, got %q", outputs[1].synced.String())
+	}
+}
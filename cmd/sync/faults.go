@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDropped is returned by a syncer with injected faults, for calls that
+// were dropped as if the connection to S3 was lost.
+var ErrDropped = errors.New("injected fault: connection dropped")
+
+// Faults to inject in the calls made by a syncer, to exercise retries and
+// error classification in tests and staging.
+type Faults struct {
+	// ErrorRate is the fraction of calls that fail with one of the S3 error
+	// codes in Errors, picked at random.
+	ErrorRate float64
+	Errors    []string
+	// SpikeRate is the fraction of calls that are delayed by Spike before
+	// being made.
+	SpikeRate float64
+	Spike     time.Duration
+	// DropRate is the fraction of calls that fail with ErrDropped.
+	DropRate float64
+	// Seed of the random decisions, the same seed injects the same faults
+	// for the same sequence of calls.
+	Seed int64
+}
+
+// ParseFaults parses faults of the form
+//
+//	error=0.01:SlowDown,InternalError;spike=0.05:2s;drop=0.001;seed=42
+//
+// where every part is optional.
+func ParseFaults(spec string) (Faults, error) {
+	var f Faults
+	for _, part := range strings.Split(spec, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return f, fmt.Errorf("fault %q is not of the form name=value", part)
+		}
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		rate, arg := value, ""
+		if i := strings.IndexByte(value, ':'); i >= 0 {
+			rate, arg = value[:i], value[i+1:]
+		}
+
+		var err error
+		switch name {
+		case "error":
+			f.ErrorRate, err = parseRate(rate)
+			if arg == "" {
+				arg = s3.ErrInternalError
+			}
+			f.Errors = strings.Split(arg, ",")
+		case "spike":
+			f.SpikeRate, err = parseRate(rate)
+			if err == nil {
+				f.Spike, err = time.ParseDuration(arg)
+			}
+		case "drop":
+			f.DropRate, err = parseRate(rate)
+		case "seed":
+			f.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return f, fmt.Errorf("unknown fault %q", name)
+		}
+		if err != nil {
+			return f, fmt.Errorf("invalid fault %q: %v", part, err)
+		}
+	}
+	return f, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate %v is not between 0 and 1", rate)
+	}
+	return rate, nil
+}
+
+// InjectFaults decorates a syncer so that its calls fail or slow down
+// according to faults.
+func InjectFaults(syncer SyncerFunc, faults Faults) SyncerFunc {
+	inject := faultInjector(faults)
+	return func(src, dst *s3.Bucket, key s3.Key) error {
+		if err := inject(); err != nil {
+			return err
+		}
+		return syncer(src, dst, key)
+	}
+}
+
+// InjectFaultsContext decorates a syncer given the context of its calls
+// like InjectFaults.
+func InjectFaultsContext(syncer ContextSyncerFunc, faults Faults) ContextSyncerFunc {
+	inject := faultInjector(faults)
+	return func(ctx context.Context, attempt Attempt, src, dst *s3.Bucket, key s3.Key) error {
+		if err := inject(); err != nil {
+			return err
+		}
+		return syncer(ctx, attempt, src, dst, key)
+	}
+}
+
+// faultInjector returns the func drawing the fault of each call, nil if the
+// call goes through.
+func faultInjector(faults Faults) func() error {
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(faults.Seed))
+
+	return func() error {
+		// draw all the decisions up front and under the lock, so that they
+		// only depend on the order of the calls
+		mu.Lock()
+		spike := rnd.Float64() < faults.SpikeRate
+		drop := rnd.Float64() < faults.DropRate
+		fail := rnd.Float64() < faults.ErrorRate
+		code := ""
+		if len(faults.Errors) != 0 {
+			code = faults.Errors[rnd.Intn(len(faults.Errors))]
+		}
+		mu.Unlock()
+
+		if spike {
+			time.Sleep(faults.Spike)
+		}
+		switch {
+		case drop:
+			return ErrDropped
+		case fail && code != "":
+			return &s3.Error{
+				StatusCode: faultStatusCode(code),
+				Code:       code,
+				Message:    "injected fault: " + code,
+			}
+		}
+		return nil
+	}
+}
+
+func faultStatusCode(code string) int {
+	switch code {
+	case s3.ErrInternalError:
+		return 500
+	case s3.ErrSlowDown, s3.ErrServiceUnavailable:
+		return 503
+	case s3.ErrAccessDenied, s3.ErrInvalidAccessKeyID, s3.ErrAccountProblem:
+		return 403
+	case s3.ErrNoSuchKey, s3.ErrNoSuchBucket:
+		return 404
+	}
+	return 400
+}
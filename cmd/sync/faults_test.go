@@ -0,0 +1,75 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	got, err := sync.ParseFaults("error=0.5:SlowDown,InternalError; spike=0.1:2s;drop=0.01;seed=42")
+	if err != nil {
+		t.Fatalf("can't parse faults: %v", err)
+	}
+	want := sync.Faults{
+		ErrorRate: 0.5,
+		Errors:    []string{"SlowDown", "InternalError"},
+		SpikeRate: 0.1,
+		Spike:     2 * time.Second,
+		DropRate:  0.01,
+		Seed:      42,
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+
+	for _, spec := range []string{"error=2", "boom=0.1", "spike=0.1:often", "drop"} {
+		if _, err := sync.ParseFaults(spec); err == nil {
+			t.Errorf("want an error parsing %q", spec)
+		}
+	}
+}
+
+func TestInjectFaultsIsDeterministic(t *testing.T) {
+	faults := sync.Faults{
+		ErrorRate: 0.3,
+		Errors:    []string{s3.ErrSlowDown},
+		DropRate:  0.2,
+		Seed:      7,
+	}
+	outcomes := func() []string {
+		syncer := sync.InjectFaults(func(src, dst *s3.Bucket, key s3.Key) error { return nil }, faults)
+		var got []string
+		for i := 0; i < 100; i++ {
+			switch err := syncer(nil, nil, s3.Key{}).(type) {
+			case nil:
+				got = append(got, "ok")
+			case *s3.Error:
+				if err.StatusCode != 503 {
+					t.Errorf("want status 503 for %q, got %d", err.Code, err.StatusCode)
+				}
+				got = append(got, err.Code)
+			default:
+				if err != sync.ErrDropped {
+					t.Fatalf("unexpected error %v", err)
+				}
+				got = append(got, "dropped")
+			}
+		}
+		return got
+	}
+
+	first := outcomes()
+	if !reflect.DeepEqual(first, outcomes()) {
+		t.Errorf("same seed injected different faults")
+	}
+	counts := map[string]int{}
+	for _, o := range first {
+		counts[o]++
+	}
+	if counts["ok"] == 0 || counts["dropped"] == 0 || counts[s3.ErrSlowDown] == 0 {
+		t.Errorf("want a mix of outcomes, got %v", counts)
+	}
+}
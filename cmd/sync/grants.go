@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"strings"
+)
+
+// Grants copies the ACL grants of each key onto its copy, beyond what a
+// canned ACL can express, such as the grants to other accounts. Canonical
+// IDs are those of the accounts at the source, which IDs maps to the
+// accounts that replace them at the destination, such as when migrating
+// between organizations. Grantees missing from IDs keep their ID, and
+// groups such as AllUsers keep their URI.
+type Grants struct {
+	IDs map[string]string
+}
+
+// Map the grants of a key at the source to those of its copy.
+func (g Grants) Map(grants []s3.Grant) []s3.Grant {
+	mapped := make([]s3.Grant, 0, len(grants))
+	for _, grant := range grants {
+		grantee := s3.Grantee{URI: grant.Grantee.URI}
+		if grantee.URI == "" {
+			grantee.ID = grant.Grantee.ID
+			if id, ok := g.IDs[grantee.ID]; ok {
+				grantee.ID = id
+			}
+		}
+		mapped = append(mapped, s3.Grant{Grantee: grantee, Permission: grant.Permission})
+	}
+	return mapped
+}
+
+// ReadGrantIDs reads a table of canonical IDs, a line per account with its
+// ID at the source and its ID at the destination separated by spaces.
+// Blank lines and those starting with # are skipped.
+func ReadGrantIDs(r io.Reader) (map[string]string, error) {
+	ids := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want a source and a destination ID, got %q", n, line)
+		}
+		if prev, ok := ids[fields[0]]; ok && prev != fields[1] {
+			return nil, fmt.Errorf("line %d: ID %s already maps to %s", n, fields[0], prev)
+		}
+		ids[fields[0]] = fields[1]
+	}
+	return ids, scanner.Err()
+}
+
+// GrantsCopy copies keys with copier, then replaces the ACL of each copy by
+// the grants of its source, mapped by grants. A copy whose grants can't be
+// copied fails, and is copied again when retried.
+func GrantsCopy(copier CopyFunc, grants Grants) CopyFunc {
+	return func(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+		if err := copier(src, dst, key, dstKey); err != nil {
+			return err
+		}
+		acl, err := src.GetPermissions(key.Key)
+		if err != nil {
+			return err
+		}
+		if err := dst.PutGrants(dstKey, grants.Map(acl.AccessControlList)); err != nil {
+			return err
+		}
+		metrics.grantsCopied.Add(int64(len(acl.AccessControlList)))
+		return nil
+	}
+}
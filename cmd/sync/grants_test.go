@@ -0,0 +1,76 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrantsCopy(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	allUsers := "http://acs.amazonaws.com/groups/global/AllUsers"
+	grants := []s3.Grant{
+		{Grantee: s3.Grantee{Owner: s3.Owner{ID: "src-owner"}}, Permission: "FULL_CONTROL"},
+		{Grantee: s3.Grantee{Owner: s3.Owner{ID: "partner"}}, Permission: "READ"},
+		{Grantee: s3.Grantee{URI: allUsers}, Permission: "READ"},
+	}
+	keys := []s3.Key{{Key: "a"}, {Key: "b"}}
+	for _, key := range keys {
+		if err := src.Put(key.Key, []byte(key.Key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+		if err := src.PutGrants(key.Key, grants); err != nil {
+			t.Fatalf("can't grant %q: %v", key.Key, err)
+		}
+	}
+
+	ids, err := sync.ReadGrantIDs(strings.NewReader("# source destination\nsrc-owner  dst-owner\n\n"))
+	if err != nil {
+		t.Fatalf("can't read IDs: %v", err)
+	}
+	copier := sync.GrantsCopy(sync.PutCopy, sync.Grants{IDs: ids})
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithCopier(copier))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	want := []s3.Grant{
+		{Grantee: s3.Grantee{Owner: s3.Owner{ID: "dst-owner"}}, Permission: "FULL_CONTROL"},
+		{Grantee: s3.Grantee{Owner: s3.Owner{ID: "partner"}}, Permission: "READ"},
+		{Grantee: s3.Grantee{URI: allUsers}, Permission: "READ"},
+	}
+	for _, key := range keys {
+		acl, err := dst.GetPermissions(key.Key)
+		if err != nil {
+			t.Fatalf("can't get the grants of %q: %v", key.Key, err)
+		}
+		if !reflect.DeepEqual(acl.AccessControlList, want) {
+			t.Errorf("%s: want grants %+v, got %+v", key.Key, want, acl.AccessControlList)
+		}
+	}
+
+	for _, bad := range []string{"one-id\n", "a b\na c\n"} {
+		if _, err := sync.ReadGrantIDs(strings.NewReader(bad)); err == nil {
+			t.Errorf("want IDs %q invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package sync
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var headMetrics = struct {
+	heads    *expvar.Int
+	tagCalls *expvar.Int
+	missing  *expvar.Int
+	filtered *expvar.Int
+	failed   *expvar.Int
+	retries  *expvar.Int
+}{
+	heads:    expvar.NewInt("brigade.head.heads"),
+	tagCalls: expvar.NewInt("brigade.head.tag_calls"),
+	missing:  expvar.NewInt("brigade.head.missing"),
+	filtered: expvar.NewInt("brigade.head.filtered"),
+	failed:   expvar.NewInt("brigade.head.failed"),
+	retries:  expvar.NewInt("brigade.head.retries"),
+}
+
+// HeadTask enriches the keys of a listing with the metadata of their
+// objects: their Content-Type, user metadata, storage class and tags, read
+// with a HEAD on each key, many at once. The enriched listing lets filters
+// pick keys by their metadata, and syncs copy it, without a call per key
+// at copy time. Keys are retried like the keys of a SyncTask.
+type HeadTask struct {
+	RetryBase time.Duration
+	MaxRetry  int
+	// HeadPara is the number of keys read at once.
+	HeadPara int
+	// Tags also reads the tags of the keys that have some, with a call per
+	// key on top of its HEAD.
+	Tags bool
+	// TagFilter, when set, leaves out the keys whose tags it doesn't pick,
+	// so that only those are synced from the enriched listing. The tags are
+	// read whether Tags is set or not.
+	TagFilter TagFilter
+
+	// OutputFormat is the listing format of the enriched and failed
+	// outputs, JSON when empty. Only JSON has room for the metadata.
+	OutputFormat string
+
+	// RunID, when set, is attached to the failures of the task.
+	RunID string
+
+	bkt   *s3.Bucket
+	stats headStats
+}
+
+// HeadProgress counts the keys of a HeadTask so far.
+type HeadProgress struct {
+	Keys     int64 `json:"keys"`
+	Enriched int64 `json:"enriched"`
+	Missing  int64 `json:"missing"`
+	Filtered int64 `json:"filtered"`
+	Failed   int64 `json:"failed"`
+	Retries  int64 `json:"retries"`
+}
+
+type headStats struct {
+	reg             *monitor.Registry
+	keys, enriched  *monitor.Counter
+	missing, failed *monitor.Counter
+	filtered        *monitor.Counter
+	retries         *monitor.Counter
+}
+
+func newHeadStats() headStats {
+	reg := monitor.NewRegistry()
+	return headStats{
+		reg:      reg,
+		keys:     reg.Counter("keys"),
+		enriched: reg.Counter("enriched"),
+		missing:  reg.Counter("missing"),
+		failed:   reg.Counter("failed"),
+		filtered: reg.Counter("filtered"),
+		retries:  reg.Counter("retries"),
+	}
+}
+
+// NewHeadTask creates a task that reads the metadata of keys of bkt. It
+// fails if the bucket can't be listed.
+func NewHeadTask(bkt *s3.Bucket) (*HeadTask, error) {
+	if _, err := bkt.List("/", "/", "/", 1); err != nil {
+		return nil, fmt.Errorf("couldn't list bucket %q: %v", bkt.Name, err)
+	}
+	return &HeadTask{
+		RetryBase: time.Second,
+		MaxRetry:  50,
+		HeadPara:  100,
+		bkt:       bkt,
+		stats:     newHeadStats(),
+	}, nil
+}
+
+// Progress of the task so far.
+func (h *HeadTask) Progress() HeadProgress {
+	snap := h.stats.reg.Snapshot()
+	return HeadProgress{
+		Keys:     snap.Counters["keys"],
+		Enriched: snap.Counters["enriched"],
+		Missing:  snap.Counters["missing"],
+		Filtered: snap.Counters["filtered"],
+		Failed:   snap.Counters["failed"],
+		Retries:  snap.Counters["retries"],
+	}
+}
+
+// Start reads the keys of the listing input, in any format, and writes
+// them with their metadata to enriched, and those whose metadata couldn't
+// be read to failed, with their error in JSON. Keys that no longer exist,
+// and keys the TagFilter doesn't pick, are left out of both.
+func (h *HeadTask) Start(input io.Reader, enriched, failed io.Writer) error {
+	switch {
+	case h.MaxRetry < 1:
+		return fmt.Errorf("need at least 1 attempt, got %d", h.MaxRetry)
+	case h.HeadPara < 1:
+		return fmt.Errorf("need at least 1 worker, got %d", h.HeadPara)
+	}
+	okEnc, err := listing.NewEnrichedWriter(enriched, h.OutputFormat)
+	if err != nil {
+		return err
+	}
+	failEnc, err := listing.NewEncoder(failed, h.OutputFormat)
+	if err != nil {
+		return err
+	}
+	rd, err := listing.NewReader(input)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	keys := make(chan s3.Key, h.HeadPara*BufferFactor)
+	keysOk := make(chan listing.Enriched, h.HeadPara*BufferFactor)
+	keysFail := make(chan listing.Failure, h.HeadPara*BufferFactor)
+
+	logrus.WithFields(logrus.Fields{
+		"head_workers": h.HeadPara,
+		"tags":         h.Tags || h.TagFilter != nil,
+	}).Info("starting key head workers")
+	var headGroup pipeline.Workers
+	headGroup.Start(h.HeadPara, func(int) {
+		for key := range keys {
+			h.enrich(key, keysOk, keysFail)
+		}
+	})
+
+	var encGroup pipeline.Workers
+	var encErr pipeline.FirstError
+	encGroup.Start(1, func(int) {
+		for e := range keysOk {
+			encErr.Set(okEnc.WriteEnriched(e))
+		}
+		encErr.Set(okEnc.Flush())
+	})
+	encGroup.Start(1, func(int) {
+		for f := range keysFail {
+			encErr.Set(failEnc.EncodeFailure(f))
+		}
+		encErr.Set(failEnc.Flush())
+	})
+
+	err = h.readKeys(rd, keys)
+	close(keys)
+	headGroup.Wait()
+	close(keysOk)
+	close(keysFail)
+	encGroup.Wait()
+	if err == nil {
+		err = encErr.Err()
+	}
+
+	progress := h.Progress()
+	logrus.WithFields(logrus.Fields{
+		"since_start": time.Since(start),
+		"keys":        progress.Keys,
+		"enriched":    progress.Enriched,
+		"missing":     progress.Missing,
+		"filtered":    progress.Filtered,
+		"failed":      progress.Failed,
+		"retries":     progress.Retries,
+	}).Info("done reading the metadata of keys")
+	return err
+}
+
+func (h *HeadTask) readKeys(rd listing.Reader, keys chan<- s3.Key) error {
+	for {
+		var key s3.Key
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			return nil
+		case nil:
+		default:
+			return err
+		}
+		h.stats.keys.Add(1)
+		keys <- key
+	}
+}
+
+// enrich reads the metadata of a key, retrying retriable errors MaxRetry
+// times.
+func (h *HeadTask) enrich(key s3.Key, enriched chan<- listing.Enriched, failed chan<- listing.Failure) {
+	for retry := 1; ; retry++ {
+		e, err := h.head(key)
+		switch {
+		case err == nil && h.TagFilter != nil && !h.TagFilter.Match(e.Tags):
+			headMetrics.filtered.Add(1)
+			h.stats.filtered.Add(1)
+			return
+		case err == nil:
+			h.stats.enriched.Add(1)
+			enriched <- e
+			return
+		case isNotFound(err):
+			headMetrics.missing.Add(1)
+			h.stats.missing.Add(1)
+			logrus.WithField("key", key.Key).Warn("key is gone, leaving it out")
+			return
+		case retriable(err) && retry < h.MaxRetry:
+			headMetrics.retries.Add(1)
+			h.stats.retries.Add(1)
+			sleepFor := h.RetryBase * time.Duration(retry)
+			logrus.WithFields(logrus.Fields{
+				"key":       key.Key,
+				"error":     err,
+				"sleep":     sleepFor,
+				"retry":     retry,
+				"max_retry": h.MaxRetry,
+			}).Debug("sleeping on retryable error")
+			time.Sleep(sleepFor)
+		default:
+			headMetrics.failed.Add(1)
+			h.stats.failed.Add(1)
+			f := listing.Failure{Key: key, Error: err.Error(), Retries: retry - 1, Time: time.Now().UTC(), RunID: h.RunID}
+			if e, ok := err.(*s3.Error); ok {
+				f.ErrorCode = e.Code
+			}
+			logrus.WithFields(logrus.Fields{
+				"key":   key.Key,
+				"error": err,
+			}).Error("failed to read the metadata of key")
+			failed <- f
+			return
+		}
+	}
+}
+
+// head reads the metadata of a key with a HEAD, and its tags if asked to
+// and it has some.
+func (h *HeadTask) head(key s3.Key) (listing.Enriched, error) {
+	return Head(h.bkt, key, h.Tags || h.TagFilter != nil)
+}
+
+// Head reads the metadata of a key of bkt with a HEAD, and its tags if tags
+// is set and it has some.
+func Head(bkt *s3.Bucket, key s3.Key, tags bool) (listing.Enriched, error) {
+	var headers map[string][]string
+	if bkt.RequesterPays {
+		headers = map[string][]string{"x-amz-request-payer": {"requester"}}
+	}
+	headMetrics.heads.Add(1)
+	resp, err := bkt.Head(key.Key, headers)
+	if err != nil {
+		return listing.Enriched{}, err
+	}
+	_ = resp.Body.Close()
+
+	// S3 leaves out the storage class of STANDARD objects
+	key.StorageClass = resp.Header.Get("x-amz-storage-class")
+	if key.StorageClass == "" {
+		key.StorageClass = "STANDARD"
+	}
+	e := listing.Enriched{Key: key, ContentType: resp.Header.Get("Content-Type")}
+	for name, values := range resp.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-meta-") && len(values) > 0 {
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]string)
+			}
+			e.Metadata[strings.TrimPrefix(name, "x-amz-meta-")] = values[0]
+		}
+	}
+	if !tags {
+		return e, nil
+	}
+	if n, _ := strconv.Atoi(resp.Header.Get("x-amz-tagging-count")); n == 0 {
+		return e, nil
+	}
+	headMetrics.tagCalls.Add(1)
+	if e.Tags, err = bkt.GetTags(key.Key); err != nil {
+		return listing.Enriched{}, err
+	}
+	return e, nil
+}
+
+// isNotFound is true for the errors of keys that don't exist. HEAD
+// responses have no body, so their errors have no code.
+func isNotFound(err error) bool {
+	e, ok := err.(*s3.Error)
+	return ok && (e.StatusCode == http.StatusNotFound || e.Code == s3.ErrNoSuchKey)
+}
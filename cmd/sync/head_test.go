@@ -0,0 +1,150 @@
+package sync_test
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHeadTask(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	bkt := mocks3.S3().Bucket("src-bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	opts := s3.Options{
+		Meta:         map[string][]string{"owner": {"storage"}},
+		StorageClass: "STANDARD_IA",
+		Tagging:      "team=storage&env=prod",
+	}
+	if err := bkt.Put("tagged", []byte("tagged"), "image/png", s3.Private, opts); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	if err := bkt.Put("plain", []byte("plain"), "text/plain", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	keys := []s3.Key{{Key: "tagged"}, {Key: "plain"}, {Key: "gone"}}
+
+	task, err := sync.NewHeadTask(bkt)
+	if err != nil {
+		t.Fatalf("can't create head task: %v", err)
+	}
+	task.HeadPara = 1
+	task.Tags = true
+	var enriched, failed bytes.Buffer
+	if err := task.Start(encodeKeys(keys), &enriched, &failed); err != nil {
+		t.Fatalf("can't head keys: %v", err)
+	}
+
+	want := []listing.Enriched{
+		{
+			Key:         s3.Key{Key: "tagged", StorageClass: "STANDARD_IA"},
+			ContentType: "image/png",
+			Metadata:    map[string]string{"owner": "storage"},
+			Tags:        map[string]string{"team": "storage", "env": "prod"},
+		},
+		{
+			Key:         s3.Key{Key: "plain", StorageClass: "STANDARD"},
+			ContentType: "text/plain",
+		},
+	}
+	var got []listing.Enriched
+	scan := bufio.NewScanner(bytes.NewReader(enriched.Bytes()))
+	for scan.Scan() {
+		var e listing.Enriched
+		if err := listing.UnmarshalEnriched(scan.Bytes(), &e); err != nil {
+			t.Fatalf("can't decode enriched key: %v", err)
+		}
+		got = append(got, e)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want enriched keys %+v, got %+v", want, got)
+	}
+	if failed.Len() != 0 {
+		t.Errorf("want no failures, got %s", failed.String())
+	}
+	progress := task.Progress()
+	if progress.Keys != 3 || progress.Enriched != 2 || progress.Missing != 1 {
+		t.Errorf("want 3 keys, 2 enriched and 1 missing, got %+v", progress)
+	}
+
+	// enriched listings are still listings of keys
+	if names := decodeKeys(&enriched); len(names) != 2 || names[0].Key != "tagged" {
+		t.Errorf("want the enriched keys read as keys, got %v", names)
+	}
+}
+
+func TestHeadTaskTagFilter(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	bkt := mocks3.S3().Bucket("src-bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	for name, tagging := range map[string]string{
+		"backup":        "backup=true&team=web",
+		"backup-legacy": "backup=true&legacy=yes",
+		"no-backup":     "backup=false",
+		"untagged":      "",
+	} {
+		if err := bkt.Put(name, []byte(name), "", s3.Private, s3.Options{Tagging: tagging}); err != nil {
+			t.Fatalf("can't put key: %v", err)
+		}
+	}
+	keys := []s3.Key{{Key: "backup"}, {Key: "backup-legacy"}, {Key: "no-backup"}, {Key: "untagged"}}
+
+	for _, tt := range []struct {
+		spec string
+		want []string
+	}{
+		{"backup=true", []string{"backup", "backup-legacy"}},
+		{"backup=true,!legacy", []string{"backup"}},
+		{"backup", []string{"backup", "backup-legacy", "no-backup"}},
+		{"!backup", []string{"untagged"}},
+	} {
+		filter, err := sync.ParseTagFilter(tt.spec)
+		if err != nil {
+			t.Fatalf("can't parse tag filter %q: %v", tt.spec, err)
+		}
+		task, err := sync.NewHeadTask(bkt)
+		if err != nil {
+			t.Fatalf("can't create head task: %v", err)
+		}
+		task.HeadPara = 1
+		task.TagFilter = filter
+		var enriched bytes.Buffer
+		if err := task.Start(encodeKeys(keys), &enriched, ioutil.Discard); err != nil {
+			t.Fatalf("can't head keys: %v", err)
+		}
+		var got []string
+		for _, key := range decodeKeys(&enriched) {
+			got = append(got, key.Key)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("want %v picked by %q, got %v", tt.want, tt.spec, got)
+		}
+		if p := task.Progress(); p.Filtered != int64(len(keys)-len(tt.want)) {
+			t.Errorf("want the other keys of %q filtered, got %+v", tt.spec, p)
+		}
+	}
+
+	for _, spec := range []string{"", "backup=", "!", "=true"} {
+		if _, err := sync.ParseTagFilter(spec); err == nil {
+			t.Errorf("want invalid tag filter %q", spec)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Hedging cuts the long tail of the latency of the sync calls, from the
+// occasional slow S3 response: a call that is slower than most calls of the
+// task so far is hedged with a second, identical call, and whichever of the
+// two succeeds first wins. The context of the other call is cancelled, which
+// only a ContextSyncerFunc can act on, a SyncerFunc carries on in the
+// background and its outcome is ignored.
+type Hedging struct {
+	// Quantile of the latency of the sync calls of the task after which a
+	// call is hedged, such as 0.95.
+	Quantile float64
+	// MinCalls is how many calls the task makes before hedging any, for
+	// the quantile to be meaningful.
+	MinCalls int64
+	// MaxRate is the fraction of the calls that can be hedged, which caps
+	// the extra calls when S3 is slow as a whole rather than for a few
+	// calls.
+	MaxRate float64
+}
+
+// Validate checks that calls can be hedged.
+func (h Hedging) Validate() error {
+	switch {
+	case h.Quantile <= 0 || h.Quantile >= 1:
+		return fmt.Errorf("hedging quantile must be in (0, 1), got %v", h.Quantile)
+	case h.MinCalls < 1:
+		return fmt.Errorf("need at least 1 call before hedging, got %d", h.MinCalls)
+	case h.MaxRate <= 0 || h.MaxRate > 1:
+		return fmt.Errorf("hedging rate must be in (0, 1], got %v", h.MaxRate)
+	}
+	return nil
+}
+
+// hedger counts the calls of a task, and those it hedged.
+type hedger struct {
+	Hedging
+	calls, hedges int64
+}
+
+// delay is how long a call can take before it's hedged, false if it can't
+// be hedged yet.
+func (h *hedger) delay(s *SyncTask) (time.Duration, bool) {
+	atomic.AddInt64(&h.calls, 1)
+	if s.stats.latency.Count() < h.MinCalls {
+		return 0, false
+	}
+	return s.stats.latency.Quantile(h.Quantile), true
+}
+
+// take a hedge, false if the task hedged too many of its calls.
+func (h *hedger) take() bool {
+	if float64(atomic.AddInt64(&h.hedges, 1)) > h.MaxRate*float64(atomic.LoadInt64(&h.calls)) {
+		atomic.AddInt64(&h.hedges, -1)
+		return false
+	}
+	return true
+}
+
+type hedgeResult struct {
+	err   error
+	hedge bool
+}
+
+// hedged makes a call, and its hedge once the call takes longer than the
+// hedging quantile, returning the outcome of the first of them to succeed,
+// or of the last of them if both fail.
+func (s *SyncTask) hedged(ctx context.Context, attempt Attempt, call func(context.Context, Attempt) error) error {
+	delay, ok := s.hedger.delay(s)
+	if !ok {
+		return call(ctx, attempt)
+	}
+	// cancels the call that lost
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// buffered, so that the call that lost doesn't block once it returns
+	results := make(chan hedgeResult, 2)
+	go func() { results <- hedgeResult{err: call(ctx, attempt)} }()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case r := <-results:
+		return r.err
+	case <-timer.C:
+	}
+	if !s.hedger.take() {
+		return (<-results).err
+	}
+	metrics.hedged.Add(1)
+	s.stats.hedged.Add(1)
+	hedge := attempt
+	hedge.Hedge = true
+	go func() { results <- hedgeResult{err: call(ctx, hedge), hedge: true} }()
+
+	r := <-results
+	if r.err != nil {
+		r = <-results
+	}
+	if r.err == nil && r.hedge {
+		metrics.hedgeWins.Add(1)
+	}
+	return r.err
+}
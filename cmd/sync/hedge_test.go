@@ -0,0 +1,82 @@
+package sync_test
+
+import (
+	"context"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestHedging(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:40]
+	slow := keys[30].Key
+
+	// the first call for the slow key hangs until it's cancelled, its hedge
+	// goes through like the calls for the other keys
+	var mu gosync.Mutex
+	var cancelled, hedgedSlow bool
+	syncer := func(ctx context.Context, attempt sync.Attempt, src, dst *s3.Bucket, key s3.Key) error {
+		if key.Key == slow && !attempt.Hedge {
+			<-ctx.Done()
+			mu.Lock()
+			cancelled = ctx.Err() == context.Canceled
+			mu.Unlock()
+			return ctx.Err()
+		}
+		if key.Key == slow {
+			mu.Lock()
+			hedgedSlow = true
+			mu.Unlock()
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(1),
+		sync.WithContextSyncer(syncer),
+		sync.WithHedging(sync.Hedging{Quantile: 0.95, MinCalls: 20, MaxRate: 0.5}),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	p := syncTask.Progress()
+	if p.Synced != int64(len(keys)) || p.Retries != 0 {
+		t.Errorf("want all %d keys synced without retries, got %+v", len(keys), p)
+	}
+	if p.Hedged < 1 {
+		t.Errorf("want the slow call hedged, got %d hedged calls", p.Hedged)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !hedgedSlow || !cancelled {
+		t.Errorf("want the slow call hedged and cancelled once its hedge won")
+	}
+
+	for _, h := range []sync.Hedging{
+		{Quantile: 1, MinCalls: 1, MaxRate: 0.1},
+		{Quantile: 0.95, MinCalls: 0, MaxRate: 0.1},
+		{Quantile: 0.95, MinCalls: 1, MaxRate: 0},
+	} {
+		if err := h.Validate(); err == nil {
+			t.Errorf("want invalid hedging %+v", h)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxHookOutput is how much of the output of a failed hook command is logged.
+const maxHookOutput = 1024
+
+// Hook runs an external command after each key is synced, for instance to
+// purge a cache or notify a system that the key is now at the destination.
+// Commands run in the background, a few at a time, so that a slow command
+// doesn't hold a sync worker; the task waits for them before it completes.
+type Hook struct {
+	// Command is run by sh -c, with {key} and {bucket} replaced by the name
+	// of the key at the destination and the destination bucket, quoted for
+	// the shell. They're also in the BRIGADE_KEY and BRIGADE_BUCKET
+	// environment variables of the command, next to the BRIGADE_RUN_ID of
+	// the task.
+	Command string
+	// Para is how many commands run at once. Syncing blocks when the
+	// commands can't keep up.
+	Para int
+	// MaxRetry is how many times a failed command is retried, waiting a
+	// second times the retry between each, before it's given up on.
+	MaxRetry int
+	// Timeout, when not 0, kills the commands that run for longer, which
+	// then fail.
+	Timeout time.Duration
+}
+
+// Validate checks that the hook can run commands.
+func (h Hook) Validate() error {
+	if strings.TrimSpace(h.Command) == "" {
+		return errors.New("hook needs a command")
+	}
+	if h.Para < 1 {
+		return fmt.Errorf("need at least 1 hook command at a time, got %d", h.Para)
+	}
+	if h.MaxRetry < 0 || h.Timeout < 0 {
+		return fmt.Errorf("hook retries and timeout can't be negative, got %d and %v", h.MaxRetry, h.Timeout)
+	}
+	return nil
+}
+
+// hookRetryBase is the wait before the first retry of a failed command.
+var hookRetryBase = time.Second
+
+// startHooks starts the workers that run the hook of the task for the keys
+// sent to s.hooked, which are waited for by the returned func.
+func (s *SyncTask) startHooks() func() {
+	if s.Hook == nil {
+		return func() {}
+	}
+	logrus.WithFields(logrus.Fields{
+		"command":       s.Hook.Command,
+		"hook_commands": s.Hook.Para,
+	}).Info("starting hook commands")
+	s.hooked = make(chan string, s.Hook.Para*BufferFactor)
+	var workers pipeline.Workers
+	workers.Start(s.Hook.Para, func(int) {
+		for name := range s.hooked {
+			s.runHook(name)
+		}
+	})
+	return func() {
+		close(s.hooked)
+		workers.Wait()
+		logrus.WithField("hook_failed", s.stats.hookFailed.Value()).Info("done running hook commands")
+	}
+}
+
+// runHook runs the hook command for a synced key, retrying it on failures.
+func (s *SyncTask) runHook(name string) {
+	var (
+		out []byte
+		err error
+	)
+	for retry := 0; retry <= s.Hook.MaxRetry; retry++ {
+		if retry > 0 {
+			metrics.hookRetries.Add(1)
+			time.Sleep(hookRetryBase * time.Duration(retry))
+		}
+		if out, err = s.execHook(name); err == nil {
+			metrics.hookOk.Add(1)
+			return
+		}
+	}
+	metrics.hookFailed.Add(1)
+	s.stats.hookFailed.Add(1)
+	if len(out) > maxHookOutput {
+		out = out[len(out)-maxHookOutput:]
+	}
+	logrus.WithFields(logrus.Fields{
+		"key":     name,
+		"retries": s.Hook.MaxRetry,
+		"error":   err,
+		"output":  string(out),
+	}).Error("hook command failed for synced key")
+}
+
+// execHook runs the hook command once for a key, returning its output.
+func (s *SyncTask) execHook(name string) ([]byte, error) {
+	command := strings.NewReplacer(
+		"{key}", shellQuote(name),
+		"{bucket}", shellQuote(s.dst.Name),
+	).Replace(s.Hook.Command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(), "BRIGADE_KEY="+name, "BRIGADE_BUCKET="+s.dst.Name, "BRIGADE_RUN_ID="+s.RunID)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// in its own process group, so that the processes it starts are
+	// killed with it on timeouts
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if s.Hook.Timeout > 0 {
+		timer := time.AfterFunc(s.Hook.Timeout, func() { _ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) })
+		defer timer.Stop()
+	}
+	err := cmd.Wait()
+	return out.Bytes(), err
+}
+
+// shellQuote quotes s as a single word for sh, so that keys can't inject
+// commands.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
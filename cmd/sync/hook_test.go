@@ -0,0 +1,120 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncHook(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	// a key that would run a command if it wasn't quoted
+	names := []string{"a/1", "it's; touch pwned", "b/2"}
+	var keys []s3.Key
+	for _, name := range names {
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+
+	dir, err := ioutil.TempDir("", "brigade-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	out := filepath.Join(dir, "hooked")
+
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(2),
+		sync.WithHook(sync.Hook{Command: "cd " + dir + " && echo {bucket} {key} >> " + out, Para: 2}),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("hook didn't run: %v", err)
+	}
+	got := strings.Split(strings.TrimSpace(string(data)), "\n")
+	sort.Strings(got)
+	want := []string{"dst-bucket a/1", "dst-bucket b/2", "dst-bucket it's; touch pwned"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("want hook run for %q, got %q", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("key wasn't quoted for the shell")
+	}
+	if failed := syncTask.Progress().HookFailed; failed != 0 {
+		t.Errorf("want no hook failure, got %d", failed)
+	}
+}
+
+func TestSyncHookFailures(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, name := range []string{"ok", "fail", "slow"} {
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+
+	hook := sync.Hook{
+		Command: `case "$BRIGADE_KEY" in fail) exit 1;; slow) sleep 5;; esac`,
+		Para:    3,
+		Timeout: 100 * time.Millisecond,
+	}
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(3), sync.WithHook(hook))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	// the keys are synced all the same
+	progress := syncTask.Progress()
+	if progress.Synced != 3 || progress.HookFailed != 2 {
+		t.Errorf("want 3 keys synced and 2 hook failures, got %+v", progress)
+	}
+
+	for _, bad := range []sync.Hook{{Para: 1}, {Command: "true"}, {Command: "true", Para: 1, MaxRetry: -1}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
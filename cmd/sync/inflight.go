@@ -0,0 +1,150 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowKeys warns about the keys that take long to sync, from their first
+// attempt, such as a huge object or a key whose connection is wedged,
+// which the aggregate counters of the task don't tell apart from the others.
+type SlowKeys struct {
+	// After is how long a key can take before it's slow. Each slow key is
+	// warned about once.
+	After time.Duration
+	// Top is how many of the slowest keys the snapshots of the task list.
+	Top int
+}
+
+// Validate checks that slow keys can be reported.
+func (k SlowKeys) Validate() error {
+	switch {
+	case k.After <= 0:
+		return fmt.Errorf("slow keys period must be positive, got %v", k.After)
+	case k.Top < 1:
+		return fmt.Errorf("need to list at least 1 slow key, got %d", k.Top)
+	}
+	return nil
+}
+
+// InflightKey is a key being synced.
+type InflightKey struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	Started time.Time `json:"started"`
+	// Elapsed seconds since the first attempt started.
+	Elapsed float64 `json:"elapsed_s"`
+	Attempt int     `json:"attempt"`
+}
+
+type inflightKey struct {
+	key     s3.Key
+	started time.Time
+	attempt int32
+	// whether the key was warned about
+	reported bool
+}
+
+// inflightKeys tracks the keys being synced.
+type inflightKeys struct {
+	mu   sync.Mutex
+	keys map[*inflightKey]struct{}
+}
+
+func newInflightKeys() *inflightKeys {
+	return &inflightKeys{keys: make(map[*inflightKey]struct{})}
+}
+
+func (t *inflightKeys) add(key s3.Key) *inflightKey {
+	k := &inflightKey{key: key, started: time.Now()}
+	t.mu.Lock()
+	t.keys[k] = struct{}{}
+	t.mu.Unlock()
+	return k
+}
+
+func (t *inflightKeys) remove(k *inflightKey) {
+	t.mu.Lock()
+	delete(t.keys, k)
+	t.mu.Unlock()
+}
+
+// oldest returns at most n of the keys, the ones that started first, or all
+// of them if n is negative.
+func (t *inflightKeys) oldest(n int) []*inflightKey {
+	t.mu.Lock()
+	keys := make([]*inflightKey, 0, len(t.keys))
+	for k := range t.keys {
+		keys = append(keys, k)
+	}
+	t.mu.Unlock()
+	sort.Sort(byStarted(keys))
+	if n >= 0 && len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+type byStarted []*inflightKey
+
+func (k byStarted) Len() int           { return len(k) }
+func (k byStarted) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k byStarted) Less(i, j int) bool { return k[i].started.Before(k[j].started) }
+
+// Slowest returns at most n of the keys being synced, the ones that have
+// been for the longest first.
+func (s *SyncTask) Slowest(n int) []InflightKey {
+	now := time.Now()
+	var keys []InflightKey
+	for _, k := range s.inflightKeys.oldest(n) {
+		keys = append(keys, InflightKey{
+			Key:     k.key.Key,
+			Size:    k.key.Size,
+			Started: k.started,
+			Elapsed: now.Sub(k.started).Seconds(),
+			Attempt: int(atomic.LoadInt32(&k.attempt)),
+		})
+	}
+	return keys
+}
+
+// watchSlowKeys warns about the keys that become slow until done is closed.
+func (s *SyncTask) watchSlowKeys(done <-chan struct{}) {
+	every := s.SlowKeys.After / 4
+	if every > time.Second {
+		every = time.Second
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick.C:
+			// the oldest keys come first, the ones after the first key
+			// that isn't slow aren't either
+			for _, k := range s.inflightKeys.oldest(-1) {
+				elapsed := now.Sub(k.started)
+				if elapsed < s.SlowKeys.After {
+					break
+				}
+				if k.reported {
+					continue
+				}
+				k.reported = true
+				metrics.slowKeys.Add(1)
+				logrus.WithFields(logrus.Fields{
+					"key":     k.key.Key,
+					"size":    k.key.Size,
+					"elapsed": elapsed,
+					"attempt": atomic.LoadInt32(&k.attempt),
+				}).Warn("key is slow to sync")
+			}
+		}
+	}
+}
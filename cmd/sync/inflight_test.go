@@ -0,0 +1,80 @@
+package sync_test
+
+import (
+	"expvar"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSlowKeys(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:20]
+	slowKeys := func() int64 {
+		n, err := strconv.ParseInt(expvar.Get("brigade.sync.slowKeys").String(), 10, 64)
+		if err != nil {
+			t.Fatalf("can't read slow keys: %v", err)
+		}
+		return n
+	}
+	before := slowKeys()
+
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(4),
+		sync.WithSlowKeys(sync.SlowKeys{After: 20 * time.Millisecond, Top: 2}),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+
+	// the first key holds its worker until it's reported as the slowest,
+	// while the others go through
+	release := make(chan struct{})
+	var slowest []sync.InflightKey
+	go func() {
+		defer close(release)
+		for slowKeys() == before {
+			time.Sleep(time.Millisecond)
+		}
+		slowest = syncTask.Slowest(2)
+	}()
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		if key.Key == keys[0].Key {
+			<-release
+		}
+		return nil
+	}
+
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if got := slowKeys() - before; got != 1 {
+		t.Errorf("want the slow key warned about once, got %d warnings", got)
+	}
+	if len(slowest) == 0 {
+		t.Fatalf("want the slow key listed")
+	}
+	if k := slowest[0]; k.Key != keys[0].Key || k.Size != keys[0].Size || k.Attempt != 1 || k.Elapsed < 0.02 {
+		t.Errorf("want %q as the slowest key, got %+v", keys[0].Key, k)
+	}
+	if got := syncTask.Slowest(2); len(got) != 0 {
+		t.Errorf("want no key in flight once done, got %+v", got)
+	}
+
+	if err := (sync.SlowKeys{After: 0, Top: 1}).Validate(); err == nil {
+		t.Errorf("want a period required")
+	}
+}
@@ -0,0 +1,162 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"sync"
+)
+
+// InputFile is a listing file of the input of a task, gunzip'd, in any
+// format.
+type InputFile struct {
+	Name string
+	R    io.Reader
+}
+
+// InputProgress of a file of Inputs: the keys read from it, and how many of
+// them were synced, failed or skipped so far.
+type InputProgress struct {
+	Name    string `json:"name"`
+	Keys    int64  `json:"keys"`
+	Synced  int64  `json:"synced"`
+	Failed  int64  `json:"failed"`
+	Skipped int64  `json:"skipped"`
+	// Done is set once the file was read to its end.
+	Done bool `json:"done"`
+}
+
+// Inputs reads many listing files as the input of a task, such as the
+// listings of split, without concatenating them first: one file after the
+// other, or a key of each file in turn when interleaved. The keys are read
+// as JSON lines by the task, which counts the outcome of each key in the
+// file it came from when given WithInputs. The file of a key is remembered
+// from when it's read until it's done, which holds the names of the keys
+// read ahead of the sync workers.
+type Inputs struct {
+	readers    []listing.Reader
+	interleave bool
+	// file read next when interleaved
+	next int
+	buf  []byte
+	key  s3.Key
+
+	mu       sync.Mutex
+	progress []InputProgress
+	// indexes of the files of the keys read and not done yet, by name,
+	// oldest first
+	pending map[string][]int
+}
+
+// NewInputs reads the keys of files, one file after the other, or a key of
+// each file in turn when interleave is set.
+func NewInputs(files []InputFile, interleave bool) (*Inputs, error) {
+	in := &Inputs{
+		interleave: interleave,
+		progress:   make([]InputProgress, len(files)),
+		pending:    make(map[string][]int),
+	}
+	for i, f := range files {
+		rd, err := listing.NewReader(f.R)
+		if err != nil {
+			return nil, fmt.Errorf("reading listing %q: %v", f.Name, err)
+		}
+		in.readers = append(in.readers, rd)
+		in.progress[i].Name = f.Name
+	}
+	return in, nil
+}
+
+// Read the keys of the files as JSON lines.
+func (in *Inputs) Read(p []byte) (int, error) {
+	for len(in.buf) == 0 {
+		i := in.pick()
+		if i < 0 {
+			return 0, io.EOF
+		}
+		switch err := in.readers[i].Read(&in.key); err {
+		case nil:
+		case io.EOF:
+			in.finish(i)
+			continue
+		default:
+			return 0, fmt.Errorf("reading listing %q: %v", in.progress[i].Name, err)
+		}
+		line, err := json.Marshal(in.key)
+		if err != nil {
+			return 0, err
+		}
+		in.buf = append(line, '\n')
+		in.mu.Lock()
+		in.progress[i].Keys++
+		in.pending[in.key.Key] = append(in.pending[in.key.Key], i)
+		in.mu.Unlock()
+	}
+	n := copy(p, in.buf)
+	in.buf = in.buf[n:]
+	return n, nil
+}
+
+// pick the file to read a key from, -1 once they're all read.
+func (in *Inputs) pick() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for n := 0; n < len(in.progress); n++ {
+		i := n
+		if in.interleave {
+			i = (in.next + n) % len(in.progress)
+		}
+		if !in.progress[i].Done {
+			in.next = i + 1
+			return i
+		}
+	}
+	return -1
+}
+
+func (in *Inputs) finish(i int) {
+	in.mu.Lock()
+	in.progress[i].Done = true
+	p := in.progress[i]
+	in.mu.Unlock()
+	logrus.WithFields(logrus.Fields{
+		"filename": p.Name,
+		"keys":     p.Keys,
+	}).Info("done reading listing file")
+}
+
+// Progress of each file, in the order they were given.
+func (in *Inputs) Progress() []InputProgress {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return append([]InputProgress(nil), in.progress...)
+}
+
+// done counts the outcome of a key, see AuditRecord, in the file it came
+// from.
+func (in *Inputs) done(name, outcome string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	files := in.pending[name]
+	if len(files) == 0 {
+		// not read from the files, such as a key of a disk queue
+		return
+	}
+	if len(files) == 1 {
+		delete(in.pending, name)
+	} else {
+		in.pending[name] = files[1:]
+	}
+	p := &in.progress[files[0]]
+	switch outcome {
+	case AuditSynced:
+		p.Synced++
+	case AuditFailed:
+		p.Failed++
+	case AuditSkipped:
+		p.Skipped++
+	}
+}
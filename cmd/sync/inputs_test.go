@@ -0,0 +1,98 @@
+package sync_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputsOrder(t *testing.T) {
+	files := func() []sync.InputFile {
+		return []sync.InputFile{
+			{Name: "a.json", R: encodeKeys([]s3.Key{{Key: "a1"}, {Key: "a2"}, {Key: "a3"}})},
+			{Name: "empty.json", R: bytes.NewBuffer(nil)},
+			{Name: "b.json", R: encodeKeys([]s3.Key{{Key: "b1"}})},
+		}
+	}
+
+	for _, tt := range []struct {
+		interleave bool
+		want       string
+	}{
+		{interleave: false, want: "a1 a2 a3 b1"},
+		{interleave: true, want: "a1 b1 a2 a3"},
+	} {
+		in, err := sync.NewInputs(files(), tt.interleave)
+		if err != nil {
+			t.Fatalf("can't read inputs: %v", err)
+		}
+		data, err := ioutil.ReadAll(in)
+		if err != nil {
+			t.Fatalf("can't read keys: %v", err)
+		}
+		var names []string
+		for _, key := range decodeKeys(bytes.NewBuffer(data)) {
+			names = append(names, key.Key)
+		}
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("interleave=%v: want keys %s, got %s", tt.interleave, tt.want, got)
+		}
+		for i, want := range []int64{3, 0, 1} {
+			p := in.Progress()[i]
+			if p.Keys != want || !p.Done {
+				t.Errorf("interleave=%v: want %d keys read from %s, got %+v", tt.interleave, want, p.Name, p)
+			}
+		}
+	}
+}
+
+func TestSyncInputs(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, b := range []*s3.Bucket{src, dst} {
+		if err := b.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		if key.Key == "a3" {
+			return fmt.Errorf("can't sync %q", key.Key)
+		}
+		return nil
+	}
+
+	in, err := sync.NewInputs([]sync.InputFile{
+		{Name: "a.json", R: encodeKeys([]s3.Key{{Key: "a1", Size: 2}, {Key: "a2", Size: 2}, {Key: "a3", Size: 2}})},
+		{Name: "b.json", R: encodeKeys([]s3.Key{{Key: "b1", Size: 2}})},
+	}, true)
+	if err != nil {
+		t.Fatalf("can't read inputs: %v", err)
+	}
+	task, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(2), sync.WithRetry(1, time.Millisecond), sync.WithSyncer(syncer), sync.WithInputs(in))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	var synced, failed bytes.Buffer
+	if err := task.Start(in, &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	progress := in.Progress()
+	if p := progress[0]; p.Keys != 3 || p.Synced != 2 || p.Failed != 1 {
+		t.Errorf("want 2 keys synced and 1 failed from a.json, got %+v", p)
+	}
+	if p := progress[1]; p.Keys != 1 || p.Synced != 1 || p.Failed != 0 {
+		t.Errorf("want 1 key synced from b.json, got %+v", p)
+	}
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// InstanceShard is the share of the keys of a listing synced by one of
+// Count instances of a sync, such as processes on different machines. Keys
+// are spread over the instances by a hash of their name, so instances given
+// the same listing split it without overlap, and without talking to each
+// other. Index goes from 1 to Count.
+type InstanceShard struct {
+	Index int
+	Count int
+}
+
+// ParseInstanceShard parses a shard such as "2/4", the second of 4.
+func ParseInstanceShard(spec string) (InstanceShard, error) {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return InstanceShard{}, fmt.Errorf("invalid shard %q, want i/n", spec)
+	}
+	var sh InstanceShard
+	var err error
+	if sh.Index, err = strconv.Atoi(parts[0]); err != nil {
+		return InstanceShard{}, fmt.Errorf("invalid shard index %q: %v", parts[0], err)
+	}
+	if sh.Count, err = strconv.Atoi(parts[1]); err != nil {
+		return InstanceShard{}, fmt.Errorf("invalid shard count %q: %v", parts[1], err)
+	}
+	return sh, sh.Validate()
+}
+
+// Validate checks that the shard is one of its count.
+func (sh InstanceShard) Validate() error {
+	if sh.Count < 1 || sh.Index < 1 || sh.Index > sh.Count {
+		return fmt.Errorf("shard must be i/n with 1 <= i <= n, got %d/%d", sh.Index, sh.Count)
+	}
+	return nil
+}
+
+// Owns is true if the key named name is in the shard.
+func (sh InstanceShard) Owns(name string) bool {
+	h := fnv.New64a()
+	_, _ = io.WriteString(h, name)
+	return h.Sum64()%uint64(sh.Count) == uint64(sh.Index-1)
+}
+
+// ShardListing reads the listing r, in any format, returning a JSON listing
+// of the keys in the shard, filtered as the listing is read.
+func ShardListing(r io.Reader, sh InstanceShard) (io.Reader, error) {
+	if err := sh.Validate(); err != nil {
+		return nil, err
+	}
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return filterListing(rd, func(key s3.Key) bool { return sh.Owns(key.Key) }), nil
+}
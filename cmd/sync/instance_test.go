@@ -0,0 +1,58 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+func TestShardListing(t *testing.T) {
+	var keys []s3.Key
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, s3.Key{Key: "prefix/" + strconv.Itoa(i)})
+	}
+
+	const n = 4
+	seen := make(map[string]int)
+	for i := 1; i <= n; i++ {
+		sh, err := sync.ParseInstanceShard(strconv.Itoa(i) + "/" + strconv.Itoa(n))
+		if err != nil {
+			t.Fatalf("can't parse shard: %v", err)
+		}
+		rd, err := sync.ShardListing(encodeKeys(keys), sh)
+		if err != nil {
+			t.Fatalf("can't shard: %v", err)
+		}
+		data, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatalf("can't read shard: %v", err)
+		}
+		shard := decodeKeys(bytes.NewBuffer(data))
+		if len(shard) < 150 || len(shard) > 350 {
+			t.Errorf("shard %d/%d: want about %d keys, got %d", i, n, len(keys)/n, len(shard))
+		}
+		for _, key := range shard {
+			seen[key.Key]++
+			if !sh.Owns(key.Key) {
+				t.Errorf("shard %d/%d: got key %q of another shard", i, n, key.Key)
+			}
+		}
+	}
+	if len(seen) != len(keys) {
+		t.Errorf("want all %d keys in a shard, got %d", len(keys), len(seen))
+	}
+	for key, count := range seen {
+		if count != 1 {
+			t.Errorf("want %q in a single shard, got %d", key, count)
+		}
+	}
+
+	for _, bad := range []string{"", "1", "0/4", "5/4", "a/4", "1/0", "1/2/3"} {
+		if _, err := sync.ParseInstanceShard(bad); err == nil {
+			t.Errorf("want %q invalid", bad)
+		}
+	}
+}
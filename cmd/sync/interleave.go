@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+)
+
+// interleave sends the keys of in to out round-robin over their top-level
+// prefixes, holding up to window keys to pick from, until in is closed and
+// all the keys held were sent. Listings are sorted, so the keys of a prefix
+// would otherwise be synced together, and S3 throttles the requests to a
+// prefix, answering with SlowDown, when there are too many of them at once.
+func interleave(in <-chan s3.Key, out chan<- s3.Key, window int) {
+	var (
+		queues = make(map[string][]s3.Key)
+		// prefixes with keys held, in the order they're sent
+		ring []string
+		next int
+		held int
+		seen = make(map[string]bool)
+	)
+	hold := func(key s3.Key) {
+		prefix := topLevelPrefix(key.Key)
+		if len(queues[prefix]) == 0 {
+			ring = append(ring, prefix)
+		}
+		queues[prefix] = append(queues[prefix], key)
+		seen[prefix] = true
+		held++
+	}
+	sent := func() {
+		prefix := ring[next]
+		queues[prefix] = queues[prefix][1:]
+		held--
+		if len(queues[prefix]) == 0 {
+			delete(queues, prefix)
+			ring = append(ring[:next], ring[next+1:]...)
+		} else {
+			next++
+		}
+		if next >= len(ring) {
+			next = 0
+		}
+	}
+
+	for in != nil || held > 0 {
+		// hold as many keys as possible before sending one, to pick from
+		// as many prefixes as possible
+		if in != nil && held < window {
+			select {
+			case key, ok := <-in:
+				if !ok {
+					in = nil
+				} else {
+					hold(key)
+				}
+				continue
+			default:
+			}
+		}
+
+		recv := in
+		if held >= window {
+			recv = nil
+		}
+		var send chan<- s3.Key
+		var key s3.Key
+		if held > 0 {
+			send = out
+			key = queues[ring[next]][0]
+		}
+		select {
+		case k, ok := <-recv:
+			if !ok {
+				in = nil
+			} else {
+				hold(k)
+			}
+		case send <- key:
+			sent()
+		}
+	}
+	logrus.WithField("prefixes", len(seen)).Info("done interleaving keys over their prefixes")
+}
@@ -0,0 +1,69 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncInterleave(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	// a sorted listing, with all the keys of a prefix together
+	var keys []s3.Key
+	for _, prefix := range []string{"a/", "b/", "c/"} {
+		for i := 0; i < 50; i++ {
+			name := prefix + strconv.Itoa(i)
+			if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+				t.Fatalf("can't put %q: %v", name, err)
+			}
+			keys = append(keys, s3.Key{Key: name})
+		}
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(1), sync.WithInterleave(len(keys)))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	var synced bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	got := decodeKeys(&synced)
+	if len(got) != len(keys) {
+		t.Fatalf("want %d keys synced, got %d", len(keys), len(got))
+	}
+	// the last prefix isn't left for the end
+	first := -1
+	for i, key := range got {
+		if strings.HasPrefix(key.Key, "c/") {
+			first = i
+			break
+		}
+	}
+	if first < 0 || first >= 100 {
+		t.Errorf("want keys of c/ synced among the others, first one is synced %dth", first)
+	}
+	// and the prefixes take turns once they're all held
+	last := got[len(got)-3:]
+	if last[0].Key[:2] == last[1].Key[:2] || last[1].Key[:2] == last[2].Key[:2] {
+		t.Errorf("want the last keys from different prefixes, got %v, %v, %v", last[0].Key, last[1].Key, last[2].Key)
+	}
+}
@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+)
+
+// DefaultMaxLine is the longest line of a JSON listing read by a task, in
+// bytes, when its Malformed doesn't say. A key takes well under a kilobyte:
+// a line of a megabyte is a corrupt input, such as one without newlines,
+// that would otherwise be buffered whole.
+const DefaultMaxLine = 1 << 20
+
+// Malformed is what a task does with the lines of its input that are longer
+// than MaxLine or can't be decoded into a key. By default, the first fails
+// the task.
+type Malformed struct {
+	// MaxLine is the longest line read, in bytes, DefaultMaxLine when 0.
+	// Longer lines are read through without being kept.
+	MaxLine int64
+	// Skip the malformed lines, counting them, rather than failing.
+	Skip bool
+	// MaxSkipped is the most lines skipped before the task fails anyway,
+	// since an input with that many is likely corrupt throughout, or no
+	// limit when 0.
+	MaxSkipped int64
+}
+
+// Validate the sizes.
+func (m Malformed) Validate() error {
+	if m.MaxLine < 0 {
+		return fmt.Errorf("longest line can't be negative, got %d", m.MaxLine)
+	}
+	if m.MaxSkipped < 0 {
+		return fmt.Errorf("number of malformed lines to skip can't be negative, got %d", m.MaxSkipped)
+	}
+	return nil
+}
+
+func (m *Malformed) maxLine() int64 {
+	if m == nil || m.MaxLine == 0 {
+		return DefaultMaxLine
+	}
+	return m.MaxLine
+}
+
+// MalformedLineError is the error of a task that read a malformed line,
+// after it skipped Skipped others. Err is a *pipeline.LineTooLongError for
+// a line too long, or the error decoding the line.
+type MalformedLineError struct {
+	Size    int64
+	Skipped int64
+	Err     error
+}
+
+func (e *MalformedLineError) Error() string {
+	return fmt.Sprintf("malformed line of %d bytes, after %d skipped: %v", e.Size, e.Skipped, e.Err)
+}
+
+// Unwrap returns the error of the line.
+func (e *MalformedLineError) Unwrap() error { return e.Err }
+
+// malformedLine skips a malformed line of size bytes, or returns the error
+// failing the task.
+func (s *SyncTask) malformedLine(size int64, err error) error {
+	metrics.malformedLines.Add(1)
+	n := s.stats.malformed.Add(1)
+	m := s.Malformed
+	if m == nil || !m.Skip || (m.MaxSkipped > 0 && n > m.MaxSkipped) {
+		return &MalformedLineError{Size: size, Skipped: n - 1, Err: err}
+	}
+	logrus.WithFields(logrus.Fields{
+		"size":    size,
+		"skipped": n,
+		"error":   err,
+	}).Warn("skipping malformed line")
+	return nil
+}
+
+// tooLong skips a line longer than the most read, or fails the task.
+func (s *SyncTask) tooLong(size int64) error {
+	metrics.fileLines.Add(1)
+	s.stats.lines.Add(1)
+	return s.malformedLine(size, &pipeline.LineTooLongError{Size: size, Max: s.Malformed.maxLine()})
+}
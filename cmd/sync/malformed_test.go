@@ -0,0 +1,81 @@
+package sync_test
+
+import (
+	"errors"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMalformedLines(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	keys := []s3.Key{{Key: "a"}, {Key: "b"}, {Key: "c"}}
+	for _, key := range keys {
+		if err := src.Put(key.Key, []byte(key.Key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+	// a line that isn't a key, and a long one that isn't either
+	input := func() string {
+		return encodeKeys(keys).String() + "not a key\n" + strings.Repeat("{", 1000) + "\n"
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithMalformed(sync.Malformed{MaxLine: 500, Skip: true}))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(strings.NewReader(input()), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("want the malformed lines skipped, got %v", err)
+	}
+	if p := syncTask.Progress(); p.Synced != int64(len(keys)) || p.Malformed != 2 {
+		t.Errorf("want %d keys synced and 2 malformed lines, got %+v", len(keys), p)
+	}
+
+	for _, tt := range []struct {
+		malformed sync.Malformed
+		skipped   int64
+		tooLong   bool
+	}{
+		// the first malformed line fails the task, unless skipped
+		{malformed: sync.Malformed{}},
+		{malformed: sync.Malformed{Skip: true, MaxSkipped: 1}, skipped: 1},
+		{malformed: sync.Malformed{MaxLine: 5}, tooLong: true},
+	} {
+		syncTask, err := sync.NewSyncTask(src, dst, sync.WithMalformed(tt.malformed))
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		err = syncTask.Start(strings.NewReader(input()), ioutil.Discard, ioutil.Discard)
+		var malformed *sync.MalformedLineError
+		if !errors.As(err, &malformed) || malformed.Skipped != tt.skipped {
+			t.Errorf("%+v: want a malformed line after %d skipped, got %v", tt.malformed, tt.skipped, err)
+			continue
+		}
+		var tooLong *pipeline.LineTooLongError
+		if errors.As(err, &tooLong) != tt.tooLong {
+			t.Errorf("%+v: want a line too long %v, got %v", tt.malformed, tt.tooLong, err)
+		}
+	}
+
+	for _, bad := range []sync.Malformed{{MaxLine: -1}, {MaxSkipped: -1}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
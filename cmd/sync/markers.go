@@ -0,0 +1,143 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The policies for directory markers, see Markers.
+const (
+	// MarkersCopy syncs the markers like any key.
+	MarkersCopy = "copy"
+	// MarkersSkip leaves the markers out of the sync.
+	MarkersSkip = "skip"
+	// MarkersSynthesize syncs the markers, and makes the ones missing at
+	// the destination for the prefixes of the keys synced.
+	MarkersSynthesize = "synthesize"
+)
+
+// MarkerContentType is the Content-Type of the markers the task makes, the
+// one consoles give the "folders" they create.
+const MarkerContentType = "application/x-directory"
+
+// IsMarker tells whether a key is a directory marker: an object of no bytes
+// whose name ends with a /, which consoles and tools create for "folders"
+// that hold no keys yet, or to make prefixes show up.
+func IsMarker(key s3.Key) bool {
+	return key.Size == 0 && strings.HasSuffix(key.Key, "/")
+}
+
+// ValidateMarkers checks a policy for directory markers.
+func ValidateMarkers(policy string) error {
+	switch policy {
+	case MarkersCopy, MarkersSkip, MarkersSynthesize:
+		return nil
+	}
+	return fmt.Errorf("unknown policy for directory markers %q, want %s, %s or %s", policy, MarkersCopy, MarkersSkip, MarkersSynthesize)
+}
+
+// skipMarker is true for the markers the task doesn't sync: all of them
+// with MarkersSkip, and those that a Mapping maps to the root of the
+// destination, which has no marker.
+func (s *SyncTask) skipMarker(key s3.Key) bool {
+	if !IsMarker(key) {
+		return false
+	}
+	return s.Markers == MarkersSkip || s.DestKey(key.Key) == "" || s.DestKey(key.Key) == "/"
+}
+
+// madeMarkers are the markers known at the destination of a task that
+// synthesizes them, by name, so that each is made once.
+type madeMarkers struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// claim the name of a marker, true if it wasn't already.
+func (m *madeMarkers) claim(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.names == nil {
+		m.names = make(map[string]bool)
+	}
+	if m.names[name] {
+		return false
+	}
+	m.names[name] = true
+	return true
+}
+
+// release the name of a marker that couldn't be made, to try again with
+// the next key under it.
+func (m *madeMarkers) release(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.names, name)
+}
+
+// parents of a name at the destination, the shortest first: a/ and a/b/
+// for a/b/c and for a/b/c/.
+func parents(name string) []string {
+	var prefixes []string
+	for i := 0; i < len(name)-1; i++ {
+		if name[i] == '/' && i > 0 {
+			prefixes = append(prefixes, name[:i+1])
+		}
+	}
+	return prefixes
+}
+
+// synthesizeMarkers makes the markers missing at the destination for the
+// prefixes of a key synced to it, once each. A marker that can't be made
+// is logged, and tried again with the next key under it.
+func (s *SyncTask) synthesizeMarkers(dst *s3.Bucket, key s3.Key) {
+	name := s.DestKey(key.Key)
+	if IsMarker(key) {
+		// the marker itself was just synced
+		s.made.claim(name)
+	}
+	for _, prefix := range parents(name) {
+		if !s.made.claim(prefix) {
+			continue
+		}
+		created, err := makeMarker(dst, prefix)
+		if err != nil {
+			s.made.release(prefix)
+			logrus.WithFields(logrus.Fields{
+				"key":    key.Key,
+				"marker": prefix,
+				"error":  err,
+			}).Warn("couldn't make directory marker")
+			continue
+		}
+		if created {
+			metrics.markersCreated.Add(1)
+			s.stats.markersCreated.Add(1)
+		}
+	}
+}
+
+// makeMarker puts an empty object at name unless there's already one, and
+// tells if it did.
+func makeMarker(dst *s3.Bucket, name string) (bool, error) {
+	resp, err := dst.Head(name, nil)
+	switch e := err.(type) {
+	case nil:
+		_ = resp.Body.Close()
+		return false, nil
+	case *s3.Error:
+		if e.StatusCode != http.StatusNotFound {
+			return false, err
+		}
+	default:
+		return false, err
+	}
+	if err := dst.Put(name, nil, MarkerContentType, s3.Private, s3.Options{}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,100 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncMarkers(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	if err := src.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	var keys []s3.Key
+	for _, name := range []string{"a/", "a/b/c", "a/d/e/f", "empty/", "g"} {
+		var data []byte
+		if !strings.HasSuffix(name, "/") {
+			data = []byte(name)
+		}
+		if err := src.Put(name, data, "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name, Size: int64(len(data))})
+	}
+	if !sync.IsMarker(keys[0]) || sync.IsMarker(keys[1]) || sync.IsMarker(s3.Key{Key: "full/", Size: 1}) {
+		t.Fatalf("want only the empty keys ending with a / as markers")
+	}
+
+	run := func(dstName string, opts ...sync.Option) (sync.Progress, []string) {
+		dst := mocks3.S3().Bucket(dstName)
+		if err := dst.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+		task, err := sync.NewSyncTask(src, dst, append(opts, sync.WithConcurrency(1))...)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		var synced, failed bytes.Buffer
+		if err := task.Start(encodeKeys(keys), &synced, &failed); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		var names []string
+		for name := range mocks3.ListBuckets()[dstName].Objects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return task.Progress(), names
+	}
+
+	p, names := run("copied")
+	if p.Synced != 3 || p.Markers != 2 || p.MarkersSkipped != 0 || p.Skipped != 0 {
+		t.Errorf("want the markers counted apart, got %+v", p)
+	}
+	if got := strings.Join(names, " "); got != "a/ a/b/c a/d/e/f empty/ g" {
+		t.Errorf("want the markers copied, got %s", got)
+	}
+
+	p, names = run("skipped", sync.WithMarkers(sync.MarkersSkip))
+	if p.Synced != 3 || p.Markers != 0 || p.MarkersSkipped != 2 || p.Skipped != 0 {
+		t.Errorf("want the markers skipped, got %+v", p)
+	}
+	if got := strings.Join(names, " "); got != "a/b/c a/d/e/f g" {
+		t.Errorf("want the markers left out, got %s", got)
+	}
+
+	p, names = run("synthesized", sync.WithMarkers(sync.MarkersSynthesize))
+	if p.Synced != 3 || p.Markers != 2 || p.MarkersCreated != 3 {
+		t.Errorf("want the missing markers made, got %+v", p)
+	}
+	if got := strings.Join(names, " "); got != "a/ a/b/ a/b/c a/d/ a/d/e/ a/d/e/f empty/ g" {
+		t.Errorf("want a marker for each prefix, got %s", got)
+	}
+	obj := mocks3.ListBuckets()["synthesized"].Objects["a/d/"]
+	if ct := obj.Meta.Get("Content-Type"); len(obj.Data) != 0 || ct != sync.MarkerContentType {
+		t.Errorf("want an empty marker, got %d bytes of %q", len(obj.Data), ct)
+	}
+
+	// a marker mapped to the root of the destination has nothing to copy
+	p, names = run("mapped", sync.WithMapping(sync.Mapping{From: "a/", To: ""}), sync.WithCopier(sync.PutCopy))
+	if p.Markers != 1 || p.MarkersSkipped != 1 {
+		t.Errorf("want the marker of the mapped prefix skipped, got %+v", p)
+	}
+	if got := strings.Join(names, " "); got != "b/c d/e/f empty/ g" {
+		t.Errorf("want the keys mapped, got %s", got)
+	}
+
+	if _, err := sync.NewSyncTask(src, src, sync.WithMarkers("keep")); err == nil {
+		t.Errorf("want an error for an unknown policy")
+	}
+}
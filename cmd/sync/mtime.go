@@ -0,0 +1,101 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SourceMTimeMeta is the user metadata in which the Last-Modified time of
+// the source of a key is stamped, sent as x-amz-meta-src-mtime.
+const SourceMTimeMeta = "src-mtime"
+
+// PreserveMTime stamps the Last-Modified time of the source of the keys
+// into their metadata at the destination, since S3 sets the Last-Modified of
+// a copy to the time it was copied. Stamping replaces the metadata of the
+// copies, so the metadata of the source is read with a HEAD and sent along.
+var PreserveMTime = false
+
+// SourceMTime is the Last-Modified time of the source of an object, given
+// the headers of a HEAD or GET on it: the time stamped in its metadata if
+// any, else its own Last-Modified time.
+func SourceMTime(h http.Header) (time.Time, error) {
+	if stamp := h.Get("x-amz-meta-" + SourceMTimeMeta); stamp != "" {
+		t, err := time.Parse(time.RFC3339, stamp)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad %s metadata %q: %v", SourceMTimeMeta, stamp, err)
+		}
+		return t, nil
+	}
+	lastModified := h.Get("Last-Modified")
+	t, err := http.ParseTime(lastModified)
+	if err != nil {
+		if t, err = time.Parse(time.RFC1123, lastModified); err != nil {
+			return time.Time{}, fmt.Errorf("bad Last-Modified %q: %v", lastModified, err)
+		}
+	}
+	return t, nil
+}
+
+// stampedCopyOptions are the options to copy a key from src, replacing its
+// metadata by the one of the source, stamped with its Last-Modified time.
+func stampedCopyOptions(src *s3.Bucket, key s3.Key) (s3.CopyOptions, error) {
+	var headers map[string][]string
+	if src.RequesterPays {
+		headers = map[string][]string{"x-amz-request-payer": {"requester"}}
+	}
+	resp, err := src.Head(key.Key, headers)
+	if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+		// HEAD responses have no body, so the error has no code
+		e.Code = s3.ErrNoSuchKey
+		return s3.CopyOptions{}, e
+	}
+	if err != nil {
+		return s3.CopyOptions{}, err
+	}
+	_ = resp.Body.Close()
+	opts, err := stampedOptions(resp.Header, key)
+	if err != nil {
+		return s3.CopyOptions{}, err
+	}
+	return s3.CopyOptions{
+		Options:               opts,
+		MetadataDirective:     "REPLACE",
+		ContentType:           resp.Header.Get("Content-Type"),
+		RequesterPays:         src.RequesterPays,
+		CopySourceCustomerKey: src.CustomerKey,
+	}, nil
+}
+
+// stampedOptions are the options to write a key with the metadata of its
+// source, given the headers of a HEAD or GET on it, stamped with the source's
+// Last-Modified time. The website redirect is part of the metadata, so it
+// doesn't need RedirectForKey.
+func stampedOptions(h http.Header, key s3.Key) (s3.Options, error) {
+	mtime, err := SourceMTime(h)
+	if err != nil && key.LastModified != "" {
+		// fall back on the time the key was listed with
+		mtime, err = time.Parse(time.RFC3339, key.LastModified)
+	}
+	if err != nil {
+		return s3.Options{}, fmt.Errorf("can't tell when %q was modified: %v", key.Key, err)
+	}
+	opts := s3.Options{
+		SSE:                h.Get("x-amz-server-side-encryption") == "AES256",
+		Meta:               map[string][]string{},
+		ContentEncoding:    h.Get("Content-Encoding"),
+		CacheControl:       h.Get("Cache-Control"),
+		ContentDisposition: h.Get("Content-Disposition"),
+		RedirectLocation:   h.Get("x-amz-website-redirect-location"),
+	}
+	for name, values := range h {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-meta-") {
+			opts.Meta[strings.TrimPrefix(name, "x-amz-meta-")] = values
+		}
+	}
+	opts.Meta[SourceMTimeMeta] = []string{mtime.UTC().Format(time.RFC3339)}
+	return opts, nil
+}
@@ -0,0 +1,69 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPreserveMTime(t *testing.T) {
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	opts := s3.Options{Meta: map[string][]string{"owner": {"ops"}}}
+	if err := src.Put("a", []byte("a"), "text/plain", s3.Private, opts); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	mtime := mocks3.ListBuckets()["src-bucket"].Objects["a"].Mtime.UTC().Format(time.RFC3339)
+
+	sync.PreserveMTime = true
+	defer func() { sync.PreserveMTime = false }()
+	time.Sleep(time.Second) // so that copies are modified at a later second
+
+	key := s3.Key{Key: "a"}
+	for name, copier := range map[string]sync.CopyFunc{"copy": sync.PutCopy, "get-put": sync.GetPut} {
+		if err := copier(src, dst, key, name); err != nil {
+			t.Fatalf("%s: can't copy key: %v", name, err)
+		}
+		meta := mocks3.ListBuckets()["dst-bucket"].Objects[name].Meta
+		if got := meta.Get("x-amz-meta-src-mtime"); got != mtime {
+			t.Errorf("%s: want the source's mtime %q stamped, got %q", name, mtime, got)
+		}
+		if got := meta.Get("x-amz-meta-owner"); got != "ops" {
+			t.Errorf("%s: want the source's metadata kept, got owner %q", name, got)
+		}
+		if got := meta.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("%s: want the source's content type kept, got %q", name, got)
+		}
+	}
+
+	// copies of copies keep the mtime of the original
+	if err := sync.PutCopy(dst, dst, s3.Key{Key: "copy"}, "copy-of-copy"); err != nil {
+		t.Fatalf("can't copy copy: %v", err)
+	}
+	resp, err := dst.Head("copy-of-copy", nil)
+	if err != nil {
+		t.Fatalf("can't head copy: %v", err)
+	}
+	_ = resp.Body.Close()
+	got, err := sync.SourceMTime(resp.Header)
+	if err != nil || got.UTC().Format(time.RFC3339) != mtime {
+		t.Errorf("want the original mtime %q, got %v, %v", mtime, got, err)
+	}
+
+	// without a stamp, the source mtime is the object's own
+	h := http.Header{"Last-Modified": {"Sat, 02 Jan 2016 15:04:05 GMT"}}
+	if got, err := sync.SourceMTime(h); err != nil || !got.Equal(time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Errorf("want the Last-Modified time, got %v, %v", got, err)
+	}
+}
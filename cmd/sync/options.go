@@ -0,0 +1,533 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/state"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Option configures a SyncTask when it's created by NewSyncTask, which fails
+// if the options are invalid, alone or together.
+type Option func(*SyncTask) error
+
+// WithRetry tries to sync each key up to max times, waiting base, then
+// twice as long and so on between the attempts.
+func WithRetry(max int, base time.Duration) Option {
+	return func(s *SyncTask) error {
+		if max < 1 || base <= 0 {
+			return fmt.Errorf("need a positive retry base and at least 1 attempt, got %v and %d", base, max)
+		}
+		s.MaxRetry, s.RetryBase = max, base
+		return nil
+	}
+}
+
+// WithConcurrency syncs n keys at a time.
+func WithConcurrency(n int) Option {
+	return func(s *SyncTask) error {
+		if n < 1 {
+			return fmt.Errorf("need at least 1 sync worker, got %d", n)
+		}
+		s.SyncPara = n
+		return nil
+	}
+}
+
+// WithDecoders decodes the keys of JSON listings with n workers.
+func WithDecoders(n int) Option {
+	return func(s *SyncTask) error {
+		if n < 1 {
+			return fmt.Errorf("need at least 1 decoder, got %d", n)
+		}
+		s.DecodePara = n
+		return nil
+	}
+}
+
+// WithMaxDecoders lets the pool of decoders grow up to n decoders when
+// lines wait to be decoded, or stay at the size of WithDecoders when n isn't
+// above it.
+func WithMaxDecoders(n int) Option {
+	return func(s *SyncTask) error {
+		if n < 1 {
+			return fmt.Errorf("need at least 1 decoder, got %d", n)
+		}
+		s.DecodeMax = n
+		return nil
+	}
+}
+
+// WithSyncer syncs the keys with syncer, which can't rename them, unlike
+// the copier of WithCopier.
+func WithSyncer(syncer SyncerFunc) Option {
+	return func(s *SyncTask) error {
+		if syncer == nil {
+			return errors.New("need a syncer")
+		}
+		s.Sync, s.SyncContext, s.renaming = syncer, nil, false
+		return nil
+	}
+}
+
+// WithContextSyncer syncs the keys with syncer, which is given the context
+// and metadata of each attempt, and can't rename the keys, unlike the copier
+// of WithCopier.
+func WithContextSyncer(syncer ContextSyncerFunc) Option {
+	return func(s *SyncTask) error {
+		if syncer == nil {
+			return errors.New("need a syncer")
+		}
+		s.SyncContext, s.renaming = syncer, false
+		return nil
+	}
+}
+
+// WithCopier syncs the keys with copier, copying them to their DestKey.
+func WithCopier(copier CopyFunc) Option {
+	return func(s *SyncTask) error {
+		if copier == nil {
+			return errors.New("need a copier")
+		}
+		s.Sync, s.SyncContext, s.renaming = Renamed(copier, s.DestKey), nil, true
+		return nil
+	}
+}
+
+// WithFilter only syncs the keys that keep says to keep, the others are
+// skipped.
+func WithFilter(keep func(s3.Key) bool) Option {
+	return func(s *SyncTask) error {
+		s.Filter = keep
+		return nil
+	}
+}
+
+// WithFaults injects faults in the sync calls of the task, whichever its
+// syncer, see InjectFaults.
+func WithFaults(f Faults) Option {
+	return func(s *SyncTask) error {
+		s.faults = &f
+		return nil
+	}
+}
+
+// WithTimeout gives up on the sync calls that take longer than timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *SyncTask) error {
+		if timeout < 0 {
+			return fmt.Errorf("timeout can't be negative, got %v", timeout)
+		}
+		s.Timeout = timeout
+		return nil
+	}
+}
+
+// WithState records the status of the keys in store, skipping the keys it
+// knows as synced.
+func WithState(store *state.Store) Option {
+	return func(s *SyncTask) error {
+		s.State = store
+		return nil
+	}
+}
+
+// WithMaxFailures stops the task once more than max keys failed, or a
+// larger fraction than rate, when they're not 0.
+func WithMaxFailures(max int64, rate float64) Option {
+	return func(s *SyncTask) error {
+		if max < 0 || rate < 0 || rate > 1 {
+			return fmt.Errorf("need at least 0 failures and a rate in [0, 1], got %d and %v", max, rate)
+		}
+		s.MaxFailures, s.MaxFailureRate = max, rate
+		return nil
+	}
+}
+
+// WithBreaker pauses the task when too many keys fail, see Breaker.
+func WithBreaker(b Breaker) Option {
+	return func(s *SyncTask) error {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+		s.Breaker = &b
+		return nil
+	}
+}
+
+// WithRetryBudget caps the retries of the whole task, see RetryBudget.
+func WithRetryBudget(b RetryBudget) Option {
+	return func(s *SyncTask) error {
+		if err := b.Validate(); err != nil {
+			return err
+		}
+		s.RetryBudget = &b
+		return nil
+	}
+}
+
+// WithHedging hedges the slowest sync calls, see Hedging.
+func WithHedging(h Hedging) Option {
+	return func(s *SyncTask) error {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+		s.Hedging = &h
+		return nil
+	}
+}
+
+// WithCallBudget shares a budget of sync calls in flight with other tasks,
+// see CallBudget.
+func WithCallBudget(b *CallBudget) Option {
+	return func(s *SyncTask) error {
+		s.CallBudget = b
+		return nil
+	}
+}
+
+// WithPrefixRates caps the rate of the writes to each prefix of the
+// destination, see PrefixRates.
+func WithPrefixRates(r PrefixRates) Option {
+	return func(s *SyncTask) error {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		s.PrefixRates = &r
+		return nil
+	}
+}
+
+// WithSchedule caps the sync calls by the time of day, see Schedule.
+func WithSchedule(sched Schedule) Option {
+	return func(s *SyncTask) error {
+		if err := sched.Validate(); err != nil {
+			return err
+		}
+		s.Schedule = &sched
+		return nil
+	}
+}
+
+// WithWatchdog acts on the task when it stalls, see Watchdog.
+func WithWatchdog(w Watchdog) Option {
+	return func(s *SyncTask) error {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+		s.Watchdog = &w
+		return nil
+	}
+}
+
+// WithParking parks the keys that exhausted their retries, see Parking.
+func WithParking(p Parking) Option {
+	return func(s *SyncTask) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		s.Parking = &p
+		return nil
+	}
+}
+
+// WithRetryWorkers retries the keys whose first sync call failed with n
+// workers of their own, see RetryPara.
+func WithRetryWorkers(n int) Option {
+	return func(s *SyncTask) error {
+		if n < 1 {
+			return fmt.Errorf("need at least 1 retry worker, got %d", n)
+		}
+		s.RetryPara = n
+		return nil
+	}
+}
+
+// WithAnomalies warns when the sync calls deviate from their baseline, see
+// Anomalies.
+func WithAnomalies(a Anomalies) Option {
+	return func(s *SyncTask) error {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+		s.Anomalies = &a
+		return nil
+	}
+}
+
+// WithSlowKeys warns about the keys that take long to sync, see SlowKeys.
+func WithSlowKeys(k SlowKeys) Option {
+	return func(s *SyncTask) error {
+		if err := k.Validate(); err != nil {
+			return err
+		}
+		s.SlowKeys = &k
+		return nil
+	}
+}
+
+// WithEvents sends an event to sink for each key synced or failed.
+func WithEvents(sink events.Sink) Option {
+	return func(s *SyncTask) error {
+		s.Events = sink
+		return nil
+	}
+}
+
+// WithExisting decides what to do with the keys that already exist at the
+// destination, see Existing.
+func WithExisting(e Existing) Option {
+	return func(s *SyncTask) error {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+		s.Existing = &e
+		return nil
+	}
+}
+
+// WithConditional tells that the copier makes conditional copies, such as
+// ConditionalCopy.
+func WithConditional() Option {
+	return func(s *SyncTask) error {
+		s.Conditional = true
+		return nil
+	}
+}
+
+// WithMapping renames the keys at the destination, see Mapping.
+func WithMapping(m Mapping) Option {
+	return func(s *SyncTask) error {
+		s.Mapping = m
+		return nil
+	}
+}
+
+// WithOutputFormat writes the synced and failed keys with an encoder, a
+// listing format or another one registered, see listing.NewEncoder.
+func WithOutputFormat(format string) Option {
+	return func(s *SyncTask) error {
+		if _, err := listing.NewEncoder(ioutil.Discard, format); err != nil {
+			return err
+		}
+		s.OutputFormat = format
+		return nil
+	}
+}
+
+// WithFailureFormat writes the failed keys with another encoder than the
+// synced ones.
+func WithFailureFormat(format string) Option {
+	return func(s *SyncTask) error {
+		if _, err := listing.NewEncoder(ioutil.Discard, format); err != nil {
+			return err
+		}
+		s.FailureFormat = format
+		return nil
+	}
+}
+
+// WithProgress measures the throughput of the task every interval, logging
+// its progress if log is set.
+func WithProgress(every time.Duration, log bool) Option {
+	return func(s *SyncTask) error {
+		if every <= 0 {
+			return fmt.Errorf("progress interval must be positive, got %v", every)
+		}
+		s.ProgressEvery = every
+		s.LogProgress = log
+		return nil
+	}
+}
+
+// WithInterleave sends the keys to the sync workers round-robin over
+// their top-level prefixes, picking from window keys held at once.
+func WithInterleave(window int) Option {
+	return func(s *SyncTask) error {
+		if window < 1 {
+			return fmt.Errorf("interleave window must be at least 1 key, got %d", window)
+		}
+		s.Interleave = window
+		return nil
+	}
+}
+
+// WithOrderedOutput writes the synced keys in the order of the listing,
+// holding at most window keys, see OrderWindow.
+func WithOrderedOutput(window int) Option {
+	return func(s *SyncTask) error {
+		if window < 1 {
+			return fmt.Errorf("order window must be at least 1 key, got %d", window)
+		}
+		s.OrderWindow = window
+		return nil
+	}
+}
+
+// WithInputs counts the outcome of the keys in the files of the input they
+// came from, the task reading the Inputs.
+func WithInputs(in *Inputs) Option {
+	return func(s *SyncTask) error {
+		s.inputs = in
+		return nil
+	}
+}
+
+// WithRunID attaches the ID of the run to the records of the task, see
+// RunID.
+func WithRunID(id string) Option {
+	return func(s *SyncTask) error {
+		s.RunID = id
+		return nil
+	}
+}
+
+// WithMarkers sets what the task does with directory markers, see Markers.
+func WithMarkers(policy string) Option {
+	return func(s *SyncTask) error {
+		if err := ValidateMarkers(policy); err != nil {
+			return err
+		}
+		s.Markers = policy
+		return nil
+	}
+}
+
+// WithPriorities syncs the keys of some prefixes first, see Priorities.
+func WithPriorities(p Priorities) Option {
+	return func(s *SyncTask) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		s.Priorities = &p
+		return nil
+	}
+}
+
+// WithQueueDir queues the decoded keys to disk in dir on their way to the
+// sync workers, see QueueDir.
+func WithQueueDir(dir string) Option {
+	return func(s *SyncTask) error {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("queue dir: %v", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("queue dir %q is not a directory", dir)
+		}
+		s.QueueDir = dir
+		return nil
+	}
+}
+
+// WithSpillDir spills the keys for the outputs to temporary files in dir
+// when the outputs fall behind.
+func WithSpillDir(dir string) Option {
+	return func(s *SyncTask) error {
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("spill dir: %v", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("spill dir %q is not a directory", dir)
+		}
+		s.SpillDir = dir
+		return nil
+	}
+}
+
+// WithVerifySample picks a fraction of the synced keys to be checked by
+// Verify.
+func WithVerifySample(fraction float64) Option {
+	return func(s *SyncTask) error {
+		if fraction < 0 || fraction > 1 {
+			return fmt.Errorf("verification sample must be in [0, 1], got %v", fraction)
+		}
+		s.VerifySample = fraction
+		return nil
+	}
+}
+
+// WithChecksum compares the content of the keys checked by Verify whose
+// ETags can't tell with a checksum.
+func WithChecksum(c checksum.Checksum) Option {
+	return func(s *SyncTask) error {
+		if c.Hash == nil {
+			return fmt.Errorf("checksum needs a hash")
+		}
+		s.Checksum = &c
+		return nil
+	}
+}
+
+// WithReconcile counts the keys expected at the destination by prefix, to
+// be checked by ReconcileCounts.
+func WithReconcile(r Reconcile) Option {
+	return func(s *SyncTask) error {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		s.Reconcile = &r
+		return nil
+	}
+}
+
+// WithDedupe tracks the content of the keys synced, to be reported by
+// DedupeKeys.
+func WithDedupe(d Dedupe) Option {
+	return func(s *SyncTask) error {
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		s.Dedupe = &d
+		return nil
+	}
+}
+
+// WithMalformed handles the malformed lines of the input, see Malformed.
+func WithMalformed(m Malformed) Option {
+	return func(s *SyncTask) error {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		s.Malformed = &m
+		return nil
+	}
+}
+
+// WithHook runs a command for each key synced, see Hook.
+func WithHook(h Hook) Option {
+	return func(s *SyncTask) error {
+		if err := h.Validate(); err != nil {
+			return err
+		}
+		s.Hook = &h
+		return nil
+	}
+}
+
+// WithAudit records the outcome of each key in log.
+func WithAudit(log *AuditLog) Option {
+	return func(s *SyncTask) error {
+		s.Audit = log
+		return nil
+	}
+}
+
+// validate the combination of the options of the task.
+func (s *SyncTask) validate() error {
+	renames := s.Mapping != (Mapping{}) || s.Existing != nil && s.Existing.Policy == CollideRename
+	if renames && !s.renaming {
+		return errors.New("keys are renamed at the destination, which needs a copier, see WithCopier")
+	}
+	if s.Conditional && !s.renaming {
+		return errors.New("conditional copies need a copier, such as ConditionalCopy")
+	}
+	return nil
+}
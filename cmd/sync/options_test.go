@@ -0,0 +1,97 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSyncTaskOptions(t *testing.T) {
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	for name, opts := range map[string][]sync.Option{
+		"no workers":    {sync.WithConcurrency(0)},
+		"no attempt":    {sync.WithRetry(0, time.Second)},
+		"no decoders":   {sync.WithMaxDecoders(0)},
+		"no syncer":     {sync.WithSyncer(nil)},
+		"bad format":    {sync.WithOutputFormat("xml")},
+		"bad failures":  {sync.WithFailureFormat("xml")},
+		"bad existing":  {sync.WithExisting(sync.Existing{Policy: "merge"})},
+		"bad breaker":   {sync.WithBreaker(sync.Breaker{})},
+		"bad sample":    {sync.WithVerifySample(2)},
+		"rename syncer": {sync.WithMapping(sync.Mapping{From: "a/", To: "b/"}), sync.WithSyncer(sync.PutCopySyncer)},
+		"conditional":   {sync.WithConditional()},
+	} {
+		if _, err := sync.NewSyncTask(src, dst, opts...); err == nil {
+			t.Errorf("%s: want an invalid task", name)
+		}
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(3),
+		sync.WithRetry(2, time.Millisecond),
+		sync.WithMapping(sync.Mapping{From: "a/", To: "b/"}),
+		sync.WithCopier(sync.PutCopy),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if syncTask.SyncPara != 3 || syncTask.MaxRetry != 2 || syncTask.RetryBase != time.Millisecond {
+		t.Errorf("want the options set, got %+v", syncTask)
+	}
+}
+
+func TestSyncFilter(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, key := range []string{"logs/a", "data/b", "logs/c"} {
+		if err := src.Put(key, []byte(key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+		keys = append(keys, s3.Key{Key: key})
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(2),
+		sync.WithFilter(func(key s3.Key) bool { return !strings.HasPrefix(key.Key, "logs/") }),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	var synced bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	got := decodeKeys(&synced)
+	if len(got) != 1 || got[0].Key != "data/b" {
+		t.Errorf("want only data/b synced, got %v", got)
+	}
+	if skipped := syncTask.Progress().Skipped; skipped != 2 {
+		t.Errorf("want the 2 filtered keys skipped, got %d", skipped)
+	}
+}
@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"sync"
+	"time"
+)
+
+// Parking parks the keys that exhausted their retries on a retriable error,
+// instead of failing them, and retries them once more at the end of the
+// task: many outages of S3 are over within the lifetime of a big sync, and
+// the keys that failed during one would otherwise need another sync.
+type Parking struct {
+	// Delay is how long a key stays parked, at least. Keys parked near the
+	// end of the task hold it for that long.
+	Delay time.Duration
+	// Max is how many keys can be parked, the keys that exhaust their
+	// retries once the lot is full fail right away.
+	Max int
+}
+
+// Validate checks that keys can be parked.
+func (p Parking) Validate() error {
+	switch {
+	case p.Delay < 0:
+		return fmt.Errorf("parking delay can't be negative, got %v", p.Delay)
+	case p.Max < 1:
+		return fmt.Errorf("need room for at least 1 parked key, got %d", p.Max)
+	}
+	return nil
+}
+
+type parkedKey struct {
+	key s3.Key
+	// when the key can be retried
+	due time.Time
+}
+
+// parkingLot holds the parked keys, in the order they were parked.
+type parkingLot struct {
+	Parking
+
+	mu   sync.Mutex
+	keys []parkedKey
+}
+
+// park a key, false if the lot is full.
+func (p *parkingLot) park(key s3.Key) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.keys) >= p.Max {
+		return false
+	}
+	p.keys = append(p.keys, parkedKey{key: key, due: time.Now().Add(p.Delay)})
+	return true
+}
+
+// take all the parked keys.
+func (p *parkingLot) take() []parkedKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := p.keys
+	p.keys = nil
+	return keys
+}
+
+// park a key that failed with err, if the task parks keys and the error
+// could go away.
+func (s *SyncTask) park(key s3.Key, err error) bool {
+	if s.parking == nil || err == ErrCollision || err == ErrExists || s.isConflict(err) || !retriable(err) {
+		return false
+	}
+	if !s.parking.park(key) {
+		logrus.WithFields(logrus.Fields{
+			"key": key.Key,
+			"max": s.parking.Max,
+		}).Warn("parking lot is full, failing key")
+		return false
+	}
+	metrics.parked.Add(1)
+	s.stats.parked.Add(1)
+	logrus.WithFields(logrus.Fields{
+		"key":   key.Key,
+		"error": err,
+		"delay": s.parking.Delay,
+	}).Warn("key exhausted its retries, parking it to retry it at the end of the sync")
+	return true
+}
+
+// redrive syncs the parked keys once more, each once its delay is over,
+// with as many workers as the task. Keys that fail again aren't parked.
+func (s *SyncTask) redrive(synced chan<- s3.Key, failed chan<- listing.Failure) {
+	if s.parking == nil {
+		return
+	}
+	parked := s.parking.take()
+	if len(parked) == 0 {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"parked": len(parked),
+		"due_in": parked[0].due.Sub(time.Now()),
+	}).Info("retrying the parked keys")
+	keys := make(chan parkedKey)
+	var workers pipeline.Workers
+	workers.Start(s.SyncPara, func(i int) {
+		for p := range keys {
+			if wait := p.due.Sub(time.Now()); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-s.ctl.done:
+				}
+			}
+			s.stats.busy.Add(1)
+			s.syncGuarded(i, s.src, s.dst, p.key, true, synced, failed)
+			s.stats.busy.Add(-1)
+			metrics.redriven.Add(1)
+			s.stats.parked.Add(-1)
+		}
+	})
+	for _, p := range parked {
+		keys <- p
+	}
+	close(keys)
+	workers.Wait()
+}
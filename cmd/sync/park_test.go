@@ -0,0 +1,80 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSyncParking(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for i := 0; i < 5; i++ {
+		name := "key-" + strconv.Itoa(i)
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+
+	for _, tt := range []struct {
+		max            int
+		synced, failed int64
+	}{
+		{max: 10, synced: 5, failed: 0},
+		// the keys that don't fit in the lot fail right away
+		{max: 2, synced: 2, failed: 3},
+	} {
+		// the destination is down for a while, longer than the retries
+		// of the keys, but shorter than the parking delay
+		mocks3.SetBehavior(s3mock.Behavior{
+			Fail: s3mock.FailMethod("PUT", s3.Error{StatusCode: http.StatusServiceUnavailable, Code: "ServiceUnavailable", Message: "Please reduce your request rate."}),
+		})
+		recovered := time.AfterFunc(200*time.Millisecond, func() { mocks3.SetBehavior(s3mock.Behavior{}) })
+
+		syncTask, err := sync.NewSyncTask(src, dst,
+			sync.WithConcurrency(1),
+			sync.WithRetry(3, time.Millisecond),
+			sync.WithParking(sync.Parking{Delay: 400 * time.Millisecond, Max: tt.max}),
+		)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		recovered.Stop()
+		p := syncTask.Progress()
+		if p.Synced != tt.synced || p.Failed != tt.failed || p.Parked != 0 {
+			t.Errorf("max %d: want %d keys synced and %d failed once parked keys are retried, got %+v", tt.max, tt.synced, tt.failed, p)
+		}
+		for _, key := range keys {
+			_ = dst.Del(key.Key)
+		}
+	}
+
+	for _, bad := range []sync.Parking{
+		{Delay: -time.Second, Max: 10},
+		{Delay: time.Second, Max: 0},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
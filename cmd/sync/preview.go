@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/aybabtme/humanize"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// maxPreviewPrefixes is how many pairs of prefixes a MappingPreview counts
+// the keys of, the keys of the others are counted together.
+const maxPreviewPrefixes = 1000
+
+// MappedKey is the name of a key of the source, and the name it's synced
+// under at the destination.
+type MappedKey struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+}
+
+// PrefixMapping counts the keys of a prefix of the source that are synced
+// under a prefix of the destination.
+type PrefixMapping struct {
+	Src   string `json:"src"`
+	Dest  string `json:"dest"`
+	Keys  int64  `json:"keys"`
+	Bytes int64  `json:"bytes"`
+}
+
+// MappingPreview is what PreviewMapping found the mapping of the keys of a
+// listing would do to them.
+type MappingPreview struct {
+	Mapping Mapping `json:"mapping"`
+	// Samples are the first keys of the listing and their new names.
+	Samples []MappedKey `json:"samples"`
+	Keys    int64       `json:"keys"`
+	Bytes   int64       `json:"bytes"`
+	// Moved keys are under the From prefix of the mapping, Normalized keys
+	// have a name that normalizing changed, and Unchanged keys keep their
+	// name.
+	Moved      int64 `json:"moved"`
+	Normalized int64 `json:"normalized"`
+	Unchanged  int64 `json:"unchanged"`
+	// Prefixes count the keys by their prefix at the source and at the
+	// destination, the most keys first. Past maxPreviewPrefixes pairs, the
+	// keys are counted in OtherKeys and OtherBytes instead.
+	Prefixes   []PrefixMapping `json:"prefixes"`
+	OtherKeys  int64           `json:"other_keys"`
+	OtherBytes int64           `json:"other_bytes"`
+}
+
+// Suspicious is true when the mapping moves keys, but none of the keys of
+// the listing are under its From prefix, which is likely a typo.
+func (p MappingPreview) Suspicious() bool {
+	return p.Mapping.From != p.Mapping.To && p.Keys > 0 && p.Moved == 0
+}
+
+// PreviewMapping reads the keys of a listing, in any format, and tells the
+// names the mapping gives them at the destination: those of the first
+// samples keys, and the counts of the keys by their prefixes depth parts
+// deep, before and after the mapping. It reads the whole listing, so that
+// a mapping that misses the keys deep in the listing shows.
+func PreviewMapping(r io.Reader, m Mapping, samples, depth int) (MappingPreview, error) {
+	preview := MappingPreview{Mapping: m}
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		return preview, err
+	}
+	moved := Mapping{From: m.From, To: m.To}
+	type prefixes struct{ src, dest string }
+	counts := make(map[prefixes]*PrefixMapping)
+	var key s3.Key
+	for {
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			for _, c := range counts {
+				preview.Prefixes = append(preview.Prefixes, *c)
+			}
+			sort.Sort(byKeys(preview.Prefixes))
+			return preview, nil
+		case nil:
+		default:
+			return preview, err
+		}
+
+		name := moved.Map(key.Key)
+		dest := name
+		if m.Normalize {
+			dest = m.Map(key.Key)
+		}
+		preview.Keys++
+		preview.Bytes += key.Size
+		switch {
+		case name != key.Key:
+			preview.Moved++
+		case dest != key.Key:
+			preview.Normalized++
+		default:
+			preview.Unchanged++
+		}
+		if len(preview.Samples) < samples {
+			preview.Samples = append(preview.Samples, MappedKey{Src: key.Key, Dest: dest})
+		}
+
+		pair := prefixes{src: prefixAt(key.Key, depth), dest: prefixAt(dest, depth)}
+		c, ok := counts[pair]
+		if !ok && len(counts) >= maxPreviewPrefixes {
+			preview.OtherKeys++
+			preview.OtherBytes += key.Size
+			continue
+		}
+		if !ok {
+			c = &PrefixMapping{Src: pair.src, Dest: pair.dest}
+			counts[pair] = c
+		}
+		c.Keys++
+		c.Bytes += key.Size
+	}
+}
+
+// byKeys sorts the prefixes by their keys, the most first, then by name.
+type byKeys []PrefixMapping
+
+func (b byKeys) Len() int      { return len(b) }
+func (b byKeys) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byKeys) Less(i, j int) bool {
+	if b[i].Keys != b[j].Keys {
+		return b[i].Keys > b[j].Keys
+	}
+	if b[i].Src != b[j].Src {
+		return b[i].Src < b[j].Src
+	}
+	return b[i].Dest < b[j].Dest
+}
+
+// String formats the preview as blocks of lines, for people to read: the
+// sampled keys, the counts of the keys and the prefixes they map to.
+func (p MappingPreview) String() string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	for _, k := range p.Samples {
+		fmt.Fprintf(tw, "%q\t-> %q\n", k.Src, k.Dest)
+	}
+	_ = tw.Flush()
+	if len(p.Samples) > 0 {
+		buf.WriteString("\n")
+	}
+
+	tw = tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "keys:\t%s (%s)\n", humanize.Comma(p.Keys), humanize.Bytes(uint64(p.Bytes)))
+	fmt.Fprintf(tw, "moved:\t%s\n", humanize.Comma(p.Moved))
+	if p.Mapping.Normalize {
+		fmt.Fprintf(tw, "normalized:\t%s\n", humanize.Comma(p.Normalized))
+	}
+	fmt.Fprintf(tw, "unchanged:\t%s\n", humanize.Comma(p.Unchanged))
+	_ = tw.Flush()
+	buf.WriteString("\n")
+
+	tw = tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "source prefix\tdestination prefix\tkeys\tbytes")
+	for _, c := range p.Prefixes {
+		fmt.Fprintf(tw, "%q\t%q\t%s\t%s\n", c.Src, c.Dest, humanize.Comma(c.Keys), humanize.Bytes(uint64(c.Bytes)))
+	}
+	if p.OtherKeys > 0 {
+		fmt.Fprintf(tw, "(others)\t\t%s\t%s\n", humanize.Comma(p.OtherKeys), humanize.Bytes(uint64(p.OtherBytes)))
+	}
+	_ = tw.Flush()
+	return buf.String()
+}
@@ -0,0 +1,61 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreviewMapping(t *testing.T) {
+	keys := []s3.Key{
+		{Key: "legacy/a/1", Size: 1},
+		{Key: "legacy/a/2 ", Size: 2},
+		{Key: "legacy/b/1", Size: 3},
+		{Key: "other/1", Size: 4},
+		{Key: "other/2\x01", Size: 5},
+	}
+	m := sync.Mapping{From: "legacy/", To: "shard-1/", Normalize: true}
+	preview, err := sync.PreviewMapping(encodeKeys(keys), m, 2, 1)
+	if err != nil {
+		t.Fatalf("can't preview mapping: %v", err)
+	}
+
+	wantSamples := []sync.MappedKey{
+		{Src: "legacy/a/1", Dest: "shard-1/a/1"},
+		{Src: "legacy/a/2 ", Dest: "shard-1/a/2"},
+	}
+	if !reflect.DeepEqual(preview.Samples, wantSamples) {
+		t.Errorf("want samples %+v, got %+v", wantSamples, preview.Samples)
+	}
+	if preview.Keys != 5 || preview.Bytes != 15 || preview.Moved != 3 || preview.Normalized != 1 || preview.Unchanged != 1 {
+		t.Errorf("want 5 keys, 3 moved, 1 normalized and 1 unchanged, got %+v", preview)
+	}
+	wantPrefixes := []sync.PrefixMapping{
+		{Src: "legacy/", Dest: "shard-1/", Keys: 3, Bytes: 6},
+		{Src: "other/", Dest: "other/", Keys: 2, Bytes: 9},
+	}
+	if !reflect.DeepEqual(preview.Prefixes, wantPrefixes) {
+		t.Errorf("want prefixes %+v, got %+v", wantPrefixes, preview.Prefixes)
+	}
+	if preview.Suspicious() {
+		t.Error("want a mapping that moves keys not to be suspicious")
+	}
+	if out := preview.String(); !strings.Contains(out, `-> "shard-1/a/1"`) || !strings.Contains(out, "moved:") {
+		t.Errorf("want the samples and counts printed, got:\n%s", out)
+	}
+
+	// a typo in the prefix moves nothing
+	m = sync.Mapping{From: "legacies/", To: "shard-1/"}
+	preview, err = sync.PreviewMapping(encodeKeys(keys), m, 0, 2)
+	if err != nil {
+		t.Fatalf("can't preview mapping: %v", err)
+	}
+	if !preview.Suspicious() || preview.Moved != 0 || len(preview.Samples) != 0 {
+		t.Errorf("want a suspicious mapping that moves nothing, got %+v", preview)
+	}
+	if len(preview.Prefixes) != 3 || preview.Prefixes[0] != (sync.PrefixMapping{Src: "legacy/a/", Dest: "legacy/a/", Keys: 2, Bytes: 3}) {
+		t.Errorf("want prefixes 2 deep, got %+v", preview.Prefixes)
+	}
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+)
+
+// Priorities sync the keys of critical prefixes ahead of the bulk of the
+// listing: the keys are held in a queue per priority class on their way to
+// the sync workers, which always get a key of the most urgent class held.
+type Priorities struct {
+	// Prefixes of the keys of each class, from the most urgent. A key is in
+	// the class of the first prefix it has, the keys with none of them are
+	// in a last class of their own.
+	Prefixes []string
+	// Window is how many keys are held to pick from, which is how far ahead
+	// in the listing an urgent key is found.
+	Window int
+}
+
+// Validate checks that there are classes to sync first.
+func (p Priorities) Validate() error {
+	switch {
+	case len(p.Prefixes) == 0:
+		return fmt.Errorf("need at least 1 priority prefix")
+	case p.Window < 1:
+		return fmt.Errorf("need a priority window of at least 1 key, got %d", p.Window)
+	}
+	return nil
+}
+
+// class of a key, 0 being the most urgent.
+func (p Priorities) class(key string) int {
+	for i, prefix := range p.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return i
+		}
+	}
+	return len(p.Prefixes)
+}
+
+// prioritize sends the keys of in to out by priority class, holding up to
+// the window of keys to pick from, until in is closed and all the keys held
+// were sent.
+func prioritize(in <-chan s3.Key, out chan<- s3.Key, p Priorities) {
+	var (
+		queues = make([][]s3.Key, len(p.Prefixes)+1)
+		counts = make([]int, len(queues))
+		held   int
+	)
+	hold := func(key s3.Key) {
+		class := p.class(key.Key)
+		queues[class] = append(queues[class], key)
+		counts[class]++
+		held++
+	}
+	// most urgent class with keys held
+	urgent := func() int {
+		for class, queue := range queues {
+			if len(queue) > 0 {
+				return class
+			}
+		}
+		return -1
+	}
+
+	for in != nil || held > 0 {
+		// hold as many keys as possible before sending one, to find the
+		// urgent ones further down the listing
+		if in != nil && held < p.Window {
+			select {
+			case key, ok := <-in:
+				if !ok {
+					in = nil
+				} else {
+					hold(key)
+				}
+				continue
+			default:
+			}
+		}
+
+		recv := in
+		if held >= p.Window {
+			recv = nil
+		}
+		var send chan<- s3.Key
+		var key s3.Key
+		class := urgent()
+		if class >= 0 {
+			send = out
+			key = queues[class][0]
+		}
+		select {
+		case k, ok := <-recv:
+			if !ok {
+				in = nil
+			} else {
+				hold(k)
+			}
+		case send <- key:
+			queues[class] = queues[class][1:]
+			held--
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"prefixes": p.Prefixes,
+		"classes":  counts,
+	}).Info("done prioritizing keys")
+}
@@ -0,0 +1,94 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestSyncPriorities(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	// a sorted listing, with the critical keys after the bulk ones
+	var keys []s3.Key
+	for _, prefix := range []string{"bulk/", "critical/", "logs/"} {
+		for i := 0; i < 100; i++ {
+			keys = append(keys, s3.Key{Key: prefix + strconv.Itoa(i)})
+		}
+	}
+
+	var mu gosync.Mutex
+	var order []string
+	first := true
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if first {
+			// lets the whole listing be held before the next key
+			first = false
+			time.Sleep(100 * time.Millisecond)
+		}
+		order = append(order, key.Key)
+		return nil
+	}
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(1),
+		sync.WithSyncer(syncer),
+		sync.WithPriorities(sync.Priorities{Prefixes: []string{"critical/", "bulk/"}, Window: len(keys)}),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != len(keys) {
+		t.Fatalf("want %d keys synced, got %d", len(keys), len(order))
+	}
+	// past the keys already on their way to the sync workers, the classes
+	// are synced in turn
+	lastCritical, firstLogs := -1, -1
+	for i, key := range order {
+		switch {
+		case strings.HasPrefix(key, "critical/"):
+			lastCritical = i
+		case strings.HasPrefix(key, "logs/") && firstLogs < 0:
+			firstLogs = i
+		}
+	}
+	if lastCritical >= 150 {
+		t.Errorf("want the critical keys synced ahead of the bulk keys, the last one is synced %dth", lastCritical)
+	}
+	if firstLogs < 200 {
+		t.Errorf("want the keys matching no prefix synced last, the first one is synced %dth", firstLogs)
+	}
+
+	for _, p := range []sync.Priorities{
+		{Window: 10},
+		{Prefixes: []string{"critical/"}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("want invalid priorities %+v", p)
+		}
+	}
+}
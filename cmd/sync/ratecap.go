@@ -0,0 +1,153 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrefixRates caps the rate of the writes to each prefix of the destination.
+// S3 throttles the requests to a prefix past a few thousand writes a second,
+// answering them with SlowDown errors, so a task that writes below the limit
+// doesn't have to find it through its errors and retries.
+type PrefixRates struct {
+	// Default is the writes a second to each prefix that has no rate of its
+	// own, 0 for no cap.
+	Default float64
+	// Depth is how many '/'-separated parts of a key make its prefix, 1
+	// for its top-level prefix.
+	Depth int
+	// Rates of given prefixes, in writes a second. A key is capped by the
+	// rate of the longest of these prefixes it has, and by Default if it
+	// has none of them.
+	Rates map[string]float64
+}
+
+// Validate checks that the rates can be kept.
+func (r PrefixRates) Validate() error {
+	switch {
+	case r.Default < 0:
+		return fmt.Errorf("prefix rate must be positive, got %v", r.Default)
+	case r.Depth < 1:
+		return fmt.Errorf("prefix depth must be at least 1, got %d", r.Depth)
+	case r.Default == 0 && len(r.Rates) == 0:
+		return fmt.Errorf("need a default rate, or the rate of a prefix")
+	}
+	for prefix, rate := range r.Rates {
+		if rate <= 0 {
+			return fmt.Errorf("rate of prefix %q must be positive, got %v", prefix, rate)
+		}
+	}
+	return nil
+}
+
+// ParsePrefixRates parses rates of the form "images/=3000,logs/=500".
+func ParsePrefixRates(spec string) (map[string]float64, error) {
+	rates := make(map[string]float64)
+	for _, part := range strings.Split(spec, ",") {
+		i := strings.LastIndexByte(part, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("prefix rate %q is not of the form prefix=rate", part)
+		}
+		rate, err := strconv.ParseFloat(part[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate of prefix %q: %v", part[:i], err)
+		}
+		rates[part[:i]] = rate
+	}
+	return rates, nil
+}
+
+// limit of the writes to a key: the prefix it counts against, and its rate,
+// 0 if it's not capped.
+func (r PrefixRates) limit(key string) (string, float64) {
+	var longest string
+	var rate float64
+	found := false
+	for prefix, prefixRate := range r.Rates {
+		if strings.HasPrefix(key, prefix) && (!found || len(prefix) > len(longest)) {
+			longest, rate, found = prefix, prefixRate, true
+		}
+	}
+	if found {
+		return longest, rate
+	}
+	return prefixAt(key, r.Depth), r.Default
+}
+
+// prefixAt is the prefix of a key up to and including its depth-th '/', or
+// the key's dir if it has fewer.
+func prefixAt(key string, depth int) string {
+	end := 0
+	for i := 0; i < depth; i++ {
+		j := strings.IndexByte(key[end:], '/')
+		if j < 0 {
+			break
+		}
+		end += j + 1
+	}
+	return key[:end]
+}
+
+// rateCaps holds a bucket of tokens for each prefix written to.
+type rateCaps struct {
+	PrefixRates
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateCaps(r PrefixRates) *rateCaps {
+	return &rateCaps{PrefixRates: r, buckets: make(map[string]*tokenBucket)}
+}
+
+// wait until a write to the key is within the rate of its prefix, or until
+// ctx is done. Returns whether the write had to wait.
+func (c *rateCaps) wait(ctx context.Context, key string) (bool, error) {
+	prefix, rate := c.limit(key)
+	if rate == 0 {
+		return false, nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	b, ok := c.buckets[prefix]
+	if !ok {
+		// a second of writes can go at once
+		b = &tokenBucket{tokens: rate, last: now}
+		c.buckets[prefix] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > rate {
+		b.tokens = rate
+	}
+	b.last = now
+	// the write takes its token right away, even if it's not there yet, so
+	// the writes that wait are let through in turn
+	b.tokens--
+	delay := time.Duration(-b.tokens / rate * float64(time.Second))
+	c.mu.Unlock()
+	if delay <= 0 {
+		return false, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true, nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		b.tokens++
+		c.mu.Unlock()
+		return true, ctx.Err()
+	}
+}
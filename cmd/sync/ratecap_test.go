@@ -0,0 +1,84 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPrefixRates(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	rates, err := sync.ParsePrefixRates("fast/=100000")
+	if err != nil {
+		t.Fatalf("can't parse rates: %v", err)
+	}
+	run := func(keys []s3.Key) (time.Duration, sync.Progress) {
+		syncTask, err := sync.NewSyncTask(src, dst,
+			sync.WithConcurrency(10),
+			sync.WithSyncer(func(src, dst *s3.Bucket, key s3.Key) error { return nil }),
+			sync.WithPrefixRates(sync.PrefixRates{Default: 50, Depth: 1, Rates: rates}),
+		)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		start := time.Now()
+		if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		return time.Since(start), syncTask.Progress()
+	}
+	keysUnder := func(prefixes ...string) []s3.Key {
+		var keys []s3.Key
+		for _, prefix := range prefixes {
+			for i := 0; i < 75; i++ {
+				keys = append(keys, s3.Key{Key: prefix + strconv.Itoa(i)})
+			}
+		}
+		return keys
+	}
+
+	// a second of writes goes at once, the rest at the rate of the prefix
+	took, p := run(keysUnder("slow/"))
+	if took < 400*time.Millisecond || p.RateCapped == 0 {
+		t.Errorf("want the writes to slow/ capped at 50/s, took %v for %+v", took, p)
+	}
+	// each prefix has a rate of its own
+	took, p = run(keysUnder("a/", "b/", "fast/"))
+	if took > 2*time.Second || p.Synced != 225 {
+		t.Errorf("want each prefix capped on its own, took %v for %+v", took, p)
+	}
+
+	if _, err := sync.ParsePrefixRates("images/"); err == nil {
+		t.Errorf("want a rate for each prefix")
+	}
+	got, err := sync.ParsePrefixRates("images/=3000,logs/a=b/=500")
+	if want := map[string]float64{"images/": 3000, "logs/a=b/": 500}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("want rates %v, got %v, %v", want, got, err)
+	}
+	for _, r := range []sync.PrefixRates{
+		{Depth: 1},
+		{Default: 10},
+		{Depth: 1, Rates: map[string]float64{"a/": 0}},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("want invalid prefix rates %+v", r)
+		}
+	}
+}
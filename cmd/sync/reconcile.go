@@ -0,0 +1,207 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reconcile counts the keys of the destination under the prefixes a task
+// synced to once it's done, and compares the counts to the keys the task
+// expects there: those it synced and those it skipped because they already
+// exist. A prefix holding fewer keys than expected tells of keys that were
+// reported synced but never landed, which a sample of keys can easily miss.
+// Keys of the destination that the task didn't sync, such as older keys,
+// aren't discrepancies.
+type Reconcile struct {
+	// Depth of the prefixes counted, in path segments: 1 counts the keys of
+	// each top level "directory" of the destination, such as "logs/".
+	Depth int
+	// Prefixes is the most prefixes counted, picked at random when the task
+	// synced to more of them, or all of them when 0.
+	Prefixes int
+}
+
+// Validate the depth and the number of prefixes.
+func (r Reconcile) Validate() error {
+	if r.Depth < 1 {
+		return fmt.Errorf("reconciliation depth must be at least 1, got %d", r.Depth)
+	}
+	if r.Prefixes < 0 {
+		return fmt.Errorf("number of prefixes to reconcile can't be negative, got %d", r.Prefixes)
+	}
+	return nil
+}
+
+// PrefixCount is the number of keys expected and found at the destination
+// under a prefix. Keys shallower than the depth of the reconciliation are
+// counted under their parent, without the keys of its sub-prefixes, which
+// isn't Recursive.
+type PrefixCount struct {
+	Prefix    string `json:"prefix"`
+	Recursive bool   `json:"recursive"`
+	Expected  int64  `json:"expected"`
+	Found     int64  `json:"found"`
+}
+
+// Reconciliation is the outcome of ReconcileCounts: how many prefixes the
+// task synced to and were counted, those with fewer keys than expected, and
+// how many couldn't be counted.
+type Reconciliation struct {
+	Prefixes      int           `json:"prefixes"`
+	Counted       int           `json:"counted"`
+	Discrepancies []PrefixCount `json:"discrepancies"`
+	Errors        int           `json:"errors"`
+}
+
+// OK is true when all the prefixes counted hold the keys expected.
+func (r Reconciliation) OK() bool { return len(r.Discrepancies) == 0 && r.Errors == 0 }
+
+type prefixKey struct {
+	prefix    string
+	recursive bool
+}
+
+// expectedKeys counts the keys a task expects at its destination, by
+// prefix.
+type expectedKeys struct {
+	mu     sync.Mutex
+	counts map[prefixKey]int64
+}
+
+// expect a key named name at the destination.
+func (s *SyncTask) expect(name string) {
+	if s.Reconcile == nil {
+		return
+	}
+	p := reconcilePrefix(name, s.Reconcile.Depth)
+	s.expected.mu.Lock()
+	defer s.expected.mu.Unlock()
+	if s.expected.counts == nil {
+		s.expected.counts = make(map[prefixKey]int64)
+	}
+	s.expected.counts[p]++
+}
+
+// reconcilePrefix is the prefix of name that is depth segments deep, or its
+// parent when it's shallower.
+func reconcilePrefix(name string, depth int) prefixKey {
+	i := 0
+	for n := 0; n < depth; n++ {
+		j := strings.IndexByte(name[i:], '/')
+		if j < 0 {
+			return prefixKey{prefix: name[:i]}
+		}
+		i += j + 1
+	}
+	return prefixKey{prefix: name[:i], recursive: true}
+}
+
+// ReconcileCounts lists the prefixes the task synced to at the destination,
+// with SyncPara prefixes at a time, once the task is done, and compares the
+// keys found to the keys expected.
+func (s *SyncTask) ReconcileCounts() Reconciliation {
+	s.expected.mu.Lock()
+	counts := make([]PrefixCount, 0, len(s.expected.counts))
+	for p, n := range s.expected.counts {
+		counts = append(counts, PrefixCount{Prefix: p.prefix, Recursive: p.recursive, Expected: n})
+	}
+	s.expected.mu.Unlock()
+	sort.Sort(byPrefix(counts))
+
+	r := Reconciliation{Prefixes: len(counts)}
+	if s.Reconcile != nil && s.Reconcile.Prefixes > 0 && len(counts) > s.Reconcile.Prefixes {
+		rand.Shuffle(len(counts), func(i, j int) { counts[i], counts[j] = counts[j], counts[i] })
+		counts = counts[:s.Reconcile.Prefixes]
+		sort.Sort(byPrefix(counts))
+	}
+
+	var (
+		mu      sync.Mutex
+		workers pipeline.Workers
+	)
+	prefixes := make(chan int)
+	para := s.SyncPara
+	if para < 1 {
+		para = 1
+	}
+	workers.Start(para, func(int) {
+		for i := range prefixes {
+			found, err := countKeys(s.dst, counts[i].Prefix, counts[i].Recursive)
+			counts[i].Found = found
+			mu.Lock()
+			if err != nil {
+				r.Errors++
+				logrus.WithFields(logrus.Fields{
+					"prefix": counts[i].Prefix,
+					"error":  err,
+				}).Error("couldn't count the keys of prefix")
+			} else {
+				r.Counted++
+			}
+			mu.Unlock()
+		}
+	})
+	for i := range counts {
+		prefixes <- i
+	}
+	close(prefixes)
+	workers.Wait()
+
+	for _, c := range counts {
+		if c.Found < c.Expected {
+			r.Discrepancies = append(r.Discrepancies, c)
+		}
+	}
+	metrics.reconciledPrefixes.Add(int64(r.Counted))
+	metrics.reconcileDiscrepancies.Add(int64(len(r.Discrepancies)))
+
+	s.summary.mu.Lock()
+	s.summary.reconciliation = &r
+	s.summary.mu.Unlock()
+	return r
+}
+
+// countKeys lists the keys of bkt under prefix, and its sub-prefixes when
+// recursive, counting them.
+func countKeys(bkt *s3.Bucket, prefix string, recursive bool) (int64, error) {
+	delim := "/"
+	if recursive {
+		delim = ""
+	}
+	var n int64
+	var marker string
+	for {
+		resp, err := bkt.List(prefix, delim, marker, 1000)
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(resp.Contents))
+		if !resp.IsTruncated {
+			return n, nil
+		}
+		marker = resp.NextMarker
+		if marker == "" && len(resp.Contents) > 0 {
+			marker = resp.Contents[len(resp.Contents)-1].Key
+		}
+		if marker == "" {
+			return n, fmt.Errorf("truncated listing of %q without a marker", prefix)
+		}
+	}
+}
+
+type byPrefix []PrefixCount
+
+func (b byPrefix) Len() int      { return len(b) }
+func (b byPrefix) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byPrefix) Less(i, j int) bool {
+	if b[i].Prefix != b[j].Prefix {
+		return b[i].Prefix < b[j].Prefix
+	}
+	return !b[i].Recursive && b[j].Recursive
+}
@@ -0,0 +1,90 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileCounts(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, name := range []string{"logs/1", "logs/2", "logs/2019/1", "img/a/1", "top"} {
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name, Size: int64(len(name))})
+	}
+
+	for _, depth := range []int{1, 2} {
+		syncTask, err := sync.NewSyncTask(src, dst, sync.WithReconcile(sync.Reconcile{Depth: depth}))
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		if r := syncTask.ReconcileCounts(); !r.OK() || r.Counted != r.Prefixes {
+			t.Errorf("depth %d: want all the prefixes counted and matching, got %+v", depth, r)
+		}
+	}
+
+	// a key reported synced went missing, and a key the sync didn't write
+	// showed up
+	if err := dst.Del("logs/2"); err != nil {
+		t.Fatalf("can't delete key: %v", err)
+	}
+	if err := dst.Put("img/b", []byte("b"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	for _, tt := range []struct {
+		depth int
+		want  []sync.PrefixCount
+	}{
+		{depth: 1, want: []sync.PrefixCount{{Prefix: "logs/", Recursive: true, Expected: 3, Found: 2}}},
+		// the keys of logs/ are counted without those of logs/2019/
+		{depth: 2, want: []sync.PrefixCount{{Prefix: "logs/", Expected: 2, Found: 1}}},
+	} {
+		syncTask, err := sync.NewSyncTask(src, dst, sync.WithReconcile(sync.Reconcile{Depth: tt.depth}))
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		// the keys that exist are skipped, the missing one is reported synced
+		// without being written
+		syncTask.Existing = &sync.Existing{Policy: sync.CollideSkip}
+		syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error { return nil }
+		if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		r := syncTask.ReconcileCounts()
+		if !reflect.DeepEqual(r.Discrepancies, tt.want) {
+			t.Errorf("depth %d: want discrepancies %+v, got %+v", tt.depth, tt.want, r.Discrepancies)
+		}
+		sum := syncTask.Summary()
+		if sum.Reconciliation == nil || !strings.Contains(sum.String(), "1 short of keys") {
+			t.Errorf("depth %d: want the discrepancy in the summary, got\n%s", tt.depth, sum)
+		}
+	}
+
+	for _, bad := range []sync.Reconcile{{Depth: 0}, {Depth: 1, Prefixes: -1}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
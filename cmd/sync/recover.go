@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"runtime/debug"
+)
+
+// The stages of the workers of a task, as their panics are reported.
+const (
+	StageDecode = "decode"
+	StageSync   = "sync"
+	StageEncode = "encode"
+)
+
+// PanicErrorCode is the error code of the keys failed by a panic.
+const PanicErrorCode = "Panic"
+
+// PanicError is a panic recovered from a worker while it was on a key. The
+// worker goes on with the next key rather than take the task down, so that
+// one bad record can't end a run of days.
+type PanicError struct {
+	Stage string
+	// Key the worker was on, or the start of the line it was decoding.
+	Key   string
+	Value interface{}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s worker on %q: %v", e.Stage, e.Key, e.Value)
+}
+
+// bailout is a panic that must take the task down, such as when its state
+// can't be written: workers let it through rather than recover from it.
+type bailout struct{ err error }
+
+// panicked records a panic recovered by a worker of a stage while on key,
+// and returns it as an error. Deferred functions call it with the value of
+// recover(), which must be called by them.
+func (s *SyncTask) panicked(stage, key string, r interface{}) *PanicError {
+	if _, ok := r.(*bailout); ok {
+		panic(r)
+	}
+	metrics.panics.Add(1)
+	s.stats.panics.Add(1)
+	logrus.WithFields(logrus.Fields{
+		"stage": stage,
+		"key":   key,
+		"panic": r,
+		"stack": string(debug.Stack()),
+	}).Error("worker panicked, recovered and going on with the next key")
+	return &PanicError{Stage: stage, Key: key, Value: r}
+}
+
+// maxPanicLine is the most of a line that a panic of a decoder reports.
+const maxPanicLine = 256
+
+func panicLine(line []byte) string {
+	if len(line) > maxPanicLine {
+		line = line[:maxPanicLine]
+	}
+	return string(line)
+}
@@ -0,0 +1,73 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"testing"
+	"time"
+)
+
+// panickyOutput is an output that is its own encoder, and panics on a key
+type panickyOutput struct {
+	panicOn string
+	keys    []string
+}
+
+func (p *panickyOutput) Write(b []byte) (int, error) { return len(b), nil }
+
+func (p *panickyOutput) EncodeKey(key s3.Key) error {
+	if key.Key == p.panicOn {
+		panic("bad key")
+	}
+	p.keys = append(p.keys, key.Key)
+	return nil
+}
+
+func (p *panickyOutput) EncodeFailure(f listing.Failure) error { return p.EncodeKey(f.Key) }
+func (p *panickyOutput) Flush() error                          { return nil }
+
+func TestSyncRecoversFromPanics(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:20]
+	bad := keys[3].Key
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		if key.Key == bad {
+			var m map[string]int
+			m[key.Key]++ // a bug on a single key
+		}
+		return nil
+	}
+	task, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(2), sync.WithSyncer(syncer))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	synced := &panickyOutput{panicOn: keys[5].Key}
+	var failed bytes.Buffer
+	if err := task.Start(encodeKeys(keys), synced, &failed); err != nil {
+		t.Fatalf("want the sync to go on after the panics, got %v", err)
+	}
+
+	p := task.Progress()
+	if p.Synced != int64(len(keys)-1) || p.Failed != 1 || p.Panics != 2 {
+		t.Errorf("want a key failed by its panic, another not encoded, got %+v", p)
+	}
+	if len(synced.keys) != len(keys)-2 {
+		t.Errorf("want the keys encoded but the one that panicked, got %d", len(synced.keys))
+	}
+	if out := failed.String(); !strings.Contains(out, bad) || !strings.Contains(out, `"error_code":"Panic"`) {
+		t.Errorf("want the key that panicked failed, got %s", out)
+	}
+}
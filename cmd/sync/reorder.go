@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"github.com/pushrax/goamz/s3"
+	gosync "sync"
+	"time"
+)
+
+// reorderKeyBytes is roughly what a key held by the reordering window costs
+// on top of its name: the fields of its s3.Key and of its slot.
+const reorderKeyBytes = 160
+
+// reorder writes the synced keys in the order of the listing. Keys are
+// registered in that order on their way to the sync workers, and held once
+// synced until the keys before them are done, synced or not. At most
+// window keys are between the oldest key not done and the newest one
+// registered: registering waits for the oldest to be done, which bounds
+// the memory of the window at the cost of holding up the sync workers
+// behind a slow key.
+type reorder struct {
+	mu    gosync.Mutex
+	cond  *gosync.Cond
+	slots []reorderSlot
+	// seq of the next key registered, and of the oldest one not done
+	next, head uint64
+	// seqs of the keys in the window, by name, oldest first
+	byName map[string][]uint64
+	out    func(s3.Key)
+	stats  *taskStats
+	// most keys and bytes held at once
+	peakKeys, peakBytes int64
+	keys, bytes         int64
+}
+
+type reorderSlot struct {
+	key          s3.Key
+	synced, done bool
+}
+
+func newReorder(window int, out func(s3.Key), stats *taskStats) *reorder {
+	r := &reorder{
+		slots:  make([]reorderSlot, window),
+		byName: make(map[string][]uint64),
+		out:    out,
+		stats:  stats,
+	}
+	r.cond = gosync.NewCond(&r.mu)
+	return r
+}
+
+// register the next key of the listing, waiting for room in the window. It
+// returns how long it waited.
+func (r *reorder) register(key s3.Key) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var waited time.Duration
+	if r.next-r.head >= uint64(len(r.slots)) {
+		start := time.Now()
+		for r.next-r.head >= uint64(len(r.slots)) {
+			r.cond.Wait()
+		}
+		waited = time.Since(start)
+	}
+	r.slots[r.next%uint64(len(r.slots))] = reorderSlot{key: key}
+	r.byName[key.Key] = append(r.byName[key.Key], r.next)
+	r.next++
+	r.hold(1, int64(len(key.Key)+reorderKeyBytes))
+	return waited
+}
+
+// synced marks a key as synced, to be written once the keys before it are
+// done.
+func (r *reorder) synced(key s3.Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slot := r.slot(key.Key, func(s *reorderSlot) bool { return !s.synced }); slot != nil {
+		slot.key, slot.synced = key, true
+	}
+}
+
+// done marks a key as done with, synced or not, and writes the synced keys
+// that no longer wait on a key before them.
+func (r *reorder) done(key s3.Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if slot := r.slot(key.Key, func(s *reorderSlot) bool { return !s.done }); slot != nil {
+		slot.done = true
+	}
+	advanced := false
+	for r.head < r.next {
+		slot := &r.slots[r.head%uint64(len(r.slots))]
+		if !slot.done {
+			break
+		}
+		if slot.synced {
+			r.out(slot.key)
+		}
+		seqs := r.byName[slot.key.Key]
+		if len(seqs) <= 1 {
+			delete(r.byName, slot.key.Key)
+		} else {
+			r.byName[slot.key.Key] = seqs[1:]
+		}
+		r.hold(-1, -int64(len(slot.key.Key)+reorderKeyBytes))
+		*slot = reorderSlot{}
+		r.head++
+		advanced = true
+	}
+	if advanced {
+		r.cond.Broadcast()
+	}
+}
+
+func (r *reorder) hold(keys, bytes int64) {
+	r.keys += keys
+	r.bytes += bytes
+	if r.keys > r.peakKeys {
+		r.peakKeys = r.keys
+	}
+	if r.bytes > r.peakBytes {
+		r.peakBytes = r.bytes
+	}
+	r.stats.reorderKeys.Set(r.keys)
+	r.stats.reorderBytes.Set(r.bytes)
+}
+
+// peak is the most keys and bytes the window held at once.
+func (r *reorder) peak() (keys, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peakKeys, r.peakBytes
+}
+
+// slot of the oldest key named name that match picks, if any.
+func (r *reorder) slot(name string, match func(*reorderSlot) bool) *reorderSlot {
+	for _, seq := range r.byName[name] {
+		if slot := &r.slots[seq%uint64(len(r.slots))]; match(slot) {
+			return slot
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package sync_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSyncOrderedOutput(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:200]
+	failing := keys[10].Key
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		// keys are done in any order
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		if key.Key == failing {
+			return errors.New("can't sync")
+		}
+		return nil
+	}
+	task, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(8),
+		sync.WithRetry(1, time.Millisecond),
+		sync.WithSyncer(syncer),
+		sync.WithOrderedOutput(16),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	var synced, failed bytes.Buffer
+	if err := task.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	got := decodeKeys(&synced)
+	if len(got) != len(keys)-1 {
+		t.Fatalf("want %d keys synced, got %d", len(keys)-1, len(got))
+	}
+	i := 0
+	for _, key := range keys {
+		if key.Key == failing {
+			continue
+		}
+		if got[i].Key != key.Key {
+			t.Fatalf("want the synced keys in the order of the listing, got %q at %d rather than %q", got[i].Key, i, key.Key)
+		}
+		i++
+	}
+	if p := task.Progress(); p.Failed != 1 || p.Reordering != 0 || p.ReorderBytes != 0 {
+		t.Errorf("want the window emptied once done, got %+v", p)
+	}
+
+	// the window holds the keys the outputs may wait on, which nothing
+	// else can reorder
+	for name, opts := range map[string][]sync.Option{
+		"no window":   {sync.WithOrderedOutput(0)},
+		"interleaved": {sync.WithOrderedOutput(16), sync.WithInterleave(10)},
+	} {
+		task, err := sync.NewSyncTask(src, dst, opts...)
+		if err == nil {
+			err = task.Start(encodeKeys(keys[:1]), &synced, &failed)
+		}
+		if err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"time"
+)
+
+// Object Lock retention modes.
+const (
+	Governance = "GOVERNANCE"
+	Compliance = "COMPLIANCE"
+)
+
+// Retention to set on the copy of a key, in a destination bucket with Object
+// Lock enabled.
+type Retention struct {
+	// Mode is Governance or Compliance, or empty to not retain the copy.
+	Mode string
+	// Until is the date until which the copy is retained.
+	Until time.Time
+	// LegalHold puts the copy under legal hold, independently of the mode.
+	LegalHold bool
+}
+
+// Validate that the retention can be set on a copy made now.
+func (r Retention) Validate() error {
+	switch r.Mode {
+	case "":
+		if !r.Until.IsZero() {
+			return errors.New("need a retention mode to retain until a date")
+		}
+		return nil
+	case Governance, Compliance:
+	default:
+		return fmt.Errorf("not a valid retention mode %q, want %s or %s", r.Mode, Governance, Compliance)
+	}
+	if !r.Until.After(time.Now()) {
+		return fmt.Errorf("retention date %v is not in the future", r.Until)
+	}
+	return nil
+}
+
+// RetentionFunc decides the retention of the copy of a key.
+type RetentionFunc func(key s3.Key) Retention
+
+// FixedRetention sets the same retention on the copies of all the keys.
+func FixedRetention(r Retention) RetentionFunc {
+	return func(s3.Key) Retention { return r }
+}
+
+// LockedCopySyncer copies keys like PutCopySyncer, setting the retention
+// decided by retention on the copies.
+func LockedCopySyncer(retention RetentionFunc) SyncerFunc {
+	copier := LockedCopy(retention)
+	return func(src, dst *s3.Bucket, key s3.Key) error {
+		return copier(src, dst, key, key.Key)
+	}
+}
+
+// LockedCopy is the CopyFunc of LockedCopySyncer.
+func LockedCopy(retention RetentionFunc) CopyFunc {
+	return func(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+		opts, err := copyOptions(src, key)
+		if err != nil {
+			return err
+		}
+		r := retention(key)
+		opts.ObjectLockMode = r.Mode
+		opts.ObjectLockRetainUntil = r.Until
+		opts.ObjectLockLegalHold = r.LegalHold
+		_, err = dst.PutCopy(dstKey, ACLForKey(src, key), opts, CopySource(src.Name, key.Key))
+		return err
+	}
+}
+
+// isObjectLockMissing is true for the errors of requests that set a
+// retention in a bucket without Object Lock, which fail for every key.
+func isObjectLockMissing(err error) bool {
+	e, ok := err.(*s3.Error)
+	return ok && e.Code == s3.ErrInvalidRequest && strings.Contains(e.Message, "Object Lock")
+}
@@ -0,0 +1,93 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionValidate(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	valid := []sync.Retention{
+		{},
+		{LegalHold: true},
+		{Mode: sync.Governance, Until: future},
+		{Mode: sync.Compliance, Until: future, LegalHold: true},
+	}
+	for _, r := range valid {
+		if err := r.Validate(); err != nil {
+			t.Errorf("want %+v to be valid, got %v", r, err)
+		}
+	}
+	invalid := []sync.Retention{
+		{Until: future},
+		{Mode: "FOREVER", Until: future},
+		{Mode: sync.Governance},
+		{Mode: sync.Compliance, Until: time.Now().Add(-time.Hour)},
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("want %+v to be invalid", r)
+		}
+	}
+}
+
+func TestLockedCopySyncer(t *testing.T) {
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	headers := make(map[string]http.Header)
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			if r.Method == "PUT" {
+				headers[strings.TrimPrefix(r.URL.Path, "/dst-bucket/")] = r.Header
+			}
+			return nil
+		},
+	})
+
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	syncer := sync.LockedCopySyncer(func(key s3.Key) sync.Retention {
+		if strings.HasSuffix(key.Key, ".tar.gz") {
+			return sync.Retention{Mode: sync.Compliance, Until: until}
+		}
+		return sync.Retention{LegalHold: true}
+	})
+
+	keys := mockbkt.Keys()[:10]
+	for _, key := range keys {
+		if err := syncer(src, dst, key); err != nil {
+			t.Fatalf("can't sync %q: %v", key.Key, err)
+		}
+	}
+
+	for _, key := range keys {
+		h, ok := headers[key.Key]
+		if !ok {
+			t.Errorf("%q wasn't copied", key.Key)
+			continue
+		}
+		wantMode, wantUntil, wantHold := "", "", "ON"
+		if strings.HasSuffix(key.Key, ".tar.gz") {
+			wantMode, wantUntil, wantHold = sync.Compliance, "2030-01-02T03:04:05Z", ""
+		}
+		if got := h.Get("x-amz-object-lock-mode"); got != wantMode {
+			t.Errorf("%q: want mode %q, got %q", key.Key, wantMode, got)
+		}
+		if got := h.Get("x-amz-object-lock-retain-until-date"); got != wantUntil {
+			t.Errorf("%q: want retention until %q, got %q", key.Key, wantUntil, got)
+		}
+		if got := h.Get("x-amz-object-lock-legal-hold"); got != wantHold {
+			t.Errorf("%q: want legal hold %q, got %q", key.Key, wantHold, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package sync
+
+import (
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/pushrax/goamz/s3"
+	"time"
+)
+
+// retryPool retries the keys whose first sync call failed with RetryPara
+// workers of its own, while the sync workers go on with the fresh keys: a
+// burst of failing keys in a prefix would otherwise hold all the sync
+// workers sleeping between their retries, and starve the fresh keys that
+// would sync right away. The keys wait for a retry worker in a queue, and
+// the sync workers wait for room in it once it's full, so that a burst of
+// failures slows the fresh keys down rather than piling up in memory.
+type retryPool struct {
+	keys    chan retryingKey
+	workers pipeline.Workers
+}
+
+// retryingKey is a key handed to the retry pool by a sync worker, and how
+// to go on syncing it.
+type retryingKey struct {
+	key    s3.Key
+	worker int
+	failed chan<- listing.Failure
+	retry  func()
+}
+
+// startRetryPool starts the retry workers of the task.
+func (s *SyncTask) startRetryPool() *retryPool {
+	p := &retryPool{keys: make(chan retryingKey, s.SyncPara*BufferFactor)}
+	p.workers.Start(s.RetryPara, func(int) {
+		for k := range p.keys {
+			s.retryGuarded(k)
+		}
+	})
+	return p
+}
+
+// retryGuarded retries a key, recovering from a panic while doing so like
+// syncGuarded.
+func (s *SyncTask) retryGuarded(k retryingKey) {
+	defer s.stats.retrying.Add(-1)
+	defer s.recoverKey(k.worker, k.key, time.Now(), k.failed)
+	k.retry()
+}
+
+// handOff a key to the retry pool.
+func (s *SyncTask) handOff(k retryingKey) {
+	s.stats.retrying.Add(1)
+	s.retries.keys <- k
+}
+
+// close the retry pool once no key is handed to it anymore, and wait for
+// the keys in it to be done.
+func (p *retryPool) close() {
+	close(p.keys)
+	p.workers.Wait()
+}
+
+// retryHandedOff goes on syncing a key handed to the retry pool after its
+// attempt retry failed with err, unless the task was cancelled meanwhile,
+// in which case the key is dropped like those the sync workers didn't get
+// to. It returns false for dropped keys.
+func (s *SyncTask) retryHandedOff(src, dst *s3.Bucket, key s3.Key, retry int, c calls, err error) (int, calls, error, bool) {
+	if !s.ctl.wait() {
+		return retry, c, err, false
+	}
+	s.backoff(retry)
+	retries, c, err, _ := s.retryKey(src, dst, key, retry+1, c, err, false)
+	return retries, c, err, true
+}
@@ -0,0 +1,77 @@
+package sync_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSyncRetryWorkers(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, b := range []*s3.Bucket{src, dst} {
+		if err := b.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	// the keys of a prefix fail, ahead of the fresh keys that would sync
+	var keys []s3.Key
+	for i := 0; i < 4; i++ {
+		keys = append(keys, s3.Key{Key: fmt.Sprintf("bad/%d", i)})
+	}
+	for i := 0; i < 20; i++ {
+		keys = append(keys, s3.Key{Key: fmt.Sprintf("good/%d", i)})
+	}
+	var badCalls, badCallsBeforeGood int64
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		if strings.HasPrefix(key.Key, "bad/") {
+			atomic.AddInt64(&badCalls, 1)
+			return &s3.Error{StatusCode: 500, Code: s3.ErrInternalError}
+		}
+		if n := atomic.LoadInt64(&badCalls); n > atomic.LoadInt64(&badCallsBeforeGood) {
+			atomic.StoreInt64(&badCallsBeforeGood, n)
+		}
+		return nil
+	}
+
+	task, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(2),
+		sync.WithRetryWorkers(1),
+		sync.WithRetry(3, 10*time.Millisecond),
+		sync.WithSyncer(syncer),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	var synced, failed bytes.Buffer
+	if err := task.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	if got := decodeKeys(&synced); len(got) != 20 {
+		t.Errorf("want the 20 fresh keys synced, got %d", len(got))
+	}
+	if got := decodeKeys(&failed); len(got) != 4 {
+		t.Errorf("want the 4 failing keys failed, got %d", len(got))
+	}
+	// the sync workers only made the first call of each failing key before
+	// they got to the fresh keys
+	if badCalls != 12 || badCallsBeforeGood > 6 {
+		t.Errorf("want the fresh keys synced while the failing keys were retried, got %d of the %d calls of the failing keys before", badCallsBeforeGood, badCalls)
+	}
+	if p := task.Progress(); p.Synced != 20 || p.Failed != 4 || p.Retrying != 0 {
+		t.Errorf("want 20 keys synced, 4 failed and none retrying, got %+v", p)
+	}
+}
@@ -0,0 +1,127 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Rewrite of the metadata of keys already at the destination, which copies
+// each key onto itself there with the REPLACE metadata directive, so that
+// only the metadata is written and no data moves between buckets. S3 drops
+// the metadata that a replacing copy doesn't send, so the metadata of each
+// key is read with a HEAD and sent along, but for the fields set here.
+type Rewrite struct {
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	// Meta is the user metadata set on the keys, on top of the one they
+	// have.
+	Meta map[string][]string
+	// ACL of the keys, or the one they have, private or public-read, when
+	// empty.
+	ACL s3.ACL
+	// StorageClass of the keys, such as STANDARD_IA, or the one they have
+	// when empty.
+	StorageClass string
+	// Tagging replaces the tags of the keys, URL-encoded as in a query
+	// string, such as "team=storage&env=prod", or keeps them when empty.
+	Tagging string
+}
+
+// Validate that the rewrite changes something, with a known ACL and tags
+// that can be parsed.
+func (r Rewrite) Validate() error {
+	if r.ContentType == "" && r.CacheControl == "" && r.ContentDisposition == "" &&
+		r.ContentEncoding == "" && len(r.Meta) == 0 && r.ACL == "" && r.StorageClass == "" && r.Tagging == "" {
+		return errors.New("need metadata, an ACL, a storage class or tags to rewrite")
+	}
+	switch r.ACL {
+	case "", s3.Private, s3.PublicRead, s3.PublicReadWrite, s3.AuthenticatedRead, s3.BucketOwnerRead, s3.BucketOwnerFull:
+	default:
+		return fmt.Errorf("not a canned ACL %q", r.ACL)
+	}
+	if r.Tagging != "" {
+		if _, err := url.ParseQuery(r.Tagging); err != nil {
+			return fmt.Errorf("invalid tags %q: %v", r.Tagging, err)
+		}
+	}
+	return nil
+}
+
+// RewriteCopy is a CopyFunc that rewrites the metadata of the keys in place
+// at the destination, as dstKey. The source bucket isn't read.
+func RewriteCopy(r Rewrite) CopyFunc {
+	return func(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+		opts, err := r.copyOptions(dst, dstKey)
+		if err != nil {
+			return err
+		}
+		acl := r.ACL
+		if acl == "" {
+			acl = ACLForKey(dst, s3.Key{Key: dstKey})
+		}
+		_, err = dst.PutCopy(dstKey, acl, opts, CopySource(dst.Name, dstKey))
+		return err
+	}
+}
+
+// copyOptions to copy a key onto itself with the metadata it has, replaced
+// by the one of the rewrite.
+func (r Rewrite) copyOptions(bkt *s3.Bucket, key string) (s3.CopyOptions, error) {
+	var headers map[string][]string
+	if bkt.RequesterPays {
+		headers = map[string][]string{"x-amz-request-payer": {"requester"}}
+	}
+	resp, err := bkt.Head(key, headers)
+	if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+		// HEAD responses have no body, so the error has no code
+		e.Code = s3.ErrNoSuchKey
+		return s3.CopyOptions{}, e
+	}
+	if err != nil {
+		return s3.CopyOptions{}, err
+	}
+	_ = resp.Body.Close()
+	h := resp.Header
+
+	opts := s3.CopyOptions{
+		Options: s3.Options{
+			SSE:                h.Get("x-amz-server-side-encryption") == "AES256",
+			Meta:               map[string][]string{},
+			ContentEncoding:    orElse(r.ContentEncoding, h.Get("Content-Encoding")),
+			CacheControl:       orElse(r.CacheControl, h.Get("Cache-Control")),
+			ContentDisposition: orElse(r.ContentDisposition, h.Get("Content-Disposition")),
+			RedirectLocation:   h.Get("x-amz-website-redirect-location"),
+			StorageClass:       orElse(r.StorageClass, h.Get("x-amz-storage-class")),
+		},
+		MetadataDirective:     "REPLACE",
+		ContentType:           orElse(r.ContentType, h.Get("Content-Type")),
+		RequesterPays:         bkt.RequesterPays,
+		CopySourceCustomerKey: bkt.CustomerKey,
+	}
+	for name, values := range h {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-meta-") {
+			opts.Meta[strings.TrimPrefix(name, "x-amz-meta-")] = values
+		}
+	}
+	for name, values := range r.Meta {
+		opts.Meta[strings.ToLower(name)] = values
+	}
+	if r.Tagging != "" {
+		opts.Tagging, opts.TaggingDirective = r.Tagging, "REPLACE"
+	}
+	return opts, nil
+}
+
+func orElse(s, fallback string) string {
+	if s != "" {
+		return s
+	}
+	return fallback
+}
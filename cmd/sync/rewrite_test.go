@@ -0,0 +1,95 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRewriteMetadata(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	// the keys were synced with the wrong content type, and no cache control
+	keys := []s3.Key{{Key: "a.css", Size: 5}, {Key: "b.css", Size: 5}}
+	for _, key := range keys {
+		opts := s3.Options{Meta: map[string][]string{"owner": {"web"}}}
+		if err := dst.Put(key.Key, []byte("body{"), "text/plain", s3.Private, opts); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+
+	rewrite := sync.Rewrite{
+		ContentType:  "text/css",
+		CacheControl: "max-age=3600",
+		Meta:         map[string][]string{"Tier": {"hot"}},
+		Tagging:      "team=web",
+	}
+	if err := rewrite.Validate(); err != nil {
+		t.Fatalf("invalid rewrite: %v", err)
+	}
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithCopier(sync.RewriteCopy(rewrite)))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if p := syncTask.Progress(); p.Synced != 2 || p.Failed != 0 {
+		t.Fatalf("want the keys rewritten, got %+v", p)
+	}
+
+	for _, key := range keys {
+		resp, err := dst.Head(key.Key, nil)
+		if err != nil {
+			t.Fatalf("can't head %q: %v", key.Key, err)
+		}
+		_ = resp.Body.Close()
+		for name, want := range map[string]string{
+			"Content-Type":        "text/css",
+			"Cache-Control":       "max-age=3600",
+			"X-Amz-Meta-Owner":    "web",
+			"X-Amz-Meta-Tier":     "hot",
+			"X-Amz-Tagging-Count": "1",
+		} {
+			if got := resp.Header.Get(name); got != want {
+				t.Errorf("want %s of %q to be %q, got %q", name, key.Key, want, got)
+			}
+		}
+		data, err := dst.Get(key.Key)
+		if err != nil || string(data) != "body{" {
+			t.Errorf("want the data of %q kept, got %q, %v", key.Key, data, err)
+		}
+		tags, err := dst.GetTags(key.Key)
+		if want := map[string]string{"team": "web"}; err != nil || !reflect.DeepEqual(tags, want) {
+			t.Errorf("want tags %v on %q, got %v, %v", want, key.Key, tags, err)
+		}
+	}
+	// the source isn't read
+	if _, err := src.Get(keys[0].Key); err == nil {
+		t.Errorf("want nothing written to the source")
+	}
+
+	for _, r := range []sync.Rewrite{
+		{},
+		{ACL: "everyone"},
+		{Tagging: "team=%zz"},
+	} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("want invalid rewrite %+v", r)
+		}
+	}
+}
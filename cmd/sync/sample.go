@@ -0,0 +1,130 @@
+package sync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"math/rand"
+	"sort"
+)
+
+// Sample is a random subset of the keys of a listing, to rehearse a sync on
+// a few keys before the full run: to check permissions, or to measure its
+// throughput.
+type Sample struct {
+	// Fraction of the keys picked, each with that probability, or Count
+	// keys picked out of the whole listing. Only one of them is set.
+	Fraction float64
+	Count    int
+	// Seed of the random picks, the same seed picks the same keys of a
+	// listing.
+	Seed int64
+}
+
+// Validate checks that the sample picks keys.
+func (s Sample) Validate() error {
+	switch {
+	case s.Fraction == 0 && s.Count == 0:
+		return errors.New("sample needs a fraction or a count of keys")
+	case s.Fraction != 0 && s.Count != 0:
+		return errors.New("sample either a fraction or a count of keys, not both")
+	case s.Fraction < 0 || s.Fraction > 1:
+		return fmt.Errorf("sample fraction must be in [0, 1], got %v", s.Fraction)
+	case s.Count < 0:
+		return fmt.Errorf("sample count can't be negative, got %d", s.Count)
+	}
+	return nil
+}
+
+// SampleListing reads the listing r, in any format, returning a JSON
+// listing of the keys of the sample. A fraction of the keys is picked as
+// the listing is read, while a count of keys is only known once the whole
+// listing was read, and is held in memory.
+func SampleListing(r io.Reader, sample Sample) (io.Reader, error) {
+	if err := sample.Validate(); err != nil {
+		return nil, err
+	}
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	rnd := rand.New(rand.NewSource(sample.Seed))
+	if sample.Count > 0 {
+		return sampleCount(rd, sample.Count, rnd)
+	}
+	return filterListing(rd, func(s3.Key) bool { return rnd.Float64() < sample.Fraction }), nil
+}
+
+// filterListing returns a JSON listing of the keys of rd to keep, which
+// are filtered as the listing is read.
+func filterListing(rd listing.Reader, keep func(s3.Key) bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		w, _ := listing.NewWriter(pw, listing.JSON)
+		var key s3.Key
+		for {
+			err := rd.Read(&key)
+			if err == io.EOF {
+				_ = pw.CloseWithError(w.Flush())
+				return
+			}
+			if err == nil && keep(key) {
+				err = w.Write(key)
+			}
+			if err != nil {
+				_ = pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	return pr
+}
+
+// sampleCount picks n keys of a listing, all with the same probability,
+// keeping them in the order of the listing.
+func sampleCount(rd listing.Reader, n int, rnd *rand.Rand) (io.Reader, error) {
+	// reservoir sampling, the i-th key replaces a pick with probability n/i
+	var reservoir []picked
+	for i := 0; ; i++ {
+		var key s3.Key
+		err := rd.Read(&key)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(reservoir) < n {
+			reservoir = append(reservoir, picked{key, i})
+		} else if j := rnd.Intn(i + 1); j < n {
+			reservoir[j] = picked{key, i}
+		}
+	}
+
+	// replaced picks are out of order
+	sort.Sort(byPos(reservoir))
+
+	var buf bytes.Buffer
+	w, _ := listing.NewWriter(&buf, listing.JSON)
+	for _, p := range reservoir {
+		if err := w.Write(p.key); err != nil {
+			return nil, err
+		}
+	}
+	return &buf, w.Flush()
+}
+
+// picked is a key of a sample, and its position in the listing.
+type picked struct {
+	key s3.Key
+	pos int
+}
+
+type byPos []picked
+
+func (b byPos) Len() int           { return len(b) }
+func (b byPos) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPos) Less(i, j int) bool { return b[i].pos < b[j].pos }
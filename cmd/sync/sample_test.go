@@ -0,0 +1,66 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strconv"
+	"testing"
+)
+
+func TestSampleListing(t *testing.T) {
+	var keys []s3.Key
+	for i := 0; i < 10000; i++ {
+		keys = append(keys, s3.Key{Key: strconv.Itoa(i)})
+	}
+	read := func(sample sync.Sample) []s3.Key {
+		rd, err := sync.SampleListing(encodeKeys(keys), sample)
+		if err != nil {
+			t.Fatalf("can't sample: %v", err)
+		}
+		data, err := ioutil.ReadAll(rd)
+		if err != nil {
+			t.Fatalf("can't read sample: %v", err)
+		}
+		return decodeKeys(bytes.NewBuffer(data))
+	}
+	inOrder := func(sampled []s3.Key) bool {
+		last := -1
+		for _, key := range sampled {
+			i, _ := strconv.Atoi(key.Key)
+			if i <= last {
+				return false
+			}
+			last = i
+		}
+		return true
+	}
+
+	sampled := read(sync.Sample{Fraction: 0.1, Seed: 1})
+	if len(sampled) < 800 || len(sampled) > 1200 || !inOrder(sampled) {
+		t.Errorf("want about 1000 keys in order, got %d", len(sampled))
+	}
+	again := read(sync.Sample{Fraction: 0.1, Seed: 1})
+	if len(again) != len(sampled) || again[0] != sampled[0] {
+		t.Errorf("want the same keys picked with the same seed")
+	}
+
+	sampled = read(sync.Sample{Count: 50, Seed: 2})
+	if len(sampled) != 50 || !inOrder(sampled) {
+		t.Errorf("want 50 keys in order, got %d", len(sampled))
+	}
+	// not only the first keys
+	if last, _ := strconv.Atoi(sampled[49].Key); last < 1000 {
+		t.Errorf("want keys from the whole listing, got up to %d", last)
+	}
+	if sampled := read(sync.Sample{Count: 20000}); len(sampled) != len(keys) {
+		t.Errorf("want all %d keys when sampling more, got %d", len(keys), len(sampled))
+	}
+
+	for _, bad := range []sync.Sample{{}, {Fraction: 0.1, Count: 10}, {Fraction: 2}, {Count: -1}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("want %+v invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleEvery is how often a task checks which window of its schedule it's
+// in.
+const scheduleEvery = 10 * time.Second
+
+// Schedule throttles a task by the time of day, such as to be polite to the
+// destination during the business hours of a sync that takes days, without
+// an operator changing its flags as the days go. In each of its windows, the
+// sync calls of the task are capped to a rate, a number in flight, or both.
+// The first window the time of day is in applies, and the task isn't
+// throttled outside of them.
+type Schedule struct {
+	Windows []Window
+	// Location of the times of day of the windows, time.Local when nil.
+	Location *time.Location
+}
+
+// Window is a part of the day, from Start to End since midnight, which spans
+// midnight when End is before Start.
+type Window struct {
+	Start, End time.Duration
+	// Rate of the sync calls a second in the window, 0 for no cap.
+	Rate float64
+	// Calls in flight in the window, 0 for no cap.
+	Calls int
+}
+
+func (w Window) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return clock(w.Start) + "-" + clock(w.End)
+}
+
+func (w Window) contains(d time.Duration) bool {
+	if w.Start < w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+// Validate checks that each window of the schedule is a part of the day that
+// caps something.
+func (s Schedule) Validate() error {
+	if len(s.Windows) == 0 {
+		return fmt.Errorf("need at least one window")
+	}
+	for _, w := range s.Windows {
+		switch {
+		case w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End > 24*time.Hour:
+			return fmt.Errorf("window %v isn't within a day", w)
+		case w.Start == w.End:
+			return fmt.Errorf("window %v is empty", w)
+		case w.Rate < 0 || w.Calls < 0:
+			return fmt.Errorf("window %v must have positive caps, got a rate of %v and %d calls", w, w.Rate, w.Calls)
+		case w.Rate == 0 && w.Calls == 0:
+			return fmt.Errorf("window %v needs a rate, or a number of calls", w)
+		}
+	}
+	return nil
+}
+
+// window returns the window t is in, false if it's in none.
+func (s Schedule) window(t time.Time) (int, bool) {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for i, w := range s.Windows {
+		if w.contains(d) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// ParseSchedule parses windows of the form
+// "06:00-22:00=rate:50,calls:200;22:00-06:00=rate:500", capping the sync
+// calls a second with rate, and those in flight with calls.
+func ParseSchedule(spec string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		i := strings.IndexByte(part, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("window %q is not of the form start-end=caps", part)
+		}
+		span := strings.Split(part[:i], "-")
+		if len(span) != 2 {
+			return nil, fmt.Errorf("window %q is not of the form start-end=caps", part)
+		}
+		var w Window
+		var err error
+		if w.Start, err = parseClock(span[0]); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseClock(span[1]); err != nil {
+			return nil, err
+		}
+		for _, limit := range strings.Split(part[i+1:], ",") {
+			kv := strings.SplitN(strings.TrimSpace(limit), ":", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("cap %q of window %v is not of the form rate:n or calls:n", limit, w)
+			}
+			switch kv[0] {
+			case "rate":
+				w.Rate, err = strconv.ParseFloat(kv[1], 64)
+			case "calls":
+				w.Calls, err = strconv.Atoi(kv[1])
+			default:
+				return nil, fmt.Errorf("unknown cap %q of window %v, want rate or calls", kv[0], w)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s of window %v: %v", kv[0], w, err)
+			}
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseClock parses a time of day like "06:30", up to "24:00".
+func parseClock(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// scheduler caps the sync calls of a task as its schedule says.
+type scheduler struct {
+	Schedule
+
+	rate   *rateLimiter
+	budget *CallBudget
+	calls  *callShare
+
+	mu      sync.Mutex
+	current int
+}
+
+func newScheduler(s Schedule) *scheduler {
+	// uncapped until a window applies
+	budget, _ := NewCallBudget(math.MaxInt32)
+	sc := &scheduler{
+		Schedule: s,
+		rate:     newRateLimiter(0),
+		budget:   budget,
+		calls:    budget.share(),
+		current:  -1,
+	}
+	sc.apply(time.Now())
+	return sc
+}
+
+// apply the caps of the window of now, if it's another one than before.
+func (sc *scheduler) apply(now time.Time) {
+	i, _ := sc.window(now)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if i == sc.current {
+		return
+	}
+	sc.current = i
+	if i < 0 {
+		sc.rate.setRate(0)
+		_ = sc.budget.SetMax(math.MaxInt32)
+		logrus.Info("out of the windows of the schedule, the sync calls aren't capped")
+		return
+	}
+	w := sc.Windows[i]
+	sc.rate.setRate(w.Rate)
+	calls := w.Calls
+	if calls == 0 {
+		calls = math.MaxInt32
+	}
+	_ = sc.budget.SetMax(calls)
+	logrus.WithFields(logrus.Fields{
+		"window": w.String(),
+		"rate":   w.Rate,
+		"calls":  w.Calls,
+	}).Info("in a window of the schedule, capping the sync calls")
+}
+
+// acquire a slot for a sync call within the caps of the current window,
+// waiting for it, or until ctx is done. Returns whether the call had to wait
+// for the rate of the window.
+func (sc *scheduler) acquire(ctx context.Context) (bool, error) {
+	waited := false
+	if delay := sc.rate.reserve(1); delay > 0 {
+		waited = true
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		}
+	}
+	return waited, sc.calls.acquire(ctx)
+}
+
+func (sc *scheduler) release() { sc.calls.release() }
+
+// watchSchedule applies the schedule of the task as the day goes, until
+// done.
+func (s *SyncTask) watchSchedule(done <-chan struct{}) {
+	tick := time.NewTicker(scheduleEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick.C:
+			s.scheduler.apply(now)
+		}
+	}
+}
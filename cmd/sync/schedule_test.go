@@ -0,0 +1,131 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	windows, err := sync.ParseSchedule("06:00-22:00=rate:50,calls:200; 22:30-06:00=calls:10;00:00-24:00=rate:0.5")
+	if err != nil {
+		t.Fatalf("can't parse schedule: %v", err)
+	}
+	want := []sync.Window{
+		{Start: 6 * time.Hour, End: 22 * time.Hour, Rate: 50, Calls: 200},
+		{Start: 22*time.Hour + 30*time.Minute, End: 6 * time.Hour, Calls: 10},
+		{Start: 0, End: 24 * time.Hour, Rate: 0.5},
+	}
+	if !reflect.DeepEqual(windows, want) {
+		t.Errorf("want windows %+v, got %+v", want, windows)
+	}
+	if err := (sync.Schedule{Windows: windows}).Validate(); err != nil {
+		t.Errorf("want a valid schedule, got %v", err)
+	}
+
+	for _, spec := range []string{
+		"06:00-22:00",
+		"06:00=rate:50",
+		"6am-10pm=rate:50",
+		"06:00-22:00=rps:50",
+		"06:00-22:00=rate:fast",
+	} {
+		if _, err := sync.ParseSchedule(spec); err == nil {
+			t.Errorf("%q: want an error", spec)
+		}
+	}
+	for _, spec := range []string{
+		"06:00-06:00=rate:50",
+		"06:00-22:00=rate:0",
+		"06:00-22:00=calls:-1",
+	} {
+		windows, err := sync.ParseSchedule(spec)
+		if err != nil {
+			t.Fatalf("%q: can't parse schedule: %v", spec, err)
+		}
+		if err := (sync.Schedule{Windows: windows}).Validate(); err == nil {
+			t.Errorf("%q: want an invalid schedule", spec)
+		}
+	}
+}
+
+func TestScheduleCapsCalls(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	// windows around now, and away from it
+	now := time.Now().UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := now.Sub(midnight)
+	around := sync.Window{
+		Start: (sinceMidnight - time.Hour + 24*time.Hour) % (24 * time.Hour),
+		End:   (sinceMidnight + time.Hour) % (24 * time.Hour),
+		Rate:  200,
+		Calls: 2,
+	}
+	away := sync.Window{Start: around.End, End: around.Start, Calls: 1}
+
+	keys := mockbkt.Keys()[:60]
+	for _, tt := range []struct {
+		name     string
+		window   sync.Window
+		capped   bool
+		minTaken time.Duration
+	}{
+		// 60 calls at 200/s, less the first burst
+		{"in window", around, true, 200 * time.Millisecond},
+		{"out of window", away, false, 0},
+	} {
+		var inflight, maxInflight int64
+		syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+			n := atomic.AddInt64(&inflight, 1)
+			defer atomic.AddInt64(&inflight, -1)
+			for {
+				max := atomic.LoadInt64(&maxInflight)
+				if n <= max || atomic.CompareAndSwapInt64(&maxInflight, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			return nil
+		}
+		syncTask, err := sync.NewSyncTask(src, dst,
+			sync.WithConcurrency(8),
+			sync.WithSyncer(syncer),
+			sync.WithSchedule(sync.Schedule{Windows: []sync.Window{tt.window}, Location: time.UTC}),
+		)
+		if err != nil {
+			t.Fatalf("%s: can't create sync task: %v", tt.name, err)
+		}
+		start := time.Now()
+		if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("%s: can't sync: %v", tt.name, err)
+		}
+		took := time.Since(start)
+		if p := syncTask.Progress(); p.Synced != int64(len(keys)) {
+			t.Errorf("%s: want all %d keys synced, got %+v", tt.name, len(keys), p)
+		}
+		max := atomic.LoadInt64(&maxInflight)
+		switch {
+		case tt.capped && max > 2:
+			t.Errorf("%s: want at most 2 calls in flight, got %d", tt.name, max)
+		case !tt.capped && max < 2:
+			t.Errorf("%s: want the calls uncapped, got at most %d in flight", tt.name, max)
+		}
+		if took < tt.minTaken {
+			t.Errorf("%s: want the calls capped to take at least %v, took %v", tt.name, tt.minTaken, took)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ShardName derives the name of the i-th shard of filename, inserting the
+// shard index before the extensions of the file. For instance, shard 3 of
+// "synced.json.gz" is named "synced-003.json.gz".
+func ShardName(filename string, i int) string {
+	return suffixName(filename, fmt.Sprintf("%03d", i))
+}
+
+// suffixName inserts a suffix before the extensions of filename.
+func suffixName(filename, suffix string) string {
+	dir, base := filepath.Split(filename)
+	name, ext := base, ""
+	if idx := strings.Index(base, "."); idx > 0 {
+		name, ext = base[:idx], base[idx:]
+	}
+	return fmt.Sprintf("%s%s-%s%s", dir, name, suffix, ext)
+}
+
+// MergeShards concatenates the lines of each shard into dst, in the order
+// the shards are given. Since each line holds a full key, the result is
+// equivalent to the output of a single, unsharded encoder. Shards in the
+// binary or msgpack formats are decoded and written again in their format.
+func MergeShards(dst io.Writer, shards ...io.Reader) error {
+	readers := make([]*bufio.Reader, len(shards))
+	format := listing.JSON
+	for i, shard := range shards {
+		readers[i] = bufio.NewReader(shard)
+		if f := listing.Detect(readers[i]); f != listing.JSON {
+			format = f
+		}
+	}
+	if format != listing.JSON {
+		return mergeListings(dst, format, readers)
+	}
+
+	w := bufio.NewWriter(dst)
+	for i, rd := range readers {
+		for {
+			line, err := rd.ReadBytes('\n')
+			if len(line) != 0 {
+				if line[len(line)-1] != '\n' {
+					line = append(line, '\n')
+				}
+				if _, werr := w.Write(line); werr != nil {
+					return fmt.Errorf("writing line from shard %d: %v", i, werr)
+				}
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("reading shard %d: %v", i, err)
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// mergeListings copies the keys of each shard into a single listing.
+func mergeListings(dst io.Writer, format string, shards []*bufio.Reader) error {
+	w, err := listing.NewWriter(dst, format)
+	if err != nil {
+		return err
+	}
+	for i, shard := range shards {
+		if listing.Detect(shard) != format {
+			if _, err := shard.Peek(1); err == io.EOF {
+				// an empty shard, nothing was written to it
+				continue
+			}
+			return fmt.Errorf("shard %d isn't in the %s format", i, format)
+		}
+		rd, err := listing.NewReader(shard)
+		if err != nil {
+			return fmt.Errorf("reading shard %d: %v", i, err)
+		}
+		if _, err := listing.Copy(w, rd); err != nil {
+			return fmt.Errorf("copying shard %d: %v", i, err)
+		}
+	}
+	return w.Flush()
+}
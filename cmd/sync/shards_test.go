@@ -0,0 +1,88 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestShardName(t *testing.T) {
+	for _, tt := range []struct {
+		filename string
+		shard    int
+		want     string
+	}{
+		{"synced.json.gz", 0, "synced-000.json.gz"},
+		{"synced.json", 15, "synced-015.json"},
+		{"dir/failed.json.gz", 3, "dir/failed-003.json.gz"},
+		{"noext", 1, "noext-001"},
+	} {
+		got := sync.ShardName(tt.filename, tt.shard)
+		if got != tt.want {
+			t.Errorf("shard %d of %q: want %q, got %q", tt.shard, tt.filename, tt.want, got)
+		}
+	}
+}
+
+func TestMergeShards(t *testing.T) {
+	shards := []string{
+		"{\"Key\":\"a\"}\n{\"Key\":\"b\"}\n",
+		"",
+		"{\"Key\":\"c\"}", // missing trailing newline
+		"{\"Key\":\"d\"}\n",
+	}
+	var readers []io.Reader
+	for _, shard := range shards {
+		readers = append(readers, strings.NewReader(shard))
+	}
+
+	var out bytes.Buffer
+	err := sync.MergeShards(&out, readers...)
+	if err != nil {
+		t.Fatalf("can't merge: %v", err)
+	}
+
+	keys := decodeKeys(&out)
+	want := []string{"a", "b", "c", "d"}
+	if len(keys) != len(want) {
+		t.Fatalf("want %d keys, got %d", len(want), len(keys))
+	}
+	for i, key := range keys {
+		if key.Key != want[i] {
+			t.Errorf("key %d: want %q, got %q", i, want[i], key.Key)
+		}
+	}
+}
+
+func TestMergeMsgPackShards(t *testing.T) {
+	var shards []io.Reader
+	for _, names := range [][]string{{"a", "b"}, nil, {"c"}} {
+		var buf bytes.Buffer
+		w, _ := listing.NewWriter(&buf, listing.MsgPack)
+		for _, name := range names {
+			if err := w.Write(s3.Key{Key: name}); err != nil {
+				t.Fatalf("can't write shard: %v", err)
+			}
+		}
+		shards = append(shards, &buf)
+	}
+
+	var out bytes.Buffer
+	if err := sync.MergeShards(&out, shards...); err != nil {
+		t.Fatalf("can't merge: %v", err)
+	}
+	keys := readListing(t, &out)
+	want := []string{"a", "b", "c"}
+	if len(keys) != len(want) {
+		t.Fatalf("want %d keys, got %d", len(want), len(keys))
+	}
+	for i, key := range keys {
+		if key.Key != want[i] {
+			t.Errorf("key %d: want %q, got %q", i, want[i], key.Key)
+		}
+	}
+}
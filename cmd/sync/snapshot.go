@@ -0,0 +1,259 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Rates at which keys and their bytes are done, synced, failed or skipped,
+// and at which the sync calls are retried.
+type Rates struct {
+	Keys    float64 `json:"keys_per_s"`
+	Bytes   float64 `json:"bytes_per_s"`
+	Retries float64 `json:"retries_per_s,omitempty"`
+}
+
+// Snapshot of the progress of a task, written periodically to a file by
+// WriteSnapshots so that monitors can report on a running sync without
+// attaching to its logs.
+type Snapshot struct {
+	Time    time.Time `json:"time"`
+	Started time.Time `json:"started"`
+	// Elapsed seconds since the task started.
+	Elapsed float64 `json:"elapsed_s"`
+	// Done is set on the last snapshot of a task, once it returned.
+	Done bool `json:"done"`
+	// RunID of the task, when it has one.
+	RunID string `json:"run_id,omitempty"`
+	Progress
+	// Rate since the task started, and since the previous snapshot.
+	Rate       Rates `json:"rate"`
+	RecentRate Rates `json:"recent_rate"`
+	// InputRead is the number of bytes of the input consumed so far, out of
+	// InputSize. The fraction of the input consumed and the ETA are only
+	// known when InputSize is.
+	InputRead int64      `json:"input_read"`
+	InputSize int64      `json:"input_size,omitempty"`
+	Fraction  float64    `json:"fraction,omitempty"`
+	Remaining float64    `json:"remaining_s,omitempty"`
+	ETA       *time.Time `json:"eta,omitempty"`
+	// Latency of the first sync calls of the process, and of the retries,
+	// in milliseconds.
+	Latency      map[string]float64 `json:"latency"`
+	RetryLatency map[string]float64 `json:"retry_latency"`
+	// Slowest keys being synced, the slowest first, with SlowKeys.
+	Slowest []InflightKey `json:"slowest,omitempty"`
+	// Inputs are the progress of each file of an input of many, see
+	// WithInputs.
+	Inputs []InputProgress `json:"inputs,omitempty"`
+}
+
+// CountingReader counts the bytes read through it, to tell how much of an
+// input was consumed. It's safe to call Count while reading.
+type CountingReader struct {
+	r io.Reader
+	n *int64
+}
+
+// NewCountingReader counts the bytes read from r.
+func NewCountingReader(r io.Reader) *CountingReader { return &CountingReader{r: r, n: new(int64)} }
+
+// Counting counts the bytes read from r with those of c, for an input of
+// many files.
+func (c *CountingReader) Counting(r io.Reader) *CountingReader { return &CountingReader{r: r, n: c.n} }
+
+func (c *CountingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// Count of the bytes read so far.
+func (c *CountingReader) Count() int64 { return atomic.LoadInt64(c.n) }
+
+// snapshotter takes snapshots of a task, remembering the previous one to
+// compute the recent rates.
+type snapshotter struct {
+	task      *SyncTask
+	started   time.Time
+	input     *CountingReader
+	inputSize int64
+	prev      Snapshot
+}
+
+func (s *snapshotter) take(now time.Time) Snapshot {
+	snap := Snapshot{
+		Time:     now,
+		Started:  s.started,
+		Elapsed:  now.Sub(s.started).Seconds(),
+		Progress: s.task.Progress(),
+		Latency:  Latency.Overall().Summarize().Millis(),
+		RunID:    s.task.RunID,
+
+		RetryLatency: RetryLatency.Overall().Summarize().Millis(),
+	}
+	if s.inputSize > 0 {
+		snap.InputSize = s.inputSize
+	}
+	if s.task.SlowKeys != nil {
+		snap.Slowest = s.task.Slowest(s.task.SlowKeys.Top)
+	}
+	if s.input != nil {
+		snap.InputRead = s.input.Count()
+	}
+	keys := snap.Synced + snap.Failed + snap.Skipped
+	snap.Rate = rates(keys, snap.Bytes, snap.Elapsed)
+	snap.Rate.Retries = perSecond(snap.Retries, snap.Elapsed)
+
+	prevKeys := s.prev.Synced + s.prev.Failed + s.prev.Skipped
+	recent := now.Sub(s.prev.Time).Seconds()
+	snap.RecentRate = rates(keys-prevKeys, snap.Bytes-s.prev.Bytes, recent)
+	snap.RecentRate.Retries = perSecond(snap.Retries-s.prev.Retries, recent)
+
+	if snap.InputSize > 0 {
+		snap.Fraction = float64(snap.InputRead) / float64(snap.InputSize)
+		// the input is read ahead of the keys being sync'd, by the size of
+		// the buffers, which is negligible on the listings worth a snapshot
+		if snap.Fraction > 0 && snap.Fraction < 1 {
+			snap.Remaining = snap.Elapsed * (1 - snap.Fraction) / snap.Fraction
+			eta := now.Add(time.Duration(snap.Remaining * float64(time.Second)))
+			snap.ETA = &eta
+		}
+	}
+	s.prev = snap
+	return snap
+}
+
+func rates(keys, bytes int64, seconds float64) Rates {
+	if seconds <= 0 {
+		return Rates{}
+	}
+	return Rates{Keys: float64(keys) / seconds, Bytes: float64(bytes) / seconds}
+}
+
+func perSecond(n int64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(n) / seconds
+}
+
+// WriteSnapshots writes a snapshot of the progress of the task to filename
+// every interval, atomically replacing the previous one. The input, if not
+// nil, counts the bytes of the task's input that were read out of
+// inputSize, from which the ETA is extrapolated when the size is known. The
+// returned func writes a last snapshot, marked done, then stops writing.
+func (s *SyncTask) WriteSnapshots(filename string, every time.Duration, input *CountingReader, inputSize int64) func() {
+	return s.takeSnapshots(every, input, inputSize, func(snap Snapshot) {
+		if err := WriteSnapshot(filename, snap); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":    err,
+				"filename": filename,
+			}).Error("failed to write progress snapshot")
+		}
+	})
+}
+
+// ProgressEvent is the name of the events of a stream that hold the
+// snapshots of a task, see StreamSnapshots.
+const ProgressEvent = "progress"
+
+// StreamedSnapshot is a snapshot of a task published to an event stream,
+// with the name of the task to tell apart the tasks of a fan-out or fan-in.
+type StreamedSnapshot struct {
+	Name string `json:"name"`
+	Snapshot
+}
+
+// StreamSnapshots publishes a snapshot of the progress of the task to the
+// stream every interval, as a ProgressEvent, like WriteSnapshots does to a
+// file. The returned func publishes a last snapshot, marked done, then
+// stops publishing.
+func (s *SyncTask) StreamSnapshots(stream *events.Stream, name string, every time.Duration, input *CountingReader, inputSize int64) func() {
+	return s.takeSnapshots(every, input, inputSize, func(snap Snapshot) {
+		stream.Publish(ProgressEvent, StreamedSnapshot{Name: name, Snapshot: snap})
+	})
+}
+
+// takeSnapshots of the task every interval, and a last one marked done
+// once the returned func is called.
+func (s *SyncTask) takeSnapshots(every time.Duration, input *CountingReader, inputSize int64, fn func(Snapshot)) func() {
+	now := time.Now()
+	snaps := &snapshotter{
+		task:      s,
+		started:   now,
+		input:     input,
+		inputSize: inputSize,
+		prev:      Snapshot{Time: now},
+	}
+	take := func(done bool) {
+		snap := snaps.take(time.Now())
+		snap.Done = done
+		fn(snap)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				take(false)
+			case <-stop:
+				take(true)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// WriteSnapshot writes a snapshot to filename, atomically replacing the
+// previous one so that readers never see a partial snapshot.
+func WriteSnapshot(filename string, snap Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// ReadSnapshot reads the snapshot written to filename.
+func ReadSnapshot(filename string) (Snapshot, error) {
+	var snap Snapshot
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("decoding snapshot: %v", err)
+	}
+	return snap, nil
+}
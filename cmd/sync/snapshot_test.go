@@ -0,0 +1,86 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSnapshots(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "progress.json")
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:50]
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 2
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		time.Sleep(time.Millisecond)
+		if key.Key == keys[0].Key {
+			return &s3.Error{Code: "AccessDenied"}
+		}
+		return nil
+	}
+
+	input := encodeKeys(keys)
+	size := int64(input.Len())
+	counter := sync.NewCountingReader(input)
+	stop := syncTask.WriteSnapshots(filename, 5*time.Millisecond, counter, size)
+	if err := syncTask.Start(counter, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	// snapshots are written while the task runs
+	running, err := sync.ReadSnapshot(filename)
+	if err != nil {
+		t.Fatalf("can't read snapshot of running task: %v", err)
+	}
+	if running.Done {
+		t.Errorf("want the snapshot of a running task not done")
+	}
+
+	stop()
+	snap, err := sync.ReadSnapshot(filename)
+	if err != nil {
+		t.Fatalf("can't read last snapshot: %v", err)
+	}
+	if !snap.Done {
+		t.Errorf("want the last snapshot done")
+	}
+	if snap.Synced != int64(len(keys)-1) || snap.Failed != 1 {
+		t.Errorf("want %d synced and 1 failed, got %+v", len(keys)-1, snap.Progress)
+	}
+	if snap.InputRead != size || snap.InputSize != size || snap.Fraction != 1 {
+		t.Errorf("want all %d bytes of the input read, got %d of %d (%v)", size, snap.InputRead, snap.InputSize, snap.Fraction)
+	}
+	if snap.Rate.Keys <= 0 || snap.Elapsed <= 0 {
+		t.Errorf("want a positive rate over the elapsed time, got %v keys/s over %vs", snap.Rate.Keys, snap.Elapsed)
+	}
+	if snap.Latency["count"] == 0 {
+		t.Errorf("want latencies in the snapshot, got %v", snap.Latency)
+	}
+	if matches, _ := filepath.Glob(filename + ".tmp*"); len(matches) != 0 {
+		t.Errorf("want no temporary files left, got %v", matches)
+	}
+}
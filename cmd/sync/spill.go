@@ -0,0 +1,170 @@
+package sync
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outputWarnEvery is how often the sync workers waiting on the outputs are
+// warned about, at most.
+var outputWarnEvery = time.Minute
+
+// spill holds the keys that didn't fit in the queue of an output in a
+// temporary file, until the output is done with its queue. The file is
+// only created once a key spills.
+type spill struct {
+	dir, name string
+
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+	// err of the file, which then takes no more keys
+	err error
+}
+
+func newSpill(dir, name string) *spill { return &spill{dir: dir, name: name} }
+
+// put v in the spill, false if it couldn't be written.
+func (sp *spill) put(v interface{}) bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.err != nil {
+		return false
+	}
+	if sp.file == nil {
+		sp.file, sp.err = ioutil.TempFile(sp.dir, "brigade-spill-"+sp.name)
+		if sp.err != nil {
+			logrus.WithFields(logrus.Fields{
+				"dir":   sp.dir,
+				"error": sp.err,
+			}).Error("couldn't create spill file, waiting on the outputs instead")
+			return false
+		}
+		logrus.WithField("filename", sp.file.Name()).Warn("outputs fall behind, spilling keys to disk")
+		sp.buf = bufio.NewWriter(sp.file)
+		sp.enc = json.NewEncoder(sp.buf)
+	}
+	if sp.err = sp.enc.Encode(v); sp.err != nil {
+		logrus.WithField("error", sp.err).Error("couldn't spill key, waiting on the outputs instead")
+		return false
+	}
+	return true
+}
+
+// replay the spilled values, decoding each with decode, then remove the
+// spill file.
+func (sp *spill) replay(decode func(dec *json.Decoder) error) error {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if sp.file == nil {
+		return nil
+	}
+	defer func() {
+		_ = sp.file.Close()
+		_ = os.Remove(sp.file.Name())
+		sp.file = nil
+	}()
+	if err := sp.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := sp.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(sp.file))
+	for {
+		switch err := decode(dec); err {
+		case nil:
+		case io.EOF:
+			return nil
+		default:
+			return fmt.Errorf("reading spill file: %v", err)
+		}
+	}
+}
+
+// sendSynced queues a synced key for the synced outputs. When they fall
+// behind and their queue is full, the key spills to disk if the task has a
+// SpillDir, or the worker waits for them.
+func (s *SyncTask) sendSynced(synced chan<- s3.Key, key s3.Key) {
+	if s.reorder != nil {
+		// written in order once the keys before it are done
+		s.reorder.synced(key)
+		return
+	}
+	s.queued(1)
+	select {
+	case synced <- key:
+		return
+	default:
+	}
+	if s.spillSynced != nil && s.spillSynced.put(key) {
+		s.spilled()
+		return
+	}
+	start := time.Now()
+	synced <- key
+	s.waitedOnOutputs(time.Since(start))
+}
+
+// sendFailed queues a failure for the failed outputs, like sendSynced.
+func (s *SyncTask) sendFailed(failed chan<- listing.Failure, f listing.Failure) {
+	f.RunID = s.RunID
+	s.queued(1)
+	select {
+	case failed <- f:
+		return
+	default:
+	}
+	if s.spillFailed != nil && s.spillFailed.put(f) {
+		s.spilled()
+		return
+	}
+	start := time.Now()
+	failed <- f
+	s.waitedOnOutputs(time.Since(start))
+}
+
+func (s *SyncTask) queued(n int64) {
+	metrics.outputQueued.Add(n)
+	s.stats.outputQueued.Add(n)
+}
+
+func (s *SyncTask) spilled() {
+	metrics.outputSpilled.Add(1)
+	s.stats.spilled.Add(1)
+}
+
+// waitedOnOutputs records how long a worker waited on the outputs, warning
+// that they fall behind every outputWarnEvery.
+func (s *SyncTask) waitedOnOutputs(waited time.Duration) {
+	metrics.secondsWaitingOutputs.Add(waited.Seconds())
+	s.stats.outputWait.Add(int64(waited))
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&s.lastOutputWarn)
+	if now-last < int64(outputWarnEvery) || !atomic.CompareAndSwapInt64(&s.lastOutputWarn, last, now) {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"waited":       waited,
+		"total_waited": time.Duration(s.stats.outputWait.Value()),
+	}).Warn("sync workers are waiting on the outputs, which fall behind; a spill dir would let them go on")
+}
+
+// wrote records that an output wrote a queued value, in elapsed.
+func (s *SyncTask) wrote(elapsed time.Duration) {
+	s.queued(-1)
+	metrics.outputWrites.Add(1)
+	metrics.secondsWritingOutputs.Add(elapsed.Seconds())
+}
@@ -0,0 +1,89 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// blockedWriter doesn't write until it's released.
+type blockedWriter struct {
+	bytes.Buffer
+	release chan struct{}
+}
+
+func (b *blockedWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return b.Buffer.Write(p)
+}
+
+func TestSyncSpill(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for i := 0; i < 200; i++ {
+		name := "key-" + strconv.Itoa(i)
+		if err := src.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+		keys = append(keys, s3.Key{Key: name})
+	}
+
+	dir, err := ioutil.TempDir("", "brigade-spill-test")
+	if err != nil {
+		t.Fatalf("can't create spill dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(1), sync.WithSpillDir(dir))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+
+	// the output is held up until all the keys are synced, which they can
+	// only be by spilling
+	synced := &blockedWriter{release: make(chan struct{})}
+	go func() {
+		for syncTask.Progress().Synced < int64(len(keys)) {
+			time.Sleep(time.Millisecond)
+		}
+		close(synced.release)
+	}()
+	if err := syncTask.Start(encodeKeys(keys), synced, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	if got := decodeKeys(&synced.Buffer); len(got) != len(keys) {
+		t.Errorf("want %d keys in the synced output, got %d", len(keys), len(got))
+	}
+	progress := syncTask.Progress()
+	if progress.Spilled == 0 {
+		t.Errorf("want keys spilled")
+	}
+	if progress.OutputQueued != 0 {
+		t.Errorf("want no key queued for the outputs once done, got %d", progress.OutputQueued)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("want spill files removed, got %d", len(files))
+	}
+
+	if _, err := sync.NewSyncTask(src, dst, sync.WithSpillDir(dir+"/missing")); err == nil {
+		t.Errorf("want a missing spill dir invalid")
+	}
+}
@@ -0,0 +1,70 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestSyncCustomerKeys(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	// the source and destination are encrypted with different keys
+	srcS3, dstS3 := *mocks3.S3(), *mocks3.S3()
+	srcS3.CustomerKey = bytes.Repeat([]byte{1}, 32)
+	dstS3.CustomerKey = bytes.Repeat([]byte{2}, 32)
+	src := srcS3.Bucket("src-bucket")
+	dst := dstS3.Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	keys := []s3.Key{{Key: "copied", Size: 6}, {Key: "uploaded", Size: 8}}
+	for _, key := range keys {
+		if err := src.Put(key.Key, []byte(key.Key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key.Key, err)
+		}
+	}
+	// objects encrypted with SSE-C can't be read without their key
+	if _, err := mocks3.S3().Bucket("src-bucket").Get("copied"); err == nil {
+		t.Fatalf("want the source keys only readable with their key")
+	}
+
+	for _, tt := range []struct {
+		key    s3.Key
+		syncer sync.SyncerFunc
+	}{
+		{keys[0], sync.PutCopySyncer},
+		{keys[1], sync.GetPutSyncer},
+	} {
+		syncTask, err := sync.NewSyncTask(src, dst, sync.WithSyncer(tt.syncer))
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		if err := syncTask.Start(encodeKeys([]s3.Key{tt.key}), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		if failed := syncTask.Progress().Failed; failed != 0 {
+			t.Fatalf("want %q synced, %d keys failed", tt.key.Key, failed)
+		}
+
+		data, err := dst.Get(tt.key.Key)
+		if err != nil {
+			t.Fatalf("can't get %q with the key of the destination: %v", tt.key.Key, err)
+		}
+		if string(data) != tt.key.Key {
+			t.Errorf("want %q, got %q", tt.key.Key, data)
+		}
+		if _, err := src.Bucket("dst-bucket").Get(tt.key.Key); err == nil {
+			t.Errorf("want %q encrypted with the key of the destination", tt.key.Key)
+		}
+	}
+}
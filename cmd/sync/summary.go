@@ -0,0 +1,281 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aybabtme/humanize"
+	"github.com/pushrax/goamz/s3"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultProgressEvery is the interval over which the peak throughput is
+// measured, unless the task has a ProgressEvery.
+const defaultProgressEvery = time.Second
+
+// Summary of a task once it's done, with the numbers that tell how a sync
+// went at a glance.
+type Summary struct {
+	// RunID of the task, when it has one.
+	RunID string `json:"run_id,omitempty"`
+	// Keys done, synced, failed or skipped.
+	Keys    int64         `json:"keys"`
+	Synced  int64         `json:"synced"`
+	Failed  int64         `json:"failed"`
+	Skipped int64         `json:"skipped"`
+	Bytes   int64         `json:"bytes"`
+	Retries int64         `json:"retries"`
+	Elapsed time.Duration `json:"elapsed"`
+	// Rate over the whole task, and the highest over a progress interval,
+	// a second by default.
+	Rate     Rates `json:"rate"`
+	PeakRate Rates `json:"peak_rate"`
+	// Failures of the keys that failed, by S3 error code or kind of error.
+	Failures map[string]int64 `json:"failures"`
+	// Parallelism is how many sync calls were in flight on average, out of
+	// the Workers of the task.
+	Parallelism float64 `json:"parallelism"`
+	Workers     int     `json:"workers"`
+	// Reconciliation of the key counts of the destination, once the task
+	// ran ReconcileCounts.
+	Reconciliation *Reconciliation `json:"reconciliation,omitempty"`
+	// Dedupe totals of the keys synced holding the same content, once the
+	// task ran DedupeKeys.
+	Dedupe *DedupeReport `json:"dedupe,omitempty"`
+}
+
+// errorClass of the error of a key, its S3 error code or the kind of error.
+func errorClass(err error) string {
+	switch err {
+	case ErrSyncTimeout:
+		return "timeout"
+	case ErrCollision:
+		return "collision"
+	case ErrExists:
+		return "exists"
+	}
+	if e, ok := err.(*s3.Error); ok && e.Code != "" {
+		return e.Code
+	}
+	return "unexpected error"
+}
+
+// summary of a task, kept up to date as it runs.
+type summary struct {
+	mu       sync.Mutex
+	started  time.Time
+	finished time.Time
+	peak     Rates
+	failures map[string]int64
+	// set by ReconcileCounts
+	reconciliation *Reconciliation
+	// set by DedupeKeys
+	dedupe *DedupeReport
+}
+
+func (s *summary) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures == nil {
+		s.failures = make(map[string]int64)
+	}
+	s.failures[errorClass(err)]++
+}
+
+// trackPeak measures the throughput of the task every ProgressEvery, until
+// done is closed, keeping the highest, and logs it with LogProgress.
+func (s *SyncTask) trackPeak(done <-chan struct{}) {
+	every := s.ProgressEvery
+	if every <= 0 {
+		every = defaultProgressEvery
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	var lastKeys, lastBytes, lastRetries int64
+	last := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick.C:
+			p := s.Progress()
+			keys := p.Synced + p.Failed + p.Skipped
+			r := rates(keys-lastKeys, p.Bytes-lastBytes, now.Sub(last).Seconds())
+			r.Retries = perSecond(p.Retries-lastRetries, now.Sub(last).Seconds())
+			lastKeys, lastBytes, lastRetries, last = keys, p.Bytes, p.Retries, now
+			s.summary.mu.Lock()
+			if r.Keys > s.summary.peak.Keys {
+				s.summary.peak.Keys = r.Keys
+			}
+			if r.Bytes > s.summary.peak.Bytes {
+				s.summary.peak.Bytes = r.Bytes
+			}
+			s.summary.mu.Unlock()
+			if s.LogProgress {
+				logrus.WithFields(logrus.Fields{
+					"synced":        p.Synced,
+					"failed":        p.Failed,
+					"skipped":       p.Skipped,
+					"inflight":      p.Inflight,
+					"retries":       p.Retries,
+					"keys_per_s":    r.Keys,
+					"bytes_per_s":   r.Bytes,
+					"retries_per_s": r.Retries,
+					"latency_p95":   p.Latency["p95_ms"],
+					"retry_p95":     p.RetryLatency["p95_ms"],
+				}).Info("sync progress")
+			}
+		}
+	}
+}
+
+// Summary of the task, up to now if it's still running.
+func (s *SyncTask) Summary() Summary {
+	p := s.Progress()
+	sum := Summary{
+		Keys:     p.Synced + p.Failed + p.Skipped,
+		Synced:   p.Synced,
+		Failed:   p.Failed,
+		Skipped:  p.Skipped,
+		Bytes:    p.Bytes,
+		Retries:  p.Retries,
+		Failures: make(map[string]int64),
+		Workers:  s.SyncPara,
+		RunID:    s.RunID,
+	}
+
+	s.summary.mu.Lock()
+	started, finished := s.summary.started, s.summary.finished
+	sum.PeakRate = s.summary.peak
+	sum.Reconciliation = s.summary.reconciliation
+	sum.Dedupe = s.summary.dedupe
+	for class, n := range s.summary.failures {
+		sum.Failures[class] = n
+	}
+	s.summary.mu.Unlock()
+
+	if started.IsZero() {
+		return sum
+	}
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	sum.Elapsed = finished.Sub(started)
+	sum.Rate = rates(sum.Keys, sum.Bytes, sum.Elapsed.Seconds())
+	// tasks shorter than a peak interval only have their average
+	if sum.Rate.Keys > sum.PeakRate.Keys {
+		sum.PeakRate.Keys = sum.Rate.Keys
+	}
+	if sum.Rate.Bytes > sum.PeakRate.Bytes {
+		sum.PeakRate.Bytes = sum.Rate.Bytes
+	}
+
+	var busy time.Duration
+	for _, w := range s.Breakdown().Workers {
+		busy += w.Latency
+	}
+	if sum.Elapsed > 0 {
+		sum.Parallelism = busy.Seconds() / sum.Elapsed.Seconds()
+	}
+	return sum
+}
+
+// String formats the summary as a block of lines, for people to read.
+func (sum Summary) String() string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "keys:\t%s (%s synced, %s failed, %s skipped)\n",
+		humanize.Comma(sum.Keys), humanize.Comma(sum.Synced), humanize.Comma(sum.Failed), humanize.Comma(sum.Skipped))
+	fmt.Fprintf(tw, "bytes:\t%s\n", humanize.Bytes(uint64(sum.Bytes)))
+	fmt.Fprintf(tw, "elapsed:\t%v\n", sum.Elapsed-sum.Elapsed%time.Second)
+	fmt.Fprintf(tw, "throughput:\t%s average, %s peak\n", formatRate(sum.Rate), formatRate(sum.PeakRate))
+	fmt.Fprintf(tw, "retries:\t%s\n", humanize.Comma(sum.Retries))
+	fmt.Fprintf(tw, "failures:\t%s\n", formatFailures(sum.Failures))
+	fmt.Fprintf(tw, "parallelism:\t%.1f of %d workers\n", sum.Parallelism, sum.Workers)
+	if sum.Reconciliation != nil {
+		fmt.Fprintf(tw, "key counts:\t%s\n", formatReconciliation(*sum.Reconciliation))
+	}
+	if d := sum.Dedupe; d != nil {
+		fmt.Fprintf(tw, "duplicates:\t%s of %s keys tracked hold the content of another, %s reclaimable\n",
+			humanize.Comma(d.Duplicates), humanize.Comma(d.Tracked), humanize.Bytes(uint64(d.Reclaimable)))
+	}
+	_ = tw.Flush()
+	return buf.String()
+}
+
+// formatReconciliation tells whether the prefixes counted hold the keys
+// expected, listing the first few that don't.
+func formatReconciliation(r Reconciliation) string {
+	out := fmt.Sprintf("%d of %d prefixes counted", r.Counted, r.Prefixes)
+	if r.Errors > 0 {
+		out += fmt.Sprintf(", %d couldn't be", r.Errors)
+	}
+	if len(r.Discrepancies) == 0 {
+		return out + ", all hold the keys expected"
+	}
+	var short []string
+	for i, d := range r.Discrepancies {
+		if i == 3 {
+			short = append(short, "...")
+			break
+		}
+		short = append(short, fmt.Sprintf("%q %s of %s", d.Prefix, humanize.Comma(d.Found), humanize.Comma(d.Expected)))
+	}
+	return fmt.Sprintf("%s, %d short of keys: %s", out, len(r.Discrepancies), strings.Join(short, ", "))
+}
+
+func formatRate(r Rates) string {
+	return fmt.Sprintf("%.1f keys/s (%s/s)", r.Keys, humanize.Bytes(uint64(r.Bytes)))
+}
+
+// formatFailures lists the classes of failures, the most frequent first.
+func formatFailures(failures map[string]int64) string {
+	if len(failures) == 0 {
+		return "none"
+	}
+	classes := make([]string, 0, len(failures))
+	for class := range failures {
+		classes = append(classes, class)
+	}
+	sort.Sort(byFrequency{classes, failures})
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s %s", class, humanize.Comma(failures[class]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type byFrequency struct {
+	classes []string
+	counts  map[string]int64
+}
+
+func (b byFrequency) Len() int      { return len(b.classes) }
+func (b byFrequency) Swap(i, j int) { b.classes[i], b.classes[j] = b.classes[j], b.classes[i] }
+func (b byFrequency) Less(i, j int) bool {
+	ci, cj := b.counts[b.classes[i]], b.counts[b.classes[j]]
+	if ci != cj {
+		return ci > cj
+	}
+	return b.classes[i] < b.classes[j]
+}
+
+// startSummary records when the task started, and tracks its peak
+// throughput until the returned func records when it finished.
+func (s *SyncTask) startSummary() func() {
+	s.summary.mu.Lock()
+	s.summary.started = time.Now()
+	s.summary.mu.Unlock()
+	done := make(chan struct{})
+	go s.trackPeak(done)
+	return func() {
+		close(done)
+		s.summary.mu.Lock()
+		s.summary.finished = time.Now()
+		s.summary.mu.Unlock()
+	}
+}
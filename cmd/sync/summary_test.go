@@ -0,0 +1,69 @@
+package sync_test
+
+import (
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncSummary(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, name := range []string{"a", "b", "c", "denied", "slow"} {
+		keys = append(keys, s3.Key{Key: name, Size: 1000})
+	}
+
+	syncer := func(src, dst *s3.Bucket, key s3.Key) error {
+		switch key.Key {
+		case "denied":
+			return &s3.Error{Code: "AccessDenied"}
+		case "slow":
+			time.Sleep(50 * time.Millisecond)
+			return &s3.Error{Code: "AccessDenied"}
+		}
+		return nil
+	}
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithConcurrency(2), sync.WithRetry(1, time.Millisecond), sync.WithSyncer(syncer))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	sum := syncTask.Summary()
+	if sum.Keys != 5 || sum.Synced != 3 || sum.Failed != 2 || sum.Bytes != 3000 {
+		t.Errorf("want 5 keys, 3 synced for 3000 bytes, got %+v", sum)
+	}
+	if sum.Failures["AccessDenied"] != 2 {
+		t.Errorf("want the failures by error code, got %v", sum.Failures)
+	}
+	if sum.Elapsed <= 0 || sum.PeakRate.Keys < sum.Rate.Keys {
+		t.Errorf("want an elapsed time and a peak at least the average, got %+v", sum)
+	}
+	if sum.Workers != 2 || sum.Parallelism <= 0 || sum.Parallelism > 2 {
+		t.Errorf("want a parallelism of at most 2 workers, got %v", sum.Parallelism)
+	}
+
+	report := sum.String()
+	for _, want := range []string{"5 (3 synced, 2 failed, 0 skipped)", "3.0KB", "AccessDenied 2", "of 2 workers"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("want %q in the summary, got:\n%s", want, report)
+		}
+	}
+}
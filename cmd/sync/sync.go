@@ -2,14 +2,25 @@ package sync
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"expvar"
 	"fmt"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Shopify/brigade/cmd/state"
 	"github.com/Sirupsen/logrus"
 	"github.com/pushrax/goamz/s3"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,28 +35,137 @@ var (
 	// BufferFactor of decode/sync channels,
 	// which are BufferFactor-times bigger than their
 	// parallelism.
-	BufferFactor = 10
+	BufferFactor = pipeline.BufferFactor
+
+	// Latency of the first call to sync a key, shared by all the tasks of
+	// the process, overall and per minute.
+	Latency = monitor.NewRecorder(time.Minute)
+	// RetryLatency of the calls retrying a key, kept apart from Latency so
+	// that its quantiles tell how S3 answers rather than how it answers the
+	// keys that already failed. The backoff between the calls isn't part of
+	// either.
+	RetryLatency = monitor.NewRecorder(time.Minute)
 )
 
+func init() {
+	expvar.Publish("brigade.sync.latency", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"overall":       Latency.Overall().Summarize().Millis(),
+			"last_interval": Latency.LastInterval().Summarize().Millis(),
+		}
+	}))
+	expvar.Publish("brigade.sync.retry_latency", expvar.Func(func() interface{} {
+		return map[string]interface{}{
+			"overall":       RetryLatency.Overall().Summarize().Millis(),
+			"last_interval": RetryLatency.LastInterval().Summarize().Millis(),
+		}
+	}))
+}
+
+// ErrSyncTimeout is the error of a sync call that took longer than the
+// Timeout of its task. It's retried like any unexpected error.
+var ErrSyncTimeout = errors.New("sync call timed out")
+
 // SyncerFunc syncs an s3.Key from a source to a destination bucket.
 type SyncerFunc func(src *s3.Bucket, dst *s3.Bucket, key s3.Key) error
 
+// Attempt is a call of a ContextSyncerFunc to sync a key.
+type Attempt struct {
+	// Number of the attempt, from 1.
+	Number int
+	// Deadline after which the call is given up on with ErrSyncTimeout,
+	// zero if the task has no Timeout.
+	Deadline time.Time
+	// PrevErr is the error of the previous attempt, nil for the first.
+	PrevErr error
+	// Hedge is set for the second call of a hedged attempt, see Hedging.
+	Hedge bool
+}
+
+// ContextSyncerFunc syncs an s3.Key from a source to a destination bucket,
+// like a SyncerFunc, knowing which attempt the call is. The context is done
+// once the call is given up on, at the Deadline of the attempt, so that the
+// syncer can abort it rather than carry on in the background.
+type ContextSyncerFunc func(ctx context.Context, attempt Attempt, src *s3.Bucket, dst *s3.Bucket, key s3.Key) error
+
+// CopyFunc copies an s3.Key from a source to a destination bucket, naming
+// it dstKey at the destination.
+type CopyFunc func(src *s3.Bucket, dst *s3.Bucket, key s3.Key, dstKey string) error
+
+// Renamed syncs keys with copier, naming them at the destination as rename
+// says.
+func Renamed(copier CopyFunc, rename func(key string) string) SyncerFunc {
+	return func(src, dst *s3.Bucket, key s3.Key) error {
+		return copier(src, dst, key, rename(key.Key))
+	}
+}
+
 // PutCopySyncer does a PutCopy call to S3, copying a key from src to dst
 // if both are in the same region.
 func PutCopySyncer(src, dst *s3.Bucket, key s3.Key) error {
-	_, err := dst.PutCopy(key.Key, ACLForKey(src, key), s3.CopyOptions{}, src.Name+"/"+key.Key)
+	return PutCopy(src, dst, key, key.Key)
+}
+
+// PutCopy is the CopyFunc of PutCopySyncer.
+func PutCopy(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+	opts, err := copyOptions(src, key)
+	if err != nil {
+		return err
+	}
+	_, err = dst.PutCopy(dstKey, ACLForKey(src, key), opts, CopySource(src.Name, key.Key))
 	return err
 }
 
+// copyOptions are the options to copy a key from src.
+func copyOptions(src *s3.Bucket, key s3.Key) (s3.CopyOptions, error) {
+	if PreserveMTime {
+		return stampedCopyOptions(src, key)
+	}
+	redirect, err := RedirectForKey(src, key)
+	if err != nil {
+		return s3.CopyOptions{}, err
+	}
+	return s3.CopyOptions{
+		Options:               s3.Options{RedirectLocation: redirect},
+		RequesterPays:         src.RequesterPays,
+		CopySourceCustomerKey: src.CustomerKey,
+	}, nil
+}
+
 // GetPutSyncer does a GET, then a PUT on the key, streaming the GET reader
-// to the PUT writer with a buffer.
+// to the PUT writer with a buffer. Unlike PutCopySyncer, it works across
+// regions and accounts, since the data goes through this host.
 func GetPutSyncer(src, dst *s3.Bucket, key s3.Key) error {
-	rd, err := src.GetReader(key.Key)
+	return GetPut(src, dst, key, key.Key)
+}
+
+// GetPut is the CopyFunc of GetPutSyncer.
+func GetPut(src, dst *s3.Bucket, key s3.Key, dstKey string) error {
+	return getPut(src, dst, key, dstKey, func(r io.Reader) io.Reader { return bufio.NewReader(r) })
+}
+
+// getPut uploads the body of the GET of a key as read through wrap.
+func getPut(src, dst *s3.Bucket, key s3.Key, dstKey string, wrap func(io.Reader) io.Reader) error {
+	var redirect string
+	if !PreserveMTime {
+		var err error
+		if redirect, err = RedirectForKey(src, key); err != nil {
+			return err
+		}
+	}
+	resp, err := src.GetResponse(key.Key)
 	if err != nil {
 		return err
 	}
-	bufrd := bufio.NewReader(rd)
-	return src.PutReader(key.Key, bufrd, key.Size, "", ACLForKey(src, key), s3.Options{})
+	defer func() { _ = resp.Body.Close() }()
+	contentType, opts := "", s3.Options{RedirectLocation: redirect}
+	if PreserveMTime {
+		if opts, err = stampedOptions(resp.Header, key); err != nil {
+			return err
+		}
+		contentType = resp.Header.Get("Content-Type")
+	}
+	return dst.PutReader(dstKey, wrap(resp.Body), key.Size, contentType, ACLForKey(src, key), opts)
 }
 
 var ACLForKey func(bkt *s3.Bucket, k s3.Key) s3.ACL = S3ACLForKey
@@ -62,6 +182,30 @@ func S3ACLForKey(bkt *s3.Bucket, k s3.Key) s3.ACL {
 	return s3.PublicRead
 }
 
+// RedirectForKey returns the website redirect location of a key, which S3
+// doesn't copy along with the rest of the metadata. By default, keys are
+// assumed to have none.
+var RedirectForKey func(bkt *s3.Bucket, k s3.Key) (string, error) = NoRedirectForKey
+
+func NoRedirectForKey(bkt *s3.Bucket, k s3.Key) (string, error) {
+	return "", nil
+}
+
+// S3RedirectForKey does a HEAD on the key to get its redirect location.
+func S3RedirectForKey(bkt *s3.Bucket, k s3.Key) (string, error) {
+	resp, err := bkt.Head(k.Key, nil)
+	if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+		// HEAD responses have no body, so the error has no code
+		e.Code = s3.ErrNoSuchKey
+		return "", e
+	}
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+	return resp.Header.Get("x-amz-website-redirect-location"), nil
+}
+
 func keyIsPrivate(bkt *s3.Bucket, k s3.Key) bool {
 	resp, err := bkt.GetPermissions(k.Key)
 	if err != nil {
@@ -80,8 +224,40 @@ func keyIsPrivate(bkt *s3.Bucket, k s3.Key) bool {
 	return true
 }
 
-// NewSyncTask creates a sync task that will sync keys from src onto dst.
-func NewSyncTask(src, dst *s3.Bucket) (*SyncTask, error) {
+// NewSyncTask creates a sync task that will sync keys from src onto dst,
+// configured by the options. It fails if the options are invalid, or if the
+// buckets can't be listed.
+func NewSyncTask(src, dst *s3.Bucket, opts ...Option) (*SyncTask, error) {
+	s := &SyncTask{
+		RetryBase:  time.Second,
+		MaxRetry:   50,
+		DecodePara: runtime.NumCPU(),
+		DecodeMax:  4 * runtime.NumCPU(),
+		SyncPara:   1000,
+		Sync:       PutCopySyncer,
+
+		src:       src,
+		dst:       dst,
+		ctl:       newControl(),
+		stats:     newTaskStats(),
+		breakdown: newBreakdown(),
+
+		inflightKeys: newInflightKeys(),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("invalid sync task option: %v", err)
+		}
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid sync task options: %v", err)
+	}
+	if s.faults != nil {
+		s.Sync = InjectFaults(s.Sync, *s.faults)
+		if s.SyncContext != nil {
+			s.SyncContext = InjectFaultsContext(s.SyncContext, *s.faults)
+		}
+	}
 
 	// before starting the sync, make sure our s3 object is usable (credentials and such)
 	_, err := src.List("/", "/", "/", 1)
@@ -93,17 +269,7 @@ func NewSyncTask(src, dst *s3.Bucket) (*SyncTask, error) {
 	if err != nil {
 		return nil, fmt.Errorf("couldn't list destination bucket %q: %v", dst.Name, err)
 	}
-
-	return &SyncTask{
-		RetryBase:  time.Second,
-		MaxRetry:   50,
-		DecodePara: runtime.NumCPU(),
-		SyncPara:   1000,
-		Sync:       PutCopySyncer,
-
-		src: src,
-		dst: dst,
-	}, nil
+	return s, nil
 }
 
 // SyncTask synchronizes keys between two buckets.
@@ -114,13 +280,238 @@ type SyncTask struct {
 	SyncPara   int
 	Sync       SyncerFunc
 
-	src *s3.Bucket
-	dst *s3.Bucket
+	// SyncContext, when set, is called to sync the keys instead of Sync,
+	// with the context and metadata of each attempt.
+	SyncContext ContextSyncerFunc
+
+	// DecodeMax, when above DecodePara, lets the pool of JSON decoders
+	// resize itself between 1 and DecodeMax decoders, starting with
+	// DecodePara, by how many lines wait to be decoded.
+	DecodeMax int
+
+	// Filter, when set, says which keys to sync, the others are skipped.
+	Filter func(s3.Key) bool
+
+	// Timeout, when not 0, is how long a sync call can take before it's
+	// given up on with ErrSyncTimeout. S3 requests can't be interrupted, so
+	// the call goes on in the background and its outcome is ignored.
+	Timeout time.Duration
+
+	// State, when set, records the status of each key as it is sync'd.
+	// Keys that the state knows as synced are skipped, which allows resuming
+	// an interrupted sync.
+	State *state.Store
+
+	// MaxFailures and MaxFailureRate, when not 0, stop the task with
+	// ErrTooManyFailures once more keys failed, or a larger fraction of the
+	// keys synced or failed so far.
+	MaxFailures    int64
+	MaxFailureRate float64
+
+	// Breaker, when set, pauses the task for a while when too many keys
+	// fail to sync.
+	Breaker *Breaker
+
+	// RetryBudget, when set, caps the retries of the whole task.
+	RetryBudget *RetryBudget
+
+	// Hedging, when set, hedges the slowest sync calls with a second call.
+	Hedging *Hedging
+
+	// CallBudget, when set, is shared with other tasks, and caps the sync
+	// calls they all make at once.
+	CallBudget *CallBudget
+
+	// PrefixRates, when set, caps the rate of the writes to each prefix of
+	// the destination.
+	PrefixRates *PrefixRates
+
+	// Schedule, when set, caps the rate of the sync calls, or those in
+	// flight, by the time of day.
+	Schedule *Schedule
+
+	// Parking, when set, parks the keys that exhausted their retries to
+	// retry them once more at the end of the task.
+	Parking *Parking
+
+	// RetryPara, when not 0, is how many workers of their own retry the
+	// keys whose first sync call failed, so that the SyncPara workers go on
+	// with the fresh keys meanwhile.
+	RetryPara int
+
+	// Watchdog, when set, acts on the task when no key completes for a
+	// while.
+	Watchdog *Watchdog
+
+	// Anomalies, when set, warns when the sync calls get much slower, or
+	// fail much more often, than they did so far.
+	Anomalies *Anomalies
+
+	// SlowKeys, when set, warns about the keys that take long to sync, and
+	// lists the slowest in the snapshots of the task.
+	SlowKeys *SlowKeys
+
+	// Events, when set, is sent an event for each key synced or failed.
+	Events events.Sink
+
+	// Existing, when set, decides what to do with the keys that already
+	// exist at the destination, which are otherwise overwritten.
+	Existing *Existing
+
+	// Conditional tells that Sync makes conditional copies, such as
+	// ConditionalCopy. Copies whose conditions don't hold are conflicts,
+	// which fail without being retried.
+	Conditional bool
+
+	// Mapping renames the keys at the destination, which keep their name
+	// when it's zero. The Sync of the task must then copy the keys to their
+	// DestKey, see Renamed.
+	Mapping Mapping
+
+	// VerifySample, when not 0, is the fraction of the synced keys picked
+	// at random to be checked at the destination by Verify.
+	VerifySample float64
+	// Checksum, when set, compares the content of the sampled keys whose
+	// ETags can't tell, such as those uploaded in parts, by the sums they
+	// carry, or by reading them when they don't.
+	Checksum *checksum.Checksum
+
+	// Reconcile, when set, counts the keys expected at the destination by
+	// prefix, for ReconcileCounts to check once the task is done.
+	Reconcile *Reconcile
+
+	// Dedupe, when set, tracks the content of the keys synced, for
+	// DedupeKeys to report the keys holding the same content once the task
+	// is done.
+	Dedupe *Dedupe
+
+	// Malformed, when set, skips the malformed lines of the input, rather
+	// than failing on the first, and sets the longest line read.
+	Malformed *Malformed
+
+	// Hook, when set, runs a command for each key synced.
+	Hook *Hook
+
+	// Audit, when set, records the outcome of each key.
+	Audit *AuditLog
+
+	// OutputFormat is the encoder of the synced and failed outputs, see
+	// listing.NewEncoder, JSON when empty. FailureFormat, when set, is the
+	// encoder of the failed outputs instead.
+	OutputFormat  string
+	FailureFormat string
+
+	// ProgressEvery is how often the task measures its throughput, for the
+	// peak rate of its Summary, a second when 0. With LogProgress, the
+	// progress is also logged that often.
+	ProgressEvery time.Duration
+	LogProgress   bool
+
+	// Interleave, when not 0, is how many keys of the listing are held to
+	// send them to the sync workers round-robin over their top-level
+	// prefixes, rather than in the order of the listing.
+	Interleave int
+
+	// Priorities, when set, sync the keys of some prefixes ahead of the
+	// others.
+	Priorities *Priorities
+
+	// OrderWindow, when not 0, writes the synced keys in the order of the
+	// listing, for consumers that rely on it, such as incremental indexers.
+	// Synced keys are held until the keys before them are done, with at
+	// most OrderWindow keys between the oldest key not done and the newest
+	// one read: a slow key holds up the sync workers once the window is
+	// full. Each key held costs about 160 bytes on top of its name, which
+	// the progress reports. The keys are decoded by a single decoder, and
+	// the task can't also prioritize, interleave, park or spill keys, or
+	// shard its synced output. A resumed task writes the keys it syncs in
+	// the order of the listing too, leaving out those synced before.
+	OrderWindow int
+
+	// SpillDir, when set, is where the keys for the outputs spill when the
+	// outputs fall behind, instead of holding up the sync workers.
+	SpillDir string
+
+	// QueueDir, when set, is where the decoded keys are queued on their way
+	// to the sync workers, so that the listing is read ahead of them
+	// however far behind they are. A task started again with the queue of
+	// a task that read its whole listing drains it, without reading its
+	// listing. The queue is removed once the task is done.
+	QueueDir string
+
+	// RunID, when set, is attached to the failures, events, audit records,
+	// anomalies and summary of the task, and passed to its hook commands,
+	// to tell its records apart from those of other runs.
+	RunID string
+
+	// Markers is what the task does with directory markers, see IsMarker:
+	// MarkersCopy, the default when empty, MarkersSkip or
+	// MarkersSynthesize. Markers are counted apart from the other keys,
+	// and those that the Mapping maps to the root of the destination are
+	// always skipped.
+	Markers string
+
+	src       *s3.Bucket
+	dst       *s3.Bucket
+	ctl       *control
+	breaker   *breaker
+	budget    *budget
+	hedger    *hedger
+	calls     *callShare
+	rateCaps  *rateCaps
+	scheduler *scheduler
+	parking   *parkingLot
+	retries   *retryPool
+	anomalies *anomalyWatch
+	stats     taskStats
+	// keys being synced
+	inflightKeys *inflightKeys
+	breakdown    *breakdown
+	// generation of the sync workers, workers of an older generation were
+	// replaced by the watchdog and stop once their key is done
+	generation int64
+	// claims of the names of the destination, when the task syncs a source
+	// of a fan-in
+	claims *claims
+	source int
+	// keys renamed by the policy for existing keys, while they're synced
+	renamesMu sync.Mutex
+	renames   map[string]bool
+	// last key that failed, for the AbortError of the task
+	lastFailure lastFailure
+	// synced keys picked to be verified
+	sampleMu sync.Mutex
+	sample   []sampled
+	// keys expected at the destination, by prefix, to be reconciled
+	expected expectedKeys
+	// keys synced by their content, to be deduped
+	dedupe dedupeKeys
+	// renaming is set when Sync copies the keys to their DestKey
+	renaming bool
+	// faults injected in Sync
+	faults *Faults
+	// names of the synced keys at the destination, for the hook commands
+	hooked chan string
+	// times, peak throughput and failures of the task, for its Summary
+	summary summary
+	// markers made at the destination with MarkersSynthesize
+	made madeMarkers
+	// files of the input, counting the outcomes of their keys
+	inputs *Inputs
+	// keys spilled to the SpillDir for the outputs, and when the workers
+	// were last warned that they wait on the outputs
+	spillSynced, spillFailed *spill
+	lastOutputWarn           int64
+	// reorder of the synced keys, with an OrderWindow
+	reorder *reorder
+	// keys queued to the QueueDir
+	queue *diskQueue
 }
 
 var metrics = struct {
-	fileLines   *expvar.Int
-	decodedKeys *expvar.Int
+	fileLines      *expvar.Int
+	decodedKeys    *expvar.Int
+	malformedLines *expvar.Int
 
 	inflight         *expvar.Int
 	secondsWaitingS3 *expvar.Float
@@ -129,9 +520,61 @@ var metrics = struct {
 	syncOk        *expvar.Int
 	syncRetries   *expvar.Int
 	syncAbandoned *expvar.Int
+	syncSkipped   *expvar.Int
+	syncedBytes   *expvar.Int
+	breakerTrips  *expvar.Int
+	budgetSpent   *expvar.Int
+	budgetDenied  *expvar.Int
+	stalls        *expvar.Int
+	anomalies     *expvar.Int
+	slowKeys      *expvar.Int
+	parked        *expvar.Int
+	redriven      *expvar.Int
+	syncTimeouts  *expvar.Int
+	diskQueued    *expvar.Int
+	hedged        *expvar.Int
+	rateCapped    *expvar.Int
+	scheduleWaits *expvar.Int
+	hedgeWins     *expvar.Int
+	collisions    *expvar.Int
+	conflicts     *expvar.Int
+
+	verifySampled    *expvar.Int
+	verifyMismatches *expvar.Int
+	verifyReads      *expvar.Int
+
+	reconciledPrefixes     *expvar.Int
+	reconcileDiscrepancies *expvar.Int
+
+	duplicateKeys *expvar.Int
+
+	hookOk      *expvar.Int
+	hookRetries *expvar.Int
+	hookFailed  *expvar.Int
+
+	auditErrors *expvar.Int
+
+	panics *expvar.Int
+
+	grantsCopied *expvar.Int
+
+	markers        *expvar.Int
+	markersSkipped *expvar.Int
+	markersCreated *expvar.Int
+
+	secondsThrottled *expvar.Float
+
+	decoders *expvar.Int
+
+	outputQueued          *expvar.Int
+	outputSpilled         *expvar.Int
+	outputWrites          *expvar.Int
+	secondsWritingOutputs *expvar.Float
+	secondsWaitingOutputs *expvar.Float
 }{
-	fileLines:   expvar.NewInt("brigade.sync.fileLines"),
-	decodedKeys: expvar.NewInt("brigade.sync.decodedKeys"),
+	fileLines:      expvar.NewInt("brigade.sync.fileLines"),
+	decodedKeys:    expvar.NewInt("brigade.sync.decodedKeys"),
+	malformedLines: expvar.NewInt("brigade.sync.malformedLines"),
 
 	inflight:         expvar.NewInt("brigade.sync.inflight"),
 	secondsWaitingS3: expvar.NewFloat("brigade.sync.secondsWaitingS3"),
@@ -140,53 +583,285 @@ var metrics = struct {
 	syncOk:        expvar.NewInt("brigade.sync.syncOk"),
 	syncRetries:   expvar.NewInt("brigade.sync.syncRetries"),
 	syncAbandoned: expvar.NewInt("brigade.sync.syncAbandoned"),
+	syncSkipped:   expvar.NewInt("brigade.sync.syncSkipped"),
+	syncedBytes:   expvar.NewInt("brigade.sync.syncedBytes"),
+	breakerTrips:  expvar.NewInt("brigade.sync.breakerTrips"),
+	budgetSpent:   expvar.NewInt("brigade.sync.budgetSpent"),
+	budgetDenied:  expvar.NewInt("brigade.sync.budgetDenied"),
+	stalls:        expvar.NewInt("brigade.sync.stalls"),
+	anomalies:     expvar.NewInt("brigade.sync.anomalies"),
+	slowKeys:      expvar.NewInt("brigade.sync.slowKeys"),
+	parked:        expvar.NewInt("brigade.sync.parked"),
+	redriven:      expvar.NewInt("brigade.sync.redriven"),
+	syncTimeouts:  expvar.NewInt("brigade.sync.syncTimeouts"),
+	diskQueued:    expvar.NewInt("brigade.sync.diskQueued"),
+	hedged:        expvar.NewInt("brigade.sync.hedged"),
+	rateCapped:    expvar.NewInt("brigade.sync.rateCapped"),
+	scheduleWaits: expvar.NewInt("brigade.sync.scheduleWaits"),
+	hedgeWins:     expvar.NewInt("brigade.sync.hedgeWins"),
+	collisions:    expvar.NewInt("brigade.sync.collisions"),
+	conflicts:     expvar.NewInt("brigade.sync.conflicts"),
+
+	verifySampled:    expvar.NewInt("brigade.sync.verifySampled"),
+	verifyMismatches: expvar.NewInt("brigade.sync.verifyMismatches"),
+	verifyReads:      expvar.NewInt("brigade.sync.verifyReads"),
+
+	reconciledPrefixes:     expvar.NewInt("brigade.sync.reconciledPrefixes"),
+	reconcileDiscrepancies: expvar.NewInt("brigade.sync.reconcileDiscrepancies"),
+
+	duplicateKeys: expvar.NewInt("brigade.sync.duplicateKeys"),
+
+	hookOk:      expvar.NewInt("brigade.sync.hookOk"),
+	hookRetries: expvar.NewInt("brigade.sync.hookRetries"),
+	hookFailed:  expvar.NewInt("brigade.sync.hookFailed"),
+
+	auditErrors: expvar.NewInt("brigade.sync.auditErrors"),
+
+	panics: expvar.NewInt("brigade.sync.panics"),
+
+	grantsCopied: expvar.NewInt("brigade.sync.grantsCopied"),
+
+	markers:        expvar.NewInt("brigade.sync.markers"),
+	markersSkipped: expvar.NewInt("brigade.sync.markersSkipped"),
+	markersCreated: expvar.NewInt("brigade.sync.markersCreated"),
+
+	secondsThrottled: expvar.NewFloat("brigade.sync.secondsThrottled"),
+
+	decoders: expvar.NewInt("brigade.sync.decoders"),
+
+	outputQueued:          expvar.NewInt("brigade.sync.outputQueued"),
+	outputSpilled:         expvar.NewInt("brigade.sync.outputSpilled"),
+	outputWrites:          expvar.NewInt("brigade.sync.outputWrites"),
+	secondsWritingOutputs: expvar.NewFloat("brigade.sync.secondsWritingOutputs"),
+	secondsWaitingOutputs: expvar.NewFloat("brigade.sync.secondsWaitingOutputs"),
 }
 
 // Start the task, reading all the keys that need to be sync'd
 // from the input reader, in JSON form, copying the keys in src onto dst.
 func (s *SyncTask) Start(input io.Reader, synced, failed io.Writer) error {
+	return s.StartSharded(input, []io.Writer{synced}, []io.Writer{failed})
+}
+
+// StartSharded is like Start, but writes the synced and failed keys over
+// many outputs, each with its own encoder. Keys are spread over the shards
+// in no particular order, whichever encoder is free takes the next key.
+// Outputs that are all ioutil.Discard get no encoder at all.
+func (s *SyncTask) StartSharded(input io.Reader, synced, failed []io.Writer) error {
+	if len(synced) == 0 || len(failed) == 0 {
+		return fmt.Errorf("need at least one synced and one failed output, got %d and %d", len(synced), len(failed))
+	}
+	for _, format := range []string{s.OutputFormat, s.failureFormat()} {
+		if _, err := listing.NewEncoder(ioutil.Discard, format); err != nil {
+			return err
+		}
+	}
+
+	if s.Breaker != nil {
+		s.breaker = &breaker{Breaker: *s.Breaker}
+	}
+	if s.RetryBudget != nil {
+		s.budget = newBudget(*s.RetryBudget)
+	}
+	if s.Hedging != nil {
+		s.hedger = &hedger{Hedging: *s.Hedging}
+	}
+	if s.CallBudget != nil {
+		s.calls = s.CallBudget.share()
+	}
+	if s.PrefixRates != nil {
+		s.rateCaps = newRateCaps(*s.PrefixRates)
+	}
+	if s.Schedule != nil {
+		s.scheduler = newScheduler(*s.Schedule)
+	}
+	if s.Parking != nil {
+		s.parking = &parkingLot{Parking: *s.Parking}
+	}
+	if s.OrderWindow > 0 {
+		if err := s.checkOrdered(len(synced)); err != nil {
+			return err
+		}
+	}
+	if s.QueueDir != "" {
+		var err error
+		if s.queue, err = openDiskQueue(s.QueueDir); err != nil {
+			return fmt.Errorf("opening disk queue: %v", err)
+		}
+	}
 
 	start := time.Now()
+	finishSummary := s.startSummary()
+	watchersDone := make(chan struct{})
+	if s.Anomalies != nil {
+		s.anomalies = newAnomalyWatch(*s.Anomalies)
+		logrus.WithFields(logrus.Fields{
+			"every":    s.Anomalies.Every,
+			"baseline": s.Anomalies.Baseline,
+		}).Info("watching for anomalies of the sync calls")
+		go s.watchAnomalies(watchersDone)
+	}
+	if s.SlowKeys != nil {
+		go s.watchSlowKeys(watchersDone)
+	}
+	if s.scheduler != nil {
+		go s.watchSchedule(watchersDone)
+	}
 
 	keysIn := make(chan s3.Key, s.SyncPara*BufferFactor)
 	keysOk := make(chan s3.Key, s.SyncPara*BufferFactor)
-	keysFail := make(chan s3.Key, s.SyncPara*BufferFactor)
+	keysFail := make(chan listing.Failure, s.SyncPara*BufferFactor)
+	// nobody reads discarded outputs, their keys aren't even encoded
+	if discarded(synced) {
+		logrus.Info("synced keys are discarded, not encoding them")
+		keysOk = nil
+	}
+	if discarded(failed) {
+		logrus.Info("failed keys are discarded, not encoding them")
+		keysFail = nil
+	}
+	if s.SpillDir != "" {
+		if keysOk != nil {
+			s.spillSynced = newSpill(s.SpillDir, "synced")
+		}
+		if keysFail != nil {
+			s.spillFailed = newSpill(s.SpillDir, "failed")
+		}
+	}
+
+	if s.OrderWindow > 0 && keysOk != nil {
+		logrus.WithFields(logrus.Fields{
+			"window":        s.OrderWindow,
+			"bytes_per_key": reorderKeyBytes,
+		}).Info("writing the synced keys in the order of the listing, with a single key decoder")
+		s.DecodePara, s.DecodeMax = 1, 1
+		out := keysOk
+		s.reorder = newReorder(s.OrderWindow, func(key s3.Key) {
+			s.queued(1)
+			start := time.Now()
+			out <- key
+			s.waitedOnOutputs(time.Since(start))
+		}, &s.stats)
+	}
 
-	decoders := make(chan []byte, s.DecodePara*BufferFactor)
+	decoders := make(chan *[]byte, s.DecodePara*BufferFactor)
 
 	// start JSON decoders
 	logrus.WithFields(logrus.Fields{
 		"key_decoders": s.DecodePara,
+		"max_decoders": s.DecodeMax,
 		"buffer_size":  cap(decoders),
 	}).Info("starting key decoders")
 
-	decGroup := sync.WaitGroup{}
-	for i := 0; i < s.DecodePara; i++ {
-		decGroup.Add(1)
-		go s.decode(&decGroup, decoders, keysIn)
+	// decoded keys go to the sync workers, unless they're prioritized or
+	// interleaved, in that order
+	decoded := keysIn
+	prioritized := make(chan struct{})
+	if s.Priorities != nil {
+		logrus.WithFields(logrus.Fields{
+			"prefixes": s.Priorities.Prefixes,
+			"window":   s.Priorities.Window,
+		}).Info("prioritizing keys by prefix")
+		in, out := make(chan s3.Key, s.SyncPara*BufferFactor), decoded
+		decoded = in
+		go func() {
+			defer close(prioritized)
+			prioritize(in, out, *s.Priorities)
+		}()
+	} else {
+		close(prioritized)
+	}
+	prioritizedIn := decoded
+	interleaved := make(chan struct{})
+	if s.Interleave > 0 {
+		logrus.WithField("window", s.Interleave).Info("interleaving keys over their prefixes")
+		in, out := make(chan s3.Key, s.SyncPara*BufferFactor), decoded
+		decoded = in
+		go func() {
+			defer close(interleaved)
+			interleave(in, out, s.Interleave)
+		}()
+	} else {
+		close(interleaved)
 	}
 
+	// decoded keys go through the disk queue, if the task has one
+	listed := decoded
+	queueErr := make(chan error, 1)
+	if s.queue != nil {
+		logrus.WithFields(logrus.Fields{
+			"dir":     s.QueueDir,
+			"resumed": s.queue.resumed,
+		}).Info("queueing keys to disk")
+		listed = make(chan s3.Key, s.SyncPara*BufferFactor)
+		go func() { queueErr <- s.runQueue(listed, decoded) }()
+	} else {
+		queueErr <- nil
+	}
+
+	decPool := s.startDecodePool(s.DecodePara, s.DecodeMax, decoders, listed)
+
 	// start S3 sync workers
 	logrus.WithFields(logrus.Fields{
 		"sync_workers": s.SyncPara,
 		"buffer_size":  cap(keysIn),
 	}).Info("starting key sync workers")
-	syncGroup := sync.WaitGroup{}
-	for i := 0; i < s.SyncPara; i++ {
-		syncGroup.Add(1)
-		go s.syncKey(&syncGroup, s.src, s.dst, keysIn, keysOk, keysFail)
+	waitHooks := s.startHooks()
+	// the keys are registered in the order of the listing on their way to
+	// the workers, when their synced keys are reordered
+	workersIn := keysIn
+	if s.reorder != nil {
+		workersIn = make(chan s3.Key, s.SyncPara)
+		go s.registerKeys(keysIn, workersIn)
+	}
+	if s.RetryPara > 0 {
+		logrus.WithField("retry_workers", s.RetryPara).Info("starting key retry workers")
+		s.retries = s.startRetryPool()
+	}
+	var syncGroup pipeline.Workers
+	startWorkers := func(gen int64) {
+		syncGroup.Start(s.SyncPara, func(i int) {
+			s.syncKey(int(gen)*s.SyncPara+i, gen, s.src, s.dst, workersIn, keysOk, keysFail)
+		})
+	}
+	startWorkers(0)
+
+	inputDone := make(chan struct{})
+	if s.Watchdog != nil {
+		logrus.WithFields(logrus.Fields{
+			"after":  s.Watchdog.After,
+			"action": s.Watchdog.Action,
+		}).Info("watching for stalls")
+		syncGroup.Start(1, func(int) { s.watch(inputDone, func() int { return len(keysIn) }, startWorkers) })
 	}
 
 	// track keys that have been sync'd, and those that we failed to sync.
-	logrus.Info("starting to write progress")
-	encGroup := sync.WaitGroup{}
-	encGroup.Add(2)
-	go s.encode(&encGroup, synced, keysOk)
-	go s.encode(&encGroup, failed, keysFail)
+	logrus.WithFields(logrus.Fields{
+		"synced_shards": len(synced),
+		"failed_shards": len(failed),
+	}).Info("starting to write progress")
+	var encGroup pipeline.Workers
+	if keysOk != nil {
+		encGroup.Start(len(synced), func(i int) { s.encode(synced[i], keysOk, i == 0) })
+	}
+	if keysFail != nil {
+		encGroup.Start(len(failed), func(i int) { s.encodeFailures(failed[i], keysFail, i == 0) })
+	}
 
 	// feed the pipeline by reading the listing file
 	logrus.Info("starting to read key listing file")
-	err := s.readLines(input, decoders)
+	var err error
+	if rd := bufio.NewReader(input); s.queue != nil && s.queue.resumed {
+		logrus.WithField("key_count", s.queue.flushed).Info("listing already queued to disk, not reading it again")
+	} else if listing.Detect(rd) != listing.JSON {
+		// binary and msgpack listings are cheap to decode, keys are read
+		// right away
+		err = s.readListing(rd, listed)
+	} else {
+		err = s.readLines(rd, decoders)
+	}
+	if err != nil {
+		err = &ListingError{Lines: s.stats.lines.Value(), Err: err}
+	}
 
 	// when done reading the source file, wait until the decoders
 	// are done.
@@ -195,7 +870,7 @@ func (s *SyncTask) Start(input io.Reader, synced, failed io.Writer) error {
 		"line_count":  metrics.fileLines.String(),
 	}).Info("done reading lines from sync list")
 	close(decoders)
-	decGroup.Wait()
+	decPool.wait()
 
 	// when the decoders are all done, wait for the sync workers to finish
 
@@ -204,33 +879,95 @@ func (s *SyncTask) Start(input io.Reader, synced, failed io.Writer) error {
 		"line_count":  metrics.decodedKeys.String(),
 	}).Info("done decoding keys from sync list")
 
+	if s.queue != nil {
+		if _, cancelled := s.ctl.state(); err != nil || cancelled {
+			s.queue.partialListing()
+		}
+		close(listed)
+	}
+	if qerr := <-queueErr; qerr != nil && err == nil {
+		err = qerr
+	}
+	if s.Interleave > 0 {
+		close(decoded)
+	}
+	<-interleaved
+	if s.Priorities != nil {
+		close(prioritizedIn)
+	}
+	<-prioritized
 	close(keysIn)
+	close(inputDone)
 	syncGroup.Wait()
+	if s.retries != nil {
+		s.retries.close()
+	}
+	s.redrive(keysOk, keysFail)
+	waitHooks()
 
-	close(keysOk)
-	close(keysFail)
+	if keysOk != nil {
+		close(keysOk)
+	}
+	if keysFail != nil {
+		close(keysFail)
+	}
 
 	encGroup.Wait()
+	close(watchersDone)
+	finishSummary()
+
+	if _, cancelled := s.ctl.state(); cancelled && err == nil {
+		err = s.aborted(s.ctl.err())
+	}
+	if s.queue != nil {
+		s.queue.close(err == nil)
+	}
 
 	// the source file is read, all keys were decoded and sync'd. we're done.
+	latency := Latency.Overall().Summarize()
 	logrus.WithFields(logrus.Fields{
-		"since_start": time.Since(start),
-		"sync_ok":     metrics.syncOk.String(),
-		"sync_fail":   metrics.syncAbandoned.String(),
+		"since_start":  time.Since(start),
+		"sync_ok":      metrics.syncOk.String(),
+		"sync_fail":    metrics.syncAbandoned.String(),
+		"sync_skip":    metrics.syncSkipped.String(),
+		"panics":       s.stats.panics.Value(),
+		"markers":      s.stats.markers.Value(),
+		"latency_p50":  latency.P50,
+		"latency_p95":  latency.P95,
+		"latency_p99":  latency.P99,
+		"latency_p999": latency.P999,
+		"latency_max":  latency.Max,
 	}).Info("done syncing keys")
+	s.logExisting()
+	s.logConflicts()
+	if s.reorder != nil {
+		keys, bytes := s.reorder.peak()
+		logrus.WithFields(logrus.Fields{
+			"window":     s.OrderWindow,
+			"peak_keys":  keys,
+			"peak_bytes": bytes,
+			"wait":       time.Duration(s.stats.reorderWait.Value()),
+		}).Info("done writing the synced keys in the order of the listing")
+	}
+	bd := s.Breakdown()
+	logBreakdown(bd)
+	if s.src.RequesterPays {
+		logRequesterPays(s.src.Name, bd)
+	}
 
 	return err
 }
 
-// reads all the \n separated lines from a file, write them (without \n) to
-// the channel. reads until EOF or stops on the first error encountered
-func (s *SyncTask) readLines(input io.Reader, decoders chan<- []byte) error {
-
-	rd := bufio.NewReader(input)
-
+// reads all the keys from a binary or msgpack listing, sending them to the
+// sync workers. reads until EOF or stops on the first error encountered
+func (s *SyncTask) readListing(input io.Reader, keys chan<- s3.Key) error {
+	rd, err := listing.NewReader(input)
+	if err != nil {
+		return err
+	}
+	var key s3.Key
 	for {
-		line, err := rd.ReadBytes('\n')
-		switch err {
+		switch err := rd.Read(&key); err {
 		case io.EOF:
 			return nil
 		case nil:
@@ -238,32 +975,111 @@ func (s *SyncTask) readLines(input io.Reader, decoders chan<- []byte) error {
 			return err
 		}
 
-		decoders <- line
+		select {
+		case keys <- key:
+		case <-s.ctl.done:
+			logrus.Warn("sync task cancelled, stop reading keys")
+			return nil
+		}
 		metrics.fileLines.Add(1)
+		metrics.decodedKeys.Add(1)
+		s.stats.lines.Add(1)
+		s.stats.decoded.Add(1)
 	}
 }
 
-// decodes s3.Keys from a channel of bytes, each byte containing a full key
-func (s *SyncTask) decode(wg *sync.WaitGroup, lines <-chan []byte, keys chan<- s3.Key) {
-	defer wg.Done()
+// reads all the \n separated lines from a file, sending them to the
+// decoders. reads until EOF or stops on the first error encountered, lines
+// that are too long included unless they're skipped
+func (s *SyncTask) readLines(input io.Reader, decoders chan<- *[]byte) error {
+	err := pipeline.ReadLinesMax(input, decoders, s.ctl.done, func() {
+		metrics.fileLines.Add(1)
+		s.stats.lines.Add(1)
+	}, s.Malformed.maxLine(), s.tooLong)
+	if err == pipeline.ErrStopped {
+		logrus.Warn("sync task cancelled, stop reading lines")
+		return nil
+	}
+	return err
+}
+
+// decodes s3.Keys from a channel of bytes, each byte containing a full key,
+// until the lines are closed or the decoder is retired
+func (s *SyncTask) decode(lines <-chan *[]byte, keys chan<- s3.Key, retire <-chan struct{}) {
+	dec := NewKeyDecoder()
 	var key s3.Key
-	for line := range lines {
-		err := json.Unmarshal(line, &key)
+	for {
+		var line *[]byte
+		select {
+		case <-retire:
+			return
+		case l, ok := <-lines:
+			if !ok {
+				return
+			}
+			line = l
+		}
+		err := s.decodeLine(dec, *line, &key)
+		size := int64(len(*line))
+		pipeline.Release(line)
+		if _, ok := err.(*PanicError); ok {
+			// recorded already, the line is skipped
+			continue
+		}
 		if err != nil {
-			logrus.WithField("error", err).Fatal("failed to unmarshal s3.Key from line")
+			if err := s.malformedLine(size, err); err != nil {
+				logrus.WithField("error", err).Error("failed to unmarshal s3.Key from line")
+				s.ctl.cancelWith(err)
+			}
 		} else {
 			keys <- key
 			metrics.decodedKeys.Add(1)
+			s.stats.decoded.Add(1)
 		}
 	}
 }
 
-// encode write the keys it receives in JSON to a dst writer.
-func (s *SyncTask) encode(wg *sync.WaitGroup, dst io.Writer, keys <-chan s3.Key) {
-	defer wg.Done()
-	enc := json.NewEncoder(dst)
-	for key := range keys {
-		err := enc.Encode(key)
+// decodeLine decodes a line, recovering from a panic of the decoder.
+func (s *SyncTask) decodeLine(dec *KeyDecoder, line []byte, key *s3.Key) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.panicked(StageDecode, panicLine(line), r)
+		}
+	}()
+	return dec.Decode(line, key)
+}
+
+// discarded is true if all the writers are ioutil.Discard.
+func discarded(writers []io.Writer) bool {
+	for _, w := range writers {
+		if w != ioutil.Discard {
+			return false
+		}
+	}
+	return true
+}
+
+// encode write the keys it receives in the output format to a dst writer.
+// Once keys is closed, the keys that spilled are written by the encoder
+// that replays the spill.
+func (s *SyncTask) encode(dst io.Writer, keys <-chan s3.Key, replay bool) {
+	enc, err := listing.NewEncoder(dst, s.OutputFormat)
+	if err != nil {
+		// the format was checked when the task started, but not the
+		// encoder of an output that is its own
+		logrus.WithField("error", err).Panic("failed to create the encoder of the output, bailing")
+	}
+	defer func() {
+		if err := enc.Flush(); err != nil {
+			logrus.WithField("error", err).Panic("failed to flush output, bailing")
+		}
+	}()
+	write := func(key s3.Key) {
+		start := time.Now()
+		err := s.encodeGuarded(key.Key, func() error { return enc.EncodeKey(key) })
+		if _, ok := err.(*PanicError); ok {
+			return
+		}
 		if err != nil {
 			// panic so that someone come look at why the destination can't be
 			// written to, with a stack trace to help
@@ -272,74 +1088,447 @@ func (s *SyncTask) encode(wg *sync.WaitGroup, dst io.Writer, keys <-chan s3.Key)
 				"key":   key,
 			}).Panic("failed to encode s3.Key to output, bailing")
 		}
+		s.wrote(time.Since(start))
+	}
+	for key := range keys {
+		write(key)
+	}
+	if !replay || s.spillSynced == nil {
+		return
+	}
+	err = s.spillSynced.replay(func(dec *json.Decoder) error {
+		var key s3.Key
+		if err := dec.Decode(&key); err != nil {
+			return err
+		}
+		write(key)
+		return nil
+	})
+	if err != nil {
+		logrus.WithField("error", err).Panic("failed to replay spilled keys, bailing")
+	}
+}
+
+// encodeGuarded calls encode, recovering from a panic of the encoder. The
+// key it was encoding is lost from the output, but not from the logs.
+func (s *SyncTask) encodeGuarded(key string, encode func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.panicked(StageEncode, key, r)
+		}
+	}()
+	return encode()
+}
+
+// failureFormat is the encoder of the failed outputs.
+func (s *SyncTask) failureFormat() string {
+	if s.FailureFormat != "" {
+		return s.FailureFormat
+	}
+	return s.OutputFormat
+}
+
+// encodeFailures writes the failures it receives in the output format to a
+// dst writer, like encode.
+func (s *SyncTask) encodeFailures(dst io.Writer, failures <-chan listing.Failure, replay bool) {
+	enc, err := listing.NewEncoder(dst, s.failureFormat())
+	if err != nil {
+		logrus.WithField("error", err).Panic("failed to create the encoder of the output, bailing")
+	}
+	defer func() {
+		if err := enc.Flush(); err != nil {
+			logrus.WithField("error", err).Panic("failed to flush output, bailing")
+		}
+	}()
+	write := func(f listing.Failure) {
+		start := time.Now()
+		err := s.encodeGuarded(f.Key.Key, func() error { return enc.EncodeFailure(f) })
+		if _, ok := err.(*PanicError); ok {
+			return
+		}
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"key":   f.Key,
+			}).Panic("failed to encode failure to output, bailing")
+		}
+		s.wrote(time.Since(start))
+	}
+	for f := range failures {
+		write(f)
+	}
+	if !replay || s.spillFailed == nil {
+		return
+	}
+	err = s.spillFailed.replay(func(dec *json.Decoder) error {
+		var f listing.Failure
+		if err := dec.Decode(&f); err != nil {
+			return err
+		}
+		write(f)
+		return nil
+	})
+	if err != nil {
+		logrus.WithField("error", err).Panic("failed to replay spilled failures, bailing")
 	}
 }
 
 // syncKey uses s.syncMethod to copy keys from `src` to `dst`, until `keys` is
-// closed. Each key error is retried MaxRetry times, unless the error is not
-// retriable.
-func (s *SyncTask) syncKey(wg *sync.WaitGroup, src, dst *s3.Bucket, keys <-chan s3.Key, synced, failed chan<- s3.Key) {
-	defer wg.Done()
+// closed, or until the worker's generation was replaced by the watchdog. Each
+// key error is retried MaxRetry times, unless the error is not retriable.
+func (s *SyncTask) syncKey(worker int, gen int64, src, dst *s3.Bucket, keys <-chan s3.Key, synced chan<- s3.Key, failed chan<- listing.Failure) {
+	for key := range keys {
+		s.stats.busy.Add(1)
+		s.syncGuarded(worker, src, dst, key, false, synced, failed)
+		s.stats.busy.Add(-1)
+		if s.reorder != nil {
+			s.reorder.done(key)
+		}
+		if atomic.LoadInt64(&s.generation) != gen {
+			return
+		}
+	}
+}
 
+// registerKeys registers the keys with the reordering of the synced output,
+// in the order they come, and passes them on to the sync workers.
+func (s *SyncTask) registerKeys(keys <-chan s3.Key, workers chan<- s3.Key) {
+	defer close(workers)
 	for key := range keys {
-		retries, err := s.syncOrRetry(src, dst, key)
-		// If we exhausted MaxRetry, log the error to the error log
-		if err != nil {
-			metrics.syncAbandoned.Add(1)
-			failed <- key
+		if waited := s.reorder.register(key); waited > 0 {
+			s.stats.reorderWait.Add(int64(waited))
+		}
+		workers <- key
+	}
+}
+
+// checkOrdered checks that the synced keys can be written in the order of
+// the listing.
+func (s *SyncTask) checkOrdered(syncedShards int) error {
+	var conflict string
+	switch {
+	case syncedShards > 1:
+		conflict = "a sharded synced output"
+	case s.Priorities != nil:
+		conflict = "priorities"
+	case s.Interleave > 0:
+		conflict = "interleaving"
+	case s.Parking != nil:
+		conflict = "parking"
+	case s.RetryPara > 0:
+		conflict = "retry workers"
+	case s.SpillDir != "":
+		conflict = "a spill dir"
+	default:
+		return nil
+	}
+	return fmt.Errorf("can't write the synced keys in the order of the listing with %s", conflict)
+}
+
+// syncGuarded is syncOne, recovering from a panic while syncing the key,
+// which fails it.
+func (s *SyncTask) syncGuarded(worker int, src, dst *s3.Bucket, key s3.Key, redriven bool, synced chan<- s3.Key, failed chan<- listing.Failure) {
+	defer s.recoverKey(worker, key, time.Now(), failed)
+	s.syncOne(worker, src, dst, key, redriven, synced, failed)
+}
+
+// recoverKey recovers from a panic while syncing a key started at start,
+// which fails it. It must be deferred.
+func (s *SyncTask) recoverKey(worker int, key s3.Key, start time.Time, failed chan<- listing.Failure) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := s.panicked(StageSync, key.Key, r)
+	metrics.syncAbandoned.Add(1)
+	s.summary.fail(err)
+	s.lastFailure.set(&RetriesExhaustedError{Key: key.Key, Err: err})
+	s.checkFailures(s.stats.failed.Add(1))
+	if failed != nil {
+		s.sendFailed(failed, listing.Failure{
+			Key:       key,
+			ErrorCode: PanicErrorCode,
+			Error:     err.Error(),
+			Time:      time.Now().UTC(),
+		})
+	}
+	s.recordState(state.Record{Key: key, Status: state.Failed, Error: err.Error(), ErrorCode: PanicErrorCode})
+	s.emit(events.Event{Type: events.Failed, Key: key, Error: err.Error(), ErrorCode: PanicErrorCode})
+	s.audit(AuditRecord{Key: key, Worker: worker, Start: start, Outcome: AuditFailed, Error: err.Error(), ErrorCode: PanicErrorCode})
+}
 
-			entry := logrus.WithFields(logrus.Fields{
-				"retries": retries,
-				"key":     key,
-				"error":   err,
+// syncOne syncs a key, recording its outcome. Keys that fail are parked if
+// the task parks keys, unless they're parked keys being redriven, which
+// were already checked and claimed. Keys whose first sync call fails are
+// handed to the retry pool, if the task has one, which records their
+// outcome.
+func (s *SyncTask) syncOne(worker int, src, dst *s3.Bucket, key s3.Key, redriven bool, synced chan<- s3.Key, failed chan<- listing.Failure) {
+	if !s.ctl.wait() {
+		// cancelled, drain the keys without syncing them
+		return
+	}
+	audited := AuditRecord{Key: key, Worker: worker, Start: time.Now()}
+	if !redriven && s.skipMarker(key) {
+		metrics.markersSkipped.Add(1)
+		s.stats.markersSkipped.Add(1)
+		audited.Outcome = AuditSkipped
+		s.audit(audited)
+		return
+	}
+	if !redriven && (s.alreadySynced(key) || s.Filter != nil && !s.Filter(key)) {
+		metrics.syncSkipped.Add(1)
+		s.stats.skipped.Add(1)
+		audited.Outcome = AuditSkipped
+		s.audit(audited)
+		return
+	}
+	var ids requestIDs
+	if s.Audit != nil {
+		dst = ids.track(dst)
+	}
+	var skip, rename, handedOff bool
+	var err error
+	if !redriven {
+		skip, err = s.collides(key)
+	}
+	if !skip && err == nil {
+		skip, rename, err = s.exists(dst, key)
+		if rename {
+			// the retry pool renames the keys handed to it until done
+			s.setRenamed(key.Key, true)
+			defer func() {
+				if !handedOff {
+					s.setRenamed(key.Key, false)
+				}
+			}()
+		}
+		if skip {
+			// already at the destination
+			s.expect(s.DestKey(key.Key))
+		}
+	}
+	if skip {
+		metrics.syncSkipped.Add(1)
+		s.stats.skipped.Add(1)
+		audited.Outcome, audited.RequestID = AuditSkipped, ids.get()
+		s.audit(audited)
+		return
+	}
+	s.recordState(state.Record{Key: key, Status: state.Pending})
+
+	var retries int
+	var c calls
+	switch {
+	case err != nil:
+	case s.retries != nil && !redriven:
+		retries, c, err, handedOff = s.retryKey(src, dst, key, 1, calls{}, nil, true)
+		if handedOff {
+			s.handOff(retryingKey{key: key, worker: worker, failed: failed, retry: func() {
+				if rename {
+					defer s.setRenamed(key.Key, false)
+				}
+				if retries, c, err, ok := s.retryHandedOff(src, dst, key, retries, c, err); ok {
+					s.syncDone(worker, dst, key, redriven, audited, &ids, retries, c, err, synced, failed)
+				}
+			}})
+			return
+		}
+	default:
+		retries, c, err = s.syncOrRetry(src, dst, key)
+	}
+	s.syncDone(worker, dst, key, redriven, audited, &ids, retries, c, err, synced, failed)
+}
+
+// syncDone records the outcome of the sync of a key, after retries and with
+// the calls c.
+func (s *SyncTask) syncDone(worker int, dst *s3.Bucket, key s3.Key, redriven bool, audited AuditRecord, ids *requestIDs, retries int, c calls, err error, synced chan<- s3.Key, failed chan<- listing.Failure) {
+	s.breakdown.add(worker, key, c, err != nil)
+	s.recordOutcome(err)
+	if err != nil && !redriven && s.park(key, err) {
+		return
+	}
+	audited.Attempts, audited.RequestID = c.count, ids.get()
+	// If we exhausted MaxRetry, log the error to the error log
+	if err != nil {
+		metrics.syncAbandoned.Add(1)
+		s.summary.fail(err)
+		s.lastFailure.set(&RetriesExhaustedError{Key: key.Key, Retries: retries, Err: err})
+		s.checkFailures(s.stats.failed.Add(1))
+		rec := state.Record{Key: key, Status: state.Failed, Retries: retries, Error: err.Error()}
+		if e, ok := err.(*s3.Error); ok {
+			rec.ErrorCode = e.Code
+		}
+		if failed != nil {
+			s.sendFailed(failed, listing.Failure{
+				Key:       key,
+				ErrorCode: rec.ErrorCode,
+				Error:     rec.Error,
+				Retries:   retries,
+				Time:      time.Now().UTC(),
 			})
+		}
+		s.recordState(rec)
+		s.emit(events.Event{Type: events.Failed, Key: key, Error: rec.Error, ErrorCode: rec.ErrorCode})
+		audited.Outcome, audited.Error, audited.ErrorCode = AuditFailed, rec.Error, rec.ErrorCode
+		s.audit(audited)
 
-			switch e := err.(type) {
-			case *s3.Error: // cannot be abort worthy at this point
-				entry.WithFields(logrus.Fields{
-					"s3_code":    e.Code,
-					"s3_message": e.Message,
-				}).Error("failed too many times to sync key, abandoned: s3.Error")
-			default:
-				entry.Error("failed too many times to sync key, abandoned: unexpected error")
+		entry := logrus.WithFields(logrus.Fields{
+			"retries": retries,
+			"key":     key,
+			"error":   err,
+		})
+
+		switch e := err.(type) {
+		case *s3.Error: // cannot be abort worthy at this point
+			entry.WithFields(logrus.Fields{
+				"s3_code":    e.Code,
+				"s3_message": e.Message,
+			}).Error("failed too many times to sync key, abandoned: s3.Error")
+		default:
+			if err == ErrCollision || err == ErrExists {
+				entry.Warn("key collides with an existing key, abandoned")
+				break
 			}
+			entry.Error("failed too many times to sync key, abandoned: unexpected error")
+		}
 
+	} else {
+		if IsMarker(key) {
+			metrics.markers.Add(1)
+			s.stats.markers.Add(1)
 		} else {
 			metrics.syncOk.Add(1)
-			synced <- key
+			metrics.syncedBytes.Add(key.Size)
+			s.stats.synced.Add(1)
+			s.stats.bytes.Add(key.Size)
+		}
+		if s.Markers == MarkersSynthesize {
+			s.synthesizeMarkers(dst, key)
+		}
+		if synced != nil {
+			s.sendSynced(synced, key)
 		}
+		s.pickSample(key)
+		s.expect(s.DestKey(key.Key))
+		s.trackContent(key, s.DestKey(key.Key))
+		if s.hooked != nil {
+			s.hooked <- s.DestKey(key.Key)
+		}
+		s.recordState(state.Record{Key: key, Status: state.Synced, Retries: retries})
+		s.emit(events.Event{Type: events.Synced, Key: key})
+		audited.Outcome = AuditSynced
+		s.audit(audited)
+	}
+}
+
+// alreadySynced is true if the state of the task knows the key as synced.
+func (s *SyncTask) alreadySynced(key s3.Key) bool {
+	if s.State == nil {
+		return false
+	}
+	rec, ok := s.State.Get(key.Key)
+	return ok && rec.Status == state.Synced && rec.Key.ETag == key.ETag
+}
+
+// emit an event about a key to the events sink of the task, if there's one.
+func (s *SyncTask) emit(ev events.Event) {
+	if s.Events == nil {
+		return
+	}
+	ev.Source, ev.Destination = s.src.Name, s.dst.Name
+	ev.RunID = s.RunID
+	ev.Time = time.Now().UTC()
+	s.Events.Send(ev)
+}
+
+// recordState saves the record in the state of the task, if there's one.
+func (s *SyncTask) recordState(rec state.Record) {
+	if s.State == nil {
+		return
+	}
+	if err := s.State.Put(rec); err != nil {
+		// panic so that someone come look at why the state can't be
+		// written to, with a stack trace to help. The workers don't
+		// recover from it, the keys would be synced again on resume.
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+			"key":   rec.Key,
+		}).Error("failed to record state of key, bailing")
+		panic(&bailout{fmt.Errorf("recording state of key %q: %v", rec.Key.Key, err)})
 	}
 }
 
 // syncOrRetry will try to sync a key many times, until it succeeds or
 // fail more than MaxRetry times. It will sleep between retries and abort
-// the program on errors that are unrecoverable (like bad auths).
-func (s *SyncTask) syncOrRetry(src, dst *s3.Bucket, key s3.Key) (int, error) {
-	var err error
-	retry := 1
+// the program on errors that are unrecoverable (like bad auths). It also
+// returns the calls that were made.
+func (s *SyncTask) syncOrRetry(src, dst *s3.Bucket, key s3.Key) (int, calls, error) {
+	retries, c, err, _ := s.retryKey(src, dst, key, 1, calls{}, nil, false)
+	return retries, c, err
+}
+
+// retryKey is syncOrRetry from the attempt retry on, after the calls c of
+// the attempts before, the last of which failed with err. With handOff, it
+// returns once an attempt failed and should be retried rather than
+// sleeping before the next one, with more set: the retry pool goes on from
+// there.
+func (s *SyncTask) retryKey(src, dst *s3.Bucket, key s3.Key, retry int, c calls, err error, handOff bool) (int, calls, error, bool) {
+	inflight := s.inflightKeys.add(key)
+	defer s.inflightKeys.remove(inflight)
 	for ; retry <= s.MaxRetry; retry++ {
+		atomic.StoreInt32(&inflight.attempt, int32(retry))
 		start := time.Now()
 
 		// do a put copy call (sync directly from bucket to another
 		// without fetching the content locally)
 		metrics.syncAttempted.Add(1)
 		metrics.inflight.Add(1)
-		err = s.Sync(src, dst, key)
+		s.stats.inflight.Add(1)
+		err = s.callSync(src, dst, key, Attempt{Number: retry, PrevErr: err})
 		metrics.inflight.Add(-1)
+		s.stats.inflight.Add(-1)
 
-		metrics.secondsWaitingS3.Add(time.Since(start).Seconds())
+		elapsed := time.Since(start)
+		c.count++
+		c.latency += elapsed
+		metrics.secondsWaitingS3.Add(elapsed.Seconds())
+		recorder, histogram := Latency, s.stats.latency
+		if retry > 1 {
+			recorder, histogram = RetryLatency, s.stats.retryLatency
+		}
+		if err == ErrSyncTimeout {
+			// the call would have taken longer, the latency is a lower bound
+			metrics.syncTimeouts.Add(1)
+			recorder.RecordCensored(elapsed)
+			histogram.RecordCensored(elapsed)
+		} else {
+			recorder.Record(elapsed)
+			histogram.Record(elapsed)
+		}
+		if s.anomalies != nil {
+			s.anomalies.record(elapsed, err != nil)
+		}
 
 		switch e := err.(type) {
 		case nil:
 			// when there are no errors, there's nothing to retry
-			return retry, nil
+			s.earnRetry()
+			return retry, c, nil, false
 		case *s3.Error:
 			// if the error is specific to S3, we can do smart stuff like
 			if s3.IsS3Error(e, s3.ErrNoSuchKey) {
 				// when the key disappeared (occurs very often), don't retry
 				// and quit right away. return no errors so the key is considered
 				// sync'd (nothing to sync)
-				return retry, nil
+				return retry, c, nil, false
+			}
+			if s.isConflict(e) {
+				// the key changed since it was listed, copying it again
+				// won't help
+				metrics.conflicts.Add(1)
+				s.stats.conflicts.Add(1)
+				logrus.WithField("key", key).Warn("key changed since it was listed, not copied")
+				return retry, c, e, false
 			}
 			if shouldAbort(e) {
 				// abort if its an error that will occur for all future calls
@@ -348,7 +1537,9 @@ func (s *SyncTask) syncOrRetry(src, dst *s3.Bucket, key s3.Key) (int, error) {
 					"key":        key,
 					"s3_code":    e.Code,
 					"s3_message": e.Message,
-				}).Fatal("abort worthy error, should not continue to sync before issue is resolved")
+				}).Error("abort worthy error, should not continue to sync before issue is resolved")
+				s.ctl.cancelWith(e)
+				return retry, c, e, false
 			}
 			if !shouldRetry(e) {
 				// give up on that key if it's not retriable, such as a key
@@ -358,25 +1549,101 @@ func (s *SyncTask) syncOrRetry(src, dst *s3.Bucket, key s3.Key) (int, error) {
 					"s3_code":    e.Code,
 					"s3_message": e.Message,
 				}).Warn("unretriable error")
-				return retry, e
+				return retry, c, e, false
 			}
 			// carry on to retry
 		default:
 			// carry on to retry
 		}
-		// log that we sleep, but don't log the error itself just
-		// yet (to avoid logging transient network errors that are
-		// recovered by retrying)
-		metrics.syncRetries.Add(1)
-		sleepFor := s.RetryBase * time.Duration(retry)
+		if retry < s.MaxRetry && !s.spendRetry() {
+			return retry, c, err, false
+		}
+		if handOff && retry < s.MaxRetry {
+			return retry, c, err, true
+		}
+		s.backoff(retry)
+	}
+	return retry, c, err, false
+}
+
+// backoff sleeps after the attempt retry of a key failed, before the next
+// one.
+func (s *SyncTask) backoff(retry int) {
+	// log that we sleep, but don't log the error itself just
+	// yet (to avoid logging transient network errors that are
+	// recovered by retrying)
+	metrics.syncRetries.Add(1)
+	s.stats.retries.Add(1)
+	sleepFor := s.RetryBase * time.Duration(retry)
+	logrus.WithFields(logrus.Fields{
+		"sleep":     sleepFor,
+		"retry":     retry,
+		"max_retry": s.MaxRetry,
+	}).Debug("sleeping on retryable error")
+	time.Sleep(sleepFor)
+}
+
+// callSync calls SyncContext, or Sync, giving up on it after the Timeout of
+// the task, and hedging it if the task does.
+func (s *SyncTask) callSync(src, dst *s3.Bucket, key s3.Key, attempt Attempt) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if s.Timeout > 0 {
+		attempt.Deadline = time.Now().Add(s.Timeout)
+		ctx, cancel = context.WithDeadline(ctx, attempt.Deadline)
+	}
+	defer cancel()
+	call := func(ctx context.Context, attempt Attempt) error {
+		if s.rateCaps != nil {
+			waited, err := s.rateCaps.wait(ctx, s.Mapping.Map(key.Key))
+			if waited {
+				metrics.rateCapped.Add(1)
+				s.stats.rateCapped.Add(1)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if s.scheduler != nil {
+			waited, err := s.scheduler.acquire(ctx)
+			if waited {
+				metrics.scheduleWaits.Add(1)
+			}
+			if err != nil {
+				return err
+			}
+			defer s.scheduler.release()
+		}
+		if s.calls != nil {
+			if err := s.calls.acquire(ctx); err != nil {
+				return err
+			}
+			defer s.calls.release()
+		}
+		if s.SyncContext != nil {
+			return s.SyncContext(ctx, attempt, src, dst, key)
+		}
+		return s.Sync(src, dst, key)
+	}
+	do := func() error { return call(ctx, attempt) }
+	if s.hedger != nil {
+		do = func() error { return s.hedged(ctx, attempt, call) }
+	}
+	if s.Timeout <= 0 {
+		return do()
+	}
+	// buffered, so that a call that timed out doesn't block once it returns
+	done := make(chan error, 1)
+	go func() { done <- do() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
 		logrus.WithFields(logrus.Fields{
-			"sleep":     sleepFor,
-			"retry":     retry,
-			"max_retry": s.MaxRetry,
-		}).Debug("sleeping on retryable error")
-		time.Sleep(sleepFor)
+			"key":     key.Key,
+			"timeout": s.Timeout,
+		}).Debug("sync call timed out")
+		return ErrSyncTimeout
 	}
-	return retry, err
 }
 
 // Classify S3 errors that should be retried.
@@ -422,6 +1689,7 @@ func shouldAbort(err error) bool {
 	case s3.IsS3Error(err, s3.ErrInvalidAccessKeyID):
 	case s3.IsS3Error(err, s3.ErrInvalidBucketName):
 	case s3.IsS3Error(err, s3.ErrNoSuchBucket):
+	case isObjectLockMissing(err):
 	}
 	return true
 }
@@ -1,16 +1,27 @@
 package sync_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"github.com/Shopify/brigade/cmd/events"
+	"github.com/Shopify/brigade/cmd/listing"
 	"github.com/Shopify/brigade/cmd/sync"
 	"github.com/Shopify/brigade/s3mock"
 	"github.com/Sirupsen/logrus"
 	"github.com/kr/pretty"
 	"github.com/pushrax/goamz/s3"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"reflect"
 	"sort"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -20,7 +31,7 @@ func init() {
 }
 
 func TestCanSync(t *testing.T) {
-	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
 
 	mockbkt := s3mock.NewPerfBucket(t)
 	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
@@ -88,8 +99,117 @@ func TestCanSync(t *testing.T) {
 	}
 }
 
+func TestSyncDiscardedOutputs(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 3
+	syncTask.RetryBase = time.Millisecond
+	synced := []io.Writer{ioutil.Discard, ioutil.Discard}
+	var failed bytes.Buffer
+	if err := syncTask.StartSharded(encodeKeys(keys), synced, []io.Writer{&failed}); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	if p := syncTask.Progress(); p.Synced != int64(len(keys)) {
+		t.Errorf("want %d keys synced, got %+v", len(keys), p)
+	}
+	if got := len(mocks3.ListBuckets()["dst-bucket"].Objects); got != len(keys) {
+		t.Errorf("want %d keys copied, got %d", len(keys), got)
+	}
+	if failed.Len() != 0 {
+		t.Errorf("want no key failed, got %v", failed.String())
+	}
+}
+
+func TestSyncListingFormats(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	for _, format := range []string{listing.Binary, listing.MsgPack} {
+		mockbkt := s3mock.NewPerfBucket(t)
+		mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+
+		src := mocks3.S3().Bucket(mockbkt.Name())
+		dst := mocks3.S3().Bucket("dst-bucket")
+		dst.PutBucket(s3.Private) // create it
+
+		var input bytes.Buffer
+		w, err := listing.NewWriter(&input, format)
+		if err != nil {
+			t.Fatalf("can't create listing: %v", err)
+		}
+		keys := mockbkt.Keys()
+		for _, key := range keys {
+			if err := w.Write(key); err != nil {
+				t.Fatalf("can't write key: %v", err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("can't flush listing: %v", err)
+		}
+
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 3
+		syncTask.RetryBase = time.Millisecond
+		syncTask.OutputFormat = format
+		var synced, failed bytes.Buffer
+		if err := syncTask.Start(&input, &synced, &failed); err != nil {
+			t.Fatalf("%s: can't sync: %v", format, err)
+		}
+		mocks3.Close()
+
+		if listing.Detect(bufio.NewReader(bytes.NewReader(synced.Bytes()))) != format {
+			t.Errorf("%s: synced keys aren't in the format of the task", format)
+		}
+		got := sortKeys(readListing(t, &synced))
+		if want := sortKeys(keys); !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: want %d keys synced, got %d", format, len(want), len(got))
+		}
+		if got := readListing(t, &failed); len(got) != 0 {
+			t.Errorf("%s: want no key failed, got %v", format, got)
+		}
+		if p := syncTask.Progress(); p.Lines != int64(len(keys)) || p.Decoded != int64(len(keys)) {
+			t.Errorf("%s: want %d keys read and decoded, got %+v", format, len(keys), p)
+		}
+	}
+}
+
+func readListing(t *testing.T, r io.Reader) []s3.Key {
+	rd, err := listing.NewReader(r)
+	if err != nil {
+		t.Fatalf("can't read listing: %v", err)
+	}
+	var keys []s3.Key
+	for {
+		var key s3.Key
+		switch err := rd.Read(&key); err {
+		case io.EOF:
+			return keys
+		case nil:
+			keys = append(keys, key)
+		default:
+			t.Fatalf("can't read key: %v", err)
+		}
+	}
+}
+
 func TestSyncRecordsError(t *testing.T) {
-	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
 
 	mockbkt := s3mock.NewPerfBucket(t)
 	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
@@ -167,7 +287,7 @@ func TestSyncRecordsError(t *testing.T) {
 }
 
 func TestSyncSucceedWith50PercentErrors(t *testing.T) {
-	time.AfterFunc(time.Second*10, func() { panic("infinite loop?") })
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
 
 	rand.Seed(42)
 
@@ -254,6 +374,304 @@ func TestSyncSucceedWith50PercentErrors(t *testing.T) {
 	}
 }
 
+func TestSyncRecordsMockFailures(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 10
+	syncTask.RetryBase = time.Millisecond
+
+	// copies are denied, which isn't retried
+	mocks3.SetBehavior(s3mock.Behavior{
+		Latency: s3mock.FixedLatency(time.Millisecond),
+		Fail: s3mock.FailMethod("PUT", s3.Error{
+			StatusCode: http.StatusForbidden,
+			Code:       s3.ErrAccessDenied,
+			Message:    "access denied",
+		}),
+	})
+
+	keys := mockbkt.Keys()[:20]
+	var synced, failed bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if synced.Len() != 0 {
+		t.Errorf("want no key synced, got %d", len(decodeKeys(&synced)))
+	}
+	if got := len(decodeKeys(&failed)); got != len(keys) {
+		t.Errorf("want %d keys failed, got %d", len(keys), got)
+	}
+	if got := syncTask.Progress().Retries; got != 0 {
+		t.Errorf("want no retries, got %d", got)
+	}
+}
+
+func TestSyncFromRequesterPaysBucket(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	srcS3, dstS3 := *mocks3.S3(), *mocks3.S3()
+	srcS3.RequesterPays = true
+	src := srcS3.Bucket(mockbkt.Name())
+	dst := dstS3.Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	// like a requester pays bucket, deny the requests that read from the
+	// source without acknowledging they're billed to the requester
+	var billed int
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			readsSrc := strings.HasPrefix(r.URL.Path, "/"+src.Name) ||
+				strings.HasPrefix(r.Header.Get("x-amz-copy-source"), src.Name+"/")
+			if !readsSrc {
+				return nil
+			}
+			if r.Header.Get("x-amz-request-payer") != "requester" {
+				return &s3.Error{StatusCode: http.StatusForbidden, Code: s3.ErrAccessDenied}
+			}
+			billed++
+			return nil
+		},
+	})
+
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 1
+	syncTask.RetryBase = time.Millisecond
+
+	keys := mockbkt.Keys()[:5]
+	var synced, failed bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if got := len(decodeKeys(&synced)); got != len(keys) {
+		t.Errorf("want %d keys synced, got %d (%d failed)", len(keys), got, len(decodeKeys(&failed)))
+	}
+	// a list to check the credentials, and a copy per key
+	if want := len(keys) + 1; billed != want {
+		t.Errorf("want %d requests billed to the requester, got %d", want, billed)
+	}
+}
+
+func TestGetPutSyncer(t *testing.T) {
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	data := []byte("hello world")
+	if err := src.Put("some/key", data, "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	key := s3.Key{Key: "some/key", Size: int64(len(data))}
+	if err := sync.GetPutSyncer(src, dst, key); err != nil {
+		t.Fatalf("can't sync key: %v", err)
+	}
+
+	got, err := dst.Get("some/key")
+	if err != nil {
+		t.Fatalf("key is not in destination: %v", err)
+	}
+	if !bytes.Equal(data, got) {
+		t.Errorf("want %q in destination, got %q", data, got)
+	}
+}
+
+func TestSyncPreservesRedirects(t *testing.T) {
+	sync.RedirectForKey = sync.S3RedirectForKey
+	defer func() { sync.RedirectForKey = sync.NoRedirectForKey }()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	redirects := map[string]string{
+		"old/page.html": "/new/page.html",
+		"elsewhere":     "https://example.com/",
+		"plain.html":    "",
+	}
+	for key, location := range redirects {
+		opts := s3.Options{RedirectLocation: location}
+		if err := src.Put(key, nil, "", s3.Private, opts); err != nil {
+			t.Fatalf("can't put key: %v", err)
+		}
+	}
+
+	for _, syncer := range []sync.SyncerFunc{sync.PutCopySyncer, sync.GetPutSyncer} {
+		for key, want := range redirects {
+			if err := syncer(src, dst, s3.Key{Key: key}); err != nil {
+				t.Fatalf("can't sync %q: %v", key, err)
+			}
+			resp, err := dst.Head(key, nil)
+			if err != nil {
+				t.Fatalf("can't head %q: %v", key, err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("x-amz-website-redirect-location"); got != want {
+				t.Errorf("%q: want redirect to %q, got %q", key, want, got)
+			}
+			if err := dst.Del(key); err != nil {
+				t.Fatalf("can't delete %q: %v", key, err)
+			}
+		}
+	}
+}
+
+func TestSyncNastyKeyNames(t *testing.T) {
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+
+	keys := []string{
+		"with space",
+		"with+plus",
+		"a+b c",
+		"hash#tag",
+		"question?mark=1",
+		"percent%20encoded",
+		"ampersand&co",
+		"dir/ünïcödé/日本.txt",
+		"dir//double/slash",
+	}
+	for _, key := range keys {
+		if err := src.Put(key, []byte(key), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+	}
+	for _, key := range keys {
+		if err := sync.PutCopySyncer(src, dst, s3.Key{Key: key}); err != nil {
+			t.Errorf("can't sync %q: %v", key, err)
+		}
+	}
+
+	got := mocks3.ListBuckets()["dst-bucket"].Objects
+	if len(got) != len(keys) {
+		t.Errorf("want %d keys in destination, got %d", len(keys), len(got))
+	}
+	for _, key := range keys {
+		obj, ok := got[key]
+		if !ok {
+			t.Errorf("%q is missing from destination", key)
+			continue
+		}
+		if string(obj.Data) != key {
+			t.Errorf("%q was copied from %q", key, obj.Data)
+		}
+	}
+}
+
+func TestCopySource(t *testing.T) {
+	tcs := map[string]string{
+		"plain/key.txt": "bkt/plain/key.txt",
+		"a b+c":         "bkt/a%20b%2Bc",
+		"x#y?z=1&w":     "bkt/x%23y%3Fz%3D1%26w",
+		"100%":          "bkt/100%25",
+		"é/~_-.":        "bkt/%C3%A9/~_-.",
+	}
+	for key, want := range tcs {
+		if got := sync.CopySource("bkt", key); got != want {
+			t.Errorf("%q: want %q, got %q", key, want, got)
+		}
+	}
+}
+
+func TestBreakdownPerPrefix(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	syncTask, err := sync.NewSyncTask(src, src)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.DecodePara = 2
+	syncTask.SyncPara = 4
+	syncTask.MaxRetry = 2
+	syncTask.RetryBase = time.Millisecond
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		if strings.HasPrefix(key.Key, "bad/") {
+			return errors.New("nope")
+		}
+		return nil
+	}
+
+	keys := []s3.Key{
+		{Key: "good/a", Size: 1},
+		{Key: "good/b", Size: 2},
+		{Key: "bad/c", Size: 4},
+		{Key: "root", Size: 8},
+	}
+	var synced, failed bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	bd := syncTask.Breakdown()
+	want := map[string]sync.Stats{
+		"good/": {Keys: 2, Bytes: 3, Calls: 2},
+		"bad/":  {Keys: 1, Failures: 1, Calls: 2},
+		"":      {Keys: 1, Bytes: 8, Calls: 1},
+	}
+	if len(bd.Prefixes) != len(want) {
+		t.Fatalf("want %d prefixes, got %d: %v", len(want), len(bd.Prefixes), bd.Prefixes)
+	}
+	for prefix, wantStats := range want {
+		got := bd.Prefixes[prefix]
+		got.Latency = 0
+		if got != wantStats {
+			t.Errorf("prefix %q: want %+v, got %+v", prefix, wantStats, got)
+		}
+	}
+
+	var workerKeys int64
+	for _, stats := range bd.Workers {
+		workerKeys += stats.Keys
+	}
+	if workerKeys != int64(len(keys)) {
+		t.Errorf("want %d keys handled by the workers, got %d", len(keys), workerKeys)
+	}
+}
+
 // encode s3 keys from a json writer, fatals on error
 func encodeKeys(keys []s3.Key) *bytes.Buffer {
 	out := bytes.NewBuffer(nil)
@@ -270,10 +688,11 @@ func encodeKeys(keys []s3.Key) *bytes.Buffer {
 // decode s3 keys from a json reader, fatals on error
 func decodeKeys(in *bytes.Buffer) []s3.Key {
 	var keys []s3.Key
-	dec := json.NewDecoder(in)
+	// failed outputs have failures, which are read as their keys
+	rd, _ := listing.NewReader(in)
 	for {
 		var key s3.Key
-		err := dec.Decode(&key)
+		err := rd.Read(&key)
 		if err == io.EOF {
 			return keys
 		} else if err != nil {
@@ -297,3 +716,332 @@ type keyslice []s3.Key
 func (k keyslice) Len() int           { return len(k) }
 func (k keyslice) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
 func (k keyslice) Less(i, j int) bool { return bytes.Compare([]byte(k[i].Key), []byte(k[j].Key)) == -1 }
+
+func TestSyncStopsOnTooManyFailures(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()
+	for _, tt := range []struct {
+		name        string
+		maxFailures int64
+		maxRate     float64
+		failEvery   int
+		wantErr     error
+	}{
+		{name: "max failures", maxFailures: 5, failEvery: 1, wantErr: sync.ErrTooManyFailures},
+		{name: "max failure rate", maxRate: 0.2, failEvery: 2, wantErr: sync.ErrTooManyFailures},
+		{name: "under the rate", maxRate: 0.2, failEvery: 10},
+	} {
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 1
+		syncTask.RetryBase = time.Millisecond
+		syncTask.MaxFailures = tt.maxFailures
+		syncTask.MaxFailureRate = tt.maxRate
+		calls := 0
+		syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+			calls++
+			if calls%tt.failEvery == 0 {
+				return &s3.Error{Code: "AccessDenied"}
+			}
+			return nil
+		}
+
+		var synced, failed bytes.Buffer
+		err = syncTask.Start(encodeKeys(keys), &synced, &failed)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: want error %v, got %v", tt.name, tt.wantErr, err)
+		}
+		p := syncTask.Progress()
+		switch {
+		case tt.wantErr == nil && calls != len(keys):
+			t.Errorf("%s: want all %d keys synced, got %d", tt.name, len(keys), calls)
+		case tt.wantErr != nil && calls == len(keys):
+			t.Errorf("%s: want the sync stopped early, all %d keys were synced", tt.name, calls)
+		case tt.maxFailures > 0 && p.Failed != tt.maxFailures+1:
+			t.Errorf("%s: want the sync stopped after %d failures, got %d", tt.name, tt.maxFailures+1, p.Failed)
+		}
+	}
+}
+
+func TestSyncTimeout(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:10]
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 1
+	syncTask.RetryBase = time.Millisecond
+	syncTask.Timeout = 20 * time.Millisecond
+
+	// the first call hangs until the test is done
+	hung := make(chan struct{})
+	defer close(hung)
+	var calls int32
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-hung
+		}
+		return nil
+	}
+
+	censored := sync.Latency.Overall().Censored()
+	var synced, failed bytes.Buffer
+	if err := syncTask.Start(encodeKeys(keys), &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	p := syncTask.Progress()
+	if p.Synced != int64(len(keys)) || p.Retries != 1 {
+		t.Errorf("want all %d keys synced after 1 retry, got %+v", len(keys), p)
+	}
+	if got := sync.Latency.Overall().Censored() - censored; got != 1 {
+		t.Errorf("want 1 censored latency, got %d", got)
+	}
+}
+
+func TestContextSyncer(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:10]
+	internal := &s3.Error{StatusCode: 500, Code: s3.ErrInternalError}
+
+	// the first attempt of the first key hangs until it's given up on, its
+	// second one fails, and its third one succeeds
+	var mu gosync.Mutex
+	var attempts []sync.Attempt
+	var aborted bool
+	syncer := func(ctx context.Context, attempt sync.Attempt, src, dst *s3.Bucket, key s3.Key) error {
+		if key.Key != keys[0].Key {
+			return nil
+		}
+		mu.Lock()
+		attempts = append(attempts, attempt)
+		mu.Unlock()
+		switch attempt.Number {
+		case 1:
+			<-ctx.Done()
+			mu.Lock()
+			aborted = ctx.Err() == context.DeadlineExceeded
+			mu.Unlock()
+			return ctx.Err()
+		case 2:
+			return internal
+		}
+		return nil
+	}
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithConcurrency(1),
+		sync.WithRetry(3, time.Millisecond),
+		sync.WithTimeout(20*time.Millisecond),
+		sync.WithContextSyncer(syncer),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	// not called with a context syncer
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		t.Errorf("want the context syncer called for %q", key.Key)
+		return nil
+	}
+
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if p := syncTask.Progress(); p.Synced != int64(len(keys)) || p.Retries != 2 {
+		t.Errorf("want all %d keys synced after 2 retries, got %+v", len(keys), p)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attempts) != 3 {
+		t.Fatalf("want 3 attempts, got %+v", attempts)
+	}
+	if !aborted {
+		t.Errorf("want the context of the hung attempt done at its deadline")
+	}
+	for i, a := range attempts {
+		if a.Number != i+1 || a.Deadline.IsZero() {
+			t.Errorf("want attempt %d with a deadline, got %+v", i+1, a)
+		}
+	}
+	if attempts[0].PrevErr != nil || attempts[1].PrevErr != sync.ErrSyncTimeout || attempts[2].PrevErr != internal {
+		t.Errorf("want the error of each previous attempt, got %+v", attempts)
+	}
+}
+
+func TestRetryLatency(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:10]
+	syncTask, err := sync.NewSyncTask(src, dst, sync.WithRetry(5, time.Millisecond))
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	// the first key fails twice before it's synced
+	var mu gosync.Mutex
+	failures := 2
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if key.Key == keys[0].Key && failures > 0 {
+			failures--
+			return &s3.Error{StatusCode: 500, Code: s3.ErrInternalError}
+		}
+		return nil
+	}
+
+	retries := sync.RetryLatency.Overall().Count()
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	p := syncTask.Progress()
+	if p.Latency["count"] != float64(len(keys)) || p.RetryLatency["count"] != 2 {
+		t.Errorf("want %d first calls and 2 retries timed apart, got %v and %v", len(keys), p.Latency, p.RetryLatency)
+	}
+	if got := sync.RetryLatency.Overall().Count() - retries; got != 2 {
+		t.Errorf("want 2 retries timed for the process, got %d", got)
+	}
+}
+
+// eventSink keeps the events it's sent.
+type eventSink struct {
+	mu     gosync.Mutex
+	events []events.Event
+}
+
+func (s *eventSink) Send(ev events.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+}
+
+func TestSyncEvents(t *testing.T) {
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:10]
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	sink := &eventSink{}
+	syncTask.Events = sink
+	syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+		if key.Key == keys[0].Key {
+			return &s3.Error{Code: "AccessDenied"}
+		}
+		return nil
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	if len(sink.events) != len(keys) {
+		t.Fatalf("want %d events, got %d", len(keys), len(sink.events))
+	}
+	for _, ev := range sink.events {
+		want := events.Synced
+		if ev.Key.Key == keys[0].Key {
+			want = events.Failed
+			if ev.ErrorCode != "AccessDenied" {
+				t.Errorf("want the error code of the failed key, got %+v", ev)
+			}
+		}
+		if ev.Type != want || ev.Source != src.Name || ev.Destination != dst.Name || ev.Time.IsZero() {
+			t.Errorf("want a %s event from %s to %s, got %+v", want, src.Name, dst.Name, ev)
+		}
+	}
+}
+
+func TestSyncRunID(t *testing.T) {
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:4]
+	var audit, failed bytes.Buffer
+	sink := &eventSink{}
+	syncTask, err := sync.NewSyncTask(src, dst,
+		sync.WithRunID("run-1"),
+		sync.WithEvents(sink),
+		sync.WithAudit(sync.NewAuditLog(&audit)),
+		sync.WithRetry(1, time.Millisecond),
+		sync.WithSyncer(func(src, dst *s3.Bucket, key s3.Key) error {
+			if key.Key == keys[0].Key {
+				return &s3.Error{Code: "AccessDenied"}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+
+	var f listing.Failure
+	if err := json.Unmarshal(failed.Bytes(), &f); err != nil || f.RunID != "run-1" {
+		t.Errorf("want the run ID in the failure, got %+v, %v", f, err)
+	}
+	for _, ev := range sink.events {
+		if ev.RunID != "run-1" {
+			t.Errorf("want the run ID in the events, got %+v", ev)
+		}
+	}
+	scan := bufio.NewScanner(&audit)
+	for scan.Scan() {
+		var rec sync.AuditRecord
+		if err := json.Unmarshal(scan.Bytes(), &rec); err != nil || rec.RunID != "run-1" {
+			t.Errorf("want the run ID in the audit records, got %s, %v", scan.Text(), err)
+		}
+	}
+	if sum := syncTask.Summary(); sum.RunID != "run-1" {
+		t.Errorf("want the run ID in the summary, got %+v", sum)
+	}
+}
@@ -0,0 +1,65 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TagFilter picks keys by the tags of their objects, such as only the ones
+// tagged backup=true. A key is picked when all the conditions of the filter
+// hold for its tags.
+type TagFilter []TagCondition
+
+// TagCondition on the tags of a key: that it has the tag with the value,
+// that it has the tag whatever its value when Value is empty, or that it
+// doesn't have the tag when Absent is set.
+type TagCondition struct {
+	Tag    string
+	Value  string
+	Absent bool
+}
+
+// ParseTagFilter parses conditions of the form "backup=true,team,!legacy":
+// tag=value for a tag with a value, tag for a tag with any value, and !tag
+// for a tag the key doesn't have.
+func ParseTagFilter(spec string) (TagFilter, error) {
+	var f TagFilter
+	for _, part := range strings.Split(spec, ",") {
+		var cond TagCondition
+		switch {
+		case strings.HasPrefix(part, "!"):
+			cond = TagCondition{Tag: part[1:], Absent: true}
+		case strings.Contains(part, "="):
+			i := strings.IndexByte(part, '=')
+			cond = TagCondition{Tag: part[:i], Value: part[i+1:]}
+			if cond.Value == "" {
+				return nil, fmt.Errorf("tag condition %q has no value, use %q for any value", part, cond.Tag)
+			}
+		default:
+			cond = TagCondition{Tag: part}
+		}
+		if cond.Tag == "" {
+			return nil, fmt.Errorf("tag condition %q has no tag", part)
+		}
+		f = append(f, cond)
+	}
+	return f, nil
+}
+
+// Match tells whether the filter picks a key with the tags.
+func (f TagFilter) Match(tags map[string]string) bool {
+	for _, cond := range f {
+		value, ok := tags[cond.Tag]
+		switch {
+		case cond.Absent:
+			if ok {
+				return false
+			}
+		case !ok:
+			return false
+		case cond.Value != "" && value != cond.Value:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"time"
+)
+
+// TrashPrefix is the prefix of the trash of a bucket, where a DeleteTask
+// with a Quarantine moves the keys rather than deleting them, under the ID
+// of its run, until they're purged.
+const TrashPrefix = ".brigade-trash/"
+
+// QuarantinePrefix is the prefix of the trash of a run.
+func QuarantinePrefix(runID string) string { return TrashPrefix + runID + "/" }
+
+// ExpiredTrash is a listing.Writer that writes the keys of the trash of a
+// bucket that were moved to it before a time to W, counting them and their
+// bytes, for a purge to delete them. The other keys are left out.
+type ExpiredTrash struct {
+	W      listing.Writer
+	Before time.Time
+
+	Keys, Bytes int64
+}
+
+func (e *ExpiredTrash) Write(key s3.Key) error {
+	if !strings.HasPrefix(key.Key, TrashPrefix) {
+		return nil
+	}
+	// the copies in the trash were last modified when they were moved
+	moved, err := time.Parse(time.RFC3339, key.LastModified)
+	if err != nil {
+		return fmt.Errorf("bad last modified time %q of %q: %v", key.LastModified, key.Key, err)
+	}
+	if !moved.Before(e.Before) {
+		return nil
+	}
+	e.Keys++
+	e.Bytes += key.Size
+	return e.W.Write(key)
+}
+
+// Flush the keys written to W.
+func (e *ExpiredTrash) Flush() error { return e.W.Flush() }
+
+// quarantine moves the keys of a batch to the trash, returning those to
+// delete: the keys copied to it, and those already gone. The keys that
+// couldn't be copied are failed, and kept.
+func (d *DeleteTask) quarantine(batch []s3.Key, failed chan<- listing.Failure) []s3.Key {
+	pending := make([]s3.Key, 0, len(batch))
+	for _, key := range batch {
+		if strings.HasPrefix(key.Key, TrashPrefix) {
+			d.stats.trashed.Add(1)
+			continue
+		}
+		retries, err := d.moveToTrash(key)
+		switch {
+		case err == nil:
+			deleteMetrics.quarantined.Add(1)
+			d.stats.quarantined.Add(1)
+		case isNotFound(err):
+			// gone already, which deleting it confirms
+		default:
+			deleteMetrics.failed.Add(1)
+			d.stats.failed.Add(1)
+			f := listing.Failure{Key: key, Error: err.Error(), Retries: retries, Time: time.Now().UTC(), RunID: d.RunID}
+			if e, ok := err.(*s3.Error); ok {
+				f.ErrorCode = e.Code
+			}
+			logrus.WithFields(logrus.Fields{
+				"key":   key.Key,
+				"error": err,
+			}).Error("failed to move key to the trash")
+			failed <- f
+			continue
+		}
+		pending = append(pending, key)
+	}
+	return pending
+}
+
+// moveToTrash copies a key under the Quarantine prefix, retrying it like
+// the deletes, and returns how many times it was retried.
+func (d *DeleteTask) moveToTrash(key s3.Key) (int, error) {
+	for retry := 1; ; retry++ {
+		_, err := d.bkt.PutCopy(d.Quarantine+key.Key, s3.Private, s3.CopyOptions{}, CopySource(d.bkt.Name, key.Key))
+		if err == nil || isNotFound(err) || !retriable(err) || retry >= d.MaxRetry {
+			return retry - 1, err
+		}
+		deleteMetrics.retries.Add(1)
+		d.stats.retries.Add(1)
+		time.Sleep(d.RetryBase * time.Duration(retry))
+	}
+}
@@ -0,0 +1,118 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeleteQuarantine(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	bkt := mocks3.S3().Bucket("dst-bucket")
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		t.Fatalf("can't create bucket: %v", err)
+	}
+	for _, name := range []string{"a", "b/c", "kept", ".brigade-trash/old-run/d"} {
+		if err := bkt.Put(name, []byte(name), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", name, err)
+		}
+	}
+	// "gone" no longer exists, and the key already in the trash stays there
+	keys := []s3.Key{{Key: "a"}, {Key: "b/c"}, {Key: "gone"}, {Key: ".brigade-trash/old-run/d"}}
+
+	task, err := sync.NewDeleteTask(bkt)
+	if err != nil {
+		t.Fatalf("can't create delete task: %v", err)
+	}
+	task.RetryBase = time.Millisecond
+	task.Quarantine = sync.QuarantinePrefix("run-1")
+
+	var deleted, failed bytes.Buffer
+	if err := task.Start(encodeKeys(keys), &deleted, &failed); err != nil {
+		t.Fatalf("can't delete: %v", err)
+	}
+	if got := decodeKeys(&deleted); len(got) != 3 {
+		t.Errorf("want 3 deleted keys, got %+v", got)
+	}
+	if failed.Len() != 0 {
+		t.Errorf("want no failed keys, got %q", failed.String())
+	}
+	var names []string
+	for name, obj := range mocks3.ListBuckets()["dst-bucket"].Objects {
+		if strings.HasPrefix(name, ".brigade-trash/run-1/") && string(obj.Data) != strings.TrimPrefix(name, ".brigade-trash/run-1/") {
+			t.Errorf("want %q moved to the trash as is, got %q", name, obj.Data)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != ".brigade-trash/old-run/d .brigade-trash/run-1/a .brigade-trash/run-1/b/c kept" {
+		t.Errorf("want the keys moved to the trash, got %s", got)
+	}
+	if p := task.Progress(); p.Deleted != 3 || p.Quarantined != 2 || p.Trashed != 1 {
+		t.Errorf("want 3 deleted, 2 quarantined and 1 already trashed, got %+v", p)
+	}
+
+	// a batch with only keys already in the trash has nothing to delete
+	var posts int32
+	mocks3.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			if r.Method == "POST" {
+				atomic.AddInt32(&posts, 1)
+			}
+			return nil
+		},
+	})
+	deleted.Reset()
+	if err := task.Start(encodeKeys([]s3.Key{{Key: ".brigade-trash/old-run/d"}}), &deleted, &failed); err != nil {
+		t.Fatalf("can't delete: %v", err)
+	}
+	if deleted.Len() != 0 || failed.Len() != 0 {
+		t.Errorf("want no key deleted or failed, got %q and %q", deleted.String(), failed.String())
+	}
+	if n := atomic.LoadInt32(&posts); n != 0 {
+		t.Errorf("want no delete call, got %d", n)
+	}
+}
+
+func TestExpiredTrash(t *testing.T) {
+	now := time.Date(2017, 3, 10, 0, 0, 0, 0, time.UTC)
+	keys := []s3.Key{
+		{Key: ".brigade-trash/run-1/a", Size: 1, LastModified: "2017-03-01T00:00:00.000Z"},
+		{Key: ".brigade-trash/run-2/b", Size: 2, LastModified: "2017-03-09T00:00:00.000Z"},
+		{Key: ".brigade-trash/run-1/c", Size: 4, LastModified: "2017-02-01T00:00:00.000Z"},
+		{Key: "kept", Size: 8, LastModified: "2017-01-01T00:00:00.000Z"},
+	}
+	var buf bytes.Buffer
+	w, err := listing.NewWriter(&buf, listing.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := &sync.ExpiredTrash{W: w, Before: now.Add(-7 * 24 * time.Hour)}
+	for _, key := range keys {
+		if err := expired.Write(key); err != nil {
+			t.Fatalf("can't write %q: %v", key.Key, err)
+		}
+	}
+	if err := expired.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, key := range decodeKeys(&buf) {
+		names = append(names, key.Key)
+	}
+	if got := strings.Join(names, " "); got != ".brigade-trash/run-1/a .brigade-trash/run-1/c" || expired.Keys != 2 || expired.Bytes != 5 {
+		t.Errorf("want the keys moved to the trash over a week ago, got %s, %d keys of %d bytes", got, expired.Keys, expired.Bytes)
+	}
+}
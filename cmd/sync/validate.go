@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"time"
+)
+
+// maxInputProblems is how many of the problems of a sample are kept in an
+// InputReport, the others are only counted.
+const maxInputProblems = 10
+
+// InputProblem is a line of a listing, or a key of a binary listing,
+// counting from 1, that isn't a usable key.
+type InputProblem struct {
+	Line    int64  `json:"line"`
+	Problem string `json:"problem"`
+}
+
+// InputReport is what ValidateInput found at the start of a listing.
+type InputReport struct {
+	Format string `json:"format"`
+	// Sampled lines, or keys of a binary listing, and the bytes they took.
+	Sampled int64 `json:"sampled"`
+	Bytes   int64 `json:"bytes"`
+	// Complete is set when the sample is the whole listing.
+	Complete bool `json:"complete"`
+	// Problems are the first of the problems found, out of ProblemCount.
+	Problems     []InputProblem `json:"problems,omitempty"`
+	ProblemCount int64          `json:"problem_count"`
+}
+
+// OK is true when the sample holds keys, or the listing is empty, and none
+// of them has problems.
+func (r InputReport) OK() bool {
+	return r.ProblemCount == 0 && (r.Sampled > 0 || r.Complete)
+}
+
+// EstimateKeys in the listing, from the size of the sample when read bytes
+// of a listing of size bytes were read for it, such as those of a gzipped
+// listing. It's 0 when the size isn't known.
+func (r InputReport) EstimateKeys(read, size int64) int64 {
+	switch {
+	case r.Complete:
+		return r.Sampled
+	case read <= 0 || size <= 0:
+		return 0
+	}
+	return int64(float64(r.Sampled) * float64(size) / float64(read))
+}
+
+func (r *InputReport) problem(line int64, format string, args ...interface{}) {
+	r.ProblemCount++
+	if len(r.Problems) < maxInputProblems {
+		r.Problems = append(r.Problems, InputProblem{Line: line, Problem: fmt.Sprintf(format, args...)})
+	}
+}
+
+// ValidateInput decodes the first sample keys of a listing, in the format
+// it's detected to be in, and checks that they can be synced, so that a
+// sync of a corrupt or unexpected listing can be refused before it starts.
+func ValidateInput(r io.Reader, sample int64) (InputReport, error) {
+	counted := NewCountingReader(r)
+	rd := bufio.NewReader(counted)
+	report := InputReport{Format: listing.Detect(rd)}
+	if report.Format != listing.JSON {
+		return report, validateListing(rd, counted, sample, &report)
+	}
+
+	dec := NewKeyDecoder()
+	var key s3.Key
+	for report.Sampled < sample {
+		line, size, err := readLine(rd, DefaultMaxLine)
+		if err == io.EOF {
+			break
+		}
+		report.Sampled++
+		report.Bytes += size
+		switch {
+		case err == errLineTooLong:
+			report.problem(report.Sampled, "line longer than %d bytes", DefaultMaxLine)
+		case err != nil:
+			return report, err
+		default:
+			if err := dec.Decode(line, &key); err != nil {
+				report.problem(report.Sampled, "not a key: %v", err)
+			} else {
+				validateKey(report.Sampled, key, &report)
+			}
+		}
+	}
+	report.Complete = atEOF(rd)
+	return report, nil
+}
+
+// atEOF is true when rd has nothing left to read.
+func atEOF(rd *bufio.Reader) bool {
+	_, err := rd.Peek(1)
+	return err == io.EOF
+}
+
+// validateListing decodes the keys of a binary or msgpack listing, which
+// can't be decoded past a corrupt key.
+func validateListing(rd *bufio.Reader, counted *CountingReader, sample int64, report *InputReport) error {
+	lr, err := listing.NewReader(rd)
+	if err != nil {
+		return err
+	}
+	var key s3.Key
+	for report.Sampled < sample {
+		err := lr.Read(&key)
+		if err == io.EOF {
+			break
+		}
+		report.Sampled++
+		if err != nil {
+			report.problem(report.Sampled, "not a key: %v", err)
+			break
+		}
+		validateKey(report.Sampled, key, report)
+	}
+	report.Complete = report.ProblemCount == 0 && atEOF(rd)
+	// less what's buffered ahead of the keys read
+	report.Bytes = counted.Count() - int64(rd.Buffered())
+	return nil
+}
+
+// validateKey checks that the fields of a key hold what a sync needs.
+func validateKey(line int64, key s3.Key, report *InputReport) {
+	switch {
+	case key.Key == "":
+		report.problem(line, "key without a name")
+	case key.Size < 0:
+		report.problem(line, "key %q has a negative size %d", key.Key, key.Size)
+	case key.ETag == "":
+		report.problem(line, "key %q has no ETag", key.Key)
+	case key.LastModified == "":
+		report.problem(line, "key %q has no last modified date", key.Key)
+	default:
+		if _, err := time.Parse(time.RFC3339, key.LastModified); err != nil {
+			report.problem(line, "key %q has an invalid last modified date %q", key.Key, key.LastModified)
+		}
+	}
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a line of rd, its \n included, or reads through it and
+// returns errLineTooLong when it's longer than max bytes. It also returns
+// the size of the line.
+func readLine(rd *bufio.Reader, max int64) ([]byte, int64, error) {
+	var line []byte
+	var size int64
+	for {
+		chunk, err := rd.ReadSlice('\n')
+		size += int64(len(chunk))
+		if size <= max {
+			line = append(line, chunk...)
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case err == io.EOF && size == 0:
+			return nil, 0, io.EOF
+		case err != nil && err != io.EOF:
+			return nil, size, err
+		case size > max:
+			return nil, size, errLineTooLong
+		}
+		return line, size, nil
+	}
+}
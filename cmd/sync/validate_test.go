@@ -0,0 +1,80 @@
+package sync_test
+
+import (
+	"bytes"
+	"github.com/Shopify/brigade/cmd/listing"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/pushrax/goamz/s3"
+	"strings"
+	"testing"
+)
+
+func TestValidateInput(t *testing.T) {
+	keys := []s3.Key{
+		{Key: "a", Size: 1, ETag: `"a"`, LastModified: "2014-04-11T14:37:45.000Z"},
+		{Key: "b", Size: 2, ETag: `"b"`, LastModified: "2014-04-11T14:37:46.000Z"},
+		{Key: "c", Size: 3, ETag: `"c"`, LastModified: "2014-04-11T14:37:47.000Z"},
+	}
+	input := encodeKeys(keys).String()
+
+	report, err := sync.ValidateInput(strings.NewReader(input), 10)
+	if err != nil {
+		t.Fatalf("can't validate input: %v", err)
+	}
+	if !report.OK() || !report.Complete || report.Format != listing.JSON || report.Sampled != 3 {
+		t.Errorf("want all the keys sampled and valid, got %+v", report)
+	}
+
+	// the keys are estimated from the bytes the sample took
+	report, err = sync.ValidateInput(strings.NewReader(input), 2)
+	if err != nil {
+		t.Fatalf("can't validate input: %v", err)
+	}
+	if report.Complete || report.Sampled != 2 {
+		t.Errorf("want 2 keys sampled, got %+v", report)
+	}
+	if got := report.EstimateKeys(report.Bytes, int64(len(input))); got != 3 {
+		t.Errorf("want 3 keys estimated, got %d", got)
+	}
+
+	var binary bytes.Buffer
+	w, err := listing.NewWriter(&binary, listing.Binary)
+	if err != nil {
+		t.Fatalf("can't create listing: %v", err)
+	}
+	for _, key := range keys {
+		if err := w.Write(key); err != nil {
+			t.Fatalf("can't write key: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("can't flush listing: %v", err)
+	}
+	report, err = sync.ValidateInput(&binary, 10)
+	if err != nil {
+		t.Fatalf("can't validate binary input: %v", err)
+	}
+	if !report.OK() || !report.Complete || report.Format != listing.Binary || report.Sampled != 3 {
+		t.Errorf("want all the binary keys sampled and valid, got %+v", report)
+	}
+
+	for _, bad := range []string{
+		"<html>not found</html>\n",
+		strings.Repeat("x", sync.DefaultMaxLine+1) + "\n",
+		`{"Key":"a","Size":1,"LastModified":"2014-04-11T14:37:45.000Z"}` + "\n",
+		`{"Key":"a","Size":1,"ETag":"\"a\"","LastModified":"yesterday"}` + "\n",
+	} {
+		report, err := sync.ValidateInput(strings.NewReader(input+bad), 10)
+		if err != nil {
+			t.Fatalf("can't validate input: %v", err)
+		}
+		if report.OK() || report.ProblemCount != 1 || report.Problems[0].Line != 4 {
+			t.Errorf("want a problem with line 4, got %+v", report)
+		}
+	}
+
+	// nothing to sync, but nothing wrong either
+	if report, err := sync.ValidateInput(strings.NewReader(""), 10); err != nil || !report.OK() {
+		t.Errorf("want an empty listing valid, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,215 @@
+package sync
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/pipeline"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mismatch is a key synced by a task that doesn't match its source at the
+// destination, where it's named Name.
+type Mismatch struct {
+	Key    s3.Key `json:"key"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Verification is the outcome of Verify: how many keys were sampled, those
+// that don't match their source, and how many couldn't be checked. Read is
+// how many keys and copies were read in full to checksum them.
+type Verification struct {
+	Sampled    int
+	Mismatches []Mismatch
+	Errors     int
+	Read       int
+}
+
+// sampled is a key picked to be verified, with its name at the destination.
+type sampled struct {
+	key  s3.Key
+	name string
+}
+
+// ParseSample parses the fraction of keys to verify, either a percentage
+// such as 1% or a fraction such as 0.01.
+func ParseSample(s string) (float64, error) {
+	if !strings.HasSuffix(s, "%") {
+		return parseRate(s)
+	}
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	return parseRate(strconv.FormatFloat(pct/100, 'g', -1, 64))
+}
+
+// pickSample picks a synced key at random, with probability VerifySample,
+// for Verify to check once the task is done.
+func (s *SyncTask) pickSample(key s3.Key) {
+	if s.VerifySample <= 0 || rand.Float64() >= s.VerifySample {
+		return
+	}
+	name := s.DestKey(key.Key)
+	s.sampleMu.Lock()
+	defer s.sampleMu.Unlock()
+	s.sample = append(s.sample, sampled{key: key, name: name})
+}
+
+// Verify HEADs the sample of the keys synced by the task at the destination,
+// with SyncPara requests at a time, once the task is done. The keys that are
+// missing, of another size, or with another ETag when their source wasn't
+// uploaded in parts, are mismatches. So are the keys stamped by PreserveMTime
+// with a Last-Modified time older than the one they were listed with. With a
+// Checksum, the keys whose ETags can't tell are compared by their sums. It
+// gives some confidence in a sync without a second full pass.
+func (s *SyncTask) Verify() Verification {
+	s.sampleMu.Lock()
+	sample := s.sample
+	s.sampleMu.Unlock()
+
+	var (
+		mu      sync.Mutex
+		v       = Verification{Sampled: len(sample)}
+		workers pipeline.Workers
+	)
+	keys := make(chan sampled)
+	para := s.SyncPara
+	if para < 1 {
+		para = 1
+	}
+	workers.Start(para, func(int) {
+		for k := range keys {
+			reason, read, err := s.verifyKey(k)
+			mu.Lock()
+			v.Read += read
+			switch {
+			case err != nil:
+				v.Errors++
+				logrus.WithFields(logrus.Fields{
+					"key":   k.name,
+					"error": err,
+				}).Error("couldn't verify key")
+			case reason != "":
+				v.Mismatches = append(v.Mismatches, Mismatch{Key: k.key, Name: k.name, Reason: reason})
+			}
+			mu.Unlock()
+		}
+	})
+	for _, k := range sample {
+		keys <- k
+	}
+	close(keys)
+	workers.Wait()
+
+	metrics.verifySampled.Add(int64(v.Sampled))
+	metrics.verifyMismatches.Add(int64(len(v.Mismatches)))
+	metrics.verifyReads.Add(int64(v.Read))
+	return v
+}
+
+// verifyKey checks a sampled key at the destination, returning why it
+// doesn't match its source, if it doesn't, and how many objects were read to
+// checksum them.
+func (s *SyncTask) verifyKey(k sampled) (string, int, error) {
+	var headers map[string][]string
+	if s.Checksum != nil {
+		headers = s.Checksum.Headers()
+	}
+	resp, err := s.dst.Head(k.name, headers)
+	if e, ok := err.(*s3.Error); ok && e.StatusCode == http.StatusNotFound {
+		return "missing at the destination", 0, nil
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.ContentLength != k.key.Size {
+		return fmt.Sprintf("size is %d, want %d", resp.ContentLength, k.key.Size), 0, nil
+	}
+	var read int
+	etag := resp.Header.Get("ETag")
+	switch {
+	case k.key.ETag != "" && !multipart(k.key.ETag) && !multipart(etag):
+		if !sameETag(etag, k.key.ETag) {
+			return fmt.Sprintf("etag is %s, want %s", etag, k.key.ETag), 0, nil
+		}
+	case s.Checksum != nil:
+		var reason string
+		reason, read, err = s.verifyChecksum(k, resp.Header)
+		if err != nil || reason != "" {
+			return reason, read, err
+		}
+	}
+	if resp.Header.Get("x-amz-meta-"+SourceMTimeMeta) != "" && k.key.LastModified != "" {
+		listed, err := time.Parse(time.RFC3339, k.key.LastModified)
+		if err != nil {
+			return "", read, fmt.Errorf("bad last modified time %q: %v", k.key.LastModified, err)
+		}
+		copied, err := SourceMTime(resp.Header)
+		if err != nil {
+			return "", read, err
+		}
+		// stamps are to the second
+		if copied.Before(listed.Truncate(time.Second)) {
+			return fmt.Sprintf("copied from the source as of %s, listed as of %s", copied.Format(time.RFC3339), k.key.LastModified), read, nil
+		}
+	}
+	return "", read, nil
+}
+
+// verifyChecksum compares a sampled key and its copy, whose headers are
+// given, by their sums. The sum the key carries is the one of the data it
+// was uploaded with, and is used as is. The one its copy carries was copied
+// along with it, unless S3 computed it, so the copy is read unless it did.
+// It returns why they differ, if they do, and how many were read.
+func (s *SyncTask) verifyChecksum(k sampled, dst http.Header) (string, int, error) {
+	c := *s.Checksum
+	var read int
+	resp, err := s.src.Head(k.key.Key, c.Headers())
+	if err != nil {
+		return "", 0, fmt.Errorf("heading source: %v", err)
+	}
+	_ = resp.Body.Close()
+	want, ok := c.Header(resp.Header)
+	if !ok {
+		if want, err = readSum(c, s.src, k.key.Key); err != nil {
+			return "", read, fmt.Errorf("checksumming source: %v", err)
+		}
+		read++
+	}
+	got, ok := c.Header(dst)
+	if !ok || !c.Computed() {
+		if got, err = readSum(c, s.dst, k.name); err != nil {
+			return "", read, fmt.Errorf("checksumming destination: %v", err)
+		}
+		read++
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Sprintf("%s is %x, want %x", c.Hash.Name(), got, want), read, nil
+	}
+	return "", read, nil
+}
+
+// readSum reads an object in full to checksum it.
+func readSum(c checksum.Checksum, b *s3.Bucket, name string) ([]byte, error) {
+	rc, err := b.GetReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rc.Close() }()
+	return c.Sum(rc)
+}
+
+// multipart is true if an ETag is the one of an object uploaded in parts,
+// which isn't the MD5 of its data and so changes when it's copied.
+func multipart(etag string) bool { return strings.Contains(etag, "-") }
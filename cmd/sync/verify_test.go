@@ -0,0 +1,145 @@
+package sync_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/checksum"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseSample(t *testing.T) {
+	for spec, want := range map[string]float64{"1%": 0.01, "0.25": 0.25, "100%": 1} {
+		if got, err := sync.ParseSample(spec); err != nil || got != want {
+			t.Errorf("%q: want %v, got %v, %v", spec, want, got, err)
+		}
+	}
+	for _, spec := range []string{"150%", "-1", "x%", ""} {
+		if _, err := sync.ParseSample(spec); err == nil {
+			t.Errorf("%q: want an error", spec)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	var keys []s3.Key
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := src.Put(key, []byte("data"), "", s3.Private, s3.Options{}); err != nil {
+			t.Fatalf("can't put %q: %v", key, err)
+		}
+		keys = append(keys, s3.Key{Key: key, Size: 4, ETag: fmt.Sprintf(`"%x"`, md5.Sum([]byte("data")))})
+	}
+
+	syncTask, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	syncTask.SyncPara = 2
+	syncTask.RetryBase = time.Millisecond
+	syncTask.VerifySample = 1
+	syncTask.Sync = sync.PutCopySyncer
+	if err := syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if v := syncTask.Verify(); v.Sampled != 4 || len(v.Mismatches) != 0 || v.Errors != 0 {
+		t.Errorf("want all the keys verified without mismatch, got %+v", v)
+	}
+
+	// "b" went missing, "c" and "d" changed since they were synced
+	if err := dst.Del("b"); err != nil {
+		t.Fatalf("can't delete key: %v", err)
+	}
+	if err := dst.Put("c", []byte("more data"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	if err := dst.Put("d", []byte("atad"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	v := syncTask.Verify()
+	var got []string
+	for _, m := range v.Mismatches {
+		got = append(got, m.Name)
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != "[b c d]" {
+		t.Errorf("want keys b, c and d mismatched, got %+v", v.Mismatches)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mocks3 := s3mock.NewMock(t)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket("src-bucket")
+	dst := mocks3.S3().Bucket("dst-bucket")
+	for _, bkt := range []*s3.Bucket{src, dst} {
+		if err := bkt.PutBucket(s3.Private); err != nil {
+			t.Fatalf("can't create bucket: %v", err)
+		}
+	}
+	c, err := checksum.ParseChecksum("sha256:content-sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("data"))
+	carried := s3.Options{Meta: map[string][]string{"content-sha256": {hex.EncodeToString(sum[:])}}}
+	// the ETags of keys uploaded in parts can't tell whether their copies
+	// match: "a" carries its sum, "b" doesn't and its copy differs
+	for _, obj := range []struct {
+		bkt        *s3.Bucket
+		name, data string
+		opts       s3.Options
+	}{
+		{src, "a", "data", carried},
+		{dst, "a", "data", s3.Options{}},
+		{src, "b", "data", s3.Options{}},
+		{dst, "b", "atad", s3.Options{}},
+	} {
+		if err := obj.bkt.Put(obj.name, []byte(obj.data), "", s3.Private, obj.opts); err != nil {
+			t.Fatalf("can't put %q: %v", obj.name, err)
+		}
+	}
+	keys := []s3.Key{{Key: "a", Size: 4, ETag: `"abc-2"`}, {Key: "b", Size: 4, ETag: `"def-2"`}}
+
+	verify := func(opts ...sync.Option) sync.Verification {
+		nop := func(src, dst *s3.Bucket, key s3.Key) error { return nil }
+		task, err := sync.NewSyncTask(src, dst, append(opts, sync.WithSyncer(nop), sync.WithVerifySample(1))...)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		if err := task.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard); err != nil {
+			t.Fatalf("can't sync: %v", err)
+		}
+		return task.Verify()
+	}
+
+	if v := verify(); len(v.Mismatches) != 0 || v.Read != 0 {
+		t.Errorf("want the keys uploaded in parts only compared by size, got %+v", v)
+	}
+	// "a" is compared to the sum it carries, "b" is read too
+	v := verify(sync.WithChecksum(c))
+	if len(v.Mismatches) != 1 || v.Mismatches[0].Name != "b" || v.Read != 3 || v.Errors != 0 {
+		t.Errorf("want b mismatched after reading 3 objects, got %+v", v)
+	}
+}
@@ -0,0 +1,142 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"os"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is returned by Start when the task was aborted by its watchdog
+// because no key completed for too long.
+var ErrStalled = errors.New("sync task stalled")
+
+// The actions a watchdog takes on a stalled task. Each action also takes
+// the ones before it: stacks are dumped when workers are restarted, for
+// instance.
+const (
+	// StallLog logs an error.
+	StallLog = "log"
+	// StallDump dumps the stacks of all the goroutines to stderr, to tell
+	// where the workers are stuck.
+	StallDump = "dump"
+	// StallRestart starts a new set of workers to take over the remaining
+	// keys. The stuck workers can't be interrupted, they stop once their
+	// key is done, and the task still waits for them before it completes.
+	StallRestart = "restart"
+	// StallAbort cancels the task, which then returns ErrStalled. If the
+	// task is still stalled after another period, the process exits.
+	StallAbort = "abort"
+)
+
+var stallLevels = map[string]int{StallLog: 0, StallDump: 1, StallRestart: 2, StallAbort: 3}
+
+// stackDump is where the stacks are dumped.
+var stackDump io.Writer = os.Stderr
+
+// Watchdog detects a task that looks busy, but where no key completed for a
+// while, for instance because all its workers are wedged on connections
+// that will never answer.
+type Watchdog struct {
+	// After is how long the task can go without completing a key, while it
+	// has keys to sync and isn't paused, before it's considered stalled.
+	After time.Duration
+	// Action taken when the task stalls, one of StallLog, StallDump,
+	// StallRestart or StallAbort.
+	Action string
+}
+
+// Validate checks that the watchdog can detect stalls.
+func (w Watchdog) Validate() error {
+	if w.After <= 0 {
+		return fmt.Errorf("watchdog period must be positive, got %v", w.After)
+	}
+	if _, ok := stallLevels[w.Action]; !ok {
+		return fmt.Errorf("unknown stall action %q, want log, dump, restart or abort", w.Action)
+	}
+	return nil
+}
+
+// watch the task until its input is done and its workers are idle, taking
+// the action of the watchdog when it stalls. restart starts a new
+// generation of workers. The caller runs the watcher with the workers, so
+// that workers can be restarted until it's done.
+func (s *SyncTask) watch(inputDone <-chan struct{}, keysQueued func() int, restart func(gen int64)) {
+	// check often enough to notice the end of the task quickly
+	every := s.Watchdog.After / 4
+	if every > time.Second {
+		every = time.Second
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+
+	lastDone := int64(-1)
+	lastProgress := time.Now()
+	for now := range tick.C {
+		busy := s.stats.busy.Value() > 0 || keysQueued() > 0
+		select {
+		case <-inputDone:
+			if !busy {
+				return
+			}
+		default:
+		}
+
+		progress := s.Progress()
+		done := progress.Synced + progress.Failed + progress.Skipped
+		if done != lastDone || !busy || progress.Paused {
+			lastDone = done
+			lastProgress = now
+			continue
+		}
+		if stalled := now.Sub(lastProgress); stalled >= s.Watchdog.After {
+			s.stalled(stalled, progress, restart)
+			lastProgress = now
+		}
+	}
+}
+
+// stalled takes the action of the watchdog on a task that didn't complete
+// a key for a while.
+func (s *SyncTask) stalled(stalled time.Duration, progress Progress, restart func(gen int64)) {
+	metrics.stalls.Add(1)
+	level := stallLevels[s.Watchdog.Action]
+	entry := logrus.WithFields(logrus.Fields{
+		"stalled_for": stalled,
+		"action":      s.Watchdog.Action,
+		"inflight":    progress.Inflight,
+		"synced":      progress.Synced,
+		"failed":      progress.Failed,
+	})
+	if level >= stallLevels[StallAbort] && progress.Cancelled {
+		dumpStacks()
+		entry.Fatal("sync task still stalled after it was aborted, exiting")
+	}
+	entry.Error("no key was sync'd for too long, sync task is stalled")
+
+	if level >= stallLevels[StallDump] {
+		dumpStacks()
+	}
+	switch s.Watchdog.Action {
+	case StallRestart:
+		gen := atomic.AddInt64(&s.generation, 1)
+		logrus.WithFields(logrus.Fields{
+			"generation":   gen,
+			"sync_workers": s.SyncPara,
+		}).Warn("restarting sync workers, stuck workers stop once their key is done")
+		restart(gen)
+	case StallAbort:
+		s.ctl.cancelWith(ErrStalled)
+	}
+}
+
+func dumpStacks() {
+	logrus.Warn("dumping the stacks of all goroutines")
+	if err := pprof.Lookup("goroutine").WriteTo(stackDump, 2); err != nil {
+		logrus.WithField("error", err).Error("failed to dump goroutine stacks")
+	}
+}
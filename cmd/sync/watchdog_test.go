@@ -0,0 +1,95 @@
+package sync_test
+
+import (
+	"errors"
+	"expvar"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func stalls(t *testing.T) int64 {
+	n, err := strconv.ParseInt(expvar.Get("brigade.sync.stalls").String(), 10, 64)
+	if err != nil {
+		t.Fatalf("can't read stalls: %v", err)
+	}
+	return n
+}
+
+func TestWatchdogValidate(t *testing.T) {
+	valid := sync.Watchdog{After: time.Minute, Action: sync.StallRestart}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("want %+v to be valid, got %v", valid, err)
+	}
+	for _, w := range []sync.Watchdog{
+		{Action: sync.StallLog},
+		{After: time.Minute},
+		{After: time.Minute, Action: "reboot"},
+	} {
+		if err := w.Validate(); err == nil {
+			t.Errorf("want %+v to be invalid", w)
+		}
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	defer time.AfterFunc(time.Second*10, func() { panic("infinite loop?") }).Stop()
+
+	mockbkt := s3mock.NewPerfBucket(t)
+	mocks3 := s3mock.NewMock(t).Seed(mockbkt)
+	defer mocks3.Close()
+
+	src := mocks3.S3().Bucket(mockbkt.Name())
+	dst := mocks3.S3().Bucket("dst-bucket")
+	dst.PutBucket(s3.Private) // create it
+
+	keys := mockbkt.Keys()[:20]
+	for _, tt := range []struct {
+		action  string
+		wantErr error
+		synced  int64
+	}{
+		// the wedged worker is replaced, the other keys are sync'd
+		{action: sync.StallRestart, synced: int64(len(keys))},
+		// the task is cancelled while the worker is wedged on its first key
+		{action: sync.StallAbort, wantErr: sync.ErrStalled, synced: 1},
+	} {
+		syncTask, err := sync.NewSyncTask(src, dst)
+		if err != nil {
+			t.Fatalf("can't create sync task: %v", err)
+		}
+		syncTask.SyncPara = 1
+		syncTask.Watchdog = &sync.Watchdog{After: 20 * time.Millisecond, Action: tt.action}
+
+		// the first key wedges its worker until the watchdog acts
+		before := stalls(t)
+		release := make(chan struct{})
+		go func() {
+			for stalls(t) == before || (tt.wantErr != nil && !syncTask.Progress().Cancelled) {
+				time.Sleep(time.Millisecond)
+			}
+			close(release)
+		}()
+		syncTask.Sync = func(src, dst *s3.Bucket, key s3.Key) error {
+			if key.Key == keys[0].Key {
+				<-release
+			}
+			return nil
+		}
+
+		err = syncTask.Start(encodeKeys(keys), ioutil.Discard, ioutil.Discard)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: want error %v, got %v", tt.action, tt.wantErr, err)
+		}
+		if got := stalls(t) - before; got != 1 {
+			t.Errorf("%s: want 1 stall, got %d", tt.action, got)
+		}
+		if got := syncTask.Progress().Synced; got != tt.synced {
+			t.Errorf("%s: want %d keys synced, got %d", tt.action, tt.synced, got)
+		}
+	}
+}
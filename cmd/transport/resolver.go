@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// LookupFunc looks up the addresses of a host.
+type LookupFunc func(ctx context.Context, host string) ([]string, error)
+
+// Resolver caches the addresses of the hosts it dials. The addresses of a
+// host are refreshed in the background once they're older than the refresh
+// interval, give or take a fifth of it so that the hosts aren't all looked
+// up at once, and are kept when the lookup fails, so that a DNS server that
+// flaps doesn't fail the connections to hosts it resolved before.
+type Resolver struct {
+	refresh time.Duration
+	lookup  LookupFunc
+
+	mu    sync.Mutex
+	hosts map[string]*cachedHost
+}
+
+type cachedHost struct {
+	addrs []string
+	// next address dialed, so that the connections spread over them
+	next       int
+	expires    time.Time
+	refreshing bool
+	// ready is closed once the first lookup of the host is done
+	ready chan struct{}
+	err   error
+}
+
+// NewResolver creates a resolver that refreshes the addresses it caches
+// every refresh. lookup is the lookup of the system when nil.
+func NewResolver(refresh time.Duration, lookup LookupFunc) (*Resolver, error) {
+	if refresh <= 0 {
+		return nil, fmt.Errorf("DNS refresh interval must be positive, got %v", refresh)
+	}
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	return &Resolver{
+		refresh: refresh,
+		lookup:  lookup,
+		hosts:   make(map[string]*cachedHost),
+	}, nil
+}
+
+// Lookup the addresses of a host, from the cache when they're there.
+func (r *Resolver) Lookup(ctx context.Context, host string) ([]string, error) {
+	addrs, _, err := r.resolve(ctx, host)
+	return addrs, err
+}
+
+// resolve a host, returning its addresses starting at the next one to dial.
+func (r *Resolver) resolve(ctx context.Context, host string) ([]string, int, error) {
+	r.mu.Lock()
+	h, ok := r.hosts[host]
+	if !ok {
+		h = &cachedHost{ready: make(chan struct{})}
+		r.hosts[host] = h
+	}
+	r.mu.Unlock()
+	if !ok {
+		r.update(host, h)
+	}
+
+	select {
+	case <-h.ready:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h.addrs == nil {
+		return nil, 0, h.err
+	}
+	if time.Now().After(h.expires) && !h.refreshing {
+		h.refreshing = true
+		go r.update(host, h)
+	}
+	next := h.next
+	h.next = (h.next + 1) % len(h.addrs)
+	return h.addrs, next, nil
+}
+
+// update the addresses of a host with a lookup, keeping the ones it had if
+// the lookup fails.
+func (r *Resolver) update(host string, h *cachedHost) {
+	metrics.lookups.Add(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	addrs, err := r.lookup(ctx, host)
+	cancel()
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses for %q", host)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h.refreshing = false
+	// staggers the refreshes of the hosts
+	jitter := time.Duration((rand.Float64()*0.4 - 0.2) * float64(r.refresh))
+	h.expires = time.Now().Add(r.refresh + jitter)
+	switch {
+	case err == nil:
+		h.addrs, h.err = addrs, nil
+		if h.next >= len(addrs) {
+			h.next = 0
+		}
+	case h.addrs != nil:
+		metrics.dnsErrors.Add(1)
+		logrus.WithFields(logrus.Fields{
+			"host":  host,
+			"addrs": h.addrs,
+			"error": err,
+		}).Warn("couldn't refresh the addresses of the host, keeping the ones cached")
+	default:
+		metrics.dnsErrors.Add(1)
+		h.err = err
+		// the next connection looks it up again
+		delete(r.hosts, host)
+	}
+	select {
+	case <-h.ready:
+	default:
+		close(h.ready)
+	}
+}
+
+// dialContext dials the addresses of the hosts from the cache, trying each
+// address of a host in turn until one connects.
+func (r *Resolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		addrs, next, err := r.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error
+		for i := range addrs {
+			ip := addrs[(next+i)%len(addrs)]
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
@@ -0,0 +1,135 @@
+// Package transport makes the HTTP transport that the S3 requests of a sync
+// share, so that their connections are kept alive from one request to the
+// next. The connections to an endpoint can be opened ahead of the sync, a
+// few at a time, rather than by all the sync workers at once as it starts,
+// and the addresses of the endpoints are cached, so that a DNS server that
+// flaps in the middle of a sync doesn't fail its requests.
+package transport
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var metrics = struct {
+	lookups   *expvar.Int
+	dnsErrors *expvar.Int
+	prewarmed *expvar.Int
+}{
+	lookups:   expvar.NewInt("brigade.transport.lookups"),
+	dnsErrors: expvar.NewInt("brigade.transport.dnsErrors"),
+	prewarmed: expvar.NewInt("brigade.transport.prewarmed"),
+}
+
+// Options of a transport.
+type Options struct {
+	// ConnectTimeout is how long dialing and the TLS handshake of a
+	// connection can take, and ReadTimeout how long S3 can take to answer
+	// a request.
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	// MaxIdleConnsPerHost is how many connections to an endpoint are kept
+	// alive between requests.
+	MaxIdleConnsPerHost int
+	// Resolver, when set, resolves the endpoints, rather than a lookup for
+	// each connection.
+	Resolver *Resolver
+}
+
+// New creates a transport that keeps its connections alive.
+func New(opts Options) *http.Transport {
+	dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.ReadTimeout,
+	}
+	if opts.Resolver != nil {
+		t.DialContext = opts.Resolver.dialContext(dialer)
+	}
+	return t
+}
+
+// Prewarm opens n connections to the endpoint, at most parallel of them at a
+// time, which the transport then keeps alive for the requests to come. They
+// are opened with an anonymous GET on the root of the endpoint, whose answer
+// doesn't matter, and are only kept if the transport keeps that many idle
+// connections. Returns how many connections were opened.
+func Prewarm(t *http.Transport, endpoint string, n, parallel int) (int, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return 0, fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+	}
+	root := u.Scheme + "://" + u.Host + "/"
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// the requests hold their connections until they're all done, by not
+	// reading the bodies of their answers, else they'd take turns on the
+	// same few connections
+	var (
+		opened  sync.WaitGroup
+		release = make(chan struct{})
+		slots   = make(chan struct{}, parallel)
+		mu      sync.Mutex
+		ok      int
+		lastErr error
+	)
+	client := &http.Client{
+		Transport: t,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	var held sync.WaitGroup
+	for i := 0; i < n; i++ {
+		opened.Add(1)
+		held.Add(1)
+		go func() {
+			defer held.Done()
+			slots <- struct{}{}
+			resp, err := client.Get(root)
+			<-slots
+			mu.Lock()
+			if err != nil {
+				lastErr = err
+			} else {
+				ok++
+			}
+			mu.Unlock()
+			opened.Done()
+			if err != nil {
+				return
+			}
+			<-release
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}()
+	}
+	opened.Wait()
+	close(release)
+	held.Wait()
+
+	metrics.prewarmed.Add(int64(ok))
+	logrus.WithFields(logrus.Fields{
+		"endpoint":    root,
+		"connections": ok,
+		"errors":      n - ok,
+	}).Info("prewarmed connections")
+	if ok == 0 && lastErr != nil {
+		return 0, fmt.Errorf("prewarming %q: %v", root, lastErr)
+	}
+	return ok, nil
+}
@@ -0,0 +1,132 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Shopify/brigade/cmd/transport"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolver(t *testing.T) {
+	var mu sync.Mutex
+	var lookups int
+	var flapping bool
+	lookup := func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups++
+		if flapping {
+			return nil, errors.New("no such host")
+		}
+		return []string{"10.0.0.1", "10.0.0.2"}, nil
+	}
+	r, err := transport.NewResolver(10*time.Millisecond, lookup)
+	if err != nil {
+		t.Fatalf("can't create resolver: %v", err)
+	}
+	if _, err := transport.NewResolver(0, lookup); err == nil {
+		t.Errorf("want a positive refresh interval")
+	}
+
+	want := []string{"10.0.0.1", "10.0.0.2"}
+	for i := 0; i < 3; i++ {
+		addrs, err := r.Lookup(context.Background(), "s3.amazonaws.com")
+		if err != nil || !reflect.DeepEqual(addrs, want) {
+			t.Fatalf("want %v, got %v, %v", want, addrs, err)
+		}
+	}
+	mu.Lock()
+	if lookups != 1 {
+		t.Errorf("want the addresses looked up once, got %d lookups", lookups)
+	}
+	// the DNS server flaps once the addresses expired
+	flapping = true
+	mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		addrs, err := r.Lookup(context.Background(), "s3.amazonaws.com")
+		if err != nil || !reflect.DeepEqual(addrs, want) {
+			t.Fatalf("want the cached %v, got %v, %v", want, addrs, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	if lookups < 2 {
+		t.Errorf("want the addresses refreshed, got %d lookups", lookups)
+	}
+	mu.Unlock()
+
+	if _, err := r.Lookup(context.Background(), "unknown.example.com"); err == nil {
+		t.Errorf("want an error for a host never resolved")
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	var conns int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// the endpoint is dialed through the resolver
+	u, _ := url.Parse(srv.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+	r, err := transport.NewResolver(time.Minute, func(ctx context.Context, name string) ([]string, error) {
+		return []string{host}, nil
+	})
+	if err != nil {
+		t.Fatalf("can't create resolver: %v", err)
+	}
+	tr := transport.New(transport.Options{
+		ConnectTimeout:      time.Second,
+		ReadTimeout:         time.Second,
+		MaxIdleConnsPerHost: 10,
+		Resolver:            r,
+	})
+	endpoint := "http://s3.example.com:" + port
+
+	n, err := transport.Prewarm(tr, endpoint, 8, 3)
+	if err != nil || n != 8 {
+		t.Fatalf("want 8 connections prewarmed, got %d, %v", n, err)
+	}
+	if got := atomic.LoadInt64(&conns); got != 8 {
+		t.Errorf("want 8 connections opened, got %d", got)
+	}
+
+	// the requests of the sync reuse them
+	client := &http.Client{Transport: tr}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(endpoint + "/bucket/key")
+			if err != nil {
+				t.Errorf("can't get: %v", err)
+				return
+			}
+			_, _ = ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt64(&conns); got > 8 {
+		t.Errorf("want the prewarmed connections reused, got %d connections", got)
+	}
+}
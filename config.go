@@ -1,48 +1,305 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/Shopify/brigade/cmd/batch"
+	"github.com/Shopify/brigade/cmd/creds"
+	"github.com/Shopify/brigade/cmd/kms"
+	"github.com/Sirupsen/logrus"
 	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
 	"io"
+	"os"
+	"regexp"
+	"sync"
 )
 
 // BucketConfig is the information needed to create an s3.Bucket object.
 type BucketConfig struct {
+	// Region of the S3 endpoint. The buckets that are synced are reached
+	// through the endpoint of their own region when it's another one, see
+	// regionalBucket.
 	Region    string `json:"aws_region"`
 	AccessKey string `json:"aws_access_key"`
 	SecretKey string `json:"aws_secret_key"`
+	// Signature is the version of the scheme used to sign the requests, "v2"
+	// or "v4". It's optional, regions that support "v2" use it by default
+	// and other regions use "v4".
+	Signature string `json:"aws_signature"`
+	// RequesterPays must be set to read from requester pays buckets, the
+	// requests and data transfer are then billed to this account.
+	RequesterPays bool `json:"requester_pays"`
+	// Accelerate uploads through the transfer acceleration endpoint of the
+	// bucket, which must have acceleration enabled.
+	Accelerate bool `json:"accelerate"`
+	// SSECustomerKey is the base64 encoded 256-bit key of the objects of
+	// the bucket encrypted with a customer-provided key (SSE-C). The objects
+	// of the source are read with it, and the ones of the destination are
+	// written with it.
+	SSECustomerKey string `json:"sse_customer_key"`
+	// Credentials is where the credentials of the requests come from:
+	// "static", the default, signs them with the keys of the config, "env"
+	// with the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN variables, "instance" with the role of the EC2
+	// instance, "sts" with the role RoleARN assumed with the keys of the
+	// config, or those of the instance without keys, and "vault" with the
+	// ones leased from VaultPath of the AWS secrets engine of Vault, at
+	// VaultAddr or $VAULT_ADDR with $VAULT_TOKEN. Temporary credentials are
+	// renewed before they expire, as the requests go on.
+	Credentials string `json:"aws_credentials"`
+	RoleARN     string `json:"aws_role_arn"`
+	ExternalID  string `json:"aws_external_id"`
+	VaultAddr   string `json:"vault_addr"`
+	VaultPath   string `json:"vault_path"`
 }
 
+// Sources of credentials, see BucketConfig.Credentials.
+const (
+	credsStatic   = "static"
+	credsEnv      = "env"
+	credsInstance = "instance"
+	credsSTS      = "sts"
+	credsVault    = "vault"
+)
+
+// regionName matches the names of AWS regions, like eu-central-1.
+var regionName = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
 func (b *BucketConfig) validate() error {
 
 	switch {
 	case b.Region == "":
 		return errors.New("need an region name")
+	case b.Credentials != "" && b.Credentials != credsStatic:
+		if err := b.validateCredentials(); err != nil {
+			return err
+		}
 	case b.AccessKey == "":
 		return errors.New("need an access key")
 	case b.SecretKey == "":
 		return errors.New("need a secret key")
 	}
 
-	if _, ok := aws.Regions[b.Region]; !ok {
+	_, known := aws.Regions[b.Region]
+	if !known && !regionName.MatchString(b.Region) {
 		var valids []string
 		for key := range aws.Regions {
 			valids = append(valids, key)
 		}
-		return fmt.Errorf("not a valid AWS region, valid regions: %v", valids)
+		return fmt.Errorf("not a valid AWS region, valid regions: %v or newer regions", valids)
+	}
+
+	switch b.Signature {
+	case "", "v4":
+	case "v2":
+		if !known {
+			return fmt.Errorf("region %q only supports v4 signatures", b.Region)
+		}
+	default:
+		return fmt.Errorf("not a valid signature version %q, want v2 or v4", b.Signature)
+	}
+
+	if b.SSECustomerKey != "" {
+		key, err := base64.StdEncoding.DecodeString(b.SSECustomerKey)
+		if err != nil {
+			return fmt.Errorf("SSE-C key is not base64 encoded: %v", err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("SSE-C key must be 256 bits, got %d", len(key)*8)
+		}
 	}
 
 	return nil
 }
 
-// AWS returns an auth and region object for the bucket.
+func (b *BucketConfig) validateCredentials() error {
+	switch b.Credentials {
+	case credsEnv, credsInstance:
+	case credsSTS:
+		if b.RoleARN == "" {
+			return errors.New("need a role ARN to assume")
+		}
+		if (b.AccessKey == "") != (b.SecretKey == "") {
+			return errors.New("need both an access key and a secret key, or neither")
+		}
+	case credsVault:
+		switch {
+		case b.VaultPath == "":
+			return errors.New("need the vault path of the credentials")
+		case b.VaultAddr == "" && os.Getenv("VAULT_ADDR") == "":
+			return errors.New("need the address of vault, or VAULT_ADDR")
+		case os.Getenv("VAULT_TOKEN") == "":
+			return errors.New("need a VAULT_TOKEN to read the credentials from vault")
+		}
+	default:
+		return fmt.Errorf("not a valid source of credentials %q, want static, env, instance, sts or vault", b.Credentials)
+	}
+	return nil
+}
+
+// AWS returns an auth and region object for the bucket. Regions that goamz
+// doesn't know about are reached through their regional S3 endpoint. The
+// auth of credentials that aren't static is the one of the moment, which
+// isn't renewed.
 func (b *BucketConfig) AWS() (aws.Auth, aws.Region) {
-	return aws.Auth{
+	auth := aws.Auth{
 		AccessKey: b.AccessKey,
 		SecretKey: b.SecretKey,
-	}, aws.Regions[b.Region]
+	}
+	if provider := b.provider(); provider != nil {
+		var err error
+		if auth, err = provider.Credentials(); err != nil {
+			logrus.WithField("error", err).Error("couldn't get credentials")
+		}
+	}
+	return auth, awsRegion(b.Region)
+}
+
+// providers are shared by the S3 objects of the same config, so that they
+// renew their credentials together.
+var providers = struct {
+	sync.Mutex
+	m map[BucketConfig]aws.CredentialsProvider
+}{m: make(map[BucketConfig]aws.CredentialsProvider)}
+
+// provider returns the provider of the credentials of the config, nil for
+// static keys.
+func (b *BucketConfig) provider() aws.CredentialsProvider {
+	if b.Credentials == "" || b.Credentials == credsStatic {
+		return nil
+	}
+	providers.Lock()
+	defer providers.Unlock()
+	if p, ok := providers.m[*b]; ok {
+		return p
+	}
+
+	var f creds.Fetcher
+	switch b.Credentials {
+	case credsEnv:
+		f = creds.Env{}
+	case credsInstance:
+		f = creds.InstanceMetadata{}
+	case credsSTS:
+		var base aws.CredentialsProvider = creds.Static{Auth: aws.Auth{AccessKey: b.AccessKey, SecretKey: b.SecretKey}}
+		if b.AccessKey == "" {
+			base = creds.NewCached(creds.InstanceMetadata{})
+		}
+		f = creds.AssumeRole{Base: base, RoleARN: b.RoleARN, ExternalID: b.ExternalID}
+	case credsVault:
+		addr := b.VaultAddr
+		if addr == "" {
+			addr = os.Getenv("VAULT_ADDR")
+		}
+		f = creds.Vault{Addr: addr, Path: b.VaultPath, Token: os.Getenv("VAULT_TOKEN")}
+	}
+	p := creds.NewCached(f)
+	providers.m[*b] = p
+	return p
+}
+
+// credentials of the bucket, static keys included, for the clients of the
+// other AWS services.
+func (b *BucketConfig) credentials() aws.CredentialsProvider {
+	if p := b.provider(); p != nil {
+		return p
+	}
+	return creds.Static{Auth: aws.Auth{AccessKey: b.AccessKey, SecretKey: b.SecretKey}}
+}
+
+// KMS returns a client of KMS in the region of the bucket, with its
+// credentials, which generates data keys under keyID.
+func (b *BucketConfig) KMS(keyID string) kms.Client {
+	return kms.Client{Credentials: b.credentials(), Region: b.Region, KeyID: keyID}
+}
+
+// Batch returns a client of the batch jobs of an account in the region of
+// the bucket, with its credentials.
+func (b *BucketConfig) Batch(accountID string) batch.Client {
+	return batch.Client{Credentials: b.credentials(), AccountID: accountID, Region: b.Region}
+}
+
+func awsRegion(name string) aws.Region {
+	if region, ok := aws.Regions[name]; ok {
+		return region
+	}
+	return aws.Region{
+		Name:                 name,
+		S3Endpoint:           "https://s3." + name + ".amazonaws.com",
+		S3LocationConstraint: true,
+		S3LowercaseBucket:    true,
+		SQSEndpoint:          "https://sqs." + name + ".amazonaws.com",
+		CloudWatchServicepoint: aws.ServiceInfo{
+			Endpoint: "https://monitoring." + name + ".amazonaws.com",
+			Signer:   aws.V4Signature,
+		},
+	}
+}
+
+// S3 returns the S3 object of the bucket, which signs requests with the
+// version of the scheme the region supports unless one is configured.
+func (b *BucketConfig) S3() *s3.S3 {
+	s := s3.New(aws.Auth{AccessKey: b.AccessKey, SecretKey: b.SecretKey}, awsRegion(b.Region))
+	s.Credentials = b.provider()
+	_, known := aws.Regions[b.Region]
+	switch {
+	case b.Signature == "v4", !known:
+		s.Signature = aws.V4Signature
+	default:
+		s.Signature = aws.V2Signature
+	}
+	s.RequesterPays = b.RequesterPays
+	// validated with the config
+	s.CustomerKey, _ = base64.StdEncoding.DecodeString(b.SSECustomerKey)
+	return s
+}
+
+// regionalBucket returns the named bucket, reached through the endpoint of
+// the region it's in, which S3 is asked for, rather than the one of s. A
+// bucket reached through another region answers every request with a
+// redirect. If S3 can't say where the bucket is, such as without the
+// s3:GetBucketLocation permission, the region of s is used.
+func regionalBucket(s *s3.S3, name string) *s3.Bucket {
+	bkt := s.Bucket(name)
+	location, err := bkt.Location()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"bucket": name,
+			"region": s.Region.Name,
+			"error":  err,
+		}).Warn("couldn't find the region of the bucket, using the one of the config")
+		return bkt
+	}
+	if location == s.Region.Name {
+		return bkt
+	}
+	logrus.WithFields(logrus.Fields{
+		"bucket":     name,
+		"region":     location,
+		"configured": s.Region.Name,
+	}).Warn("bucket is in another region than the one of the config, using its region")
+	regional := *s
+	regional.Region = awsRegion(location)
+	// such as the transfer acceleration endpoint, which serves all regions
+	regional.Region.S3BucketEndpoint = s.Region.S3BucketEndpoint
+	if _, known := aws.Regions[location]; !known {
+		regional.Signature = aws.V4Signature
+	}
+	return regional.Bucket(name)
+}
+
+// UploadS3 is like S3, but goes through the transfer acceleration endpoint of
+// the buckets if the config enables it. Copies within S3 can't be
+// accelerated, only uploads of data read from elsewhere.
+func (b *BucketConfig) UploadS3() *s3.S3 {
+	s := b.S3()
+	if b.Accelerate {
+		s.Region.S3BucketEndpoint = "https://${bucket}.s3-accelerate.amazonaws.com"
+	}
+	return s
 }
 
 // Config contains authentication info for the AWS buckets. We use
@@ -52,6 +309,29 @@ type Config struct {
 	Source      BucketConfig `json:"source_bucket"`
 	Destination BucketConfig `json:"destination_bucket"`
 	State       BucketConfig `json:"state_bucket"`
+
+	// Queue is optional, the credentials of the state bucket are used for
+	// the work queue if it's not set.
+	Queue BucketConfig `json:"queue"`
+	// Monitoring is optional, the credentials of the state bucket are used
+	// to publish metrics if it's not set.
+	Monitoring BucketConfig `json:"monitoring"`
+}
+
+// QueueConfig is the configuration to use to access the work queue.
+func (c Config) QueueConfig() BucketConfig {
+	if c.Queue == (BucketConfig{}) {
+		return c.State
+	}
+	return c.Queue
+}
+
+// MonitoringConfig is the configuration to use to publish metrics.
+func (c Config) MonitoringConfig() BucketConfig {
+	if c.Monitoring == (BucketConfig{}) {
+		return c.State
+	}
+	return c.Monitoring
 }
 
 func (c Config) validate() error {
@@ -64,6 +344,16 @@ func (c Config) validate() error {
 	if err := c.State.validate(); err != nil {
 		return fmt.Errorf("state config is not valid: %v", err)
 	}
+	if c.Queue != (BucketConfig{}) {
+		if err := c.Queue.validate(); err != nil {
+			return fmt.Errorf("queue config is not valid: %v", err)
+		}
+	}
+	if c.Monitoring != (BucketConfig{}) {
+		if err := c.Monitoring.validate(); err != nil {
+			return fmt.Errorf("monitoring config is not valid: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// confirmPlan shows the plan of a destructive command and asks to go on
+// with it, like terraform apply: only "yes" goes on. Without a terminal to
+// answer from, such as in a script, the plan is refused, the command must
+// be told -yes instead.
+func confirmPlan(plan string) bool {
+	fmt.Fprintf(os.Stderr, "Plan: %s.\n", plan)
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		logrus.WithField("plan", plan).Error("refusing to go on without a terminal to confirm the plan, run with -yes to skip confirming it")
+		return false
+	}
+	fmt.Fprint(os.Stderr, "Only 'yes' will be accepted to go on: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil || strings.TrimSpace(answer) != "yes" {
+		logrus.WithField("plan", plan).Error("plan wasn't confirmed, stopping")
+		return false
+	}
+	return true
+}
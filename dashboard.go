@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// controlHeader must be set on the requests that pause and resume the syncs.
+// Browsers only send it cross-origin after a preflight that the handler
+// doesn't answer, so that other pages can't pause a sync through the
+// browser of an operator.
+const controlHeader = "X-Brigade-Control"
+
+// taskControl pauses and resumes a running sync from the dashboard.
+type taskControl struct {
+	pause, resume func()
+}
+
+var dashboardControls = struct {
+	sync.Mutex
+	tasks map[string]taskControl
+}{tasks: make(map[string]taskControl)}
+
+// onDashboardControl registers a running sync, by name, to be paused and
+// resumed from the dashboard.
+func onDashboardControl(name string, pause, resume func()) {
+	dashboardControls.Lock()
+	defer dashboardControls.Unlock()
+	dashboardControls.tasks[name] = taskControl{pause: pause, resume: resume}
+}
+
+// handleDashboard serves the dashboard on the monitoring handler, with the
+// endpoints pausing and resuming the syncs. The dashboard follows /events.
+func handleDashboard(mux *http.ServeMux) {
+	mux.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(dashboardHTML))
+	})
+	mux.HandleFunc("/dashboard/pause", controlHandler(func(c taskControl) { c.pause() }))
+	mux.HandleFunc("/dashboard/resume", controlHandler(func(c taskControl) { c.resume() }))
+}
+
+// controlHandler applies fn to the sync named by the name parameter, or to
+// all of them without one, and replies with the names of the syncs.
+func controlHandler(fn func(taskControl)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get(controlHeader) == "" {
+			http.Error(w, "missing "+controlHeader+" header", http.StatusForbidden)
+			return
+		}
+		name := r.URL.Query().Get("name")
+
+		dashboardControls.Lock()
+		names := []string{}
+		for n, c := range dashboardControls.tasks {
+			if name == "" || n == name {
+				fn(c)
+				names = append(names, n)
+			}
+		}
+		dashboardControls.Unlock()
+
+		if name != "" && len(names) == 0 {
+			http.Error(w, "no sync named "+name, http.StatusNotFound)
+			return
+		}
+		sort.Strings(names)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string][]string{"syncs": names})
+	}
+}
+
+// dashboardHTML is a single page following /events: the counters and rates
+// of each sync, a sparkline of the keys synced per second, the latency
+// percentiles of the first sync calls and the last keys that failed.
+const dashboardHTML = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>brigade</title>
+<style>
+body { font: 14px sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #ddd; }
+th:first-child, td:first-child { text-align: left; }
+#state { color: #888; }
+#errors td { text-align: left; font-family: monospace; }
+canvas { border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>brigade <span id="state">connecting</span></h1>
+<button onclick="control('pause')">Pause</button>
+<button onclick="control('resume')">Resume</button>
+
+<h2>Syncs</h2>
+<table id="syncs">
+<tr><th>sync</th><th>synced</th><th>failed</th><th>skipped</th><th>inflight</th><th>retries</th><th>bytes</th><th>keys/s</th><th>MB/s</th><th>retries/s</th><th>done</th><th>ETA</th><th>state</th></tr>
+</table>
+
+<h2>Keys synced a second</h2>
+<canvas id="spark" width="600" height="80"></canvas>
+
+<h2>Latency of the first sync calls</h2>
+<table id="latency"><tr><th>p50</th><th>p95</th><th>p99</th><th>p99.9</th><th>max</th></tr><tr><td colspan="5">-</td></tr></table>
+
+<h2>Recent failures</h2>
+<table id="errors"><tr><th>time</th><th>key</th><th>code</th><th>error</th></tr></table>
+
+<script>
+var syncs = {}, rates = [], failures = [];
+
+function fmt(n) { return Math.round(n).toLocaleString(); }
+function ms(n) { return n.toFixed(1) + " ms"; }
+
+function row(table, id, cells) {
+  var tr = document.getElementById(id);
+  if (!tr) {
+    tr = table.insertRow(-1);
+    tr.id = id;
+  }
+  tr.innerHTML = "";
+  cells.forEach(function(c) { tr.insertCell(-1).textContent = c; });
+}
+
+function draw() {
+  var canvas = document.getElementById("spark"), ctx = canvas.getContext("2d");
+  ctx.clearRect(0, 0, canvas.width, canvas.height);
+  var max = Math.max.apply(null, rates.concat([1]));
+  ctx.beginPath();
+  rates.forEach(function(r, i) {
+    var x = i * canvas.width / 120, y = canvas.height - r / max * (canvas.height - 4) - 2;
+    if (i == 0) { ctx.moveTo(x, y); } else { ctx.lineTo(x, y); }
+  });
+  ctx.stroke();
+  ctx.fillText(fmt(max) + " keys/s", 4, 12);
+}
+
+function progress(snap) {
+  syncs[snap.name] = snap;
+  var state = snap.done ? "done" : snap.cancelled ? "cancelled" : snap.paused ? "paused" : "running";
+  row(document.getElementById("syncs"), "sync-" + snap.name, [
+    snap.name, fmt(snap.synced), fmt(snap.failed), fmt(snap.skipped), fmt(snap.inflight),
+    fmt(snap.retries), fmt(snap.bytes), fmt(snap.recent_rate.keys_per_s),
+    (snap.recent_rate.bytes_per_s / 1e6).toFixed(1),
+    (snap.recent_rate.retries_per_s || 0).toFixed(1),
+    snap.fraction ? (snap.fraction * 100).toFixed(1) + "%" : "-",
+    snap.eta ? new Date(snap.eta).toLocaleString() : "-", state]);
+
+  var total = 0;
+  for (var name in syncs) { total += syncs[name].recent_rate.keys_per_s; }
+  rates.push(total);
+  if (rates.length > 120) { rates.shift(); }
+  draw();
+
+  var l = snap.latency || {};
+  if (l.count) {
+    var table = document.getElementById("latency");
+    table.deleteRow(1);
+    row(table, "latency-row", [ms(l.p50_ms), ms(l.p95_ms), ms(l.p99_ms), ms(l.p999_ms), ms(l.max_ms)]);
+  }
+}
+
+function failed(ev) {
+  failures.unshift(ev);
+  failures = failures.slice(0, 20);
+  var table = document.getElementById("errors");
+  while (table.rows.length > 1) { table.deleteRow(1); }
+  failures.forEach(function(f, i) {
+    row(table, "error-" + i, [new Date(f.time).toLocaleTimeString(), f.key.Key, f.error_code || "", f.error || ""]);
+  });
+}
+
+function control(action) {
+  fetch("/dashboard/" + action, {method: "POST", headers: {"` + controlHeader + `": "1"}})
+    .then(function(resp) { if (!resp.ok) { alert(action + " failed: " + resp.status); } });
+}
+
+var events = new EventSource("/events");
+events.onopen = function() { document.getElementById("state").textContent = "following"; };
+events.onerror = function() { document.getElementById("state").textContent = "disconnected"; };
+events.addEventListener("progress", function(e) { progress(JSON.parse(e.data)); });
+events.addEventListener("failed", function(e) { failed(JSON.parse(e.data)); });
+</script>
+</body>
+</html>
+`
@@ -15,12 +15,45 @@ accessible from an incompatible set of credentials for the original bucket.
 In a scenario where the original bucket is compromised and destroyed, the
 copy would be up and relatively fresh, while inaccessible by an attacker.
 
-    list     Lists the keys in an S3 bucket.
-    sync     Syncs the keys from a source S3 bucket to another.
-    slice    Slice an S3 key listing into multiple sub-listings.
-    diff     Generates a differential listing of S3 keys.
-    backup   Executes list, diff and sync from a source to a destination bucket.
-    help, h  Shows a list of commands or help for one command
+Other Go programs can list, diff and sync buckets the way the commands do
+with package github.com/Shopify/brigade/brigade.
+
+Brigade is a Go module, built with make build, which embeds the version of
+the build, from its release tag, its commit and when it was built. Releases
+are tagged with make release RELEASE=v1.2.0. brigade --version, the first
+line logged by each run, the manifests of syncs and /version of the
+monitoring handler report the build, to tie bug reports and manifests to it.
+
+    list           Lists the keys in an S3 bucket.
+    sync           Syncs the keys from a source S3 bucket to another.
+    ingest         Ingests the files of an SFTP server or HTTP manifest into an S3 bucket.
+    slice          Slice an S3 key listing into multiple sub-listings.
+    split          Splits a key listing into listings of balanced bytes.
+    diff           Generates a differential listing of S3 keys.
+    batch          Hands the keys of a listing over to S3 Batch Operations.
+    delete         Deletes the keys of a listing from an S3 bucket.
+    purge          Empties the trash of keys moved to it by delete -quarantine.
+    head           Enriches the keys of a listing with the metadata of their objects.
+    bucket-config  Copies the configuration of a bucket to another.
+    backup         Executes list, diff and sync from a source to a destination bucket.
+    merge          Merges sharded key listings into a single one.
+    convert        Converts key listings between the JSON, binary and msgpack formats.
+    estimate       Reports the keys and bytes of a listing or a bucket.
+    bench          Measures the throughput and latency of S3 on buckets, with synthetic keys.
+    soak           Syncs synthetic keys for hours through faults, restarts and credential rotations, checking none is lost.
+    lint           Reports the keys of a listing likely to cause problems.
+    drift          Compares the synced keys to the latest S3 Inventory of the destination.
+    compare        Checks that a destination holds the keys of a source, with the same content.
+    audit          Checks that the copies of keys have their ACL, tags and metadata.
+    plan           Splits a key listing into partitions by top-level prefix.
+    execute        Syncs the partitions of a plan.
+    status         Queries the state file of a sync.
+    watch          Follows the progress of a running sync.
+    coordinate     Distributes a key listing over a work queue.
+    work           Syncs the keys pulled from a work queue.
+    daemon         Runs sync jobs submitted over an RPC control API.
+    serve          Runs sync jobs submitted over a REST API.
+    help, h        Shows a list of commands or help for one command
 
 */
 package main
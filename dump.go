@@ -0,0 +1,53 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"time"
+)
+
+var statsDumps = struct {
+	sync.Mutex
+	dumps []func(w io.Writer)
+}{}
+
+// onStatsDump registers the stats of a running task, written by dump when
+// the process is asked for its stats.
+func onStatsDump(dump func(w io.Writer)) {
+	statsDumps.Lock()
+	defer statsDumps.Unlock()
+	statsDumps.dumps = append(statsDumps.dumps, dump)
+}
+
+// dumpStats writes the metrics of the process, the stats of its tasks and
+// a summary of its goroutines to w, to tell what a run that looks stalled
+// is up to, without stopping it.
+func dumpStats(w io.Writer) {
+	fmt.Fprintf(w, "\n=== stats at %s, %d goroutines ===\n", time.Now().Format(time.RFC3339), runtime.NumGoroutine())
+
+	fmt.Fprintln(w, "\n--- metrics")
+	expvar.Do(func(kv expvar.KeyValue) {
+		// the runtime's own vars are on /debug/vars
+		if strings.HasPrefix(kv.Key, "brigade.") {
+			fmt.Fprintf(w, "%s: %s\n", kv.Key, kv.Value)
+		}
+	})
+
+	statsDumps.Lock()
+	dumps := append([]func(io.Writer){}, statsDumps.dumps...)
+	statsDumps.Unlock()
+	for _, dump := range dumps {
+		fmt.Fprintln(w)
+		dump(w)
+	}
+
+	// goroutines grouped by stack, with how many share each
+	fmt.Fprintln(w, "\n--- goroutines")
+	_ = pprof.Lookup("goroutine").WriteTo(w, 1)
+	fmt.Fprintln(w, "=== end of stats ===")
+}
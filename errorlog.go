@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"io"
+	"log/syslog"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// errorLogHook copies the errors that are logged to a writer of their own,
+// as JSON lines, so that they can be looked at without the noise of the
+// progress logs.
+type errorLogHook struct {
+	w   io.Writer
+	fmt logrus.JSONFormatter
+}
+
+func (h *errorLogHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h *errorLogHook) Fire(entry *logrus.Entry) error {
+	line, err := formatEntry(&h.fmt, entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.w.Write(line)
+	return err
+}
+
+// formatEntry formats a copy of an entry: the formatter adds the time, level
+// and message to the fields of the entry, which would then clash when the
+// entry is logged to stderr.
+func formatEntry(f logrus.Formatter, entry *logrus.Entry) ([]byte, error) {
+	e := *entry
+	e.Data = make(logrus.Fields, len(entry.Data)+3)
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			// errors have no exported fields, they'd be written as {}
+			v = err.Error()
+		}
+		e.Data[k] = v
+	}
+	return f.Format(&e)
+}
+
+// syslogHook sends the errors and warnings that are logged to syslog, with
+// the priority of their level. On systemd hosts, the local syslog socket is
+// read by journald.
+type syslogHook struct {
+	w   *syslog.Writer
+	fmt logrus.JSONFormatter
+}
+
+// newSyslogHook connects to the syslog daemon at addr, either "local" or a
+// network address like udp://host:514.
+func newSyslogHook(addr, tag string) (*syslogHook, error) {
+	network, raddr := "", ""
+	if addr != "local" {
+		u, err := url.Parse(addr)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q, want local or network://host:port", addr)
+		}
+		network, raddr = u.Scheme, u.Host
+	}
+	w, err := syslog.Dial(network, raddr, syslog.LOG_WARNING|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogHook{w: w}, nil
+}
+
+func (h *syslogHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (h *syslogHook) Fire(entry *logrus.Entry) error {
+	line, err := formatEntry(&h.fmt, entry)
+	if err != nil {
+		return err
+	}
+	msg := string(bytes.TrimSpace(line))
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return h.w.Crit(msg)
+	case logrus.ErrorLevel:
+		return h.w.Err(msg)
+	default:
+		return h.w.Warning(msg)
+	}
+}
+
+// rotatingFile is a file that is rotated once it grows past maxSize bytes:
+// it's renamed with a .1 suffix, the previous .1 becomes .2 and so on, and
+// only maxFiles of those are kept. A maxSize of 0 never rotates the file.
+type rotatingFile struct {
+	mu       sync.Mutex
+	name     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// openRotatingFile opens filename for appending, creating it if needed.
+func openRotatingFile(filename string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	r := &rotatingFile{name: filename, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	r.file, r.size = file, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating %s: %v", r.name, err)
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts
+// a new file.
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if r.maxFiles < 1 {
+		if err := os.Remove(r.name); err != nil {
+			return err
+		}
+		return r.open()
+	}
+	for i := r.maxFiles - 1; i > 0; i-- {
+		err := os.Rename(r.rotatedName(i), r.rotatedName(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.name, r.rotatedName(1)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) rotatedName(i int) string {
+	return fmt.Sprintf("%s.%d", r.name, i)
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
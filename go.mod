@@ -0,0 +1,26 @@
+module github.com/Shopify/brigade
+
+go 1.20
+
+require (
+	github.com/Sirupsen/logrus v0.0.0-00010101000000-000000000000
+	github.com/aybabtme/humanize v0.0.0-00010101000000-000000000000
+	github.com/codegangsta/cli v0.0.0-00010101000000-000000000000
+	github.com/dustin/randbo v0.0.0-00010101000000-000000000000
+	github.com/kr/pretty v0.0.0-00010101000000-000000000000
+	github.com/kr/text v0.0.0-00010101000000-000000000000
+	github.com/pushrax/goamz v0.0.0-00010101000000-000000000000
+)
+
+// The dependencies are the copies vendored by godep, some of which carry
+// changes of their own, see Godeps/Godeps.json for the revisions they're
+// based on.
+replace (
+	github.com/Sirupsen/logrus => ./Godeps/_workspace/src/github.com/Sirupsen/logrus
+	github.com/aybabtme/humanize => ./Godeps/_workspace/src/github.com/aybabtme/humanize
+	github.com/codegangsta/cli => ./Godeps/_workspace/src/github.com/codegangsta/cli
+	github.com/dustin/randbo => ./Godeps/_workspace/src/github.com/dustin/randbo
+	github.com/kr/pretty => ./Godeps/_workspace/src/github.com/kr/pretty
+	github.com/kr/text => ./Godeps/_workspace/src/github.com/kr/text
+	github.com/pushrax/goamz => ./Godeps/_workspace/src/github.com/pushrax/goamz
+)
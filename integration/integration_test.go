@@ -0,0 +1,112 @@
+//go:build integration
+// +build integration
+
+// Package integration runs list, diff and sync end to end against an S3
+// compatible server. Run it with:
+//
+//	BRIGADE_INTEGRATION_DOCKER=minio/minio go test -tags integration ./integration/
+package integration_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/Shopify/brigade/cmd/diff"
+	"github.com/Shopify/brigade/cmd/list"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Shopify/brigade/testutil"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestListDiffSync(t *testing.T) {
+	srv := testutil.Start(t)
+	defer srv.Close()
+
+	src := srv.Bucket("brigade-src")
+	dst := srv.Bucket("brigade-dst")
+	prefixes := []string{"a/", "b/deep/", "c/", ""}
+
+	// a first sync copies everything
+	srv.Seed(src, 100, prefixes...)
+	var firstList bytes.Buffer
+	if err := list.List(srv.S3, src.Name, "", &firstList); err != nil {
+		t.Fatalf("can't list source: %v", err)
+	}
+	firstKeys := decodeKeys(t, bytes.NewReader(firstList.Bytes()))
+	if len(firstKeys) != 100 {
+		t.Fatalf("want 100 keys listed, got %d", len(firstKeys))
+	}
+	syncKeys(t, src, dst, &firstList, len(firstKeys))
+
+	// a second sync only copies what changed since the first listing
+	more := srv.Seed(src, 120, prefixes...)
+	var secondList bytes.Buffer
+	if err := list.List(srv.S3, src.Name, "", &secondList); err != nil {
+		t.Fatalf("can't list source: %v", err)
+	}
+	var changes bytes.Buffer
+	if err := diff.Diff(bytes.NewReader(firstList.Bytes()), &secondList, &changes); err != nil {
+		t.Fatalf("can't diff listings: %v", err)
+	}
+	changed := decodeKeys(t, bytes.NewReader(changes.Bytes()))
+	if len(changed) == 0 {
+		t.Fatalf("want changed keys, got none")
+	}
+	syncKeys(t, src, dst, &changes, len(changed))
+
+	// the destination ends up with the same keys and contents
+	got := srv.Keys(dst)
+	if len(got) != len(more) {
+		t.Fatalf("want %d keys in destination, got %d", len(more), len(got))
+	}
+	want := make(map[string]string, len(more))
+	for _, key := range more {
+		want[key.Key] = key.ETag
+	}
+	for _, key := range got {
+		if etag, ok := want[key.Key]; !ok {
+			t.Errorf("unexpected key %q in destination", key.Key)
+		} else if etag != key.ETag {
+			t.Errorf("key %q: want etag %s, got %s", key.Key, etag, key.ETag)
+		}
+	}
+}
+
+func syncKeys(t *testing.T, src, dst *s3.Bucket, input io.Reader, want int) {
+	task, err := sync.NewSyncTask(src, dst)
+	if err != nil {
+		t.Fatalf("can't create sync task: %v", err)
+	}
+	task.SyncPara = 10
+	task.RetryBase = 10 * time.Millisecond
+	task.MaxRetry = 3
+
+	var synced, failed bytes.Buffer
+	if err := task.Start(input, &synced, &failed); err != nil {
+		t.Fatalf("can't sync: %v", err)
+	}
+	if failed.Len() != 0 {
+		t.Errorf("want no failures, got %s", failed.String())
+	}
+	if got := len(decodeKeys(t, &synced)); got != want {
+		t.Errorf("want %d keys synced, got %d", want, got)
+	}
+}
+
+func decodeKeys(t *testing.T, r io.Reader) []s3.Key {
+	var keys []s3.Key
+	dec := json.NewDecoder(r)
+	for {
+		var key s3.Key
+		switch err := dec.Decode(&key); err {
+		case nil:
+			keys = append(keys, key)
+		case io.EOF:
+			return keys
+		default:
+			t.Fatalf("can't decode key: %v", err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/state"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeTask is a running sync as seen by the liveness probe: the last count
+// of keys it had read or done, and when that count last moved.
+type probeTask struct {
+	progress func() (count int64, idle bool)
+	count    int64
+	moved    time.Time
+}
+
+var probes = struct {
+	sync.Mutex
+	ready bool
+	tasks map[string]*probeTask
+}{tasks: make(map[string]*probeTask)}
+
+// onProbe registers a running sync, by name, with the liveness probe.
+// progress returns a count that grows as long as the sync makes progress,
+// and whether the sync is idle on purpose, such as when it's paused.
+func onProbe(name string, progress func() (count int64, idle bool)) {
+	probes.Lock()
+	defer probes.Unlock()
+	count, _ := progress()
+	probes.tasks[name] = &probeTask{progress: progress, count: count, moved: time.Now()}
+}
+
+// setReady marks the syncs as started, for the readiness probe.
+func setReady() {
+	probes.Lock()
+	defer probes.Unlock()
+	probes.ready = true
+}
+
+// stuckTasks returns the names of the syncs that haven't moved for longer
+// than timeout while not idle.
+func stuckTasks(now time.Time, timeout time.Duration) []string {
+	probes.Lock()
+	defer probes.Unlock()
+	var stuck []string
+	for name, t := range probes.tasks {
+		count, idle := t.progress()
+		if idle || count != t.count {
+			t.count = count
+			t.moved = now
+			continue
+		}
+		if now.Sub(t.moved) > timeout {
+			stuck = append(stuck, name)
+		}
+	}
+	sort.Strings(stuck)
+	return stuck
+}
+
+// serveProbes serves the liveness and readiness probes of a sync run as a
+// Kubernetes Job on addr, apart from the monitoring handler, which only
+// listens on localhost. /healthz fails once a sync hasn't moved for the
+// liveness timeout, so that the pod is restarted, and /readyz succeeds once
+// the syncs started.
+func serveProbes(addr string, timeout time.Duration) (stop func(), err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening for probes on %q: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		stuck := stuckTasks(time.Now(), timeout)
+		w.Header().Set("Content-Type", "application/json")
+		if len(stuck) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"stuck": stuck, "timeout": timeout.String()})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		probes.Lock()
+		ready := probes.ready
+		probes.Unlock()
+		if !ready {
+			http.Error(w, "not started", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logrus.WithField("error", err).Error("probe handler failed")
+		}
+	}()
+	logrus.WithFields(logrus.Fields{
+		"addr":      ln.Addr().String(),
+		"liveness":  "/healthz",
+		"readiness": "/readyz",
+	}).Info("probe handler listening")
+	return func() { logIfErr(srv.Close()) }, nil
+}
+
+// stateEncryption is how the state files are encrypted at rest, with the
+// passphrase in passphraseFile or a data key generated by KMS under kmsKey
+// with the credentials of the state bucket. It's nil when they're not.
+func stateEncryption(cfg *Config, passphraseFile, kmsKey string) (*state.Encryption, error) {
+	switch {
+	case passphraseFile != "" && kmsKey != "":
+		return nil, errors.New("encrypt the state with either a passphrase or a KMS key, not both")
+	case passphraseFile != "":
+		data, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading passphrase: %v", err)
+		}
+		passphrase := strings.TrimRight(string(data), "\r\n")
+		if passphrase == "" {
+			return nil, fmt.Errorf("passphrase file %q is empty", passphraseFile)
+		}
+		return &state.Encryption{Passphrase: passphrase}, nil
+	case kmsKey != "":
+		if cfg == nil {
+			return nil, errors.New("need a config with the credentials of the state bucket to call KMS")
+		}
+		return &state.Encryption{KMS: cfg.State.KMS(kmsKey)}, nil
+	}
+	return nil, nil
+}
+
+// openState opens a state file, encrypted if enc is set.
+func openState(filename string, enc *state.Encryption) (*state.Store, error) {
+	if enc == nil {
+		return state.Open(filename)
+	}
+	return state.OpenEncrypted(filename, *enc)
+}
+
+// checkpointKey is the key of the checkpoint of a state file under prefix.
+func checkpointKey(prefix, stateFilename string) string {
+	return path.Join(prefix, filepath.Base(stateFilename))
+}
+
+// restoreCheckpoint downloads the checkpoint of a state file, unless the
+// state file exists already, which is then at least as recent. It returns
+// false when there's no checkpoint to resume from.
+func restoreCheckpoint(bkt *s3.Bucket, key, filename string) (bool, error) {
+	if _, err := os.Stat(filename); err == nil {
+		return false, nil
+	}
+	rd, err := bkt.GetReader(key)
+	if s3.IsS3Error(err, s3.ErrNoSuchKey) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getting checkpoint s3://%s/%s: %v", bkt.Name, key, err)
+	}
+	defer func() { _ = rd.Close() }()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".restore")
+	if err != nil {
+		return false, fmt.Errorf("creating state file: %v", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := io.Copy(tmp, rd); err != nil {
+		_ = tmp.Close()
+		return false, fmt.Errorf("downloading checkpoint s3://%s/%s: %v", bkt.Name, key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("closing state file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return false, fmt.Errorf("renaming state file: %v", err)
+	}
+	return true, nil
+}
+
+// uploadCheckpoint writes the records of the store to a temporary file next
+// to the state file, and uploads it to key, replacing the last checkpoint.
+func uploadCheckpoint(store *state.Store, bkt *s3.Bucket, key, filename string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".checkpoint")
+	if err != nil {
+		return fmt.Errorf("creating checkpoint file: %v", err)
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := store.Checkpoint(tmp); err != nil {
+		return fmt.Errorf("writing checkpoint file: %v", err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("sizing checkpoint file: %v", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewinding checkpoint file: %v", err)
+	}
+	if err := bkt.PutReader(key, tmp, size, "application/json", s3.Private, s3.Options{}); err != nil {
+		return fmt.Errorf("uploading checkpoint s3://%s/%s: %v", bkt.Name, key, err)
+	}
+	return nil
+}
+
+// checkpointState uploads a checkpoint of the store every interval, and a
+// last one when stopped or when the process is told to terminate, so that
+// a restarted sync resumes from it.
+func checkpointState(store *state.Store, bkt *s3.Bucket, key, filename string, every time.Duration) (stop func()) {
+	var (
+		once sync.Once
+		mu   sync.Mutex
+	)
+	// an upload still running must not replace the last checkpoint
+	upload := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := uploadCheckpoint(store, bkt, key, filename); err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"checkpoint": fmt.Sprintf("s3://%s/%s", bkt.Name, key),
+			"key_count":  store.Len(),
+		}).Debug("uploaded checkpoint of state file")
+		return nil
+	}
+	done := make(chan struct{})
+	final := func() error {
+		var err error
+		once.Do(func() {
+			close(done)
+			err = upload()
+		})
+		return err
+	}
+	onShutdown(final)
+
+	go func() {
+		tick := time.NewTicker(every)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				logIfErr(upload())
+			}
+		}
+	}()
+	return func() { logIfErr(final()) }
+}
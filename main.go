@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/Shopify/brigade/cmd/events"
 	"github.com/Sirupsen/logrus"
 	"net/http"
 	"os"
@@ -17,14 +18,37 @@ var (
 	catchSignals  = []os.Signal{os.Interrupt, os.Kill}
 	signalTimeout = time.Second * 5
 	addr          = "127.0.0.1:6060"
+
+	// progressStream broadcasts the events and progress of the syncs of the
+	// process to the clients of /events on the monitoring handler, such as
+	// the watch command.
+	progressStream = events.NewStream()
+
+	// exitStatus is set by commands that fail, and is used once everything
+	// they deferred, like closing their outputs, is done.
+	exitStatus int
 )
 
 func main() {
+	defer func() {
+		if exitStatus != 0 {
+			os.Exit(exitStatus)
+		}
+	}()
 
 	// use all cores
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	logrus.SetOutput(os.Stderr)
 
+	// watching a run is done next to it, without its lock or the monitoring
+	// handler it watches
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		if err := newApp().Run(os.Args); err != nil {
+			logrus.WithField("error", err).Error("couldn't run app")
+		}
+		return
+	}
+
 	file, err := os.OpenFile("brigade.lock", os.O_CREATE|os.O_TRUNC, 0640)
 	if err != nil {
 		logrus.Fatal(err)
@@ -52,21 +76,49 @@ func main() {
 			case <-time.After(signalTimeout):
 				logrus.Info("no signal received: continuing")
 			case s := <-c:
+				logrus.WithField("signal", s).Warn("completing output files before terminating")
+				runShutdownHooks()
 				logrus.WithField("signal", s).Fatal("terminating")
 			}
 		}
 	}()
 
+	// Kubernetes terminates a pod with SIGTERM, and kills it once its grace
+	// period is over: complete the outputs and checkpoints before then
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGTERM)
+		s := <-c
+		logrus.WithField("signal", s).Warn("completing output files before terminating")
+		runShutdownHooks()
+		logrus.WithField("signal", s).Fatal("terminating")
+	}()
+
+	// stats of a run that looks stalled, without stopping it
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGQUIT)
+		for range c {
+			dumpStats(os.Stderr)
+		}
+	}()
+
 	// open a pprof http handler
+	http.Handle("/events", progressStream)
+	http.HandleFunc("/version", serveBuildInfo)
+	handleDashboard(http.DefaultServeMux)
 
 	go func() {
 		// don't monitor short tasks
 		time.Sleep(time.Second * 2)
 
 		logrus.WithFields(logrus.Fields{
-			"addr":    addr,
-			"metrics": "/debug/vars",
-			"pprof":   "/debug/pprof",
+			"addr":      addr,
+			"metrics":   "/debug/vars",
+			"pprof":     "/debug/pprof",
+			"events":    "/events",
+			"dashboard": "/dashboard",
+			"version":   "/version",
 		}).Info("monitoring handler listening")
 		logrus.Fatal(http.ListenAndServe(addr, nil))
 	}()
@@ -74,6 +126,8 @@ func main() {
 	if err := newApp().Run(os.Args); err != nil {
 		logrus.WithField("error", err).Error("couldn't run app")
 	}
+	// the clients following the run see its last events
+	progressStream.Close(time.Second)
 }
 
 func logIfErr(err error) {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/monitor"
+	"github.com/Shopify/brigade/cmd/sync"
+	"github.com/Sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// publishCloudWatch publishes the sync metrics of the process to a CloudWatch
+// namespace every interval. Counters are published as the delta since the
+// previous publication, so that they can be summed over any period. The
+// returned func publishes one last time, then stops publishing.
+func publishCloudWatch(cfg *Config, namespace string, every time.Duration, dims map[string]string) func() {
+	monCfg := cfg.MonitoringConfig()
+	auth, region := monCfg.AWS()
+	cw := monitor.NewCloudWatch(auth, region, namespace)
+	cw.Dimensions = dims
+	if runID != "" {
+		cw.Dimensions["RunID"] = runID
+	}
+
+	counters := []struct {
+		name, unit, expvar string
+		last               int64
+	}{
+		{name: "syncedKeys", unit: monitor.Count, expvar: "brigade.sync.syncOk"},
+		{name: "failedKeys", unit: monitor.Count, expvar: "brigade.sync.syncAbandoned"},
+		{name: "syncedBytes", unit: monitor.Bytes, expvar: "brigade.sync.syncedBytes"},
+	}
+	collect := func() []monitor.Datum {
+		var data []monitor.Datum
+		for i, c := range counters {
+			v, ok := expvar.Get(c.expvar).(*expvar.Int)
+			if !ok {
+				continue
+			}
+			cur := v.Value()
+			data = append(data, monitor.Datum{Name: c.name, Value: float64(cur - c.last), Unit: c.unit})
+			counters[i].last = cur
+		}
+		// latencies of the last complete minute, rather than since the
+		// start, so that throttling shows up when it happens
+		if latency := sync.Latency.LastInterval().Summarize(); latency.Count != 0 {
+			data = append(data,
+				monitor.Datum{Name: "latencyP50", Value: millis(latency.P50), Unit: monitor.Milliseconds},
+				monitor.Datum{Name: "latencyP95", Value: millis(latency.P95), Unit: monitor.Milliseconds},
+				monitor.Datum{Name: "latencyP99", Value: millis(latency.P99), Unit: monitor.Milliseconds},
+				monitor.Datum{Name: "latencyP999", Value: millis(latency.P999), Unit: monitor.Milliseconds},
+				monitor.Datum{Name: "latencyMax", Value: millis(latency.Max), Unit: monitor.Milliseconds},
+			)
+		}
+		if latency := sync.RetryLatency.LastInterval().Summarize(); latency.Count != 0 {
+			data = append(data,
+				monitor.Datum{Name: "retryLatencyP50", Value: millis(latency.P50), Unit: monitor.Milliseconds},
+				monitor.Datum{Name: "retryLatencyP95", Value: millis(latency.P95), Unit: monitor.Milliseconds},
+			)
+		}
+		return data
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"namespace": namespace,
+		"interval":  every,
+	}).Info("publishing metrics to cloudwatch")
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		monitor.Publish(cw, every, collect, stop)
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+func millis(d time.Duration) float64 { return d.Seconds() * 1000 }
+
+// writeLatencyReport writes the overall histogram of the sync latencies of
+// the process to filename, as JSON.
+func writeLatencyReport(filename string) error {
+	latency := sync.Latency.Overall()
+	report := struct {
+		Summary map[string]float64 `json:"summary"`
+		Buckets []monitor.Bucket   `json:"buckets"`
+	}{
+		Summary: latency.Summarize().Millis(),
+		Buckets: latency.Buckets(),
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(report); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("encoding latency report: %v", err)
+	}
+	return file.Close()
+}
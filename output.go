@@ -0,0 +1,235 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/Shopify/brigade/cmd/s3file"
+	"github.com/Sirupsen/logrus"
+	"github.com/pushrax/goamz/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// atomicFile is a gzip'd output file that is written under a temporary name
+// and renamed to its final name only once complete. The content is flushed
+// and fsync'd periodically, so that a crash leaves a temporary file with all
+// but the last few seconds of records, and never a truncated file under the
+// final name.
+type atomicFile struct {
+	mu     sync.Mutex
+	name   string
+	file   *os.File
+	gzip   *gzip.Writer
+	done   chan struct{}
+	closed bool
+}
+
+// umask of the process, read once at start, before any file is created,
+// since reading it means setting it.
+var umask = func() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
+}()
+
+// createAtomicFile creates a temporary file next to filename, which will
+// be fsync'd every `syncEvery`, or never if syncEvery is 0. The file gets
+// the mode os.Create gives, rather than the 0600 of temporary files, for
+// the outputs to be read by other users as before.
+func createAtomicFile(filename string, syncEvery time.Duration) (*atomicFile, error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(0666 &^ umask); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	a := &atomicFile{
+		name: filename,
+		file: file,
+		gzip: gzip.NewWriter(file),
+		done: make(chan struct{}),
+	}
+	if syncEvery > 0 {
+		go a.syncLoop(syncEvery)
+	}
+	onShutdown(a.Close)
+	return a, nil
+}
+
+func (a *atomicFile) syncLoop(every time.Duration) {
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-tick.C:
+		}
+		a.mu.Lock()
+		if !a.closed {
+			if err := a.sync(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error":    err,
+					"filename": a.file.Name(),
+				}).Error("periodic fsync of output file")
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+func (a *atomicFile) sync() error {
+	if err := a.gzip.Flush(); err != nil {
+		return fmt.Errorf("flushing gzip writer: %v", err)
+	}
+	return a.file.Sync()
+}
+
+// Write to the temporary file.
+func (a *atomicFile) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return 0, fmt.Errorf("output file %q is already closed", a.name)
+	}
+	return a.gzip.Write(p)
+}
+
+// Close the gzip stream, fsync the temporary file and rename it to its
+// final name. It is safe to call Close many times, only the first call
+// has an effect.
+func (a *atomicFile) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	close(a.done)
+
+	if err := a.gzip.Close(); err != nil {
+		_ = a.file.Close()
+		return fmt.Errorf("closing gzip writer of %q: %v", a.file.Name(), err)
+	}
+	if err := a.file.Sync(); err != nil {
+		_ = a.file.Close()
+		return fmt.Errorf("syncing %q: %v", a.file.Name(), err)
+	}
+	if err := a.file.Close(); err != nil {
+		return fmt.Errorf("closing %q: %v", a.file.Name(), err)
+	}
+	if err := os.Rename(a.file.Name(), a.name); err != nil {
+		return fmt.Errorf("renaming %q to %q: %v", a.file.Name(), a.name, err)
+	}
+	if err := syncDir(filepath.Dir(a.name)); err != nil {
+		return fmt.Errorf("syncing the rename of %q: %v", a.name, err)
+	}
+	logrus.WithField("filename", a.name).Info("output file completed")
+	return nil
+}
+
+// syncDir fsyncs a directory, for the renames into it to survive a crash.
+func syncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// s3Output is a gzip'd output uploaded to S3 as it's written. The object
+// only appears once the output is closed, a crash leaves the parts uploaded
+// so far in an unfinished multipart upload, but for the last few MB.
+type s3Output struct {
+	mu     sync.Mutex
+	name   string
+	upload *s3file.Writer
+	gzip   *gzip.Writer
+	closed bool
+}
+
+// createS3Output starts uploading to a key of a bucket.
+func createS3Output(bkt *s3.Bucket, key string) (*s3Output, error) {
+	upload, err := s3file.Create(bkt, key, "application/x-gzip")
+	if err != nil {
+		return nil, err
+	}
+	o := &s3Output{
+		name:   "s3://" + bkt.Name + "/" + key,
+		upload: upload,
+		gzip:   gzip.NewWriter(upload),
+	}
+	onShutdown(o.Close)
+	return o, nil
+}
+
+// Write to the upload.
+func (o *s3Output) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return 0, fmt.Errorf("output %q is already closed", o.name)
+	}
+	return o.gzip.Write(p)
+}
+
+// Close the gzip stream and complete the upload, or abort it if the stream
+// can't be closed. It is safe to call Close many times, only the first call
+// has an effect.
+func (o *s3Output) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	if err := o.gzip.Close(); err != nil {
+		// abort rather than publish a truncated output
+		logIfErr(o.upload.Abort())
+		return fmt.Errorf("closing gzip writer of %q: %v", o.name, err)
+	}
+	if err := o.upload.Close(); err != nil {
+		return err
+	}
+	logrus.WithField("filename", o.name).Info("output file completed")
+	return nil
+}
+
+var shutdown = struct {
+	sync.Mutex
+	hooks []func() error
+}{}
+
+// onShutdown registers a hook to run when the process is told to terminate,
+// before exiting.
+func onShutdown(hook func() error) {
+	shutdown.Lock()
+	defer shutdown.Unlock()
+	shutdown.hooks = append(shutdown.hooks, hook)
+}
+
+// runShutdownHooks runs all the registered hooks, logging their errors.
+func runShutdownHooks() {
+	shutdown.Lock()
+	defer shutdown.Unlock()
+	for _, hook := range shutdown.hooks {
+		logIfErr(hook())
+	}
+	shutdown.hooks = nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"github.com/Sirupsen/logrus"
+	"time"
+)
+
+// runID tells the runs of brigade apart in the logs, outputs, events and
+// metrics they share with other runs. It's the -run-id of the command, or
+// one generated as it starts.
+var runID string
+
+// runIDVar publishes the ID of the run with the other metrics.
+var runIDVar = expvar.NewString("brigade.run_id")
+
+// newRunID generates an ID that sorts by the time the run started, like
+// 20161016T191838-9f3c2a71e04b5d68.
+func newRunID(now time.Time) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// the time alone still tells most runs apart
+		return now.UTC().Format("20060102T150405.000000000")
+	}
+	return now.UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b)
+}
+
+// setRunID sets the ID of the run, attaching it to every line logged from
+// then on.
+func setRunID(id string) {
+	runID = id
+	runIDVar.Set(id)
+	logrus.AddHook(runIDHook{id: id})
+}
+
+// runIDHook adds the ID of the run to the fields of the entries logged. It's
+// added before the other hooks, which then see it too.
+type runIDHook struct {
+	id string
+}
+
+func (h runIDHook) Levels() []logrus.Level {
+	return []logrus.Level{
+		logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel,
+		logrus.WarnLevel, logrus.InfoLevel, logrus.DebugLevel,
+	}
+}
+
+// Fire replaces the fields of the entry with a copy, rather than adding to
+// them, since an entry can be kept and logged again, from other goroutines.
+func (h runIDHook) Fire(entry *logrus.Entry) error {
+	data := make(logrus.Fields, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	data["run_id"] = h.id
+	entry.Data = data
+	return nil
+}
@@ -0,0 +1,112 @@
+package s3mock
+
+import (
+	"encoding/xml"
+	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Behavior controls how the mock S3 answers requests, on top of what its
+// in-memory backend does. A nil func leaves requests unchanged.
+type Behavior struct {
+	// Latency is how long to wait before handling a request.
+	Latency func(r *http.Request) time.Duration
+	// Fail returns the error to answer a request with, or nil to let the
+	// in-memory backend handle the request.
+	Fail func(r *http.Request) *s3.Error
+}
+
+// FixedLatency delays every request by d.
+func FixedLatency(d time.Duration) func(r *http.Request) time.Duration {
+	return func(*http.Request) time.Duration { return d }
+}
+
+// FailMethod fails all the requests of an HTTP method with err.
+func FailMethod(method string, err s3.Error) func(r *http.Request) *s3.Error {
+	return func(r *http.Request) *s3.Error {
+		if r.Method == method {
+			return &err
+		}
+		return nil
+	}
+}
+
+// behaviorProxy sits in front of the in-memory backend and applies the
+// behavior to the requests it forwards.
+type behaviorProxy struct {
+	proxy *httputil.ReverseProxy
+
+	mu       sync.Mutex
+	behavior Behavior
+	// requests answered, which number their request IDs
+	requests int64
+}
+
+func newBehaviorProxy(backend string) (*behaviorProxy, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, err
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.ErrorHandler = dropConn
+	return &behaviorProxy{proxy: proxy}, nil
+}
+
+// dropConn closes the client connection when the backend dropped the
+// connection of the proxy, so that clients see a network error as if they
+// talked to the backend directly.
+func dropConn(w http.ResponseWriter, r *http.Request, err error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	_ = conn.Close()
+}
+
+func (b *behaviorProxy) set(behavior Behavior) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.behavior = behavior
+}
+
+func (b *behaviorProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	behavior := b.behavior
+	b.mu.Unlock()
+
+	// like S3, every response has a request ID
+	id := atomic.AddInt64(&b.requests, 1)
+	w.Header().Set("x-amz-request-id", strconv.FormatInt(id, 16))
+	if behavior.Latency != nil {
+		time.Sleep(behavior.Latency(r))
+	}
+	if behavior.Fail != nil {
+		if err := behavior.Fail(r); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	b.proxy.ServeHTTP(w, r)
+}
+
+func writeError(w http.ResponseWriter, err *s3.Error) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(err.StatusCode)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: err.Code, Message: err.Message})
+}
@@ -7,31 +7,43 @@ import (
 	"github.com/pushrax/goamz/s3"
 	"github.com/pushrax/goamz/s3/s3test"
 	"io"
+	"net/http/httptest"
 	"testing"
 )
 
-// MockS3 is a helper to setup a fake S3 that has prepopulated buckets.
+// MockS3 is a helper to setup a fake S3 that has prepopulated buckets. The
+// buckets are held in memory, and requests go through a proxy that can add
+// latency and errors, see SetBehavior.
 type MockS3 struct {
-	t      *testing.T
+	t      testing.TB
 	fakes3 *s3.S3
 	srv    *s3test.Server
+	proxy  *behaviorProxy
+	front  *httptest.Server
 }
 
-// NewMock creates an S3 mock that fails tests if it errors.
-func NewMock(t *testing.T) *MockS3 {
+// NewMock creates an S3 mock that fails tests, or benchmarks, if it errors.
+func NewMock(t testing.TB) *MockS3 {
 	srv, err := s3test.NewServer(&s3test.Config{})
 	if err != nil {
 		t.Fatalf("s3mock.NewMock: couldn't create test s3 server, %v", err)
 	}
+	proxy, err := newBehaviorProxy(srv.URL())
+	if err != nil {
+		t.Fatalf("s3mock.NewMock: couldn't create proxy to test s3 server, %v", err)
+	}
+	front := httptest.NewServer(proxy)
 	region := aws.Region{
 		Name:                 "faux-region-1",
-		S3Endpoint:           srv.URL(),
+		S3Endpoint:           front.URL,
 		S3LocationConstraint: true,
 	}
 	return &MockS3{
 		t:      t,
 		fakes3: s3.New(aws.Auth{}, region),
 		srv:    srv,
+		proxy:  proxy,
+		front:  front,
 	}
 }
 
@@ -39,7 +51,13 @@ func NewMock(t *testing.T) *MockS3 {
 func (m *MockS3) S3() *s3.S3 { return m.fakes3 }
 
 // Close the mock resources.
-func (m *MockS3) Close() { m.srv.Quit() }
+func (m *MockS3) Close() {
+	m.front.Close()
+	m.srv.Quit()
+}
+
+// SetBehavior changes how the mock answers the requests that follow.
+func (m *MockS3) SetBehavior(b Behavior) { m.proxy.set(b) }
 
 // ListBuckets gives a snapshot of the buckets on S3.
 func (m *MockS3) ListBuckets() map[string]s3test.Bucket {
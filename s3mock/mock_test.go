@@ -3,8 +3,12 @@ package s3mock_test
 import (
 	"bytes"
 	"github.com/Shopify/brigade/s3mock"
+	"github.com/pushrax/goamz/aws"
 	"github.com/pushrax/goamz/s3"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestPerfMockBucketKnownKey(t *testing.T) {
@@ -39,3 +43,92 @@ func TestPerfMockBucketUnknownKey(t *testing.T) {
 		t.Errorf("should have returned nil data, got %q", data)
 	}
 }
+
+func TestMockBehavior(t *testing.T) {
+	mock := s3mock.NewMock(t).Seed(s3mock.NewPerfBucket(t))
+	defer mock.Close()
+
+	bkt := mock.S3().Bucket("shopify-perf")
+
+	mock.SetBehavior(s3mock.Behavior{
+		Latency: s3mock.FixedLatency(50 * time.Millisecond),
+		Fail: s3mock.FailMethod("PUT", s3.Error{
+			StatusCode: http.StatusServiceUnavailable,
+			Code:       s3.ErrSlowDown,
+			Message:    "slow down",
+		}),
+	})
+
+	start := time.Now()
+	if _, err := bkt.Get("ruby-2.1.1-webscale1.tar.gz"); err != nil {
+		t.Errorf("should have found file: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("want a latency of at least 50ms, got %v", elapsed)
+	}
+
+	err := bkt.Put("new-key", []byte("data"), "", s3.Private, s3.Options{})
+	if !s3.IsS3Error(err, s3.ErrSlowDown) {
+		t.Errorf("expected 'slow down' error, got %v", err)
+	}
+
+	mock.SetBehavior(s3mock.Behavior{})
+	if err := bkt.Put("new-key", []byte("data"), "", s3.Private, s3.Options{}); err != nil {
+		t.Errorf("should have put key once behavior is reset: %v", err)
+	}
+}
+
+func TestMockV4Signature(t *testing.T) {
+	mock := s3mock.NewMock(t).Seed(s3mock.NewPerfBucket(t))
+	defer mock.Close()
+
+	mock.S3().Signature = aws.V4Signature
+
+	var paths []string
+	mock.SetBehavior(s3mock.Behavior{
+		Fail: func(r *http.Request) *s3.Error {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=") ||
+				!strings.Contains(auth, "/faux-region-1/s3/aws4_request") {
+				t.Errorf("%s %s: not signed with v4: %q", r.Method, r.URL, auth)
+			}
+			if r.Header.Get("X-Amz-Date") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+				t.Errorf("%s %s: missing v4 headers", r.Method, r.URL)
+			}
+			paths = append(paths, r.URL.EscapedPath())
+			return nil
+		},
+	})
+
+	bkt := mock.S3().Bucket("shopify-perf")
+	key := "dir/a b+c.txt"
+	if err := bkt.Put(key, []byte("data"), "", s3.Private, s3.Options{}); err != nil {
+		t.Fatalf("can't put key: %v", err)
+	}
+	if _, err := bkt.PutCopy("copy/"+key, s3.Private, s3.CopyOptions{}, "shopify-perf/dir/a%20b%2Bc.txt"); err != nil {
+		t.Fatalf("can't copy key: %v", err)
+	}
+	list, err := bkt.List("dir/", "", "", 10)
+	if err != nil {
+		t.Fatalf("can't list keys: %v", err)
+	}
+	if len(list.Contents) != 1 || list.Contents[0].Key != key {
+		t.Errorf("want to list %q, got %v", key, list.Contents)
+	}
+	if want := "/shopify-perf/dir/a%20b%2Bc.txt"; len(paths) == 0 || paths[0] != want {
+		t.Errorf("want the path sent as %q, got %v", want, paths)
+	}
+}
+
+func TestMockBucketLocation(t *testing.T) {
+	mock := s3mock.NewMock(t).Seed(s3mock.NewPerfBucket(t))
+	defer mock.Close()
+
+	location, err := mock.S3().Bucket("shopify-perf").Location()
+	if err != nil {
+		t.Fatalf("can't get location: %v", err)
+	}
+	if want := mock.S3().Region.Name; location != want {
+		t.Errorf("want the bucket in %q, got %q", want, location)
+	}
+}
@@ -0,0 +1,186 @@
+// Package testutil runs brigade against a real, S3 compatible server such
+// as MinIO or localstack, for integration tests.
+//
+// The tests using it are skipped unless one of those is set:
+//
+//	BRIGADE_INTEGRATION_S3=http://127.0.0.1:9000  use a server that's already running
+//	BRIGADE_INTEGRATION_DOCKER=minio/minio        start a MinIO container from this image
+//
+// The credentials are read from BRIGADE_INTEGRATION_ACCESS_KEY and
+// BRIGADE_INTEGRATION_SECRET_KEY, and default to those of the container.
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pushrax/goamz/aws"
+	"github.com/pushrax/goamz/s3"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The environment variables that enable the integration tests.
+const (
+	EnvEndpoint  = "BRIGADE_INTEGRATION_S3"
+	EnvDocker    = "BRIGADE_INTEGRATION_DOCKER"
+	EnvAccessKey = "BRIGADE_INTEGRATION_ACCESS_KEY"
+	EnvSecretKey = "BRIGADE_INTEGRATION_SECRET_KEY"
+)
+
+const (
+	defaultAccessKey = "brigade-access-key"
+	defaultSecretKey = "brigade-secret-key"
+	readyTimeout     = 30 * time.Second
+)
+
+// Server is an S3 compatible server.
+type Server struct {
+	// S3 talks to the server.
+	S3 *s3.S3
+
+	t    *testing.T
+	stop func()
+}
+
+// Start connects to the server given by the environment, starting it first
+// if it runs in a container. The test is skipped if the environment enables
+// no server.
+func Start(t *testing.T) *Server {
+	accessKey := envOr(EnvAccessKey, defaultAccessKey)
+	secretKey := envOr(EnvSecretKey, defaultSecretKey)
+
+	endpoint := os.Getenv(EnvEndpoint)
+	stop := func() {}
+	if endpoint == "" {
+		image := os.Getenv(EnvDocker)
+		if image == "" {
+			t.Skipf("integration tests need %s or %s to be set", EnvEndpoint, EnvDocker)
+		}
+		endpoint, stop = startContainer(t, image, accessKey, secretKey)
+	}
+
+	region := aws.Region{
+		Name:                 "us-east-1",
+		S3Endpoint:           endpoint,
+		S3LocationConstraint: false,
+		S3LowercaseBucket:    true,
+	}
+	auth := aws.Auth{AccessKey: accessKey, SecretKey: secretKey}
+	return &Server{S3: s3.New(auth, region), t: t, stop: stop}
+}
+
+// Close the server, removing its container if it was started by Start.
+func (s *Server) Close() { s.stop() }
+
+// Bucket creates a new, empty bucket whose name starts with prefix.
+func (s *Server) Bucket(prefix string) *s3.Bucket {
+	name := fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	bkt := s.S3.Bucket(name)
+	if err := bkt.PutBucket(s3.Private); err != nil {
+		s.t.Fatalf("testutil: couldn't create bucket %q: %v", name, err)
+	}
+	return bkt
+}
+
+// Seed puts n keys with random content in bkt, spread over the prefixes,
+// and returns the keys as listed by the server.
+func (s *Server) Seed(bkt *s3.Bucket, n int, prefixes ...string) []s3.Key {
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	rnd := rand.New(rand.NewSource(int64(n)))
+	for i := 0; i < n; i++ {
+		data := make([]byte, 1+rnd.Intn(4096))
+		_, _ = rnd.Read(data)
+		name := fmt.Sprintf("%skey-%05d", prefixes[i%len(prefixes)], i)
+		if err := bkt.Put(name, data, "application/octet-stream", s3.Private, s3.Options{}); err != nil {
+			s.t.Fatalf("testutil: couldn't put key %q: %v", name, err)
+		}
+	}
+	return s.Keys(bkt)
+}
+
+// Keys in bkt, as listed by the server.
+func (s *Server) Keys(bkt *s3.Bucket) []s3.Key {
+	var keys []s3.Key
+	marker := ""
+	for {
+		res, err := bkt.List("", "", marker, 1000)
+		if err != nil {
+			s.t.Fatalf("testutil: couldn't list bucket %q: %v", bkt.Name, err)
+		}
+		keys = append(keys, res.Contents...)
+		if !res.IsTruncated || len(res.Contents) == 0 {
+			return keys
+		}
+		marker = res.Contents[len(res.Contents)-1].Key
+	}
+}
+
+func startContainer(t *testing.T, image, accessKey, secretKey string) (string, func()) {
+	out, err := exec.Command("docker", "run", "-d",
+		"-p", "127.0.0.1::9000",
+		"-e", "MINIO_ROOT_USER="+accessKey,
+		"-e", "MINIO_ROOT_PASSWORD="+secretKey,
+		image, "server", "/data",
+	).CombinedOutput()
+	if err != nil {
+		t.Fatalf("testutil: couldn't start container from %q: %v: %s", image, err, out)
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() {
+		if out, err := exec.Command("docker", "rm", "-f", id).CombinedOutput(); err != nil {
+			t.Logf("testutil: couldn't remove container %q: %v: %s", id, err, out)
+		}
+	}
+
+	out, err = exec.Command("docker", "port", id, "9000").CombinedOutput()
+	if err != nil {
+		stop()
+		t.Fatalf("testutil: couldn't find port of container %q: %v: %s", id, err, out)
+	}
+	// docker port may list many bindings, one per line
+	addr := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	endpoint := "http://" + addr
+
+	deadline := time.Now().Add(readyTimeout)
+	for {
+		resp, err := http.Get(endpoint + "/minio/health/ready")
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return endpoint, stop
+			}
+		}
+		if time.Now().After(deadline) {
+			stop()
+			t.Fatalf("testutil: server at %s wasn't ready after %v", endpoint, readyTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// Listing encodes keys as a listing, as read by diff and sync.
+func Listing(t *testing.T, keys []s3.Key) *bytes.Buffer {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, key := range keys {
+		if err := enc.Encode(key); err != nil {
+			t.Fatalf("testutil: couldn't encode key %q: %v", key.Key, err)
+		}
+	}
+	return &buf
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}